@@ -2,75 +2,180 @@ package client
 
 import (
 	"errors"
+	"fmt"
+	"strings"
 	"time"
 )
 
+// ErrNotFound key 在节点上不存在
+var ErrNotFound = errors.New("key not found")
+
+// ErrValueTooLarge 值超过节点接受的最大值，节点在写入存储之前拒绝了它
+var ErrValueTooLarge = errors.New("value too large")
+
+// writeError 把写入失败的响应转换为错误：值过大时包装 ErrValueTooLarge，其他错误带上节点返回的信息
+func writeError(command string, res *BluebellResponse) error {
+	if res.Code == VALUE_TOO_LARGE {
+		// 节点的错误信息本身以 "value too large: " 开头，去掉它避免重复
+		msg := strings.TrimPrefix(string(res.Result), ErrValueTooLarge.Error()+": ")
+		return fmt.Errorf("%w: %s", ErrValueTooLarge, msg)
+	}
+	if len(res.Result) == 0 {
+		return fmt.Errorf("%s failed", command)
+	}
+	return fmt.Errorf("%s failed: %s", command, res.Result)
+}
+
 func (hc *HuaHuoLsmClient) Set(key string, value []byte) error {
-	ip, err := GetRing().Get(key)
+	return hc.SetWithFlags(key, value, 0)
+}
+
+// SetWithFlags 写入 key 的值和标志，之后不带标志的 Set 把标志重置为 0
+func (hc *HuaHuoLsmClient) SetWithFlags(key string, value []byte, flags uint32) error {
+	// 写入失败时节点也可能已经执行，同样使缓存失效
+	defer hc.invalidate(key)
+	if hc.written != nil {
+		return hc.setAndWait(key, value, flags)
+	}
+	c, err := hc.route(key)
 	if err != nil {
 		return err
 	}
-	err = HuaHuoLsmCli.Clients[ip].set(key, value)
+	err = c.set(key, value, flags, hc.nextTraceID(), hc.opID)
 	return err
 }
 
 func (hc *HuaHuoLsmClient) Get(key string) ([]byte, error) {
-	ip, err := GetRing().Get(key)
+	value, _, err := hc.GetWithFlags(key)
+	return value, err
+}
+
+// GetWithFlags 返回 key 的值和标志，没有设置过标志的 key 返回 0
+func (hc *HuaHuoLsmClient) GetWithFlags(key string) ([]byte, uint32, error) {
+	if hc.cache == nil {
+		return hc.getWithFlags(key)
+	}
+	if value, flags, ok := hc.cache.get(key); ok {
+		return value, flags, nil
+	}
+	generation := hc.cache.snapshot()
+	value, flags, err := hc.getWithFlags(key)
+	if err == nil {
+		hc.cache.add(key, value, flags, generation)
+	}
+	return value, flags, err
+}
+
+// getWithFlags 从节点读取 key 的值和标志，不经过本地缓存
+func (hc *HuaHuoLsmClient) getWithFlags(key string) ([]byte, uint32, error) {
+	if hc.written != nil {
+		return hc.getWritten(key)
+	}
+	c, err := hc.route(key)
+	if err != nil {
+		return nil, 0, err
+	}
+	return c.get(key, hc.nextTraceID())
+}
+
+// Delete 删除 key，key 不存在时同样返回成功
+func (hc *HuaHuoLsmClient) Delete(key string) error {
+	defer hc.invalidate(key)
+	c, err := hc.route(key)
+	if err != nil {
+		return err
+	}
+	return c.del(key, hc.nextTraceID())
+}
+
+// GetOr 返回 key 的值，key 不存在时返回 def；路由失败、连接错误和节点返回的其他错误照常返回
+func (hc *HuaHuoLsmClient) GetOr(key string, def []byte) ([]byte, error) {
+	value, err := hc.Get(key)
+	if errors.Is(err, ErrNotFound) {
+		return def, nil
+	}
+	return value, err
+}
+
+// Append 把 suffix 追加到 key 当前的值之后，返回追加后的完整值；key 不存在时视为追加到空值
+func (hc *HuaHuoLsmClient) Append(key string, suffix []byte) ([]byte, error) {
+	defer hc.invalidate(key)
+	c, err := hc.route(key)
 	if err != nil {
 		return nil, err
 	}
-	value, err := HuaHuoLsmCli.Clients[ip].get(key)
+	value, err := c.appendValue(key, suffix, hc.nextTraceID(), hc.opID)
 	return value, err
 }
 
-func (c *Client) set(key string, value []byte) error {
+// GetOrSet 在 key 存在时返回它当前的值，loaded 为 true；否则写入 value 并返回它，loaded 为 false。
+// 判断和写入在节点上原子地完成，可用于实现只有一个调用方负责填充的缓存
+func (hc *HuaHuoLsmClient) GetOrSet(key string, value []byte) (stored []byte, loaded bool, err error) {
+	defer hc.invalidate(key)
+	c, err := hc.route(key)
+	if err != nil {
+		return nil, false, err
+	}
+	return c.getOrSet(key, value, hc.nextTraceID(), hc.opID)
+}
+
+func (c *Client) set(key string, value []byte, flags uint32, traceID, opID string) error {
+	defer c.observe(opSet, time.Now())
 	// Serialize key and value to calculate total size
 
 	request := &Bluebell{
 		Command: SET_KEY,
 		Key:     key,
 		Value:   value,
+		TraceID: traceID,
+		OpID:    opID,
+		Flags:   flags,
 	}
 
-	go c.sendRequestToServer(request)
-	res, err := c.waitForResponseWithTimeout(5 * time.Second) // 等待响应，设置超时
+	res, err := c.exchange(request, 5*time.Second) // 等待响应，设置超时
 	if err != nil {
 		return err
 	}
 	if res.Code != SUCCESS {
-		return errors.New(string("set failed"))
+		return writeError("set", res)
 	}
 	return nil
 }
 
-func (c *Client) get(key string) ([]byte, error) {
+func (c *Client) get(key string, traceID string) ([]byte, uint32, error) {
+	defer c.observe(opGet, time.Now())
 	request := &Bluebell{
 		Command: GET_KEY,
 		Key:     key,
 		Value:   nil,
+		TraceID: traceID,
 	}
 
-	go c.sendRequestToServer(request)
-	res, err := c.waitForResponseWithTimeout(5 * time.Second) // 等待响应，设置超时
+	res, err := c.exchange(request, 5*time.Second) // 等待响应，设置超时
 	if err != nil {
-		return nil, err
+		return nil, 0, err
 	}
 	if res.Code != SUCCESS {
-		return nil, errors.New(string(res.Result))
+		// 节点对不存在的键返回不带结果的错误响应，其他错误带有错误信息
+		if len(res.Result) == 0 {
+			return nil, 0, ErrNotFound
+		}
+		return nil, 0, errors.New(string(res.Result))
 	}
 
-	return res.Result, nil
+	return res.Result, res.Flags, nil
 }
 
-func (c *Client) del(key string) error {
+func (c *Client) del(key string, traceID string) error {
+	defer c.observe(opDelete, time.Now())
 	request := &Bluebell{
 		Command: DEL_KEY,
 		Key:     key,
 		Value:   nil,
+		TraceID: traceID,
 	}
 
-	go c.sendRequestToServer(request)
-	res, err := c.waitForResponseWithTimeout(5 * time.Second) // 等待响应，设置超时
+	res, err := c.exchange(request, 5*time.Second) // 等待响应，设置超时
 	if err != nil {
 		return err
 	}
@@ -80,3 +185,46 @@ func (c *Client) del(key string) error {
 	}
 	return nil
 }
+
+func (c *Client) appendValue(key string, suffix []byte, traceID, opID string) ([]byte, error) {
+	request := &Bluebell{
+		Command: APPEND_KEY,
+		Key:     key,
+		Value:   suffix,
+		TraceID: traceID,
+		OpID:    opID,
+	}
+
+	res, err := c.exchange(request, 5*time.Second) // 等待响应，设置超时
+	if err != nil {
+		return nil, err
+	}
+	if res.Code != SUCCESS {
+		return nil, errors.New(string(res.Result))
+	}
+
+	return res.Result, nil
+}
+
+func (c *Client) getOrSet(key string, value []byte, traceID, opID string) ([]byte, bool, error) {
+	request := &Bluebell{
+		Command: GETORSET_KEY,
+		Key:     key,
+		Value:   value,
+		TraceID: traceID,
+		OpID:    opID,
+	}
+
+	res, err := c.exchange(request, 5*time.Second) // 等待响应，设置超时
+	if err != nil {
+		return nil, false, err
+	}
+	if res.Code != SUCCESS {
+		return nil, false, errors.New(string(res.Result))
+	}
+	if len(res.Result) == 0 {
+		return nil, false, errors.New("invalid getorset response")
+	}
+
+	return res.Result[1:], res.Result[0] == 1, nil
+}
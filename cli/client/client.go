@@ -2,13 +2,16 @@ package client
 
 import (
 	"bufio"
+	"crypto/rand"
 	"encoding/binary"
+	"encoding/hex"
 	"errors"
 	"fmt"
 	"io"
 	"log"
 	"net"
 	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/valyala/bytebufferpool"
@@ -24,6 +27,16 @@ var HuaHuoLsmCli *HuaHuoLsmClient
 type HuaHuoLsmClient struct {
 	Clients map[string]*Client
 	Ready   bool
+	// 调用方指定的追踪 ID，为空时每个请求生成一个新的
+	traceID string
+	// 调用方指定的幂等键，随写请求发送，为空时不去重
+	opID string
+	// 设置后按键范围路由，详见 UseRangeRing
+	rangeRing *RangeRing
+	// 不为 nil 时保证读到自己的写入，详见 WithReadYourWrites
+	written *writeSession
+	// 不为 nil 时在进程内缓存 Get 的结果，详见 WithLocalCache
+	cache *valueCache
 }
 
 func LsmCliInit() {
@@ -39,7 +52,16 @@ type Client struct {
 	Conn       net.Conn
 	ResponseCh chan []byte
 	Buffer     *bytebufferpool.ByteBuffer
-	Status     bool
+	// 连接是否可用，Start 成功后为 true，Close 后为 false。读取节点状态的协程与连接的读循环并发访问它
+	Status atomic.Bool
+	// 最近一次连接成功或收到响应的时间（UnixNano），原子地读写
+	lastHealthy int64
+	// 流属于连接，同一时刻一个连接上只进行一个流式传输
+	streamMu sync.Mutex
+	// 每个请求在等到自己的响应之前独占连接，详见 exchange
+	exchangeMu sync.Mutex
+	// 发往节点的 Get、Set 和 Delete 的延迟，下标为 opGet、opSet 和 opDelete，详见 Metrics
+	latency [numLatencyOps]latencyHistogram
 }
 
 func New(serverAddr string, serverPort int) *Client {
@@ -51,30 +73,36 @@ func New(serverAddr string, serverPort int) *Client {
 		ServerAddr: serverAddr,
 		ServerPort: serverPort,
 		Buffer:     bytebufferpool.Get(),
-		Status:     true,
 	}
 }
 
+// addr 返回节点的地址，与 HuaHuoLsmClient.Clients 的键相同
+func (c *Client) addr() string {
+	return fmt.Sprintf("%s:%d", c.ServerAddr, c.ServerPort)
+}
+
+// Start 连接节点并在后台接收响应，连接失败时返回错误
 func (c *Client) Start() error {
-	statusCh := make(chan bool) // 创建一个通道用于通知启动状态
+	statusCh := make(chan error) // 创建一个通道用于通知启动状态
 
 	go func() {
 		defer close(statusCh) // 确保在函数结束时关闭通道
 
 		c.ResponseCh = make(chan []byte, 1024)
 		log.Println("Starting huacache client...")
-		addr := fmt.Sprintf("%s:%d", c.ServerAddr, c.ServerPort)
-		conn, err := net.Dial("tcp", addr)
+		conn, err := net.Dial("tcp", c.addr())
 		if err != nil {
 			log.Printf("Connection failed: %v\n", err)
-			statusCh <- false
+			statusCh <- fmt.Errorf("failed to connect to %s: %w", c.addr(), err)
 			return
 		}
 		c.Conn = conn
 		defer conn.Close()
+		c.markHealthy()
+		c.Status.Store(true)
 
 		log.Println("Client started successfully, waiting to receive messages...")
-		statusCh <- true // 通知调用者启动成功
+		statusCh <- nil // 通知调用者启动成功
 		reader := bufio.NewReader(c.Conn)
 
 		for {
@@ -109,13 +137,12 @@ func (c *Client) Start() error {
 		c.Close()
 		log.Println("Client is shutting down " + time.Now().Format("2006-01-02 15:04:05"))
 	}()
-	if success := <-statusCh; success {
-		log.Println("Client has started successfully.")
-		// 这里可以进行其他操作，比如开始处理消息
-	} else {
+	if err := <-statusCh; err != nil {
 		log.Println("Client failed to start.")
+		return err
 	}
-	return nil // 返回通道
+	log.Println("Client has started successfully.")
+	return nil
 }
 
 func (c *Client) sendRequestToServer(request *Bluebell) error {
@@ -131,6 +158,28 @@ func (c *Client) sendRequestToServer(request *Bluebell) error {
 	return err
 }
 
+// exchange 独占连接发送请求，返回与请求的追踪 ID 匹配的响应。连接上的所有请求都通过 exchange 发送：
+// 响应都进入同一个 ResponseCh，两个请求交错时一个请求可能取走另一个请求的响应。
+// 之前超时的请求的响应可能迟到，它们的追踪 ID 不同，被丢弃；不返回追踪 ID 的旧版本节点的响应直接返回
+func (c *Client) exchange(request *Bluebell, timeout time.Duration) (*BluebellResponse, error) {
+	c.exchangeMu.Lock()
+	defer c.exchangeMu.Unlock()
+
+	if err := c.sendRequestToServer(request); err != nil {
+		return nil, err
+	}
+	deadline := time.Now().Add(timeout)
+	for {
+		res, err := c.waitForResponseWithTimeout(time.Until(deadline))
+		if err != nil {
+			return nil, err
+		}
+		if res.TraceID == request.TraceID || res.TraceID == "" {
+			return res, nil
+		}
+	}
+}
+
 func (c *Client) waitForResponseWithTimeout(timeout time.Duration) (*BluebellResponse, error) {
 	timer := timerPool.Get().(*time.Timer)
 	timer.Reset(timeout)
@@ -141,6 +190,7 @@ func (c *Client) waitForResponseWithTimeout(timeout time.Duration) (*BluebellRes
 
 	select {
 	case response := <-c.ResponseCh:
+		c.markHealthy()
 		res, err := DeserializeResponse(response)
 		if err != nil {
 			log.Printf("Error during response deserialization: %v", err)
@@ -156,6 +206,7 @@ func (c *Client) Close() error {
 	if c.Conn != nil {
 		err := c.Conn.Close()
 		c.Conn = nil // 清理连接
+		c.Status.Store(false)
 		if err != nil {
 			log.Printf("Failed to close connection: %v", err)
 			return err
@@ -165,3 +216,50 @@ func (c *Client) Close() error {
 	log.Println("Client is shutting down " + time.Now().Format("2006-01-02 15:04:05"))
 	return nil
 }
+
+// markHealthy 记录节点当前可用
+func (c *Client) markHealthy() {
+	atomic.StoreInt64(&c.lastHealthy, time.Now().UnixNano())
+}
+
+// LastHealthy 返回最近一次连接成功或收到响应的时间，从未成功时返回零值
+func (c *Client) LastHealthy() time.Time {
+	nanos := atomic.LoadInt64(&c.lastHealthy)
+	if nanos == 0 {
+		return time.Time{}
+	}
+	return time.Unix(0, nanos)
+}
+
+// WithTraceID 返回使用指定追踪 ID 发送请求的客户端，用于把客户端的调用与服务端日志中的同一请求对应起来
+func (hc *HuaHuoLsmClient) WithTraceID(traceID string) *HuaHuoLsmClient {
+	traced := *hc
+	traced.traceID = traceID
+	return &traced
+}
+
+// WithOpID 返回使用幂等键 opID 发送写请求（Set、Append 和 GetOrSet）的客户端。
+// 超时后用同一个幂等键重试时，节点返回第一次执行的结果而不会再次执行，因此 Append 的重试是安全的。
+// 每个逻辑上的写操作应使用不同的幂等键，节点只记住最近的一部分幂等键
+func (hc *HuaHuoLsmClient) WithOpID(opID string) *HuaHuoLsmClient {
+	withOpID := *hc
+	withOpID.opID = opID
+	return &withOpID
+}
+
+// nextTraceID 返回下一个请求的追踪 ID：调用方指定了就使用它，否则随机生成
+func (hc *HuaHuoLsmClient) nextTraceID() string {
+	if hc.traceID != "" {
+		return hc.traceID
+	}
+	return newTraceID()
+}
+
+// newTraceID 生成 16 个十六进制字符的随机追踪 ID
+func newTraceID() string {
+	var b [8]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return ""
+	}
+	return hex.EncodeToString(b[:])
+}
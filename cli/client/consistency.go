@@ -0,0 +1,101 @@
+package client
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+// writeSession 记录读写一致的客户端写入过的 key 和写入它们的节点
+type writeSession struct {
+	mu sync.Mutex
+	// key 到确认写入的节点地址
+	nodes map[string]string
+}
+
+// WithReadYourWrites 返回保证读到自己写入的客户端：返回的客户端 Set 成功之后，
+// 它对同一个 key 的 Get 总能读到这次写入，即使其间哈希环或范围路由发生了变化。
+//
+//   - Set 收到节点的成功响应后才记录写入的节点；节点在写入的 WAL 同步到磁盘后才返回成功，收到成功响应时写入已经持久化
+//   - Get 发送给确认了最近一次写入的节点，而不是按当前的路由重新选择；该节点已经断开时按当前的路由选择
+//
+// 代价是延迟：路由变化后读取仍然发给原来的节点，可能比新的节点更远或更忙。
+// 写入过的 key 在返回的客户端的生命周期内一直被记住，应为一个工作单元创建一个，而不是全局共用
+func (hc *HuaHuoLsmClient) WithReadYourWrites() *HuaHuoLsmClient {
+	session := *hc
+	session.written = &writeSession{nodes: make(map[string]string)}
+	return &session
+}
+
+// record 记录 key 由地址为 node 的节点确认写入
+func (s *writeSession) record(key, node string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.nodes[key] = node
+}
+
+// node 返回确认了 key 最近一次写入的节点地址
+func (s *writeSession) node(key string) (string, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	node, ok := s.nodes[key]
+	return node, ok
+}
+
+// routeWritten 返回确认了 key 最近一次写入的节点，该节点已经断开或没有写入过 key 时按当前的路由选择
+func (hc *HuaHuoLsmClient) routeWritten(key string) (*Client, error) {
+	if node, ok := hc.written.node(key); ok {
+		clientsMu.RLock()
+		c := hc.Clients[node]
+		clientsMu.RUnlock()
+		if c != nil && c.Status.Load() {
+			return c, nil
+		}
+	}
+	return hc.route(key)
+}
+
+// setAndWait 写入 key 并等待节点确认，成功后记录确认写入的节点
+func (hc *HuaHuoLsmClient) setAndWait(key string, value []byte, flags uint32) error {
+	c, err := hc.route(key)
+	if err != nil {
+		return err
+	}
+	defer c.observe(opSet, time.Now())
+	res, err := c.exchange(&Bluebell{
+		Command: SET_KEY,
+		Key:     key,
+		Value:   value,
+		TraceID: hc.nextTraceID(),
+		OpID:    hc.opID,
+		Flags:   flags,
+	}, 5*time.Second)
+	if err != nil {
+		return err
+	}
+	if res.Code != SUCCESS {
+		return writeError("set", res)
+	}
+	hc.written.record(key, c.addr())
+	return nil
+}
+
+// getWritten 从确认了 key 最近一次写入的节点读取 key
+func (hc *HuaHuoLsmClient) getWritten(key string) ([]byte, uint32, error) {
+	c, err := hc.routeWritten(key)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer c.observe(opGet, time.Now())
+	res, err := c.exchange(&Bluebell{Command: GET_KEY, Key: key, TraceID: hc.nextTraceID()}, 5*time.Second)
+	if err != nil {
+		return nil, 0, err
+	}
+	if res.Code != SUCCESS {
+		if len(res.Result) == 0 {
+			return nil, 0, ErrNotFound
+		}
+		return nil, 0, errors.New(string(res.Result))
+	}
+	return res.Result, res.Flags, nil
+}
@@ -0,0 +1,142 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestReadYourWrites(t *testing.T) {
+	LsmCliInit()
+	defer HuaHuoLsmCli.UseRangeRing(nil)
+
+	var addrs []string
+	for i := 0; i < 2; i++ {
+		n := startFakeNode(t)
+		go n.serve()
+		addr := n.listener.Addr().String()
+		if err := HuaHuoLsmCli.addNode(addr); err != nil {
+			t.Fatal(err)
+		}
+		defer HuaHuoLsmCli.removeNode(addr)
+		addrs = append(addrs, addr)
+	}
+
+	// 所有键先由第一个节点负责
+	ring := NewRangeRing()
+	ring.Assign("", addrs[0])
+	HuaHuoLsmCli.UseRangeRing(ring)
+
+	session := HuaHuoLsmCli.WithReadYourWrites()
+	for i := 0; i < 1000; i++ {
+		key := fmt.Sprintf("key%d", i%10)
+		value := []byte(fmt.Sprintf("value%d", i))
+		if err := session.Set(key, value); err != nil {
+			t.Fatal(err)
+		}
+		got, err := session.Get(key)
+		if err != nil || string(got) != string(value) {
+			t.Fatalf("iteration %d: expected %q, but got %q (%v)", i, value, got, err)
+		}
+	}
+
+	// 路由变化后，读取仍然发给确认了写入的节点
+	ring.Assign("", addrs[1])
+	if _, err := HuaHuoLsmCli.Get("key9"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected the new node to miss the key, but got %v", err)
+	}
+	got, err := session.Get("key9")
+	if err != nil || string(got) != "value999" {
+		t.Fatalf("expected value999 from the node that took the write, but got %q (%v)", got, err)
+	}
+
+	// 之前超时的请求迟到的响应被丢弃
+	stale, _ := (&BluebellResponse{Code: SUCCESS, Result: []byte("stale"), TraceID: "timed-out"}).Serialize()
+	HuaHuoLsmCli.Clients[addrs[0]].ResponseCh <- stale
+	got, err = session.Get("key9")
+	if err != nil || string(got) != "value999" {
+		t.Fatalf("expected value999 instead of the stale response, but got %q (%v)", got, err)
+	}
+
+	// 没有写入过的键按当前的路由读取
+	if err := HuaHuoLsmCli.Set("fresh", []byte("new")); err != nil {
+		t.Fatal(err)
+	}
+	got, err = session.Get("fresh")
+	if err != nil || string(got) != "new" {
+		t.Fatalf("expected new, but got %q (%v)", got, err)
+	}
+}
+
+func TestConcurrentPlainAndSessionRequests(t *testing.T) {
+	LsmCliInit()
+
+	// 节点处理每个请求前稍作等待，让多个请求同时在连接上等待响应
+	n := startFakeNode(t)
+	n.delay = 100 * time.Microsecond
+	go n.serve()
+	addr := n.listener.Addr().String()
+	if err := HuaHuoLsmCli.addNode(addr); err != nil {
+		t.Fatal(err)
+	}
+	defer HuaHuoLsmCli.removeNode(addr)
+	if err := HuaHuoLsmCli.Set("plain", []byte("plain-value")); err != nil {
+		t.Fatal(err)
+	}
+
+	// 普通的 Get 与读写一致的 Set、Get 共用同一个连接，每个请求都要拿到自己的响应，
+	// 其间不断有之前超时的请求的响应迟到，它们不能被当作任何请求的结果
+	var wg sync.WaitGroup
+	errs := make(chan error, 8)
+	done := make(chan struct{})
+	stale, _ := (&BluebellResponse{Code: SUCCESS, Result: []byte("stale"), TraceID: "timed-out"}).Serialize()
+	responses := HuaHuoLsmCli.Clients[addr].ResponseCh
+	go func() {
+		for {
+			select {
+			case <-done:
+				return
+			case responses <- stale:
+				time.Sleep(time.Millisecond)
+			}
+		}
+	}()
+	for g := 0; g < 4; g++ {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				got, err := HuaHuoLsmCli.Get("plain")
+				if err != nil || string(got) != "plain-value" {
+					errs <- fmt.Errorf("plain get %d: expected plain-value, but got %q (%v)", i, got, err)
+					return
+				}
+			}
+		}()
+		go func() {
+			defer wg.Done()
+			session := HuaHuoLsmCli.WithReadYourWrites()
+			for i := 0; i < 200; i++ {
+				key := fmt.Sprintf("session%d", g)
+				value := []byte(fmt.Sprintf("value%d-%d", g, i))
+				if err := session.Set(key, value); err != nil {
+					errs <- fmt.Errorf("session %d set %d: %w", g, i, err)
+					return
+				}
+				got, err := session.Get(key)
+				if err != nil || string(got) != string(value) {
+					errs <- fmt.Errorf("session %d get %d: expected %q, but got %q (%v)", g, i, value, got, err)
+					return
+				}
+			}
+		}()
+	}
+	wg.Wait()
+	close(done)
+	close(errs)
+	for err := range errs {
+		t.Error(err)
+	}
+}
@@ -3,9 +3,11 @@ package client
 import (
 	"crypto/md5"
 	"errors"
+	"net"
 	"sort"
 	"strconv"
 	"sync"
+	"sync/atomic"
 )
 
 var (
@@ -39,10 +41,20 @@ func hash(digest *[16]byte, h int) int64 {
 }
 
 // HashRing represents the structure of a consistent hash ring.
+// 节点变化（etcd 的监听协程调用 Add、Remove、SetWeight）与请求中的 Get 并发进行，由 mu 同步
 type HashRing struct {
+	mu sync.RWMutex
+
 	replicas int              // Number of virtual nodes per physical node
 	keys     []int64          // Sorted hash values
 	hashMap  map[int64]string // Mapping from hash values to physical node names
+
+	generation uint64      // 拓扑变化时递增，用于使路由缓存失效
+	cache      *routeCache // 可选的 key 到节点的路由缓存
+
+	zones map[string]string // 物理节点所在的机架或可用区，GetN 据此分散副本
+
+	nodeWeights map[string]int // 权重不是 FULL_WEIGHT 的物理节点，虚拟节点数量按权重等比例减少
 }
 
 // NewRing creates a new hash ring.
@@ -54,32 +66,144 @@ func NewRing() *HashRing {
 	return m
 }
 
+// EnableCache 为哈希环启用容量为 size 的路由缓存（size <= 0 表示关闭）。
+// 节点的增删会使已缓存的路由失效，因此重新平衡后仍能路由到正确的节点。
+func (m *HashRing) EnableCache(size int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if size <= 0 {
+		m.cache = nil
+		return
+	}
+	m.cache = newRouteCache(size)
+}
+
+// nodeReplicas 返回物理节点 node 按权重应占据的虚拟节点数量，至少为 1，调用方必须持有 mu
+func (m *HashRing) nodeReplicas(node string) int {
+	weight, ok := m.nodeWeights[node]
+	if !ok {
+		return m.replicas
+	}
+	return max(1, m.replicas*weight/FULL_WEIGHT)
+}
+
+// SetWeight 设置物理节点 node 的权重（FULL_WEIGHT 为全部权重），节点占据的虚拟节点数量与权重成正比，
+// 分到的 key 也随之减少，例如让仍在预热的节点只承担少量流量。
+// 节点已在环上时按新的权重重新放置它的虚拟节点；否则只记录权重，之后 Add 时生效
+func (m *HashRing) SetWeight(node string, weight int) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	inRing := false
+	for _, hash := range m.virtualHashes(node) {
+		if m.hashMap[hash] == node {
+			inRing = true
+			break
+		}
+	}
+	if inRing {
+		m.remove(node)
+	}
+
+	if weight >= FULL_WEIGHT {
+		delete(m.nodeWeights, node)
+	} else {
+		if m.nodeWeights == nil {
+			m.nodeWeights = make(map[string]int)
+		}
+		m.nodeWeights[node] = weight
+	}
+
+	if inRing {
+		m.add(node)
+	}
+}
+
+// virtualHashes 返回物理节点 node 的所有虚拟节点的哈希值，调用方必须持有 mu
+func (m *HashRing) virtualHashes(node string) []int64 {
+	replicas := m.nodeReplicas(node)
+	hashes := make([]int64, 0, replicas*4)
+	for i := 0; i < replicas; i++ {
+		virtualNodeKey := node + strconv.Itoa(i)
+		digest := computeMD5(virtualNodeKey)
+		for j := 0; j < 4; j++ {
+			hashes = append(hashes, hash(&digest, j))
+		}
+	}
+	return hashes
+}
+
 // Add adds new physical nodes to the hash ring.
+// 只对新节点的虚拟节点排序，再与已排好序的 keys 归并，避免每次变更都重新排序整个环
 func (m *HashRing) Add(keys ...string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.add(keys...)
+}
+
+// add 把物理节点加入哈希环，调用方必须持有 mu 的写锁
+func (m *HashRing) add(keys ...string) {
+	var added []int64
 	for _, key := range keys {
-		for i := 0; i < m.replicas; i++ {
-			virtualNodeKey := key + strconv.Itoa(i)
-			digest := computeMD5(virtualNodeKey)
-			for j := 0; j < 4; j++ {
-				hash := hash(&digest, j)
-				m.keys = append(m.keys, hash)
-				m.hashMap[hash] = key
+		for _, hash := range m.virtualHashes(key) {
+			if _, ok := m.hashMap[hash]; !ok {
+				added = append(added, hash)
 			}
+			m.hashMap[hash] = key
 		}
 	}
-	sort.Slice(m.keys, func(i, j int) bool {
-		return m.keys[i] < m.keys[j]
+	sort.Slice(added, func(i, j int) bool {
+		return added[i] < added[j]
 	})
+
+	merged := make([]int64, 0, len(m.keys)+len(added))
+	i, j := 0, 0
+	for i < len(m.keys) || j < len(added) {
+		var next int64
+		if j == len(added) || i < len(m.keys) && m.keys[i] <= added[j] {
+			next = m.keys[i]
+			i++
+		} else {
+			next = added[j]
+			j++
+		}
+		// 多个虚拟节点的哈希相同时只保留一个
+		if len(merged) == 0 || merged[len(merged)-1] != next {
+			merged = append(merged, next)
+		}
+	}
+	m.keys = merged
+	atomic.AddUint64(&m.generation, 1)
 }
 
 // Get retrieves the closest physical node for the given key.
 func (m *HashRing) Get(key string) (string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
 	if len(m.keys) == 0 {
 		return "", nil
 	}
 	if len(m.hashMap) == 0 {
 		return "", errors.New("no node available!")
 	}
+	if m.cache == nil {
+		return m.lookup(key), nil
+	}
+
+	generation := atomic.LoadUint64(&m.generation)
+	if node, ok := m.cache.get(key, generation); ok {
+		return node, nil
+	}
+	node := m.lookup(key)
+	m.cache.add(key, node, generation)
+	return node, nil
+}
+
+// lookup 计算 key 的哈希并在环上查找顺时针方向最近的节点，调用方必须持有 mu
+func (m *HashRing) lookup(key string) string {
 	digest := computeMD5(key)
 	hash := hash(&digest, 0)
 	idx := sort.Search(len(m.keys), func(i int) bool {
@@ -88,28 +212,138 @@ func (m *HashRing) Get(key string) (string, error) {
 	if idx == len(m.keys) {
 		idx = 0
 	}
-	return m.hashMap[m.keys[idx]], nil
+	return m.hashMap[m.keys[idx]]
 }
 
+// weights 返回每个物理节点在环上占据的虚拟节点数量
+func (m *HashRing) weights() map[string]int {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	weights := make(map[string]int)
+	for _, node := range m.hashMap {
+		weights[node]++
+	}
+	return weights
+}
+
+// SetZone 设置物理节点 node 所在的机架或可用区，zone 为空时清除。
+// GetN 会优先把副本放在不同的可用区中
+func (m *HashRing) SetZone(node, zone string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if zone == "" {
+		delete(m.zones, node)
+		return
+	}
+	if m.zones == nil {
+		m.zones = make(map[string]string)
+	}
+	m.zones[node] = zone
+}
+
+// GetN 从 key 的位置开始顺时针遍历哈希环，返回最多 n 个用于存放副本的物理节点，第一个与 Get 的结果相同。
+// 同一主机（地址中端口之前的部分）上的多个节点只选第一个，避免多个副本落在同一台机器上；
+// 设置了可用区时先选择可用区互不相同的主机，可用区不够时再按环上的顺序用其余主机补足。
+// 不同主机的数量少于 n 时返回的节点少于 n 个
+func (m *HashRing) GetN(key string, n int) ([]string, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	if len(m.keys) == 0 || n <= 0 {
+		return nil, nil
+	}
+	if len(m.hashMap) == 0 {
+		return nil, errors.New("no node available!")
+	}
+
+	digest := computeMD5(key)
+	hash := hash(&digest, 0)
+	start := sort.Search(len(m.keys), func(i int) bool {
+		return m.keys[i] >= hash
+	})
+
+	// 按环上的顺序收集每台主机遇到的第一个节点；没有可用区时收集到 n 个即可
+	var candidates []string
+	hosts := make(map[string]bool)
+	for i := 0; i < len(m.keys); i++ {
+		node := m.hashMap[m.keys[(start+i)%len(m.keys)]]
+		if h := nodeHost(node); !hosts[h] {
+			hosts[h] = true
+			candidates = append(candidates, node)
+			if len(m.zones) == 0 && len(candidates) == n {
+				break
+			}
+		}
+	}
+
+	// 先选可用区互不相同的主机，没有设置可用区的节点不受限制
+	nodes := make([]string, 0, n)
+	chosen := make(map[string]bool)
+	zones := make(map[string]bool)
+	for _, node := range candidates {
+		if len(nodes) == n {
+			break
+		}
+		zone := m.zones[node]
+		if zone != "" && zones[zone] {
+			continue
+		}
+		zones[zone] = true
+		chosen[node] = true
+		nodes = append(nodes, node)
+	}
+	for _, node := range candidates {
+		if len(nodes) == n {
+			break
+		}
+		if !chosen[node] {
+			nodes = append(nodes, node)
+		}
+	}
+	return nodes, nil
+}
+
+// nodeHost 返回节点地址中的主机部分，地址不含端口时返回地址本身
+func nodeHost(node string) string {
+	host, _, err := net.SplitHostPort(node)
+	if err != nil {
+		return node
+	}
+	return host
+}
+
+// Remove 从哈希环中移除物理节点，同时清除它的权重
 func (m *HashRing) Remove(node string) {
-	// 遍历哈希映射，移除与目标节点相关的所有虚拟节点
-	for hashValue, physicalNode := range m.hashMap {
-		if physicalNode == node {
-			delete(m.hashMap, hashValue)
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.remove(node)
+}
+
+// remove 从哈希环中移除物理节点，调用方必须持有 mu 的写锁
+func (m *HashRing) remove(node string) {
+	// 重新计算目标节点的虚拟节点，移除仍属于它的哈希值
+	removed := false
+	for _, hash := range m.virtualHashes(node) {
+		if m.hashMap[hash] == node {
+			delete(m.hashMap, hash)
+			removed = true
 		}
 	}
+	if !removed {
+		return
+	}
 
-	// 重建 keys 列表
+	// 过滤掉已移除的哈希值，剩余元素的相对顺序不变，无需重新排序
 	newKeys := make([]int64, 0, len(m.keys))
 	for _, key := range m.keys {
-		if m.hashMap[key] != node {
+		if _, ok := m.hashMap[key]; ok {
 			newKeys = append(newKeys, key)
 		}
 	}
-
-	// 更新 keys 列表并重新排序
 	m.keys = newKeys
-	sort.Slice(m.keys, func(i, j int) bool {
-		return m.keys[i] < m.keys[j]
-	})
+	delete(m.nodeWeights, node)
+	atomic.AddUint64(&m.generation, 1)
 }
@@ -1,7 +1,9 @@
 package client
 
 import (
+	"math/rand"
 	"strconv"
+	"sync"
 	"testing"
 )
 
@@ -38,3 +40,218 @@ func TestConsistentHash(t *testing.T) {
 		t.Fatalf("Load balancing average test failed: Maximum percentage: %.2f%%, Minimum percentage: %.2f%%, Difference exceeds %.2f%%", maxRate, minRate, limit)
 	}
 }
+
+func TestRouteCacheRebalance(t *testing.T) {
+	ring := NewRing()
+	ring.EnableCache(16)
+	ring.Add("192.128.1.1:8080", "192.128.1.2:8080", "192.128.1.3:8080")
+
+	// 找到一个路由到第一个节点的 key 并缓存
+	var key string
+	for i := 0; ; i++ {
+		key = "key" + strconv.Itoa(i)
+		if node, _ := ring.Get(key); node == "192.128.1.1:8080" {
+			break
+		}
+	}
+	if node, _ := ring.Get(key); node != "192.128.1.1:8080" {
+		t.Fatalf("expected cached node 192.128.1.1:8080, but got %s", node)
+	}
+
+	// 移除节点后缓存的路由失效，key 被路由到剩余的节点
+	ring.Remove("192.128.1.1:8080")
+	node, _ := ring.Get(key)
+	if node == "192.128.1.1:8080" || node == "" {
+		t.Fatalf("expected key to move off the removed node, but got %q", node)
+	}
+
+	// 缓存不超过容量
+	for i := 0; i < 100; i++ {
+		ring.Get("key" + strconv.Itoa(i))
+	}
+	if ring.cache.len() != 16 {
+		t.Fatalf("expected 16 cached routes, but got %d", ring.cache.len())
+	}
+}
+
+// BenchmarkRingGet 在倾斜的（Zipf 分布）负载下比较有无路由缓存的开销
+func BenchmarkRingGet(b *testing.B) {
+	keys := make([]string, 10000)
+	for i := range keys {
+		keys[i] = "key" + strconv.Itoa(i)
+	}
+
+	for _, size := range []int{0, 1024} {
+		b.Run("cache="+strconv.Itoa(size), func(b *testing.B) {
+			ring := NewRing()
+			ring.EnableCache(size)
+			for i := 1; i <= 8; i++ {
+				ring.Add("192.128.1." + strconv.Itoa(i) + ":8080")
+			}
+			zipf := rand.NewZipf(rand.New(rand.NewSource(1)), 1.2, 1, uint64(len(keys)-1))
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				ring.Get(keys[zipf.Uint64()])
+			}
+		})
+	}
+}
+
+func TestRingIncrementalMatchesRebuild(t *testing.T) {
+	ring := NewRing()
+	members := make(map[string]bool)
+	node := func(i int) string { return "10.0." + strconv.Itoa(i/256) + "." + strconv.Itoa(i%256) + ":9000" }
+
+	r := rand.New(rand.NewSource(1))
+	for step := 0; step < 200; step++ {
+		n := node(r.Intn(30))
+		if members[n] && r.Intn(2) == 0 {
+			ring.Remove(n)
+			delete(members, n)
+		} else {
+			ring.Add(n)
+			members[n] = true
+		}
+
+		// 按当前成员完整重建的结果
+		expected := make(map[int64]bool)
+		for m := range members {
+			for _, h := range ring.virtualHashes(m) {
+				expected[h] = true
+			}
+		}
+		if len(ring.keys) != len(expected) || len(ring.hashMap) != len(expected) {
+			t.Fatalf("step %d: expected %d hashes, but got %d keys and %d mappings", step, len(expected), len(ring.keys), len(ring.hashMap))
+		}
+		for i, h := range ring.keys {
+			if i > 0 && ring.keys[i-1] >= h {
+				t.Fatalf("step %d: keys are not strictly sorted at %d", step, i)
+			}
+			if !expected[h] || !members[ring.hashMap[h]] {
+				t.Fatalf("step %d: unexpected hash %d owned by %q", step, h, ring.hashMap[h])
+			}
+		}
+	}
+}
+
+// TestRingConcurrentChurn 在节点变化的同时查找，用 go test -race 运行时检查数据竞争
+func TestRingConcurrentChurn(t *testing.T) {
+	ring := NewRing()
+	ring.EnableCache(100)
+	for i := 0; i < 4; i++ {
+		ring.Add("10.0.0." + strconv.Itoa(i) + ":9000")
+	}
+
+	var wg sync.WaitGroup
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 50; i++ {
+			n := "10.0.1." + strconv.Itoa(i%5) + ":9000"
+			ring.Add(n)
+			ring.SetWeight(n, FULL_WEIGHT/2)
+			ring.Remove(n)
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 2000; i++ {
+			key := strconv.Itoa(i)
+			node, err := ring.Get(key)
+			if err != nil || node == "" {
+				t.Errorf("expected a node for %s, but got %q (%v)", key, node, err)
+				return
+			}
+			if _, err := ring.GetN(key, 2); err != nil {
+				t.Errorf("unexpected error: %v", err)
+				return
+			}
+		}
+	}()
+	wg.Wait()
+}
+
+func TestGetNDistinctHosts(t *testing.T) {
+	// 一台主机上运行多个节点，它们的虚拟节点占据环上的大部分位置
+	ring := NewRing()
+	for port := 8080; port < 8086; port++ {
+		ring.Add("10.0.0.1:" + strconv.Itoa(port))
+	}
+	ring.Add("10.0.0.2:8080", "10.0.0.3:8080")
+
+	for i := 0; i < 1000; i++ {
+		key := "key" + strconv.Itoa(i)
+		nodes, err := ring.GetN(key, 3)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if len(nodes) != 3 {
+			t.Fatalf("expected 3 replicas for %s, but got %v", key, nodes)
+		}
+		if first, _ := ring.Get(key); nodes[0] != first {
+			t.Fatalf("expected the first replica of %s to be %s, but got %v", key, first, nodes)
+		}
+		hosts := make(map[string]bool)
+		for _, node := range nodes {
+			hosts[nodeHost(node)] = true
+		}
+		if len(hosts) != 3 {
+			t.Fatalf("expected replicas of %s on 3 distinct hosts, but got %v", key, nodes)
+		}
+
+		// 只有 3 台主机，更多的副本无处可放
+		if nodes, _ := ring.GetN(key, 5); len(nodes) != 3 {
+			t.Fatalf("expected at most 3 replicas, but got %v", nodes)
+		}
+	}
+}
+
+func TestGetNZones(t *testing.T) {
+	ring := NewRing()
+	zones := map[string]string{
+		"10.0.0.1:8080": "a",
+		"10.0.0.2:8080": "a",
+		"10.0.0.3:8080": "a",
+		"10.0.0.4:8080": "b",
+		"10.0.0.5:8080": "c",
+	}
+	for node, zone := range zones {
+		ring.Add(node)
+		ring.SetZone(node, zone)
+	}
+
+	for i := 0; i < 1000; i++ {
+		key := "key" + strconv.Itoa(i)
+		nodes, _ := ring.GetN(key, 3)
+		seen := make(map[string]bool)
+		for _, node := range nodes {
+			seen[zones[node]] = true
+		}
+		if len(nodes) != 3 || len(seen) != 3 {
+			t.Fatalf("expected replicas of %s in 3 zones, but got %v", key, nodes)
+		}
+
+		// 可用区不够时用同一可用区的其他主机补足
+		if nodes, _ := ring.GetN(key, 5); len(nodes) != 5 {
+			t.Fatalf("expected 5 replicas, but got %v", nodes)
+		}
+	}
+}
+
+// BenchmarkRingChurn 在 100 个节点的环上反复移除并重新加入一个节点
+func BenchmarkRingChurn(b *testing.B) {
+	ring := NewRing()
+	nodes := make([]string, 100)
+	for i := range nodes {
+		nodes[i] = "10.0.0." + strconv.Itoa(i) + ":9000"
+		ring.Add(nodes[i])
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		node := nodes[i%len(nodes)]
+		ring.Remove(node)
+		ring.Add(node)
+	}
+}
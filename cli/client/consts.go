@@ -4,17 +4,50 @@ const (
 	MB                         = 1 << 20
 	GB                         = 1 << 30
 	HTTP_BODY_DEFAULT_MAX_SIZE = 32 * MB
+	// 流式传输时每块的大小
+	STREAM_CHUNK_SIZE = 1 * MB
 )
 
 // command
 const (
-	GET_KEY = "get"
-	SET_KEY = "set"
-	DEL_KEY = "del"
+	GET_KEY    = "get"
+	SET_KEY    = "set"
+	DEL_KEY    = "del"
+	APPEND_KEY = "append"
+	// 键存在时返回当前值，否则写入；响应的第一个字节为 1 表示值已存在
+	GETORSET_KEY = "getorset"
+	SCAN_KEY     = "scan"
+	// 前缀扫描，Key 是前缀，Value 是十进制的数量上限
+	SCANPREFIX_KEY = "scanprefix"
+	// 返回节点的全部键值对，数据量超过节点的上限时返回错误
+	GETALL_KEY = "getall"
+	// 流式读取，getstream 的响应是 8 字节的值长度，之后每个 getchunk 返回下一块
+	GETSTREAM_KEY = "getstream"
+	GETCHUNK_KEY  = "getchunk"
+	// 流式写入，setchunk 追加一块，setcommit 把拼好的值写入存储
+	SETCHUNK_KEY  = "setchunk"
+	SETCOMMIT_KEY = "setcommit"
+	// 让节点把存储备份到节点快照根目录下名为 Value 的子目录，Key 是快照 ID，响应是 JSON 的快照清单
+	SNAPSHOT_KEY = "snapshot"
+	// 写入多个键值对，Value 依次是长度前缀的键和值，响应依次是每个键值对的错误信息
+	MSET_KEY = "mset"
+	// 把多个 set 和 del 作为一个批量执行，Value 依次是每个子命令的长度前缀的命令、键和值，
+	// 响应的第一个字节为 1 表示批量已写入，之后依次是每个子命令的错误信息
+	EXEC_KEY = "exec"
 )
 const (
 	SUCCESS = "0"
+	// 值超过节点接受的最大值，Result 是节点的错误信息
+	VALUE_TOO_LARGE = "2"
 )
 const (
 	CONSISTENTHASH_VIRTUAL_NODE_NUM = 160
+	// 节点在 etcd 中注册的全部权重，预热中的节点以更低的权重注册
+	FULL_WEIGHT = 100
+)
+
+// value type tag
+const (
+	TYPE_STRING byte = 's'
+	TYPE_JSON   byte = 'j'
 )
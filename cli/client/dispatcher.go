@@ -2,8 +2,8 @@ package client
 
 import (
 	"fmt"
-	"strconv"
-	"strings"
+	"log"
+	"time"
 )
 
 var RegistryCli *RegistryClient
@@ -17,22 +17,70 @@ func GetRegistryCli() *RegistryClient {
 	return RegistryCli
 }
 
+// 初始化失败后重试的初始间隔和最大间隔，每次失败间隔翻倍
+var (
+	dispatcherRetryInterval    = time.Second
+	dispatcherRetryMaxInterval = 30 * time.Second
+)
+
+// ipRegistry 是 dispatcher 依赖的注册中心操作，测试中可以替换
+type ipRegistry interface {
+	QueryNodes() (map[string]int, error)
+	WatchIPChanges()
+}
+
+// DispatcherInit 从 addr 上的 etcd 加载节点列表并监听变化。
+// etcd 不可用时不会 panic，而是在后台以指数退避重试，etcd 恢复后再加载节点。
 func DispatcherInit(addr string) {
-	cli, err := NewRegistryClient([]string{addr})
+	connect := func() (ipRegistry, error) {
+		cli, err := NewRegistryClient([]string{addr})
+		if err != nil {
+			return nil, err
+		}
+		RegistryCli = cli
+		return cli, nil
+	}
+	if err := dispatcherInit(connect); err != nil {
+		log.Printf("failed to init dispatcher, retrying in background: %v", err)
+		go retryDispatcherInit(connect)
+	}
+}
+
+// retryDispatcherInit 以指数退避反复初始化 dispatcher 直到成功
+func retryDispatcherInit(connect func() (ipRegistry, error)) {
+	interval := dispatcherRetryInterval
+	for {
+		time.Sleep(interval)
+		err := dispatcherInit(connect)
+		if err == nil {
+			log.Printf("dispatcher initialized after etcd recovered")
+			return
+		}
+		log.Printf("failed to init dispatcher: %v", err)
+		if interval *= 2; interval > dispatcherRetryMaxInterval {
+			interval = dispatcherRetryMaxInterval
+		}
+	}
+}
+
+// dispatcherInit 连接注册中心，按注册的权重把节点加入哈希环并启动监听协程
+func dispatcherInit(connect func() (ipRegistry, error)) error {
+	cli, err := connect()
+	if err != nil {
+		return err
+	}
+	nodes, err := cli.QueryNodes()
 	if err != nil {
-		panic(err)
+		return err
 	}
-	ips, _ := cli.QueryIPs()
-	fmt.Println(ips)
-	for _, ip := range ips {
-		parts := strings.Split(ip, ":")
-		addr := parts[0]
-		port, _ := strconv.Atoi(parts[1])
-		HuaHuoLsmCli.Clients[ip] = New(addr, port)
-		HuaHuoLsmCli.Clients[ip].Start()
-		HuaHuoLsmCli.Clients[ip].Status = true
-		GetRing().Add(ip)
+	fmt.Println(nodes)
+	for ip, weight := range nodes {
+		// 连接不上的节点先跳过，它重新注册时由监听协程加入
+		if err := HuaHuoLsmCli.addWeightedNode(ip, weight); err != nil {
+			fmt.Printf("[WARN] failed to add node %s: %v\n", ip, err)
+		}
 	}
 	// 启动监听协程
 	go cli.WatchIPChanges()
+	return nil
 }
@@ -0,0 +1,129 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// fakeRegistry 返回固定的节点及其权重，记录是否开始监听
+type fakeRegistry struct {
+	nodes   map[string]int
+	watched chan struct{}
+}
+
+func (r *fakeRegistry) QueryNodes() (map[string]int, error) {
+	return r.nodes, nil
+}
+
+func (r *fakeRegistry) WatchIPChanges() {
+	close(r.watched)
+}
+
+func TestDispatcherInitRetriesWhileEtcdIsDown(t *testing.T) {
+	LsmCliInit()
+	dispatcherRetryInterval = time.Millisecond
+	dispatcherRetryMaxInterval = 5 * time.Millisecond
+	defer func() {
+		dispatcherRetryInterval = time.Second
+		dispatcherRetryMaxInterval = 30 * time.Second
+	}()
+
+	n := startFakeNode(t)
+	go n.serve()
+	addr := n.listener.Addr().String()
+	registry := &fakeRegistry{nodes: map[string]int{addr: FULL_WEIGHT}, watched: make(chan struct{})}
+	defer GetRing().Remove(addr)
+
+	// 前几次连接模拟 etcd 不可用
+	var attempts int32
+	connect := func() (ipRegistry, error) {
+		if atomic.AddInt32(&attempts, 1) <= 3 {
+			return nil, errors.New("etcd is unavailable")
+		}
+		return registry, nil
+	}
+
+	if err := dispatcherInit(connect); err == nil {
+		t.Fatal("expected the first attempt to fail")
+	}
+	go retryDispatcherInit(connect)
+
+	select {
+	case <-registry.watched:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("dispatcher did not initialize after etcd recovered, attempts: %d", atomic.LoadInt32(&attempts))
+	}
+	c := HuaHuoLsmCli.Clients[addr]
+	if c == nil || !c.Status.Load() {
+		t.Fatalf("expected node %s to be connected", addr)
+	}
+	defer c.Close()
+	if node, err := GetRing().Get("key"); err != nil || node != addr {
+		t.Fatalf("expected key to route to %s, but got %s (%v)", addr, node, err)
+	}
+}
+
+func TestDispatcherWarmingNodeWeight(t *testing.T) {
+	LsmCliInit()
+
+	warm, cold := startFakeNode(t), startFakeNode(t)
+	go warm.serve()
+	go cold.serve()
+	warmAddr, coldAddr := warm.listener.Addr().String(), cold.listener.Addr().String()
+	defer HuaHuoLsmCli.removeNode(warmAddr)
+	defer HuaHuoLsmCli.removeNode(coldAddr)
+
+	registry := &fakeRegistry{
+		nodes:   map[string]int{warmAddr: FULL_WEIGHT, coldAddr: 10},
+		watched: make(chan struct{}),
+	}
+	if err := dispatcherInit(func() (ipRegistry, error) { return registry, nil }); err != nil {
+		t.Fatal(err)
+	}
+
+	// coldShare 返回路由到仍在预热的节点的 key 的比例
+	coldShare := func() float64 {
+		hits := 0
+		for i := 0; i < 10000; i++ {
+			if node, _ := GetRing().Get(fmt.Sprintf("key%d", i)); node == coldAddr {
+				hits++
+			}
+		}
+		return float64(hits) / 10000
+	}
+
+	// 权重 10 的节点大约分到 10/110 的 key
+	if share := coldShare(); share > 0.2 {
+		t.Fatalf("expected the warming node to receive reduced traffic, but got %.2f of keys", share)
+	}
+
+	// 预热完成后以全部权重重新注册，不重新建立连接
+	c := HuaHuoLsmCli.Clients[coldAddr]
+	if err := HuaHuoLsmCli.addWeightedNode(coldAddr, FULL_WEIGHT); err != nil {
+		t.Fatal(err)
+	}
+	if HuaHuoLsmCli.Clients[coldAddr] != c {
+		t.Fatal("expected the existing connection to be kept when the weight changes")
+	}
+	if share := coldShare(); share < 0.35 || share > 0.65 {
+		t.Fatalf("expected the ready node to receive about half of keys, but got %.2f", share)
+	}
+}
+
+func TestParseWeight(t *testing.T) {
+	for value, want := range map[string]int{
+		"10":  10,
+		"100": FULL_WEIGHT,
+		"250": FULL_WEIGHT,
+		"-5":  0,
+		// 旧版本的节点注册的是时间
+		"2024-01-01 00:00:00 +0000 UTC": FULL_WEIGHT,
+	} {
+		if got := parseWeight([]byte(value)); got != want {
+			t.Fatalf("expected weight %d for %q, but got %d", want, value, got)
+		}
+	}
+}
@@ -5,10 +5,12 @@ import (
 	"fmt"
 	clientv3 "go.etcd.io/etcd/client/v3"
 	"strconv"
-	"strings"
 	"time"
 )
 
+// etcd 请求的超时时间，etcd 不可用时查询在超时后失败而不是一直阻塞
+const requestTimeout = 5 * time.Second
+
 type RegistryClient struct {
 	client *clientv3.Client
 	lease  clientv3.Lease
@@ -47,20 +49,44 @@ func (rc *RegistryClient) AddIP(ip string) error {
 
 // QueryIPs 查询所有已注册IP地址
 func (rc *RegistryClient) QueryIPs() ([]string, error) {
-	ctx := context.Background()
+	nodes, err := rc.QueryNodes()
+	if err != nil {
+		return nil, err
+	}
+
+	ips := make([]string, 0, len(nodes))
+	for ip := range nodes {
+		ips = append(ips, ip)
+	}
+	return ips, nil
+}
+
+// QueryNodes 查询所有已注册的节点地址及其权重
+func (rc *RegistryClient) QueryNodes() (map[string]int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), requestTimeout)
+	defer cancel()
 	resp, err := rc.client.Get(ctx, "/registry/ips/", clientv3.WithPrefix())
 	if err != nil {
 		return nil, err
 	}
 
-	ips := make([]string, 0)
+	nodes := make(map[string]int)
 	for _, kv := range resp.Kvs {
-		ips = append(ips, string(kv.Key[len("/registry/ips/"):]))
+		nodes[string(kv.Key[len("/registry/ips/"):])] = parseWeight(kv.Value)
 	}
-	return ips, nil
+	return nodes, nil
+}
+
+// parseWeight 解析节点注册的权重，值不是十进制整数时（旧版本的节点注册的是时间）视为 FULL_WEIGHT
+func parseWeight(value []byte) int {
+	weight, err := strconv.Atoi(string(value))
+	if err != nil || weight > FULL_WEIGHT {
+		return FULL_WEIGHT
+	}
+	return max(weight, 0)
 }
 
-// WatchIPChanges 监听IP注册变化
+// WatchIPChanges 监听IP注册变化，节点更新权重时调整它在哈希环上的虚拟节点数量
 func (rc *RegistryClient) WatchIPChanges() {
 
 	watcher := clientv3.NewWatcher(rc.client)
@@ -71,18 +97,14 @@ func (rc *RegistryClient) WatchIPChanges() {
 			ip := string(ev.Kv.Key[len("/registry/ips/"):])
 			switch ev.Type {
 			case clientv3.EventTypePut:
-				fmt.Printf("[INFO] IP added: %s (Revision: %d)\n", ip, ev.Kv.CreateRevision)
-				GetRing().Add(ip)
-				parts := strings.Split(ip, ":")
-				addr := parts[0]
-				port, _ := strconv.Atoi(parts[1])
-				HuaHuoLsmCli.Clients[ip] = New(addr, port)
-				HuaHuoLsmCli.Clients[ip].Start()
-				HuaHuoLsmCli.Clients[ip].Status = true
+				weight := parseWeight(ev.Kv.Value)
+				fmt.Printf("[INFO] IP added: %s, weight %d (Revision: %d)\n", ip, weight, ev.Kv.ModRevision)
+				if err := HuaHuoLsmCli.addWeightedNode(ip, weight); err != nil {
+					fmt.Printf("[WARN] failed to add node %s: %v\n", ip, err)
+				}
 			case clientv3.EventTypeDelete:
 				fmt.Printf("[WARN] IP expired/deleted: %s (Revision: %d)\n", ip, ev.Kv.ModRevision)
-				GetRing().Remove(ip)
-				HuaHuoLsmCli.Clients[ip].Close()
+				HuaHuoLsmCli.removeNode(ip)
 			}
 		}
 	}
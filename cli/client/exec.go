@@ -0,0 +1,147 @@
+package client
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// ErrTxAborted 表示子命令本身合法，但同一个节点上的其他子命令不合法，整个节点上的批量没有写入
+var ErrTxAborted = errors.New("transaction aborted by another command on the node")
+
+// Tx 收集一组 set 和 del，Exec 时一起执行，详见 Multi
+type Tx struct {
+	hc       *HuaHuoLsmClient
+	commands []txCommand
+}
+
+// txCommand 是 Tx 中的一个子命令，del 的值为 nil
+type txCommand struct {
+	command string
+	key     string
+	value   []byte
+}
+
+// Multi 开始一组写入（MULTI/EXEC）：在返回的 Tx 上调用 Set 和 Del，最后调用 Exec 发送。
+//
+// 原子性只在节点内：落在同一个节点上的子命令作为一个批量在一次 WAL 写入中全有或全无地写入
+// （节点有多个分片时只在每个分片内原子，与 MSet 相同），有一个不合法时这个节点上的子命令都不写入。
+// 不同节点之间没有原子性，某个节点失败或拒绝时其他节点上的写入不会回滚。
+// 需要整组原子时，让这些键路由到同一个节点，例如用 UseRangeRing 把它们放进同一段范围
+func (hc *HuaHuoLsmClient) Multi() *Tx {
+	return &Tx{hc: hc}
+}
+
+// Set 在 Tx 中加入一个写入
+func (tx *Tx) Set(key string, value []byte) *Tx {
+	tx.commands = append(tx.commands, txCommand{command: SET_KEY, key: key, value: value})
+	return tx
+}
+
+// Del 在 Tx 中加入一个删除
+func (tx *Tx) Del(key string) *Tx {
+	tx.commands = append(tx.commands, txCommand{command: DEL_KEY, key: key})
+	return tx
+}
+
+// Exec 执行 Tx 中的子命令，返回每个子命令的结果（与加入的顺序相同），成功的为 nil。
+// 子命令按路由分到各自的节点，每个节点只发送一个 exec 命令，不同节点同时执行。
+// 不合法的子命令返回节点的错误信息，同一个节点上其余的子命令返回 ErrTxAborted；
+// 路由失败、离线或请求失败的节点上的所有子命令失败。这些错误同时合并在第二个返回值中
+func (tx *Tx) Exec() ([]error, error) {
+	hc := tx.hc
+	results := make([]error, len(tx.commands))
+	var errs []error
+	buckets := make(map[*Client][]int)
+	for i, cmd := range tx.commands {
+		hc.invalidate(cmd.key)
+		c, err := hc.route(cmd.key)
+		if err != nil {
+			results[i] = err
+			errs = append(errs, fmt.Errorf("key %s: %w", cmd.key, err))
+			continue
+		}
+		buckets[c] = append(buckets[c], i)
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for c, indexes := range buckets {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			commands := make([]txCommand, len(indexes))
+			for j, i := range indexes {
+				commands[j] = tx.commands[i]
+			}
+			cmdErrs, err := c.exec(commands, hc.nextTraceID())
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				errs = append(errs, fmt.Errorf("node %s:%d: %w", c.ServerAddr, c.ServerPort, err))
+			}
+			for j, i := range indexes {
+				if cmdErrs != nil {
+					results[i] = cmdErrs[j]
+				} else {
+					results[i] = err
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	return results, errors.Join(errs...)
+}
+
+// exec 在一个节点上执行 commands，返回每个子命令的错误。
+// 节点拒绝了批量时同时返回每个子命令的错误和一个说明拒绝的错误
+func (c *Client) exec(commands []txCommand, traceID string) ([]error, error) {
+	value := new(bytes.Buffer)
+	for _, cmd := range commands {
+		for _, field := range [][]byte{[]byte(cmd.command), []byte(cmd.key), cmd.value} {
+			if err := writeBytes(value, field); err != nil {
+				return nil, err
+			}
+		}
+	}
+	request := &Bluebell{
+		Command: EXEC_KEY,
+		Value:   value.Bytes(),
+		TraceID: traceID,
+	}
+
+	res, err := c.exchange(request, 5*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	if res.Code != SUCCESS {
+		return nil, errors.New(string(res.Result))
+	}
+	if len(res.Result) == 0 {
+		return nil, errors.New("invalid exec response: empty result")
+	}
+
+	applied := res.Result[0] == 1
+	cmdErrs := make([]error, len(commands))
+	buf := bytes.NewReader(res.Result[1:])
+	for i := range commands {
+		msg, err := readBytes(buf)
+		if err != nil {
+			return nil, fmt.Errorf("invalid exec response: %w", err)
+		}
+		switch {
+		case len(msg) > 0:
+			cmdErrs[i] = errors.New(string(msg))
+		case !applied:
+			cmdErrs[i] = ErrTxAborted
+		}
+	}
+	if !applied {
+		return cmdErrs, errors.New("transaction rejected because of invalid commands")
+	}
+	return cmdErrs, nil
+}
@@ -0,0 +1,82 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestMultiExec(t *testing.T) {
+	LsmCliInit()
+
+	nodes := make(map[string]*fakeNode)
+	for i := 0; i < 2; i++ {
+		n := startFakeNode(t)
+		go n.serve()
+		addr := n.listener.Addr().String()
+		if err := HuaHuoLsmCli.addNode(addr); err != nil {
+			t.Fatal(err)
+		}
+		defer HuaHuoLsmCli.removeNode(addr)
+		nodes[addr] = n
+	}
+
+	// 选出都落在同一个节点上的键
+	var keys []string
+	var owner string
+	for i := 0; len(keys) < 4; i++ {
+		key := fmt.Sprintf("exec%d", i)
+		addr, err := GetRing().Get(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if owner == "" {
+			owner = addr
+		}
+		if addr == owner {
+			keys = append(keys, key)
+		}
+	}
+	n := nodes[owner]
+	n.values[keys[3]] = []byte("old")
+
+	// 有一个不合法的子命令时节点上的批量都不写入
+	results, err := HuaHuoLsmCli.Multi().Set(keys[0], []byte("v0")).Set(keys[1], nil).Del(keys[3]).Exec()
+	if err == nil {
+		t.Fatal("expected the transaction to be rejected")
+	}
+	if !errors.Is(results[0], ErrTxAborted) || results[1] == nil || errors.Is(results[1], ErrTxAborted) || !errors.Is(results[2], ErrTxAborted) {
+		t.Fatalf("unexpected results %v", results)
+	}
+	if _, ok := n.values[keys[0]]; ok || string(n.values[keys[3]]) != "old" {
+		t.Fatalf("expected nothing to be written, but got %q", n.values)
+	}
+
+	results, err = HuaHuoLsmCli.Multi().Set(keys[0], []byte("v0")).Set(keys[1], []byte("v1")).Set(keys[2], []byte("v2")).Del(keys[3]).Exec()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, err := range results {
+		if err != nil {
+			t.Fatalf("expected command %d to succeed, but got %v", i, err)
+		}
+	}
+	for i, key := range keys[:3] {
+		if want := fmt.Sprintf("v%d", i); string(n.values[key]) != want {
+			t.Fatalf("expected %s=%s, but got %q", key, want, n.values[key])
+		}
+	}
+	if _, ok := n.values[keys[3]]; ok {
+		t.Fatalf("expected %s to be deleted", keys[3])
+	}
+	// 所有子命令都在一个 exec 中发送
+	for addr, node := range nodes {
+		want := int32(0)
+		if addr == owner {
+			want = 2
+		}
+		if got := node.execs.Load(); got != want {
+			t.Fatalf("expected node %s to receive %d exec, but got %d", addr, want, got)
+		}
+	}
+}
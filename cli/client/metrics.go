@@ -0,0 +1,123 @@
+package client
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// numLatencyBounds 是延迟直方图中有上界的桶的数量
+const numLatencyBounds = 17
+
+// latencyBounds 是延迟直方图各个桶的上界，从 100 微秒开始每个桶翻倍（最大约 6.5 秒），最后一个桶之外还有一个没有上界的桶
+var latencyBounds = func() []time.Duration {
+	bounds := make([]time.Duration, numLatencyBounds)
+	for i := range bounds {
+		bounds[i] = 100 * time.Microsecond << i
+	}
+	return bounds
+}()
+
+// 记录延迟的操作
+const (
+	opGet = iota
+	opSet
+	opDelete
+	numLatencyOps
+)
+
+// latencyHistogram 用原子计数记录一个节点上一种操作的延迟，并发的请求记录时不需要加锁
+type latencyHistogram struct {
+	counts [numLatencyBounds + 1]atomic.Int64
+	sum    atomic.Int64
+}
+
+func (h *latencyHistogram) record(d time.Duration) {
+	bucket := len(latencyBounds)
+	for i, bound := range latencyBounds {
+		if d <= bound {
+			bucket = i
+			break
+		}
+	}
+	h.counts[bucket].Add(1)
+	h.sum.Add(int64(d))
+}
+
+func (h *latencyHistogram) snapshot() LatencyHistogram {
+	s := LatencyHistogram{Bounds: latencyBounds, Counts: make([]int64, len(h.counts))}
+	for i := range h.counts {
+		s.Counts[i] = h.counts[i].Load()
+		s.Count += s.Counts[i]
+	}
+	s.Sum = time.Duration(h.sum.Load())
+	return s
+}
+
+// observe 记录从 start 开始的一次操作的延迟，成功和失败的请求都计入
+func (c *Client) observe(op int, start time.Time) {
+	c.latency[op].record(time.Since(start))
+}
+
+// LatencyHistogram 是一种操作的延迟分布
+type LatencyHistogram struct {
+	// 各个桶的上界，Counts[i] 是延迟不超过 Bounds[i]（且超过前一个上界）的请求数量，
+	// Counts 比 Bounds 多一个元素，最后一个是超过所有上界的请求数量
+	Bounds []time.Duration
+	Counts []int64
+	// 请求总数和总延迟
+	Count int64
+	Sum   time.Duration
+}
+
+// Quantile 返回分位数 q（0 到 1 之间）所在的桶的上界，是该分位数延迟的上限估计；
+// 落在最后一个没有上界的桶时返回最大的上界，没有请求时返回 0
+func (h LatencyHistogram) Quantile(q float64) time.Duration {
+	if h.Count == 0 {
+		return 0
+	}
+	rank := int64(q * float64(h.Count))
+	if rank < 1 {
+		rank = 1
+	}
+	var seen int64
+	for i, count := range h.Counts {
+		seen += count
+		if seen >= rank && i < len(h.Bounds) {
+			return h.Bounds[i]
+		}
+	}
+	return h.Bounds[len(h.Bounds)-1]
+}
+
+// Mean 返回平均延迟，没有请求时返回 0
+func (h LatencyHistogram) Mean() time.Duration {
+	if h.Count == 0 {
+		return 0
+	}
+	return h.Sum / time.Duration(h.Count)
+}
+
+// NodeMetrics 是客户端发往一个节点的请求的延迟
+type NodeMetrics struct {
+	Get    LatencyHistogram
+	Set    LatencyHistogram
+	Delete LatencyHistogram
+}
+
+// Metrics 返回自连接以来发往每个已知节点的 Get、Set 和 Delete 的延迟直方图，键是节点地址。
+// 延迟从发送请求开始到收到响应或超时为止，本地缓存命中的 Get 不计入；比较各节点的分位数可以找出拖慢 p99 的节点。
+// 节点被移除后重新加入时从零开始统计。返回的是调用时的快照，可以与请求并发调用
+func (hc *HuaHuoLsmClient) Metrics() map[string]NodeMetrics {
+	clientsMu.RLock()
+	defer clientsMu.RUnlock()
+
+	metrics := make(map[string]NodeMetrics, len(hc.Clients))
+	for addr, c := range hc.Clients {
+		metrics[addr] = NodeMetrics{
+			Get:    c.latency[opGet].snapshot(),
+			Set:    c.latency[opSet].snapshot(),
+			Delete: c.latency[opDelete].snapshot(),
+		}
+	}
+	return metrics
+}
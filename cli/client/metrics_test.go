@@ -0,0 +1,87 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestMetrics(t *testing.T) {
+	LsmCliInit()
+	defer HuaHuoLsmCli.UseRangeRing(nil)
+
+	// 第二个节点每个请求慢 20 毫秒
+	var addrs []string
+	for i := 0; i < 2; i++ {
+		n := startFakeNode(t)
+		n.delay = time.Duration(i) * 20 * time.Millisecond
+		go n.serve()
+		addr := n.listener.Addr().String()
+		if err := HuaHuoLsmCli.addNode(addr); err != nil {
+			t.Fatal(err)
+		}
+		defer HuaHuoLsmCli.removeNode(addr)
+		addrs = append(addrs, addr)
+	}
+	ring := NewRangeRing()
+	ring.Assign("", addrs[0])
+	ring.Assign("m", addrs[1])
+	HuaHuoLsmCli.UseRangeRing(ring)
+
+	// 第一个节点负责 a 开头的键，第二个节点负责 z 开头的键
+	for i := 0; i < 5; i++ {
+		for _, prefix := range []string{"a", "z"} {
+			key := fmt.Sprintf("%s%d", prefix, i)
+			if err := HuaHuoLsmCli.Set(key, []byte("v")); err != nil {
+				t.Fatal(err)
+			}
+			if _, err := HuaHuoLsmCli.Get(key); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	if err := HuaHuoLsmCli.Delete("a0"); err != nil {
+		t.Fatal(err)
+	}
+	// 不存在的键同样计入
+	if _, err := HuaHuoLsmCli.Get("a0"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected ErrNotFound, but got %v", err)
+	}
+
+	metrics := HuaHuoLsmCli.Metrics()
+	fast, slow := metrics[addrs[0]], metrics[addrs[1]]
+	for _, c := range []struct {
+		name string
+		got  LatencyHistogram
+		want int64
+	}{
+		{"fast get", fast.Get, 6},
+		{"fast set", fast.Set, 5},
+		{"fast delete", fast.Delete, 1},
+		{"slow get", slow.Get, 5},
+		{"slow set", slow.Set, 5},
+		{"slow delete", slow.Delete, 0},
+	} {
+		if c.got.Count != c.want {
+			t.Fatalf("%s: expected %d requests, but got %d", c.name, c.want, c.got.Count)
+		}
+		var total int64
+		for _, count := range c.got.Counts {
+			total += count
+		}
+		if total != c.got.Count {
+			t.Fatalf("%s: expected the buckets to add up to %d, but got %d", c.name, c.got.Count, total)
+		}
+	}
+
+	if p99 := slow.Get.Quantile(0.99); p99 < 20*time.Millisecond {
+		t.Fatalf("expected the slow node's p99 to be at least 20ms, but got %s", p99)
+	}
+	if mean := slow.Set.Mean(); mean < 20*time.Millisecond {
+		t.Fatalf("expected the slow node's mean to be at least 20ms, but got %s", mean)
+	}
+	if p99 := fast.Get.Quantile(0.99); p99 >= 20*time.Millisecond {
+		t.Fatalf("expected the fast node's p99 to be below 20ms, but got %s", p99)
+	}
+}
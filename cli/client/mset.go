@@ -0,0 +1,97 @@
+package client
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// MSet 写入多个键值对，返回每个键的结果，写入成功的键为 nil。
+// 键值对按哈希环分到各自的节点，每个节点只发送一个 mset 命令，不同节点同时写入。
+//
+// 原子性只在节点内：一个节点上的键值对在一次 WAL 写入中原子地写入（节点有多个分片时只在每个分片内原子），
+// 不同节点之间没有原子性，某个节点失败时其他节点上的写入不会回滚。
+// 键或值不合法的键值对只有该键失败；路由失败、离线或请求失败的节点上的所有键失败，
+// 这些错误同时合并在第二个返回值中，调用方可以只重试失败的键
+func (hc *HuaHuoLsmClient) MSet(pairs map[string][]byte) (map[string]error, error) {
+	results := make(map[string]error, len(pairs))
+	var errs []error
+	buckets := make(map[*Client][]string)
+	for key := range pairs {
+		hc.invalidate(key)
+		c, err := hc.route(key)
+		if err != nil {
+			results[key] = err
+			errs = append(errs, fmt.Errorf("key %s: %w", key, err))
+			continue
+		}
+		buckets[c] = append(buckets[c], key)
+	}
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for c, keys := range buckets {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			keyErrs, err := c.mset(keys, pairs, hc.nextTraceID())
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				errs = append(errs, fmt.Errorf("node %s:%d: %w", c.ServerAddr, c.ServerPort, err))
+			}
+			for i, key := range keys {
+				if err != nil {
+					results[key] = err
+				} else {
+					results[key] = keyErrs[i]
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	return results, errors.Join(errs...)
+}
+
+// mset 在一个节点上写入 keys 对应的键值对，返回每个键的错误
+func (c *Client) mset(keys []string, pairs map[string][]byte, traceID string) ([]error, error) {
+	value := new(bytes.Buffer)
+	for _, key := range keys {
+		if err := writeBytes(value, []byte(key)); err != nil {
+			return nil, err
+		}
+		if err := writeBytes(value, pairs[key]); err != nil {
+			return nil, err
+		}
+	}
+	request := &Bluebell{
+		Command: MSET_KEY,
+		Value:   value.Bytes(),
+		TraceID: traceID,
+	}
+
+	res, err := c.exchange(request, 5*time.Second)
+	if err != nil {
+		return nil, err
+	}
+	if res.Code != SUCCESS {
+		return nil, errors.New(string(res.Result))
+	}
+
+	keyErrs := make([]error, len(keys))
+	buf := bytes.NewReader(res.Result)
+	for i := range keys {
+		msg, err := readBytes(buf)
+		if err != nil {
+			return nil, fmt.Errorf("invalid mset response: %w", err)
+		}
+		if len(msg) > 0 {
+			keyErrs[i] = errors.New(string(msg))
+		}
+	}
+	return keyErrs, nil
+}
@@ -0,0 +1,74 @@
+package client
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestMSet(t *testing.T) {
+	LsmCliInit()
+
+	nodes := make(map[string]*fakeNode)
+	for i := 0; i < 2; i++ {
+		n := startFakeNode(t)
+		go n.serve()
+		addr := n.listener.Addr().String()
+		if err := HuaHuoLsmCli.addNode(addr); err != nil {
+			t.Fatal(err)
+		}
+		defer HuaHuoLsmCli.removeNode(addr)
+		nodes[addr] = n
+	}
+
+	// 选出落在两个节点上的键
+	pairs := make(map[string][]byte)
+	owners := make(map[string]string)
+	for i := 0; len(pairs) < 6 || len(distinct(owners)) < 2; i++ {
+		key := fmt.Sprintf("mset%d", i)
+		addr, err := GetRing().Get(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pairs[key] = []byte("v" + key)
+		owners[key] = addr
+	}
+	pairs["empty"] = nil
+	owners["empty"], _ = GetRing().Get("empty")
+
+	results, err := HuaHuoLsmCli.MSet(pairs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != len(pairs) {
+		t.Fatalf("expected %d results, but got %d", len(pairs), len(results))
+	}
+	for key, value := range pairs {
+		n := nodes[owners[key]]
+		if key == "empty" {
+			if results[key] == nil {
+				t.Fatal("expected the empty value to fail")
+			}
+			continue
+		}
+		if results[key] != nil {
+			t.Fatalf("expected %s to succeed, but got %v", key, results[key])
+		}
+		if string(n.values[key]) != string(value) {
+			t.Fatalf("expected %s=%s on node %s, but got %q", key, value, owners[key], n.values[key])
+		}
+	}
+	// 每个节点只收到一个 mset
+	for addr, n := range nodes {
+		if got := n.msets.Load(); got != 1 {
+			t.Fatalf("expected node %s to receive 1 mset, but got %d", addr, got)
+		}
+	}
+}
+
+func distinct(owners map[string]string) map[string]bool {
+	set := make(map[string]bool)
+	for _, addr := range owners {
+		set[addr] = true
+	}
+	return set
+}
@@ -0,0 +1,179 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// clientsMu 保护 HuaHuoLsmCli.Clients 和全局哈希环的成员，WatchIPChanges 在后台协程中并发地增删节点
+var clientsMu sync.RWMutex
+
+// NodeInfo 描述客户端已知的一个节点
+type NodeInfo struct {
+	// 节点地址，形如 host:port
+	Addr string
+	// 节点在哈希环上占据的虚拟节点数量
+	Weight int
+	// 连接是否可用
+	Status bool
+	// 最近一次连接成功或收到响应的时间，从未成功时为零值
+	LastHealthy time.Time
+}
+
+// Nodes 返回客户端已知的所有节点及其状态，按地址排序。
+// 返回的是调用时的快照，可以与 WatchIPChanges 并发调用
+func (hc *HuaHuoLsmClient) Nodes() []NodeInfo {
+	clientsMu.RLock()
+	defer clientsMu.RUnlock()
+
+	weights := GetRing().weights()
+	nodes := make([]NodeInfo, 0, len(hc.Clients))
+	for addr, c := range hc.Clients {
+		nodes = append(nodes, NodeInfo{
+			Addr:        addr,
+			Weight:      weights[addr],
+			Status:      c.Status.Load(),
+			LastHealthy: c.LastHealthy(),
+		})
+	}
+	sort.Slice(nodes, func(i, j int) bool {
+		return nodes[i].Addr < nodes[j].Addr
+	})
+	return nodes
+}
+
+// addNode 连接地址为 ip 的节点并以全部权重把它加入哈希环
+func (hc *HuaHuoLsmClient) addNode(ip string) error {
+	return hc.addWeightedNode(ip, FULL_WEIGHT)
+}
+
+// addWeightedNode 连接地址为 ip 的节点并以 weight 为权重把它加入哈希环。
+// 节点的连接仍然可用时只更新权重（节点预热完成后重新注册了权重），否则重新连接（节点重启后重新注册）
+func (hc *HuaHuoLsmClient) addWeightedNode(ip string, weight int) error {
+	clientsMu.Lock()
+	if c, ok := hc.Clients[ip]; ok && c.Status.Load() {
+		GetRing().SetWeight(ip, weight)
+		clientsMu.Unlock()
+		return nil
+	}
+	clientsMu.Unlock()
+
+	parts := strings.Split(ip, ":")
+	if len(parts) != 2 {
+		return fmt.Errorf("invalid node address %q", ip)
+	}
+	port, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return fmt.Errorf("invalid node address %q: %w", ip, err)
+	}
+
+	// 建立连接可能较慢，不在持有锁时进行
+	clientsMu.RLock()
+	c := New(parts[0], port)
+	clientsMu.RUnlock()
+	// 连接失败的节点不加入哈希环，否则路由到它的请求都会失败；注册中心下次通知时再重试
+	if err := c.Start(); err != nil {
+		return fmt.Errorf("failed to add node %s: %w", ip, err)
+	}
+
+	clientsMu.Lock()
+	defer clientsMu.Unlock()
+	hc.Clients[ip] = c
+	GetRing().SetWeight(ip, weight)
+	GetRing().Add(ip)
+	return nil
+}
+
+// removeNode 把地址为 ip 的节点移出哈希环并关闭它的连接
+func (hc *HuaHuoLsmClient) removeNode(ip string) {
+	clientsMu.Lock()
+	GetRing().Remove(ip)
+	c := hc.Clients[ip]
+	delete(hc.Clients, ip)
+	clientsMu.Unlock()
+
+	if c != nil {
+		c.Close()
+	}
+}
+
+// onlineClients 返回所有连接可用的节点
+func (hc *HuaHuoLsmClient) onlineClients() []*Client {
+	clientsMu.RLock()
+	defer clientsMu.RUnlock()
+
+	clients := make([]*Client, 0, len(hc.Clients))
+	for _, c := range hc.Clients {
+		if c.Status.Load() {
+			clients = append(clients, c)
+		}
+	}
+	return clients
+}
+
+// UseRangeRing 让客户端按 r 的键范围而不是全局哈希环路由，r 为 nil 时恢复使用哈希环。
+// 读写发送给 r.Get 返回的节点，ScanAll 和 ScanPrefixAll 只发送给 r.NodesForRange 返回的节点。
+// 节点的连接仍由 dispatcher 根据注册中心维护，r 中的节点必须是已连接的节点地址。
+// 切换路由方式不会迁移数据，应在写入任何数据之前设置
+func (hc *HuaHuoLsmClient) UseRangeRing(r *RangeRing) {
+	clientsMu.Lock()
+	defer clientsMu.Unlock()
+	hc.rangeRing = r
+}
+
+// scanClients 返回范围扫描 [start, end) 需要访问的节点：按范围路由时只有拥有这段范围的节点，否则是所有在线节点
+func (hc *HuaHuoLsmClient) scanClients(start, end []byte) ([]*Client, error) {
+	clientsMu.RLock()
+	r := hc.rangeRing
+	clientsMu.RUnlock()
+	if r == nil {
+		return hc.onlineClients(), nil
+	}
+
+	ips, err := r.NodesForRange(string(start), string(end))
+	if err != nil {
+		return nil, err
+	}
+
+	clientsMu.RLock()
+	defer clientsMu.RUnlock()
+	clients := make([]*Client, 0, len(ips))
+	for _, ip := range ips {
+		c := hc.Clients[ip]
+		if c == nil {
+			return nil, fmt.Errorf("node %q is not connected", ip)
+		}
+		clients = append(clients, c)
+	}
+	return clients, nil
+}
+
+// route 返回负责 key 的节点
+func (hc *HuaHuoLsmClient) route(key string) (*Client, error) {
+	clientsMu.RLock()
+	defer clientsMu.RUnlock()
+
+	var ip string
+	var err error
+	if hc.rangeRing != nil {
+		ip, err = hc.rangeRing.Get(key)
+	} else {
+		ip, err = GetRing().Get(key)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if ip == "" {
+		return nil, errors.New("no node available")
+	}
+	c := hc.Clients[ip]
+	if c == nil {
+		return nil, fmt.Errorf("node %q is not connected", ip)
+	}
+	return c, nil
+}
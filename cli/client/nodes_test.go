@@ -0,0 +1,102 @@
+package client
+
+import (
+	"testing"
+	"time"
+)
+
+func TestNodes(t *testing.T) {
+	LsmCliInit()
+
+	var addrs []string
+	for i := 0; i < 2; i++ {
+		n := startFakeNode(t)
+		go n.serve()
+		addrs = append(addrs, n.listener.Addr().String())
+	}
+	before := time.Now()
+	for _, addr := range addrs {
+		if err := HuaHuoLsmCli.addNode(addr); err != nil {
+			t.Fatal(err)
+		}
+		defer HuaHuoLsmCli.removeNode(addr)
+	}
+
+	nodes := HuaHuoLsmCli.Nodes()
+	if len(nodes) != 2 {
+		t.Fatalf("expected 2 nodes, but got %v", nodes)
+	}
+	for i, node := range nodes {
+		if i > 0 && nodes[i-1].Addr >= node.Addr {
+			t.Fatalf("expected nodes to be sorted by address, but got %v", nodes)
+		}
+		if node.Addr != addrs[0] && node.Addr != addrs[1] {
+			t.Fatalf("unexpected node %s", node.Addr)
+		}
+		if !node.Status {
+			t.Fatalf("expected node %s to be online", node.Addr)
+		}
+		if node.Weight == 0 {
+			t.Fatalf("expected node %s to have a weight", node.Addr)
+		}
+		if node.LastHealthy.Before(before) {
+			t.Fatalf("expected node %s to be healthy since %v, but got %v", node.Addr, before, node.LastHealthy)
+		}
+	}
+
+	HuaHuoLsmCli.removeNode(addrs[0])
+	nodes = HuaHuoLsmCli.Nodes()
+	if len(nodes) != 1 || nodes[0].Addr != addrs[1] {
+		t.Fatalf("expected only %s after removing %s, but got %v", addrs[1], addrs[0], nodes)
+	}
+	if node, err := GetRing().Get("key"); err != nil || node != addrs[1] {
+		t.Fatalf("expected key to route to %s, but got %s (%v)", addrs[1], node, err)
+	}
+}
+
+func TestNodesConcurrentWithMembershipChanges(t *testing.T) {
+	LsmCliInit()
+
+	n := startFakeNode(t)
+	addr := n.listener.Addr().String()
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 20; i++ {
+			go n.serve()
+			HuaHuoLsmCli.addNode(addr)
+			HuaHuoLsmCli.removeNode(addr)
+		}
+	}()
+
+	for {
+		select {
+		case <-done:
+			if nodes := HuaHuoLsmCli.Nodes(); len(nodes) != 0 {
+				t.Fatalf("expected no nodes, but got %v", nodes)
+			}
+			return
+		default:
+			HuaHuoLsmCli.Nodes()
+		}
+	}
+}
+
+func TestAddUnreachableNode(t *testing.T) {
+	LsmCliInit()
+
+	n := startFakeNode(t)
+	addr := n.listener.Addr().String()
+	n.listener.Close()
+
+	if err := HuaHuoLsmCli.addNode(addr); err == nil {
+		HuaHuoLsmCli.removeNode(addr)
+		t.Fatal("expected an error for an unreachable node")
+	}
+	if nodes := HuaHuoLsmCli.Nodes(); len(nodes) != 0 {
+		t.Fatalf("expected the unreachable node not to be added, but got %v", nodes)
+	}
+	if _, ok := GetRing().weights()[addr]; ok {
+		t.Fatal("expected the unreachable node not to be on the ring")
+	}
+}
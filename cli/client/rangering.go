@@ -0,0 +1,121 @@
+package client
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// RangeRing 按连续的键范围把键分配给节点，是 HashRing 之外的另一种路由方式。
+// 哈希环把相邻的键打散到所有节点上，范围扫描只能发送给每个节点；
+// 按范围分配时一个范围扫描只需要访问拥有这段范围的节点。
+// 代价是负载取决于键的分布，热点范围需要调用方自己拆分。
+// 每个范围由起点标识，包含从起点开始、到下一个范围起点之前的所有键，最后一个范围没有上界。
+// 可以被多个 goroutine 并发调用
+type RangeRing struct {
+	mu     sync.RWMutex
+	starts []string // 按升序排列的范围起点
+	nodes  []string // nodes[i] 拥有从 starts[i] 开始的范围
+}
+
+// NewRangeRing 创建一个没有任何范围的 RangeRing
+func NewRangeRing() *RangeRing {
+	return &RangeRing{}
+}
+
+// Assign 把从 start 开始的范围分配给 node，start 已经是某个范围的起点时替换它的节点。
+// 小于所有起点的键不属于任何节点，通常第一个范围的起点是空字符串
+func (r *RangeRing) Assign(start, node string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	i := sort.SearchStrings(r.starts, start)
+	if i < len(r.starts) && r.starts[i] == start {
+		r.nodes[i] = node
+		return
+	}
+	r.starts = append(r.starts, "")
+	r.nodes = append(r.nodes, "")
+	copy(r.starts[i+1:], r.starts[i:])
+	copy(r.nodes[i+1:], r.nodes[i:])
+	r.starts[i], r.nodes[i] = start, node
+}
+
+// Remove 移除 node 拥有的所有范围，它们并入前一个范围；
+// 被移除的是第一个范围时，之后的第一个范围向前扩展到原来的起点
+func (r *RangeRing) Remove(node string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if len(r.starts) == 0 {
+		return
+	}
+	first := r.starts[0]
+	starts := make([]string, 0, len(r.starts))
+	nodes := make([]string, 0, len(r.nodes))
+	for i, n := range r.nodes {
+		if n != node {
+			starts = append(starts, r.starts[i])
+			nodes = append(nodes, n)
+		}
+	}
+	if len(starts) > 0 {
+		starts[0] = first
+	}
+	r.starts, r.nodes = starts, nodes
+}
+
+// Get 返回拥有 key 的节点，没有任何范围时返回空字符串，与 HashRing.Get 一致
+func (r *RangeRing) Get(key string) (string, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if len(r.starts) == 0 {
+		return "", nil
+	}
+	i, err := r.find(key)
+	if err != nil {
+		return "", err
+	}
+	return r.nodes[i], nil
+}
+
+// NodesForRange 返回拥有 [start, end) 范围内的键的节点，按键的顺序排列，每个节点只出现一次。
+// end 为空表示不限制，start 不小于非空的 end 时范围为空，返回 nil
+func (r *RangeRing) NodesForRange(start, end string) ([]string, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	if len(r.starts) == 0 || end != "" && start >= end {
+		return nil, nil
+	}
+	i, err := r.find(start)
+	if err != nil {
+		return nil, err
+	}
+
+	var nodes []string
+	seen := make(map[string]bool)
+	// 包含 start 的范围总是需要，之后的范围起点小于 end 时才需要
+	for j := i; j < len(r.starts); j++ {
+		if j > i && end != "" && r.starts[j] >= end {
+			break
+		}
+		if !seen[r.nodes[j]] {
+			seen[r.nodes[j]] = true
+			nodes = append(nodes, r.nodes[j])
+		}
+	}
+	return nodes, nil
+}
+
+// find 返回包含 key 的范围的下标，即最后一个不大于 key 的起点
+func (r *RangeRing) find(key string) (int, error) {
+	i := sort.Search(len(r.starts), func(i int) bool {
+		return r.starts[i] > key
+	})
+	if i == 0 {
+		return 0, fmt.Errorf("no node owns key %q", key)
+	}
+	return i - 1, nil
+}
@@ -0,0 +1,88 @@
+package client
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestRangeRing(t *testing.T) {
+	ring := NewRangeRing()
+	if node, err := ring.Get("key"); err != nil || node != "" {
+		t.Fatalf("expected no node for an empty ring, but got %q (%v)", node, err)
+	}
+
+	ring.Assign("m", "node2")
+	ring.Assign("", "node1")
+	ring.Assign("t", "node3")
+	ring.Assign("x", "node1")
+
+	for key, want := range map[string]string{
+		"":   "node1",
+		"a":  "node1",
+		"l~": "node1",
+		"m":  "node2",
+		"s":  "node2",
+		"t":  "node3",
+		"wz": "node3",
+		"x":  "node1",
+		"zz": "node1",
+	} {
+		if node, err := ring.Get(key); err != nil || node != want {
+			t.Fatalf("expected %s for %q, but got %q (%v)", want, key, node, err)
+		}
+	}
+
+	for _, tc := range []struct {
+		start, end string
+		want       string
+	}{
+		{"a", "b", "[node1]"},
+		{"a", "m", "[node1]"},
+		{"a", "m\x00", "[node1 node2]"},
+		{"n", "u", "[node2 node3]"},
+		{"n", "", "[node2 node3 node1]"},
+		{"", "", "[node1 node2 node3]"},
+		{"u", "u", "[]"},
+		{"z", "a", "[]"},
+	} {
+		nodes, err := ring.NodesForRange(tc.start, tc.end)
+		if err != nil || fmt.Sprint(nodes) != tc.want {
+			t.Fatalf("expected %s for [%q, %q), but got %v (%v)", tc.want, tc.start, tc.end, nodes, err)
+		}
+	}
+
+	// 替换范围的节点
+	ring.Assign("t", "node4")
+	if node, _ := ring.Get("u"); node != "node4" {
+		t.Fatalf("expected node4, but got %s", node)
+	}
+
+	// 移除的范围并入前一个范围
+	ring.Remove("node4")
+	if node, _ := ring.Get("u"); node != "node2" {
+		t.Fatalf("expected node2 after removing node4, but got %s", node)
+	}
+
+	// 移除第一个范围后，下一个范围向前扩展
+	ring.Remove("node1")
+	for _, key := range []string{"", "a", "zz"} {
+		if node, err := ring.Get(key); err != nil || node != "node2" {
+			t.Fatalf("expected node2 for %q after removing node1, but got %q (%v)", key, node, err)
+		}
+	}
+}
+
+func TestRangeRingUnownedKeys(t *testing.T) {
+	ring := NewRangeRing()
+	ring.Assign("m", "node1")
+
+	if node, err := ring.Get("a"); err == nil {
+		t.Fatalf("expected an error for a key below the first range, but got %s", node)
+	}
+	if nodes, err := ring.NodesForRange("a", "z"); err == nil {
+		t.Fatalf("expected an error for a range below the first range, but got %v", nodes)
+	}
+	if nodes, err := ring.NodesForRange("m", "z"); err != nil || fmt.Sprint(nodes) != "[node1]" {
+		t.Fatalf("expected [node1], but got %v (%v)", nodes, err)
+	}
+}
@@ -0,0 +1,76 @@
+package client
+
+import (
+	"container/list"
+	"sync"
+)
+
+// routeCache 是 key 到节点的 LRU 缓存，避免热点 key 每次请求都计算 MD5 并二分查找哈希环。
+// 每个条目记录写入时哈希环的代数，哈希环拓扑变化后代数增加，旧条目在查找时被视为未命中。
+type routeCache struct {
+	mu       sync.Mutex
+	capacity int
+	ll       *list.List
+	items    map[string]*list.Element
+}
+
+type routeEntry struct {
+	key        string
+	node       string
+	generation uint64
+}
+
+func newRouteCache(capacity int) *routeCache {
+	return &routeCache{
+		capacity: capacity,
+		ll:       list.New(),
+		items:    make(map[string]*list.Element),
+	}
+}
+
+// get 返回 key 在代数 generation 下缓存的节点
+func (c *routeCache) get(key string, generation uint64) (string, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.items[key]
+	if !ok {
+		return "", false
+	}
+	entry := elem.Value.(*routeEntry)
+	if entry.generation != generation {
+		// 哈希环已经变化，缓存的节点可能不再正确
+		c.ll.Remove(elem)
+		delete(c.items, key)
+		return "", false
+	}
+	c.ll.MoveToFront(elem)
+	return entry.node, true
+}
+
+// add 缓存 key 在代数 generation 下对应的节点，超出容量时淘汰最久未使用的条目
+func (c *routeCache) add(key, node string, generation uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if elem, ok := c.items[key]; ok {
+		entry := elem.Value.(*routeEntry)
+		entry.node, entry.generation = node, generation
+		c.ll.MoveToFront(elem)
+		return
+	}
+
+	c.items[key] = c.ll.PushFront(&routeEntry{key: key, node: node, generation: generation})
+	if c.ll.Len() > c.capacity {
+		oldest := c.ll.Back()
+		c.ll.Remove(oldest)
+		delete(c.items, oldest.Value.(*routeEntry).key)
+	}
+}
+
+// len 返回缓存的条目数
+func (c *routeCache) len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.ll.Len()
+}
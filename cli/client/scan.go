@@ -0,0 +1,250 @@
+package client
+
+import (
+	"bytes"
+	"container/heap"
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// kv 是节点 Scan 返回的一个键值对
+type kv struct {
+	key   []byte
+	value []byte
+}
+
+// ScanAll 在整个集群上遍历 [start, end) 范围内的键值对，按键全局升序返回，空的边界表示不限制。
+// 哈希环打散了键的顺序，因此 ScanAll 把范围扫描并发发送给每个节点，
+// 依赖每个节点的 Scan 命令返回本节点按键排序的子集，再用堆把它们归并成全局有序的结果。
+// 重新平衡期间同一个键可能出现在多个节点上，此时只返回其中一个节点的值。
+// 通过 UseRangeRing 按范围路由时只发送给拥有这段范围的节点。
+func (hc *HuaHuoLsmClient) ScanAll(start, end []byte) (*ScanIterator, error) {
+	clients, err := hc.scanClients(start, end)
+	if err != nil {
+		return nil, err
+	}
+	return hc.fanOut(clients, func(c *Client) ([]kv, error) {
+		return c.scan(start, end, hc.nextTraceID())
+	})
+}
+
+// ScanPrefixAll 在整个集群上遍历以 prefix 开头的键，按键全局升序返回最多 limit 个（limit <= 0 表示不限制）。
+// 同一前缀的键被哈希环分散到各个节点，因此与 ScanAll 一样把请求并发发送给每个节点：
+// 每个节点最多返回本节点排序后的前 limit 个键，全局的前 limit 个键一定在这些结果中，
+// 归并后再截断到 limit 个。按范围路由时只发送给拥有这个前缀的节点。
+func (hc *HuaHuoLsmClient) ScanPrefixAll(prefix []byte, limit int) (*ScanIterator, error) {
+	clients, err := hc.scanClients(prefix, prefixEnd(prefix))
+	if err != nil {
+		return nil, err
+	}
+	it, err := hc.fanOut(clients, func(c *Client) ([]kv, error) {
+		return c.scanPrefix(prefix, limit, hc.nextTraceID())
+	})
+	if err != nil {
+		return nil, err
+	}
+	it.limit = limit
+	return it, nil
+}
+
+// prefixEnd 返回大于所有以 prefix 开头的键的最小键，prefix 为空或全部由 0xff 组成时返回 nil（不限制）
+func prefixEnd(prefix []byte) []byte {
+	for i := len(prefix) - 1; i >= 0; i-- {
+		if prefix[i] != 0xff {
+			end := make([]byte, i+1)
+			copy(end, prefix)
+			end[i]++
+			return end
+		}
+	}
+	return nil
+}
+
+// GetAll 返回整个集群的全部键值对，按键全局升序排列，每个键只出现一次，只适用于小数据集。
+// 与 ScanAll 一样把请求并发发送给每个节点并归并结果；任一节点的数据量超过节点的上限时返回错误。
+func (hc *HuaHuoLsmClient) GetAll() (*ScanIterator, error) {
+	return hc.fanOut(hc.onlineClients(), func(c *Client) ([]kv, error) {
+		return c.getAll(hc.nextTraceID())
+	})
+}
+
+// fanOut 在 clients 的每个节点上并发执行 scan，把各节点的有序结果归并成一个迭代器，任一节点失败时返回错误。
+func (hc *HuaHuoLsmClient) fanOut(clients []*Client, scan func(c *Client) ([]kv, error)) (*ScanIterator, error) {
+	results := make([][]kv, len(clients))
+	errs := make([]error, len(clients))
+	var wg sync.WaitGroup
+	for i, c := range clients {
+		wg.Add(1)
+		go func(i int, c *Client) {
+			defer wg.Done()
+			results[i], errs[i] = scan(c)
+		}(i, c)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+	return newScanIterator(results), nil
+}
+
+func (c *Client) scan(start, end []byte, traceID string) ([]kv, error) {
+	request := &Bluebell{
+		Command: SCAN_KEY,
+		Key:     string(start),
+		Value:   end,
+		TraceID: traceID,
+	}
+
+	return c.requestKVs(request)
+}
+
+func (c *Client) scanPrefix(prefix []byte, limit int, traceID string) ([]kv, error) {
+	request := &Bluebell{
+		Command: SCANPREFIX_KEY,
+		Key:     string(prefix),
+		Value:   []byte(strconv.Itoa(limit)),
+		TraceID: traceID,
+	}
+
+	return c.requestKVs(request)
+}
+
+func (c *Client) getAll(traceID string) ([]kv, error) {
+	request := &Bluebell{
+		Command: GETALL_KEY,
+		TraceID: traceID,
+	}
+
+	return c.requestKVs(request)
+}
+
+// requestKVs 发送请求并解码响应中的键值对
+func (c *Client) requestKVs(request *Bluebell) ([]kv, error) {
+	res, err := c.exchange(request, 5*time.Second) // 等待响应，设置超时
+	if err != nil {
+		return nil, err
+	}
+	if res.Code != SUCCESS {
+		return nil, errors.New(string(res.Result))
+	}
+
+	return decodeKVs(res.Result)
+}
+
+// decodeKVs 解码 Scan 响应中依次排列的长度前缀的键和值
+func decodeKVs(data []byte) ([]kv, error) {
+	buf := bytes.NewReader(data)
+	var kvs []kv
+	for buf.Len() > 0 {
+		key, err := readBytes(buf)
+		if err != nil {
+			return nil, err
+		}
+		value, err := readBytes(buf)
+		if err != nil {
+			return nil, err
+		}
+		kvs = append(kvs, kv{key: key, value: value})
+	}
+	return kvs, nil
+}
+
+// ScanIterator 按键升序遍历 ScanAll 或 ScanPrefixAll 从各节点取回的有序结果。
+//
+//	it, err := HuaHuoLsmCli.ScanAll(start, end)
+//	...
+//	for it.Next() {
+//		key, value := it.Key(), it.Value()
+//	}
+type ScanIterator struct {
+	cursors cursorHeap
+	current kv
+	// 最多返回的键数量，0 表示不限制
+	limit int
+	count int
+}
+
+func newScanIterator(results [][]kv) *ScanIterator {
+	it := &ScanIterator{}
+	for _, kvs := range results {
+		if len(kvs) > 0 {
+			it.cursors = append(it.cursors, &cursor{kvs: kvs})
+		}
+	}
+	heap.Init(&it.cursors)
+	return it
+}
+
+// Next 前进到下一个键值对，没有更多键值对时返回 false
+func (it *ScanIterator) Next() bool {
+	if len(it.cursors) == 0 || it.limit > 0 && it.count >= it.limit {
+		return false
+	}
+	it.current = it.pop()
+	it.count++
+
+	// 跳过其他节点上相同的键
+	for len(it.cursors) > 0 && bytes.Equal(it.cursors[0].head().key, it.current.key) {
+		it.pop()
+	}
+	return true
+}
+
+// Key 返回当前的键
+func (it *ScanIterator) Key() []byte {
+	return it.current.key
+}
+
+// Value 返回当前的值
+func (it *ScanIterator) Value() []byte {
+	return it.current.value
+}
+
+// pop 取出堆顶游标的当前键值对并前进该游标
+func (it *ScanIterator) pop() kv {
+	c := it.cursors[0]
+	head := c.head()
+	c.pos++
+	if c.pos == len(c.kvs) {
+		heap.Pop(&it.cursors)
+	} else {
+		heap.Fix(&it.cursors, 0)
+	}
+	return head
+}
+
+// cursor 指向一个节点结果中的下一个键值对
+type cursor struct {
+	kvs []kv
+	pos int
+}
+
+func (c *cursor) head() kv {
+	return c.kvs[c.pos]
+}
+
+// cursorHeap 是按当前键排序的游标最小堆
+type cursorHeap []*cursor
+
+func (h cursorHeap) Len() int { return len(h) }
+func (h cursorHeap) Less(i, j int) bool {
+	return bytes.Compare(h[i].head().key, h[j].head().key) < 0
+}
+func (h cursorHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+
+func (h *cursorHeap) Push(x interface{}) {
+	*h = append(*h, x.(*cursor))
+}
+
+func (h *cursorHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	c := old[n-1]
+	*h = old[:n-1]
+	return c
+}
@@ -0,0 +1,589 @@
+package client
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sort"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/bytedance/sonic"
+)
+
+// fakeNode 是只支持 scan、scanprefix、getall、get、set、del、mset、exec、getorset、snapshot 和流式命令的节点，按服务端协议返回本节点排好序的键值对
+type fakeNode struct {
+	listener net.Listener
+	keys     []string
+	// getorset 写入的值
+	values map[string][]byte
+	// 收到的 scan 和 scanprefix 请求数量
+	scans atomic.Int32
+	// 收到的 get 请求数量
+	gets atomic.Int32
+	// 收到的 mset 请求数量
+	msets atomic.Int32
+	// 收到的 exec 请求数量
+	execs atomic.Int32
+	// scan 和 scanprefix 返回的键值对总数
+	sent atomic.Int64
+	// 响应每个请求之前等待的时间
+	delay time.Duration
+	// set 接受的最大值长度，为 0 时不限制
+	maxValue int
+}
+
+func startFakeNode(t *testing.T) *fakeNode {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	n := &fakeNode{listener: listener, values: make(map[string][]byte)}
+	t.Cleanup(func() { listener.Close() })
+	return n
+}
+
+func (n *fakeNode) serve() {
+	conn, err := n.listener.Accept()
+	if err != nil {
+		return
+	}
+	defer conn.Close()
+
+	// 连接上正在写入和读取的流
+	var setting, getting []byte
+	for {
+		var header [4]byte
+		if _, err := io.ReadFull(conn, header[:]); err != nil {
+			return
+		}
+		body := make([]byte, binary.BigEndian.Uint32(header[:]))
+		if _, err := io.ReadFull(conn, body); err != nil {
+			return
+		}
+		time.Sleep(n.delay)
+		buf := bytes.NewReader(body)
+		command, _ := readString(buf)
+		start, _ := readString(buf)
+		end, _ := readBytes(buf)
+		readString(buf)
+		traceID, _ := readString(buf)
+		readString(buf)
+		var flags uint32
+		binary.Read(buf, binary.BigEndian, &flags)
+
+		if command == GET_KEY {
+			n.gets.Add(1)
+			value, ok := n.values[start]
+			// 与服务端一致，不存在的键返回不带结果的错误响应
+			code := SUCCESS
+			if !ok {
+				code = "1"
+			}
+			if err := n.respondTrace(conn, code, value, traceID); err != nil {
+				return
+			}
+			continue
+		}
+
+		if command == SET_KEY {
+			// 与服务端一致，过大的值返回 VALUE_TOO_LARGE，空值返回带错误信息的失败响应
+			code, msg := SUCCESS, []byte(nil)
+			switch {
+			case n.maxValue > 0 && len(end) > n.maxValue:
+				code = VALUE_TOO_LARGE
+				msg = fmt.Appendf(nil, "value too large: %d bytes exceeds the limit of %d bytes", len(end), n.maxValue)
+			case len(end) == 0:
+				code, msg = "1", []byte("value is required")
+			default:
+				n.values[start] = end
+			}
+			if err := n.respondTrace(conn, code, msg, traceID); err != nil {
+				return
+			}
+			continue
+		}
+
+		if command == DEL_KEY {
+			delete(n.values, start)
+			if err := n.respondTrace(conn, SUCCESS, nil, traceID); err != nil {
+				return
+			}
+			continue
+		}
+
+		if command == MSET_KEY {
+			n.msets.Add(1)
+			pairs := bytes.NewReader(end)
+			result := new(bytes.Buffer)
+			for pairs.Len() > 0 {
+				key, _ := readBytes(pairs)
+				value, _ := readBytes(pairs)
+				// 与服务端一致，空值的键值对失败
+				var msg []byte
+				if len(value) == 0 {
+					msg = []byte("value is required")
+				} else {
+					n.values[string(key)] = value
+				}
+				writeBytes(result, msg)
+			}
+			if err := n.respondTrace(conn, SUCCESS, result.Bytes(), traceID); err != nil {
+				return
+			}
+			continue
+		}
+
+		if command == EXEC_KEY {
+			n.execs.Add(1)
+			commands := bytes.NewReader(end)
+			var cmds [][3][]byte
+			var msgs [][]byte
+			applied := byte(1)
+			for commands.Len() > 0 {
+				cmd, _ := readBytes(commands)
+				key, _ := readBytes(commands)
+				value, _ := readBytes(commands)
+				// 与服务端一致，空的键或 set 的空值使整个批量被拒绝
+				var msg []byte
+				if len(key) == 0 {
+					msg = []byte("key required")
+				} else if string(cmd) == SET_KEY && len(value) == 0 {
+					msg = []byte("value required")
+				}
+				if msg != nil {
+					applied = 0
+				}
+				cmds = append(cmds, [3][]byte{cmd, key, value})
+				msgs = append(msgs, msg)
+			}
+			result := bytes.NewBuffer([]byte{applied})
+			for i, cmd := range cmds {
+				if applied == 1 {
+					if string(cmd[0]) == DEL_KEY {
+						delete(n.values, string(cmd[1]))
+					} else {
+						n.values[string(cmd[1])] = cmd[2]
+					}
+				}
+				writeBytes(result, msgs[i])
+			}
+			if err := n.respondTrace(conn, SUCCESS, result.Bytes(), traceID); err != nil {
+				return
+			}
+			continue
+		}
+
+		if command == SNAPSHOT_KEY {
+			// 在清单中带回收到的目录名
+			manifest, _ := sonic.Marshal(map[string]any{"ID": start, "Shards": []map[string]string{{"Dir": string(end), "Epoch": "e"}}})
+			if err := n.respondTrace(conn, SUCCESS, manifest, traceID); err != nil {
+				return
+			}
+			continue
+		}
+
+		if command == GETORSET_KEY {
+			result := []byte{1}
+			if _, ok := n.values[start]; !ok {
+				n.values[start] = end
+				result[0] = 0
+			}
+			result = append(result, n.values[start]...)
+			if err := n.respond(conn, result); err != nil {
+				return
+			}
+			continue
+		}
+
+		var chunk []byte
+		streamed := true
+		switch command {
+		case SETCHUNK_KEY:
+			setting = append(setting, end...)
+		case SETCOMMIT_KEY:
+			n.values[start], setting = setting, nil
+		case GETSTREAM_KEY:
+			getting = n.values[start]
+			chunk = binary.BigEndian.AppendUint64(nil, uint64(len(getting)))
+		case GETCHUNK_KEY:
+			size := min(len(getting), STREAM_CHUNK_SIZE)
+			chunk, getting = getting[:size], getting[size:]
+		default:
+			streamed = false
+		}
+		if streamed {
+			if err := n.respond(conn, chunk); err != nil {
+				return
+			}
+			continue
+		}
+
+		n.scans.Add(1)
+		match := func(key string) bool {
+			return key >= start && (len(end) == 0 || key < string(end))
+		}
+		// 与服务端一致，scan 的 Flags 是数量上限
+		limit := int(flags)
+		if command == SCANPREFIX_KEY {
+			match = func(key string) bool { return strings.HasPrefix(key, start) }
+			limit, _ = strconv.Atoi(string(end))
+		}
+
+		result := new(bytes.Buffer)
+		count := 0
+		for _, key := range n.keys {
+			if match(key) && (limit <= 0 || count < limit) {
+				writeBytes(result, []byte(key))
+				writeBytes(result, []byte("v"+key))
+				count++
+			}
+		}
+		n.sent.Add(int64(count))
+		if err := n.respond(conn, result.Bytes()); err != nil {
+			return
+		}
+	}
+}
+
+func (n *fakeNode) respond(conn net.Conn, result []byte) error {
+	return n.respondCode(conn, SUCCESS, result)
+}
+
+func (n *fakeNode) respondCode(conn net.Conn, code string, result []byte) error {
+	return n.respondTrace(conn, code, result, "")
+}
+
+// respondTrace 与服务端一样在响应中带回请求的追踪 ID
+func (n *fakeNode) respondTrace(conn net.Conn, code string, result []byte, traceID string) error {
+	res, _ := (&BluebellResponse{Code: code, Result: result, TraceID: traceID}).Serialize()
+	frame := make([]byte, 4+len(res))
+	binary.BigEndian.PutUint32(frame, uint32(len(res)))
+	copy(frame[4:], res)
+	_, err := conn.Write(frame)
+	return err
+}
+
+// startFakeCluster 启动三个 fakeNode，按哈希环把 keys 分布到各节点上并注册到 HuaHuoLsmCli，返回这些节点
+func startFakeCluster(t *testing.T, keys []string) []*fakeNode {
+	LsmCliInit()
+
+	ring := NewRing()
+	nodes := make(map[string]*fakeNode)
+	for i := 0; i < 3; i++ {
+		n := startFakeNode(t)
+		addr := n.listener.Addr().String()
+		nodes[addr] = n
+		ring.Add(addr)
+	}
+
+	for _, key := range keys {
+		addr, _ := ring.Get(key)
+		nodes[addr].keys = append(nodes[addr].keys, key)
+	}
+	var started []*fakeNode
+	for addr, n := range nodes {
+		started = append(started, n)
+		if len(n.keys) == 0 {
+			t.Fatalf("expected node %s to own some keys", addr)
+		}
+		sort.Strings(n.keys)
+		go n.serve()
+
+		host, port, _ := net.SplitHostPort(addr)
+		p, _ := strconv.Atoi(port)
+		c := New(host, p)
+		c.Start()
+		t.Cleanup(func() { c.Close() })
+		HuaHuoLsmCli.Clients[addr] = c
+	}
+	return started
+}
+
+func TestScanAll(t *testing.T) {
+	var keys []string
+	for i := 0; i < 300; i++ {
+		keys = append(keys, fmt.Sprintf("key%03d", i))
+	}
+	startFakeCluster(t, keys)
+
+	it, err := HuaHuoLsmCli.ScanAll([]byte("key050"), []byte("key250"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	i := 50
+	for it.Next() {
+		want := fmt.Sprintf("key%03d", i)
+		if string(it.Key()) != want || string(it.Value()) != "v"+want {
+			t.Fatalf("expected %s at position %d, but got %s=%s", want, i-50, it.Key(), it.Value())
+		}
+		i++
+	}
+	if i != 250 {
+		t.Fatalf("expected 200 keys, but got %d", i-50)
+	}
+}
+
+func TestScanPrefixAll(t *testing.T) {
+	var keys []string
+	for i := 0; i < 100; i++ {
+		keys = append(keys, fmt.Sprintf("user:%03d", i), fmt.Sprintf("order:%03d", i))
+	}
+	startFakeCluster(t, keys)
+
+	for _, limit := range []int{0, 30} {
+		it, err := HuaHuoLsmCli.ScanPrefixAll([]byte("user:"), limit)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var got []string
+		for it.Next() {
+			if string(it.Value()) != "v"+string(it.Key()) {
+				t.Fatalf("unexpected value %s for %s", it.Value(), it.Key())
+			}
+			got = append(got, string(it.Key()))
+		}
+		want := 100
+		if limit > 0 {
+			want = limit
+		}
+		if len(got) != want {
+			t.Fatalf("limit %d: expected %d keys, but got %d", limit, want, len(got))
+		}
+		for i, key := range got {
+			if key != fmt.Sprintf("user:%03d", i) {
+				t.Fatalf("limit %d: expected user:%03d at %d, but got %s", limit, i, i, key)
+			}
+		}
+	}
+}
+
+func TestScanAllRangeRing(t *testing.T) {
+	LsmCliInit()
+	defer HuaHuoLsmCli.UseRangeRing(nil)
+
+	// 三个节点分别拥有 [, key100)、[key100, key200) 和 [key200, )
+	ring := NewRangeRing()
+	var nodes []*fakeNode
+	for i := 0; i < 3; i++ {
+		n := startFakeNode(t)
+		for j := i * 100; j < (i+1)*100; j++ {
+			n.keys = append(n.keys, fmt.Sprintf("key%03d", j))
+		}
+		go n.serve()
+		nodes = append(nodes, n)
+
+		addr := n.listener.Addr().String()
+		if i == 0 {
+			ring.Assign("", addr)
+		} else {
+			ring.Assign(fmt.Sprintf("key%03d", i*100), addr)
+		}
+		host, port, _ := net.SplitHostPort(addr)
+		p, _ := strconv.Atoi(port)
+		c := New(host, p)
+		c.Start()
+		t.Cleanup(func() { c.Close() })
+		HuaHuoLsmCli.Clients[addr] = c
+	}
+	HuaHuoLsmCli.UseRangeRing(ring)
+
+	for _, tc := range []struct {
+		start, end int
+		scanned    []int32
+	}{
+		{120, 180, []int32{0, 1, 0}},
+		{150, 250, []int32{0, 2, 1}},
+		{0, 300, []int32{1, 3, 2}},
+	} {
+		it, err := HuaHuoLsmCli.ScanAll([]byte(fmt.Sprintf("key%03d", tc.start)), []byte(fmt.Sprintf("key%03d", tc.end)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		i := tc.start
+		for it.Next() {
+			if want := fmt.Sprintf("key%03d", i); string(it.Key()) != want {
+				t.Fatalf("expected %s, but got %s", want, it.Key())
+			}
+			i++
+		}
+		if i != tc.end {
+			t.Fatalf("expected %d keys in [%d, %d), but got %d", tc.end-tc.start, tc.start, tc.end, i-tc.start)
+		}
+		// 只访问拥有这段范围的节点
+		for j, n := range nodes {
+			if got := n.scans.Load(); got != tc.scanned[j] {
+				t.Fatalf("after scanning [%d, %d): expected node %d to be scanned %d times, but got %d", tc.start, tc.end, j, tc.scanned[j], got)
+			}
+		}
+	}
+
+	// 读写按范围路由
+	if _, _, err := HuaHuoLsmCli.GetOrSet("key250", []byte("value")); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := nodes[2].values["key250"]; !ok {
+		t.Fatal("expected key250 to be written to the node owning [key200, )")
+	}
+}
+
+func TestGetAll(t *testing.T) {
+	var keys []string
+	for i := 0; i < 100; i++ {
+		keys = append(keys, fmt.Sprintf("key%03d", i))
+	}
+	startFakeCluster(t, keys)
+
+	it, err := HuaHuoLsmCli.GetAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	seen := make(map[string]int)
+	var got []string
+	for it.Next() {
+		if string(it.Value()) != "v"+string(it.Key()) {
+			t.Fatalf("unexpected value %s for %s", it.Value(), it.Key())
+		}
+		seen[string(it.Key())]++
+		got = append(got, string(it.Key()))
+	}
+	if len(got) != len(keys) {
+		t.Fatalf("expected %d keys, but got %d", len(keys), len(got))
+	}
+	for i, key := range keys {
+		if seen[key] != 1 || got[i] != key {
+			t.Fatalf("expected %s exactly once at %d, but got %s (%d times)", key, i, got[i], seen[key])
+		}
+	}
+}
+
+func TestGetOrSet(t *testing.T) {
+	var keys []string
+	for i := 0; i < 30; i++ {
+		keys = append(keys, fmt.Sprintf("key%03d", i))
+	}
+	startFakeCluster(t, keys)
+
+	// 全局哈希环不包含测试节点，直接使用其中一个节点的连接
+	var c *Client
+	for _, c = range HuaHuoLsmCli.Clients {
+		break
+	}
+	stored, loaded, err := c.getOrSet("cache", []byte("first"), "trace-1", "")
+	if err != nil || loaded || string(stored) != "first" {
+		t.Fatalf("expected first to be set, but got %s (%v, %v)", stored, loaded, err)
+	}
+	stored, loaded, err = c.getOrSet("cache", []byte("second"), "trace-2", "")
+	if err != nil || !loaded || string(stored) != "first" {
+		t.Fatalf("expected first to be loaded, but got %s (%v, %v)", stored, loaded, err)
+	}
+}
+
+func TestGetOr(t *testing.T) {
+	LsmCliInit()
+
+	// 哈希环上只有一个节点，所有键都路由到它
+	n := startFakeNode(t)
+	go n.serve()
+	addr := n.listener.Addr().String()
+	if err := HuaHuoLsmCli.addNode(addr); err != nil {
+		t.Fatal(err)
+	}
+	defer HuaHuoLsmCli.removeNode(addr)
+
+	if _, _, err := HuaHuoLsmCli.GetOrSet("present", []byte("value")); err != nil {
+		t.Fatal(err)
+	}
+	value, err := HuaHuoLsmCli.GetOr("present", []byte("default"))
+	if err != nil || string(value) != "value" {
+		t.Fatalf("expected value, but got %q (%v)", value, err)
+	}
+
+	value, err = HuaHuoLsmCli.GetOr("absent", []byte("default"))
+	if err != nil || string(value) != "default" {
+		t.Fatalf("expected default, but got %q (%v)", value, err)
+	}
+	if _, err := HuaHuoLsmCli.Get("absent"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected %v, but got %v", ErrNotFound, err)
+	}
+
+	// 节点下线后返回错误，而不是默认值
+	HuaHuoLsmCli.removeNode(addr)
+	if value, err := HuaHuoLsmCli.GetOr("absent", []byte("default")); err == nil {
+		t.Fatalf("expected an error for a node that is down, but got %q", value)
+	}
+}
+
+func TestSetErrors(t *testing.T) {
+	LsmCliInit()
+
+	n := startFakeNode(t)
+	n.maxValue = 4
+	go n.serve()
+	addr := n.listener.Addr().String()
+	if err := HuaHuoLsmCli.addNode(addr); err != nil {
+		t.Fatal(err)
+	}
+	defer HuaHuoLsmCli.removeNode(addr)
+
+	if err := HuaHuoLsmCli.Set("key", []byte("fits")); err != nil {
+		t.Fatal(err)
+	}
+	err := HuaHuoLsmCli.Set("key", []byte("too large"))
+	if !errors.Is(err, ErrValueTooLarge) {
+		t.Fatalf("expected %v, but got %v", ErrValueTooLarge, err)
+	}
+	if want := "value too large: 9 bytes exceeds the limit of 4 bytes"; err.Error() != want {
+		t.Fatalf("expected %q, but got %q", want, err)
+	}
+
+	// 其他错误带上节点返回的信息
+	err = HuaHuoLsmCli.Set("key", nil)
+	if err == nil || errors.Is(err, ErrValueTooLarge) || !strings.Contains(err.Error(), "value is required") {
+		t.Fatalf("expected the node's message, but got %v", err)
+	}
+}
+
+func TestScanIteratorSkipsDuplicates(t *testing.T) {
+	it := newScanIterator([][]kv{
+		{{key: []byte("a"), value: []byte("1")}, {key: []byte("c"), value: []byte("3")}},
+		nil,
+		{{key: []byte("b"), value: []byte("2")}, {key: []byte("c"), value: []byte("3")}},
+	})
+	var keys []string
+	for it.Next() {
+		keys = append(keys, string(it.Key()))
+	}
+	if fmt.Sprint(keys) != "[a b c]" {
+		t.Fatalf("expected [a b c], but got %v", keys)
+	}
+}
+
+func TestStream(t *testing.T) {
+	var keys []string
+	for i := 0; i < 30; i++ {
+		keys = append(keys, fmt.Sprintf("key%03d", i))
+	}
+	startFakeCluster(t, keys)
+
+	var c *Client
+	for _, c = range HuaHuoLsmCli.Clients {
+		break
+	}
+	// 值跨越多个块，最后一块不满
+	value := bytes.Repeat([]byte("0123456789abcdef"), (3*STREAM_CHUNK_SIZE+STREAM_CHUNK_SIZE/2)/16)
+	if err := c.setStream("large", bytes.NewReader(value), "trace-1"); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	var got bytes.Buffer
+	n, err := c.getStream("large", &got, "trace-2")
+	if err != nil || n != int64(len(value)) || !bytes.Equal(got.Bytes(), value) {
+		t.Fatalf("expected %d bytes, but got %d (%v)", len(value), n, err)
+	}
+}
@@ -0,0 +1,185 @@
+package client
+
+import (
+	"bytes"
+	"container/heap"
+	"sync"
+)
+
+// scanStreamPageSize 是 ScanStream 每次从一个节点读取的键值对数量上限
+const scanStreamPageSize = 256
+
+// KV 是 ScanStream 返回的一个键值对
+type KV struct {
+	Key   string
+	Value []byte
+}
+
+// ScanStream 与 ScanAll 一样在整个集群上按键全局升序遍历 [start, end) 范围内的键值对，空的边界表示不限制，
+// 但不把结果全部读入内存：每个节点按页读取，归并时某个节点的一页用完才读取它的下一页，
+// 客户端同时最多持有每个节点的一页。结果通过返回的通道逐个发送，消费者读取得慢时遍历随之暂停。
+//
+// 遍历结束或出错时通道关闭，之后调用返回的函数得到遍历的错误（正常结束时为 nil）。
+// 通道关闭之前调用该函数会停止遍历，等待通道关闭后返回 nil，用于提前结束读取：
+//
+//	kvs, wait := HuaHuoLsmCli.ScanStream(start, end)
+//	for kv := range kvs {
+//		...
+//	}
+//	if err := wait(); err != nil {
+//		...
+//	}
+func (hc *HuaHuoLsmClient) ScanStream(start, end string) (<-chan KV, func() error) {
+	out := make(chan KV)
+	stop := make(chan struct{})
+	done := make(chan struct{})
+	var err error
+
+	go func() {
+		defer close(done)
+		defer close(out)
+		err = hc.scanStream([]byte(start), []byte(end), out, stop)
+	}()
+
+	var once sync.Once
+	return out, func() error {
+		select {
+		case <-done:
+			return err
+		default:
+		}
+		once.Do(func() { close(stop) })
+		<-done
+		return err
+	}
+}
+
+// scanStream 归并各节点的分页结果并逐个发送到 out，stop 关闭时停止并返回 nil
+func (hc *HuaHuoLsmClient) scanStream(start, end []byte, out chan<- KV, stop <-chan struct{}) error {
+	clients, err := hc.scanClients(start, end)
+	if err != nil {
+		return err
+	}
+
+	// 并发读取每个节点的第一页，之后按需读取
+	pagers := make([]*scanPager, len(clients))
+	errs := make([]error, len(clients))
+	var wg sync.WaitGroup
+	for i, c := range clients {
+		pagers[i] = &scanPager{hc: hc, c: c, next: start, end: end}
+		wg.Add(1)
+		go func(p *scanPager, i int) {
+			defer wg.Done()
+			errs[i] = p.fetch()
+		}(pagers[i], i)
+	}
+	wg.Wait()
+
+	var pages pagerHeap
+	for i, p := range pagers {
+		if errs[i] != nil {
+			return errs[i]
+		}
+		if len(p.kvs) > 0 {
+			pages = append(pages, p)
+		}
+	}
+	heap.Init(&pages)
+
+	var last []byte
+	for len(pages) > 0 {
+		p := pages[0]
+		head := p.kvs[0]
+		p.kvs = p.kvs[1:]
+		if len(p.kvs) == 0 {
+			if err := p.fetch(); err != nil {
+				return err
+			}
+		}
+		if len(p.kvs) == 0 {
+			heap.Pop(&pages)
+		} else {
+			heap.Fix(&pages, 0)
+		}
+
+		// 重新平衡期间同一个键可能出现在多个节点上，只返回先取出的一个
+		if last != nil && bytes.Equal(head.key, last) {
+			continue
+		}
+		last = head.key
+
+		select {
+		case out <- KV{Key: string(head.key), Value: head.value}:
+		case <-stop:
+			return nil
+		}
+	}
+	return nil
+}
+
+// scanPager 分页读取一个节点 [next, end) 范围内的键值对
+type scanPager struct {
+	hc   *HuaHuoLsmClient
+	c    *Client
+	next []byte
+	end  []byte
+	// 当前页中还没有归并的键值对
+	kvs []kv
+	// 节点已经没有更多的键
+	exhausted bool
+}
+
+// fetch 读取下一页，节点没有更多的键时 kvs 保持为空
+func (p *scanPager) fetch() error {
+	if p.exhausted {
+		return nil
+	}
+	kvs, err := p.c.scanPage(p.next, p.end, scanStreamPageSize, p.hc.nextTraceID())
+	if err != nil {
+		return err
+	}
+	// 旧版本的节点忽略数量上限，一次返回整个范围
+	if len(kvs) < scanStreamPageSize {
+		p.exhausted = true
+	} else {
+		// 下一页从最后一个键之后的最小键开始
+		last := kvs[len(kvs)-1].key
+		p.next = append(append(make([]byte, 0, len(last)+1), last...), 0)
+	}
+	p.kvs = kvs
+	return nil
+}
+
+func (c *Client) scanPage(start, end []byte, limit int, traceID string) ([]kv, error) {
+	request := &Bluebell{
+		Command: SCAN_KEY,
+		Key:     string(start),
+		Value:   end,
+		TraceID: traceID,
+		// scan 的 Flags 是返回数量的上限
+		Flags: uint32(limit),
+	}
+
+	return c.requestKVs(request)
+}
+
+// pagerHeap 是按当前页第一个键排序的最小堆
+type pagerHeap []*scanPager
+
+func (h pagerHeap) Len() int { return len(h) }
+func (h pagerHeap) Less(i, j int) bool {
+	return bytes.Compare(h[i].kvs[0].key, h[j].kvs[0].key) < 0
+}
+func (h pagerHeap) Swap(i, j int) { h[i], h[j] = h[j], h[i] }
+
+func (h *pagerHeap) Push(x interface{}) {
+	*h = append(*h, x.(*scanPager))
+}
+
+func (h *pagerHeap) Pop() interface{} {
+	old := *h
+	n := len(old)
+	p := old[n-1]
+	*h = old[:n-1]
+	return p
+}
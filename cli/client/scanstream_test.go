@@ -0,0 +1,65 @@
+package client
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestScanStream(t *testing.T) {
+	var keys []string
+	for i := 0; i < 20000; i++ {
+		keys = append(keys, fmt.Sprintf("key%05d", i))
+	}
+	nodes := startFakeCluster(t, keys)
+	sent := func() int64 {
+		var total int64
+		for _, n := range nodes {
+			total += n.sent.Load()
+		}
+		return total
+	}
+
+	kvs, wait := HuaHuoLsmCli.ScanStream("key00100", "")
+	i := 100
+	for kv := range kvs {
+		want := fmt.Sprintf("key%05d", i)
+		if kv.Key != want || string(kv.Value) != "v"+want {
+			t.Fatalf("expected %s at position %d, but got %s=%s", want, i-100, kv.Key, kv.Value)
+		}
+		i++
+		// 客户端最多持有每个节点的一页
+		if buffered := sent() - int64(i-100); buffered > int64(len(nodes)*scanStreamPageSize) {
+			t.Fatalf("expected at most one page per node to be buffered, but %d key-value pairs were read ahead", buffered)
+		}
+	}
+	if err := wait(); err != nil {
+		t.Fatal(err)
+	}
+	if i != 20000 {
+		t.Fatalf("expected 19900 keys, but got %d", i-100)
+	}
+}
+
+func TestScanStreamStop(t *testing.T) {
+	var keys []string
+	for i := 0; i < 5000; i++ {
+		keys = append(keys, fmt.Sprintf("key%05d", i))
+	}
+	startFakeCluster(t, keys)
+
+	kvs, wait := HuaHuoLsmCli.ScanStream("", "key04000")
+	for i := 0; i < 10; i++ {
+		if kv := <-kvs; kv.Key != fmt.Sprintf("key%05d", i) {
+			t.Fatalf("unexpected key %s at position %d", kv.Key, i)
+		}
+	}
+	// 提前停止后通道关闭
+	if err := wait(); err != nil {
+		t.Fatal(err)
+	}
+	for range kvs {
+	}
+	if err := wait(); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -15,10 +15,15 @@ type Bluebell struct {
 	Key     string // 键，通常是用于标识数据的字符串
 	Value   []byte // 值，存储数据的字节数组
 	Group   string // 组，表示消息所属的组或类别
+	TraceID string // 追踪 ID，服务端写入日志并在响应中原样返回，可以为空
+	OpID    string // 幂等键，重试写请求时使用相同的值，节点返回第一次执行的结果而不会重复执行，可以为空
+	Flags   uint32 // 键的标志，set 时与值一起写入，为 0 时不带标志
 }
 type BluebellResponse struct {
-	Code   string
-	Result []byte // 响应数据
+	Code    string
+	Result  []byte // 响应数据
+	TraceID string // 请求的追踪 ID
+	Flags   uint32 // get 返回的键的标志
 }
 
 func (b *BluebellResponse) Serialize() ([]byte, error) {
@@ -31,6 +36,14 @@ func (b *BluebellResponse) Serialize() ([]byte, error) {
 		return nil, err
 	}
 
+	if err := writeString(buf, b.TraceID); err != nil {
+		return nil, err
+	}
+
+	if err := binary.Write(buf, binary.BigEndian, b.Flags); err != nil {
+		return nil, err
+	}
+
 	return buf.Bytes(), nil
 }
 func DeserializeResponse(data []byte) (*BluebellResponse, error) {
@@ -46,17 +59,37 @@ func DeserializeResponse(data []byte) (*BluebellResponse, error) {
 		return nil, err
 	}
 
+	// 旧版本的服务端不返回追踪 ID
+	var traceID string
+	if buf.Len() > 0 {
+		traceID, err = readString(buf)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// 旧版本的服务端不返回标志
+	var flags uint32
+	if buf.Len() > 0 {
+		if err := binary.Read(buf, binary.BigEndian, &flags); err != nil {
+			return nil, err
+		}
+	}
+
 	return &BluebellResponse{
-		Code:   code,
-		Result: result,
+		Code:    code,
+		Result:  result,
+		TraceID: traceID,
+		Flags:   flags,
 	}, nil
 }
 func (b *Bluebell) String() string {
-	return fmt.Sprintf("Bluebell{\n  Command: %s,\n  Key: %s,\n  Value: %s,\n  Group: %s\n}",
+	return fmt.Sprintf("Bluebell{\n  Command: %s,\n  Key: %s,\n  Value: %s,\n  Group: %s,\n  TraceID: %s\n}",
 		b.Command,
 		b.Key,
 		string(b.Value), // 将 []byte 转换为 string
 		b.Group,
+		b.TraceID,
 	)
 }
 
@@ -84,6 +117,21 @@ func (b *Bluebell) Serialize() ([]byte, error) {
 		return nil, err
 	}
 
+	// TraceID 字段
+	if err := writeString(buf, b.TraceID); err != nil {
+		return nil, err
+	}
+
+	// OpID 字段
+	if err := writeString(buf, b.OpID); err != nil {
+		return nil, err
+	}
+
+	// Flags 字段，旧版本的服务端忽略
+	if err := binary.Write(buf, binary.BigEndian, b.Flags); err != nil {
+		return nil, err
+	}
+
 	return buf.Bytes(), nil
 }
 
@@ -113,6 +161,9 @@ func readString(buf io.Reader) (string, error) {
 	if err := binary.Read(buf, binary.BigEndian, &length); err != nil {
 		return "", err
 	}
+	if err := checkLength(buf, length); err != nil {
+		return "", err
+	}
 	strBuf := make([]byte, length)
 	if _, err := io.ReadFull(buf, strBuf); err != nil {
 		return "", err
@@ -126,12 +177,25 @@ func readBytes(buf io.Reader) ([]byte, error) {
 	if err := binary.Read(buf, binary.BigEndian, &length); err != nil {
 		return nil, err
 	}
+	if err := checkLength(buf, length); err != nil {
+		return nil, err
+	}
 	byteBuf := make([]byte, length)
 	if _, err := io.ReadFull(buf, byteBuf); err != nil {
 		return nil, err
 	}
 	return byteBuf, nil
 }
+
+// checkLength 在分配内存之前检查长度前缀是否超出缓冲区中剩余的数据，
+// 避免损坏的长度前缀导致分配巨大的内存
+func checkLength(buf io.Reader, length uint32) error {
+	if r, ok := buf.(interface{ Len() int }); ok && int64(length) > int64(r.Len()) {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
+
 func (b *Bluebell) Encode() ([]byte, error) {
 	// 1. 序列化 Bluebell 结构体
 	serializedData, err := b.Serialize()
@@ -0,0 +1,156 @@
+package client
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math/rand"
+	"testing"
+)
+
+func TestBluebellEncodeBinary(t *testing.T) {
+	r := rand.New(rand.NewSource(1))
+	for i := 0; i < 100; i++ {
+		key := make([]byte, 1+r.Intn(256))
+		value := make([]byte, r.Intn(4096))
+		r.Read(key)
+		r.Read(value)
+		// 嵌入看起来像长度前缀的字节
+		if len(value) >= 4 {
+			binary.BigEndian.PutUint32(value, uint32(len(value)))
+		}
+
+		frame, err := (&Bluebell{Command: SET_KEY, Key: string(key), Value: value}).Encode()
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if int(binary.BigEndian.Uint32(frame)) != len(frame)-4 {
+			t.Fatalf("frame header %d does not match body length %d", binary.BigEndian.Uint32(frame), len(frame)-4)
+		}
+
+		// 按服务端的字段顺序解码
+		buf := bytes.NewReader(frame[4:])
+		command, err := readString(buf)
+		if err != nil || command != SET_KEY {
+			t.Fatalf("unexpected command %q: %v", command, err)
+		}
+		gotKey, err := readString(buf)
+		if err != nil || gotKey != string(key) {
+			t.Fatalf("key mismatch: %v", err)
+		}
+		gotValue, err := readBytes(buf)
+		if err != nil || !bytes.Equal(gotValue, value) {
+			t.Fatalf("value mismatch: %v", err)
+		}
+	}
+}
+
+func TestDeserializeResponseRejectsBadLength(t *testing.T) {
+	data, err := (&BluebellResponse{Code: SUCCESS, Result: []byte("value")}).Serialize()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	// Result 的长度前缀紧跟在 Code 之后
+	binary.BigEndian.PutUint32(data[4+len(SUCCESS):], 0xffffffff)
+	if _, err := DeserializeResponse(data); err != io.ErrUnexpectedEOF {
+		t.Fatalf("expected %v, but got %v", io.ErrUnexpectedEOF, err)
+	}
+}
+
+func TestTraceID(t *testing.T) {
+	frame, err := (&Bluebell{Command: GET_KEY, Key: "key", TraceID: "trace-1"}).Encode()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	// 按服务端的字段顺序解码：Command、Key、Value、Group、TraceID
+	buf := bytes.NewReader(frame[4:])
+	for i := 0; i < 2; i++ {
+		readString(buf)
+	}
+	readBytes(buf)
+	readString(buf)
+	if traceID, err := readString(buf); err != nil || traceID != "trace-1" {
+		t.Fatalf("expected trace id trace-1, but got %q: %v", traceID, err)
+	}
+
+	data, err := (&BluebellResponse{Code: SUCCESS, TraceID: "trace-1"}).Serialize()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	res, err := DeserializeResponse(data)
+	if err != nil || res.TraceID != "trace-1" {
+		t.Fatalf("expected trace id trace-1 in response, but got %+v: %v", res, err)
+	}
+
+	// 旧版本的服务端不返回追踪 ID 和之后的标志
+	res, err = DeserializeResponse(data[:len(data)-4-len("trace-1")-4])
+	if err != nil || res.TraceID != "" {
+		t.Fatalf("expected an empty trace id, but got %+v: %v", res, err)
+	}
+
+	hc := &HuaHuoLsmClient{}
+	if a, b := hc.nextTraceID(), hc.nextTraceID(); len(a) != 16 || a == b {
+		t.Fatalf("expected distinct generated trace ids, but got %q and %q", a, b)
+	}
+	if traceID := hc.WithTraceID("caller").nextTraceID(); traceID != "caller" {
+		t.Fatalf("expected the caller's trace id, but got %q", traceID)
+	}
+}
+
+func TestOpID(t *testing.T) {
+	frame, err := (&Bluebell{Command: APPEND_KEY, Key: "key", Value: []byte("a"), TraceID: "trace-1", OpID: "op-1"}).Encode()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	// 幂等键是 TraceID 之后的可选字段
+	buf := bytes.NewReader(frame[4:])
+	for i := 0; i < 2; i++ {
+		readString(buf)
+	}
+	readBytes(buf)
+	readString(buf)
+	readString(buf)
+	if opID, err := readString(buf); err != nil || opID != "op-1" {
+		t.Fatalf("expected op id op-1, but got %q: %v", opID, err)
+	}
+
+	hc := &HuaHuoLsmClient{}
+	if withOpID := hc.WithOpID("op-1"); withOpID.opID != "op-1" || hc.opID != "" {
+		t.Fatalf("expected only the returned client to carry the op id, but got %q and %q", withOpID.opID, hc.opID)
+	}
+}
+
+func TestFlags(t *testing.T) {
+	frame, err := (&Bluebell{Command: SET_KEY, Key: "key", Value: []byte("v"), Flags: 0xdeadbeef}).Encode()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	// 标志是 OpID 之后的可选字段
+	buf := bytes.NewReader(frame[4:])
+	for i := 0; i < 2; i++ {
+		readString(buf)
+	}
+	readBytes(buf)
+	for i := 0; i < 3; i++ {
+		readString(buf)
+	}
+	var flags uint32
+	if err := binary.Read(buf, binary.BigEndian, &flags); err != nil || flags != 0xdeadbeef {
+		t.Fatalf("expected flags deadbeef, but got %x: %v", flags, err)
+	}
+
+	data, err := (&BluebellResponse{Code: SUCCESS, Result: []byte("v"), Flags: 7}).Serialize()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	res, err := DeserializeResponse(data)
+	if err != nil || res.Flags != 7 {
+		t.Fatalf("expected flags 7 in response, but got %+v: %v", res, err)
+	}
+
+	// 旧版本的服务端不返回标志
+	res, err = DeserializeResponse(data[:len(data)-4])
+	if err != nil || res.Flags != 0 {
+		t.Fatalf("expected no flags, but got %+v: %v", res, err)
+	}
+}
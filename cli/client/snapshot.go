@@ -0,0 +1,128 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/bytedance/sonic"
+)
+
+// 节点备份整个存储可能需要很长时间
+var snapshotTimeout = 10 * time.Minute
+
+// SnapshotManifest 是节点返回的快照清单，与节点写入快照目录的 MANIFEST.json 相同
+type SnapshotManifest struct {
+	// 集群快照的 ID
+	ID string
+	// 节点上快照开始和完成的时间
+	Started  time.Time
+	Finished time.Time
+	// 每个分片的快照
+	Shards []struct {
+		// 快照目录中分片备份的子目录
+		Dir string
+		// 快照时分片的纪元
+		Epoch string
+	}
+}
+
+// NodeSnapshot 是一个节点的快照结果
+type NodeSnapshot struct {
+	// 节点地址，形如 host:port
+	Addr string
+	// 节点快照根目录（节点的 -snapshot-dir）下的快照目录名
+	Name string
+	// 快照成功时节点返回的清单
+	Manifest *SnapshotManifest
+	// 快照失败或节点离线时的错误
+	Err error
+}
+
+// ClusterSnapshot 是一次集群快照的结果，Nodes 按节点地址排序
+type ClusterSnapshot struct {
+	ID    string
+	Nodes []NodeSnapshot
+}
+
+// SnapshotCluster 让每个注册的节点同时把自己的存储备份到节点快照根目录下的 <id>-<节点地址>，返回每个节点的清单。
+// 快照根目录由节点的 -snapshot-dir 决定，客户端只能指定目录名，id 因此不能包含路径分隔符；
+// 目录名带有节点地址，多个节点的快照根目录是同一个共享存储时不会冲突。
+// 每个节点的快照目录中有 MANIFEST.json，没有清单的目录是没有完成的快照。
+//
+// 一致性：每个节点的快照是该节点（每个分片）某一时刻的一致状态，
+// 但各节点在各自收到命令时备份，集群整体只是近似一致，不同节点之间可能相差快照期间的写入。
+// 需要严格一致的集群备份时，应在快照期间停止写入。
+//
+// 离线的节点和快照失败的节点记录在各自的 Err 中，此时同时返回结果和合并的错误，
+// 调用方可以只重试失败的节点或丢弃这次快照
+func (hc *HuaHuoLsmClient) SnapshotCluster(id string) (*ClusterSnapshot, error) {
+	if id == "" {
+		return nil, errors.New("snapshot id is required")
+	}
+
+	clientsMu.RLock()
+	snapshot := &ClusterSnapshot{ID: id}
+	clients := make(map[string]*Client, len(hc.Clients))
+	for addr, c := range hc.Clients {
+		snapshot.Nodes = append(snapshot.Nodes, NodeSnapshot{Addr: addr, Name: id + "-" + nodeDirName(addr)})
+		clients[addr] = c
+	}
+	clientsMu.RUnlock()
+	sort.Slice(snapshot.Nodes, func(i, j int) bool { return snapshot.Nodes[i].Addr < snapshot.Nodes[j].Addr })
+
+	var wg sync.WaitGroup
+	for i := range snapshot.Nodes {
+		node := &snapshot.Nodes[i]
+		c := clients[node.Addr]
+		if !c.Status.Load() {
+			node.Err = errors.New("node is offline")
+			continue
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			node.Manifest, node.Err = c.snapshot(id, node.Name, hc.nextTraceID())
+		}()
+	}
+	wg.Wait()
+
+	var errs []error
+	for _, node := range snapshot.Nodes {
+		if node.Err != nil {
+			errs = append(errs, fmt.Errorf("node %s: %w", node.Addr, node.Err))
+		}
+	}
+	return snapshot, errors.Join(errs...)
+}
+
+// nodeDirName 把节点地址转换为可以作为目录名的形式
+func nodeDirName(addr string) string {
+	return strings.NewReplacer(":", "_", "/", "_").Replace(addr)
+}
+
+func (c *Client) snapshot(id, name, traceID string) (*SnapshotManifest, error) {
+	request := &Bluebell{
+		Command: SNAPSHOT_KEY,
+		Key:     id,
+		Value:   []byte(name),
+		TraceID: traceID,
+	}
+
+	res, err := c.exchange(request, snapshotTimeout)
+	if err != nil {
+		return nil, err
+	}
+	if res.Code != SUCCESS {
+		return nil, errors.New(string(res.Result))
+	}
+
+	manifest := &SnapshotManifest{}
+	if err := sonic.Unmarshal(res.Result, manifest); err != nil {
+		return nil, fmt.Errorf("invalid snapshot manifest: %w", err)
+	}
+	return manifest, nil
+}
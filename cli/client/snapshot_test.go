@@ -0,0 +1,58 @@
+package client
+
+import "testing"
+
+func TestSnapshotCluster(t *testing.T) {
+	LsmCliInit()
+
+	var addrs []string
+	for i := 0; i < 3; i++ {
+		n := startFakeNode(t)
+		go n.serve()
+		addr := n.listener.Addr().String()
+		if err := HuaHuoLsmCli.addNode(addr); err != nil {
+			t.Fatal(err)
+		}
+		defer HuaHuoLsmCli.removeNode(addr)
+		addrs = append(addrs, addr)
+	}
+
+	snapshot, err := HuaHuoLsmCli.SnapshotCluster("snap-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if snapshot.ID != "snap-1" || len(snapshot.Nodes) != len(addrs) {
+		t.Fatalf("unexpected snapshot %+v", snapshot)
+	}
+	for _, node := range snapshot.Nodes {
+		want := "snap-1-" + nodeDirName(node.Addr)
+		if node.Err != nil || node.Name != want {
+			t.Fatalf("expected node %s to snapshot into %s, but got %s (%v)", node.Addr, want, node.Name, node.Err)
+		}
+		if m := node.Manifest; m == nil || m.ID != "snap-1" || len(m.Shards) != 1 || m.Shards[0].Dir != want {
+			t.Fatalf("unexpected manifest from %s: %+v", node.Addr, m)
+		}
+	}
+
+	// 离线的节点记录在结果中，其余节点照常快照
+	HuaHuoLsmCli.Clients[addrs[1]].Status.Store(false)
+	defer func() { HuaHuoLsmCli.Clients[addrs[1]].Status.Store(true) }()
+	snapshot, err = HuaHuoLsmCli.SnapshotCluster("snap-2")
+	if err == nil {
+		t.Fatal("expected an error for the offline node")
+	}
+	failed := 0
+	for _, node := range snapshot.Nodes {
+		if node.Err != nil {
+			failed++
+			if node.Addr != addrs[1] {
+				t.Fatalf("expected only %s to fail, but %s failed: %v", addrs[1], node.Addr, node.Err)
+			}
+		} else if node.Manifest == nil || node.Manifest.ID != "snap-2" {
+			t.Fatalf("unexpected manifest from %s: %+v", node.Addr, node.Manifest)
+		}
+	}
+	if failed != 1 {
+		t.Fatalf("expected 1 failed node, but got %d", failed)
+	}
+}
@@ -0,0 +1,98 @@
+package client
+
+import (
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// SetStream 把 r 中的全部内容作为 key 的值分块写入，用于超过单条消息上限的大值。
+// 节点收到 setcommit 后才写入存储，中途失败时 key 保持原来的值
+func (hc *HuaHuoLsmClient) SetStream(key string, r io.Reader) error {
+	defer hc.invalidate(key)
+	c, err := hc.route(key)
+	if err != nil {
+		return err
+	}
+	return c.setStream(key, r, hc.nextTraceID())
+}
+
+// GetStream 把 key 的值分块写入 w，返回值的长度；节点直接从数据文件分块读取，不需要把整个值放进内存
+func (hc *HuaHuoLsmClient) GetStream(key string, w io.Writer) (int64, error) {
+	c, err := hc.route(key)
+	if err != nil {
+		return 0, err
+	}
+	return c.getStream(key, w, hc.nextTraceID())
+}
+
+func (c *Client) setStream(key string, r io.Reader, traceID string) error {
+	c.streamMu.Lock()
+	defer c.streamMu.Unlock()
+
+	chunk := make([]byte, STREAM_CHUNK_SIZE)
+	for {
+		n, err := io.ReadFull(r, chunk)
+		if n > 0 {
+			if _, err := c.streamRequest(&Bluebell{Command: SETCHUNK_KEY, Key: key, Value: chunk[:n], TraceID: traceID}); err != nil {
+				return err
+			}
+		}
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+	}
+	_, err := c.streamRequest(&Bluebell{Command: SETCOMMIT_KEY, Key: key, TraceID: traceID})
+	return err
+}
+
+func (c *Client) getStream(key string, w io.Writer, traceID string) (int64, error) {
+	c.streamMu.Lock()
+	defer c.streamMu.Unlock()
+
+	res, err := c.streamRequest(&Bluebell{Command: GETSTREAM_KEY, Key: key, TraceID: traceID})
+	if err != nil {
+		return 0, err
+	}
+	if len(res) != 8 {
+		return 0, errors.New("invalid stream size")
+	}
+	size := int64(binary.BigEndian.Uint64(res))
+
+	var written int64
+	for written < size {
+		chunk, err := c.streamRequest(&Bluebell{Command: GETCHUNK_KEY, TraceID: traceID})
+		if err != nil {
+			return written, err
+		}
+		if len(chunk) == 0 {
+			return written, fmt.Errorf("stream ended after %d of %d bytes", written, size)
+		}
+		n, err := w.Write(chunk)
+		written += int64(n)
+		if err != nil {
+			return written, err
+		}
+	}
+	return written, nil
+}
+
+// streamRequest 发送流式命令并返回成功响应的结果
+func (c *Client) streamRequest(request *Bluebell) ([]byte, error) {
+	res, err := c.exchange(request, 5*time.Second) // 等待响应，设置超时
+	if err != nil {
+		return nil, err
+	}
+	if res.Code == VALUE_TOO_LARGE {
+		return nil, writeError(request.Command, res)
+	}
+	if res.Code != SUCCESS {
+		return nil, errors.New(string(res.Result))
+	}
+	return res.Result, nil
+}
@@ -0,0 +1,67 @@
+package client
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/bytedance/sonic"
+)
+
+// ErrTypeMismatch 存储的值与读取时期望的类型不一致
+var ErrTypeMismatch = errors.New("stored value type mismatch")
+
+// SetString 存储字符串，值前带一字节类型标记
+func (hc *HuaHuoLsmClient) SetString(key string, value string) error {
+	return hc.Set(key, encodeTyped(TYPE_STRING, []byte(value)))
+}
+
+// GetString 读取由 SetString 存储的字符串
+func (hc *HuaHuoLsmClient) GetString(key string) (string, error) {
+	value, err := hc.Get(key)
+	if err != nil {
+		return "", err
+	}
+	data, err := decodeTyped(TYPE_STRING, value)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// SetJSON 将 v 序列化为 JSON 后存储，值前带一字节类型标记
+func (hc *HuaHuoLsmClient) SetJSON(key string, v any) error {
+	data, err := sonic.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return hc.Set(key, encodeTyped(TYPE_JSON, data))
+}
+
+// GetJSON 读取由 SetJSON 存储的值并反序列化到 out
+func (hc *HuaHuoLsmClient) GetJSON(key string, out any) error {
+	value, err := hc.Get(key)
+	if err != nil {
+		return err
+	}
+	data, err := decodeTyped(TYPE_JSON, value)
+	if err != nil {
+		return err
+	}
+	return sonic.Unmarshal(data, out)
+}
+
+// encodeTyped 在数据前加上类型标记
+func encodeTyped(tag byte, data []byte) []byte {
+	value := make([]byte, len(data)+1)
+	value[0] = tag
+	copy(value[1:], data)
+	return value
+}
+
+// decodeTyped 校验类型标记并返回去掉标记后的数据
+func decodeTyped(tag byte, value []byte) ([]byte, error) {
+	if len(value) == 0 || value[0] != tag {
+		return nil, fmt.Errorf("%w: expected %q", ErrTypeMismatch, tag)
+	}
+	return value[1:], nil
+}
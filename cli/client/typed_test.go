@@ -0,0 +1,50 @@
+package client
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/bytedance/sonic"
+)
+
+func TestTypedRoundTrip(t *testing.T) {
+	// 字符串
+	value := encodeTyped(TYPE_STRING, []byte("测试value"))
+	data, err := decodeTyped(TYPE_STRING, value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != "测试value" {
+		t.Fatalf("expected %s, got %s", "测试value", data)
+	}
+
+	// JSON
+	in := MyStruct{Name: "huahuo", Age: 18}
+	encoded, err := sonic.Marshal(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	value = encodeTyped(TYPE_JSON, encoded)
+	data, err = decodeTyped(TYPE_JSON, value)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out MyStruct
+	if err := sonic.Unmarshal(data, &out); err != nil {
+		t.Fatal(err)
+	}
+	if out != in {
+		t.Fatalf("expected %+v, got %+v", in, out)
+	}
+
+	// 类型不匹配
+	if _, err := decodeTyped(TYPE_JSON, encodeTyped(TYPE_STRING, []byte("x"))); !errors.Is(err, ErrTypeMismatch) {
+		t.Fatalf("expected %v, got %v", ErrTypeMismatch, err)
+	}
+	if _, err := decodeTyped(TYPE_JSON, []byte("raw")); !errors.Is(err, ErrTypeMismatch) {
+		t.Fatalf("expected %v, got %v", ErrTypeMismatch, err)
+	}
+	if _, err := decodeTyped(TYPE_JSON, nil); !errors.Is(err, ErrTypeMismatch) {
+		t.Fatalf("expected %v, got %v", ErrTypeMismatch, err)
+	}
+}
@@ -0,0 +1,116 @@
+package client
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// valueCache 是 Get 结果的 LRU 缓存，条目在写入 ttl 之后过期。
+// 每次失效都增加代数，Get 只在发出请求前后代数没有变化时才缓存结果，
+// 避免与本客户端的写入并发的 Get 把写入之前的值放回缓存。
+type valueCache struct {
+	mu         sync.Mutex
+	capacity   int
+	ttl        time.Duration
+	ll         *list.List
+	items      map[string]*list.Element
+	generation uint64
+}
+
+type valueEntry struct {
+	key     string
+	value   []byte
+	flags   uint32
+	expires time.Time
+}
+
+func newValueCache(capacity int, ttl time.Duration) *valueCache {
+	return &valueCache{
+		capacity: capacity,
+		ttl:      ttl,
+		ll:       list.New(),
+		items:    make(map[string]*list.Element),
+	}
+}
+
+// get 返回 key 缓存的值和标志的副本，过期的条目被视为未命中
+func (c *valueCache) get(key string) ([]byte, uint32, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	elem, ok := c.items[key]
+	if !ok {
+		return nil, 0, false
+	}
+	entry := elem.Value.(*valueEntry)
+	if time.Now().After(entry.expires) {
+		c.ll.Remove(elem)
+		delete(c.items, key)
+		return nil, 0, false
+	}
+	c.ll.MoveToFront(elem)
+	return append([]byte(nil), entry.value...), entry.flags, true
+}
+
+// snapshot 返回当前的代数，传给之后的 add
+func (c *valueCache) snapshot() uint64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.generation
+}
+
+// add 缓存 key 的值和标志，代数已经不是 generation 时不缓存；超出容量时淘汰最久未使用的条目
+func (c *valueCache) add(key string, value []byte, flags uint32, generation uint64) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.generation != generation {
+		return
+	}
+	entry := &valueEntry{key: key, value: append([]byte(nil), value...), flags: flags, expires: time.Now().Add(c.ttl)}
+	if elem, ok := c.items[key]; ok {
+		elem.Value = entry
+		c.ll.MoveToFront(elem)
+		return
+	}
+
+	c.items[key] = c.ll.PushFront(entry)
+	if c.ll.Len() > c.capacity {
+		oldest := c.ll.Back()
+		c.ll.Remove(oldest)
+		delete(c.items, oldest.Value.(*valueEntry).key)
+	}
+}
+
+// invalidate 删除 key 的缓存，并使正在进行的 Get 不再缓存它们的结果
+func (c *valueCache) invalidate(key string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.generation++
+	if elem, ok := c.items[key]; ok {
+		c.ll.Remove(elem)
+		delete(c.items, key)
+	}
+}
+
+// WithLocalCache 返回在进程内缓存 Get 结果的客户端，最多缓存 size 个 key，每个在缓存 ttl 之后过期。
+// 命中缓存的 Get 不访问节点，适合反复读取相同 key 的读多写少的场景；不存在的 key 不被缓存。
+//
+// 返回的客户端的 Set、Delete、Append、GetOrSet 和 SetStream 使同一个 key 的缓存失效，
+// 但其他客户端（包括没有共用这个缓存的客户端）的写入不会：在 ttl 之内可能读到旧值，
+// 需要读到最新值的场景不应使用缓存或应使用较短的 ttl。
+// 从返回的客户端派生的客户端（例如 WithTraceID）共用同一个缓存
+func (hc *HuaHuoLsmClient) WithLocalCache(size int, ttl time.Duration) *HuaHuoLsmClient {
+	cached := *hc
+	cached.cache = newValueCache(size, ttl)
+	return &cached
+}
+
+// invalidate 使 key 在本地缓存中的条目失效，没有启用缓存时什么也不做
+func (hc *HuaHuoLsmClient) invalidate(key string) {
+	if hc.cache != nil {
+		hc.cache.invalidate(key)
+	}
+}
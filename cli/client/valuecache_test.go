@@ -0,0 +1,85 @@
+package client
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestLocalCache(t *testing.T) {
+	LsmCliInit()
+	n := startFakeNode(t)
+	go n.serve()
+	addr := n.listener.Addr().String()
+	if err := HuaHuoLsmCli.addNode(addr); err != nil {
+		t.Fatal(err)
+	}
+	defer HuaHuoLsmCli.removeNode(addr)
+
+	cached := HuaHuoLsmCli.WithLocalCache(2, time.Hour)
+	if err := cached.Set("key", []byte("v1")); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		value, err := cached.Get("key")
+		if err != nil || string(value) != "v1" {
+			t.Fatalf("expected v1, but got %q (%v)", value, err)
+		}
+	}
+	if gets := n.gets.Load(); gets != 1 {
+		t.Fatalf("expected cache hits to skip the node, but it got %d gets", gets)
+	}
+
+	// 本客户端的写入使缓存失效
+	if err := cached.Set("key", []byte("v2")); err != nil {
+		t.Fatal(err)
+	}
+	if value, err := cached.Get("key"); err != nil || string(value) != "v2" {
+		t.Fatalf("expected v2 after the set, but got %q (%v)", value, err)
+	}
+	if err := cached.Delete("key"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cached.Get("key"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected %v after the delete, but got %v", ErrNotFound, err)
+	}
+	if gets := n.gets.Load(); gets != 3 {
+		t.Fatalf("expected the writes to invalidate the entry, but the node got %d gets", gets)
+	}
+
+	// 其他客户端的写入在过期之前读不到
+	if err := cached.Set("key", []byte("v3")); err != nil {
+		t.Fatal(err)
+	}
+	cached.Get("key")
+	if err := HuaHuoLsmCli.Set("key", []byte("other")); err != nil {
+		t.Fatal(err)
+	}
+	if value, _ := cached.Get("key"); string(value) != "v3" {
+		t.Fatalf("expected the stale v3 from the cache, but got %q", value)
+	}
+}
+
+func TestValueCacheExpiresAndEvicts(t *testing.T) {
+	c := newValueCache(2, 50*time.Millisecond)
+	c.add("a", []byte("1"), 0, c.snapshot())
+	c.add("b", []byte("2"), 0, c.snapshot())
+	c.get("a")
+	c.add("c", []byte("3"), 0, c.snapshot())
+	if _, _, ok := c.get("b"); ok {
+		t.Fatal("expected the least recently used entry to be evicted")
+	}
+
+	// 失效之前开始的读取不缓存结果
+	generation := c.snapshot()
+	c.invalidate("d")
+	c.add("d", []byte("stale"), 0, generation)
+	if _, _, ok := c.get("d"); ok {
+		t.Fatal("expected a read that raced an invalidation not to be cached")
+	}
+
+	time.Sleep(60 * time.Millisecond)
+	if _, _, ok := c.get("a"); ok {
+		t.Fatal("expected the entry to expire")
+	}
+}
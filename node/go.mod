@@ -6,6 +6,7 @@ toolchain go1.24.0
 
 require (
 	github.com/bytedance/sonic v1.12.9
+	github.com/klauspost/compress v1.17.11
 	github.com/panjf2000/gnet/v2 v2.7.2
 	go.etcd.io/etcd/client/v3 v3.5.18
 )
@@ -2,15 +2,47 @@ package etcd
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	clientv3 "go.etcd.io/etcd/client/v3"
 	"log"
+	"math/rand"
+	"strconv"
+	"sync"
 	"time"
 )
 
+// etcd 请求的超时时间，etcd 不可用时注册在超时后失败而不是一直阻塞
+const requestTimeout = 5 * time.Second
+
+// 续约中断后重新注册的退避：第 n 次重试前等待 reconnectBaseDelay * 2^n，不超过 reconnectMaxDelay，
+// 实际等待在 [delay/2, delay] 内随机，许多节点同时与 etcd 断开时不会在同一时刻一起重连
+const (
+	reconnectBaseDelay = 500 * time.Millisecond
+	reconnectMaxDelay  = 30 * time.Second
+)
+
+// FullWeight 是完成预热、可以承担全部流量的节点的权重。
+// 注册的值是十进制的权重，客户端按权重分配节点在哈希环上的虚拟节点，权重越低分到的请求越少
+const FullWeight = 100
+
 type RegistryClient struct {
 	client *clientv3.Client
+	kv     clientv3.KV
 	lease  clientv3.Lease
+
+	// 保护注册的信息，续约中断后后台重新注册时会更新租约
+	mu sync.Mutex
+	// 注册的地址、权重和租约，Register 成功之后才有效
+	ip      string
+	weight  int
+	leaseID clientv3.LeaseID
+
+	// Close 时取消，停止续约和重新注册
+	ctx    context.Context
+	cancel context.CancelFunc
+	// 重新注册前等待 d，ctx 取消时返回 false，测试中替换为不真正等待的实现
+	wait func(ctx context.Context, d time.Duration) bool
 }
 
 func NewRegistryClient(endpoints []string) (*RegistryClient, error) {
@@ -22,49 +54,164 @@ func NewRegistryClient(endpoints []string) (*RegistryClient, error) {
 		return nil, err
 	}
 
+	return newRegistryClient(client, client, clientv3.NewLease(client)), nil
+}
+
+// newRegistryClient 用给定的 KV 和租约接口创建客户端，client 只用于 Close，可以为 nil
+func newRegistryClient(client *clientv3.Client, kv clientv3.KV, lease clientv3.Lease) *RegistryClient {
+	ctx, cancel := context.WithCancel(context.Background())
 	return &RegistryClient{
 		client: client,
-		lease:  clientv3.NewLease(client),
-	}, nil
+		kv:     kv,
+		lease:  lease,
+		ctx:    ctx,
+		cancel: cancel,
+		wait:   waitFor,
+	}
+}
+
+// Register 以 weight 为权重注册节点地址 ip，之后可以用 SetWeight 调整权重。
+// 续约中断（例如与 etcd 的连接断开）后在后台按指数退避重新注册，直到 Close
+func (rc *RegistryClient) Register(ip string, weight int) error {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
+
+	leaseID, err := rc.register(ip, weight)
+	if err != nil {
+		return err
+	}
+	rc.ip, rc.weight, rc.leaseID = ip, weight, leaseID
+	// 启动自动续约
+	go rc.keepAlive(leaseID)
+
+	return nil
 }
 
-func (rc *RegistryClient) Register(ip string) error {
-	ctx := context.Background()
+// register 创建租约并在租约下写入地址的权重
+func (rc *RegistryClient) register(ip string, weight int) (clientv3.LeaseID, error) {
+	ctx, cancel := context.WithTimeout(rc.ctx, requestTimeout)
+	defer cancel()
 
 	// 创建租约
 	leaseResp, err := rc.lease.Grant(ctx, 5)
 	if err != nil {
-		return err
+		return 0, err
 	}
 
-	// 存储字符串
-	key := fmt.Sprintf("/registry/ips/%s", ip)
-	_, err = rc.client.Put(ctx, key, time.Now().String(), clientv3.WithLease(leaseResp.ID))
+	// 存储权重
+	_, err = rc.kv.Put(ctx, registryKey(ip), strconv.Itoa(weight), clientv3.WithLease(leaseResp.ID))
 	if err != nil {
-		return err
+		return 0, err
 	}
-	// 启动自动续约
-	go rc.keepAlive(leaseResp.ID)
+	return leaseResp.ID, nil
+}
+
+// SetWeight 修改已注册节点的权重，例如预热完成后提高到 FullWeight
+func (rc *RegistryClient) SetWeight(weight int) error {
+	rc.mu.Lock()
+	defer rc.mu.Unlock()
 
+	if rc.ip == "" {
+		return errors.New("node is not registered")
+	}
+
+	ctx, cancel := context.WithTimeout(rc.ctx, requestTimeout)
+	defer cancel()
+	if _, err := rc.kv.Put(ctx, registryKey(rc.ip), strconv.Itoa(weight), clientv3.WithLease(rc.leaseID)); err != nil {
+		return err
+	}
+	rc.weight = weight
 	return nil
 }
 
-func (rc *RegistryClient) keepAlive(leaseID clientv3.LeaseID) {
-	ctx := context.Background()
-	ch, err := rc.lease.KeepAlive(ctx, leaseID)
-	if err != nil {
-		log.Printf("Failed to keep alive lease %d: %v", leaseID, err)
-		return
+// registryKey 返回节点地址在 etcd 中的键
+func registryKey(ip string) string {
+	return fmt.Sprintf("/registry/ips/%s", ip)
+}
+
+// Close 停止续约并关闭与 etcd 的连接
+func (rc *RegistryClient) Close() error {
+	rc.cancel()
+	if rc.client == nil {
+		return nil
 	}
+	return rc.client.Close()
+}
 
+// keepAlive 为租约自动续约。续约的通道关闭时按 reconnectDelay 退避重新注册，
+// 成功后继续为新的租约续约；重新注册后收到过续约响应才重置退避，反复断开的连接不会被频繁重试
+func (rc *RegistryClient) keepAlive(leaseID clientv3.LeaseID) {
+	attempt := 0
 	for {
-		select {
-		case _, ok := <-ch:
-			fmt.Println("[keep alive] " + time.Now().String())
-			if !ok {
-				log.Printf("Keep alive channel closed for lease %d", leaseID)
+		ch, err := rc.lease.KeepAlive(rc.ctx, leaseID)
+		if err != nil {
+			log.Printf("Failed to keep alive lease %d: %v", leaseID, err)
+		} else {
+			for range ch {
+				fmt.Println("[keep alive] " + time.Now().String())
+				attempt = 0
+			}
+			if rc.ctx.Err() != nil {
 				return
 			}
+			log.Printf("Keep alive channel closed for lease %d", leaseID)
+		}
+
+		var ok bool
+		if leaseID, ok = rc.reconnect(&attempt); !ok {
+			return
 		}
 	}
 }
+
+// reconnect 按退避重新注册，直到成功或 Close，attempt 是连续重试的次数
+func (rc *RegistryClient) reconnect(attempt *int) (clientv3.LeaseID, bool) {
+	for {
+		delay := reconnectDelay(*attempt, rand.Float64)
+		*attempt++
+		rc.mu.Lock()
+		ip := rc.ip
+		rc.mu.Unlock()
+		log.Printf("Re-registering %s in %s (attempt %d)", ip, delay, *attempt)
+		if !rc.wait(rc.ctx, delay) {
+			return 0, false
+		}
+
+		// 持有锁直到更新租约，SetWeight 不会写入即将失效的旧租约
+		rc.mu.Lock()
+		leaseID, err := rc.register(rc.ip, rc.weight)
+		if err == nil {
+			rc.leaseID = leaseID
+		}
+		rc.mu.Unlock()
+		if err == nil {
+			log.Printf("Re-registered %s with lease %d", ip, leaseID)
+			return leaseID, true
+		}
+		if rc.ctx.Err() != nil {
+			return 0, false
+		}
+		log.Printf("Failed to re-register %s: %v", ip, err)
+	}
+}
+
+// reconnectDelay 返回第 attempt 次（从 0 开始）重试前的等待时间，random 返回 [0, 1) 内的随机数
+func reconnectDelay(attempt int, random func() float64) time.Duration {
+	delay := reconnectMaxDelay
+	if attempt < 32 {
+		delay = min(reconnectBaseDelay<<attempt, reconnectMaxDelay)
+	}
+	return delay/2 + time.Duration(random()*float64(delay/2))
+}
+
+// waitFor 等待 d，ctx 取消时提前返回 false
+func waitFor(ctx context.Context, d time.Duration) bool {
+	timer := time.NewTimer(d)
+	defer timer.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
@@ -0,0 +1,147 @@
+package etcd
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	clientv3 "go.etcd.io/etcd/client/v3"
+)
+
+// mockLease 的续约通道总是立即关闭，模拟反复断开的连接。healthy 中的租约在关闭前先收到一次续约响应
+type mockLease struct {
+	clientv3.Lease
+
+	mu      sync.Mutex
+	granted clientv3.LeaseID
+	healthy map[clientv3.LeaseID]bool
+}
+
+func (l *mockLease) Grant(ctx context.Context, ttl int64) (*clientv3.LeaseGrantResponse, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.granted++
+	return &clientv3.LeaseGrantResponse{ID: l.granted, TTL: ttl}, nil
+}
+
+func (l *mockLease) KeepAlive(ctx context.Context, id clientv3.LeaseID) (<-chan *clientv3.LeaseKeepAliveResponse, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	ch := make(chan *clientv3.LeaseKeepAliveResponse, 1)
+	if l.healthy[id] {
+		ch <- &clientv3.LeaseKeepAliveResponse{ID: id}
+	}
+	close(ch)
+	return ch, nil
+}
+
+// mockKV 记录每次 Put 的键、值和租约
+type mockKV struct {
+	clientv3.KV
+
+	mu   sync.Mutex
+	puts []string
+}
+
+func (kv *mockKV) Put(ctx context.Context, key, val string, opts ...clientv3.OpOption) (*clientv3.PutResponse, error) {
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	kv.puts = append(kv.puts, key+"="+val)
+	return &clientv3.PutResponse{}, nil
+}
+
+func TestReconnectDelay(t *testing.T) {
+	for attempt, want := range []time.Duration{500 * time.Millisecond, time.Second, 2 * time.Second, 4 * time.Second} {
+		if got := reconnectDelay(attempt, func() float64 { return 0 }); got != want/2 {
+			t.Errorf("attempt %d: expected the shortest delay %s, but got %s", attempt, want/2, got)
+		}
+		if got := reconnectDelay(attempt, func() float64 { return 1 }); got != want {
+			t.Errorf("attempt %d: expected the longest delay %s, but got %s", attempt, want, got)
+		}
+	}
+	for _, attempt := range []int{6, 10, 64, 1000} {
+		if got := reconnectDelay(attempt, func() float64 { return 1 }); got != reconnectMaxDelay {
+			t.Errorf("attempt %d: expected the delay to be capped at %s, but got %s", attempt, reconnectMaxDelay, got)
+		}
+	}
+}
+
+func TestKeepAliveReconnectsWithBackoff(t *testing.T) {
+	const retries = 12
+	lease := &mockLease{healthy: map[clientv3.LeaseID]bool{}}
+	kv := &mockKV{}
+	rc := newRegistryClient(nil, kv, lease)
+	defer rc.Close()
+
+	var delays []time.Duration
+	done := make(chan struct{})
+	rc.wait = func(ctx context.Context, d time.Duration) bool {
+		delays = append(delays, d)
+		if len(delays) == retries {
+			close(done)
+			return false
+		}
+		return true
+	}
+
+	if err := rc.Register("10.0.0.1:9000", 30); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-done:
+	case <-time.After(10 * time.Second):
+		t.Fatal("timed out waiting for the retries")
+	}
+
+	// 每次续约通道关闭后重新注册，退避随重试次数增长、有上限并且带有抖动
+	distinct := map[time.Duration]bool{}
+	for attempt, d := range delays {
+		upper := min(reconnectBaseDelay<<attempt, reconnectMaxDelay)
+		if d < upper/2 || d > upper {
+			t.Errorf("attempt %d: delay %s is outside [%s, %s]", attempt, d, upper/2, upper)
+		}
+		distinct[d] = true
+	}
+	if len(distinct) < retries/2 {
+		t.Errorf("expected jittered delays, but got %v", delays)
+	}
+
+	kv.mu.Lock()
+	defer kv.mu.Unlock()
+	// 第一次注册，以及除最后一次被取消的等待之外的每次重试
+	if len(kv.puts) != retries {
+		t.Fatalf("expected %d registrations, but got %d", retries, len(kv.puts))
+	}
+	for _, put := range kv.puts {
+		if put != registryKey("10.0.0.1:9000")+"=30" {
+			t.Fatalf("unexpected registration %s", put)
+		}
+	}
+}
+
+func TestKeepAliveResetsBackoffAfterResponse(t *testing.T) {
+	// 重新注册得到的租约收到续约响应后，下一次重试从最短的退避开始
+	lease := &mockLease{healthy: map[clientv3.LeaseID]bool{3: true}}
+	rc := newRegistryClient(nil, &mockKV{}, lease)
+	defer rc.Close()
+
+	var delays []time.Duration
+	done := make(chan struct{})
+	rc.wait = func(ctx context.Context, d time.Duration) bool {
+		delays = append(delays, d)
+		if len(delays) == 4 {
+			close(done)
+			return false
+		}
+		return true
+	}
+	if err := rc.Register("10.0.0.1:9000", FullWeight); err != nil {
+		t.Fatal(err)
+	}
+	<-done
+
+	if delays[1] < reconnectBaseDelay || delays[2] > reconnectBaseDelay || delays[3] < reconnectBaseDelay {
+		t.Fatalf("expected the backoff to reset after the healthy lease, but got %v", delays)
+	}
+}
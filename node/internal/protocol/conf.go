@@ -1,8 +1,43 @@
 package protocol
 
+import "time"
+
+// 值的大小有三层上限，从外到内依次为：
+//
+//	LIMIT_SIZE：单条消息（帧，不含 4 字节的长度头）的上限，也是服务端为每条消息预留的缓冲区大小。
+//	    值加上命令、键等字段必须放进一条消息，超过的 set 在读到命令和键后就返回 ValueTooLargeCode，
+//	    消息的其余部分到达时直接丢弃，不会被缓冲。
+//	storage.MaxValueSize：节点接受的最大值，通过 lsmtree.ValueSizeLimit 设置为引擎的上限，
+//	    大于 LIMIT_SIZE 的值只能通过 setchunk/setcommit 流式写入（详见 stream.go），
+//	    set 和 setchunk 在调用存储之前检查它，超过时同样返回 ValueTooLargeCode。
+//	lsmtree.MaxValueSizeLimit：引擎能设置的上限，storage.MaxValueSize 不能超过它。
 const (
 	MB                         = 1 << 20
 	GB                         = 1 << 30
 	HTTP_BODY_DEFAULT_MAX_SIZE = 32 * MB
 	LIMIT_SIZE                 = 15 * MB
 )
+
+// 读取超过 LIMIT_SIZE 的消息时，最多缓冲这么多字节用于解析命令和键
+const oversizedHeaderLimit = 64 * 1024
+
+// 超过该耗时的请求会连同追踪 ID 写入日志
+const slowRequestThreshold = 100 * time.Millisecond
+
+// 连接读写缓冲区的默认大小，大 value 较多时可以调大
+const defaultBufferCap = 2 * MB
+
+// 默认的 TCP keep-alive 间隔
+const defaultTCPKeepAlive = 5 * time.Minute
+
+// Shutdown 检查待写响应的间隔
+const shutdownPollInterval = 10 * time.Millisecond
+
+// 默认记住的幂等键数量
+const defaultIdempotencyCacheSize = 10000
+
+// getall 返回的键和值的总大小上限，加上长度前缀后仍需放进一条消息（LIMIT_SIZE）
+const getAllMaxSize = 8 * MB
+
+// 流式传输中每块的大小
+const streamChunkSize = 1 * MB
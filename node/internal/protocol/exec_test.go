@@ -0,0 +1,80 @@
+package protocol
+
+import (
+	"bytes"
+	"testing"
+)
+
+// encodeExec 编码 exec 的子命令，每个子命令是命令、键和值
+func encodeExec(t *testing.T, commands ...[3]string) []byte {
+	t.Helper()
+	buf := new(bytes.Buffer)
+	for _, cmd := range commands {
+		for _, field := range cmd {
+			if err := writeBytes(buf, []byte(field)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	return buf.Bytes()
+}
+
+// decodeExec 解码 exec 的响应，返回批量是否写入和每个子命令的错误信息
+func decodeExec(t *testing.T, res *BluebellResponse) (bool, []string) {
+	t.Helper()
+	if res.Code != SuccessCode || len(res.Result) == 0 {
+		t.Fatalf("unexpected response %+v", res)
+	}
+	var msgs []string
+	buf := bytes.NewReader(res.Result[1:])
+	for buf.Len() > 0 {
+		msg, err := readBytes(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		msgs = append(msgs, string(msg))
+	}
+	return res.Result[0] == 1, msgs
+}
+
+func TestExec(t *testing.T) {
+	server := NewBluebellServer("tcp", "127.0.0.1:0", false)
+	if res := server.handle(nil, &BluebellRequest{Command: "set", Key: "exec-old", Value: []byte("v")}); res.Code != SuccessCode {
+		t.Fatalf("unexpected response %+v", res)
+	}
+
+	// 有一个不合法的子命令时整个批量被拒绝
+	value := encodeExec(t, [3]string{"set", "exec-a", "1"}, [3]string{"del", "exec-old", ""}, [3]string{"set", "", "2"})
+	applied, msgs := decodeExec(t, server.handle(nil, &BluebellRequest{Command: "exec", Value: value}))
+	if applied || len(msgs) != 3 || msgs[0] != "" || msgs[1] != "" || msgs[2] == "" {
+		t.Fatalf("expected the batch to be rejected because of the empty key, but got %t %q", applied, msgs)
+	}
+	if res := server.handle(nil, &BluebellRequest{Command: "get", Key: "exec-old"}); res.Code != SuccessCode {
+		t.Fatalf("expected the rejected batch not to delete exec-old, but got %+v", res)
+	}
+
+	value = encodeExec(t, [3]string{"set", "exec-a", "1"}, [3]string{"del", "exec-old", ""}, [3]string{"set", "exec-b", "2"})
+	applied, msgs = decodeExec(t, server.handle(nil, &BluebellRequest{Command: "exec", Value: value}))
+	if !applied || len(msgs) != 3 || msgs[0] != "" || msgs[1] != "" || msgs[2] != "" {
+		t.Fatalf("expected the batch to be applied, but got %t %q", applied, msgs)
+	}
+	for key, expected := range map[string]string{"exec-a": "1", "exec-b": "2"} {
+		res := server.handle(nil, &BluebellRequest{Command: "get", Key: key})
+		if res.Code != SuccessCode || string(res.Result) != expected {
+			t.Fatalf("expected %s=%s, but got %+v", key, expected, res)
+		}
+	}
+	if res := server.handle(nil, &BluebellRequest{Command: "get", Key: "exec-old"}); res.Code != ErrorCode {
+		t.Fatalf("expected exec-old to be deleted, but got %+v", res)
+	}
+
+	// 不支持的子命令和截断的请求被拒绝
+	for _, value := range [][]byte{
+		encodeExec(t, [3]string{"append", "exec-a", "1"}),
+		value[:len(value)-1],
+	} {
+		if res := server.handle(nil, &BluebellRequest{Command: "exec", Value: value}); res.Code != ErrorCode {
+			t.Fatalf("expected an error, but got %+v", res)
+		}
+	}
+}
@@ -1,13 +1,20 @@
 package protocol
 
 import (
+	"bytes"
+	"errors"
 	"fmt"
+	"github.com/bytedance/sonic"
 	"github.com/huahuoao/lsm-core/internal/storage"
+	"github.com/huahuoao/lsm-core/internal/storage/engine/lsmtree"
+	"strconv"
 )
 
 const (
 	SuccessCode = "0"
 	ErrorCode   = "1"
+	// 值超过单条消息的上限或节点接受的最大值，Result 是错误信息，详见 conf.go 中 LIMIT_SIZE 的说明
+	ValueTooLargeCode = "2"
 )
 
 func newResponse(code string, result []byte) *BluebellResponse {
@@ -17,21 +24,332 @@ func newResponse(code string, result []byte) *BluebellResponse {
 	}
 }
 
+// errorResponse 返回写入失败的响应，值过大时使用 ValueTooLargeCode
+func errorResponse(err error) *BluebellResponse {
+	if errors.Is(err, lsmtree.ErrValueTooLarge) {
+		return newResponse(ValueTooLargeCode, []byte(err.Error()))
+	}
+	return newResponse(ErrorCode, []byte(err.Error()))
+}
+
+// checkValueSize 在调用存储之前检查值是否超过节点接受的最大值
+func checkValueSize(size int) error {
+	if size > storage.MaxValueSize {
+		return fmt.Errorf("%w: %d bytes exceeds the limit of %d bytes", lsmtree.ErrValueTooLarge, size, storage.MaxValueSize)
+	}
+	return nil
+}
+
 func HandleGet(request *BluebellRequest) *BluebellResponse {
 	client := storage.GetClient()
-	res, ok := client.Get([]byte(request.Key))
+	res, flags, ok, err := client.GetWithFlags([]byte(request.Key))
+	if err != nil {
+		return newResponse(ErrorCode, []byte(err.Error()))
+	}
 	if !ok {
 		return newResponse(ErrorCode, nil)
 	}
-	return newResponse(SuccessCode, res)
+	response := newResponse(SuccessCode, res)
+	response.Flags = flags
+	return response
 }
 
 func HandleSet(request *BluebellRequest) *BluebellResponse {
+	if err := checkValueSize(len(request.Value)); err != nil {
+		return errorResponse(err)
+	}
+	client := storage.GetClient()
+	err := client.PutWithFlags([]byte(request.Key), request.Value, request.Flags)
+	if err != nil {
+		return errorResponse(err)
+	}
+	return newResponse(SuccessCode, nil)
+}
+
+func HandleDel(request *BluebellRequest) *BluebellResponse {
+	client := storage.GetClient()
+	if err := client.Delete([]byte(request.Key)); err != nil {
+		return newResponse(ErrorCode, []byte(err.Error()))
+	}
+	return newResponse(SuccessCode, nil)
+}
+
+// HandleMSet 写入多个键值对，Value 依次是长度前缀的键和值。键值对在一次 WAL 写入中原子地写入，
+// 但节点有多个分片时只在每个分片内原子，详见 storage.Hbase.PutBatch。
+// 响应依次是每个键值对的长度前缀的错误信息，写入成功的键值对为空。
+func HandleMSet(request *BluebellRequest) *BluebellResponse {
+	var keys, values [][]byte
+	buf := bytes.NewReader(request.Value)
+	for buf.Len() > 0 {
+		key, err := readBytes(buf)
+		if err != nil {
+			return newResponse(ErrorCode, []byte("invalid mset pairs: "+err.Error()))
+		}
+		value, err := readBytes(buf)
+		if err != nil {
+			return newResponse(ErrorCode, []byte("invalid mset pairs: "+err.Error()))
+		}
+		keys, values = append(keys, key), append(values, value)
+	}
+
+	client := storage.GetClient()
+	errs, err := client.PutBatch(keys, values)
+	if err != nil {
+		return newResponse(ErrorCode, []byte(err.Error()))
+	}
+	res := new(bytes.Buffer)
+	for _, err := range errs {
+		var msg []byte
+		if err != nil {
+			msg = []byte(err.Error())
+		}
+		if err := writeBytes(res, msg); err != nil {
+			return newResponse(ErrorCode, []byte(err.Error()))
+		}
+	}
+	return newResponse(SuccessCode, res.Bytes())
+}
+
+// execCommands 是 exec 中可以使用的子命令
+var execCommands = map[string]bool{
+	"set": true,
+	"del": true,
+}
+
+// readExecCommand 从 exec 的请求中读取下一个子命令：长度前缀的命令、键和值，del 的值为空
+func readExecCommand(buf *bytes.Reader) (string, []byte, []byte, error) {
+	command, err := readBytes(buf)
+	if err != nil {
+		return "", nil, nil, err
+	}
+	if !execCommands[string(command)] {
+		return "", nil, nil, fmt.Errorf("unsupported command %q", command)
+	}
+	key, err := readBytes(buf)
+	if err != nil {
+		return "", nil, nil, err
+	}
+	value, err := readBytes(buf)
+	if err != nil {
+		return "", nil, nil, err
+	}
+	return string(command), key, value, nil
+}
+
+// HandleExec 把多个 set 和 del 子命令作为一个批量执行（MULTI/EXEC），Value 依次是每个子命令的长度前缀的命令、键和值。
+// 批量在一次 WAL 写入中全有或全无地写入，但节点有多个分片时只在每个分片内原子，详见 storage.Hbase.ApplyBatch。
+// 响应的第一个字节表示批量是否写入（1 为写入，0 为因为有不合法的子命令而被拒绝），
+// 之后依次是每个子命令的长度前缀的错误信息，没有错误的为空。
+func HandleExec(request *BluebellRequest) *BluebellResponse {
+	var keys, values [][]byte
+	buf := bytes.NewReader(request.Value)
+	for buf.Len() > 0 {
+		command, key, value, err := readExecCommand(buf)
+		if err != nil {
+			return newResponse(ErrorCode, []byte("invalid exec commands: "+err.Error()))
+		}
+		if command == "del" {
+			value = nil
+		}
+		keys, values = append(keys, key), append(values, value)
+	}
+
+	client := storage.GetClient()
+	errs, err := client.ApplyBatch(keys, values)
+	applied := byte(1)
+	if errors.Is(err, lsmtree.ErrBatchRejected) && errs != nil {
+		applied = 0
+	} else if err != nil {
+		return newResponse(ErrorCode, []byte(err.Error()))
+	}
+	res := bytes.NewBuffer([]byte{applied})
+	for _, err := range errs {
+		var msg []byte
+		if err != nil {
+			msg = []byte(err.Error())
+		}
+		if err := writeBytes(res, msg); err != nil {
+			return newResponse(ErrorCode, []byte(err.Error()))
+		}
+	}
+	return newResponse(SuccessCode, res.Bytes())
+}
+
+func HandleAppend(request *BluebellRequest) *BluebellResponse {
+	client := storage.GetClient()
+	value, err := client.Append([]byte(request.Key), request.Value)
+	if err != nil {
+		return errorResponse(err)
+	}
+	return newResponse(SuccessCode, value)
+}
+
+// HandleGetOrSet 在键存在时返回它当前的值，否则写入请求中的值。
+// 响应的第一个字节表示值是否已存在（1 为已存在，0 为本次写入），之后是键当前的值。
+func HandleGetOrSet(request *BluebellRequest) *BluebellResponse {
+	client := storage.GetClient()
+	value, loaded, err := client.GetOrSet([]byte(request.Key), request.Value)
+	if err != nil {
+		return errorResponse(err)
+	}
+	res := make([]byte, 1+len(value))
+	if loaded {
+		res[0] = 1
+	}
+	copy(res[1:], value)
+	return newResponse(SuccessCode, res)
+}
+
+// HandlePlanCompaction 是管理命令，以 JSON 返回每个分片下一次合并的计划，不执行合并。
+func HandlePlanCompaction(request *BluebellRequest) *BluebellResponse {
+	client := storage.GetClient()
+	plans, err := client.PlanCompaction()
+	if err != nil {
+		return newResponse(ErrorCode, []byte(err.Error()))
+	}
+	res, err := sonic.Marshal(plans)
+	if err != nil {
+		return newResponse(ErrorCode, []byte(err.Error()))
+	}
+	return newResponse(SuccessCode, res)
+}
+
+// HandleMajorCompact 是管理命令，把每个分片的所有磁盘表合并为一个并丢弃墓碑，合并完成后返回。
+func HandleMajorCompact(request *BluebellRequest) *BluebellResponse {
+	client := storage.GetClient()
+	if err := client.MajorCompact(); err != nil {
+		return newResponse(ErrorCode, []byte(err.Error()))
+	}
+	return newResponse(SuccessCode, nil)
+}
+
+// HandleSync 把节点上所有分片的 WAL 同步到磁盘，返回成功后之前确认的写入在节点崩溃后仍然存在。
+func HandleSync(request *BluebellRequest) *BluebellResponse {
+	client := storage.GetClient()
+	if err := client.Sync(); err != nil {
+		return newResponse(ErrorCode, []byte(err.Error()))
+	}
+	return newResponse(SuccessCode, nil)
+}
+
+// HandleBackgroundOps 是管理命令，以 JSON 返回每个分片正在进行的刷新、合并和备份，下标与分片一一对应。
+func HandleBackgroundOps(request *BluebellRequest) *BluebellResponse {
 	client := storage.GetClient()
-	err := client.Put([]byte(request.Key), request.Value)
+	ops, err := client.BackgroundOps()
+	if err != nil {
+		return newResponse(ErrorCode, []byte(err.Error()))
+	}
+	res, err := sonic.Marshal(ops)
+	if err != nil {
+		return newResponse(ErrorCode, []byte(err.Error()))
+	}
+	return newResponse(SuccessCode, res)
+}
+
+// HandleCancelOp 是管理命令，取消一个后台操作，Key 是分片的下标，Value 是 bgops 返回的操作编号，都是十进制数。
+// 返回时操作可能还没有结束。
+func HandleCancelOp(request *BluebellRequest) *BluebellResponse {
+	shard, err := strconv.Atoi(request.Key)
 	if err != nil {
+		return newResponse(ErrorCode, []byte(fmt.Sprintf("invalid shard %q", request.Key)))
+	}
+	id, err := strconv.ParseUint(string(request.Value), 10, 64)
+	if err != nil {
+		return newResponse(ErrorCode, []byte(fmt.Sprintf("invalid operation id %q", request.Value)))
+	}
+	client := storage.GetClient()
+	if err := client.CancelOp(shard, id); err != nil {
 		return newResponse(ErrorCode, []byte(err.Error()))
 	}
-	fmt.Println("set success")
 	return newResponse(SuccessCode, nil)
 }
+
+// HandleSnapshot 是管理命令，把存储备份到节点快照根目录下名为 Value 的子目录，Key 是协调者指定的快照 ID，
+// 以 JSON 返回快照的清单。Value 只是目录名，不能是路径，详见 storage.SnapshotDir。
+func HandleSnapshot(request *BluebellRequest) *BluebellResponse {
+	dir, err := storage.SnapshotDir(string(request.Value))
+	if err != nil {
+		return newResponse(ErrorCode, []byte(err.Error()))
+	}
+	client := storage.GetClient()
+	manifest, err := client.Snapshot(request.Key, dir)
+	if err != nil {
+		return newResponse(ErrorCode, []byte(err.Error()))
+	}
+	res, err := sonic.Marshal(manifest)
+	if err != nil {
+		return newResponse(ErrorCode, []byte(err.Error()))
+	}
+	return newResponse(SuccessCode, res)
+}
+
+// HandleEpoch 以 JSON 返回每个分片的纪元，客户端发现纪元变化时应丢弃缓存并重新路由。
+func HandleEpoch(request *BluebellRequest) *BluebellResponse {
+	client := storage.GetClient()
+	epochs, err := client.Epochs()
+	if err != nil {
+		return newResponse(ErrorCode, []byte(err.Error()))
+	}
+	res, err := sonic.Marshal(epochs)
+	if err != nil {
+		return newResponse(ErrorCode, []byte(err.Error()))
+	}
+	return newResponse(SuccessCode, res)
+}
+
+// HandleScan 返回本节点 [Key, Value) 范围内按键升序排列的键值对，
+// 空的边界表示不限制。结果依次编码为长度前缀的键和值。
+// Flags 是返回数量的上限，为 0 表示不限制，客户端用它分页读取大范围。
+func HandleScan(request *BluebellRequest) *BluebellResponse {
+	client := storage.GetClient()
+	kvs, err := client.Scan([]byte(request.Key), request.Value, int(request.Flags))
+	if err != nil {
+		return newResponse(ErrorCode, []byte(err.Error()))
+	}
+	return encodeKVs(kvs)
+}
+
+// HandleScanPrefix 返回本节点以 Key 为前缀的键值对，按键升序排列，编码方式与 HandleScan 相同。
+// Value 是十进制的数量上限，为空或 0 表示不限制。
+func HandleScanPrefix(request *BluebellRequest) *BluebellResponse {
+	limit := 0
+	if len(request.Value) > 0 {
+		var err error
+		limit, err = strconv.Atoi(string(request.Value))
+		if err != nil {
+			return newResponse(ErrorCode, []byte("invalid limit "+string(request.Value)))
+		}
+	}
+
+	client := storage.GetClient()
+	kvs, err := client.ScanPrefix([]byte(request.Key), limit)
+	if err != nil {
+		return newResponse(ErrorCode, []byte(err.Error()))
+	}
+	return encodeKVs(kvs)
+}
+
+// HandleGetAll 返回本节点以 Key 为前缀的全部键值对（Key 为空时返回全部），按键升序排列，编码方式与 HandleScan 相同。
+// 只适用于小数据集，键和值的总大小超过 getAllMaxSize 时拒绝请求。
+func HandleGetAll(request *BluebellRequest) *BluebellResponse {
+	client := storage.GetClient()
+	kvs, err := client.GetAll([]byte(request.Key), getAllMaxSize)
+	if err != nil {
+		return newResponse(ErrorCode, []byte(err.Error()))
+	}
+	return encodeKVs(kvs)
+}
+
+// encodeKVs 把键值对依次编码为长度前缀的键和值。
+func encodeKVs(kvs []storage.KV) *BluebellResponse {
+	buf := new(bytes.Buffer)
+	for _, kv := range kvs {
+		if err := writeBytes(buf, kv.Key); err != nil {
+			return newResponse(ErrorCode, []byte(err.Error()))
+		}
+		if err := writeBytes(buf, kv.Value); err != nil {
+			return newResponse(ErrorCode, []byte(err.Error()))
+		}
+	}
+	return newResponse(SuccessCode, buf.Bytes())
+}
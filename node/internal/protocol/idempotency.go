@@ -0,0 +1,80 @@
+package protocol
+
+import (
+	"container/list"
+	"sync"
+)
+
+// idempotentCommands 是支持幂等键的写命令。set 重复执行本身无害，
+// 但 append 重复执行会多追加一次，getorset 重试时应返回第一次的 loaded
+var idempotentCommands = map[string]bool{
+	"set":      true,
+	"append":   true,
+	"getorset": true,
+}
+
+// opCache 记住最近执行过的带幂等键的写请求的响应，容量有限，淘汰最久未使用的条目。
+// 客户端超时后用同一个幂等键重试时，节点返回第一次执行的响应，而不会再次执行
+type opCache struct {
+	mu       sync.Mutex
+	capacity int
+	ll       *list.List
+	items    map[string]*list.Element
+}
+
+type opEntry struct {
+	opID string
+	// 第一次执行完成后关闭，之后 res 不再改变
+	done chan struct{}
+	res  *BluebellResponse
+}
+
+func newOpCache(capacity int) *opCache {
+	return &opCache{
+		capacity: capacity,
+		ll:       list.New(),
+		items:    make(map[string]*list.Element),
+	}
+}
+
+// do 执行幂等键为 opID 的请求。同一个幂等键的请求已经执行过或正在执行时，
+// 等待第一次执行完成并返回它的响应，否则调用 fn 执行。
+// 失败的响应不会被记住，重试时会重新执行
+func (c *opCache) do(opID string, fn func() *BluebellResponse) *BluebellResponse {
+	c.mu.Lock()
+	if elem, ok := c.items[opID]; ok {
+		c.ll.MoveToFront(elem)
+		entry := elem.Value.(*opEntry)
+		c.mu.Unlock()
+		<-entry.done
+		return entry.response()
+	}
+	entry := &opEntry{opID: opID, done: make(chan struct{})}
+	elem := c.ll.PushFront(entry)
+	c.items[opID] = elem
+	for c.ll.Len() > c.capacity {
+		oldest := c.ll.Back()
+		c.ll.Remove(oldest)
+		delete(c.items, oldest.Value.(*opEntry).opID)
+	}
+	c.mu.Unlock()
+
+	entry.res = fn()
+	close(entry.done)
+
+	if entry.res.Code == ErrorCode {
+		c.mu.Lock()
+		if c.items[opID] == elem {
+			c.ll.Remove(elem)
+			delete(c.items, opID)
+		}
+		c.mu.Unlock()
+	}
+	return entry.response()
+}
+
+// response 返回响应的副本，调用方会修改其中的追踪 ID
+func (e *opEntry) response() *BluebellResponse {
+	res := *e.res
+	return &res
+}
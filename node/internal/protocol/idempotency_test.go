@@ -0,0 +1,109 @@
+package protocol
+
+import (
+	"bytes"
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestIdempotentAppend(t *testing.T) {
+	server := NewBluebellServer("tcp", "127.0.0.1:0", false)
+
+	request := &BluebellRequest{Command: "append", Key: "idempotent-append", Value: []byte("a"), OpID: "op-1"}
+	frame, err := request.Encode()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	decoded, err := Deserialize(readFrame(t, bytes.NewReader(frame)))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if decoded.OpID != "op-1" {
+		t.Fatalf("expected op id op-1, but got %q", decoded.OpID)
+	}
+
+	// 客户端超时后用同一个幂等键重试，只追加一次
+	for i := 0; i < 3; i++ {
+		decoded.TraceID = fmt.Sprintf("trace-%d", i)
+		res := server.handle(nil, decoded)
+		if res.Code != SuccessCode || string(res.Result) != "a" {
+			t.Fatalf("retry %d: expected the first result, but got %+v", i, res)
+		}
+		if res.TraceID != decoded.TraceID {
+			t.Fatalf("retry %d: expected trace id %s, but got %q", i, decoded.TraceID, res.TraceID)
+		}
+	}
+
+	// 新的幂等键或没有幂等键的请求照常执行
+	res := server.handle(nil, &BluebellRequest{Command: "append", Key: "idempotent-append", Value: []byte("b"), OpID: "op-2"})
+	if string(res.Result) != "ab" {
+		t.Fatalf("expected ab, but got %+v", res)
+	}
+	res = server.handle(nil, &BluebellRequest{Command: "append", Key: "idempotent-append", Value: []byte("c")})
+	if string(res.Result) != "abc" {
+		t.Fatalf("expected abc, but got %+v", res)
+	}
+
+	// 关闭去重后每次重试都会执行
+	server = NewBluebellServer("tcp", "127.0.0.1:0", false, WithIdempotencyCache(0))
+	for i := 0; i < 2; i++ {
+		server.handle(nil, &BluebellRequest{Command: "append", Key: "idempotent-append", Value: []byte("d"), OpID: "op-3"})
+	}
+	res = server.handle(nil, &BluebellRequest{Command: "get", Key: "idempotent-append"})
+	if string(res.Result) != "abcdd" {
+		t.Fatalf("expected abcdd, but got %+v", res)
+	}
+}
+
+func TestOpCache(t *testing.T) {
+	cache := newOpCache(2)
+
+	// 并发的重试等待第一次执行完成，只执行一次
+	var calls int32
+	release := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			res := cache.do("op", func() *BluebellResponse {
+				atomic.AddInt32(&calls, 1)
+				<-release
+				return newResponse(SuccessCode, []byte("done"))
+			})
+			if string(res.Result) != "done" {
+				t.Errorf("unexpected response %+v", res)
+			}
+		}()
+	}
+	close(release)
+	wg.Wait()
+	if calls != 1 {
+		t.Fatalf("expected one execution, but got %d", calls)
+	}
+
+	// 失败的响应不被记住
+	fail := func() *BluebellResponse {
+		atomic.AddInt32(&calls, 1)
+		return newResponse(ErrorCode, []byte("failed"))
+	}
+	cache.do("failing", fail)
+	cache.do("failing", fail)
+	if calls != 3 {
+		t.Fatalf("expected failed requests to be executed again, but got %d executions", calls)
+	}
+
+	// 超出容量时淘汰最久未使用的幂等键
+	succeed := func() *BluebellResponse {
+		atomic.AddInt32(&calls, 1)
+		return newResponse(SuccessCode, nil)
+	}
+	cache.do("a", succeed)
+	cache.do("b", succeed)
+	cache.do("op", succeed)
+	if calls != 6 {
+		t.Fatalf("expected op to be evicted, but got %d executions", calls)
+	}
+}
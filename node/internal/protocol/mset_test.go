@@ -0,0 +1,48 @@
+package protocol
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestMSet(t *testing.T) {
+	server := NewBluebellServer("tcp", "127.0.0.1:0", false)
+
+	pairs := new(bytes.Buffer)
+	for _, kv := range [][2]string{{"mset-a", "1"}, {"", "2"}, {"mset-b", "3"}} {
+		_ = writeBytes(pairs, []byte(kv[0]))
+		_ = writeBytes(pairs, []byte(kv[1]))
+	}
+	res := server.handle(nil, &BluebellRequest{Command: "mset", Value: pairs.Bytes()})
+	if res.Code != SuccessCode {
+		t.Fatalf("expected success, but got %+v", res)
+	}
+
+	// 每个键值对一个错误信息，成功的为空
+	result := bytes.NewReader(res.Result)
+	for i, failed := range []bool{false, true, false} {
+		msg, err := readBytes(result)
+		if err != nil {
+			t.Fatalf("pair %d: %s", i, err)
+		}
+		if (len(msg) > 0) != failed {
+			t.Fatalf("pair %d: unexpected error %q", i, msg)
+		}
+	}
+	if result.Len() != 0 {
+		t.Fatalf("expected 3 results, but %d bytes remain", result.Len())
+	}
+
+	for key, expected := range map[string]string{"mset-a": "1", "mset-b": "3"} {
+		res := server.handle(nil, &BluebellRequest{Command: "get", Key: key})
+		if res.Code != SuccessCode || string(res.Result) != expected {
+			t.Fatalf("expected %s=%s, but got %+v", key, expected, res)
+		}
+	}
+
+	// 截断的请求被拒绝
+	res = server.handle(nil, &BluebellRequest{Command: "mset", Value: pairs.Bytes()[:pairs.Len()-1]})
+	if res.Code != ErrorCode {
+		t.Fatalf("expected an error for truncated pairs, but got %+v", res)
+	}
+}
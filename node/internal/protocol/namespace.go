@@ -0,0 +1,165 @@
+package protocol
+
+import (
+	"bytes"
+	"strings"
+)
+
+// 命名空间让多个租户共用一个集群而互不可见：连接用 namespace 命令（Key 是命名空间）设置命名空间后，
+// 服务端在每个键前加上 "<命名空间>:" 再交给存储，返回的键去掉这个前缀，客户端看到的是干净的键空间。
+// scan、scanprefix 和 getall 只返回命名空间内的键，幂等键同样按命名空间隔离。
+//
+// 节点没有认证，命名空间由连接自己声明：每个连接只能设置一次，之后不能更改或清除，
+// 但没有设置命名空间的连接仍然可以看到所有租户的键（带前缀）。管理命令不受命名空间影响。
+
+// namespaceSeparator 分隔命名空间和键，命名空间中不能包含它，
+// 否则命名空间 "a" 中的键 "b:x" 会与命名空间 "a:b" 中的键 "x" 相同
+const namespaceSeparator = ":"
+
+// namespacedKeyCommands 是 Key 为单个键的命令
+var namespacedKeyCommands = map[string]bool{
+	"get":        true,
+	"set":        true,
+	"del":        true,
+	"append":     true,
+	"getorset":   true,
+	"scanprefix": true,
+	"getstream":  true,
+	"setchunk":   true,
+	"setcommit":  true,
+}
+
+// HandleNamespace 设置连接的命名空间，每个连接只能设置一次
+func HandleNamespace(st *streamState, request *BluebellRequest) *BluebellResponse {
+	if st == nil {
+		return newResponse(ErrorCode, []byte("namespace requires a connection"))
+	}
+	if request.Key == "" || strings.Contains(request.Key, namespaceSeparator) {
+		return newResponse(ErrorCode, []byte("namespace must be non-empty and must not contain "+namespaceSeparator))
+	}
+	if st.namespace != "" {
+		return newResponse(ErrorCode, []byte("namespace is already set to "+st.namespace))
+	}
+	st.namespace = request.Key
+	return newResponse(SuccessCode, nil)
+}
+
+// inNamespace 返回在命名空间 ns 中执行的请求：键加上命名空间的前缀，scan 的范围限制在命名空间内
+func inNamespace(ns string, request *BluebellRequest) (*BluebellRequest, error) {
+	prefix := ns + namespaceSeparator
+	scoped := *request
+	if scoped.OpID != "" {
+		scoped.OpID = prefix + scoped.OpID
+	}
+
+	switch {
+	case namespacedKeyCommands[request.Command]:
+		// 空的键仍然由存储拒绝，不能变成命名空间前缀本身；scanprefix 的空前缀表示整个命名空间
+		if request.Key != "" || request.Command == "scanprefix" {
+			scoped.Key = prefix + request.Key
+		}
+	case request.Command == "mset":
+		value, err := prefixPairs(prefix, request.Value)
+		if err != nil {
+			return nil, err
+		}
+		scoped.Value = value
+	case request.Command == "exec":
+		value, err := prefixExecCommands(prefix, request.Value)
+		if err != nil {
+			return nil, err
+		}
+		scoped.Value = value
+	case request.Command == "scan":
+		// 空的结束边界表示命名空间的末尾
+		scoped.Key = prefix + request.Key
+		if len(request.Value) == 0 {
+			scoped.Value = prefixEnd(prefix)
+		} else {
+			scoped.Value = append([]byte(prefix), request.Value...)
+		}
+	case request.Command == "getall":
+		scoped.Key = prefix
+	}
+	return &scoped, nil
+}
+
+// prefixEnd 返回大于所有以 prefix 开头的键的最小键。prefix 以分隔符结尾，不会全部由 0xff 组成
+func prefixEnd(prefix string) []byte {
+	end := []byte(prefix)
+	end[len(end)-1]++
+	return end
+}
+
+// prefixPairs 给 mset 的每个键加上前缀
+func prefixPairs(prefix string, pairs []byte) ([]byte, error) {
+	buf := bytes.NewReader(pairs)
+	out := new(bytes.Buffer)
+	for buf.Len() > 0 {
+		key, err := readBytes(buf)
+		if err != nil {
+			return nil, err
+		}
+		value, err := readBytes(buf)
+		if err != nil {
+			return nil, err
+		}
+		if err := writeBytes(out, append([]byte(prefix), key...)); err != nil {
+			return nil, err
+		}
+		if err := writeBytes(out, value); err != nil {
+			return nil, err
+		}
+	}
+	return out.Bytes(), nil
+}
+
+// stripNamespace 去掉 scan、scanprefix 和 getall 成功响应中每个键的命名空间前缀
+func stripNamespace(ns string, command string, res *BluebellResponse) *BluebellResponse {
+	if res.Code != SuccessCode || (command != "scan" && command != "scanprefix" && command != "getall") {
+		return res
+	}
+	prefix := []byte(ns + namespaceSeparator)
+	buf := bytes.NewReader(res.Result)
+	out := new(bytes.Buffer)
+	for buf.Len() > 0 {
+		key, err := readBytes(buf)
+		if err != nil {
+			return newResponse(ErrorCode, []byte(err.Error()))
+		}
+		value, err := readBytes(buf)
+		if err != nil {
+			return newResponse(ErrorCode, []byte(err.Error()))
+		}
+		if err := writeBytes(out, bytes.TrimPrefix(key, prefix)); err != nil {
+			return newResponse(ErrorCode, []byte(err.Error()))
+		}
+		if err := writeBytes(out, value); err != nil {
+			return newResponse(ErrorCode, []byte(err.Error()))
+		}
+	}
+	res.Result = out.Bytes()
+	return res
+}
+
+// prefixExecCommands 给 exec 的每个子命令的键加上前缀
+func prefixExecCommands(prefix string, commands []byte) ([]byte, error) {
+	buf := bytes.NewReader(commands)
+	out := new(bytes.Buffer)
+	for buf.Len() > 0 {
+		command, key, value, err := readExecCommand(buf)
+		if err != nil {
+			return nil, err
+		}
+		// 空的键仍然由存储拒绝，不能变成命名空间前缀本身
+		if len(key) > 0 {
+			key = append([]byte(prefix), key...)
+		}
+		for _, field := range [][]byte{[]byte(command), key, value} {
+			if err := writeBytes(out, field); err != nil {
+				return nil, err
+			}
+		}
+	}
+	return out.Bytes(), nil
+}
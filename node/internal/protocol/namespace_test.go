@@ -0,0 +1,101 @@
+package protocol
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+)
+
+// decodeKVs 解码 scan、scanprefix 和 getall 的响应，返回 "键=值" 的列表
+func decodeKVs(t *testing.T, res *BluebellResponse) []string {
+	t.Helper()
+	if res.Code != SuccessCode {
+		t.Fatalf("unexpected response %+v", res)
+	}
+	var kvs []string
+	buf := bytes.NewReader(res.Result)
+	for buf.Len() > 0 {
+		key, err := readBytes(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		value, err := readBytes(buf)
+		if err != nil {
+			t.Fatal(err)
+		}
+		kvs = append(kvs, string(key)+"="+string(value))
+	}
+	return kvs
+}
+
+func TestNamespaceIsolation(t *testing.T) {
+	server := NewBluebellServer("tcp", "127.0.0.1:0", false)
+	a, b, plain := &streamState{}, &streamState{}, &streamState{}
+	for st, ns := range map[*streamState]string{a: "ns-tenant-a", b: "ns-tenant-b"} {
+		if res := server.handle(st, &BluebellRequest{Command: "namespace", Key: ns}); res.Code != SuccessCode {
+			t.Fatalf("failed to set namespace %s: %+v", ns, res)
+		}
+	}
+	if res := server.handle(a, &BluebellRequest{Command: "namespace", Key: "ns-other"}); res.Code != ErrorCode {
+		t.Fatal("expected the namespace not to be changed once set")
+	}
+	if res := server.handle(plain, &BluebellRequest{Command: "namespace", Key: "ns:bad"}); res.Code != ErrorCode || plain.namespace != "" {
+		t.Fatal("expected a namespace containing the separator to be rejected")
+	}
+
+	// 两个命名空间写入同一个逻辑键
+	for st, value := range map[*streamState]string{a: "from-a", b: "from-b"} {
+		if res := server.handle(st, &BluebellRequest{Command: "set", Key: "shared", Value: []byte(value)}); res.Code != SuccessCode {
+			t.Fatalf("unexpected response %+v", res)
+		}
+	}
+	pairs := new(bytes.Buffer)
+	for _, s := range []string{"x", "1", "y", "2"} {
+		if err := writeBytes(pairs, []byte(s)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if res := server.handle(a, &BluebellRequest{Command: "mset", Value: pairs.Bytes()}); res.Code != SuccessCode {
+		t.Fatalf("unexpected response %+v", res)
+	}
+
+	if res := server.handle(a, &BluebellRequest{Command: "get", Key: "shared"}); string(res.Result) != "from-a" {
+		t.Fatalf("expected from-a, but got %+v", res)
+	}
+	if res := server.handle(b, &BluebellRequest{Command: "get", Key: "shared"}); string(res.Result) != "from-b" {
+		t.Fatalf("expected from-b, but got %+v", res)
+	}
+	if res := server.handle(b, &BluebellRequest{Command: "get", Key: "x"}); res.Code != ErrorCode {
+		t.Fatalf("expected x to be invisible in the other namespace, but got %+v", res)
+	}
+	// 没有设置命名空间的连接看到带前缀的键
+	if res := server.handle(plain, &BluebellRequest{Command: "get", Key: "ns-tenant-a:shared"}); string(res.Result) != "from-a" {
+		t.Fatalf("expected the prefixed key in storage, but got %+v", res)
+	}
+
+	// 扫描限制在命名空间内，返回的键去掉前缀
+	want := "[shared=from-a x=1 y=2]"
+	for _, request := range []*BluebellRequest{
+		{Command: "scan"},
+		{Command: "scanprefix"},
+		{Command: "getall"},
+	} {
+		if got := decodeKVs(t, server.handle(a, request)); fmt.Sprint(got) != want {
+			t.Errorf("%s: expected %s, but got %s", request.Command, want, fmt.Sprint(got))
+		}
+	}
+	if got := decodeKVs(t, server.handle(a, &BluebellRequest{Command: "scan", Key: "t", Value: []byte("y")})); fmt.Sprint(got) != "[x=1]" {
+		t.Errorf("expected the bounded scan to return x, but got %s", fmt.Sprint(got))
+	}
+	if got := decodeKVs(t, server.handle(b, &BluebellRequest{Command: "scanprefix", Key: "sh"})); fmt.Sprint(got) != "[shared=from-b]" {
+		t.Errorf("expected only the key of namespace b, but got %s", fmt.Sprint(got))
+	}
+
+	// 删除只影响自己的命名空间
+	if res := server.handle(a, &BluebellRequest{Command: "del", Key: "shared"}); res.Code != SuccessCode {
+		t.Fatalf("unexpected response %+v", res)
+	}
+	if res := server.handle(b, &BluebellRequest{Command: "get", Key: "shared"}); string(res.Result) != "from-b" {
+		t.Fatalf("expected the key of namespace b to survive, but got %+v", res)
+	}
+}
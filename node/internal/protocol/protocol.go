@@ -6,9 +6,12 @@ import (
 	"fmt"
 	"github.com/panjf2000/gnet/v2"
 	"io"
+	"os"
 	"sync"
+	"time"
 
 	"github.com/bytedance/sonic"
+	"github.com/huahuoao/lsm-core/internal/storage/engine/lsmtree"
 )
 
 // Bluebell 消息结构
@@ -16,10 +19,16 @@ type BluebellRequest struct {
 	Command string
 	Key     string // 键，通常是用于标识数据的字符串
 	Value   []byte // 值，存储数据的字节数组
+	Group   string // 组，客户端发送但服务端不使用
+	TraceID string // 追踪 ID，写入慢请求和错误日志并在响应中原样返回，可以为空
+	OpID    string // 幂等键，重试写请求时使用相同的值，节点返回第一次执行的结果而不会重复执行，可以为空
+	Flags   uint32 // 键的标志，set 时与值一起写入，为 0 时不带标志；scan 时是返回数量的上限
 }
 type BluebellResponse struct {
-	Code   string
-	Result []byte // 响应数据
+	Code    string
+	Result  []byte // 响应数据
+	TraceID string // 请求的追踪 ID
+	Flags   uint32 // get 返回的键的标志
 }
 
 func (b *BluebellResponse) Serialize() ([]byte, error) {
@@ -32,6 +41,14 @@ func (b *BluebellResponse) Serialize() ([]byte, error) {
 		return nil, err
 	}
 
+	if err := writeString(buf, b.TraceID); err != nil {
+		return nil, err
+	}
+
+	if err := binary.Write(buf, binary.BigEndian, b.Flags); err != nil {
+		return nil, err
+	}
+
 	return buf.Bytes(), nil
 }
 func (b *BluebellResponse) Encode() ([]byte, error) {
@@ -68,16 +85,35 @@ func DeserializeResponse(data []byte) (*BluebellResponse, error) {
 		return nil, err
 	}
 
+	var traceID string
+	if buf.Len() > 0 {
+		traceID, err = readString(buf)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// Flags 是可选字段，旧版本的节点不发送
+	var flags uint32
+	if buf.Len() > 0 {
+		if err := binary.Read(buf, binary.BigEndian, &flags); err != nil {
+			return nil, err
+		}
+	}
+
 	return &BluebellResponse{
-		Code:   code,
-		Result: result,
+		Code:    code,
+		Result:  result,
+		TraceID: traceID,
+		Flags:   flags,
 	}, nil
 }
 func (b *BluebellRequest) String() string {
-	return fmt.Sprintf("Bluebell{\n  Command: %s,\n  Key: %s,\n  Value: %s,\n }",
+	return fmt.Sprintf("Bluebell{\n  Command: %s,\n  Key: %s,\n  Value: %s,\n  TraceID: %s,\n }",
 		b.Command,
 		b.Key,
 		string(b.Value), // 将 []byte 转换为 string
+		b.TraceID,
 	)
 }
 func (b *BluebellRequest) Encode() ([]byte, error) {
@@ -116,6 +152,26 @@ func (b *BluebellRequest) Serialize() ([]byte, error) {
 		return nil, err
 	}
 
+	// Group 字段
+	if err := writeString(buf, b.Group); err != nil {
+		return nil, err
+	}
+
+	// TraceID 字段
+	if err := writeString(buf, b.TraceID); err != nil {
+		return nil, err
+	}
+
+	// OpID 字段
+	if err := writeString(buf, b.OpID); err != nil {
+		return nil, err
+	}
+
+	// Flags 字段
+	if err := binary.Write(buf, binary.BigEndian, b.Flags); err != nil {
+		return nil, err
+	}
+
 	return buf.Bytes(), nil
 }
 
@@ -145,6 +201,34 @@ func Deserialize(data []byte) (*BluebellRequest, error) {
 	}
 	b.Value = value
 
+	// Group、TraceID、OpID 和 Flags 是可选字段，旧版本的客户端不发送
+	if buf.Len() > 0 {
+		group, err := readString(buf)
+		if err != nil {
+			return nil, err
+		}
+		b.Group = group
+	}
+	if buf.Len() > 0 {
+		traceID, err := readString(buf)
+		if err != nil {
+			return nil, err
+		}
+		b.TraceID = traceID
+	}
+	if buf.Len() > 0 {
+		opID, err := readString(buf)
+		if err != nil {
+			return nil, err
+		}
+		b.OpID = opID
+	}
+	if buf.Len() > 0 {
+		if err := binary.Read(buf, binary.BigEndian, &b.Flags); err != nil {
+			return nil, err
+		}
+	}
+
 	return b, nil
 }
 
@@ -174,6 +258,9 @@ func readString(buf io.Reader) (string, error) {
 	if err := binary.Read(buf, binary.BigEndian, &length); err != nil {
 		return "", err
 	}
+	if err := checkLength(buf, length); err != nil {
+		return "", err
+	}
 	strBuf := make([]byte, length)
 	if _, err := io.ReadFull(buf, strBuf); err != nil {
 		return "", err
@@ -187,6 +274,9 @@ func readBytes(buf io.Reader) ([]byte, error) {
 	if err := binary.Read(buf, binary.BigEndian, &length); err != nil {
 		return nil, err
 	}
+	if err := checkLength(buf, length); err != nil {
+		return nil, err
+	}
 	byteBuf := make([]byte, length)
 	if _, err := io.ReadFull(buf, byteBuf); err != nil {
 		return nil, err
@@ -194,6 +284,15 @@ func readBytes(buf io.Reader) ([]byte, error) {
 	return byteBuf, nil
 }
 
+// checkLength 在分配内存之前检查长度前缀是否超出缓冲区中剩余的数据，
+// 避免损坏或恶意的长度前缀导致分配巨大的内存
+func checkLength(buf io.Reader, length uint32) error {
+	if r, ok := buf.(interface{ Len() int }); ok && int64(length) > int64(r.Len()) {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
+
 // BluebellServer 实现 gnet 的 Server
 type BluebellServer struct {
 	*gnet.BuiltinEventEngine
@@ -205,20 +304,127 @@ type BluebellServer struct {
 	connected    int32
 	disconnected int32
 	inBufferPool *sync.Pool
+	// 已经开始处理但响应还没写完的请求数量
+	pending int64
+	// 发出的 AsyncWrite 总次数
+	asyncWrites int64
+	// Shutdown 开始后为 1，不再处理新的请求
+	closing int32
+	// 事件循环数量，0 表示由 gnet 决定（多核模式下等于 CPU 核数）
+	NumEventLoop int
+	// 每个连接的读写缓冲区大小
+	ReadBufferCap  int
+	WriteBufferCap int
+	// TCP keep-alive 间隔，0 表示不启用
+	TCPKeepAlive time.Duration
+	// 日志输出，默认使用标准库的 log
+	logger Logger
+	// 最近执行过的带幂等键的写请求，nil 表示不去重
+	ops *opCache
+}
+
+// Logger 与引擎使用同一个日志接口，同一个实现可以同时传给 lsmtree.Open 和 NewBluebellServer
+type Logger = lsmtree.Logger
+
+// ServerOption 用于调整 BluebellServer 的运行参数
+type ServerOption func(*BluebellServer)
+
+// WithNumEventLoop 设置事件循环数量，通常不超过机器的 CPU 核数
+func WithNumEventLoop(n int) ServerOption {
+	return func(s *BluebellServer) {
+		s.NumEventLoop = n
+	}
 }
 
-// 创建新服务
-func NewBluebellServer(network, addr string, multicore bool) *BluebellServer {
-	return &BluebellServer{
-		buffer:    make(map[gnet.Conn]*bytes.Buffer),
-		Network:   network,
-		Addr:      addr,
-		Multicore: multicore,
+// WithBufferCap 设置每个连接的读写缓冲区大小
+func WithBufferCap(read, write int) ServerOption {
+	return func(s *BluebellServer) {
+		s.ReadBufferCap = read
+		s.WriteBufferCap = write
+	}
+}
+
+// WithTCPKeepAlive 设置 TCP keep-alive 间隔
+func WithTCPKeepAlive(d time.Duration) ServerOption {
+	return func(s *BluebellServer) {
+		s.TCPKeepAlive = d
+	}
+}
+
+// WithLogger 设置服务使用的日志接口，传入 nil 时保持默认
+func WithLogger(logger Logger) ServerOption {
+	return func(s *BluebellServer) {
+		if logger != nil {
+			s.logger = logger
+		}
+	}
+}
+
+// WithIdempotencyCache 设置节点记住的幂等键数量，size <= 0 时不去重，带幂等键的请求每次都会执行
+func WithIdempotencyCache(size int) ServerOption {
+	return func(s *BluebellServer) {
+		if size <= 0 {
+			s.ops = nil
+			return
+		}
+		s.ops = newOpCache(size)
+	}
+}
+
+// 创建新服务，未指定的参数使用 conf.go 中的默认值
+func NewBluebellServer(network, addr string, multicore bool, opts ...ServerOption) *BluebellServer {
+	s := &BluebellServer{
+		buffer:         make(map[gnet.Conn]*bytes.Buffer),
+		Network:        network,
+		Addr:           addr,
+		Multicore:      multicore,
+		ReadBufferCap:  defaultBufferCap,
+		WriteBufferCap: defaultBufferCap,
+		TCPKeepAlive:   defaultTCPKeepAlive,
+		logger:         lsmtree.StdLogger(),
+		ops:            newOpCache(defaultIdempotencyCacheSize),
 		inBufferPool: &sync.Pool{
 			New: func() interface{} {
 				return make([]byte, LIMIT_SIZE) // 预先创建缓冲区
 			},
 		}}
+	for _, opt := range opts {
+		opt(s)
+	}
+	return s
+}
+
+// GnetOptions 返回与服务配置对应的 gnet 启动参数
+func (s *BluebellServer) GnetOptions() []gnet.Option {
+	options := []gnet.Option{
+		gnet.WithMulticore(s.Multicore),
+		gnet.WithReadBufferCap(s.ReadBufferCap),
+		gnet.WithWriteBufferCap(s.WriteBufferCap),
+		gnet.WithLogger(gnetLogger{s.logger}),
+	}
+	if s.NumEventLoop > 0 {
+		options = append(options, gnet.WithNumEventLoop(s.NumEventLoop))
+	}
+	if s.TCPKeepAlive > 0 {
+		options = append(options, gnet.WithTCPKeepAlive(s.TCPKeepAlive))
+	}
+	return options
+}
+
+// gnetLogger 把 gnet 自己的日志转到服务的日志接口
+type gnetLogger struct {
+	Logger
+}
+
+func (l gnetLogger) Debugf(format string, args ...any) { l.Debug(format, args...) }
+func (l gnetLogger) Infof(format string, args ...any)  { l.Info(format, args...) }
+func (l gnetLogger) Warnf(format string, args ...any)  { l.Warn(format, args...) }
+func (l gnetLogger) Errorf(format string, args ...any) { l.Error(format, args...) }
+
+// Fatalf 与 gnet 默认的日志一样在记录后退出进程
+func (l gnetLogger) Fatalf(format string, args ...any) {
+	l.Error(format, args...)
+	os.Exit(1)
 }
 
 func SonicSerialize(b interface{}) []byte {
@@ -0,0 +1,213 @@
+package protocol
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"math/rand"
+	"testing"
+
+	"github.com/huahuoao/lsm-core/internal/storage/engine/lsmtree"
+)
+
+// randBinary 返回长度在 [1, max] 之间的随机字节，经常包含零字节和看起来像长度前缀的字节。
+func randBinary(r *rand.Rand, max int) []byte {
+	b := make([]byte, 1+r.Intn(max))
+	r.Read(b)
+	for i := 0; i+4 <= len(b); i += 4 + r.Intn(8) {
+		switch r.Intn(3) {
+		case 0:
+			binary.BigEndian.PutUint32(b[i:], uint32(len(b)))
+		case 1:
+			binary.BigEndian.PutUint32(b[i:], 0)
+		}
+	}
+	return b
+}
+
+// readFrame 按服务端的分帧方式从流中读取一条消息：[长度 4 字节][消息]
+func readFrame(t testing.TB, r io.Reader) []byte {
+	var header [4]byte
+	if _, err := io.ReadFull(r, header[:]); err != nil {
+		t.Fatalf("failed to read header: %s", err)
+	}
+	message := make([]byte, binary.BigEndian.Uint32(header[:]))
+	if _, err := io.ReadFull(r, message); err != nil {
+		t.Fatalf("failed to read message: %s", err)
+	}
+	return message
+}
+
+// TestBinarySafePipeline 让随机二进制键值经过完整的链路：
+// 请求编码 → 服务端分帧 → Deserialize → 存储 encode/decode → 读回 → 响应编码 → 客户端解码。
+func TestBinarySafePipeline(t *testing.T) {
+	tree, err := lsmtree.Open(t.TempDir(), lsmtree.MemTableThreshold(64*1024))
+	if err != nil {
+		t.Fatalf("failed to open LSM tree: %s", err)
+	}
+	defer tree.Close()
+
+	r := rand.New(rand.NewSource(1))
+	sizes := []int{8, 64, 1024, lsmtree.MaxKeySize}
+	var stream bytes.Buffer
+	var requests []*BluebellRequest
+	for i := 0; i < 200; i++ {
+		request := &BluebellRequest{
+			Command: "set",
+			Key:     string(randBinary(r, sizes[i%len(sizes)])),
+			Value:   randBinary(r, sizes[(i+1)%len(sizes)]),
+		}
+		frame, err := request.Encode()
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		// 所有请求写入同一个流，模拟流水线中粘在一起的消息
+		stream.Write(frame)
+		requests = append(requests, request)
+	}
+
+	for _, want := range requests {
+		got, err := Deserialize(readFrame(t, &stream))
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if got.Command != want.Command || got.Key != want.Key || !bytes.Equal(got.Value, want.Value) {
+			t.Fatalf("request mismatch after framing: %q", got.Key)
+		}
+		if err := tree.Put([]byte(got.Key), got.Value); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+	if stream.Len() != 0 {
+		t.Fatalf("expected the stream to be fully consumed, but %d bytes remain", stream.Len())
+	}
+
+	// 后写入的相同键覆盖先写入的
+	expected := make(map[string][]byte)
+	for _, request := range requests {
+		expected[request.Key] = request.Value
+	}
+	for key, value := range expected {
+		stored, ok, err := tree.Get([]byte(key))
+		if err != nil || !ok {
+			t.Fatalf("failed to read back key %q: %v", key, err)
+		}
+
+		frame, err := newResponse(SuccessCode, stored).Encode()
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		res, err := DeserializeResponse(readFrame(t, bytes.NewReader(frame)))
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if res.Code != SuccessCode || !bytes.Equal(res.Result, value) {
+			t.Fatalf("value mismatch for key %q", key)
+		}
+	}
+}
+
+func TestDeserializeRejectsBadLength(t *testing.T) {
+	request := &BluebellRequest{Command: "set", Key: "key", Value: []byte("value")}
+	data, err := request.Serialize()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	// 值的长度前缀声称有 4GB 数据
+	binary.BigEndian.PutUint32(data[4+len("set")+4+len("key"):], 0xffffffff)
+	if _, err := Deserialize(data); err != io.ErrUnexpectedEOF {
+		t.Fatalf("expected %v, but got %v", io.ErrUnexpectedEOF, err)
+	}
+
+	if _, err := Deserialize(data[:len(data)-1]); err == nil {
+		t.Fatalf("expected an error for truncated data")
+	}
+}
+
+func TestTraceIDRoundTrip(t *testing.T) {
+	request := &BluebellRequest{Command: "unknown", Key: "key", Value: []byte("value"), TraceID: "trace-1"}
+	frame, err := request.Encode()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	got, err := Deserialize(readFrame(t, bytes.NewReader(frame)))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if got.TraceID != "trace-1" {
+		t.Fatalf("expected trace id trace-1, but got %q", got.TraceID)
+	}
+
+	server := NewBluebellServer("tcp", "127.0.0.1:0", false)
+	res := server.handle(nil, got)
+	if res.Code != ErrorCode || res.TraceID != "trace-1" {
+		t.Fatalf("expected an error response with trace id trace-1, but got %+v", res)
+	}
+
+	frame, err = res.Encode()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	decoded, err := DeserializeResponse(readFrame(t, bytes.NewReader(frame)))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if decoded.TraceID != "trace-1" {
+		t.Fatalf("expected trace id trace-1 in response, but got %q", decoded.TraceID)
+	}
+}
+
+func TestFlagsRoundTrip(t *testing.T) {
+	request := &BluebellRequest{Command: "set", Key: "flagged", Value: []byte("value"), Flags: 0xdeadbeef}
+	frame, err := request.Encode()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	got, err := Deserialize(readFrame(t, bytes.NewReader(frame)))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if got.Flags != 0xdeadbeef {
+		t.Fatalf("expected flags deadbeef, but got %x", got.Flags)
+	}
+
+	server := NewBluebellServer("tcp", "127.0.0.1:0", false)
+	if res := server.handle(nil, got); res.Code != SuccessCode {
+		t.Fatalf("unexpected response %+v", res)
+	}
+	res := server.handle(nil, &BluebellRequest{Command: "get", Key: "flagged"})
+	frame, err = res.Encode()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	decoded, err := DeserializeResponse(readFrame(t, bytes.NewReader(frame)))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if decoded.Code != SuccessCode || string(decoded.Result) != "value" || decoded.Flags != 0xdeadbeef {
+		t.Fatalf("expected the value with flags deadbeef, but got %+v", decoded)
+	}
+
+	// 不带标志的 set 把标志重置为 0
+	server.handle(nil, &BluebellRequest{Command: "set", Key: "flagged", Value: []byte("plain")})
+	if res := server.handle(nil, &BluebellRequest{Command: "get", Key: "flagged"}); res.Flags != 0 {
+		t.Fatalf("expected no flags, but got %x", res.Flags)
+	}
+}
+
+func TestDeserializeWithoutOptionalFields(t *testing.T) {
+	// 旧版本的客户端只发送 Command、Key 和 Value
+	buf := new(bytes.Buffer)
+	writeString(buf, "get")
+	writeString(buf, "key")
+	writeBytes(buf, nil)
+
+	request, err := Deserialize(buf.Bytes())
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if request.Command != "get" || request.Key != "key" || request.TraceID != "" || request.Flags != 0 {
+		t.Fatalf("unexpected request %+v", request)
+	}
+}
@@ -1,17 +1,20 @@
 package protocol
 
 import (
+	"bytes"
+	"context"
 	"encoding/binary"
 	"fmt"
 	"io"
-	"log"
 	"sync/atomic"
+	"time"
 
+	"github.com/huahuoao/lsm-core/internal/storage/engine/lsmtree"
 	"github.com/panjf2000/gnet/v2"
 )
 
 func (s *BluebellServer) OnBoot(eng gnet.Engine) (action gnet.Action) {
-	log.Printf("running node on %s with multi-core=%t",
+	s.logger.Info("running node on %s with multi-core=%t",
 		fmt.Sprintf("%s://%s", s.Network, s.Addr), s.Multicore)
 	s.eng = eng
 	return
@@ -19,27 +22,63 @@ func (s *BluebellServer) OnBoot(eng gnet.Engine) (action gnet.Action) {
 
 func (s *BluebellServer) OnOpen(c gnet.Conn) (out []byte, action gnet.Action) {
 	atomic.AddInt32(&s.connected, 1)
-	log.Printf("now the client nums is %v", s.connected)
+	c.SetContext(&streamState{})
+	s.logger.Debug("now the client nums is %v", s.connected)
 	return
 }
 
 func (s *BluebellServer) OnClose(c gnet.Conn, err error) (action gnet.Action) {
 	if err != nil {
-		log.Printf("error occurred on connection=%s, %v\n", c.RemoteAddr().String(), err)
+		s.logger.Warn("error occurred on connection=%s, %v", c.RemoteAddr().String(), err)
+	}
+	if st, ok := c.Context().(*streamState); ok {
+		st.close()
 	}
 	atomic.AddInt32(&s.disconnected, 1)
 	connected := atomic.AddInt32(&s.connected, -1)
 	if connected == 0 {
-		log.Printf("all %d connections are closed", s.disconnected)
+		s.logger.Info("all %d connections are closed", s.disconnected)
 	}
 	return
 }
 
 func (s *BluebellServer) OnTraffic(c gnet.Conn) (action gnet.Action) {
 	reader := c.(gnet.Reader)
-	writer := c.(gnet.Writer)
 
+	// 同一次 OnTraffic 中所有请求的响应按请求顺序拼接，返回前一次写出，
+	// 流水线模式下可以把多次小的写合并成一次系统调用。
+	// responses 是 out 中的响应数量；held 表示本轮循环已经计入 pending 但还没有产生响应
+	var out []byte
+	responses := 0
+	held := false
+	defer func() {
+		if held {
+			s.release(1)
+		}
+		s.writeResponses(c, out, responses)
+	}()
+
+	st, _ := c.Context().(*streamState)
 	for {
+		// 丢弃已经拒绝的超过上限的消息的剩余部分
+		if st != nil && st.discard > 0 {
+			n := int(min(st.discard, int64(reader.InboundBuffered())))
+			if _, err := reader.Discard(n); err != nil {
+				s.logger.Error("discard error: %v", err)
+				return gnet.Close
+			}
+			if st.discard -= int64(n); st.discard > 0 {
+				return gnet.None
+			}
+		}
+
+		// 关闭过程中不再读取新的请求，未读取的请求留给客户端重试。
+		// 请求在检查 closing 之前就计入 pending，Shutdown 看到 pending 为 0 时不会有请求正在执行
+		if !s.acquire() {
+			return gnet.None
+		}
+		held = true
+
 		// Peek the first 4 bytes (header) to get the message length
 		header, err := reader.Peek(4)
 		if err != nil {
@@ -47,15 +86,43 @@ func (s *BluebellServer) OnTraffic(c gnet.Conn) (action gnet.Action) {
 				// Not enough data, exit the loop and wait for more data
 				return gnet.None
 			}
-			log.Println("Read header error:", err)
+			s.logger.Error("read header error: %v", err)
 			return gnet.None
 		}
 
 		// Extract message length
 		messageLength := binary.BigEndian.Uint32(header)
 
+		// 超过上限的消息不缓冲：读到命令和键后返回 ValueTooLargeCode，之后丢弃其余部分。
+		// 无法解析出这些字段时关闭连接
+		if messageLength > LIMIT_SIZE {
+			request, err := peekOversizedRequest(reader)
+			if err == io.ErrShortBuffer {
+				return gnet.None
+			}
+			if err != nil || st == nil {
+				s.logger.Warn("message length %d exceeds limit %d", messageLength, LIMIT_SIZE)
+				return gnet.Close
+			}
+			s.logger.Warn("command=%s key=%q message length %d exceeds limit %d", request.Command, request.Key, messageLength, LIMIT_SIZE)
+			res := newResponse(ValueTooLargeCode, []byte(fmt.Sprintf(
+				"%s: message of %d bytes exceeds the limit of %d bytes, use setchunk for larger values",
+				lsmtree.ErrValueTooLarge, messageLength, LIMIT_SIZE)))
+			resBytes, err := res.Encode()
+			if err != nil {
+				s.logger.Error("failed to serialize response: %v", err)
+				return gnet.Close
+			}
+			out = append(out, resBytes...)
+			responses++
+			held = false
+			st.discard = int64(messageLength) + 4
+			continue
+		}
+
 		// Check if we have enough data in the buffer
-		if reader.InboundBuffered() < int(messageLength+4) {
+		// 先转换为 int 再相加，避免长度接近 uint32 上限时溢出
+		if reader.InboundBuffered() < int(messageLength)+4 {
 			// Not enough data for a complete message, exit the loop
 			return gnet.None
 		}
@@ -63,48 +130,213 @@ func (s *BluebellServer) OnTraffic(c gnet.Conn) (action gnet.Action) {
 		// Discard the header (advance buffer)
 		_, err = reader.Discard(4)
 		if err != nil {
-			log.Println("Discard error:", err)
+			s.logger.Error("discard error: %v", err)
 			return gnet.None
 		}
 
 		// Read the message body
 		message, err := reader.Next(int(messageLength))
 		if err != nil {
-			log.Println("Read message error:", err)
+			s.logger.Error("read message error: %v", err)
 			return gnet.None
 		}
 		// Deserialize the message
 		bluebell, err := Deserialize(message)
-		fmt.Printf("req: %v\n", bluebell)
 
 		if err != nil {
-			log.Println("Failed to deserialize message:", err)
+			s.logger.Warn("failed to deserialize message: %v", err)
+			s.release(1)
+			held = false
 			continue
 		}
 
 		// Process the message and generate a response
-		var res *BluebellResponse
-		switch bluebell.Command {
-		case "get":
-			res = HandleGet(bluebell)
-		case "set":
-			res = HandleSet(bluebell)
-		}
-		fmt.Printf("res1: %v\n", res)
+		res := s.handle(st, bluebell)
 		// Serialize the response
 		resBytes, err := res.Encode()
 
 		if err != nil {
-			log.Println("Failed to serialize response:", err)
+			s.logger.Error("failed to serialize response: %v", err)
+			s.release(1)
+			held = false
 			continue
 		}
+		out = append(out, resBytes...)
+		responses++
+		held = false
+	}
 
-		// Write the response asynchronously
-		err = writer.AsyncWrite(resBytes, nil)
-		if err != nil {
-			log.Println("Async write error:", err)
-			return gnet.None
+}
+
+// peekOversizedRequest 不移动读取位置，从超过上限的消息开头解析命令和键。
+// 这些字段还没有全部到达时返回 io.ErrShortBuffer，超过 oversizedHeaderLimit 仍无法解析时返回错误。
+func peekOversizedRequest(reader gnet.Reader) (*BluebellRequest, error) {
+	size := min(reader.InboundBuffered(), 4+oversizedHeaderLimit)
+	header, err := reader.Peek(size)
+	if err != nil {
+		return nil, err
+	}
+	buf := bytes.NewReader(header[4:])
+	command, err := readString(buf)
+	if err == nil {
+		var key string
+		if key, err = readString(buf); err == nil {
+			return &BluebellRequest{Command: command, Key: key}, nil
+		}
+	}
+	if (err == io.ErrUnexpectedEOF || err == io.EOF) && size < 4+oversizedHeaderLimit {
+		return nil, io.ErrShortBuffer
+	}
+	return nil, err
+}
+
+// acquire 把一个请求计入 pending，Shutdown 已经开始时撤销并返回 false。
+// 先增加 pending 再检查 closing：Shutdown 先设置 closing 再检查 pending，两者至少有一方看到对方的修改。
+func (s *BluebellServer) acquire() bool {
+	atomic.AddInt64(&s.pending, 1)
+	if atomic.LoadInt32(&s.closing) == 1 {
+		s.release(1)
+		return false
+	}
+	return true
+}
+
+// release 把 n 个请求移出 pending。
+func (s *BluebellServer) release(n int) {
+	atomic.AddInt64(&s.pending, -int64(n))
+}
+
+// writeResponses 通过一次 AsyncWrite 写出拼接好的 n 个响应。
+// 这些请求在响应写完（或写失败）后的回调中移出 pending，Shutdown 据此等待所有响应送达。
+func (s *BluebellServer) writeResponses(c gnet.Conn, out []byte, n int) {
+	if n == 0 {
+		return
+	}
+	atomic.AddInt64(&s.asyncWrites, 1)
+	err := c.AsyncWrite(out, func(c gnet.Conn, err error) error {
+		return s.onWritten(c, err, n)
+	})
+	if err != nil {
+		s.release(n)
+		s.logger.Error("async write error: %v", err)
+	}
+}
+
+// handle 执行请求并生成响应，响应中带回请求的追踪 ID。st 是连接上的流式传输状态，为 nil 时不支持流式命令。
+// 失败或耗时超过 slowRequestThreshold 的请求连同追踪 ID 写入日志。
+func (s *BluebellServer) handle(st *streamState, bluebell *BluebellRequest) *BluebellResponse {
+	start := time.Now()
+
+	// 设置了命名空间的连接中，键在交给存储之前加上命名空间的前缀，返回的键去掉前缀
+	request := bluebell
+	var err error
+	if st != nil && st.namespace != "" {
+		request, err = inNamespace(st.namespace, bluebell)
+	}
+
+	var res *BluebellResponse
+	switch {
+	case err != nil:
+		res = newResponse(ErrorCode, []byte("invalid "+bluebell.Command+" request: "+err.Error()))
+	case request.OpID != "" && s.ops != nil && idempotentCommands[request.Command]:
+		res = s.ops.do(request.OpID, func() *BluebellResponse {
+			return dispatch(st, request)
+		})
+	default:
+		res = dispatch(st, request)
+	}
+	if err == nil && request != bluebell {
+		res = stripNamespace(st.namespace, bluebell.Command, res)
+	}
+	res.TraceID = bluebell.TraceID
+
+	elapsed := time.Since(start)
+	// 未命中的 get 也返回 ErrorCode 但没有错误信息，不写日志
+	if res.Code == ErrorCode && len(res.Result) > 0 {
+		s.logger.Warn("trace=%s command=%s key=%q failed after %s: %s", bluebell.TraceID, bluebell.Command, bluebell.Key, elapsed, res.Result)
+	} else if elapsed > slowRequestThreshold {
+		s.logger.Warn("trace=%s command=%s key=%q slow request took %s", bluebell.TraceID, bluebell.Command, bluebell.Key, elapsed)
+	}
+
+	return res
+}
+
+// dispatch 按命令执行请求
+func dispatch(st *streamState, bluebell *BluebellRequest) *BluebellResponse {
+	switch bluebell.Command {
+	case "get":
+		return HandleGet(bluebell)
+	case "set":
+		return HandleSet(bluebell)
+	case "del":
+		return HandleDel(bluebell)
+	case "mset":
+		return HandleMSet(bluebell)
+	case "exec":
+		return HandleExec(bluebell)
+	case "append":
+		return HandleAppend(bluebell)
+	case "getorset":
+		return HandleGetOrSet(bluebell)
+	case "scan":
+		return HandleScan(bluebell)
+	case "scanprefix":
+		return HandleScanPrefix(bluebell)
+	case "getall":
+		return HandleGetAll(bluebell)
+	case "plan_compaction":
+		return HandlePlanCompaction(bluebell)
+	case "major_compact":
+		return HandleMajorCompact(bluebell)
+	case "sync":
+		return HandleSync(bluebell)
+	case "bgops":
+		return HandleBackgroundOps(bluebell)
+	case "cancel_op":
+		return HandleCancelOp(bluebell)
+	case "snapshot":
+		return HandleSnapshot(bluebell)
+	case "epoch":
+		return HandleEpoch(bluebell)
+	case "namespace":
+		return HandleNamespace(st, bluebell)
+	case "getstream":
+		return HandleGetStream(st, bluebell)
+	case "getchunk":
+		return HandleGetChunk(st, bluebell)
+	case "setchunk":
+		return HandleSetChunk(st, bluebell)
+	case "setcommit":
+		return HandleSetCommit(st, bluebell)
+	default:
+		return newResponse(ErrorCode, []byte("unknown command "+bluebell.Command))
+	}
+}
+
+// onWritten 是 AsyncWrite 的回调，在 n 个响应写完后把它们的请求移出 pending。
+func (s *BluebellServer) onWritten(c gnet.Conn, err error, n int) error {
+	if err != nil {
+		s.logger.Error("failed to write response to %s: %v", c.RemoteAddr(), err)
+	}
+	s.release(n)
+	return nil
+}
+
+// Shutdown 安全地关闭服务：不再处理新的请求，等待正在执行的请求完成、它们的响应通过 AsyncWrite 写完，
+// 然后停止 gnet 引擎，保证滚动重启时客户端能收到已执行请求的确认。
+// ctx 到期时不再等待，返回 ctx 的错误。
+func (s *BluebellServer) Shutdown(ctx context.Context) error {
+	atomic.StoreInt32(&s.closing, 1)
+
+	ticker := time.NewTicker(shutdownPollInterval)
+	defer ticker.Stop()
+	for atomic.LoadInt64(&s.pending) > 0 {
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%d requests not answered: %w", atomic.LoadInt64(&s.pending), ctx.Err())
+		case <-ticker.C:
 		}
 	}
 
+	return s.eng.Stop(ctx)
 }
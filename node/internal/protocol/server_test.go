@@ -0,0 +1,334 @@
+package protocol
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/bytedance/sonic"
+	"github.com/huahuoao/lsm-core/internal/storage"
+	"github.com/huahuoao/lsm-core/internal/storage/engine/lsmtree"
+	"github.com/panjf2000/gnet/v2"
+)
+
+// TestMain 在临时的 HOME 下打开节点的存储，所有测试共用它，与节点一样整个进程只打开一次
+func TestMain(m *testing.M) {
+	home, err := os.MkdirTemp("", "protocol-test")
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	os.Setenv("HOME", home)
+	if err := storage.InitClient(); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	code := m.Run()
+	_ = storage.GetClient().Close()
+	_ = os.RemoveAll(home)
+	os.Exit(code)
+}
+
+// freeAddr 返回一个当前空闲的本地地址
+func freeAddr(t testing.TB) string {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	return l.Addr().String()
+}
+
+// startTestServer 在空闲端口上运行服务并建立一个连接，引擎退出时向 done 发送 gnet.Run 的结果
+func startTestServer(t testing.TB, opts ...ServerOption) (*BluebellServer, net.Conn, chan error) {
+	addr := freeAddr(t)
+	server := NewBluebellServer("tcp", addr, false, opts...)
+	done := make(chan error, 1)
+	go func() {
+		done <- gnet.Run(server, server.Network+"://"+server.Addr, server.GnetOptions()...)
+	}()
+
+	var conn net.Conn
+	var err error
+	for i := 0; i < 100; i++ {
+		if conn, err = net.Dial("tcp", addr); err == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatalf("failed to connect: %s", err)
+	}
+	return server, conn, done
+}
+
+// testLogger 保存服务端写出的日志，discard 为 true 时丢弃
+type testLogger struct {
+	mu      sync.Mutex
+	lines   []string
+	discard bool
+}
+
+func (l *testLogger) log(level, format string, args ...interface{}) {
+	if l.discard {
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.lines = append(l.lines, level+" "+fmt.Sprintf(format, args...))
+}
+
+func (l *testLogger) Debug(format string, args ...interface{}) { l.log("DEBUG", format, args...) }
+func (l *testLogger) Info(format string, args ...interface{})  { l.log("INFO", format, args...) }
+func (l *testLogger) Warn(format string, args ...interface{})  { l.log("WARN", format, args...) }
+func (l *testLogger) Error(format string, args ...interface{}) { l.log("ERROR", format, args...) }
+
+func (l *testLogger) Lines() []string {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return append([]string(nil), l.lines...)
+}
+
+// pipeline 把 count 个请求编码到一起，追踪 ID 为 trace-<序号>
+func pipeline(t testing.TB, count int) []byte {
+	var requests bytes.Buffer
+	for i := 0; i < count; i++ {
+		frame, err := (&BluebellRequest{Command: "unknown", Key: "key", TraceID: fmt.Sprintf("trace-%d", i)}).Encode()
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		requests.Write(frame)
+	}
+	return requests.Bytes()
+}
+
+func TestShutdownDeliversPendingResponses(t *testing.T) {
+	server, conn, done := startTestServer(t)
+	defer conn.Close()
+
+	// 一次写入所有请求，服务端在同一次 OnTraffic 中处理它们
+	count := 100
+	if _, err := conn.Write(pipeline(t, count)); err != nil {
+		t.Fatalf("failed to write requests: %s", err)
+	}
+
+	// 收到第一个响应后立即关闭
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	readFrame(t, conn)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	for i := 1; i < count; i++ {
+		res, err := DeserializeResponse(readFrame(t, conn))
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if res.TraceID != fmt.Sprintf("trace-%d", i) {
+			t.Fatalf("unexpected response %+v", res)
+		}
+	}
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected the engine to stop after Shutdown")
+	}
+}
+
+// blockingLogger 在写出失败请求的日志时阻塞，直到 release 被关闭，使请求停在 handle 中
+type blockingLogger struct {
+	testLogger
+	entered chan struct{}
+	release chan struct{}
+}
+
+func (l *blockingLogger) Warn(format string, args ...interface{}) {
+	if strings.Contains(format, "failed after") {
+		l.entered <- struct{}{}
+		<-l.release
+	}
+}
+
+func TestShutdownWaitsForRunningRequest(t *testing.T) {
+	logger := &blockingLogger{entered: make(chan struct{}, 1), release: make(chan struct{})}
+	server, conn, done := startTestServer(t, WithLogger(logger))
+	defer conn.Close()
+
+	if _, err := conn.Write(pipeline(t, 1)); err != nil {
+		t.Fatalf("failed to write requests: %s", err)
+	}
+	select {
+	case <-logger.entered:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected the request to be handled")
+	}
+
+	// 请求还在 handle 中，Shutdown 必须等它完成并写出响应
+	shutdown := make(chan error, 1)
+	go func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		shutdown <- server.Shutdown(ctx)
+	}()
+	select {
+	case err := <-shutdown:
+		t.Fatalf("expected Shutdown to wait for the running request, but it returned %v", err)
+	case <-time.After(200 * time.Millisecond):
+	}
+
+	close(logger.release)
+	if err := <-shutdown; err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	res, err := DeserializeResponse(readFrame(t, conn))
+	if err != nil || res.TraceID != "trace-0" {
+		t.Fatalf("expected the response of trace-0, but got %+v (%v)", res, err)
+	}
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected the engine to stop after Shutdown")
+	}
+}
+
+func TestPipelinedResponsesAreBatched(t *testing.T) {
+	server, conn, _ := startTestServer(t)
+	defer conn.Close()
+	defer server.Shutdown(context.Background())
+
+	count := 100
+	if _, err := conn.Write(pipeline(t, count)); err != nil {
+		t.Fatalf("failed to write requests: %s", err)
+	}
+
+	// 响应保持请求的顺序和追踪 ID
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	for i := 0; i < count; i++ {
+		res, err := DeserializeResponse(readFrame(t, conn))
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if want := fmt.Sprintf("trace-%d", i); res.TraceID != want {
+			t.Fatalf("expected response for %s, but got %+v", want, res)
+		}
+	}
+	// 请求可能分几次到达服务端，但写的次数远少于响应数量
+	if writes := atomic.LoadInt64(&server.asyncWrites); writes >= int64(count) {
+		t.Fatalf("expected responses to be batched, but got %d writes for %d responses", writes, count)
+	}
+}
+
+// BenchmarkPipelinedWrites 以深度 100 的流水线发送请求，报告每批请求服务端发出的 AsyncWrite 次数
+func BenchmarkPipelinedWrites(b *testing.B) {
+	server, conn, _ := startTestServer(b, WithLogger(&testLogger{discard: true}))
+	defer conn.Close()
+	defer server.Shutdown(context.Background())
+
+	depth := 100
+	requests := pipeline(b, depth)
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, err := conn.Write(requests); err != nil {
+			b.Fatalf("failed to write requests: %s", err)
+		}
+		for j := 0; j < depth; j++ {
+			readFrame(b, conn)
+		}
+	}
+	b.ReportMetric(float64(atomic.LoadInt64(&server.asyncWrites))/float64(b.N), "writes/op")
+}
+
+func TestServerOptions(t *testing.T) {
+	server := NewBluebellServer("tcp", "127.0.0.1:0", true)
+	if server.ReadBufferCap != defaultBufferCap || server.WriteBufferCap != defaultBufferCap {
+		t.Fatalf("unexpected default buffer caps: %d %d", server.ReadBufferCap, server.WriteBufferCap)
+	}
+	if server.TCPKeepAlive != defaultTCPKeepAlive || server.NumEventLoop != 0 {
+		t.Fatalf("unexpected defaults: %v %d", server.TCPKeepAlive, server.NumEventLoop)
+	}
+	if got := len(server.GnetOptions()); got != 5 {
+		t.Fatalf("expected 5 gnet options, got %d", got)
+	}
+
+	server = NewBluebellServer("tcp", "127.0.0.1:0", true,
+		WithNumEventLoop(4), WithBufferCap(64*1024, 128*1024), WithTCPKeepAlive(0))
+	if server.NumEventLoop != 4 || server.ReadBufferCap != 64*1024 || server.WriteBufferCap != 128*1024 {
+		t.Fatalf("options not applied: %+v", server)
+	}
+	// keep-alive 关闭时不传给 gnet
+	if got := len(server.GnetOptions()); got != 5 {
+		t.Fatalf("expected 5 gnet options, got %d", got)
+	}
+}
+
+func TestServerLogger(t *testing.T) {
+	logger := &testLogger{}
+	server, conn, _ := startTestServer(t, WithLogger(logger))
+	defer conn.Close()
+	defer server.Shutdown(context.Background())
+
+	if _, err := conn.Write(pipeline(t, 1)); err != nil {
+		t.Fatalf("failed to write requests: %s", err)
+	}
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	readFrame(t, conn)
+
+	// gnet 的启动信息、服务的启动信息和失败的请求都写入注入的日志接口
+	var launched, booted, failed bool
+	for _, line := range logger.Lines() {
+		launched = launched || strings.HasPrefix(line, "INFO Launching gnet")
+		booted = booted || strings.HasPrefix(line, "INFO running node on tcp://"+server.Addr)
+		failed = failed || (strings.HasPrefix(line, "WARN trace=trace-0 command=unknown") && strings.Contains(line, "unknown command"))
+	}
+	if !launched || !booted || !failed {
+		t.Fatalf("expected launch, boot and failure logs, but got %q", logger.Lines())
+	}
+}
+
+func TestSyncCommand(t *testing.T) {
+	server := NewBluebellServer("tcp", "127.0.0.1:0", false)
+	if res := server.handle(nil, &BluebellRequest{Command: "set", Key: "sync-key", Value: []byte("v")}); res.Code != SuccessCode {
+		t.Fatalf("unexpected response %+v", res)
+	}
+	if res := server.handle(nil, &BluebellRequest{Command: "sync"}); res.Code != SuccessCode {
+		t.Fatalf("expected sync to succeed, but got %+v", res)
+	}
+}
+
+func TestBackgroundOpsCommands(t *testing.T) {
+	server := NewBluebellServer("tcp", "127.0.0.1:0", false)
+	res := server.handle(nil, &BluebellRequest{Command: "bgops"})
+	if res.Code != SuccessCode {
+		t.Fatalf("expected bgops to succeed, but got %+v", res)
+	}
+	var ops [][]lsmtree.OpStatus
+	if err := sonic.Unmarshal(res.Result, &ops); err != nil {
+		t.Fatal(err)
+	}
+	if len(ops) == 0 {
+		t.Fatal("expected the operations of every shard")
+	}
+
+	// 没有正在进行的操作时取消失败
+	res = server.handle(nil, &BluebellRequest{Command: "cancel_op", Key: "0", Value: []byte("12345")})
+	if res.Code != ErrorCode || string(res.Result) != lsmtree.ErrOpNotFound.Error() {
+		t.Fatalf("expected the operation not to be found, but got %+v", res)
+	}
+	if res := server.handle(nil, &BluebellRequest{Command: "cancel_op", Key: "x", Value: []byte("1")}); res.Code != ErrorCode {
+		t.Fatalf("expected an invalid shard to fail, but got %+v", res)
+	}
+}
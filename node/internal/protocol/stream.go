@@ -0,0 +1,129 @@
+package protocol
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+
+	"github.com/huahuoao/lsm-core/internal/storage"
+	"github.com/huahuoao/lsm-core/internal/storage/engine/lsmtree"
+)
+
+// 单条消息的大小有上限（LIMIT_SIZE），更大的值通过以下流式命令分块传输，每块不超过 streamChunkSize：
+//
+//	读：getstream 打开 Key 的值，响应是 8 字节的值长度；之后每个 getchunk 返回下一块，
+//	    读完最后一块后流自动关闭。值位于未压缩的磁盘表中时，每块直接从数据文件中读取，
+//	    节点不会把整个值读入内存，也不会因为客户端读得慢而积压响应。
+//	写：每个 setchunk 把 Value 追加到 Key 正在写入的值之后，setcommit 把拼好的值写入存储。
+//	    内存表保存完整的值，因此值在节点上被完整地拼接后再写入，流式写入避免的是单条消息的上限。
+//
+// 流属于连接，每个连接同时最多有一个读流和一个写流，开始新的流会丢弃同一方向上未完成的流，
+// 连接关闭时未完成的流被丢弃。
+
+// streamState 是一个连接上正在进行的流式传输
+type streamState struct {
+	// 正在读取的值和还没有返回的字节数
+	get       *lsmtree.ValueReader
+	remaining int64
+	// 正在写入的键和已经收到的部分
+	setKey string
+	set    *bytes.Buffer
+	// 被拒绝的超过 LIMIT_SIZE 的消息还没有到达、需要丢弃的字节数
+	discard int64
+	// 连接的命名空间，为空表示没有设置，详见 namespace.go
+	namespace string
+}
+
+// closeGet 关闭正在读取的值
+func (st *streamState) closeGet() {
+	if st.get != nil {
+		st.get.Close()
+		st.get = nil
+	}
+}
+
+// close 丢弃连接上所有未完成的流
+func (st *streamState) close() {
+	st.closeGet()
+	st.setKey, st.set = "", nil
+}
+
+// HandleGetStream 打开 Key 的值用于流式读取，响应是 8 字节大端序的值长度
+func HandleGetStream(st *streamState, request *BluebellRequest) *BluebellResponse {
+	if st == nil {
+		return newResponse(ErrorCode, []byte("streaming requires a connection"))
+	}
+	st.closeGet()
+
+	client := storage.GetClient()
+	r, ok, err := client.OpenValue([]byte(request.Key))
+	if err != nil {
+		return newResponse(ErrorCode, []byte(err.Error()))
+	}
+	if !ok {
+		return newResponse(ErrorCode, nil)
+	}
+	st.get, st.remaining = r, r.Size()
+
+	res := make([]byte, 8)
+	binary.BigEndian.PutUint64(res, uint64(r.Size()))
+	return newResponse(SuccessCode, res)
+}
+
+// HandleGetChunk 返回正在读取的值的下一块，读完后关闭流
+func HandleGetChunk(st *streamState, request *BluebellRequest) *BluebellResponse {
+	if st == nil || st.get == nil {
+		return newResponse(ErrorCode, []byte("no value stream in progress"))
+	}
+
+	size := int64(streamChunkSize)
+	if st.remaining < size {
+		size = st.remaining
+	}
+	chunk := make([]byte, size)
+	n, err := io.ReadFull(st.get, chunk)
+	st.remaining -= int64(n)
+	if err == nil && st.remaining == 0 {
+		// 读到结尾才会校验整个值的校验和
+		_, err = st.get.Read(make([]byte, 1))
+	}
+	if err == nil {
+		return newResponse(SuccessCode, chunk)
+	}
+	st.closeGet()
+	if err != io.EOF {
+		return newResponse(ErrorCode, []byte(err.Error()))
+	}
+	return newResponse(SuccessCode, chunk[:n])
+}
+
+// HandleSetChunk 把 Value 追加到 Key 正在写入的值之后，Key 与正在写入的键不同时开始一个新的值
+func HandleSetChunk(st *streamState, request *BluebellRequest) *BluebellResponse {
+	if st == nil {
+		return newResponse(ErrorCode, []byte("streaming requires a connection"))
+	}
+	if st.set == nil || st.setKey != request.Key {
+		st.setKey, st.set = request.Key, new(bytes.Buffer)
+	}
+	if err := checkValueSize(st.set.Len() + len(request.Value)); err != nil {
+		st.setKey, st.set = "", nil
+		return errorResponse(err)
+	}
+	st.set.Write(request.Value)
+	return newResponse(SuccessCode, nil)
+}
+
+// HandleSetCommit 把 Key 已经收到的所有块作为一个值写入存储
+func HandleSetCommit(st *streamState, request *BluebellRequest) *BluebellResponse {
+	if st == nil || st.set == nil || st.setKey != request.Key {
+		return newResponse(ErrorCode, []byte("no value stream in progress for key "+request.Key))
+	}
+	value := st.set.Bytes()
+	st.setKey, st.set = "", nil
+
+	client := storage.GetClient()
+	if err := client.Put([]byte(request.Key), value); err != nil {
+		return errorResponse(err)
+	}
+	return newResponse(SuccessCode, nil)
+}
@@ -0,0 +1,140 @@
+package protocol
+
+import (
+	"bytes"
+	"context"
+	"encoding/binary"
+	"math/rand"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/huahuoao/lsm-core/internal/storage"
+)
+
+// roundTrip 发送一个请求并读取它的响应
+func roundTrip(t *testing.T, conn net.Conn, request *BluebellRequest) *BluebellResponse {
+	t.Helper()
+	frame, err := request.Encode()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if _, err := conn.Write(frame); err != nil {
+		t.Fatalf("failed to write request: %s", err)
+	}
+	conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+	res, err := DeserializeResponse(readFrame(t, conn))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	return res
+}
+
+func TestStreamLargeValue(t *testing.T) {
+	server, conn, _ := startTestServer(t, WithLogger(&testLogger{discard: true}))
+	defer conn.Close()
+	defer server.Shutdown(context.Background())
+
+	// 值远大于单条消息的上限
+	value := make([]byte, 100*MB)
+	rand.New(rand.NewSource(1)).Read(value)
+	if len(value) <= LIMIT_SIZE {
+		t.Fatalf("expected the value to exceed the message limit")
+	}
+
+	for off := 0; off < len(value); off += streamChunkSize {
+		end := off + streamChunkSize
+		if end > len(value) {
+			end = len(value)
+		}
+		if res := roundTrip(t, conn, &BluebellRequest{Command: "setchunk", Key: "large", Value: value[off:end]}); res.Code != SuccessCode {
+			t.Fatalf("failed to send chunk at %d: %s", off, res.Result)
+		}
+	}
+	if res := roundTrip(t, conn, &BluebellRequest{Command: "setcommit", Key: "large"}); res.Code != SuccessCode {
+		t.Fatalf("failed to commit: %s", res.Result)
+	}
+
+	// 继续写入几个值，让不可变内存表刷新到磁盘，之后的读取直接来自数据文件
+	filler := bytes.Repeat([]byte("f"), 32*1024)
+	for _, key := range []string{"filler-1", "filler-2", "filler-3", "filler-4"} {
+		if res := roundTrip(t, conn, &BluebellRequest{Command: "set", Key: key, Value: filler}); res.Code != SuccessCode {
+			t.Fatalf("failed to set %s: %s", key, res.Result)
+		}
+	}
+
+	res := roundTrip(t, conn, &BluebellRequest{Command: "getstream", Key: "large"})
+	if res.Code != SuccessCode || len(res.Result) != 8 {
+		t.Fatalf("failed to open stream: %+v", res)
+	}
+	size := int(binary.BigEndian.Uint64(res.Result))
+	if size != len(value) {
+		t.Fatalf("expected size %d, but got %d", len(value), size)
+	}
+	got := make([]byte, 0, size)
+	for len(got) < size {
+		res := roundTrip(t, conn, &BluebellRequest{Command: "getchunk"})
+		if res.Code != SuccessCode || len(res.Result) == 0 || len(res.Result) > streamChunkSize {
+			t.Fatalf("unexpected chunk after %d bytes: code %s, %d bytes", len(got), res.Code, len(res.Result))
+		}
+		got = append(got, res.Result...)
+	}
+	if !bytes.Equal(got, value) {
+		t.Fatalf("streamed value does not match")
+	}
+
+	// 读完后流已经关闭
+	if res := roundTrip(t, conn, &BluebellRequest{Command: "getchunk"}); res.Code != ErrorCode {
+		t.Fatalf("expected the stream to be closed, but got %+v", res)
+	}
+	if res := roundTrip(t, conn, &BluebellRequest{Command: "getstream", Key: "missing"}); res.Code != ErrorCode {
+		t.Fatalf("expected a missing key, but got %+v", res)
+	}
+	if res := roundTrip(t, conn, &BluebellRequest{Command: "setcommit", Key: "large"}); res.Code != ErrorCode {
+		t.Fatalf("expected a commit without chunks to fail, but got %+v", res)
+	}
+}
+
+func TestOversizedSetRejected(t *testing.T) {
+	logger := &testLogger{discard: true}
+	server, conn, _ := startTestServer(t, WithLogger(logger))
+	defer conn.Close()
+	defer server.Shutdown(context.Background())
+
+	// 值超过单条消息的上限：读到命令和键后就返回 ValueTooLargeCode，连接保持可用
+	frame, err := (&BluebellRequest{Command: "set", Key: "oversized", Value: make([]byte, LIMIT_SIZE+1), TraceID: "t"}).Encode()
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn.SetReadDeadline(time.Now().Add(10 * time.Second))
+	sent := make(chan error, 1)
+	go func() {
+		_, err := conn.Write(frame)
+		sent <- err
+	}()
+	res, err := DeserializeResponse(readFrame(t, conn))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res.Code != ValueTooLargeCode {
+		t.Fatalf("expected ValueTooLargeCode, but got %+v", res)
+	}
+	if err := <-sent; err != nil {
+		t.Fatalf("failed to write the oversized request: %s", err)
+	}
+
+	if res := roundTrip(t, conn, &BluebellRequest{Command: "set", Key: "after", Value: []byte("v")}); res.Code != SuccessCode {
+		t.Fatalf("expected the connection to stay usable, but got %+v", res)
+	}
+	if res := roundTrip(t, conn, &BluebellRequest{Command: "get", Key: "oversized"}); res.Code != ErrorCode || len(res.Result) != 0 {
+		t.Fatalf("expected the oversized value not to be written, but got %+v", res)
+	}
+
+	// 处理器在调用存储之前检查节点接受的最大值
+	if res := errorResponse(checkValueSize(storage.MaxValueSize + 1)); res.Code != ValueTooLargeCode {
+		t.Fatalf("expected ValueTooLargeCode for a value over the node limit, but got %+v", res)
+	}
+	if err := checkValueSize(storage.MaxValueSize); err != nil {
+		t.Fatalf("expected a value at the node limit to be accepted, but got %v", err)
+	}
+}
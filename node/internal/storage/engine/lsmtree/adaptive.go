@@ -0,0 +1,38 @@
+package lsmtree
+
+import "time"
+
+// adaptiveFillInterval 是自适应阈值下期望写满一个内存表的时间。
+const adaptiveFillInterval = time.Second
+
+// AdaptiveMemTableThreshold 开启自适应的内存表阈值（默认关闭），有效阈值在 [min, max] 字节之间调整。
+// 每次内存表写满时按写满它的速率估计写入速率，把阈值调整为约 adaptiveFillInterval 内写入的字节数：
+// 写入快时使用更大的内存表以减少刷新和合并，写入慢时使用更小的内存表，WAL 更短、重新打开时恢复更快。
+// MemTableThreshold 作为初始阈值，超出范围时取最近的边界；当前的有效阈值见 Stats.MemTableThreshold。
+func AdaptiveMemTableThreshold(min, max int) func(*LSMTree) {
+	return func(t *LSMTree) {
+		t.adaptiveMinThreshold = min
+		t.adaptiveMaxThreshold = max
+	}
+}
+
+// adaptMemTableThreshold 在当前内存表写满时按写满它的速率调整阈值，没有开启时什么也不做，调用方必须持有写锁。
+func (t *LSMTree) adaptMemTableThreshold() {
+	if t.adaptiveMaxThreshold <= 0 {
+		return
+	}
+	elapsed := time.Since(t.memTableCreated).Seconds()
+	if elapsed <= 0 {
+		return
+	}
+
+	// 指数移动平均，避免一次突发写入或短暂的空闲使阈值大幅摆动；
+	// 平均值限制在阈值范围对应的速率内，远超上限的突发写入之后阈值可以很快回落
+	rate := float64(t.memTable.bytes()) / elapsed
+	if t.writeRate > 0 {
+		rate = (t.writeRate + rate) / 2
+	}
+	interval := adaptiveFillInterval.Seconds()
+	t.writeRate = min(max(rate, float64(t.adaptiveMinThreshold)/interval), float64(t.adaptiveMaxThreshold)/interval)
+	t.memTableThreshold = int(t.writeRate * interval)
+}
@@ -0,0 +1,54 @@
+package lsmtree
+
+import (
+	"bytes"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestAdaptiveMemTableThreshold(t *testing.T) {
+	const minThreshold, maxThreshold = 4 << 10, 256 << 10
+	tree, err := Open(t.TempDir(), AdaptiveMemTableThreshold(minThreshold, maxThreshold), DisableWAL(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tree.Close()
+
+	if threshold := tree.Stats().MemTableThreshold; threshold != defaultMemTableThreshold {
+		t.Fatalf("expected the initial threshold %d, but got %d", defaultMemTableThreshold, threshold)
+	}
+
+	// 高速写入：每秒远多于 maxThreshold 字节，阈值增长到上限为止
+	value := bytes.Repeat([]byte("v"), 1000)
+	for i := 0; i < 2000; i++ {
+		if err := tree.Put([]byte(fmt.Sprintf("key%05d", i)), value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	high := tree.Stats().MemTableThreshold
+	if high <= defaultMemTableThreshold || high > maxThreshold {
+		t.Fatalf("expected the threshold to grow within (%d, %d], but got %d", defaultMemTableThreshold, maxThreshold, high)
+	}
+
+	// 写入变慢后阈值回落，但不低于下限：每个内存表都像是写了一小时才写满
+	for i := 0; i < 2000 && tree.Stats().MemTableThreshold > minThreshold; i++ {
+		tree.mu.Lock()
+		tree.memTableCreated = time.Now().Add(-time.Hour)
+		tree.mu.Unlock()
+		if err := tree.Put([]byte(fmt.Sprintf("slow%05d", i)), value); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if low := tree.Stats().MemTableThreshold; low != minThreshold {
+		t.Fatalf("expected the threshold to shrink to %d, but got %d", minThreshold, low)
+	}
+}
+
+func TestAdaptiveMemTableThresholdRange(t *testing.T) {
+	for _, r := range [][2]int{{0, 100}, {200, 100}} {
+		if _, err := Open(t.TempDir(), AdaptiveMemTableThreshold(r[0], r[1])); err == nil {
+			t.Fatalf("expected an error for range %v", r)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package lsmtree
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"strconv"
+)
+
+// Backup 将数据库当前的磁盘表、元数据、WAL 和固定的键的日志复制到 backupDir，并在其中写入只包含这些磁盘表的清单。
+// 备份目录可以通过 Open(backupDir, ReadOnly()) 挂载查询，
+// 也可以作为普通数据库目录重新打开。
+// 备份不包含纪元，从备份打开的数据库会生成新的纪元。
+func (t *LSMTree) Backup(backupDir string) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	// 没有完成的合并的磁盘表处于移动的中途，与清单不一致
+	if err := t.checkPendingCompaction(); err != nil {
+		return err
+	}
+	if err := os.MkdirAll(backupDir, 0700); err != nil {
+		return fmt.Errorf("failed to create directory %s: %w", backupDir, err)
+	}
+
+	names := []string{diskTableNumFileName}
+	oldest := t.maxDiskTableIndex - t.diskTableNum + 1
+	for index := oldest; index <= t.maxDiskTableIndex; index++ {
+		prefix := strconv.Itoa(index) + "-"
+		names = append(names,
+			prefix+diskTableDataFileName,
+			prefix+diskTableValuesFileName,
+			prefix+diskTableIndexFileName,
+			prefix+diskTableSparseIndexFileName,
+			prefix+diskTableCodecFileName,
+		)
+	}
+
+	// WAL 和固定的键的日志可能位于单独的目录，备份中总是放在备份目录下
+	srcs := []string{path.Join(t.walDir, walFileName), path.Join(t.walDir, pinnedFileName)}
+	for _, name := range names {
+		srcs = append(srcs, path.Join(t.dbDir, name))
+	}
+	var sizes []int64
+	var total int64
+	for _, src := range srcs {
+		size, err := GetFileSize(src)
+		if os.IsNotExist(err) {
+			size = -1
+		} else if err != nil {
+			return fmt.Errorf("failed to stat %s: %w", src, err)
+		} else {
+			total += size
+		}
+		sizes = append(sizes, size)
+	}
+
+	op := t.startOp(OpBackup, total, nil)
+	defer t.finishOp(op)
+	for i, src := range srcs {
+		if sizes[i] < 0 {
+			continue
+		}
+		dst := path.Join(backupDir, path.Base(src))
+		if err := copyFile(src, dst); err != nil {
+			return fmt.Errorf("failed to copy %s to %s: %w", src, dst, err)
+		}
+		op.processed.Add(sizes[i])
+	}
+
+	// 清单最后写入，它存在时备份中的磁盘表都已复制完成
+	state := manifestState{version: t.manifest.state.version, edits: 1, num: t.diskTableNum, max: t.maxDiskTableIndex}
+	return writeManifest(backupDir, state)
+}
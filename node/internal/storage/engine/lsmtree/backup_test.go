@@ -0,0 +1,92 @@
+package lsmtree
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"strconv"
+	"testing"
+)
+
+func TestOpenBackupReadOnly(t *testing.T) {
+	dbDir, err := os.MkdirTemp(os.TempDir(), "example")
+	if err != nil {
+		panic(fmt.Errorf("failed to create %s: %w", dbDir, err))
+	}
+	defer func() {
+		if err := os.RemoveAll(dbDir); err != nil {
+			panic(fmt.Errorf("failed to remove %s: %w", dbDir, err))
+		}
+	}()
+	backupDir := path.Join(dbDir, "backup")
+
+	tree, err := Open(dbDir, MemTableThreshold(100))
+	if err != nil {
+		panic(fmt.Errorf("failed to open LSM tree %s: %w", dbDir, err))
+	}
+
+	for i := 1; i <= 1000; i++ {
+		key := strconv.Itoa(i)
+		if err := tree.Put([]byte(key), []byte(key)); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+
+	if err := tree.Backup(backupDir); err != nil {
+		t.Fatalf("failed to backup: %s", err)
+	}
+
+	backup, err := Open(backupDir, ReadOnly())
+	if err != nil {
+		t.Fatalf("failed to open backup read-only: %s", err)
+	}
+
+	// 原数据库继续写入，不影响备份
+	for i := 1; i <= 1000; i++ {
+		key := strconv.Itoa(i)
+		if err := tree.Put([]byte(key), []byte("new")); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+
+	for i := 1; i <= 1000; i++ {
+		key := strconv.Itoa(i)
+		value, ok, err := backup.Get([]byte(key))
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if !ok || string(value) != key {
+			t.Fatalf("value is wrong for key %s: %s != %s", key, key, value)
+		}
+	}
+
+	if err := backup.Put([]byte("key"), []byte("value")); !errors.Is(err, ErrReadOnly) {
+		t.Fatalf("expected %v, but got %v", ErrReadOnly, err)
+	}
+	if err := backup.Delete([]byte("key")); !errors.Is(err, ErrReadOnly) {
+		t.Fatalf("expected %v, but got %v", ErrReadOnly, err)
+	}
+	if err := backup.Close(); err != nil {
+		panic(fmt.Errorf("failed to close: %w", err))
+	}
+
+	// 备份不带 WAL 时跳过重放
+	if err := os.Remove(path.Join(backupDir, walFileName)); err != nil {
+		panic(fmt.Errorf("failed to remove WAL: %w", err))
+	}
+	backup, err = Open(backupDir, ReadOnly())
+	if err != nil {
+		t.Fatalf("failed to open backup without WAL: %s", err)
+	}
+	if _, err := os.Stat(path.Join(backupDir, walFileName)); !os.IsNotExist(err) {
+		t.Fatalf("read-only open must not create the WAL")
+	}
+	if err := backup.Close(); err != nil {
+		panic(fmt.Errorf("failed to close: %w", err))
+	}
+
+	if err := tree.Close(); err != nil {
+		panic(fmt.Errorf("failed to close: %w", err))
+	}
+}
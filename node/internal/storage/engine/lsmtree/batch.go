@@ -0,0 +1,193 @@
+package lsmtree
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// WAL 中的批量写入以一条标记记录开头：键为空（正常写入的键不能为空），值为批量中记录的数量，之后是批量中的记录。
+// 标记不是写入，不占用序号；读取 WAL 时批量中的记录要么全部应用，要么在末尾不完整时全部丢弃。
+const batchMarkerLen = 8
+
+// ErrBatchRejected 当 ApplyBatch 的批量中有不合法的写入时返回，此时整个批量都没有写入。
+var ErrBatchRejected = errors.New("batch rejected because of invalid entries")
+
+// PutBatch 在一次写锁和一次 WAL 同步中写入多个键值对，keys 和 values 一一对应。
+// 键或值不合法的键值对被跳过，错误记录在返回的切片中对应的位置；其余键值对原子地写入：
+// 崩溃后重新打开时它们要么全部存在，要么全部不存在，读取也不会看到只写入了一部分的批量。
+// 同一个键在批量中出现多次时后面的值生效，固定的键返回 ErrKeyPinned（详见 PutPinned）。
+// 整个批量失败（例如写 WAL 失败或只读）时返回第二个错误。
+func (t *LSMTree) PutBatch(keys, values [][]byte) ([]error, error) {
+	return t.applyBatch(keys, values, batchOptions{skipWAL: t.disableWAL})
+}
+
+// PutBatchNoWAL 与 PutBatch 相同，但这一个批量不写入 WAL（其他写入不受影响，详见 DisableWAL）：
+// 批量只有在内存表刷新为磁盘表之后才持久化，进程崩溃或者没有调用 Flush 就 Close 都会丢失它，
+// 批量中的键在重新打开后恢复为 WAL 或磁盘表中更早的值。批量不占用序号，开启 ArchiveWAL 时返回错误。
+// 只应用于可以从源头重新导入的数据，例如批量导入，导入完成后调用 Flush。
+func (t *LSMTree) PutBatchNoWAL(keys, values [][]byte) ([]error, error) {
+	if t.walRetention > 0 {
+		return nil, errors.New("WAL archiving requires the WAL to be enabled")
+	}
+	return t.applyBatch(keys, values, batchOptions{skipWAL: true})
+}
+
+// ApplyBatch 与 PutBatch 一样在一次 WAL 写入中原子地写入批量，但 values 中为 nil 的位置删除对应的键，
+// 并且批量是全有或全无的：任何一个写入不合法时整个批量都不写入，
+// 错误记录在返回的切片中对应的位置，第二个返回值为 ErrBatchRejected。
+func (t *LSMTree) ApplyBatch(keys, values [][]byte) ([]error, error) {
+	return t.applyBatch(keys, values, batchOptions{skipWAL: t.disableWAL, deletes: true, rejectInvalid: true})
+}
+
+// batchOptions 是 applyBatch 在不同的批量写入之间的差别。
+type batchOptions struct {
+	// 不写入 WAL，详见 PutBatchNoWAL
+	skipWAL bool
+	// 值为 nil 表示删除键，详见 ApplyBatch
+	deletes bool
+	// 有不合法的写入时拒绝整个批量，而不是只跳过它们
+	rejectInvalid bool
+}
+
+// applyBatch 实现 PutBatch、PutBatchNoWAL 和 ApplyBatch。
+func (t *LSMTree) applyBatch(keys, values [][]byte, opts batchOptions) ([]error, error) {
+	if len(keys) != len(values) {
+		return nil, fmt.Errorf("%d keys but %d values", len(keys), len(values))
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.readOnly {
+		return nil, ErrReadOnly
+	}
+
+	errs := make([]error, len(keys))
+	var validKeys, validValues [][]byte
+	invalid := false
+	for i := range keys {
+		if _, pinned := t.pinned.get(keys[i]); pinned {
+			errs[i] = ErrKeyPinned
+		} else if opts.deletes && values[i] == nil {
+			errs[i] = t.checkKey(keys[i])
+		} else {
+			errs[i] = t.checkEntry(keys[i], values[i])
+		}
+		if errs[i] != nil {
+			invalid = true
+			continue
+		}
+		validKeys = append(validKeys, keys[i])
+		validValues = append(validValues, values[i])
+	}
+	if invalid && opts.rejectInvalid {
+		return errs, ErrBatchRejected
+	}
+	if len(validKeys) == 0 {
+		return errs, nil
+	}
+
+	if !opts.skipWAL {
+		n, err := appendBatchToWAL(t.wal, validKeys, validValues)
+		t.stats.WALBytesWritten += int64(n)
+		if err != nil {
+			return nil, fmt.Errorf("failed to append to file %s: %w", t.wal.Name(), err)
+		}
+		t.stats.WALSyncs++
+		t.walSeq += uint64(len(validKeys))
+		t.notifyWALAppended()
+	}
+	atomic.StoreInt64(&t.lastWrite, time.Now().UnixNano())
+
+	// 批量中的记录全部写入当前的内存表之后才检查阈值，不会被刷新分到两个磁盘表中
+	for i, key := range validKeys {
+		t.stats.UserBytesWritten += int64(len(key) + len(validValues[i]))
+		if validValues[i] == nil {
+			t.memTable.delete(key)
+			continue
+		}
+		t.memTable.putWithFlags(key, validValues[i], 0)
+		t.negatives.remove(key)
+	}
+
+	return errs, t.flushIfNeeded()
+}
+
+// appendBatchToWAL 把标记记录和批量中的记录一次写入 WAL 文件并同步，返回写入的字节数。
+func appendBatchToWAL(wal *os.File, keys, values [][]byte) (int, error) {
+	if _, err := wal.Seek(0, io.SeekEnd); err != nil {
+		return 0, fmt.Errorf("failed to seek to the end: %w", err)
+	}
+
+	var buf bytes.Buffer
+	if _, err := encode(nil, encodeInt(len(keys)), &buf); err != nil {
+		return 0, err
+	}
+	for i := range keys {
+		if _, err := encodeWithFlags(keys[i], values[i], 0, &buf); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := wal.Write(buf.Bytes())
+	if err != nil {
+		return n, fmt.Errorf("failed to write to the file: %w", err)
+	}
+	if err := wal.Sync(); err != nil {
+		return n, fmt.Errorf("failed to sync the file: %w", err)
+	}
+	return n, nil
+}
+
+// batchMarker 判断记录是否为批量的标记记录，是时返回批量中记录的数量。
+func batchMarker(key, value []byte) (int, bool) {
+	if len(key) != 0 || len(value) != batchMarkerLen {
+		return 0, false
+	}
+	return decodeInt(value), true
+}
+
+// decodeWALRecord 从 WAL 中解码下一条写入，跳过批量的标记记录。
+// 用于读取已经完整写入的 WAL（例如归档段），不检查批量是否完整。
+func decodeWALRecord(r io.Reader) ([]byte, []byte, uint32, error) {
+	for {
+		key, value, flags, err := decodeRecordWithFlags(r, true)
+		if err != nil {
+			return nil, nil, 0, err
+		}
+		if _, ok := batchMarker(key, value); !ok {
+			return key, value, flags, nil
+		}
+	}
+}
+
+// readBatch 读取标记记录之后的 n 条记录。批量没有写完时返回 io.ErrUnexpectedEOF。
+func readBatch(r io.Reader, n int) ([]walRecord, error) {
+	records := make([]walRecord, 0, n)
+	for i := 0; i < n; i++ {
+		key, value, flags, err := decodeRecordWithFlags(r, true)
+		if err == io.EOF {
+			return nil, io.ErrUnexpectedEOF
+		}
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := batchMarker(key, value); ok {
+			return nil, errors.New("nested batch marker")
+		}
+		records = append(records, walRecord{key: key, value: value, flags: flags})
+	}
+	return records, nil
+}
+
+// walRecord 是从 WAL 中读取的一条写入。
+type walRecord struct {
+	key   []byte
+	value []byte
+	flags uint32
+}
@@ -0,0 +1,194 @@
+package lsmtree
+
+import (
+	"errors"
+	"os"
+	"path"
+	"strings"
+	"testing"
+)
+
+func TestPutBatch(t *testing.T) {
+	dbDir := t.TempDir()
+	tree, err := Open(dbDir, KeySizeLimit(8), ArchiveWAL(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keys := [][]byte{[]byte("a"), []byte(strings.Repeat("k", 9)), []byte("b"), []byte("c")}
+	values := [][]byte{[]byte("1"), []byte("2"), []byte("3"), nil}
+	errs, err := tree.PutBatch(keys, values)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if errs[0] != nil || !errors.Is(errs[1], ErrKeyTooLarge) || errs[2] != nil || !errors.Is(errs[3], ErrValueRequired) {
+		t.Fatalf("unexpected per-key errors: %v", errs)
+	}
+	if seq := tree.Sequence(); seq != 2 {
+		t.Fatalf("expected sequence 2, got %d", seq)
+	}
+	if err := tree.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// 重新打开后从 WAL 恢复批量
+	tree, err = Open(dbDir, KeySizeLimit(8), ArchiveWAL(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tree.Close()
+	for key, expected := range map[string]string{"a": "1", "b": "3"} {
+		if value, exists, err := tree.Get([]byte(key)); err != nil || !exists || string(value) != expected {
+			t.Fatalf("expected %s=%s, got %q (%t, %v)", key, expected, value, exists, err)
+		}
+	}
+	if seq := tree.Sequence(); seq != 2 {
+		t.Fatalf("expected sequence 2 after reopening, got %d", seq)
+	}
+}
+
+func TestOpenDropsIncompleteBatch(t *testing.T) {
+	dbDir := t.TempDir()
+	walFile, err := os.OpenFile(path.Join(dbDir, walFileName), os.O_RDWR|os.O_CREATE, 0600)
+	if err != nil {
+		t.Fatal(err)
+	}
+	valid, err := appendToWAL(walFile, []byte("key0"), []byte("value0"), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, err := appendBatchToWAL(walFile, [][]byte{[]byte("key1"), []byte("key2")}, [][]byte{[]byte("value1"), []byte("value2")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 批量的最后一条记录没有写完
+	if err := walFile.Truncate(int64(valid + n - 1)); err != nil {
+		t.Fatal(err)
+	}
+	walFile.Close()
+
+	tree, err := Open(dbDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tree.Close()
+	if _, exists, _ := tree.Get([]byte("key0")); !exists {
+		t.Fatal("the record before the batch should be recovered")
+	}
+	for _, key := range []string{"key1", "key2"} {
+		if _, exists, _ := tree.Get([]byte(key)); exists {
+			t.Fatalf("%s of the incomplete batch should not be recovered", key)
+		}
+	}
+	if info, err := os.Stat(path.Join(dbDir, walFileName)); err != nil || info.Size() != int64(valid) {
+		t.Fatalf("expected the WAL to be truncated to %d bytes, got %v (%v)", valid, info.Size(), err)
+	}
+}
+
+func TestPutBatchNoWAL(t *testing.T) {
+	dbDir := t.TempDir()
+	tree, err := Open(dbDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	put := func(noWAL bool, key, value string) {
+		putBatch := tree.PutBatch
+		if noWAL {
+			putBatch = tree.PutBatchNoWAL
+		}
+		if errs, err := putBatch([][]byte{[]byte(key)}, [][]byte{[]byte(value)}); err != nil || errs[0] != nil {
+			t.Fatalf("failed to put %s: %v %v", key, errs, err)
+		}
+	}
+	put(false, "logged", "old")
+	put(true, "flushed", "value")
+	if err := tree.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	put(false, "logged", "v1")
+	put(true, "logged", "v2")
+	put(true, "unflushed", "value")
+	if seq := tree.Sequence(); seq != 2 {
+		t.Fatalf("expected batches without the WAL not to take sequences, got %d", seq)
+	}
+	if value, exists, _ := tree.Get([]byte("unflushed")); !exists || string(value) != "value" {
+		t.Fatalf("expected unflushed to be readable before reopening, got %q (%t)", value, exists)
+	}
+
+	// 不刷新直接关闭：没有写入 WAL 的批量丢失，同一个键恢复为 WAL 中的值
+	if err := tree.Close(); err != nil {
+		t.Fatal(err)
+	}
+	tree, err = Open(dbDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tree.Close()
+	for key, expected := range map[string]string{"flushed": "value", "logged": "v1"} {
+		if value, exists, err := tree.Get([]byte(key)); err != nil || !exists || string(value) != expected {
+			t.Fatalf("expected %s=%s, got %q (%t, %v)", key, expected, value, exists, err)
+		}
+	}
+	if _, exists, err := tree.Get([]byte("unflushed")); err != nil || exists {
+		t.Fatalf("expected the unflushed batch to be lost, got %t (%v)", exists, err)
+	}
+
+	archived, err := Open(t.TempDir(), ArchiveWAL(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer archived.Close()
+	if _, err := archived.PutBatchNoWAL([][]byte{[]byte("key")}, [][]byte{[]byte("value")}); err == nil {
+		t.Fatal("expected an error when archiving the WAL")
+	}
+}
+
+func TestApplyBatch(t *testing.T) {
+	dbDir := t.TempDir()
+	tree, err := Open(dbDir, KeySizeLimit(8))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.Put([]byte("old"), []byte("value")); err != nil {
+		t.Fatal(err)
+	}
+
+	// 有一个不合法的写入时整个批量都不写入
+	keys := [][]byte{[]byte("a"), []byte("old"), []byte(strings.Repeat("k", 9))}
+	values := [][]byte{[]byte("1"), nil, []byte("2")}
+	errs, err := tree.ApplyBatch(keys, values)
+	if !errors.Is(err, ErrBatchRejected) {
+		t.Fatalf("expected ErrBatchRejected, got %v", err)
+	}
+	if errs[0] != nil || errs[1] != nil || !errors.Is(errs[2], ErrKeyTooLarge) {
+		t.Fatalf("unexpected per-key errors: %v", errs)
+	}
+	if _, exists, _ := tree.Get([]byte("a")); exists {
+		t.Fatal("expected the rejected batch not to be written")
+	}
+	if _, exists, _ := tree.Get([]byte("old")); !exists {
+		t.Fatal("expected the rejected batch not to delete old")
+	}
+
+	errs, err = tree.ApplyBatch(keys[:2], values[:2])
+	if err != nil || errs[0] != nil || errs[1] != nil {
+		t.Fatalf("unexpected errors: %v %v", errs, err)
+	}
+	if err := tree.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// 写入和删除都从 WAL 中恢复
+	tree, err = Open(dbDir, KeySizeLimit(8))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tree.Close()
+	if value, exists, err := tree.Get([]byte("a")); err != nil || !exists || string(value) != "1" {
+		t.Fatalf("expected a=1, got %q (%t, %v)", value, exists, err)
+	}
+	if _, exists, err := tree.Get([]byte("old")); err != nil || exists {
+		t.Fatalf("expected old to be deleted, got %t (%v)", exists, err)
+	}
+}
@@ -0,0 +1,126 @@
+package lsmtree
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// 后台操作的类型，详见 OpStatus。
+const (
+	OpFlush           = "flush"
+	OpCompaction      = "compaction"
+	OpMajorCompaction = "major-compaction"
+	OpBackup          = "backup"
+)
+
+var (
+	// ErrOpNotFound 当 CancelOp 的操作不存在或已经结束时返回。
+	ErrOpNotFound = errors.New("background operation not found")
+	// ErrOpNotCancellable 当 CancelOp 的操作不能取消时返回。
+	ErrOpNotCancellable = errors.New("background operation is not cancellable")
+)
+
+// OpStatus 是一个正在进行的后台操作的状态。
+type OpStatus struct {
+	// 操作的编号，在数据库打开期间唯一，用于 CancelOp
+	ID uint64
+	// 操作的类型，OpFlush、OpCompaction、OpMajorCompaction 或 OpBackup
+	Type    string
+	Started time.Time
+	// 已经处理的字节数和需要处理的总字节数，BytesTotal 为 0 表示操作不报告进度
+	BytesProcessed int64
+	BytesTotal     int64
+	// 操作能否通过 CancelOp 取消
+	Cancellable bool
+}
+
+// backgroundOp 是登记在 backgroundOps 中的一个操作。
+type backgroundOp struct {
+	id        uint64
+	kind      string
+	started   time.Time
+	total     int64
+	processed atomic.Int64
+	cancel    context.CancelFunc
+}
+
+func (op *backgroundOp) status() OpStatus {
+	processed := op.processed.Load()
+	// 开始时 stat 得到的大小与处理的字节数不一定完全一致，进度不超过 100%
+	if processed > op.total {
+		processed = op.total
+	}
+	return OpStatus{
+		ID:             op.id,
+		Type:           op.kind,
+		Started:        op.started,
+		BytesProcessed: processed,
+		BytesTotal:     op.total,
+		Cancellable:    op.cancel != nil,
+	}
+}
+
+// backgroundOps 登记正在进行的后台操作。刷新、合并和备份持有写锁，因此它使用单独的锁。
+type backgroundOps struct {
+	mu      sync.Mutex
+	lastID  uint64
+	running map[uint64]*backgroundOp
+}
+
+// startOp 登记一个 kind 类型的操作，cancel 为 nil 时操作不能取消。操作结束时必须调用 finishOp。
+func (t *LSMTree) startOp(kind string, total int64, cancel context.CancelFunc) *backgroundOp {
+	t.ops.mu.Lock()
+	defer t.ops.mu.Unlock()
+
+	if t.ops.running == nil {
+		t.ops.running = make(map[uint64]*backgroundOp)
+	}
+	t.ops.lastID++
+	op := &backgroundOp{id: t.ops.lastID, kind: kind, started: time.Now(), total: total, cancel: cancel}
+	t.ops.running[op.id] = op
+	return op
+}
+
+// finishOp 移除 startOp 登记的操作。
+func (t *LSMTree) finishOp(op *backgroundOp) {
+	t.ops.mu.Lock()
+	defer t.ops.mu.Unlock()
+	delete(t.ops.running, op.id)
+}
+
+// BackgroundOps 返回正在进行的刷新、合并和备份，按开始的先后排列。不获取树的锁，可以在这些操作进行时调用。
+func (t *LSMTree) BackgroundOps() []OpStatus {
+	t.ops.mu.Lock()
+	defer t.ops.mu.Unlock()
+
+	ops := make([]OpStatus, 0, len(t.ops.running))
+	for _, op := range t.ops.running {
+		ops = append(ops, op.status())
+	}
+	sort.Slice(ops, func(i, j int) bool {
+		return ops[i].ID < ops[j].ID
+	})
+	return ops
+}
+
+// CancelOp 取消编号为 id 的操作，返回时操作可能还没有结束。目前只有完全合并可以取消，
+// 被取消的 MajorCompact 返回 context.Canceled，数据库保持合并前的状态。
+// 操作不存在或已经结束时返回 ErrOpNotFound，不能取消时返回 ErrOpNotCancellable。
+func (t *LSMTree) CancelOp(id uint64) error {
+	t.ops.mu.Lock()
+	op, ok := t.ops.running[id]
+	t.ops.mu.Unlock()
+
+	if !ok {
+		return ErrOpNotFound
+	}
+	if op.cancel == nil {
+		return ErrOpNotCancellable
+	}
+	op.cancel()
+	return nil
+}
@@ -0,0 +1,95 @@
+package lsmtree
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+func TestCancelMajorCompaction(t *testing.T) {
+	tree, err := Open(t.TempDir(), MemTableThreshold(64<<20), DiskTableNumThreshold(100), DisableWAL(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tree.Close()
+
+	// 四个各约 10 MB 的磁盘表
+	value := bytes.Repeat([]byte("v"), 500)
+	for table := 0; table < 4; table++ {
+		for i := 0; i < 20000; i++ {
+			if err := tree.Put([]byte(fmt.Sprintf("key%06d", i)), value); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := tree.Flush(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if ops := tree.BackgroundOps(); len(ops) != 0 {
+		t.Fatalf("expected no background operations, but got %v", ops)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- tree.MajorCompact()
+	}()
+
+	var op OpStatus
+	for found := false; !found; {
+		for _, status := range tree.BackgroundOps() {
+			if status.Type == OpMajorCompaction {
+				op, found = status, true
+			}
+		}
+	}
+	if !op.Cancellable || op.BytesTotal == 0 || op.Started.IsZero() {
+		t.Fatalf("unexpected status %+v", op)
+	}
+	if err := tree.CancelOp(op.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-done; !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected the major compaction to be cancelled, but got %v", err)
+	}
+
+	if ops := tree.BackgroundOps(); len(ops) != 0 {
+		t.Fatalf("expected no background operations after cancelling, but got %v", ops)
+	}
+	if err := tree.CancelOp(op.ID); !errors.Is(err, ErrOpNotFound) {
+		t.Fatalf("expected ErrOpNotFound, but got %v", err)
+	}
+	if tree.diskTableNum != 4 {
+		t.Fatalf("expected the disk tables to be kept, but got %d", tree.diskTableNum)
+	}
+	if got, exists, err := tree.Get([]byte("key012345")); err != nil || !exists || !bytes.Equal(got, value) {
+		t.Fatalf("unexpected value after cancelling (%t, %v)", exists, err)
+	}
+
+	// 合并结束后可以重新进行
+	if err := tree.MajorCompact(); err != nil {
+		t.Fatal(err)
+	}
+	if tree.diskTableNum != 1 {
+		t.Fatalf("expected one disk table, but got %d", tree.diskTableNum)
+	}
+}
+
+func TestCancelOpNotCancellable(t *testing.T) {
+	tree, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tree.Close()
+
+	op := tree.startOp(OpFlush, 0, nil)
+	ops := tree.BackgroundOps()
+	if len(ops) != 1 || ops[0].Type != OpFlush || ops[0].Cancellable {
+		t.Fatalf("unexpected operations %v", ops)
+	}
+	if err := tree.CancelOp(op.id); !errors.Is(err, ErrOpNotCancellable) {
+		t.Fatalf("expected ErrOpNotCancellable, but got %v", err)
+	}
+	tree.finishOp(op)
+}
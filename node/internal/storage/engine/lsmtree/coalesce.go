@@ -0,0 +1,80 @@
+package lsmtree
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// CoalesceSmallTables 开启后台合并小磁盘表（默认关闭）：没有写入的时间达到 idle 后，
+// 后台任务依次合并数据文件都小于 minSize 的相邻磁盘表，直到没有这样的表对或者有新的写入。
+// 频繁的小刷新会留下大量很小的磁盘表，即使总数没有达到 DiskTableNumThreshold，
+// 每次未命中的读取也要逐个查找它们；空闲时把它们合并可以让读取保持较少的磁盘表。
+// 该合并不经过合并策略，与策略触发的合并共用写锁，不会同时进行。
+func CoalesceSmallTables(minSize int, idle time.Duration) func(*LSMTree) {
+	return func(t *LSMTree) {
+		t.coalesceMinSize = minSize
+		t.coalesceIdle = idle
+	}
+}
+
+// startCoalescing 启动后台合并小磁盘表的任务，Close 时退出。
+func (t *LSMTree) startCoalescing() {
+	atomic.StoreInt64(&t.lastWrite, time.Now().UnixNano())
+
+	t.background.Add(1)
+	go func() {
+		defer t.background.Done()
+
+		ticker := time.NewTicker(t.coalesceIdle)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-t.ctx.Done():
+				return
+			case <-ticker.C:
+			}
+			for t.idleFor(t.coalesceIdle) {
+				merged, err := t.coalesceOnce()
+				if err != nil {
+					t.logger.Error("failed to coalesce small disk tables: %v", err)
+				}
+				if !merged || err != nil {
+					break
+				}
+			}
+		}
+	}()
+}
+
+// idleFor 判断没有写入的时间是否达到 idle。
+func (t *LSMTree) idleFor(idle time.Duration) bool {
+	return t.sinceLastWrite() >= idle
+}
+
+// sinceLastWrite 返回距离最近一次写入的时间。
+func (t *LSMTree) sinceLastWrite() time.Duration {
+	return time.Since(time.Unix(0, atomic.LoadInt64(&t.lastWrite)))
+}
+
+// coalesceOnce 合并从最旧的开始第一对数据文件都小于 coalesceMinSize 的相邻磁盘表，返回是否合并。
+func (t *LSMTree) coalesceOnce() (bool, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	// Close 先取消 ctx 再获取写锁，之后不再修改磁盘表
+	if t.ctx.Err() != nil || t.majorDone != nil {
+		return false, nil
+	}
+	tables := t.tableInfos()
+	for i := 0; i+1 < len(tables); i++ {
+		a, b := tables[i], tables[i+1]
+		if b.Index != a.Index+1 || a.DataSize >= t.coalesceMinSize || b.DataSize >= t.coalesceMinSize {
+			continue
+		}
+		if err := t.compactPair(t.ctx, a.Index, b.Index); err != nil {
+			return false, err
+		}
+		return true, nil
+	}
+	return false, nil
+}
@@ -0,0 +1,81 @@
+package lsmtree
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestCoalesceSmallTables(t *testing.T) {
+	dbDir := t.TempDir()
+	tree, err := Open(dbDir,
+		MemTableMaxEntries(1),
+		ImmutableMemtableMaxNum(1),
+		DiskTableNumThreshold(100),
+		CoalesceSmallTables(4096, 50*time.Millisecond),
+	)
+	if err != nil {
+		t.Fatalf("failed to open LSM tree %s: %s", dbDir, err)
+	}
+	defer tree.Close()
+
+	// 每次写入都刷新为一张很小的磁盘表，数量远低于阈值
+	count := 40
+	for i := 0; i < count; i++ {
+		if err := tree.Put([]byte(fmt.Sprintf("key%02d", i)), []byte("value")); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+
+	tableNum := func() int {
+		tree.mu.RLock()
+		defer tree.mu.RUnlock()
+		return tree.diskTableNum
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for tableNum() > 1 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// 合并后的表仍然小于下限，因此所有小表被合并为一张
+	if n := tableNum(); n != 1 {
+		t.Fatalf("expected small tables to be coalesced during idle, but got %d disk tables", n)
+	}
+	for i := 0; i < count; i++ {
+		key := fmt.Sprintf("key%02d", i)
+		if value, ok, err := tree.Get([]byte(key)); err != nil || !ok || string(value) != "value" {
+			t.Fatalf("failed to read %s: %v", key, err)
+		}
+	}
+
+	if _, err := Open(t.TempDir(), CoalesceSmallTables(1024, 0)); err == nil {
+		t.Fatalf("expected an error for a zero idle time")
+	}
+}
+
+func TestCoalesceWaitsForIdle(t *testing.T) {
+	dbDir := t.TempDir()
+	tree, err := Open(dbDir,
+		MemTableMaxEntries(1),
+		ImmutableMemtableMaxNum(1),
+		DiskTableNumThreshold(100),
+		CoalesceSmallTables(1024, time.Hour),
+	)
+	if err != nil {
+		t.Fatalf("failed to open LSM tree %s: %s", dbDir, err)
+	}
+
+	for i := 0; i < 5; i++ {
+		if err := tree.Put([]byte(fmt.Sprintf("key%02d", i)), []byte("value")); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+
+	// 没有空闲足够久时不合并，Close 会停止后台任务
+	if err := tree.Close(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if tree.diskTableNum != 5 {
+		t.Fatalf("expected 5 disk tables, but got %d", tree.diskTableNum)
+	}
+}
@@ -0,0 +1,169 @@
+package lsmtree
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+
+	"github.com/klauspost/compress/snappy"
+	"github.com/klauspost/compress/zstd"
+)
+
+// diskTableCodecFileName 是记录磁盘表格式的文件名，内容为一个字节的 Codec，
+// 使用 FixedRecords 以外的记录编码时之后再跟一个字节的 RecordFormat，
+// 分开存放值（详见 SeparateValues）时总是写入 RecordFormat，最后再跟一个字节 1。
+// 不压缩并且使用 FixedRecords 的磁盘表没有这个文件，因此引入压缩之前写入的磁盘表不需要迁移。
+const diskTableCodecFileName = "codec"
+
+// Codec 是磁盘表数据文件中值的压缩算法，每个磁盘表单独记录自己使用的算法。
+// 修改 Compression 只影响之后刷新和合并写入的磁盘表，旧的磁盘表仍按写入时的算法读取，
+// 在合并时以当前的算法重写。键、索引和稀疏索引不压缩。
+type Codec byte
+
+const (
+	// NoCompression 不压缩，是默认值。
+	NoCompression Codec = iota
+	// Snappy 压缩和解压都很快，压缩率较低。
+	Snappy
+	// Zstd 压缩率更高，但压缩更慢。
+	Zstd
+)
+
+// zstd 的编码器和解码器的 EncodeAll、DecodeAll 可以并发调用，全局共享一份。
+var (
+	zstdEncoder, _ = zstd.NewWriter(nil)
+	zstdDecoder, _ = zstd.NewReader(nil)
+)
+
+// Compression 设置新写入的磁盘表使用的压缩算法（默认 NoCompression）。
+func Compression(codec Codec) func(*LSMTree) {
+	return func(t *LSMTree) {
+		t.compression = codec
+	}
+}
+
+func (c Codec) String() string {
+	switch c {
+	case NoCompression:
+		return "none"
+	case Snappy:
+		return "snappy"
+	case Zstd:
+		return "zstd"
+	default:
+		return fmt.Sprintf("codec(%d)", byte(c))
+	}
+}
+
+// valid 返回 c 是否是已知的压缩算法。
+func (c Codec) valid() bool {
+	return c <= Zstd
+}
+
+// compress 压缩值，墓碑（nil）保持为 nil。
+func (c Codec) compress(value []byte) []byte {
+	if value == nil {
+		return nil
+	}
+
+	switch c {
+	case Snappy:
+		return snappy.Encode(nil, value)
+	case Zstd:
+		return zstdEncoder.EncodeAll(value, nil)
+	default:
+		return value
+	}
+}
+
+// decompress 解压 compress 的结果，墓碑（nil）保持为 nil。
+func (c Codec) decompress(value []byte) ([]byte, error) {
+	if value == nil {
+		return nil, nil
+	}
+
+	var decoded []byte
+	var err error
+	switch c {
+	case NoCompression:
+		return value, nil
+	case Snappy:
+		decoded, err = snappy.Decode(nil, value)
+	case Zstd:
+		decoded, err = zstdDecoder.DecodeAll(value, nil)
+	default:
+		return nil, fmt.Errorf("unknown codec %d", byte(c))
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to decompress %s value: %w", c, err)
+	}
+	// 压缩前的值不是墓碑，解压后的空值也不能变成墓碑
+	if decoded == nil {
+		decoded = []byte{}
+	}
+
+	return decoded, nil
+}
+
+// tableFormat 是磁盘表数据文件的格式，每个磁盘表单独记录。
+type tableFormat struct {
+	// 值的压缩算法
+	codec Codec
+	// 记录的编码
+	records RecordFormat
+	// 值是否存放在单独的值文件中，详见 SeparateValues
+	separateValues bool
+}
+
+// writeTableFormat 记录磁盘表的格式，不压缩并且使用 FixedRecords 时不写文件。
+func writeTableFormat(dbDir, prefix string, format tableFormat) error {
+	if format == (tableFormat{}) {
+		return nil
+	}
+
+	data := []byte{byte(format.codec)}
+	if format.records != FixedRecords || format.separateValues {
+		data = append(data, byte(format.records))
+	}
+	if format.separateValues {
+		data = append(data, 1)
+	}
+	filePath := path.Join(dbDir, prefix+diskTableCodecFileName)
+	if err := os.WriteFile(filePath, data, 0600); err != nil {
+		return fmt.Errorf("failed to write %s: %w", filePath, err)
+	}
+
+	return nil
+}
+
+// readTableFormat 返回磁盘表的格式，没有记录时为 NoCompression 和 FixedRecords。
+func readTableFormat(dbDir, prefix string) (tableFormat, error) {
+	filePath := path.Join(dbDir, prefix+diskTableCodecFileName)
+	data, err := os.ReadFile(filePath)
+	if errors.Is(err, os.ErrNotExist) {
+		return tableFormat{}, nil
+	}
+	if err != nil {
+		return tableFormat{}, fmt.Errorf("failed to read %s: %w", filePath, err)
+	}
+
+	if len(data) < 1 || len(data) > 3 || !Codec(data[0]).valid() {
+		return tableFormat{}, fmt.Errorf("the file %s is corrupted, invalid codec %v", filePath, data)
+	}
+	format := tableFormat{codec: Codec(data[0])}
+	if len(data) >= 2 {
+		format.records = RecordFormat(data[1])
+		if (len(data) == 2 && format.records == FixedRecords) || !format.records.valid() {
+			return tableFormat{}, fmt.Errorf("the file %s is corrupted, invalid record format %v", filePath, data)
+		}
+	}
+	if len(data) == 3 {
+		if data[2] != 1 {
+			return tableFormat{}, fmt.Errorf("the file %s is corrupted, invalid value layout %v", filePath, data)
+		}
+		format.separateValues = true
+	}
+
+	return format, nil
+}
@@ -0,0 +1,137 @@
+package lsmtree
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestCodecRoundTrip(t *testing.T) {
+	for _, codec := range []Codec{NoCompression, Snappy, Zstd} {
+		for _, value := range [][]byte{nil, []byte("x"), []byte(strings.Repeat("value", 100))} {
+			decoded, err := codec.decompress(codec.compress(value))
+			if err != nil {
+				t.Fatalf("%s: unexpected error: %s", codec, err)
+			}
+			if (value == nil) != (decoded == nil) || string(decoded) != string(value) {
+				t.Fatalf("%s: expected %q, but got %q", codec, value, decoded)
+			}
+		}
+	}
+
+	if _, err := Open(t.TempDir(), Compression(Codec(100))); err == nil {
+		t.Fatalf("expected an error for an unknown codec")
+	}
+}
+
+func TestMixedCodecs(t *testing.T) {
+	dbDir := t.TempDir()
+	value := func(i, version int) []byte {
+		return []byte(fmt.Sprintf("%s-%d-%d", strings.Repeat("v", 200), i, version))
+	}
+	options := []func(*LSMTree){MemTableMaxEntries(10), ImmutableMemtableMaxNum(1), DiskTableNumThreshold(100)}
+
+	// 先用 Snappy 写入一批磁盘表
+	tree, err := Open(dbDir, append(options, Compression(Snappy))...)
+	if err != nil {
+		t.Fatalf("failed to open LSM tree %s: %s", dbDir, err)
+	}
+	for i := 0; i < 60; i++ {
+		if err := tree.Put([]byte(fmt.Sprintf("key%03d", i)), value(i, 1)); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+	if err := tree.Close(); err != nil {
+		t.Fatalf("failed to close: %s", err)
+	}
+
+	// 修改压缩算法后再写入，一半的键被覆盖，部分键被删除
+	tree, err = Open(dbDir, append(options, Compression(Zstd))...)
+	if err != nil {
+		t.Fatalf("failed to open LSM tree %s: %s", dbDir, err)
+	}
+	defer tree.Close()
+	expected := make(map[string][]byte)
+	for i := 0; i < 60; i++ {
+		expected[fmt.Sprintf("key%03d", i)] = value(i, 1)
+	}
+	for i := 30; i < 90; i++ {
+		key := fmt.Sprintf("key%03d", i)
+		if err := tree.Put([]byte(key), value(i, 2)); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		expected[key] = value(i, 2)
+	}
+	for i := 0; i < 90; i += 9 {
+		key := fmt.Sprintf("key%03d", i)
+		if err := tree.Delete([]byte(key)); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		delete(expected, key)
+	}
+
+	codecs := make(map[Codec]int)
+	oldest := tree.maxDiskTableIndex - tree.diskTableNum + 1
+	for index := oldest; index <= tree.maxDiskTableIndex; index++ {
+		format, err := readTableFormat(dbDir, strconv.Itoa(index)+"-")
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		codecs[format.codec]++
+	}
+	if codecs[Snappy] == 0 || codecs[Zstd] == 0 || codecs[NoCompression] != 0 {
+		t.Fatalf("expected both snappy and zstd tables, but got %v", codecs)
+	}
+
+	check := func() {
+		t.Helper()
+		for i := 0; i < 90; i++ {
+			key := fmt.Sprintf("key%03d", i)
+			got, ok, err := tree.Get([]byte(key))
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			want, exists := expected[key]
+			if ok != exists || string(got) != string(want) {
+				t.Fatalf("value is wrong for key %s: %q (%v) != %q (%v)", key, got, ok, want, exists)
+			}
+		}
+
+		it, err := tree.Scan(nil, nil)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		defer it.Close()
+		n := 0
+		for it.Next() {
+			if want := expected[string(it.Key())]; string(it.Value()) != string(want) {
+				t.Fatalf("scan value is wrong for key %s: %q != %q", it.Key(), it.Value(), want)
+			}
+			n++
+		}
+		if err := it.Err(); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if n != len(expected) {
+			t.Fatalf("expected %d keys in scan, but got %d", len(expected), n)
+		}
+	}
+	check()
+
+	// 合并后只剩一个以当前算法（zstd）重写的磁盘表
+	for tree.diskTableNum > 1 {
+		if err := tree.compactDiskTables(context.Background()); err != nil {
+			t.Fatalf("failed to compact: %s", err)
+		}
+	}
+	format, err := readTableFormat(dbDir, strconv.Itoa(tree.maxDiskTableIndex)+"-")
+	if err != nil || format.codec != Zstd {
+		t.Fatalf("expected the merged table to use zstd, but got %s (%v)", format.codec, err)
+	}
+	if err := tree.Verify(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	check()
+}
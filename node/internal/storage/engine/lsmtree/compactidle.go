@@ -0,0 +1,123 @@
+package lsmtree
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// CompactWhenIdle 开启空闲合并（默认关闭）：刷新后需要的合并不在写入中进行，而是推迟到没有读写的时间达到 idle 后，
+// 由后台任务在空闲时合并，避免合并占用写锁时阻塞前台的读写。繁忙时推迟的时间最多为 maxDeferral，
+// 超过后下一次写入（或者后台任务）不再等待空闲，立即合并到不再需要合并为止，避免磁盘表无限增多。
+// 每次开始推迟时 Stats 的 CompactionsDeferred 加一。只影响刷新后按阈值和合并策略触发的合并，
+// MajorCompact、CompactOnReadAmplification 和 CoalesceSmallTables 不受影响。
+func CompactWhenIdle(idle, maxDeferral time.Duration) func(*LSMTree) {
+	return func(t *LSMTree) {
+		t.compactIdle = idle
+		t.compactMaxDeferral = maxDeferral
+	}
+}
+
+// recordAccess 在开启空闲合并时记录一次读取的时间，调用方持有读锁，多个读取可能同时调用。
+func (t *LSMTree) recordAccess() {
+	if t.compactIdle > 0 {
+		atomic.StoreInt64(&t.lastRead, time.Now().UnixNano())
+	}
+}
+
+// sinceLastAccess 返回距离最近一次读取或写入的时间。
+func (t *LSMTree) sinceLastAccess() time.Duration {
+	return min(t.sinceLastWrite(), time.Since(time.Unix(0, atomic.LoadInt64(&t.lastRead))))
+}
+
+// compactionNeeded 判断是否需要按阈值和合并策略合并，调用方必须持有写锁。
+// 策略找不到可以合并的磁盘表时（例如相邻的磁盘表合并后都会超过大小上限）不需要合并。
+func (t *LSMTree) compactionNeeded(flushed bool) bool {
+	if t.diskTableNum < t.diskTableNumThreshold && !(flushed && t.compactionTriggered()) {
+		return false
+	}
+	return len(t.compactionStrategy.Plan(t.tableInfos())) > 0
+}
+
+// deferCompaction 判断刷新后需要的合并是否推迟到空闲时，调用方必须持有写锁。
+// 推迟超过 compactMaxDeferral 后返回 false，由调用方立即合并。
+func (t *LSMTree) deferCompaction() bool {
+	if t.compactIdle <= 0 {
+		return false
+	}
+	if t.compactionDeferred.IsZero() {
+		t.compactionDeferred = time.Now()
+		t.stats.CompactionsDeferred++
+		select {
+		case t.idleCompaction <- struct{}{}:
+		default:
+			// 后台任务已经在等待
+		}
+		return true
+	}
+	return time.Since(t.compactionDeferred) < t.compactMaxDeferral
+}
+
+// compactDeferred 合并到不再需要合并为止并结束推迟，调用方必须持有写锁。
+// 推迟的合并可能是 CompactionTrigger 在刷新后要求的，因此策略有计划时至少合并一次。
+func (t *LSMTree) compactDeferred() error {
+	for needed := len(t.compactionStrategy.Plan(t.tableInfos())) > 0; needed; needed = t.compactionNeeded(false) {
+		if err := t.compactDiskTables(t.ctx); err != nil {
+			return err
+		}
+	}
+	t.compactionDeferred = time.Time{}
+	return nil
+}
+
+// startIdleCompaction 启动空闲合并的后台任务，Close 时退出。
+func (t *LSMTree) startIdleCompaction() {
+	t.idleCompaction = make(chan struct{}, 1)
+	atomic.StoreInt64(&t.lastWrite, time.Now().UnixNano())
+	atomic.StoreInt64(&t.lastRead, time.Now().UnixNano())
+
+	t.background.Add(1)
+	go func() {
+		defer t.background.Done()
+
+		for {
+			select {
+			case <-t.ctx.Done():
+				return
+			case <-t.idleCompaction:
+			}
+			// 等到空闲或者推迟的时间用完，期间合并可能已经在写入中进行
+			for {
+				wait, err := t.compactIfIdle()
+				if err != nil {
+					t.logger.Error("failed to compact deferred disk tables: %v", err)
+				}
+				if wait <= 0 || err != nil {
+					break
+				}
+				select {
+				case <-t.ctx.Done():
+					return
+				case <-time.After(wait):
+				}
+			}
+		}
+	}()
+}
+
+// compactIfIdle 在空闲或者推迟的时间用完时合并推迟的磁盘表，否则返回需要继续等待的时间。
+// 没有推迟的合并时返回 0。
+func (t *LSMTree) compactIfIdle() (time.Duration, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	// Close 先取消 ctx 再获取写锁，之后不再修改磁盘表
+	if t.ctx.Err() != nil || t.compactionDeferred.IsZero() {
+		return 0, nil
+	}
+	idleWait := t.compactIdle - t.sinceLastAccess()
+	deferralWait := t.compactMaxDeferral - time.Since(t.compactionDeferred)
+	if idleWait > 0 && deferralWait > 0 {
+		return min(idleWait, deferralWait), nil
+	}
+	return 0, t.compactDeferred()
+}
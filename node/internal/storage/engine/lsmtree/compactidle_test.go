@@ -0,0 +1,98 @@
+package lsmtree
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+// writeContinuously 每毫秒写入一个键直到 stop 关闭，返回写入结束时关闭的通道。
+func writeContinuously(t *testing.T, tree *LSMTree, stop chan struct{}) chan struct{} {
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return
+			case <-time.After(time.Millisecond):
+			}
+			if err := tree.Put([]byte(fmt.Sprintf("key%06d", i)), make([]byte, 100)); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+	return done
+}
+
+func (t *LSMTree) diskTables() int {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+	return t.diskTableNum
+}
+
+func TestCompactWhenIdle(t *testing.T) {
+	tree, err := Open(t.TempDir(), MemTableThreshold(1024), ImmutableMemtableMaxNum(1), DiskTableNumThreshold(3),
+		DisableWAL(true), CompactWhenIdle(100*time.Millisecond, time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tree.Close()
+
+	stop := make(chan struct{})
+	done := writeContinuously(t, tree, stop)
+	// 持续写入期间合并一直推迟，磁盘表超过阈值
+	deadline := time.Now().Add(5 * time.Second)
+	for tree.diskTables() < 6 {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected disk tables to accumulate while busy, but got %d", tree.diskTables())
+		}
+		time.Sleep(time.Millisecond)
+	}
+	if deferred := tree.Stats().CompactionsDeferred; deferred != 1 {
+		t.Fatalf("expected one deferred compaction, but got %d", deferred)
+	}
+	close(stop)
+	<-done
+
+	// 空闲后后台任务合并到低于阈值
+	deadline = time.Now().Add(5 * time.Second)
+	for tree.diskTables() >= 3 {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the deferred compaction to run when idle, but got %d disk tables", tree.diskTables())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if stats := tree.Stats(); stats.CompactionBytesWritten == 0 {
+		t.Fatal("expected compaction to write bytes")
+	}
+}
+
+func TestCompactWhenIdleMaxDeferral(t *testing.T) {
+	tree, err := Open(t.TempDir(), MemTableThreshold(1024), ImmutableMemtableMaxNum(1), DiskTableNumThreshold(3),
+		DisableWAL(true), CompactWhenIdle(time.Hour, 200*time.Millisecond))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tree.Close()
+
+	stop := make(chan struct{})
+	done := writeContinuously(t, tree, stop)
+	defer func() {
+		close(stop)
+		<-done
+	}()
+
+	// 从不空闲，推迟的时间用完后仍然合并
+	deadline := time.Now().Add(5 * time.Second)
+	for tree.Stats().CompactionBytesWritten == 0 {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected compaction after the max deferral, but got %d disk tables", tree.diskTables())
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if deferred := tree.Stats().CompactionsDeferred; deferred == 0 {
+		t.Fatal("expected the compaction to be deferred first")
+	}
+}
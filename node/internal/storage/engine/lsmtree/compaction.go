@@ -0,0 +1,152 @@
+package lsmtree
+
+import (
+	"fmt"
+)
+
+// CompactionPlan 描述一次计划中的合并。
+type CompactionPlan struct {
+	// 参与合并的磁盘表编号，从旧到新排列。
+	Inputs []int
+	// 合并后数据文件的估计大小（输入数据文件大小之和），单位为字节。
+	EstimatedSize int
+}
+
+// CompactionStrategy 根据磁盘表的元数据决定合并哪些磁盘表。
+// 当磁盘表数量达到 DiskTableNumThreshold 时，LSMTree 执行策略返回的第一个计划。
+// 目前执行的计划必须恰好包含两张编号相邻的磁盘表。
+type CompactionStrategy interface {
+	// Plan 根据从旧到新排列的磁盘表返回建议的合并，不得读写磁盘。
+	Plan(tables []TableInfo) []CompactionPlan
+}
+
+// CompactionTrigger 可以由 CompactionStrategy 额外实现：每次刷新内存表之后，
+// 即使磁盘表总数没有达到 DiskTableNumThreshold，ShouldCompact 返回 true 时也会执行一次合并。
+type CompactionTrigger interface {
+	ShouldCompact(tables []TableInfo) bool
+}
+
+// AdjacentPairStrategy 是默认的合并策略：从最旧的磁盘表开始，
+// 选择第一对合并后数据文件不超过 MaxSize 的相邻磁盘表。
+type AdjacentPairStrategy struct {
+	MaxSize int
+}
+
+// Plan 实现 CompactionStrategy。
+func (s AdjacentPairStrategy) Plan(tables []TableInfo) []CompactionPlan {
+	for i := 0; i+1 < len(tables); i++ {
+		a, b := tables[i], tables[i+1]
+		if b.Index != a.Index+1 {
+			continue
+		}
+		if size := a.DataSize + b.DataSize; size <= s.MaxSize {
+			return []CompactionPlan{{Inputs: []int{a.Index, b.Index}, EstimatedSize: size}}
+		}
+	}
+	return nil
+}
+
+// SizeTieredStrategy 按数据文件大小把磁盘表分层：第 0 层小于 BaseSize，
+// 第 i 层小于 BaseSize*Fanout^i。某一层的磁盘表数量达到该层的阈值时，
+// 合并该层中第一对相邻的磁盘表，因此小表的堆积在本层内被清理，不会牵动更大的稳定的磁盘表。
+// 使用该策略时通常把 DiskTableNumThreshold 设得足够大，让分层阈值决定何时合并。
+type SizeTieredStrategy struct {
+	// 第 0 层磁盘表的大小上限，单位为字节。
+	BaseSize int
+	// 相邻两层大小上限的倍数，至少为 2。
+	Fanout int
+	// TierThresholds[i] 是第 i 层触发合并的磁盘表数量，更高的层使用最后一个值。
+	TierThresholds []int
+	// 合并后数据文件的大小上限，0 表示不限制。
+	MaxSize int
+}
+
+// tier 返回大小为 size 的磁盘表所在的层。
+func (s SizeTieredStrategy) tier(size int) int {
+	tier := 0
+	for limit := s.BaseSize; size >= limit && s.Fanout > 1; limit *= s.Fanout {
+		tier++
+	}
+	return tier
+}
+
+// threshold 返回第 tier 层触发合并的磁盘表数量，没有配置时返回 0 表示不触发。
+func (s SizeTieredStrategy) threshold(tier int) int {
+	if len(s.TierThresholds) == 0 {
+		return 0
+	}
+	if tier >= len(s.TierThresholds) {
+		return s.TierThresholds[len(s.TierThresholds)-1]
+	}
+	return s.TierThresholds[tier]
+}
+
+// Plan 实现 CompactionStrategy：从最小的层开始，为每个达到阈值的层返回一对同层的相邻磁盘表。
+func (s SizeTieredStrategy) Plan(tables []TableInfo) []CompactionPlan {
+	counts := make(map[int]int)
+	maxTier := 0
+	for _, table := range tables {
+		tier := s.tier(table.DataSize)
+		counts[tier]++
+		if tier > maxTier {
+			maxTier = tier
+		}
+	}
+
+	var plans []CompactionPlan
+	for tier := 0; tier <= maxTier; tier++ {
+		threshold := s.threshold(tier)
+		if threshold <= 0 || counts[tier] < threshold {
+			continue
+		}
+		for i := 0; i+1 < len(tables); i++ {
+			a, b := tables[i], tables[i+1]
+			if b.Index != a.Index+1 || s.tier(a.DataSize) != tier || s.tier(b.DataSize) != tier {
+				continue
+			}
+			size := a.DataSize + b.DataSize
+			if s.MaxSize > 0 && size > s.MaxSize {
+				continue
+			}
+			plans = append(plans, CompactionPlan{Inputs: []int{a.Index, b.Index}, EstimatedSize: size})
+			break
+		}
+	}
+	return plans
+}
+
+// ShouldCompact 实现 CompactionTrigger：任何一层的磁盘表数量达到阈值且有可合并的表对时返回 true。
+func (s SizeTieredStrategy) ShouldCompact(tables []TableInfo) bool {
+	return len(s.Plan(tables)) > 0
+}
+
+// Compaction 为 LSMTree 设置合并策略。
+func Compaction(strategy CompactionStrategy) func(*LSMTree) {
+	return func(t *LSMTree) {
+		t.compactionStrategy = strategy
+	}
+}
+
+// PlanCompaction 用当前选择的合并策略对现有磁盘表做一次演练，
+// 返回下一次触发合并时会执行的合并，不修改任何文件。
+func (t *LSMTree) PlanCompaction() []CompactionPlan {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	return t.compactionStrategy.Plan(t.tableInfos())
+}
+
+// tableInfos 返回从旧到新排列的现有磁盘表的元数据，无法读取数据文件大小的磁盘表被跳过。
+// KeyNum 需要读取整个索引文件才能得到，这里不填充。
+func (t *LSMTree) tableInfos() []TableInfo {
+	var tables []TableInfo
+	oldest := t.maxDiskTableIndex - t.diskTableNum + 1
+	for index := oldest; index <= t.maxDiskTableIndex; index++ {
+		size, err := diskTableDataSize(t.dbDir, fmt.Sprintf("%d-", index))
+		if err != nil {
+			continue // 文件不存在，跳过
+		}
+		tables = append(tables, TableInfo{Index: index, DataSize: int(size)})
+	}
+	return tables
+}
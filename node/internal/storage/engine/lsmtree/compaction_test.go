@@ -0,0 +1,372 @@
+package lsmtree
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path"
+	"reflect"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestAdjacentPairStrategyPlan(t *testing.T) {
+	strategy := AdjacentPairStrategy{MaxSize: 100}
+
+	tests := []struct {
+		name   string
+		tables []TableInfo
+		want   []CompactionPlan
+	}{
+		{
+			name:   "no tables",
+			tables: nil,
+			want:   nil,
+		},
+		{
+			name:   "single table",
+			tables: []TableInfo{{Index: 0, DataSize: 10}},
+			want:   nil,
+		},
+		{
+			name:   "oldest pair",
+			tables: []TableInfo{{Index: 0, DataSize: 10}, {Index: 1, DataSize: 20}, {Index: 2, DataSize: 30}},
+			want:   []CompactionPlan{{Inputs: []int{0, 1}, EstimatedSize: 30}},
+		},
+		{
+			name:   "skip oversized pair",
+			tables: []TableInfo{{Index: 3, DataSize: 90}, {Index: 4, DataSize: 20}, {Index: 5, DataSize: 30}},
+			want:   []CompactionPlan{{Inputs: []int{4, 5}, EstimatedSize: 50}},
+		},
+		{
+			name:   "skip missing table",
+			tables: []TableInfo{{Index: 0, DataSize: 10}, {Index: 2, DataSize: 10}, {Index: 3, DataSize: 10}},
+			want:   []CompactionPlan{{Inputs: []int{2, 3}, EstimatedSize: 20}},
+		},
+		{
+			name:   "all pairs oversized",
+			tables: []TableInfo{{Index: 0, DataSize: 60}, {Index: 1, DataSize: 60}, {Index: 2, DataSize: 60}},
+			want:   nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := strategy.Plan(tt.tables); !reflect.DeepEqual(got, tt.want) {
+				t.Fatalf("expected %v, but got %v", tt.want, got)
+			}
+		})
+	}
+}
+
+func TestPlanCompaction(t *testing.T) {
+	dbDir := t.TempDir()
+
+	tree, err := Open(dbDir, MemTableMaxEntries(10), ImmutableMemtableMaxNum(1), DiskTableNumThreshold(100))
+	if err != nil {
+		panic(fmt.Errorf("failed to open LSM tree %s: %w", dbDir, err))
+	}
+	defer tree.Close()
+
+	for i := 0; i < 40; i++ {
+		key := strconv.Itoa(i)
+		if err := tree.Put([]byte(key), []byte(key)); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+
+	before, err := os.ReadDir(dbDir)
+	if err != nil {
+		panic(fmt.Errorf("failed to read %s: %w", dbDir, err))
+	}
+
+	plans := tree.PlanCompaction()
+	if len(plans) != 1 {
+		t.Fatalf("expected 1 plan, but got %v", plans)
+	}
+	oldest := tree.maxDiskTableIndex - tree.diskTableNum + 1
+	if !reflect.DeepEqual(plans[0].Inputs, []int{oldest, oldest + 1}) {
+		t.Fatalf("expected inputs %v, but got %v", []int{oldest, oldest + 1}, plans[0].Inputs)
+	}
+	if plans[0].EstimatedSize <= 0 {
+		t.Fatalf("expected a positive estimated size, but got %d", plans[0].EstimatedSize)
+	}
+
+	// 演练不修改任何文件
+	after, err := os.ReadDir(dbDir)
+	if err != nil {
+		panic(fmt.Errorf("failed to read %s: %w", dbDir, err))
+	}
+	if len(before) != len(after) {
+		t.Fatalf("expected %d files, but got %d", len(before), len(after))
+	}
+
+	// 没有可合并的磁盘表时返回空计划
+	Compaction(AdjacentPairStrategy{MaxSize: 0})(tree)
+	if plans := tree.PlanCompaction(); len(plans) != 0 {
+		t.Fatalf("expected no plans, but got %v", plans)
+	}
+}
+
+func TestSizeTieredStrategyPlan(t *testing.T) {
+	strategy := SizeTieredStrategy{BaseSize: 100, Fanout: 10, TierThresholds: []int{3, 2}}
+
+	// 第 0 层 < 100，第 1 层 < 1000，第 2 层 < 10000
+	tables := []TableInfo{
+		{Index: 0, DataSize: 5000},
+		{Index: 1, DataSize: 6000},
+		{Index: 2, DataSize: 500},
+		{Index: 3, DataSize: 50},
+		{Index: 4, DataSize: 60},
+	}
+	want := []CompactionPlan{{Inputs: []int{0, 1}, EstimatedSize: 11000}}
+	if got := strategy.Plan(tables); !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected %v, but got %v", want, got)
+	}
+
+	// 第 0 层达到阈值 3，先于第 2 层给出计划
+	tables = append(tables, TableInfo{Index: 5, DataSize: 70})
+	want = []CompactionPlan{
+		{Inputs: []int{3, 4}, EstimatedSize: 110},
+		{Inputs: []int{0, 1}, EstimatedSize: 11000},
+	}
+	if got := strategy.Plan(tables); !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected %v, but got %v", want, got)
+	}
+	if !strategy.ShouldCompact(tables) {
+		t.Fatalf("expected compaction to be triggered")
+	}
+
+	// 合并后超过 MaxSize 的表对被跳过
+	strategy.MaxSize = 10000
+	want = []CompactionPlan{{Inputs: []int{3, 4}, EstimatedSize: 110}}
+	if got := strategy.Plan(tables); !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected %v, but got %v", want, got)
+	}
+
+	if strategy.ShouldCompact(tables[:3]) {
+		t.Fatalf("expected no compaction below the tier thresholds")
+	}
+}
+
+func TestSizeTieredCompaction(t *testing.T) {
+	dbDir := t.TempDir()
+
+	strategy := SizeTieredStrategy{BaseSize: 4096, Fanout: 4, TierThresholds: []int{3}}
+	tree, err := Open(dbDir,
+		MemTableMaxEntries(10),
+		ImmutableMemtableMaxNum(1),
+		DiskTableNumThreshold(100),
+		Compaction(strategy),
+	)
+	if err != nil {
+		panic(fmt.Errorf("failed to open LSM tree %s: %w", dbDir, err))
+	}
+	defer tree.Close()
+
+	// 两张大表：每个值 2 kB，数据文件约 20 kB，位于第 2 层
+	large := bytes.Repeat([]byte("v"), 2048)
+	for i := 0; i < 20; i++ {
+		if err := tree.Put([]byte(fmt.Sprintf("large%02d", i)), large); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+	largeTables := make(map[string]bool)
+	for _, table := range tree.tableInfos() {
+		if strategy.tier(table.DataSize) != 2 {
+			t.Fatalf("expected only large tables, but got %+v", table)
+		}
+		largeTables[readDataFile(t, dbDir, table.Index)] = true
+	}
+	if len(largeTables) != 2 {
+		t.Fatalf("expected 2 large tables, but got %d", len(largeTables))
+	}
+
+	// 大量小表：每次刷新只写入很小的值，合并后的表最多增长到第 1 层
+	for i := 0; i < 200; i++ {
+		if err := tree.Put([]byte(fmt.Sprintf("small%03d", i)), []byte("v")); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+
+	counts := make(map[int]int)
+	for _, table := range tree.tableInfos() {
+		tier := strategy.tier(table.DataSize)
+		if tier < 2 {
+			counts[tier]++
+			continue
+		}
+		if data := readDataFile(t, dbDir, table.Index); !largeTables[data] {
+			t.Fatalf("large disk table %d was rewritten", table.Index)
+		}
+		delete(largeTables, readDataFile(t, dbDir, table.Index))
+	}
+	if counts[0] >= 3 || counts[1] >= 3 {
+		t.Fatalf("expected small tables to be merged below the threshold, but got %v", counts)
+	}
+	if tree.diskTableNum < 3 {
+		t.Fatalf("expected small tables to be flushed, but got %d disk tables", tree.diskTableNum)
+	}
+	if len(largeTables) != 0 {
+		t.Fatalf("expected large tables to be untouched, but %d are missing", len(largeTables))
+	}
+
+	for i := 0; i < 200; i++ {
+		key := fmt.Sprintf("small%03d", i)
+		if _, ok, err := tree.Get([]byte(key)); err != nil || !ok {
+			t.Fatalf("failed to read %s: %v", key, err)
+		}
+	}
+}
+
+// readDataFile 返回磁盘表数据文件的内容。
+func readDataFile(t *testing.T, dbDir string, index int) string {
+	data, err := os.ReadFile(path.Join(dbDir, fmt.Sprintf("%d-%s", index, diskTableDataFileName)))
+	if err != nil {
+		t.Fatalf("failed to read disk table %d: %s", index, err)
+	}
+	return string(data)
+}
+
+// pickStrategy 合并 pick 选出的两个磁盘表，用于在测试中控制合并顺序。
+type pickStrategy func(tables []TableInfo) []int
+
+func (p pickStrategy) Plan(tables []TableInfo) []CompactionPlan {
+	return []CompactionPlan{{Inputs: p(tables)}}
+}
+
+func TestCompactionKeepsTombstonesAboveOlderTables(t *testing.T) {
+	dbDir := t.TempDir()
+	var pick func(tables []TableInfo) []int
+	strategy := pickStrategy(func(tables []TableInfo) []int { return pick(tables) })
+	tree, err := Open(dbDir, MemTableMaxEntries(2), ImmutableMemtableMaxNum(1), DiskTableNumThreshold(100), Compaction(strategy))
+	if err != nil {
+		t.Fatalf("failed to open LSM tree %s: %s", dbDir, err)
+	}
+	defer tree.Close()
+
+	deleted := []byte("deleted-key")
+	steps := []func() error{
+		// 磁盘表 0：被删除的键的旧值
+		func() error { return tree.Put(deleted, []byte("old")) },
+		func() error { return tree.Put([]byte("a"), []byte("1")) },
+		// 磁盘表 1：墓碑
+		func() error { return tree.Delete(deleted) },
+		func() error { return tree.Put([]byte("b"), []byte("1")) },
+		// 磁盘表 2
+		func() error { return tree.Put([]byte("c"), []byte("1")) },
+		func() error { return tree.Put([]byte("d"), []byte("1")) },
+	}
+	for _, step := range steps {
+		if err := step(); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+	if tree.diskTableNum != 3 {
+		t.Fatalf("expected 3 disk tables, got %d", tree.diskTableNum)
+	}
+
+	compact := func() {
+		tree.mu.Lock()
+		defer tree.mu.Unlock()
+		if err := tree.compactDiskTables(tree.ctx); err != nil {
+			t.Fatalf("failed to compact: %s", err)
+		}
+	}
+	assertDeleted := func() {
+		if value, ok, err := tree.Get(deleted); err != nil || ok {
+			t.Fatalf("deleted key reappeared: %q ok=%v err=%v", value, ok, err)
+		}
+	}
+
+	// 合并墓碑所在的表和更新的表，更旧的表仍有旧值，墓碑必须保留
+	pick = func(tables []TableInfo) []int {
+		return []int{tables[1].Index, tables[2].Index}
+	}
+	compact()
+	assertDeleted()
+	if data := readDataFile(t, dbDir, tree.maxDiskTableIndex); !strings.Contains(data, string(deleted)) {
+		t.Fatal("tombstone was dropped while an older table still holds the key")
+	}
+
+	// 与最旧的表合并后墓碑不再需要
+	pick = func(tables []TableInfo) []int {
+		return []int{tables[0].Index, tables[1].Index}
+	}
+	compact()
+	assertDeleted()
+	if data := readDataFile(t, dbDir, tree.maxDiskTableIndex); strings.Contains(data, string(deleted)) {
+		t.Fatal("tombstone was kept after merging with the oldest table")
+	}
+	for _, key := range []string{"a", "b", "c", "d"} {
+		if _, ok, err := tree.Get([]byte(key)); err != nil || !ok {
+			t.Fatalf("failed to read %s: %v", key, err)
+		}
+	}
+}
+
+func TestCompactionRenumberingKeepsNewestWins(t *testing.T) {
+	dbDir := t.TempDir()
+	var pick func(tables []TableInfo) []int
+	strategy := pickStrategy(func(tables []TableInfo) []int { return pick(tables) })
+	tree, err := Open(dbDir, MemTableMaxEntries(2), ImmutableMemtableMaxNum(1), DiskTableNumThreshold(100), Compaction(strategy))
+	if err != nil {
+		t.Fatalf("failed to open LSM tree %s: %s", dbDir, err)
+	}
+	defer tree.Close()
+
+	// 磁盘表 0..4，每个表都覆盖 shared 并有一个独有的键
+	for i := 0; i < 5; i++ {
+		if err := tree.Put([]byte("shared"), []byte(strconv.Itoa(i))); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if err := tree.Put([]byte("only-"+strconv.Itoa(i)), []byte(strconv.Itoa(i))); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+	if tree.diskTableNum != 5 {
+		t.Fatalf("expected 5 disk tables, got %d", tree.diskTableNum)
+	}
+
+	// 合并中间的一对表，更旧的表 0 和 1 需要后移填补空缺；
+	// 如果从旧到新移动，表 0 会覆盖仍在使用的表 1
+	pick = func(tables []TableInfo) []int {
+		return []int{tables[2].Index, tables[3].Index}
+	}
+	tree.mu.Lock()
+	err = tree.compactDiskTables(tree.ctx)
+	tree.mu.Unlock()
+	if err != nil {
+		t.Fatalf("failed to compact: %s", err)
+	}
+
+	oldest := tree.maxDiskTableIndex - tree.diskTableNum + 1
+	if tree.diskTableNum != 4 || oldest != 1 {
+		t.Fatalf("expected disk tables 1..4, got %d tables from %d", tree.diskTableNum, oldest)
+	}
+	if value, ok, err := tree.Get([]byte("shared")); err != nil || !ok || string(value) != "4" {
+		t.Fatalf("expected the newest value 4, got %q ok=%v err=%v", value, ok, err)
+	}
+	for i := 0; i < 5; i++ {
+		key := "only-" + strconv.Itoa(i)
+		if value, ok, err := tree.Get([]byte(key)); err != nil || !ok || string(value) != strconv.Itoa(i) {
+			t.Fatalf("failed to read %s: %q ok=%v err=%v", key, value, ok, err)
+		}
+	}
+	// 编号越大越新：表 1 是原来的表 0，表 2 是原来的表 1
+	if data := readDataFile(t, dbDir, 1); !strings.Contains(data, "only-0") {
+		t.Fatal("expected the oldest table to move to index 1")
+	}
+	if data := readDataFile(t, dbDir, 2); !strings.Contains(data, "only-1") {
+		t.Fatal("expected table 1 to move to index 2")
+	}
+
+	// 重命名到仍在使用的编号会被拒绝，目标表保持不变
+	if err := moveDiskTable(dbDir, "1-", "2-"); err == nil {
+		t.Fatal("expected renaming onto a live disk table to fail")
+	}
+	if data := readDataFile(t, dbDir, 2); !strings.Contains(data, "only-1") {
+		t.Fatal("live disk table was overwritten")
+	}
+}
@@ -1,13 +1,32 @@
 package lsmtree
 
+import "time"
+
 const (
 	databaseSourcePath = "/lsm_huahuo/"
 	// 默认 MemTable 表阈值。
 	defaultMemTableThreshold = 16000 // 16 kB
+	// 默认不可变内存表数量上限。
+	defaultImmutableMemtableMaxNum = 4
 	// 稀疏索引中键之间的默认距离。
 	defaultSparseKeyDistance = 128
 	// 默认 SSTable 数量阈值。
 	defaultDiskTableNumThreshold = 10
 	// 默认单个SSTable文件大小上限
 	defaultSSTableSize = 5 * 1024 * 1024 // 5 MB
+	// 默认同时查找的磁盘表数量
+	defaultSearchParallelism = 1
+	// 默认 Scan 预读的字节数
+	defaultReadAhead = 64 * 1024 // 64 kB
+	// 默认最多缓存稀疏索引的磁盘表数量
+	defaultSparseIndexCacheSize = 256
+	// 默认预热超时时间
+	defaultWarmUpTimeout = 30 * time.Second
+	// 查找期间磁盘表被另一个进程删除时，重新读取磁盘表集合后重试的次数和第一次重试前等待的时间（之后每次加倍）
+	diskTableSearchRetries = 4
+	diskTableSearchBackoff = 5 * time.Millisecond
+	// 默认固定的键和值的总字节数上限
+	defaultPinnedMemoryLimit = 4 << 20 // 4 MB
+	// 固定的键的日志至少达到该大小才会重写
+	minPinnedLogRewriteSize = 64 << 10 // 64 kB
 )
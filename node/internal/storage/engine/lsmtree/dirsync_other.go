@@ -0,0 +1,8 @@
+//go:build !linux && !darwin
+
+package lsmtree
+
+// syncDir 在不支持同步目录的平台上什么也不做。
+func syncDir(dir string) error {
+	return nil
+}
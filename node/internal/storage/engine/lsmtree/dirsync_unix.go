@@ -0,0 +1,22 @@
+//go:build linux || darwin
+
+package lsmtree
+
+import (
+	"fmt"
+	"os"
+)
+
+// syncDir 同步目录，使其中文件的创建、重命名和删除在崩溃后仍然可见。
+func syncDir(dir string) error {
+	d, err := os.Open(dir)
+	if err != nil {
+		return fmt.Errorf("failed to open directory %s: %w", dir, err)
+	}
+	defer d.Close()
+
+	if err := d.Sync(); err != nil {
+		return fmt.Errorf("failed to sync directory %s: %w", dir, err)
+	}
+	return nil
+}
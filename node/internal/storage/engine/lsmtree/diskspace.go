@@ -0,0 +1,52 @@
+package lsmtree
+
+import (
+	"errors"
+	"fmt"
+	"path"
+	"strconv"
+)
+
+// ErrInsufficientSpace 当刷新或合并之前检查到磁盘剩余空间不足时返回，此时还没有写入任何文件。
+var ErrInsufficientSpace = errors.New("insufficient disk space")
+
+// MinFreeSpace 为 LSMTree 设置 minFreeSpace（默认 0，即不检查）。
+// 开启后，每次刷新和合并之前估计需要写入的字节数（刷新为内存表的大小，合并为输入磁盘表的文件大小之和），
+// 剩余空间不足以写入并保留 minFreeSpace 字节时返回 ErrInsufficientSpace，而不是写到一半才失败。
+// 无法获取剩余空间的平台上不检查。
+func MinFreeSpace(minFreeSpace int) func(*LSMTree) {
+	return func(t *LSMTree) {
+		t.minFreeSpace = minFreeSpace
+	}
+}
+
+// freeSpace 返回目录所在文件系统中可用的字节数，测试中可以替换它来模拟磁盘已满。
+var freeSpace = diskFreeSpace
+
+// checkFreeSpace 检查剩余空间是否足以写入 required 字节并保留 minFreeSpace 字节。
+func (t *LSMTree) checkFreeSpace(required int) error {
+	if t.minFreeSpace <= 0 {
+		return nil
+	}
+	free, err := freeSpace(t.dbDir)
+	if err != nil {
+		t.logger.Warn("failed to get free space of %s, skipping the check: %v", t.dbDir, err)
+		return nil
+	}
+	if need := uint64(required) + uint64(t.minFreeSpace); free < need {
+		return fmt.Errorf("%w: %d bytes free in %s, need %d", ErrInsufficientSpace, free, t.dbDir, need)
+	}
+	return nil
+}
+
+// diskTableSize 返回磁盘表所有文件的大小之和，无法读取大小的文件被跳过。
+func diskTableSize(dbDir string, index int) int {
+	prefix := strconv.Itoa(index) + "-"
+	size := 0
+	for _, name := range diskTableFileNames {
+		if n, err := GetFileSize(path.Join(dbDir, prefix+name)); err == nil {
+			size += int(n)
+		}
+	}
+	return size
+}
@@ -0,0 +1,10 @@
+//go:build !linux && !darwin
+
+package lsmtree
+
+import "errors"
+
+// diskFreeSpace 在不支持的平台上总是返回错误，剩余空间不做检查。
+func diskFreeSpace(dir string) (uint64, error) {
+	return 0, errors.New("free space is not supported on this platform")
+}
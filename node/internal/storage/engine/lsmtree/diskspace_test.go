@@ -0,0 +1,131 @@
+package lsmtree
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"testing"
+)
+
+func TestMinFreeSpace(t *testing.T) {
+	// 模拟只剩 free 字节的磁盘
+	free := uint64(1 << 30)
+	freeSpace = func(dir string) (uint64, error) { return free, nil }
+	defer func() { freeSpace = diskFreeSpace }()
+
+	dbDir := t.TempDir()
+	tree, err := Open(dbDir,
+		MemTableMaxEntries(1),
+		ImmutableMemtableMaxNum(1),
+		DiskTableNumThreshold(100),
+		MinFreeSpace(4096),
+	)
+	if err != nil {
+		t.Fatalf("failed to open LSM tree %s: %s", dbDir, err)
+	}
+	defer tree.Close()
+
+	for i := 0; i < 2; i++ {
+		if err := tree.Put([]byte(fmt.Sprintf("key%d", i)), []byte("value")); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+	entries, err := os.ReadDir(dbDir)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	// 磁盘已满：刷新在写入任何文件之前被拒绝，写入仍然保留在内存表中
+	free = 4096
+	if err := tree.Put([]byte("key2"), []byte("value")); !errors.Is(err, ErrInsufficientSpace) {
+		t.Fatalf("expected %v, but got %v", ErrInsufficientSpace, err)
+	}
+	if after, _ := os.ReadDir(dbDir); len(after) != len(entries) {
+		t.Fatalf("expected no new files, but got %d instead of %d", len(after), len(entries))
+	}
+	if tree.diskTableNum != 2 {
+		t.Fatalf("expected 2 disk tables, but got %d", tree.diskTableNum)
+	}
+	if _, ok, err := tree.Get([]byte("key2")); err != nil || !ok {
+		t.Fatalf("expected key2 to stay readable, but got %v %v", ok, err)
+	}
+
+	// 合并同样被拒绝，磁盘表保持不变
+	if err := tree.compactDiskTables(context.Background()); !errors.Is(err, ErrInsufficientSpace) {
+		t.Fatalf("expected %v, but got %v", ErrInsufficientSpace, err)
+	}
+	if tree.diskTableNum != 2 {
+		t.Fatalf("expected 2 disk tables, but got %d", tree.diskTableNum)
+	}
+
+	// 空间释放后，下一次写入把积压的内存表一起刷新
+	free = 1 << 30
+	if err := tree.Put([]byte("key3"), []byte("value")); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if tree.diskTableNum != 3 || len(tree.immutableMemtables) != 0 {
+		t.Fatalf("expected the backlog to be flushed, but got %d disk tables", tree.diskTableNum)
+	}
+	for i := 0; i < 4; i++ {
+		if _, ok, err := tree.Get([]byte(fmt.Sprintf("key%d", i))); err != nil || !ok {
+			t.Fatalf("expected key%d to exist, but got %v %v", i, ok, err)
+		}
+	}
+}
+
+func TestFailedFlushKeepsLaterWrites(t *testing.T) {
+	free := uint64(1 << 30)
+	freeSpace = func(dir string) (uint64, error) { return free, nil }
+	defer func() { freeSpace = diskFreeSpace }()
+
+	dbDir := t.TempDir()
+	tree, err := Open(dbDir,
+		MemTableMaxEntries(2),
+		ImmutableMemtableMaxNum(1),
+		DiskTableNumThreshold(100),
+		MinFreeSpace(4096),
+	)
+	if err != nil {
+		t.Fatalf("failed to open LSM tree %s: %s", dbDir, err)
+	}
+	defer tree.Close()
+
+	// 刷新失败，key0 和 key1 所在的不可变内存表留在队列中
+	free = 4096
+	if err := tree.Put([]byte("key0"), []byte("value")); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := tree.Put([]byte("key1"), []byte("value")); !errors.Is(err, ErrInsufficientSpace) {
+		t.Fatalf("expected %v, but got %v", ErrInsufficientSpace, err)
+	}
+
+	// 空间释放后，key2 进入当前内存表并触发刷新，刷新会清除 WAL
+	free = 1 << 30
+	if err := tree.Put([]byte("key2"), []byte("value")); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	// 模拟崩溃：不关闭数据库，直接用此刻的文件打开副本
+	crashDir := t.TempDir()
+	entries, err := os.ReadDir(dbDir)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	for _, entry := range entries {
+		if err := copyFile(path.Join(dbDir, entry.Name()), path.Join(crashDir, entry.Name())); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+	recovered, err := Open(crashDir)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer recovered.Close()
+	for i := 0; i < 3; i++ {
+		if _, ok, err := recovered.Get([]byte(fmt.Sprintf("key%d", i))); err != nil || !ok {
+			t.Fatalf("expected key%d to survive the crash, but got %v %v", i, ok, err)
+		}
+	}
+}
@@ -1,16 +1,21 @@
 package lsmtree
 
 import (
+	"bufio"
 	"bytes"
+	"context"
+	"errors"
 	"fmt"
 	"io"
 	"os"
 	"path"
+	"sort"
 	"strconv"
+	"sync"
 )
 
 const (
-	// DiskTableNumFileName是包含最大磁盘表编号的磁盘表文件名。
+	// DiskTableNumFileName是元数据文件名，记录数据库的格式版本，文件名沿用最初记录最大磁盘表编号时的名字。
 	diskTableNumFileName = "maxdisktable"
 	// DiskTableDataFileName是包含原始数据的磁盘表数据文件名。
 	diskTableDataFileName = "data"
@@ -22,171 +27,297 @@ const (
 	newDiskTableFlag = os.O_WRONLY | os.O_CREATE | os.O_TRUNC | os.O_APPEND
 )
 
+// TableInfo 描述一个已写入磁盘的磁盘表。
+type TableInfo struct {
+	// 磁盘表编号。
+	Index int
+	// 磁盘表中的键数量（包括已删除的键）。
+	KeyNum int
+	// 数据文件的大小，分开存放值（详见 SeparateValues）时加上值文件的大小，单位为字节。
+	DataSize int
+	// 数据、值、索引和稀疏索引文件的总大小，单位为字节。
+	FileSize int
+}
+
 // createDiskTable根据给定的内存表（MemTable）、在给定的目录下，使用给定的前缀创建一个磁盘表（DiskTable）。
-func createDiskTable(memTable *memTable, dbDir string, index, sparseKeyDistance int) error {
+// 数据文件使用 format 的压缩算法和记录编码。ctx 被取消时删除已写入的部分文件并返回 ctx 的错误。
+func createDiskTable(ctx context.Context, memTable *memTable, dbDir string, index, sparseKeyDistance int, format tableFormat) (TableInfo, error) {
 	prefix := strconv.Itoa(index) + "-"
 
-	w, err := newDiskTableWriter(dbDir, prefix, sparseKeyDistance)
+	w, err := newDiskTableWriter(dbDir, prefix, sparseKeyDistance, format)
 	if err != nil {
-		return fmt.Errorf("failed to create disk table writer: %w", err)
+		return TableInfo{}, fmt.Errorf("failed to create disk table writer: %w", err)
 	}
 
 	for it := memTable.iterator(); it.hasNext(); {
-		key, value := it.next()
-		if err := w.write(key, value); err != nil {
-			return fmt.Errorf("failed to write to disk table %d: %w", index, err)
+		if err := ctx.Err(); err != nil {
+			abortDiskTable(w, dbDir, prefix)
+			return TableInfo{}, err
+		}
+
+		key, value, flags := it.nextWithFlags()
+		if err := w.write(key, value, flags); err != nil {
+			abortDiskTable(w, dbDir, prefix)
+			return TableInfo{}, fmt.Errorf("failed to write to disk table %d: %w", index, err)
 		}
 
 	}
 
 	if err := w.sync(); err != nil {
-		return fmt.Errorf("failed to sync disk table: %w", err)
+		abortDiskTable(w, dbDir, prefix)
+		return TableInfo{}, fmt.Errorf("failed to sync disk table: %w", err)
 	}
 
 	if err := w.close(); err != nil {
-		return fmt.Errorf("failed to close disk table: %w", err)
+		return TableInfo{}, fmt.Errorf("failed to close disk table: %w", err)
 	}
 
-	return nil
+	return TableInfo{Index: index, KeyNum: w.keyNum, DataSize: w.dataPos + w.valuesPos, FileSize: w.size()}, nil
 }
 
-// searchInDiskTables通过遍历目录中的所有磁盘表，根据给定的键在磁盘表中查找对应的值。
-func searchInDiskTables(dbDir string, maxIndex int, key []byte) ([]byte, bool, error) {
-	for index := maxIndex; index >= 0; index-- {
-		value, exists, err := searchInDiskTable(dbDir, index, key)
+// searchInDiskTables 从新到旧遍历编号在 [oldest, maxIndex] 内的磁盘表，根据给定的键查找对应的值。
+// verify 为 false 时跳过记录校验和的检查。parallelism 大于 1 时交给 searchInDiskTablesParallel。
+// sparse 缓存稀疏索引，为 nil 时每次从稀疏索引文件中查找。
+// 同时返回键的标志、找到键的磁盘表编号（没有找到时为 0）和查找过的磁盘表数量，后者用于统计读放大。
+func searchInDiskTables(dbDir string, sparse *sparseIndexCache, oldest, maxIndex int, key []byte, verify bool, parallelism int) ([]byte, uint32, bool, int, int, error) {
+	if parallelism > 1 && maxIndex > oldest {
+		return searchInDiskTablesParallel(dbDir, sparse, oldest, maxIndex, key, verify, parallelism)
+	}
+
+	tables := 0
+	for index := maxIndex; index >= oldest; index-- {
+		tables++
+		value, flags, exists, err := searchInDiskTable(dbDir, sparse, index, key, verify)
 		if err != nil {
-			return nil, false, fmt.Errorf("failed to search in disk table with index %d: %w", index, err)
+			return nil, 0, false, 0, tables, fmt.Errorf("failed to search in disk table with index %d: %w", index, err)
 		}
 
 		if exists {
-			return value, exists, nil
+			return value, flags, exists, index, tables, nil
+		}
+	}
+
+	return nil, 0, false, 0, tables, nil
+}
+
+// searchInDiskTablesParallel 最多同时在 parallelism 个磁盘表中查找键，
+// 稀疏索引的范围不包含该键的表在打开索引文件之前就会返回。
+// 结果与顺序查找相同：取最新的包含该键的表，比它更新的表出错时返回错误。
+// 所有磁盘表都会被查找，返回的查找数量总是磁盘表的数量。
+func searchInDiskTablesParallel(dbDir string, sparse *sparseIndexCache, oldest, maxIndex int, key []byte, verify bool, parallelism int) ([]byte, uint32, bool, int, int, error) {
+	type result struct {
+		value  []byte
+		flags  uint32
+		exists bool
+		err    error
+	}
+	tables := maxIndex - oldest + 1
+	results := make([]result, tables)
+
+	var wg sync.WaitGroup
+	sem := make(chan struct{}, parallelism)
+	for index := maxIndex; index >= oldest; index-- {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(index int) {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			r := &results[index-oldest]
+			r.value, r.flags, r.exists, r.err = searchInDiskTable(dbDir, sparse, index, key, verify)
+		}(index)
+	}
+	wg.Wait()
+
+	for index := maxIndex; index >= oldest; index-- {
+		r := results[index-oldest]
+		if r.err != nil {
+			return nil, 0, false, 0, tables, fmt.Errorf("failed to search in disk table with index %d: %w", index, r.err)
+		}
+
+		if r.exists {
+			return r.value, r.flags, r.exists, index, tables, nil
 		}
 	}
 
-	return nil, false, nil
+	return nil, 0, false, 0, tables, nil
 }
 
-// searchInDiskTable在给定的磁盘表中查找给定的键。
-func searchInDiskTable(dbDir string, index int, key []byte) ([]byte, bool, error) {
+// searchInDiskTable在给定的磁盘表中查找给定的键，返回值和键的标志。
+func searchInDiskTable(dbDir string, sparse *sparseIndexCache, index int, key []byte, verify bool) ([]byte, uint32, bool, error) {
 	prefix := strconv.Itoa(index) + "-"
 
-	sparseIndexPath := path.Join(dbDir, prefix+diskTableSparseIndexFileName)
-	sparseIndexFile, err := os.OpenFile(sparseIndexPath, os.O_RDONLY, 0600)
+	offset, ok, err := locateInDiskTable(dbDir, sparse, index, key, verify)
+	if err != nil || !ok {
+		return nil, 0, false, err
+	}
+
+	format, err := readTableFormat(dbDir, prefix)
 	if err != nil {
-		return nil, false, fmt.Errorf("failed to open sparse index file: %w", err)
+		return nil, 0, false, err
 	}
-	defer sparseIndexFile.Close()
 
-	from, to, ok, err := searchInSparseIndex(sparseIndexFile, key)
+	dataPath := path.Join(dbDir, prefix+diskTableDataFileName)
+	dataFile, err := os.OpenFile(dataPath, os.O_RDONLY, 0600)
 	if err != nil {
-		return nil, false, fmt.Errorf("failed to search in sparse index file %s: %w", sparseIndexPath, err)
+		return nil, 0, false, fmt.Errorf("failed to open data file: %w", err)
 	}
-	if !ok {
-		return nil, false, nil
+	defer dataFile.Close()
+
+	value, flags, ok, err := searchInDataFile(dataFile, offset, key, format.records, verify)
+	if err != nil {
+		return nil, 0, false, fmt.Errorf("failed to search in data file %s: %w", dataPath, err)
+	}
+
+	if err := dataFile.Close(); err != nil {
+		return nil, 0, false, fmt.Errorf("failed to close data file: %w", err)
+	}
+
+	// 分开存放时数据文件中是指向值文件的指针，再读一次值文件
+	if ok && format.separateValues {
+		if value, err = readSeparateValue(dbDir, prefix, value, verify); err != nil {
+			return nil, 0, false, err
+		}
+	}
+
+	if value, err = format.codec.decompress(value); err != nil {
+		return nil, 0, false, fmt.Errorf("failed to read value in data file %s: %w", dataPath, err)
+	}
+
+	return value, flags, ok, nil
+}
+
+// locateInDiskTable 借助稀疏索引和索引，返回键在编号为 index 的磁盘表数据文件中的记录偏移量，磁盘表中没有该键时返回 false。
+// sparse 不为 nil 时在缓存的稀疏索引中查找。
+func locateInDiskTable(dbDir string, sparse *sparseIndexCache, index int, key []byte, verify bool) (int, bool, error) {
+	prefix := strconv.Itoa(index) + "-"
+	from, to, ok, err := sparseIndexWindow(dbDir, sparse, index, key, verify)
+	if err != nil || !ok {
+		return 0, false, err
 	}
 
 	indexPath := path.Join(dbDir, prefix+diskTableIndexFileName)
 	indexFile, err := os.OpenFile(indexPath, os.O_RDONLY, 0600)
 	if err != nil {
-		return nil, false, fmt.Errorf("failed to open index file: %w", err)
+		return 0, false, fmt.Errorf("failed to open index file: %w", err)
 	}
 	defer indexFile.Close()
 
-	offset, ok, err := searchInIndex(indexFile, from, to, key)
+	offset, ok, err := searchInIndex(indexFile, from, to, key, verify)
 	if err != nil {
-		return nil, false, fmt.Errorf("failed to search in index file %s: %w", indexPath, err)
+		return 0, false, fmt.Errorf("failed to search in index file %s: %w", indexPath, err)
 	}
 	if !ok {
-		return nil, false, nil
+		return 0, false, nil
 	}
 
-	dataPath := path.Join(dbDir, prefix+diskTableDataFileName)
-	dataFile, err := os.OpenFile(dataPath, os.O_RDONLY, 0600)
-	if err != nil {
-		return nil, false, fmt.Errorf("failed to open data file: %w", err)
-	}
-	defer dataFile.Close()
-
-	value, ok, err := searchInDataFile(dataFile, offset, key)
-	if err != nil {
-		return nil, false, fmt.Errorf("failed to search in data file %s: %w", dataPath, err)
-	}
+	return offset, true, nil
+}
 
-	if err := sparseIndexFile.Close(); err != nil {
-		return nil, false, fmt.Errorf("failed to close sparse index file: %w", err)
+// sparseIndexWindow 返回键在编号为 index 的磁盘表索引文件中所在的范围，语义与 searchInSparseIndex 相同。
+func sparseIndexWindow(dbDir string, sparse *sparseIndexCache, index int, key []byte, verify bool) (int, int, bool, error) {
+	if sparse != nil {
+		s, err := sparse.get(dbDir, index, verify)
+		if err != nil {
+			return 0, 0, false, err
+		}
+		from, to, ok := s.window(key)
+		return from, to, ok, nil
 	}
 
-	if err := indexFile.Close(); err != nil {
-		return nil, false, fmt.Errorf("failed to close index file: %w", err)
+	sparseIndexPath := path.Join(dbDir, strconv.Itoa(index)+"-"+diskTableSparseIndexFileName)
+	sparseIndexFile, err := os.OpenFile(sparseIndexPath, os.O_RDONLY, 0600)
+	if err != nil {
+		return 0, 0, false, fmt.Errorf("failed to open sparse index file: %w", err)
 	}
+	defer sparseIndexFile.Close()
 
-	if err := dataFile.Close(); err != nil {
-		return nil, false, fmt.Errorf("failed to close data file: %w", err)
+	from, to, ok, err := searchInSparseIndex(sparseIndexFile, key, verify)
+	if err != nil {
+		return 0, 0, false, fmt.Errorf("failed to search in sparse index file %s: %w", sparseIndexPath, err)
 	}
-
-	return value, ok, nil
+	return from, to, ok, nil
 }
 
 // searchInDataFile从给定的偏移量开始，在数据文件中根据键查找对应的值。
-// 偏移量必须始终指向记录的开头。
-func searchInDataFile(r io.ReadSeeker, offset int, searchKey []byte) ([]byte, bool, error) {
-	if _, err := r.Seek(int64(offset), io.SeekStart); err != nil {
-		return nil, false, fmt.Errorf("failed to seek: %w", err)
+// 偏移量必须始终指向记录的开头，记录按 records 编码。
+func searchInDataFile(rs io.ReadSeeker, offset int, searchKey []byte, records RecordFormat, verify bool) ([]byte, uint32, bool, error) {
+	if _, err := rs.Seek(int64(offset), io.SeekStart); err != nil {
+		return nil, 0, false, fmt.Errorf("failed to seek: %w", err)
 	}
 
+	// 变长的长度需要逐字节读取，经过缓冲区避免每个字节一次系统调用
+	var r io.Reader = rs
+	if records != FixedRecords {
+		r = bufio.NewReader(rs)
+	}
 	for {
-		key, value, err := decode(r)
+		key, value, flags, err := records.decode(r, verify)
 		if err != nil && err != io.EOF {
-			return nil, false, fmt.Errorf("failed to read: %w", err)
+			return nil, 0, false, fmt.Errorf("failed to read: %w", err)
 		}
 		if err == io.EOF {
-			return nil, false, nil
+			return nil, 0, false, nil
 		}
 
 		if bytes.Equal(key, searchKey) {
-			return value, true, nil
+			return value, flags, true, nil
 		}
 	}
 }
 
 // searchInIndex在指定范围内的索引文件中查找键。
-func searchInIndex(r io.ReadSeeker, from, to int, searchKey []byte) (int, bool, error) {
+// 把 [from, to) 内的索引块一次读入内存，解析后二分查找；to 为 0 表示一直读到文件末尾，
+// 与 searchInSparseIndex 返回的范围一致。
+func searchInIndex(r io.ReadSeeker, from, to int, searchKey []byte, verify bool) (int, bool, error) {
 	if _, err := r.Seek(int64(from), io.SeekStart); err != nil {
 		return 0, false, fmt.Errorf("failed to seek: %w", err)
 	}
 
-	for {
-		key, value, err := decode(r)
-		if err != nil && err != io.EOF {
-			return 0, false, fmt.Errorf("failed to read: %w", err)
-		}
-		if err == io.EOF {
-			return 0, false, nil
-		}
-		offset := decodeInt(value)
-
-		if bytes.Equal(key, searchKey) {
-			return offset, true, nil
-		}
+	var block io.Reader = r
+	if to > 0 {
+		block = io.LimitReader(r, int64(to-from))
+	}
+	data, err := io.ReadAll(block)
+	if err != nil {
+		return 0, false, fmt.Errorf("failed to read: %w", err)
+	}
 
-		if to > from {
-			current, err := r.Seek(0, io.SeekCurrent)
-			if err != nil {
-				return 0, false, fmt.Errorf("failed to seek: %w", err)
-			}
+	return searchInIndexBlock(data, searchKey, verify)
+}
 
-			if current > int64(to) {
-				return 0, false, nil
-			}
+// searchInIndexBlock 解析内存中的索引块，在按键排序的条目中二分查找键。
+// 条目是变长的，无法直接在文件中二分，但解析只是顺序解码，比较键的次数从块内的条目数降到对数级别。
+func searchInIndexBlock(data []byte, searchKey []byte, verify bool) (int, bool, error) {
+	var keys [][]byte
+	var offsets []int
+	r := bytes.NewReader(data)
+	for r.Len() > 0 {
+		key, value, err := decodeRecord(r, verify)
+		if err != nil {
+			return 0, false, fmt.Errorf("failed to read: %w", err)
 		}
+		keys = append(keys, key)
+		offsets = append(offsets, decodeInt(value))
+	}
+
+	i := sort.Search(len(keys), func(i int) bool {
+		return bytes.Compare(keys[i], searchKey) >= 0
+	})
+	if i < len(keys) && bytes.Equal(keys[i], searchKey) {
+		return offsets[i], true, nil
 	}
+	return 0, false, nil
 }
 
 // searchInSparseIndex查找键所在的范围。
-func searchInSparseIndex(r io.Reader, searchKey []byte) (int, int, bool, error) {
+// 返回索引文件中的 [from, to)：from 是最后一个不大于键的稀疏索引条目的偏移量，
+// to 是下一个条目的偏移量，键落在最后一个条目之后时 to 为 0，表示一直到索引文件末尾。
+// 键恰好等于某个条目时同样以下一个条目作为上界，只查找这一个稀疏块。
+func searchInSparseIndex(r io.Reader, searchKey []byte, verify bool) (int, int, bool, error) {
 	from := -1
 	for {
-		key, value, err := decode(r)
+		key, value, err := decodeRecord(r, verify)
 		if err != nil && err != io.EOF {
 			return 0, 0, false, fmt.Errorf("failed to read: %w", err)
 		}
@@ -196,11 +327,9 @@ func searchInSparseIndex(r io.Reader, searchKey []byte) (int, int, bool, error)
 		offset := decodeInt(value)
 
 		cmp := bytes.Compare(key, searchKey)
-		if cmp == 0 {
-			return offset, offset, true, nil
-		} else if cmp < 0 {
+		if cmp <= 0 {
 			from = offset
-		} else if cmp > 0 {
+		} else {
 			if from == -1 {
 				// 如果稀疏索引中的第一个键大于查找的键，意味着不存在该键
 				return 0, 0, false, nil
@@ -211,12 +340,55 @@ func searchInSparseIndex(r io.Reader, searchKey []byte) (int, int, bool, error)
 	}
 }
 
-// renameDiskTable重命名磁盘表的相关文件，包括数据、索引和稀疏索引文件。
+// moveDiskTable 与 renameDiskTable 相同，但目标编号上已有磁盘表时返回错误而不是覆盖它。
+// 合并后重新编号必须使用它，并按从新到旧的顺序移动，保证编号越大越新。
+func moveDiskTable(dbDir string, oldPrefix, newPrefix string) error {
+	if _, err := os.Stat(path.Join(dbDir, newPrefix+diskTableDataFileName)); err == nil {
+		return fmt.Errorf("failed to move disk table %s to %s: target already exists", oldPrefix, newPrefix)
+	} else if !os.IsNotExist(err) {
+		return fmt.Errorf("failed to stat disk table %s: %w", newPrefix, err)
+	}
+
+	return renameDiskTable(dbDir, oldPrefix, newPrefix)
+}
+
+// moveDiskTables 把按从新到旧排列的磁盘表 live 移到以 max 结尾的连续编号上，填补合并留下的空缺，返回移动的数量。
+// 目标编号不小于原来的编号，按这个顺序移动时目标总是空的。
+func moveDiskTables(dbDir string, live []int, max int) (int, error) {
+	moved := 0
+	for i, index := range live {
+		target := max - i
+		if index == target {
+			continue
+		}
+		if err := moveDiskTable(dbDir, strconv.Itoa(index)+"-", strconv.Itoa(target)+"-"); err != nil {
+			return moved, err
+		}
+		moved++
+	}
+	return moved, nil
+}
+
+// renameDiskTable重命名磁盘表的相关文件，包括数据、值、压缩算法的记录、索引和稀疏索引文件。
+// 文件按这个顺序重命名，Open 时据此补完被崩溃打断的重命名，详见 recoverDiskTables。
 func renameDiskTable(dbDir string, oldPrefix, newPrefix string) error {
 	if err := os.Rename(path.Join(dbDir, oldPrefix+diskTableDataFileName), path.Join(dbDir, newPrefix+diskTableDataFileName)); err != nil {
 		return fmt.Errorf("failed to rename data file: %w", err)
 	}
 
+	// 值文件和压缩算法的记录不一定存在，覆盖目标时不能留下目标原来的文件
+	for _, name := range []string{diskTableValuesFileName, diskTableCodecFileName} {
+		oldPath := path.Join(dbDir, oldPrefix+name)
+		newPath := path.Join(dbDir, newPrefix+name)
+		if err := os.Rename(oldPath, newPath); os.IsNotExist(err) {
+			if err := os.Remove(newPath); err != nil && !os.IsNotExist(err) {
+				return fmt.Errorf("failed to remove %s file: %w", name, err)
+			}
+		} else if err != nil {
+			return fmt.Errorf("failed to rename %s file: %w", name, err)
+		}
+	}
+
 	if err := os.Rename(path.Join(dbDir, oldPrefix+diskTableIndexFileName), path.Join(dbDir, newPrefix+diskTableIndexFileName)); err != nil {
 		return fmt.Errorf("failed to rename index file: %w", err)
 	}
@@ -228,7 +400,7 @@ func renameDiskTable(dbDir string, oldPrefix, newPrefix string) error {
 	return nil
 }
 
-// deleteDiskTables删除磁盘表的相关文件，包括数据、索引和稀疏索引文件。
+// deleteDiskTables删除磁盘表的相关文件，包括数据、索引、稀疏索引文件以及值文件和压缩算法的记录。
 func deleteDiskTables(dbDir string, prefixes ...string) error {
 	for _, prefix := range prefixes {
 		dataPath := path.Join(dbDir, prefix+diskTableDataFileName)
@@ -245,24 +417,47 @@ func deleteDiskTables(dbDir string, prefixes ...string) error {
 		if err := os.Remove(sparseIndexPath); err != nil {
 			return fmt.Errorf("failed to remove data file %s: %w", sparseIndexPath, err)
 		}
+
+		for _, name := range []string{diskTableValuesFileName, diskTableCodecFileName} {
+			filePath := path.Join(dbDir, prefix+name)
+			if err := os.Remove(filePath); err != nil && !os.IsNotExist(err) {
+				return fmt.Errorf("failed to remove %s file %s: %w", name, filePath, err)
+			}
+		}
 	}
 
 	return nil
 }
 
+// errKeyOutOfOrder 在调试构建中写入的键没有严格递增时由 diskTableWriter.write 返回，详见 checkKeyOrder。
+var errKeyOutOfOrder = errors.New("key out of order")
+
 // diskTableWriter是磁盘表的一个简单抽象，仅用于写入操作相关的功能。
 type diskTableWriter struct {
 	dataFile        *os.File
 	indexFile       *os.File
 	sparseIndexFile *os.File
+	// 分开存放值时的值文件，否则为 nil
+	valuesFile *os.File
 
 	sparseKeyDistance int
+	// 值的压缩算法和记录编码
+	format tableFormat
 
-	keyNum, dataPos, indexPos int
+	keyNum, dataPos, indexPos, sparseIndexPos, valuesPos int
+	// 上一个写入的键，只在 checkKeyOrder 时记录
+	lastKey []byte
 }
 
-// newDiskTableWriter返回一个新的diskTableWriter实例。
-func newDiskTableWriter(dbDir, prefix string, sparseKeyDistance int) (*diskTableWriter, error) {
+// newDiskTableWriter返回一个新的diskTableWriter实例，数据文件使用 format 的压缩算法和记录编码。
+func newDiskTableWriter(dbDir, prefix string, sparseKeyDistance int, format tableFormat) (*diskTableWriter, error) {
+	if !format.codec.valid() {
+		return nil, fmt.Errorf("unknown codec %d", byte(format.codec))
+	}
+	if !format.records.valid() {
+		return nil, fmt.Errorf("unknown record format %d", byte(format.records))
+	}
+
 	dataPath := path.Join(dbDir, prefix+diskTableDataFileName)
 	dataFile, err := os.OpenFile(dataPath, newDiskTableFlag, 0600)
 	if err != nil {
@@ -281,20 +476,55 @@ func newDiskTableWriter(dbDir, prefix string, sparseKeyDistance int) (*diskTable
 		return nil, fmt.Errorf("failed to open sparse index file %s: %w", sparseIndexPath, err)
 	}
 
+	// 同一前缀上可能残留着之前写了一半的磁盘表的记录和值文件，不压缩、不分开存放时也要清理
+	for _, name := range []string{diskTableCodecFileName, diskTableValuesFileName} {
+		filePath := path.Join(dbDir, prefix+name)
+		if err := os.Remove(filePath); err != nil && !os.IsNotExist(err) {
+			return nil, fmt.Errorf("failed to remove %s file %s: %w", name, filePath, err)
+		}
+	}
+	var valuesFile *os.File
+	if format.separateValues {
+		valuesPath := path.Join(dbDir, prefix+diskTableValuesFileName)
+		if valuesFile, err = os.OpenFile(valuesPath, newDiskTableFlag, 0600); err != nil {
+			return nil, fmt.Errorf("failed to open values file %s: %w", valuesPath, err)
+		}
+	}
+	if err := writeTableFormat(dbDir, prefix, format); err != nil {
+		return nil, err
+	}
+
 	return &diskTableWriter{
 		dataFile:          dataFile,
 		indexFile:         indexFile,
 		sparseIndexFile:   sparseIndexFile,
+		valuesFile:        valuesFile,
 		sparseKeyDistance: sparseKeyDistance,
+		format:            format,
 		keyNum:            0,
 		dataPos:           0,
 		indexPos:          0,
 	}, nil
 }
 
-// write将键和值写入磁盘表的相关文件，即数据、索引和稀疏索引文件。
-func (w *diskTableWriter) write(key, value []byte) error {
-	dataBytes, err := encode(key, value, w.dataFile)
+// write将键、值和键的标志写入磁盘表的相关文件，即数据、索引和稀疏索引文件，分开存放时值写入值文件。
+func (w *diskTableWriter) write(key, value []byte, flags uint32) error {
+	if checkKeyOrder {
+		if w.keyNum > 0 && bytes.Compare(key, w.lastKey) <= 0 {
+			return fmt.Errorf("%w: %q after %q", errKeyOutOfOrder, key, w.lastKey)
+		}
+		w.lastKey = append(w.lastKey[:0], key...)
+	}
+	value = w.format.codec.compress(value)
+	if w.valuesFile != nil && value != nil {
+		n, err := w.valuesFile.Write(value)
+		if err != nil {
+			return fmt.Errorf("failed to write to the values file: %w", err)
+		}
+		value = encodeValuePointer(int64(w.valuesPos), value)
+		w.valuesPos += n
+	}
+	dataBytes, err := w.format.records.encode(key, value, flags, w.dataFile)
 	if err != nil {
 		return fmt.Errorf("failed to write to the data file: %w", err)
 	}
@@ -305,9 +535,11 @@ func (w *diskTableWriter) write(key, value []byte) error {
 	}
 
 	if w.keyNum%w.sparseKeyDistance == 0 {
-		if _, err := encodeKeyOffset(key, w.indexPos, w.sparseIndexFile); err != nil {
+		sparseIndexBytes, err := encodeKeyOffset(key, w.indexPos, w.sparseIndexFile)
+		if err != nil {
 			return fmt.Errorf("failed to write to the file: %w", err)
 		}
+		w.sparseIndexPos += sparseIndexBytes
 	}
 
 	w.dataPos += dataBytes
@@ -317,6 +549,17 @@ func (w *diskTableWriter) write(key, value []byte) error {
 	return nil
 }
 
+// size返回已写入所有文件的总字节数。
+func (w *diskTableWriter) size() int {
+	return w.dataPos + w.indexPos + w.sparseIndexPos + w.valuesPos
+}
+
+// abortDiskTable关闭写入器并删除写入了一部分的磁盘表文件。
+func abortDiskTable(w *diskTableWriter, dbDir, prefix string) {
+	_ = w.close()
+	_ = deleteDiskTables(dbDir, prefix)
+}
+
 // sync将所有已写入的内容提交到稳定存储中。
 func (w *diskTableWriter) sync() error {
 	if err := w.dataFile.Sync(); err != nil {
@@ -331,6 +574,12 @@ func (w *diskTableWriter) sync() error {
 		return fmt.Errorf("failed to sync sparse index file: %w", err)
 	}
 
+	if w.valuesFile != nil {
+		if err := w.valuesFile.Sync(); err != nil {
+			return fmt.Errorf("failed to sync values file: %w", err)
+		}
+	}
+
 	return nil
 }
 
@@ -348,32 +597,54 @@ func (w *diskTableWriter) close() error {
 		return fmt.Errorf("failed to close sparse index file: %w", err)
 	}
 
+	if w.valuesFile != nil {
+		if err := w.valuesFile.Close(); err != nil {
+			return fmt.Errorf("failed to close values file: %w", err)
+		}
+	}
+
 	return nil
 }
 
-// updateDiskTableMeta更新当前最大磁盘表编号。
-func updateDiskTableMeta(dbDir string, num, max int) error {
+// updateDiskTableMeta更新元数据中的格式版本。
+// 元数据的格式为 [格式版本（1 字节）]，磁盘表的集合记录在清单中，详见 manifestFileName。
+func updateDiskTableMeta(dbDir string) error {
 	filePath := path.Join(dbDir, diskTableNumFileName)
-	if err := os.WriteFile(filePath, encodeIntPair(num, max), 0600); err != nil {
+	if err := os.WriteFile(filePath, []byte{formatVersion}, 0600); err != nil {
 		return fmt.Errorf("failed to write %s: %w", filePath, err)
 	}
 
 	return nil
 }
 
-// readDiskTableMeta读取并返回磁盘表编号以及最大索引值。
-func readDiskTableMeta(dbDir string) (int, int, error) {
+// readDiskTableMeta读取并返回格式版本、磁盘表编号以及最大索引值。
+// 版本 1 的元数据没有格式版本字节，版本 2 的元数据为 [格式版本][磁盘表数量][最大编号]，
+// 之后的元数据只有格式版本，磁盘表数量和最大编号返回 0 和 -1，由清单决定。
+// 元数据不存在时，walDir 中有非空的 WAL 说明数据库由版本 1 创建且尚未刷新过，否则是新数据库。
+func readDiskTableMeta(dbDir, walDir string) (int, int, int, error) {
 	filePath := path.Join(dbDir, diskTableNumFileName)
 	data, err := os.ReadFile(filePath)
 	if err != nil && !os.IsNotExist(err) {
-		return 0, 0, fmt.Errorf("failed to read file %s: %w", filePath, err)
+		return 0, 0, 0, fmt.Errorf("failed to read file %s: %w", filePath, err)
 	}
 
 	if err != nil && os.IsNotExist(err) {
-		return 0, -1, nil
+		if size, err := GetFileSize(path.Join(walDir, walFileName)); err == nil && size > 0 {
+			return 1, 0, -1, nil
+		}
+		return formatVersion, 0, -1, nil
 	}
 
-	num, max := decodeIntPair(data)
-
-	return num, max, nil
+	switch len(data) {
+	case 1:
+		return int(data[0]), 0, -1, nil
+	case 16:
+		num, max := decodeIntPair(data)
+		return 1, num, max, nil
+	case 17:
+		num, max := decodeIntPair(data[1:])
+		return int(data[0]), num, max, nil
+	default:
+		return 0, 0, 0, fmt.Errorf("the file %s is corrupted, invalid length %d", filePath, len(data))
+	}
 }
@@ -0,0 +1,166 @@
+package lsmtree
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path"
+	"testing"
+)
+
+func TestSearchInSparseIndexBounds(t *testing.T) {
+	sparse := new(bytes.Buffer)
+	for i, key := range []string{"b", "d", "f"} {
+		if _, err := encodeKeyOffset([]byte(key), i*100, sparse); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, tc := range []struct {
+		key      string
+		from, to int
+		ok       bool
+	}{
+		{"a", 0, 0, false},
+		// 键等于稀疏索引条目时以下一个条目为上界
+		{"b", 0, 100, true},
+		{"c", 0, 100, true},
+		{"d", 100, 200, true},
+		{"e", 100, 200, true},
+		// 最后一个条目之后一直到文件末尾
+		{"f", 200, 0, true},
+		{"g", 200, 0, true},
+	} {
+		from, to, ok, err := searchInSparseIndex(bytes.NewReader(sparse.Bytes()), []byte(tc.key), true)
+		if err != nil || from != tc.from || to != tc.to || ok != tc.ok {
+			t.Fatalf("%s: expected [%d, %d) %v, but got [%d, %d) %v (%v)", tc.key, tc.from, tc.to, tc.ok, from, to, ok, err)
+		}
+	}
+}
+
+func TestSearchInIndexStopsAtBound(t *testing.T) {
+	index := new(bytes.Buffer)
+	var ends []int
+	for i, key := range []string{"d", "e", "f", "g"} {
+		if _, err := encodeKeyOffset([]byte(key), i, index); err != nil {
+			t.Fatal(err)
+		}
+		ends = append(ends, index.Len())
+	}
+	r := bytes.NewReader(index.Bytes())
+
+	// 上界之后的键不会被找到，说明没有一直读到文件末尾
+	if _, ok, err := searchInIndex(r, 0, ends[0], []byte("g"), true); err != nil || ok {
+		t.Fatalf("expected g to be outside [0, %d), but got %v (%v)", ends[0], ok, err)
+	}
+	if offset, ok, err := searchInIndex(r, 0, ends[0], []byte("d"), true); err != nil || !ok || offset != 0 {
+		t.Fatalf("expected d at 0, but got %d %v (%v)", offset, ok, err)
+	}
+	// to 为 0 时查找到文件末尾
+	if offset, ok, err := searchInIndex(r, ends[0], 0, []byte("g"), true); err != nil || !ok || offset != 3 {
+		t.Fatalf("expected g at 3, but got %d %v (%v)", offset, ok, err)
+	}
+}
+
+func TestGetSparseIndexKeys(t *testing.T) {
+	dbDir := t.TempDir()
+	tree, err := Open(dbDir, SparseKeyDistance(4))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tree.Close()
+
+	for i := 0; i < 50; i++ {
+		key := []byte(fmt.Sprintf("key%03d", i))
+		if err := tree.Put(key, key); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tree.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	// 每个稀疏索引条目的键都只查找自己的稀疏块，并且能被读到
+	prefix := fmt.Sprintf("%d-", tree.maxDiskTableIndex)
+	sparse, err := os.ReadFile(path.Join(dbDir, prefix+diskTableSparseIndexFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := bytes.NewReader(sparse)
+	entries := 0
+	for r.Len() > 0 {
+		key, value, err := decode(r)
+		if err != nil {
+			t.Fatal(err)
+		}
+		entries++
+		from, to, ok, err := searchInSparseIndex(bytes.NewReader(sparse), key, true)
+		if err != nil || !ok || from != decodeInt(value) || to != 0 && to <= from {
+			t.Fatalf("%s: expected a window starting at %d, but got [%d, %d) %v (%v)", key, decodeInt(value), from, to, ok, err)
+		}
+		if got, ok, err := tree.Get(key); err != nil || !ok || !bytes.Equal(got, key) {
+			t.Fatalf("expected %s, but got %s %v (%v)", key, got, ok, err)
+		}
+	}
+	if entries < 2 {
+		t.Fatalf("expected several sparse index entries, but got %d", entries)
+	}
+}
+
+func TestSearchInIndexBlock(t *testing.T) {
+	block := new(bytes.Buffer)
+	for i := 0; i < 100; i++ {
+		if _, err := encodeKeyOffset([]byte(fmt.Sprintf("key%03d", 2*i)), i, block); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for i := 0; i < 200; i++ {
+		key := fmt.Sprintf("key%03d", i)
+		offset, ok, err := searchInIndexBlock(block.Bytes(), []byte(key), true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		// 只有偶数键在索引块中
+		if ok != (i%2 == 0) || ok && offset != i/2 {
+			t.Fatalf("%s: expected %v at %d, but got %v at %d", key, i%2 == 0, i/2, ok, offset)
+		}
+	}
+	for _, key := range []string{"a", "key", "z"} {
+		if _, ok, err := searchInIndexBlock(block.Bytes(), []byte(key), true); err != nil || ok {
+			t.Fatalf("%s: expected no entry, but got %v (%v)", key, ok, err)
+		}
+	}
+
+	// 截断的索引块返回错误
+	if _, _, err := searchInIndexBlock(block.Bytes()[:block.Len()-1], []byte("key000"), true); err == nil {
+		t.Fatal("expected an error for a truncated index block")
+	}
+}
+
+func BenchmarkGetSparseKeyDistance1024(b *testing.B) {
+	count := 50000
+	dbDir := b.TempDir()
+	tree, err := Open(dbDir, MemTableThreshold(64<<20), SparseKeyDistance(1024))
+	if err != nil {
+		b.Fatalf("failed to open LSM tree %s: %s", dbDir, err)
+	}
+	defer tree.Close()
+	for i := 0; i < count; i++ {
+		key := []byte(fmt.Sprintf("key%06d", i))
+		if err := tree.Put(key, key); err != nil {
+			b.Fatal(err)
+		}
+	}
+	if err := tree.Flush(); err != nil {
+		b.Fatal(err)
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		key := []byte(fmt.Sprintf("key%06d", i*7919%count))
+		if _, ok, err := tree.Get(key); err != nil || !ok {
+			b.Fatalf("expected %s to exist (%v)", key, err)
+		}
+	}
+}
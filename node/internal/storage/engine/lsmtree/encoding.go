@@ -2,86 +2,143 @@ package lsmtree
 
 import (
 	"encoding/binary"
+	"errors"
 	"fmt"
+	"hash/crc32"
 	"io"
 )
 
+// checksumLen 是记录中校验和的字节数。
+const checksumLen = 4
+
+// flagsLen 是记录中标志的字节数，详见 PutWithFlags。
+const flagsLen = 4
+
+// flaggedKeyLen 是编码的键长度中表示记录在键之后带有标志的位。
+// 键的长度远小于这个值，没有标志的记录不设置它，因此格式与引入标志之前相同；
+// 不认识标志的旧版本读到带标志的记录时会报告键长度无效，而不是读错值。
+const flaggedKeyLen = 1 << 62
+
+// ErrChecksumMismatch 当记录的校验和与内容不一致时返回，说明文件已损坏。
+var ErrChecksumMismatch = errors.New("checksum mismatch")
+
 // encode 对键和值进行编码，并将其写入指定的写入器。
 // 返回写入的字节数和发生的错误。
 // 此函数必须与 decode 兼容：encode(decode(v)) == v。
 func encode(key []byte, value []byte, w io.Writer) (int, error) {
+	return encodeWithFlags(key, value, 0, w)
+}
+
+// encodeWithFlags 与 encode 相同，flags 不为 0 时在键之后写入 4 字节的标志，
+// 并在编码的键长度中设置 flaggedKeyLen。flags 为 0 的记录与 encode 的结果完全相同。
+func encodeWithFlags(key []byte, value []byte, flags uint32, w io.Writer) (int, error) {
 	// 编码格式：
-	// [编码的总长度（字节）][编码的键长度（字节）][键][值]
+	// [编码的总长度（字节）][校验和][编码的键长度（字节）][键][标志（可选）][值]
+	// 校验和是对 [编码的键长度][键][标志][值] 计算的 CRC32。
 
 	// 已写入的字节数
 	bytes := 0
 
 	keyLen := encodeInt(len(key))
-	len := len(keyLen) + len(key) + len(value)
-	encodedLen := encodeInt(len)
-
-	if n, err := w.Write(encodedLen); err != nil {
-		return n, err
-	} else {
-		bytes += n
-	}
-
-	if n, err := w.Write(keyLen); err != nil {
-		return bytes + n, err
-	} else {
-		bytes += n
-	}
-
-	if n, err := w.Write(key); err != nil {
-		return bytes + n, err
-	} else {
-		bytes += n
+	var encodedFlags []byte
+	if flags != 0 {
+		keyLen = encodeInt(len(key) | flaggedKeyLen)
+		encodedFlags = binary.BigEndian.AppendUint32(nil, flags)
 	}
+	len := checksumLen + len(keyLen) + len(key) + len(encodedFlags) + len(value)
+	encodedLen := encodeInt(len)
 
-	if n, err := w.Write(value); err != nil {
-		return bytes + n, err
-	} else {
-		bytes += n
+	h := crc32.NewIEEE()
+	h.Write(keyLen)
+	h.Write(key)
+	h.Write(encodedFlags)
+	h.Write(value)
+	var checksum [checksumLen]byte
+	binary.BigEndian.PutUint32(checksum[:], h.Sum32())
+
+	for _, part := range [][]byte{encodedLen, checksum[:], keyLen, key, encodedFlags, value} {
+		if n, err := w.Write(part); err != nil {
+			return bytes + n, err
+		} else {
+			bytes += n
+		}
 	}
 
 	return bytes, nil
 }
 
-// decode 从指定的读取器中解码键和值。
+// decode 从指定的读取器中解码键和值，并校验记录的校验和。
 // 返回读取的字节数和发生的错误。
 // 此函数必须与 encode 兼容：encode(decode(v)) == v。
 func decode(r io.Reader) ([]byte, []byte, error) {
+	return decodeRecord(r, true)
+}
+
+// decodeRecord 从指定的读取器中解码键和值，verify 为 false 时跳过校验和检查。
+func decodeRecord(r io.Reader, verify bool) ([]byte, []byte, error) {
+	key, value, _, err := decodeRecordWithFlags(r, verify)
+	return key, value, err
+}
+
+// decodeRecordWithFlags 与 decodeRecord 相同，同时返回记录的标志，没有标志的记录返回 0。
+func decodeRecordWithFlags(r io.Reader, verify bool) ([]byte, []byte, uint32, error) {
 	// 编码格式：
-	// [编码的总长度（字节）][编码的键长度（字节）][键][值]
+	// [编码的总长度（字节）][校验和][编码的键长度（字节）][键][标志（可选）][值]
 
+	// 使用 io.ReadFull 读取完整的长度和记录，读取器单次 Read 可能只返回一部分数据
 	var encodedEntryLen [8]byte
-	if _, err := r.Read(encodedEntryLen[:]); err != nil {
-		return nil, nil, err
+	if _, err := io.ReadFull(r, encodedEntryLen[:]); err != nil {
+		if err == io.ErrUnexpectedEOF {
+			return nil, nil, 0, fmt.Errorf("the file is corrupted, failed to read entry length: %w", err)
+		}
+		return nil, nil, 0, err
 	}
 
 	entryLen := decodeInt(encodedEntryLen[:])
+	if entryLen < checksumLen+8 {
+		return nil, nil, 0, fmt.Errorf("the file is corrupted, invalid entry length %d", entryLen)
+	}
 	encodedEntry := make([]byte, entryLen)
-	n, err := r.Read(encodedEntry)
-	if err != nil {
-		return nil, nil, err
+	if _, err := io.ReadFull(r, encodedEntry); err != nil {
+		return nil, nil, 0, fmt.Errorf("the file is corrupted, failed to read entry: %w", err)
 	}
 
-	if n < entryLen {
-		return nil, nil, fmt.Errorf("the file is corrupted, failed to read entry")
+	checksum := binary.BigEndian.Uint32(encodedEntry[0:checksumLen])
+	encodedEntry = encodedEntry[checksumLen:]
+	if verify && crc32.ChecksumIEEE(encodedEntry) != checksum {
+		return nil, nil, 0, ErrChecksumMismatch
 	}
 
-	keyLen := decodeInt(encodedEntry[0:8])
-	key := encodedEntry[8 : 8+keyLen]
+	keyLen, flagged := splitKeyLen(decodeInt(encodedEntry[0:8]))
 	keyPartLen := 8 + keyLen
+	if flagged {
+		keyPartLen += flagsLen
+	}
+	if keyLen < 0 || keyPartLen > len(encodedEntry) {
+		return nil, nil, 0, fmt.Errorf("the file is corrupted, invalid key length %d", keyLen)
+	}
+	key := encodedEntry[8 : 8+keyLen]
+	var flags uint32
+	if flagged {
+		flags = binary.BigEndian.Uint32(encodedEntry[8+keyLen : keyPartLen])
+	}
 
 	if keyPartLen == len(encodedEntry) {
-		return key, nil, err
+		return key, nil, flags, nil
 	}
 
 	valueStart := keyPartLen
 	value := encodedEntry[valueStart:]
 
-	return key, value, err
+	return key, value, flags, nil
+}
+
+// splitKeyLen 从编码的键长度中分离出键的长度和记录是否带有标志。
+func splitKeyLen(encoded int) (int, bool) {
+	if encoded >= 0 && encoded&flaggedKeyLen != 0 {
+		return encoded &^ flaggedKeyLen, true
+	}
+	return encoded, false
 }
 
 // encodeKeyOffset 编码键偏移量并将其写入给定的写入器。
@@ -120,3 +177,20 @@ func decodeIntPair(encoded []byte) (int, int) {
 
 	return x, y
 }
+
+// EncodeOrderedInt 把整数编码为 8 字节的键，键按字节序比较的顺序与整数的大小顺序一致，负数也是如此。
+// 编码翻转大端序补码的符号位，使负数排在非负数之前。可以作为键的前缀或整个键，用 DecodeOrderedInt 解码。
+func EncodeOrderedInt(x int64) []byte {
+	var encoded [8]byte
+	binary.BigEndian.PutUint64(encoded[:], uint64(x)^(1<<63))
+
+	return encoded[:]
+}
+
+// DecodeOrderedInt 解码 EncodeOrderedInt 编码的整数，encoded 必须恰好是 8 字节。
+func DecodeOrderedInt(encoded []byte) (int64, error) {
+	if len(encoded) != 8 {
+		return 0, fmt.Errorf("ordered int must be 8 bytes, got %d", len(encoded))
+	}
+	return int64(binary.BigEndian.Uint64(encoded) ^ (1 << 63)), nil
+}
@@ -0,0 +1,174 @@
+package lsmtree
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"sort"
+	"testing"
+	"testing/iotest"
+)
+
+func TestEncodeDecode(t *testing.T) {
+	var buf bytes.Buffer
+	if _, err := encode([]byte("key"), []byte("value"), &buf); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if _, err := encode([]byte("deleted"), nil, &buf); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	key, value, err := decode(&buf)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if string(key) != "key" || string(value) != "value" {
+		t.Fatalf("unexpected record %s=%s", key, value)
+	}
+
+	key, value, err = decode(&buf)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if string(key) != "deleted" || value != nil {
+		t.Fatalf("unexpected record %s=%s", key, value)
+	}
+}
+
+func TestDecodeChecksumMismatch(t *testing.T) {
+	var buf bytes.Buffer
+	if _, err := encode([]byte("key"), []byte("value"), &buf); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	// 篡改值的最后一个字节
+	encoded := buf.Bytes()
+	encoded[len(encoded)-1] ^= 0xff
+
+	if _, _, err := decode(bytes.NewReader(encoded)); !errors.Is(err, ErrChecksumMismatch) {
+		t.Fatalf("expected %v, but got %v", ErrChecksumMismatch, err)
+	}
+
+	key, value, err := decodeRecord(bytes.NewReader(encoded), false)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if string(key) != "key" || bytes.Equal(value, []byte("value")) {
+		t.Fatalf("expected corrupted value to be returned unverified, but got %s=%s", key, value)
+	}
+}
+
+func TestDecodeShortReads(t *testing.T) {
+	var buf bytes.Buffer
+	key := []byte("\x00\x00\x00\x00\x00\x00\x00\x08key")
+	value := []byte("\x00\x00\x00\x00\x00\x00\x00\x00")
+	if _, err := encode(key, value, &buf); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	// 每次 Read 只返回一个字节的读取器也能解码完整的记录
+	r := iotest.OneByteReader(bytes.NewReader(buf.Bytes()))
+	gotKey, gotValue, err := decode(r)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if !bytes.Equal(gotKey, key) || !bytes.Equal(gotValue, value) {
+		t.Fatalf("unexpected record %q=%q", gotKey, gotValue)
+	}
+	if _, _, err := decode(r); err != io.EOF {
+		t.Fatalf("expected %v, but got %v", io.EOF, err)
+	}
+
+	// 截断的记录返回错误而不是 io.EOF
+	truncated := bytes.NewReader(buf.Bytes()[:buf.Len()-1])
+	if _, _, err := decode(truncated); err == nil || err == io.EOF {
+		t.Fatalf("expected a corruption error, but got %v", err)
+	}
+}
+
+func FuzzEncodeDecode(f *testing.F) {
+	f.Add([]byte("key"), []byte("value"))
+	f.Add([]byte{0}, []byte{0, 0, 0, 0, 0, 0, 0, 0})
+	f.Add([]byte("\x00\x00\x00\x00\x00\x00\x00\x10"), []byte("\xff\xff\xff\xff"))
+
+	f.Fuzz(func(t *testing.T, key, value []byte) {
+		var buf bytes.Buffer
+		if _, err := encode(key, value, &buf); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		gotKey, gotValue, err := decode(&buf)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if !bytes.Equal(gotKey, key) || !bytes.Equal(gotValue, value) {
+			t.Fatalf("expected %q=%q, but got %q=%q", key, value, gotKey, gotValue)
+		}
+		// 空值被解码为墓碑
+		if len(value) == 0 && gotValue != nil {
+			t.Fatalf("expected a tombstone, but got %q", gotValue)
+		}
+	})
+}
+
+func TestOrderedInt(t *testing.T) {
+	values := []int64{math.MinInt64, math.MinInt64 + 1, -1 << 32, -256, -1, 0, 1, 255, 256, 1 << 32, math.MaxInt64 - 1, math.MaxInt64}
+
+	// 编码后的键按字节序排列，结果与数值顺序一致
+	keys := make([][]byte, len(values))
+	for i := range values {
+		keys[len(values)-1-i] = EncodeOrderedInt(values[i])
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		return bytes.Compare(keys[i], keys[j]) < 0
+	})
+	for i, key := range keys {
+		got, err := DecodeOrderedInt(key)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if got != values[i] {
+			t.Fatalf("expected %d at position %d, but got %d", values[i], i, got)
+		}
+	}
+
+	if _, err := DecodeOrderedInt([]byte{1, 2, 3}); err == nil {
+		t.Fatalf("expected an error for a short key")
+	}
+}
+
+func TestOrderedIntScan(t *testing.T) {
+	tree, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer tree.Close()
+
+	for _, x := range []int64{5, -3, 0, -100, 42, -1} {
+		if err := tree.Put(EncodeOrderedInt(x), []byte(fmt.Sprint(x))); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+
+	// 范围扫描 [-3, 42) 按数值顺序返回
+	it, err := tree.Scan(EncodeOrderedInt(-3), EncodeOrderedInt(42))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer it.Close()
+	var got []int64
+	for it.Next() {
+		x, err := DecodeOrderedInt(it.Key())
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		got = append(got, x)
+	}
+	if err := it.Err(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if fmt.Sprint(got) != "[-3 -1 0 5]" {
+		t.Fatalf("expected [-3 -1 0 5], but got %v", got)
+	}
+}
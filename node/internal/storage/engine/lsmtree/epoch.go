@@ -0,0 +1,123 @@
+package lsmtree
+
+import (
+	"crypto/rand"
+	"fmt"
+	"os"
+	"path"
+	"strconv"
+)
+
+// 存放数据库纪元的文件名。
+const epochFileName = "epoch"
+
+// newEpoch 生成一个随机的 UUID（版本 4）作为纪元。
+func newEpoch() (string, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", fmt.Errorf("failed to generate epoch: %w", err)
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:]), nil
+}
+
+// writeEpoch 生成新的纪元并写入 dbDir。
+func writeEpoch(dbDir string) (string, error) {
+	epoch, err := newEpoch()
+	if err != nil {
+		return "", err
+	}
+	filePath := path.Join(dbDir, epochFileName)
+	if err := os.WriteFile(filePath, []byte(epoch), 0600); err != nil {
+		return "", fmt.Errorf("failed to write %s: %w", filePath, err)
+	}
+	return epoch, nil
+}
+
+// loadEpoch 读取 dbDir 中的纪元，不存在时（新建的数据库或从备份恢复）生成一个新的。
+// 只读方式打开时新的纪元不会写入磁盘。
+func loadEpoch(dbDir string, readOnly bool) (string, error) {
+	filePath := path.Join(dbDir, epochFileName)
+	data, err := os.ReadFile(filePath)
+	if err == nil {
+		return string(data), nil
+	}
+	if !os.IsNotExist(err) {
+		return "", fmt.Errorf("failed to read file %s: %w", filePath, err)
+	}
+	if readOnly {
+		return newEpoch()
+	}
+	return writeEpoch(dbDir)
+}
+
+// Epoch 返回数据库的纪元。纪元在数据库创建时随机生成，在 DropAll 或从备份恢复后改变，
+// 正常的重新打开不会改变它。外部缓存和客户端发现纪元变化时应丢弃缓存的数据。
+func (t *LSMTree) Epoch() string {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	return t.epoch
+}
+
+// DropAll 删除数据库中的所有数据并生成新的纪元。
+func (t *LSMTree) DropAll() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.readOnly {
+		return ErrReadOnly
+	}
+
+	// 先更换纪元：中途失败时客户端最多多丢弃一次缓存，而不会把旧数据当成有效的
+	epoch, err := writeEpoch(t.dbDir)
+	if err != nil {
+		return err
+	}
+	t.epoch = epoch
+	// 等待写入的 WAL 订阅发现纪元变化后返回 ErrWALDropped
+	t.notifyWALAppended()
+
+	defer t.sparseIndexes.clear()
+	oldest := t.maxDiskTableIndex - t.diskTableNum + 1
+	version, err := t.manifest.append(editDropAll)
+	if err != nil {
+		return fmt.Errorf("failed to drop disk tables: %w", err)
+	}
+	// 磁盘表等正在读取它们的迭代器关闭后删除，中途失败时剩余的磁盘表不在清单中，由 Open 删除
+	var retired []string
+	for index := t.maxDiskTableIndex; index >= oldest; index-- {
+		prefix := obsoletePrefix(version, index)
+		if err = renameDiskTable(t.dbDir, strconv.Itoa(index)+"-", prefix); err != nil {
+			err = fmt.Errorf("failed to delete disk table %d: %w", index, err)
+			break
+		}
+		retired = append(retired, prefix)
+	}
+	t.diskTableNum, t.maxDiskTableIndex = 0, -1
+	t.readers.retire(version, retired)
+	if err != nil {
+		return err
+	}
+
+	wal, err := clearWAL(t.walDir, t.wal)
+	if wal != nil {
+		t.wal = wal
+	}
+	if err != nil {
+		return fmt.Errorf("failed to clear the WAL file: %w", err)
+	}
+	// 归档的 WAL 属于旧的纪元，不能再用于恢复
+	if err := removeArchivedWALs(t.walDir); err != nil {
+		return err
+	}
+	t.walStart = t.walSeq
+	t.refreshMemTable()
+	t.immutableMemtables = nil
+	if err := t.clearPinned(); err != nil {
+		return err
+	}
+
+	return nil
+}
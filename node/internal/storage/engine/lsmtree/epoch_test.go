@@ -0,0 +1,96 @@
+package lsmtree
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"testing"
+)
+
+func TestEpoch(t *testing.T) {
+	dbDir, err := os.MkdirTemp(os.TempDir(), "example")
+	if err != nil {
+		panic(fmt.Errorf("failed to create %s: %w", dbDir, err))
+	}
+	defer func() {
+		if err := os.RemoveAll(dbDir); err != nil {
+			panic(fmt.Errorf("failed to remove %s: %w", dbDir, err))
+		}
+	}()
+
+	tree, err := Open(dbDir, MemTableThreshold(100))
+	if err != nil {
+		t.Fatalf("failed to open LSM tree %s: %s", dbDir, err)
+	}
+	epoch := tree.Epoch()
+	if len(epoch) != 36 || tree.Stats().Epoch != epoch {
+		t.Fatalf("unexpected epoch %q, stats epoch %q", epoch, tree.Stats().Epoch)
+	}
+	for i := 0; i < 100; i++ {
+		key := []byte(fmt.Sprintf("key-%d", i))
+		if err := tree.Put(key, key); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+	if err := tree.Close(); err != nil {
+		t.Fatalf("failed to close: %s", err)
+	}
+
+	// 正常重新打开，纪元不变
+	tree, err = Open(dbDir, MemTableThreshold(100))
+	if err != nil {
+		t.Fatalf("failed to reopen LSM tree %s: %s", dbDir, err)
+	}
+	if got := tree.Epoch(); got != epoch {
+		t.Fatalf("epoch changed across reopen: %s != %s", got, epoch)
+	}
+
+	// 从备份恢复的数据库有新的纪元
+	backupDir := path.Join(dbDir, "backup")
+	if err := tree.Backup(backupDir); err != nil {
+		t.Fatalf("failed to backup: %s", err)
+	}
+	backup, err := Open(backupDir)
+	if err != nil {
+		t.Fatalf("failed to open backup: %s", err)
+	}
+	if got := backup.Epoch(); got == epoch {
+		t.Fatalf("restored database kept epoch %s", got)
+	}
+	if err := backup.Close(); err != nil {
+		t.Fatalf("failed to close backup: %s", err)
+	}
+
+	if err := tree.DropAll(); err != nil {
+		t.Fatalf("failed to drop all: %s", err)
+	}
+	dropped := tree.Epoch()
+	if dropped == epoch {
+		t.Fatalf("epoch did not change on DropAll: %s", dropped)
+	}
+	if _, ok, err := tree.Get([]byte("key-1")); err != nil || ok {
+		t.Fatalf("expected key to be dropped, ok=%v err=%v", ok, err)
+	}
+	if err := tree.Put([]byte("key-1"), []byte("new")); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := tree.Close(); err != nil {
+		t.Fatalf("failed to close: %s", err)
+	}
+
+	tree, err = Open(dbDir, MemTableThreshold(100))
+	if err != nil {
+		t.Fatalf("failed to reopen LSM tree %s: %s", dbDir, err)
+	}
+	defer tree.Close()
+	if got := tree.Epoch(); got != dropped {
+		t.Fatalf("epoch changed across reopen: %s != %s", got, dropped)
+	}
+	value, ok, err := tree.Get([]byte("key-1"))
+	if err != nil || !ok || string(value) != "new" {
+		t.Fatalf("unexpected value %q ok=%v err=%v", value, ok, err)
+	}
+	if _, ok, _ := tree.Get([]byte("key-2")); ok {
+		t.Fatal("dropped key reappeared after reopen")
+	}
+}
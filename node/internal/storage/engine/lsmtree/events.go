@@ -0,0 +1,78 @@
+package lsmtree
+
+import "sync"
+
+// EventListener 接收刷新和合并完成的通知，可用于在刷新后触发备份、复制新的磁盘表等。
+// 回调在后台执行，不会阻塞写入路径；同一个监听器的回调按事件发生的顺序逐个执行，
+// 不同的监听器互不等待。回调中的 panic 会被恢复并记录。
+//
+// 磁盘表编号是事件发生时磁盘表的位置，而不是固定的标识：之后的合并会把更旧的磁盘表依次后移，
+// 回调执行时同一个编号可能已经指向另一个磁盘表，需要读取磁盘表的监听器应在回调中尽快完成，
+// 或者结合之后的 OnCompaction 事件换算编号。
+type EventListener interface {
+	// OnFlush 在内存表被刷新为编号为 tableIndex 的磁盘表后调用。
+	OnFlush(tableIndex int, info TableInfo)
+	// OnCompaction 在输入的磁盘表被合并为编号为 output 的磁盘表后调用。
+	OnCompaction(inputs []int, output int)
+}
+
+// Listeners 为 LSMTree 注册事件监听器。
+func Listeners(listeners ...EventListener) func(*LSMTree) {
+	return func(t *LSMTree) {
+		t.listeners = append(t.listeners, listeners...)
+		for _, l := range listeners {
+			t.eventQueues = append(t.eventQueues, &eventQueue{listener: l})
+		}
+	}
+}
+
+// eventQueue 保存一个监听器尚未执行的回调，同一时刻至多有一个 goroutine 按顺序执行它们。
+type eventQueue struct {
+	listener EventListener
+
+	mu      sync.Mutex
+	pending []func(EventListener)
+	// 是否有 goroutine 正在执行回调
+	running bool
+}
+
+// notify 把回调加入每个监听器的队列，在后台按加入的顺序执行。
+// 调用方持有写锁，因此队列中的顺序就是事件发生的顺序。
+func (t *LSMTree) notify(fn func(EventListener)) {
+	for _, q := range t.eventQueues {
+		q.mu.Lock()
+		q.pending = append(q.pending, fn)
+		if !q.running {
+			q.running = true
+			go t.drainEvents(q)
+		}
+		q.mu.Unlock()
+	}
+}
+
+// drainEvents 依次执行队列中的回调，队列为空时退出。
+func (t *LSMTree) drainEvents(q *eventQueue) {
+	for {
+		q.mu.Lock()
+		if len(q.pending) == 0 {
+			q.running = false
+			q.mu.Unlock()
+			return
+		}
+		fn := q.pending[0]
+		q.pending = q.pending[1:]
+		q.mu.Unlock()
+
+		t.runEvent(q.listener, fn)
+	}
+}
+
+// runEvent 执行一个回调，恢复并记录其中的 panic。
+func (t *LSMTree) runEvent(l EventListener, fn func(EventListener)) {
+	defer func() {
+		if r := recover(); r != nil {
+			t.logger.Error("event listener panicked: %v", r)
+		}
+	}()
+	fn(l)
+}
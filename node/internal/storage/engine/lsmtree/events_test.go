@@ -0,0 +1,155 @@
+package lsmtree
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+)
+
+type compactionEvent struct {
+	inputs []int
+	output int
+}
+
+type testListener struct {
+	flushes     chan TableInfo
+	compactions chan compactionEvent
+}
+
+func (l *testListener) OnFlush(tableIndex int, info TableInfo) {
+	if tableIndex != info.Index {
+		panic(fmt.Errorf("table index %d does not match info %d", tableIndex, info.Index))
+	}
+	l.flushes <- info
+}
+
+func (l *testListener) OnCompaction(inputs []int, output int) {
+	l.compactions <- compactionEvent{inputs, output}
+}
+
+type panicListener struct{}
+
+func (panicListener) OnFlush(int, TableInfo) { panic("bad listener") }
+
+func (panicListener) OnCompaction([]int, int) { panic("bad listener") }
+
+func TestEventListener(t *testing.T) {
+	dbDir, err := os.MkdirTemp(os.TempDir(), "example")
+	if err != nil {
+		panic(fmt.Errorf("failed to create %s: %w", dbDir, err))
+	}
+	defer func() {
+		if err := os.RemoveAll(dbDir); err != nil {
+			panic(fmt.Errorf("failed to remove %s: %w", dbDir, err))
+		}
+	}()
+
+	listener := &testListener{
+		flushes:     make(chan TableInfo, 1000),
+		compactions: make(chan compactionEvent, 1000),
+	}
+	tree, err := Open(
+		dbDir,
+		MemTableThreshold(100),
+		DiskTableNumThreshold(2),
+		Listeners(panicListener{}, listener),
+	)
+	if err != nil {
+		panic(fmt.Errorf("failed to open LSM tree %s: %w", dbDir, err))
+	}
+
+	for i := 1; i <= 1000; i++ {
+		key := strconv.Itoa(i)
+		if err := tree.Put([]byte(key), []byte(key)); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+
+	// 回调在后台执行，panicListener 不影响 listener 收到事件
+	timeout := time.After(time.Second)
+	for flushed := false; !flushed; {
+		select {
+		case info := <-listener.flushes:
+			if info.KeyNum == 0 || info.DataSize == 0 {
+				t.Fatalf("expected non-empty table info, but got %+v", info)
+			}
+			flushed = info.Index == 0
+		case <-timeout:
+			t.Fatalf("OnFlush was not called for table 0")
+		}
+	}
+
+	for compacted := false; !compacted; {
+		select {
+		case event := <-listener.compactions:
+			if len(event.inputs) != 2 || event.inputs[1] != event.output || event.inputs[0] >= event.inputs[1] {
+				t.Fatalf("unexpected compaction event %+v", event)
+			}
+			compacted = event.inputs[0] == 0 && event.output == 1
+		case <-timeout:
+			t.Fatalf("OnCompaction was not called for tables 0 and 1")
+		}
+	}
+
+	if err := tree.Close(); err != nil {
+		panic(fmt.Errorf("failed to close: %w", err))
+	}
+}
+
+// orderListener 记录收到的刷新事件的磁盘表编号，第一个回调等待一段时间，使后来的事件有机会抢先执行。
+type orderListener struct {
+	mu      sync.Mutex
+	flushed []int
+}
+
+func (l *orderListener) OnFlush(tableIndex int, _ TableInfo) {
+	if tableIndex == 0 {
+		time.Sleep(50 * time.Millisecond)
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.flushed = append(l.flushed, tableIndex)
+}
+
+func (l *orderListener) OnCompaction([]int, int) {}
+
+func TestEventListenerOrder(t *testing.T) {
+	listener := &orderListener{}
+	tree, err := Open(t.TempDir(), DiskTableNumThreshold(1000), Listeners(listener))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer tree.Close()
+
+	const flushes = 50
+	for i := 0; i < flushes; i++ {
+		if err := tree.Put([]byte(strconv.Itoa(i)), []byte("value")); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if err := tree.Flush(); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		listener.mu.Lock()
+		flushed := append([]int(nil), listener.flushed...)
+		listener.mu.Unlock()
+		if len(flushed) == flushes {
+			for i, index := range flushed {
+				if index != i {
+					t.Fatalf("expected flush events in order, but got %v", flushed)
+				}
+			}
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected %d flush events, but got %d", flushes, len(flushed))
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
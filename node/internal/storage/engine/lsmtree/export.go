@@ -0,0 +1,275 @@
+package lsmtree
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+)
+
+// 导出文件是与内部三文件布局无关的单文件格式，供其他工具读取或在数据库之间迁移数据。
+// 所有整数均为大端序，文件布局如下：
+//
+//	[文件头] [数据块 1] ... [数据块 n] [索引] [文件尾]
+//
+//	文件头（12 字节）：magic "HHLSMEXP"（8 字节）、格式版本 uint32（当前为 1）。
+//	数据块：条目数量 uint32，之后依次是条目，最后是对条目部分计算的 CRC32（IEEE）uint32。
+//	        每个条目为 [键长度 uint32][键][值长度 uint32][值]，键在整个文件中严格升序且不重复，
+//	        只包含存活的键（不包含墓碑）。数据块按大约 exportBlockSize 字节切分。
+//	索引：每个数据块一项，[第一个键的长度 uint32][第一个键][块偏移 uint64][块长度 uint32]，
+//	      偏移和长度覆盖整个数据块（包括条目数量和 CRC32）。
+//	文件尾（32 字节）：索引偏移 uint64、索引长度 uint32、数据块数量 uint32、条目总数 uint64、
+//	                   magic "HHLSMEXP"（8 字节）。
+//
+// 读取方可以从文件尾开始定位索引，按第一个键二分查找数据块，也可以从文件头顺序读取全部数据块。
+const (
+	exportMagic   = "HHLSMEXP"
+	exportVersion = 1
+	// 数据块的目标大小，超过后开始新的数据块
+	exportBlockSize = 4096
+	exportFooterLen = 8 + 4 + 4 + 8 + 8
+)
+
+// ErrInvalidExport 当导出文件的格式不正确或校验失败时返回。
+var ErrInvalidExport = errors.New("invalid export file")
+
+// Export 把数据库当前所有存活的键值对按键升序写入 filePath，格式见上文。
+func (t *LSMTree) Export(filePath string) error {
+	it, err := t.Scan(nil, nil)
+	if err != nil {
+		return err
+	}
+	defer it.Close()
+
+	f, err := os.OpenFile(filePath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to create %s: %w", filePath, err)
+	}
+	defer f.Close()
+
+	w := &exportWriter{w: bufio.NewWriter(f)}
+	w.header()
+	for it.Next() {
+		w.add(it.Key(), it.Value())
+	}
+	if err := it.Err(); err != nil {
+		return fmt.Errorf("failed to scan: %w", err)
+	}
+	w.finish()
+	if w.err != nil {
+		return fmt.Errorf("failed to write %s: %w", filePath, w.err)
+	}
+	if err := f.Sync(); err != nil {
+		return fmt.Errorf("failed to sync %s: %w", filePath, err)
+	}
+
+	return f.Close()
+}
+
+// Import 读取 Export 生成的文件并把其中的键值对写入数据库，已存在的键被覆盖。
+func (t *LSMTree) Import(filePath string) error {
+	return ReadExport(filePath, t.Put)
+}
+
+// exportWriter 按导出格式写入数据，第一次出错后忽略后续写入，错误保存在 err 中。
+type exportWriter struct {
+	w   *bufio.Writer
+	pos int64
+	err error
+
+	block      bytes.Buffer
+	blockCount uint32
+	blockFirst []byte
+
+	index      bytes.Buffer
+	indexCount uint32
+	entryCount uint64
+}
+
+func (w *exportWriter) write(p []byte) {
+	if w.err != nil {
+		return
+	}
+	n, err := w.w.Write(p)
+	w.pos += int64(n)
+	w.err = err
+}
+
+func (w *exportWriter) header() {
+	var version [4]byte
+	binary.BigEndian.PutUint32(version[:], exportVersion)
+	w.write([]byte(exportMagic))
+	w.write(version[:])
+}
+
+// add 把一个条目加入当前数据块，数据块达到目标大小时写出。
+func (w *exportWriter) add(key, value []byte) {
+	if w.blockCount == 0 {
+		w.blockFirst = append(w.blockFirst[:0], key...)
+	}
+	writeUint32(&w.block, uint32(len(key)))
+	w.block.Write(key)
+	writeUint32(&w.block, uint32(len(value)))
+	w.block.Write(value)
+	w.blockCount++
+	w.entryCount++
+
+	if w.block.Len() >= exportBlockSize {
+		w.flushBlock()
+	}
+}
+
+// flushBlock 写出当前数据块并记录它的索引项。
+func (w *exportWriter) flushBlock() {
+	if w.blockCount == 0 {
+		return
+	}
+	offset := w.pos
+
+	var count, checksum [4]byte
+	binary.BigEndian.PutUint32(count[:], w.blockCount)
+	binary.BigEndian.PutUint32(checksum[:], crc32.ChecksumIEEE(w.block.Bytes()))
+	w.write(count[:])
+	w.write(w.block.Bytes())
+	w.write(checksum[:])
+
+	writeUint32(&w.index, uint32(len(w.blockFirst)))
+	w.index.Write(w.blockFirst)
+	var position [12]byte
+	binary.BigEndian.PutUint64(position[:8], uint64(offset))
+	binary.BigEndian.PutUint32(position[8:], uint32(w.pos-offset))
+	w.index.Write(position[:])
+	w.indexCount++
+
+	w.block.Reset()
+	w.blockCount = 0
+}
+
+// finish 写出最后一个数据块、索引和文件尾。
+func (w *exportWriter) finish() {
+	w.flushBlock()
+
+	indexOffset := w.pos
+	w.write(w.index.Bytes())
+
+	var footer [exportFooterLen]byte
+	binary.BigEndian.PutUint64(footer[0:8], uint64(indexOffset))
+	binary.BigEndian.PutUint32(footer[8:12], uint32(w.index.Len()))
+	binary.BigEndian.PutUint32(footer[12:16], w.indexCount)
+	binary.BigEndian.PutUint64(footer[16:24], w.entryCount)
+	copy(footer[24:], exportMagic)
+	w.write(footer[:])
+
+	if w.err == nil {
+		w.err = w.w.Flush()
+	}
+}
+
+func writeUint32(buf *bytes.Buffer, n uint32) {
+	var b [4]byte
+	binary.BigEndian.PutUint32(b[:], n)
+	buf.Write(b[:])
+}
+
+// ReadExport 按导出格式读取 filePath，依次对每个键值对调用 fn，fn 返回错误时停止并返回该错误。
+// 文件头、文件尾、索引和每个数据块的校验和都会被检查，格式不正确时返回 ErrInvalidExport。
+func ReadExport(filePath string, fn func(key, value []byte) error) error {
+	data, err := os.ReadFile(filePath)
+	if err != nil {
+		return fmt.Errorf("failed to read %s: %w", filePath, err)
+	}
+
+	if len(data) < 12+exportFooterLen || string(data[:8]) != exportMagic || string(data[len(data)-8:]) != exportMagic {
+		return fmt.Errorf("%s: missing magic: %w", filePath, ErrInvalidExport)
+	}
+	if version := binary.BigEndian.Uint32(data[8:12]); version != exportVersion {
+		return fmt.Errorf("%s: unsupported version %d: %w", filePath, version, ErrInvalidExport)
+	}
+
+	footer := data[len(data)-exportFooterLen:]
+	indexOffset := binary.BigEndian.Uint64(footer[0:8])
+	indexLen := uint64(binary.BigEndian.Uint32(footer[8:12]))
+	blockNum := binary.BigEndian.Uint32(footer[12:16])
+	entryNum := binary.BigEndian.Uint64(footer[16:24])
+	if indexOffset < 12 || indexOffset+indexLen != uint64(len(data)-exportFooterLen) {
+		return fmt.Errorf("%s: invalid index position: %w", filePath, ErrInvalidExport)
+	}
+
+	index := bytes.NewReader(data[indexOffset : indexOffset+indexLen])
+	var entries uint64
+	for i := uint32(0); i < blockNum; i++ {
+		if _, err := readExportField(index); err != nil {
+			return fmt.Errorf("%s: invalid index entry %d: %w", filePath, i, ErrInvalidExport)
+		}
+		var position [12]byte
+		if _, err := io.ReadFull(index, position[:]); err != nil {
+			return fmt.Errorf("%s: invalid index entry %d: %w", filePath, i, ErrInvalidExport)
+		}
+		offset := binary.BigEndian.Uint64(position[:8])
+		length := uint64(binary.BigEndian.Uint32(position[8:]))
+		if offset < 12 || length < 8 || offset+length > indexOffset {
+			return fmt.Errorf("%s: block %d out of range: %w", filePath, i, ErrInvalidExport)
+		}
+
+		n, err := readExportBlock(data[offset:offset+length], fn)
+		if err != nil {
+			if errors.Is(err, ErrInvalidExport) {
+				return fmt.Errorf("%s: block %d: %w", filePath, i, err)
+			}
+			return err
+		}
+		entries += n
+	}
+	if entries != entryNum {
+		return fmt.Errorf("%s: expected %d entries, but read %d: %w", filePath, entryNum, entries, ErrInvalidExport)
+	}
+
+	return nil
+}
+
+// readExportBlock 校验并读取一个数据块，返回其中的条目数量。
+func readExportBlock(block []byte, fn func(key, value []byte) error) (uint64, error) {
+	count := binary.BigEndian.Uint32(block[:4])
+	entries := block[4 : len(block)-4]
+	if crc32.ChecksumIEEE(entries) != binary.BigEndian.Uint32(block[len(block)-4:]) {
+		return 0, fmt.Errorf("checksum mismatch: %w", ErrInvalidExport)
+	}
+
+	r := bytes.NewReader(entries)
+	for i := uint32(0); i < count; i++ {
+		key, err := readExportField(r)
+		if err != nil {
+			return 0, fmt.Errorf("invalid key: %w", ErrInvalidExport)
+		}
+		value, err := readExportField(r)
+		if err != nil {
+			return 0, fmt.Errorf("invalid value: %w", ErrInvalidExport)
+		}
+		if err := fn(key, value); err != nil {
+			return 0, err
+		}
+	}
+
+	return uint64(count), nil
+}
+
+// readExportField 读取一个长度前缀的字段。
+func readExportField(r *bytes.Reader) ([]byte, error) {
+	var length [4]byte
+	if _, err := io.ReadFull(r, length[:]); err != nil {
+		return nil, err
+	}
+	n := binary.BigEndian.Uint32(length[:])
+	if int64(n) > int64(r.Len()) {
+		return nil, io.ErrUnexpectedEOF
+	}
+	field := make([]byte, n)
+	if _, err := io.ReadFull(r, field); err != nil {
+		return nil, err
+	}
+	return field, nil
+}
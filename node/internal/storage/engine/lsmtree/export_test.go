@@ -0,0 +1,93 @@
+package lsmtree
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"testing"
+)
+
+func TestExportImport(t *testing.T) {
+	dbDir := t.TempDir()
+	tree, err := Open(dbDir, MemTableMaxEntries(100), ImmutableMemtableMaxNum(1), DiskTableNumThreshold(100))
+	if err != nil {
+		t.Fatalf("failed to open LSM tree %s: %s", dbDir, err)
+	}
+	defer tree.Close()
+
+	// 数据跨越多个磁盘表和内存表，包含覆盖、删除和二进制键值
+	expected := make(map[string]string)
+	for i := 0; i < 1000; i++ {
+		key := fmt.Sprintf("key%04d", i)
+		value := fmt.Sprintf("value%d\x00\xff", i)
+		if err := tree.Put([]byte(key), []byte(value)); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		expected[key] = value
+	}
+	for i := 0; i < 1000; i += 7 {
+		key := fmt.Sprintf("key%04d", i)
+		if err := tree.Delete([]byte(key)); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		delete(expected, key)
+	}
+
+	exportPath := path.Join(t.TempDir(), "export.hhsst")
+	if err := tree.Export(exportPath); err != nil {
+		t.Fatalf("failed to export: %s", err)
+	}
+
+	// 读取方看到的键严格升序
+	var last string
+	count := 0
+	err = ReadExport(exportPath, func(key, value []byte) error {
+		if string(key) <= last {
+			return fmt.Errorf("keys out of order: %q after %q", key, last)
+		}
+		last = string(key)
+		count++
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("failed to read export: %s", err)
+	}
+	if count != len(expected) {
+		t.Fatalf("expected %d entries, but got %d", len(expected), count)
+	}
+
+	other, err := Open(path.Join(t.TempDir(), "import"))
+	if err != nil {
+		t.Fatalf("failed to open LSM tree: %s", err)
+	}
+	defer other.Close()
+	if err := other.Import(exportPath); err != nil {
+		t.Fatalf("failed to import: %s", err)
+	}
+	for i := 0; i < 1000; i++ {
+		key := fmt.Sprintf("key%04d", i)
+		value, ok, err := other.Get([]byte(key))
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		want, exists := expected[key]
+		if ok != exists || string(value) != want {
+			t.Fatalf("value is wrong for key %s: %q (%v) != %q (%v)", key, value, ok, want, exists)
+		}
+	}
+
+	// 损坏的数据块被发现
+	data, err := os.ReadFile(exportPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data[20] ^= 0xff
+	if err := os.WriteFile(exportPath, data, 0600); err != nil {
+		t.Fatal(err)
+	}
+	err = ReadExport(exportPath, func(key, value []byte) error { return nil })
+	if !errors.Is(err, ErrInvalidExport) {
+		t.Fatalf("expected %v, but got %v", ErrInvalidExport, err)
+	}
+}
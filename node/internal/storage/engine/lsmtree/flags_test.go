@@ -0,0 +1,114 @@
+package lsmtree
+
+import (
+	"context"
+	"io"
+	"testing"
+)
+
+func expectFlags(t *testing.T, tree *LSMTree, key string, value string, flags uint32) {
+	t.Helper()
+	gotValue, gotFlags, exists, err := tree.GetWithFlags([]byte(key))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !exists || string(gotValue) != value || gotFlags != flags {
+		t.Fatalf("expected %q=%q with flags %x, but got %q with flags %x (exists %t)", key, value, flags, gotValue, gotFlags, exists)
+	}
+}
+
+func TestPutWithFlags(t *testing.T) {
+	for _, format := range []RecordFormat{FixedRecords, VarintRecords} {
+		t.Run(format.String(), func(t *testing.T) {
+			dbDir := t.TempDir()
+			tree, err := Open(dbDir, DiskTableNumThreshold(100), RecordEncoding(format))
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if err := tree.Put([]byte("plain"), []byte("v0")); err != nil {
+				t.Fatal(err)
+			}
+			if err := tree.PutWithFlags([]byte("a"), []byte("v1"), 7); err != nil {
+				t.Fatal(err)
+			}
+			if err := tree.PutWithFlags([]byte("b"), []byte("v2"), 0xffffffff); err != nil {
+				t.Fatal(err)
+			}
+			expectFlags(t, tree, "plain", "v0", 0)
+			expectFlags(t, tree, "a", "v1", 7)
+
+			// 追加保留标志，没有标志的写入把标志重置为 0
+			if _, err := tree.Append([]byte("a"), []byte("+")); err != nil {
+				t.Fatal(err)
+			}
+			if err := tree.Put([]byte("b"), []byte("v3")); err != nil {
+				t.Fatal(err)
+			}
+			expectFlags(t, tree, "a", "v1+", 7)
+			expectFlags(t, tree, "b", "v3", 0)
+
+			// 第一个磁盘表
+			if err := tree.Flush(); err != nil {
+				t.Fatal(err)
+			}
+			expectFlags(t, tree, "a", "v1+", 7)
+			expectFlags(t, tree, "plain", "v0", 0)
+
+			// 未刷新的写入从 WAL 恢复
+			if err := tree.PutWithFlags([]byte("c"), []byte("v4"), 42); err != nil {
+				t.Fatal(err)
+			}
+			if err := tree.PutWithFlags([]byte("b"), []byte("v5"), 9); err != nil {
+				t.Fatal(err)
+			}
+			if err := tree.Close(); err != nil {
+				t.Fatal(err)
+			}
+			tree, err = Open(dbDir, DiskTableNumThreshold(100), RecordEncoding(format))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer tree.Close()
+			expectFlags(t, tree, "c", "v4", 42)
+			expectFlags(t, tree, "b", "v5", 9)
+
+			// 合并两个磁盘表后保留最新记录的标志
+			if err := tree.Flush(); err != nil {
+				t.Fatal(err)
+			}
+			tree.mu.Lock()
+			err = tree.compactPair(context.Background(), 0, 1)
+			tree.mu.Unlock()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tables := tree.tableInfos(); len(tables) != 1 {
+				t.Fatalf("expected 1 disk table after the compaction, but got %d", len(tables))
+			}
+			expectFlags(t, tree, "plain", "v0", 0)
+			expectFlags(t, tree, "a", "v1+", 7)
+			expectFlags(t, tree, "b", "v5", 9)
+			expectFlags(t, tree, "c", "v4", 42)
+
+			// 流式读取跳过键和值之间的标志
+			r, exists, err := tree.OpenValue([]byte("c"))
+			if err != nil || !exists {
+				t.Fatalf("expected to open the value, but got %t, %v", exists, err)
+			}
+			defer r.Close()
+			value, err := io.ReadAll(r)
+			if err != nil || string(value) != "v4" {
+				t.Fatalf("expected %q, but got %q, %v", "v4", value, err)
+			}
+
+			// 删除后再写入的键没有标志
+			if err := tree.Delete([]byte("a")); err != nil {
+				t.Fatal(err)
+			}
+			if _, _, exists, _ := tree.GetWithFlags([]byte("a")); exists {
+				t.Fatal("expected the deleted key to be missing")
+			}
+		})
+	}
+}
@@ -0,0 +1,115 @@
+package lsmtree
+
+import (
+	"fmt"
+	"sync"
+)
+
+// GroupCommit 开启组提交（默认关闭）：同时进行的 Put、PutWithFlags 和 Delete 排入提交队列，
+// 由队首的写入作为领导者在一次写锁中依次执行队列中的所有写入，最后只同步一次 WAL，再唤醒其余的写入。
+// 每个写入仍然在 WAL 同步之后才返回，持久性与逐个同步相同；写锁在同步期间一直持有，读取不会看到还没有同步的写入。
+// 并发写入很多时大幅减少同步次数，单个写入时与关闭时相同。Append、GetOrSet、PutBatch 等写入不经过队列，仍然各自同步。
+func GroupCommit(enabled bool) func(*LSMTree) {
+	return func(t *LSMTree) {
+		t.groupCommit = enabled
+	}
+}
+
+// commitRequest 是提交队列中的一个写入。
+type commitRequest struct {
+	key    []byte
+	value  []byte
+	flags  uint32
+	delete bool
+
+	err error
+	// 写入完成或成为领导者时关闭，finished 区分这两种情况
+	wake     chan struct{}
+	finished bool
+}
+
+// commitQueue 是等待组提交的写入队列。
+type commitQueue struct {
+	mu      sync.Mutex
+	pending []*commitRequest
+	// 是否有领导者正在提交，领导者提交完一组后把领导权交给队首的写入
+	leading bool
+}
+
+// commit 把写入排入提交队列并等待它完成。没有领导者时成为领导者，提交队列中所有的写入。
+func (t *LSMTree) commit(req *commitRequest) error {
+	req.wake = make(chan struct{})
+	q := &t.commits
+
+	q.mu.Lock()
+	q.pending = append(q.pending, req)
+	if q.leading {
+		q.mu.Unlock()
+		<-req.wake
+		if req.finished {
+			return req.err
+		}
+		// 前一个领导者把领导权交给了这个写入，它仍然在队列中
+	} else {
+		q.leading = true
+		q.mu.Unlock()
+	}
+
+	q.mu.Lock()
+	group := q.pending
+	q.pending = nil
+	q.mu.Unlock()
+
+	t.commitGroup(group)
+	for _, r := range group {
+		r.finished = true
+		if r != req {
+			close(r.wake)
+		}
+	}
+
+	// 提交期间排入的写入由队首的写入继续提交，这个写入不必等待它们
+	q.mu.Lock()
+	if len(q.pending) > 0 {
+		close(q.pending[0].wake)
+	} else {
+		q.leading = false
+	}
+	q.mu.Unlock()
+
+	return req.err
+}
+
+// commitGroup 在一次写锁中按顺序执行 group 中的写入，最后同步一次 WAL。
+// 同步失败时所有成功写入内存表的写入都返回错误，与逐个同步时写入 WAL 失败一样，它们可能在重新打开后存在也可能不存在。
+func (t *LSMTree) commitGroup(group []*commitRequest) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.committingGroup = true
+	for _, r := range group {
+		if r.delete {
+			r.err = t.delete(r.key)
+		} else {
+			r.err = t.put(r.key, r.value, r.flags)
+		}
+	}
+	t.committingGroup = false
+
+	if !t.walUnsynced {
+		return
+	}
+	t.walUnsynced = false
+	err := t.wal.Sync()
+	if err != nil {
+		err = fmt.Errorf("failed to sync file %s: %w", t.wal.Name(), err)
+		for _, r := range group {
+			if r.err == nil {
+				r.err = err
+			}
+		}
+		return
+	}
+	t.stats.WALSyncs++
+	t.notifyWALAppended()
+}
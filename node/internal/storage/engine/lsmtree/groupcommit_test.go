@@ -0,0 +1,117 @@
+package lsmtree
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestGroupCommit(t *testing.T) {
+	dbDir := t.TempDir()
+	tree, err := Open(dbDir, GroupCommit(true), MemTableThreshold(64<<10))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const writers, writes = 64, 50
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < writes; i++ {
+				key := []byte(fmt.Sprintf("w%02d-%03d", w, i))
+				if err := tree.Put(key, []byte(fmt.Sprintf("v%d", i))); err != nil {
+					t.Error(err)
+					return
+				}
+				// 每个写入者删除自己写入的偶数键，同一个键的写入按顺序提交
+				if i%2 == 0 {
+					if err := tree.Delete(key); err != nil {
+						t.Error(err)
+						return
+					}
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	stats := tree.Stats()
+	total := int64(writers * (writes + writes/2))
+	if stats.WALSyncs == 0 || stats.WALSyncs >= total {
+		t.Fatalf("expected concurrent writes to share syncs, but got %d syncs for %d writes", stats.WALSyncs, total)
+	}
+	t.Logf("%d syncs for %d writes", stats.WALSyncs, total)
+
+	// 无效的写入只让自己失败
+	if err := tree.Put(nil, []byte("v")); err != ErrKeyRequired {
+		t.Fatalf("expected ErrKeyRequired, but got %v", err)
+	}
+	if err := tree.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	tree, err = Open(dbDir, GroupCommit(true))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tree.Close()
+	for w := 0; w < writers; w++ {
+		for i := 0; i < writes; i++ {
+			key := fmt.Sprintf("w%02d-%03d", w, i)
+			value, exists, err := tree.Get([]byte(key))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if exists != (i%2 == 1) || exists && string(value) != fmt.Sprintf("v%d", i) {
+				t.Fatalf("unexpected %s=%q (%t) after reopening", key, value, exists)
+			}
+		}
+	}
+}
+
+// BenchmarkConcurrentPut 比较 64 个并发写入者逐个同步 WAL 和组提交的吞吐量。
+func BenchmarkConcurrentPut(b *testing.B) {
+	const writers = 64
+	for _, bench := range []struct {
+		name        string
+		groupCommit bool
+	}{
+		{"fsync-per-write", false},
+		{"group-commit", true},
+	} {
+		b.Run(bench.name, func(b *testing.B) {
+			tree, err := Open(b.TempDir(), GroupCommit(bench.groupCommit), MemTableThreshold(64<<20))
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer tree.Close()
+
+			value := make([]byte, 100)
+			var next atomic.Int64
+			var wg sync.WaitGroup
+			b.ResetTimer()
+			for w := 0; w < writers; w++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					for {
+						i := next.Add(1)
+						if i > int64(b.N) {
+							return
+						}
+						if err := tree.Put([]byte(fmt.Sprintf("key%09d", i)), value); err != nil {
+							b.Error(err)
+							return
+						}
+					}
+				}()
+			}
+			wg.Wait()
+			b.StopTimer()
+			b.ReportMetric(float64(tree.Stats().WALSyncs)/float64(b.N), "syncs/op")
+		})
+	}
+}
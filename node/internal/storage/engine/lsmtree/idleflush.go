@@ -0,0 +1,59 @@
+package lsmtree
+
+import (
+	"sync/atomic"
+	"time"
+)
+
+// FlushOnIdle 开启空闲刷新（默认关闭）：没有写入的时间达到 idle 后，把内存表刷新为磁盘表。
+// 写入集中在一段时间、之后长时间空闲的负载中，数据可能一直留在内存表和 WAL 里，
+// 崩溃后要重放的 WAL 更长；空闲时刷新让恢复保持很快，WAL 也保持很小。每次写入都重新计时。
+func FlushOnIdle(idle time.Duration) func(*LSMTree) {
+	return func(t *LSMTree) {
+		t.flushIdle = idle
+	}
+}
+
+// startIdleFlush 启动空闲刷新的后台任务，Close 时退出。
+func (t *LSMTree) startIdleFlush() {
+	atomic.StoreInt64(&t.lastWrite, time.Now().UnixNano())
+
+	t.background.Add(1)
+	go func() {
+		defer t.background.Done()
+
+		timer := time.NewTimer(t.flushIdle)
+		defer timer.Stop()
+		for {
+			select {
+			case <-t.ctx.Done():
+				return
+			case <-timer.C:
+			}
+			// 期间有新的写入时，等到距离最近一次写入满 flushIdle 再检查
+			if since := t.sinceLastWrite(); since < t.flushIdle {
+				timer.Reset(t.flushIdle - since)
+				continue
+			}
+			if err := t.flushIfIdle(); err != nil {
+				t.logger.Error("failed to flush idle memtable: %v", err)
+			}
+			timer.Reset(t.flushIdle)
+		}
+	}()
+}
+
+// flushIfIdle 在没有写入的时间仍然达到 flushIdle 时刷新所有内存表。
+func (t *LSMTree) flushIfIdle() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	// Close 先取消 ctx 再获取写锁，之后不再刷新
+	if t.ctx.Err() != nil || !t.idleFor(t.flushIdle) {
+		return nil
+	}
+	if t.memTable.size() == 0 && len(t.immutableMemtables) == 0 {
+		return nil
+	}
+	return t.flushAll()
+}
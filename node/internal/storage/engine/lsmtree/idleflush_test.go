@@ -0,0 +1,61 @@
+package lsmtree
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestFlushOnIdle(t *testing.T) {
+	listener := &testListener{
+		flushes:     make(chan TableInfo, 100),
+		compactions: make(chan compactionEvent, 100),
+	}
+	idle := 100 * time.Millisecond
+	tree, err := Open(t.TempDir(), FlushOnIdle(idle), Listeners(listener))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tree.Close()
+
+	// 写入间隔短于空闲时间时不刷新
+	deadline := time.Now().Add(3 * idle)
+	for i := 0; time.Now().Before(deadline); i++ {
+		if err := tree.Put([]byte(fmt.Sprintf("key%03d", i)), []byte("value")); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(idle / 10)
+	}
+	select {
+	case info := <-listener.flushes:
+		t.Fatalf("expected no flush while writing, but got %v", info)
+	default:
+	}
+
+	// 空闲超过间隔后刷新，数据仍然可以读到
+	select {
+	case <-listener.flushes:
+	case <-time.After(5 * time.Second):
+		t.Fatal("expected a flush after the idle interval")
+	}
+	tree.mu.RLock()
+	size := tree.memTable.size()
+	tree.mu.RUnlock()
+	if size != 0 {
+		t.Fatalf("expected an empty memtable after the idle flush, but got %d entries", size)
+	}
+	if value, ok, err := tree.Get([]byte("key000")); err != nil || !ok || string(value) != "value" {
+		t.Fatalf("expected value, but got %s %v (%v)", value, ok, err)
+	}
+
+	// 内存表为空时不会反复刷新出空的磁盘表
+	select {
+	case info := <-listener.flushes:
+		t.Fatalf("expected no flush without new writes, but got %v", info)
+	case <-time.After(3 * idle):
+	}
+
+	if _, err := Open(t.TempDir(), FlushOnIdle(-time.Second)); err == nil {
+		t.Fatal("expected an error for a negative idle flush interval")
+	}
+}
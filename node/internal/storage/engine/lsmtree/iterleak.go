@@ -0,0 +1,7 @@
+//go:build !lsmdebug
+
+package lsmtree
+
+// trackIterator 在调试构建（-tags lsmdebug）中检测没有关闭的迭代器，默认构建中什么也不做，
+// 没有关闭的迭代器打开的文件要等到垃圾回收时才由 os.File 释放。
+func trackIterator(it *Iterator, logger Logger) {}
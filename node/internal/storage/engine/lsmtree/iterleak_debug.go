@@ -0,0 +1,21 @@
+//go:build lsmdebug
+
+package lsmtree
+
+import (
+	"runtime"
+	"runtime/debug"
+)
+
+// trackIterator 记录迭代器创建时的调用栈。迭代器没有调用 Close 就被垃圾回收时，
+// 输出带调用栈的警告并关闭它打开的文件。
+func trackIterator(it *Iterator, logger Logger) {
+	stack := debug.Stack()
+	runtime.SetFinalizer(it, func(it *Iterator) {
+		if it.sources == nil {
+			return
+		}
+		logger.Warn("iterator was not closed, created at:\n%s", stack)
+		_ = it.Close()
+	})
+}
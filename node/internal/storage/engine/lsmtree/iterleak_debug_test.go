@@ -0,0 +1,55 @@
+//go:build lsmdebug
+
+package lsmtree
+
+import (
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestIteratorLeakWarning(t *testing.T) {
+	logger := &testLogger{}
+	tree, err := Open(t.TempDir(), WithLogger(logger))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tree.Close()
+
+	if err := tree.Put([]byte("key"), []byte("value")); err != nil {
+		t.Fatal(err)
+	}
+
+	// 关闭的迭代器不会产生警告
+	it, err := tree.Scan(nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	it.Close()
+	for i := 0; i < 5; i++ {
+		runtime.GC()
+	}
+	time.Sleep(10 * time.Millisecond)
+	if line, ok := logger.find("WARN"); ok {
+		t.Fatalf("expected no warning for a closed iterator, but got %q", line)
+	}
+
+	if _, err := tree.Scan(nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		runtime.GC()
+		if line, ok := logger.find("WARN iterator was not closed"); ok {
+			if !strings.Contains(line, "TestIteratorLeakWarning") {
+				t.Fatalf("expected the warning to include where the iterator was created, but got %q", line)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("expected a warning for the abandoned iterator")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -0,0 +1,84 @@
+package lsmtree
+
+import (
+	"fmt"
+	"os"
+	"runtime"
+	"testing"
+	"time"
+)
+
+// openFiles 返回进程打开的文件数，不支持时跳过测试
+func openFiles(t *testing.T) int {
+	entries, err := os.ReadDir("/proc/self/fd")
+	if err != nil {
+		t.Skipf("cannot count open files: %s", err)
+	}
+	return len(entries)
+}
+
+func TestIteratorReleasesFiles(t *testing.T) {
+	tree, err := Open(t.TempDir(), MemTableThreshold(256), DiskTableNumThreshold(100))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tree.Close()
+
+	for i := 0; i < 200; i++ {
+		key := []byte(fmt.Sprintf("key%03d", i))
+		if err := tree.Put(key, key); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tree.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if tree.diskTableNum < 2 {
+		t.Fatalf("expected several disk tables, but got %d", tree.diskTableNum)
+	}
+
+	before := openFiles(t)
+
+	// 关闭的迭代器立即释放文件
+	for i := 0; i < 100; i++ {
+		it, err := tree.Scan(nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		it.Next()
+		if err := it.Close(); err != nil {
+			t.Fatal(err)
+		}
+		if err := it.Close(); err != nil {
+			t.Fatalf("expected Close to be idempotent, but got %s", err)
+		}
+	}
+	if after := openFiles(t); after != before {
+		t.Fatalf("expected %d open files after closing iterators, but got %d", before, after)
+	}
+
+	// 丢弃的迭代器在垃圾回收后也不会泄漏文件
+	for i := 0; i < 100; i++ {
+		it, err := tree.Scan(nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		it.Next()
+	}
+	if opened := openFiles(t); opened <= before {
+		t.Fatalf("expected abandoned iterators to hold files, but got %d open files", opened)
+	}
+	// 终结器在单独的 goroutine 中运行，文件可能要经过几轮垃圾回收才被关闭
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		runtime.GC()
+		after := openFiles(t)
+		if after <= before {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected %d open files after abandoned iterators were collected, but got %d", before, after)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
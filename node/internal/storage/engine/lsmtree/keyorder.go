@@ -0,0 +1,6 @@
+//go:build !lsmdebug
+
+package lsmtree
+
+// checkKeyOrder 在调试构建（-tags lsmdebug）中让 diskTableWriter 检查写入的键严格递增，默认构建中不检查。
+const checkKeyOrder = false
@@ -0,0 +1,7 @@
+//go:build lsmdebug
+
+package lsmtree
+
+// checkKeyOrder 让 diskTableWriter 检查写入的键严格递增：顺序错误的键会让二分查找失效，
+// 在写入时返回错误，而不是生成一个读取时才出错的磁盘表。
+const checkKeyOrder = true
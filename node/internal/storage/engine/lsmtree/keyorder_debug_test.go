@@ -0,0 +1,43 @@
+//go:build lsmdebug
+
+package lsmtree
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestKeyOrderCheck(t *testing.T) {
+	for _, keys := range [][]string{
+		{"a", "c", "b"},
+		{"a", "b", "b"},
+	} {
+		dbDir := t.TempDir()
+		w, err := newDiskTableWriter(dbDir, "0-", defaultSparseKeyDistance, tableFormat{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i, key := range keys {
+			err = w.write([]byte(key), []byte("value"), 0)
+			if i < len(keys)-1 && err != nil {
+				t.Fatalf("unexpected error writing %q: %v", key, err)
+			}
+		}
+		if !errors.Is(err, errKeyOutOfOrder) {
+			t.Fatalf("expected %v for keys %q, but got %v", errKeyOutOfOrder, keys, err)
+		}
+		abortDiskTable(w, dbDir, "0-")
+	}
+
+	// 正常的刷新和合并不受影响
+	tree, err := Open(t.TempDir(), MemTableMaxEntries(10), ImmutableMemtableMaxNum(1), DiskTableNumThreshold(3))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tree.Close()
+	for i := 0; i < 100; i++ {
+		if err := tree.Put([]byte{byte(100 - i)}, []byte("value")); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
@@ -0,0 +1,183 @@
+package lsmtree
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strconv"
+)
+
+// liveValue 是 ScanKeys 中磁盘表输入代表未删除的键的值，迭代器不会把它返回给调用方。
+var liveValue = []byte{}
+
+// ScanKeys 返回按键升序遍历 [start, end) 范围内的键的迭代器，边界和快照的语义与 Scan 相同，
+// 迭代器的 Value 总是返回 nil。
+//
+// 磁盘表的索引文件已经按键的顺序单独保存了所有键和它们在数据文件中的偏移量，
+// ScanKeys 只顺序读取索引文件，不读取数据文件中的值，值较大时比 Scan 读取的字节少得多。
+// 记录的长度由相邻两个偏移量算出，与磁盘表的记录编码下同一个键的墓碑长度相同的记录是墓碑，
+// 因此已删除的键同样会被跳过。
+// 点查询仍然经由索引直接定位数据文件中的记录，不受影响。
+func (t *LSMTree) ScanKeys(start, end []byte) (*Iterator, error) {
+	if len(start) == 0 {
+		start = nil
+	}
+	if len(end) == 0 {
+		end = nil
+	}
+	if start != nil && end != nil && bytes.Compare(start, end) >= 0 {
+		return &Iterator{done: true, keysOnly: true}, nil
+	}
+
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	sources := []scanSource{newMemTableSource(t.pinned, start, end), newMemTableSource(t.memTable, start, end)}
+	for i := len(t.immutableMemtables) - 1; i >= 0; i-- {
+		sources = append(sources, newMemTableSource(t.immutableMemtables[i], start, end))
+	}
+
+	oldest := t.maxDiskTableIndex - t.diskTableNum + 1
+	for index := t.maxDiskTableIndex; index >= oldest; index-- {
+		source, err := newIndexSource(t.dbDir, index, start)
+		if err != nil {
+			closeScanSources(sources)
+			return nil, fmt.Errorf("failed to open disk table %d: %w", index, err)
+		}
+		if source != nil {
+			sources = append(sources, source)
+		}
+	}
+
+	it := &Iterator{sources: sources, end: end, keysOnly: true, release: t.readers.acquire(t.manifest.state.version)}
+	trackIterator(it, t.logger)
+	t.recordAccess()
+	return it, nil
+}
+
+// indexSource 从第一个不小于 start 的键开始遍历磁盘表的索引文件，只读取键。
+type indexSource struct {
+	file *os.File
+	r    *bufio.Reader
+	// 数据文件的大小，最后一个键的记录到这里为止
+	dataSize int
+	// 数据文件的记录编码，用来识别墓碑
+	records RecordFormat
+
+	// 当前的键和它在数据文件中的记录偏移量
+	cur       []byte
+	curOffset int
+	// 下一个键和它的记录偏移量，nextKey 为 nil 表示当前的键是最后一个
+	nextKey    []byte
+	nextOffset int
+	done       bool
+}
+
+// newIndexSource 打开磁盘表的索引文件并定位到 start，磁盘表中所有键都小于 start 时返回 nil。
+func newIndexSource(dbDir string, index int, start []byte) (*indexSource, error) {
+	prefix := strconv.Itoa(index) + "-"
+
+	from := 0
+	if start != nil {
+		var err error
+		if from, err = sparseIndexFrom(dbDir, prefix, start); err != nil {
+			return nil, err
+		}
+	}
+
+	format, err := readTableFormat(dbDir, prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	dataSize, err := GetFileSize(path.Join(dbDir, prefix+diskTableDataFileName))
+	if err != nil {
+		return nil, fmt.Errorf("failed to stat data file: %w", err)
+	}
+
+	indexPath := path.Join(dbDir, prefix+diskTableIndexFileName)
+	file, err := os.OpenFile(indexPath, os.O_RDONLY, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open index file: %w", err)
+	}
+	if _, err := file.Seek(int64(from), io.SeekStart); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to seek: %w", err)
+	}
+
+	s := &indexSource{file: file, r: bufio.NewReader(file), dataSize: int(dataSize), records: format.records}
+	for {
+		key, offset, err := s.read()
+		if err != nil {
+			file.Close()
+			return nil, fmt.Errorf("failed to read index file %s: %w", indexPath, err)
+		}
+		if key == nil {
+			file.Close()
+			return nil, nil
+		}
+		if start == nil || bytes.Compare(key, start) >= 0 {
+			s.cur, s.curOffset = key, offset
+			break
+		}
+	}
+	if s.nextKey, s.nextOffset, err = s.read(); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to read index file %s: %w", indexPath, err)
+	}
+
+	return s, nil
+}
+
+// read 读取索引文件中的下一个键和偏移量，到达文件末尾时返回 nil。
+func (s *indexSource) read() ([]byte, int, error) {
+	key, value, err := decode(s.r)
+	if err == io.EOF {
+		return nil, 0, nil
+	}
+	if err != nil {
+		return nil, 0, err
+	}
+	return key, decodeInt(value), nil
+}
+
+func (s *indexSource) valid() bool {
+	return !s.done
+}
+
+func (s *indexSource) key() []byte {
+	return s.cur
+}
+
+// value 对墓碑返回 nil，其他的键返回 liveValue。
+func (s *indexSource) value() []byte {
+	end := s.dataSize
+	if s.nextKey != nil {
+		end = s.nextOffset
+	}
+	if end-s.curOffset == s.records.tombstoneLen(len(s.cur)) {
+		return nil
+	}
+	return liveValue
+}
+
+func (s *indexSource) advance() error {
+	if s.nextKey == nil {
+		s.done = true
+		return nil
+	}
+	s.cur, s.curOffset = s.nextKey, s.nextOffset
+
+	var err error
+	if s.nextKey, s.nextOffset, err = s.read(); err != nil {
+		return fmt.Errorf("failed to read index file: %w", err)
+	}
+	return nil
+}
+
+func (s *indexSource) close() error {
+	return s.file.Close()
+}
@@ -0,0 +1,160 @@
+package lsmtree
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// collectKeys 返回迭代器中所有的键，并检查 ScanKeys 的迭代器不返回值。
+func collectKeys(t testing.TB, open func() (*Iterator, error)) []string {
+	it, err := open()
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer it.Close()
+	var keys []string
+	for it.Next() {
+		if it.keysOnly && it.Value() != nil {
+			t.Fatalf("expected no value for %s, but got %q", it.Key(), it.Value())
+		}
+		keys = append(keys, string(it.Key()))
+	}
+	if err := it.Err(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	return keys
+}
+
+func TestScanKeys(t *testing.T) {
+	dbDir := t.TempDir()
+	tree, err := Open(dbDir, SparseKeyDistance(4), DiskTableNumThreshold(100))
+	if err != nil {
+		t.Fatalf("failed to open LSM tree %s: %s", dbDir, err)
+	}
+	defer tree.Close()
+
+	flush := func() {
+		t.Helper()
+		if err := tree.Flush(); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+
+	// 第一张磁盘表：值的长度各不相同
+	for i := 0; i < 50; i++ {
+		if err := tree.Put([]byte(fmt.Sprintf("key%02d", i)), bytes.Repeat([]byte("v"), i+1)); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+	flush()
+
+	// 第二张磁盘表使用压缩，删除部分键，其中包括它的最后一个键
+	tree.compression = Snappy
+	for i := 0; i < 50; i += 7 {
+		if err := tree.Delete([]byte(fmt.Sprintf("key%02d", i))); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+	if err := tree.Delete([]byte("key49")); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	flush()
+
+	// 内存表中重新写入一个被删除的键并删除一个新的键
+	if err := tree.Put([]byte("key14"), []byte("again")); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := tree.Delete([]byte("key20")); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	for _, r := range [][2]string{{"", ""}, {"key10", "key30"}, {"key21", ""}, {"key48", ""}, {"key50", ""}, {"key30", "key10"}} {
+		start, end := []byte(r[0]), []byte(r[1])
+		want := collectKeys(t, func() (*Iterator, error) { return tree.Scan(start, end) })
+		got := collectKeys(t, func() (*Iterator, error) { return tree.ScanKeys(start, end) })
+		if !reflect.DeepEqual(got, want) {
+			t.Fatalf("range %q: expected %v, but got %v", r, want, got)
+		}
+	}
+
+	// 合并后丢弃墓碑，结果不变
+	want := collectKeys(t, func() (*Iterator, error) { return tree.Scan(nil, nil) })
+	if err := tree.compactDiskTables(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if got := collectKeys(t, func() (*Iterator, error) { return tree.ScanKeys(nil, nil) }); !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected %v after compaction, but got %v", want, got)
+	}
+}
+
+func TestScanKeysVarintRecords(t *testing.T) {
+	dbDir := t.TempDir()
+	tree, err := Open(dbDir, RecordEncoding(VarintRecords), DiskTableNumThreshold(100))
+	if err != nil {
+		t.Fatalf("failed to open LSM tree %s: %s", dbDir, err)
+	}
+	defer tree.Close()
+
+	// 长键的长度需要多个字节的变长整数
+	long := strings.Repeat("k", 200)
+	for _, key := range []string{"a", "b", long} {
+		if err := tree.Put([]byte(key), []byte("value")); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+	if err := tree.Flush(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	for _, key := range []string{"a", long} {
+		if err := tree.Delete([]byte(key)); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+	if err := tree.Flush(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	if got := collectKeys(t, func() (*Iterator, error) { return tree.ScanKeys(nil, nil) }); !reflect.DeepEqual(got, []string{"b"}) {
+		t.Fatalf("expected [b], but got %v", got)
+	}
+}
+
+func BenchmarkScanKeys(b *testing.B) {
+	dbDir := b.TempDir()
+	tree, err := Open(dbDir, MemTableThreshold(64<<20))
+	if err != nil {
+		b.Fatalf("failed to open LSM tree %s: %s", dbDir, err)
+	}
+	defer tree.Close()
+
+	value := bytes.Repeat([]byte("v"), 4096)
+	count := 5000
+	for i := 0; i < count; i++ {
+		if err := tree.Put([]byte(fmt.Sprintf("key%06d", i)), value); err != nil {
+			b.Fatalf("unexpected error: %s", err)
+		}
+	}
+	if err := tree.Flush(); err != nil {
+		b.Fatalf("unexpected error: %s", err)
+	}
+
+	for _, scan := range []struct {
+		name string
+		open func() (*Iterator, error)
+	}{
+		{"data", func() (*Iterator, error) { return tree.Scan(nil, nil) }},
+		{"index", func() (*Iterator, error) { return tree.ScanKeys(nil, nil) }},
+	} {
+		b.Run(scan.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if keys := collectKeys(b, scan.open); len(keys) != count {
+					b.Fatalf("expected %d keys, but got %d", count, len(keys))
+				}
+			}
+		})
+	}
+}
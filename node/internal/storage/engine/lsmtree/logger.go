@@ -0,0 +1,33 @@
+package lsmtree
+
+import "log"
+
+// Logger 是引擎和服务端输出日志的接口，嵌入方可以实现它把日志转到自己的日志系统（例如输出 JSON）。
+// 参数与 fmt.Printf 相同，实现需要能被多个 goroutine 同时调用。
+type Logger interface {
+	Debug(format string, args ...interface{})
+	Info(format string, args ...interface{})
+	Warn(format string, args ...interface{})
+	Error(format string, args ...interface{})
+}
+
+// WithLogger 设置 LSMTree 使用的日志接口，默认使用标准库的 log。传入 nil 时保持默认。
+func WithLogger(logger Logger) func(*LSMTree) {
+	return func(t *LSMTree) {
+		if logger != nil {
+			t.logger = logger
+		}
+	}
+}
+
+// StdLogger 返回把日志写到标准库 log 的 Logger，每行带上级别前缀。
+func StdLogger() Logger {
+	return stdLogger{}
+}
+
+type stdLogger struct{}
+
+func (stdLogger) Debug(format string, args ...interface{}) { log.Printf("DEBUG "+format, args...) }
+func (stdLogger) Info(format string, args ...interface{})  { log.Printf("INFO "+format, args...) }
+func (stdLogger) Warn(format string, args ...interface{})  { log.Printf("WARN "+format, args...) }
+func (stdLogger) Error(format string, args ...interface{}) { log.Printf("ERROR "+format, args...) }
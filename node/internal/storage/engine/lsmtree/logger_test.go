@@ -0,0 +1,73 @@
+package lsmtree
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// testLogger 把日志按 "级别 内容" 的格式保存下来
+type testLogger struct {
+	mu    sync.Mutex
+	lines []string
+}
+
+func (l *testLogger) log(level, format string, args ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.lines = append(l.lines, level+" "+fmt.Sprintf(format, args...))
+}
+
+func (l *testLogger) Debug(format string, args ...interface{}) { l.log("DEBUG", format, args...) }
+func (l *testLogger) Info(format string, args ...interface{})  { l.log("INFO", format, args...) }
+func (l *testLogger) Warn(format string, args ...interface{})  { l.log("WARN", format, args...) }
+func (l *testLogger) Error(format string, args ...interface{}) { l.log("ERROR", format, args...) }
+
+// find 返回第一条以 prefix 开头的日志
+func (l *testLogger) find(prefix string) (string, bool) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	for _, line := range l.lines {
+		if strings.HasPrefix(line, prefix) {
+			return line, true
+		}
+	}
+	return "", false
+}
+
+func TestLogger(t *testing.T) {
+	logger := &testLogger{}
+	dbDir := t.TempDir()
+	tree, err := Open(dbDir, WithLogger(logger), MemTableMaxEntries(1), ImmutableMemtableMaxNum(1), Listeners(panicListener{}))
+	if err != nil {
+		t.Fatalf("failed to open LSM tree %s: %s", dbDir, err)
+	}
+	defer tree.Close()
+
+	tree.PrintStatus()
+	if line, ok := logger.find("INFO MemTable:"); !ok {
+		t.Fatalf("expected status to be logged, but got %q", line)
+	}
+
+	// 监听器的 panic 作为错误写入注入的日志接口
+	for i := 0; i < 3; i++ {
+		if err := tree.Put([]byte(fmt.Sprint(i)), []byte("value")); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		if line, ok := logger.find("ERROR event listener panicked"); ok {
+			if !strings.Contains(line, "bad listener") {
+				t.Fatalf("unexpected log line %q", line)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the listener panic to be logged")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
@@ -1,22 +1,28 @@
 package lsmtree
 
 import (
+	"context"
 	"errors"
 	"fmt"
+	"io/fs"
 	"math"
 	"os"
 	"path"
-	"strconv"
 	"sync"
+	"sync/atomic"
+	"time"
 )
 
 const (
-	// MaxKeySize 是允许的最大键大小。
-	// 该大小是硬编码的，不能更改，因为这会影响编码特性。
+	// MaxKeySize 是默认允许的最大键大小，可以通过 KeySizeLimit 调整。
 	MaxKeySize = math.MaxUint16
-	// MaxValueSize 是允许的最大值大小。
-	// 该大小是硬编码的，不能更改，因为这会影响编码特性。
+	// MaxKeySizeLimit 是 KeySizeLimit 能设置的上限。
+	// 记录的编码使用 8 字节的长度字段，真正的约束来自导出文件格式中 4 字节的键长度字段。
+	MaxKeySizeLimit = math.MaxUint32
+	// MaxValueSize 是默认允许的最大值大小，可以通过 ValueSizeLimit 调整。
 	MaxValueSize = math.MaxUint16
+	// MaxValueSizeLimit 是 ValueSizeLimit 能设置的上限，同样来自导出文件格式中 4 字节的值长度字段。
+	MaxValueSizeLimit = math.MaxUint32
 )
 
 const (
@@ -29,23 +35,40 @@ var (
 	ErrKeyRequired = errors.New("key required")
 	// ErrValueRequired 当放入零长度值或 nil 时返回。
 	ErrValueRequired = errors.New("value required")
-	// ErrKeyTooLarge 当放入的键大于 MaxKeySize 时返回。
+	// ErrKeyTooLarge 当放入的键大于 KeySizeLimit 设置的上限（默认为 MaxKeySize）时返回。
 	ErrKeyTooLarge = errors.New("key too large")
-	// ErrValueTooLarge 当放入的值大于 MaxValueSize 时返回。
+	// ErrValueTooLarge 当放入的值大于 ValueSizeLimit 设置的上限（默认为 MaxValueSize）时返回。
 	ErrValueTooLarge = errors.New("value too large")
+	// ErrWarmUpTimeout 当预热未能在 WarmUpTimeout 内完成时返回。
+	ErrWarmUpTimeout = errors.New("warm up timed out")
+	// ErrNotDirectory 当 Open 的路径存在但不是目录时返回。
+	ErrNotDirectory = errors.New("not a directory")
+	// ErrReadOnly 当对以只读方式打开的数据库执行写操作时返回。
+	ErrReadOnly = errors.New("database is read-only")
 )
 
 // LSMTree (https://en.wikipedia.org/wiki/Log-structured_merge-tree)
 // 是针对存储数据在文件中的日志结构合并树实现。
-// 该实现不是 goroutine 安全的！如果需要，确保对树的访问是同步的。
+// 读写操作通过 mu 同步，可以被多个 goroutine 并发调用。
 type LSMTree struct {
 	// 存储 LSM 树文件的目录的路径，
 	// 必须为树的每个实例提供专用目录。
 	dbDir string
+	// 存放 WAL 文件的目录，默认与 dbDir 相同。
+	walDir string
 
 	// 在执行任何写操作之前，
 	// 它会写入写前日志（WAL），然后才应用。
 	wal *os.File
+	// 保留的归档 WAL 段数量，0 表示刷新时直接截断 WAL，详见 ArchiveWAL。
+	walRetention int
+	// 写入是否跳过 WAL，详见 DisableWAL。
+	disableWAL bool
+	// 下一条写入的序号和当前 WAL 中第一条记录的序号。
+	walSeq   uint64
+	walStart uint64
+	// 等待下一次写入的 WAL 订阅共用的通道，写入时关闭，没有等待的订阅时为 nil，详见 WALSubscribe。
+	walWaiters atomic.Pointer[chan struct{}]
 
 	// 它指向磁盘上最新创建的 DiskTable。
 	// MemTable 被刷新后，索引会更新。
@@ -55,6 +78,11 @@ type LSMTree struct {
 	// 持久存储中已刷新和合并的磁盘表的当前数量。
 	diskTableNum int
 
+	// 记录磁盘表集合的清单，diskTableNum 和 maxDiskTableIndex 由它重放得到，详见 manifestFileName。
+	manifest *manifest
+	// 正在读取磁盘表的迭代器和 ValueReader，被合并替换的磁盘表等它们关闭后删除。
+	readers *tableReaders
+
 	// 所有已刷新到 WAL 但未刷新到已排序文件中的更改
 	// 可读写的内存表
 	memTable *memTable
@@ -65,6 +93,11 @@ type LSMTree struct {
 	// 如果 MemTable 的大小（以字节为单位）超过阈值，
 	// 必须将其刷新到文件系统。
 	memTableThreshold int
+	// 如果 MemTable 中的键数量超过该值，同样必须将其刷新到文件系统。
+	// 0 表示不限制。
+	memTableMaxEntries int
+	// 内存表和不可变内存表占用内存的硬上限，0 表示不限制。
+	memoryLimit int
 
 	// 如果 DiskTable 的数量超过阈值，
 	// 磁盘表必须被合并以减少它。
@@ -72,8 +105,135 @@ type LSMTree struct {
 
 	// 稀疏索引中键之间的距离。
 	sparseKeyDistance int
-	// 不可变表的合并写入互斥锁
+
+	// 允许的最大键大小。
+	keySizeLimit int
+	// 允许的最大值大小。
+	valueSizeLimit int
+
+	// 预热的最长耗时。
+	warmUpTimeout time.Duration
+
+	// 目录不存在时 Open 是否自动创建。
+	createIfMissing bool
+
+	// 刷新和合并完成后接收通知的监听器。
+	listeners []EventListener
+	// 每个监听器的回调队列，与 listeners 一一对应。
+	eventQueues []*eventQueue
+
+	// 日志输出，默认使用标准库的 log。
+	logger Logger
+
+	// 新写入的磁盘表使用的压缩算法。
+	compression Codec
+	// 新写入的磁盘表使用的记录编码，详见 RecordEncoding。
+	recordFormat RecordFormat
+	// 新写入的磁盘表是否把键和值分开存放，详见 SeparateValues。
+	separateValues bool
+
+	// Open 时是否在清理后合并磁盘表。
+	compactOnOpen bool
+
+	// 以只读方式打开时不创建也不修改任何文件，写操作返回 ErrReadOnly。
+	readOnly bool
+
+	// 读取磁盘表时是否校验记录的校验和。
+	verifyChecksums bool
+
+	// 刷新和合并之后至少保留的磁盘剩余空间，0 表示不检查。
+	minFreeSpace int
+
+	// Scan 遍历磁盘表时预读的字节数，0 表示不预读。
+	readAhead int
+
+	// 最多缓存稀疏索引的磁盘表数量，0 表示不缓存，详见 SparseIndexCacheSize。
+	sparseIndexCacheSize int
+	// 按磁盘表编号缓存的稀疏索引，不缓存时为 nil。合并和删除磁盘表时在写锁下清空。
+	sparseIndexes *sparseIndexCache
+
+	// 最近确认不存在的键的缓存设置，详见 NegativeCache。
+	negativeCacheSize int
+	negativeCacheTTL  time.Duration
+	// 不缓存时为 nil。
+	negatives *negativeCache
+
+	// 自适应内存表阈值的范围，adaptiveMaxThreshold 为 0 时不开启，详见 AdaptiveMemTableThreshold。
+	adaptiveMinThreshold int
+	adaptiveMaxThreshold int
+	// 观察到的写入速率（字节每秒）的指数移动平均，限制在阈值范围对应的速率内，还没有观察时为 0。
+	writeRate float64
+	// 当前内存表创建的时间，用于计算写满它的速率。
+	memTableCreated time.Time
+
+	// 固定在内存中、不刷新到磁盘表的键，没有墓碑，详见 PutPinned。
+	pinned *memTable
+	// 固定的键和值的总字节数上限。
+	pinnedMemoryLimit int
+	// 固定的键的日志，还没有固定过键或开启 DisableWAL 时为 nil。
+	pinnedLog     *os.File
+	pinnedLogSize int64
+
+	// 读取时同时查找的磁盘表数量上限，不大于 1 时从新到旧依次查找。
+	searchParallelism int
+
+	// 决定合并哪些磁盘表的策略。
+	compactionStrategy CompactionStrategy
+
+	// 后台合并小磁盘表的设置，详见 CoalesceSmallTables。
+	coalesceMinSize int
+	coalesceIdle    time.Duration
+	// 是否把每个不可变内存表刷新为各自的磁盘表，详见 FlushMemtablesSeparately。
+	flushSeparately bool
+	// 空闲多久后刷新内存表，0 表示不刷新，详见 FlushOnIdle。
+	flushIdle time.Duration
+	// 最近一次写入的时间（UnixNano），在写锁下更新，后台任务原子地读取。
+	lastWrite int64
+	// 开启空闲合并时最近一次读取的时间（UnixNano），读取时在读锁下原子地更新。
+	lastRead int64
+	// 空闲合并的设置，compactIdle 为 0 表示不推迟合并，详见 CompactWhenIdle。
+	compactIdle        time.Duration
+	compactMaxDeferral time.Duration
+	// 开始推迟合并的时间，没有推迟的合并时为零值，在写锁下读写。
+	compactionDeferred time.Time
+
+	// 自上次合并以来 Get 的次数和查找的磁盘表总数，读取时在读锁下原子地更新。
+	gets          int64
+	getTablesRead int64
+	// 自 Open 以来每个层级给出的 Get 结果数量，下标为 ReadTier，读取时在读锁下原子地更新。
+	readSources [numReadTiers]int64
+	// 触发合并的读放大，0 表示不按读放大合并，详见 CompactOnReadAmplification。
+	readAmpThreshold float64
+	// 正在进行的合并，没有合并时为 nil，详见 CompactionProgress。
+	compaction atomic.Pointer[compactionState]
+	// 正在进行的后台操作，详见 BackgroundOps
+	ops backgroundOps
+	// 正在进行的完全合并结束时关闭，没有完全合并时为 nil，在写锁下读写，详见 MajorCompact。
+	majorDone chan struct{}
+
+	// 通知后台任务按读放大合并，没有开启时为 nil。
+	readAmpCompaction chan struct{}
+	// 通知后台任务等待空闲后合并，没有开启空闲合并时为 nil。
+	idleCompaction chan struct{}
+
+	// 写入字节数的统计，在写锁下更新。
+	stats Stats
+	// 是否开启组提交和等待提交的写入，详见 GroupCommit。
+	groupCommit bool
+	commits     commitQueue
+	// 正在执行一组提交时为 true，写入 WAL 时不同步；walUnsynced 表示 WAL 中有还没有同步的写入。都在写锁下读写。
+	committingGroup bool
+	walUnsynced     bool
+	// 数据库的纪元，详见 Epoch。
+	epoch string
+	// 写操作持有写锁，读操作持有读锁
 	mu sync.RWMutex
+
+	// Close 时取消，用于中断正在进行的刷新和合并
+	ctx    context.Context
+	cancel context.CancelFunc
+	// 后台任务，Close 等待它们退出
+	background sync.WaitGroup
 }
 
 // MemTableThreshold 为 LSMTree 设置 memTableThreshold。
@@ -85,6 +245,34 @@ func MemTableThreshold(memTableThreshold int) func(*LSMTree) {
 	}
 }
 
+// MemTableMaxEntries 为 LSMTree 设置 memTableMaxEntries。
+// 如果 MemTable 中的键数量超过该值，即使未达到 memTableThreshold
+// 也会将其刷新到文件系统，以先达到的条件为准。
+func MemTableMaxEntries(memTableMaxEntries int) func(*LSMTree) {
+	return func(t *LSMTree) {
+		t.memTableMaxEntries = memTableMaxEntries
+	}
+}
+
+// MemoryLimit 为 LSMTree 设置 memoryLimit。
+// 内存表和不可变内存表占用的内存（包括跳表节点的开销）达到上限时，
+// 写操作会同步刷新所有内存表后才返回，期间其他写操作被阻塞，
+// 避免刷新跟不上写入时内存无限增长。
+func MemoryLimit(memoryLimit int) func(*LSMTree) {
+	return func(t *LSMTree) {
+		t.memoryLimit = memoryLimit
+	}
+}
+
+// ImmutableMemtableMaxNum 为 LSMTree 设置 immutableMemtableMaxNum，必须 >= 1。
+// 不可变内存表数量达到该值时合并刷新到磁盘。较大的值可以平滑突发写入，
+// 但会占用更多内存；较小的值刷新得更早。
+func ImmutableMemtableMaxNum(immutableMemtableMaxNum int) func(*LSMTree) {
+	return func(t *LSMTree) {
+		t.immutableMemtableMaxNum = immutableMemtableMaxNum
+	}
+}
+
 // SparseKeyDistance 为 LSMTree 设置 sparseKeyDistance。
 // 稀疏索引中键之间的距离。
 func SparseKeyDistance(sparseKeyDistance int) func(*LSMTree) {
@@ -93,6 +281,24 @@ func SparseKeyDistance(sparseKeyDistance int) func(*LSMTree) {
 	}
 }
 
+// KeySizeLimit 为 LSMTree 设置 keySizeLimit（默认 MaxKeySize），不能超过 MaxKeySizeLimit。
+// 每条记录在读取时会被完整地读入内存，内存表、索引和稀疏索引也都保存完整的键，
+// 因此较大的键会按比例增加内存占用和索引大小。
+// 通过网络写入时键还受服务端单条消息大小的限制。
+func KeySizeLimit(keySizeLimit int) func(*LSMTree) {
+	return func(t *LSMTree) {
+		t.keySizeLimit = keySizeLimit
+	}
+}
+
+// ValueSizeLimit 为 LSMTree 设置 valueSizeLimit（默认 MaxValueSize），不能超过 MaxValueSizeLimit。
+// 内存表保存完整的值，写入时值必须完整地放在内存中；读取较大的值时可以使用 OpenValue 流式读取。
+func ValueSizeLimit(valueSizeLimit int) func(*LSMTree) {
+	return func(t *LSMTree) {
+		t.valueSizeLimit = valueSizeLimit
+	}
+}
+
 // DiskTableNumThreshold 为 LSMTree 设置 diskTableNumThreshold。
 // 如果 DiskTable 的数量超过阈值，磁盘表必须
 // 被合并以减少它。
@@ -102,52 +308,306 @@ func DiskTableNumThreshold(diskTableNumThreshold int) func(*LSMTree) {
 	}
 }
 
-// Open 打开数据库。只有一个树的实例可以
-// 读取和写入该目录。
-func Open(dbDir string, options ...func(*LSMTree)) (*LSMTree, error) {
-	if _, err := os.Stat(dbDir); os.IsNotExist(err) {
-		return nil, fmt.Errorf("directory %s does not exist", dbDir)
+// WarmUpTimeout 为 LSMTree 设置 warmUpTimeout。
+// WarmUp 超过该时长仍未完成时放弃剩余的磁盘表。
+func WarmUpTimeout(warmUpTimeout time.Duration) func(*LSMTree) {
+	return func(t *LSMTree) {
+		t.warmUpTimeout = warmUpTimeout
 	}
+}
 
-	walPath := path.Join(dbDir, walFileName)
-	wal, err := os.OpenFile(walPath, os.O_RDWR|os.O_CREATE, 0600)
-	if err != nil {
-		return nil, fmt.Errorf("failed to open file %s: %w", walPath, err)
+// WALDir 为 LSMTree 设置 walDir。
+// WAL 可以放在与数据不同的（更快的）设备上，例如数据在 HDD、WAL 在 NVMe。
+// 重新打开数据库时必须传入相同的目录，否则未刷新的写入无法恢复。
+func WALDir(walDir string) func(*LSMTree) {
+	return func(t *LSMTree) {
+		t.walDir = walDir
 	}
+}
 
-	memTable, err := loadMemTable(wal)
-	if err != nil {
-		return nil, fmt.Errorf("failed to load entries from %s: %w", walPath, err)
+// DisableWAL 为 LSMTree 设置 disableWAL（默认 false）。
+// 为 true 时 Put、Append、GetOrSet 和 Delete 不写入 WAL，写入只有在内存表刷新为磁盘表之后才持久化：
+// 进程崩溃或者没有调用 Flush 就 Close 都会丢失尚未刷新的全部写入。
+// 只应用于可以从源头重新导入的数据，例如批量导入，导入完成后调用 Flush。不能与 ArchiveWAL 同时使用。
+func DisableWAL(disableWAL bool) func(*LSMTree) {
+	return func(t *LSMTree) {
+		t.disableWAL = disableWAL
+	}
+}
+
+// CreateIfMissing 为 LSMTree 设置 createIfMissing。
+// 为 true（默认）时，Open 会在 dbDir 不存在时创建它。
+func CreateIfMissing(createIfMissing bool) func(*LSMTree) {
+	return func(t *LSMTree) {
+		t.createIfMissing = createIfMissing
 	}
+}
 
-	diskTableNum, maxDiskTableIndex, err := readDiskTableMeta(dbDir)
-	if err != nil {
-		return nil, fmt.Errorf("failed to read disk table meta: %w", err)
+// VerifyChecksums 为 LSMTree 设置 verifyChecksums（默认开启）。
+// 关闭后 Get 读取磁盘表时不再校验记录的校验和，可以节省读密集场景下的 CPU，
+// 但磁盘上的静默损坏将不会被发现，损坏的值可能直接返回给调用方，
+// 只应在存储可信时关闭。WAL 重放、合并和 Verify 总是会校验。
+func VerifyChecksums(verifyChecksums bool) func(*LSMTree) {
+	return func(t *LSMTree) {
+		t.verifyChecksums = verifyChecksums
 	}
+}
 
+// SearchParallelism 为 LSMTree 设置 searchParallelism（默认 1，即顺序查找）。
+// 磁盘表较多且存储支持并发随机读（例如 SSD）时，Get 可以同时在多个磁盘表中查找，
+// 减少命中旧表或未命中时的延迟，代价是每次读取打开更多文件。
+func SearchParallelism(searchParallelism int) func(*LSMTree) {
+	return func(t *LSMTree) {
+		t.searchParallelism = searchParallelism
+	}
+}
+
+// ReadOnly 以只读方式打开数据库，例如挂载一个备份目录进行查询。
+// 只读模式下目录必须已存在，WAL 可以缺失或不可写：缺失时跳过重放。
+func ReadOnly() func(*LSMTree) {
+	return func(t *LSMTree) {
+		t.readOnly = true
+	}
+}
+
+// Open 打开数据库。只有一个树的实例可以
+// 读取和写入该目录。
+func Open(dbDir string, options ...func(*LSMTree)) (*LSMTree, error) {
 	t := &LSMTree{
-		wal:                     wal,
-		memTable:                memTable,
 		dbDir:                   dbDir,
-		maxDiskTableIndex:       maxDiskTableIndex,
 		memTableThreshold:       defaultMemTableThreshold,
 		sparseKeyDistance:       defaultSparseKeyDistance,
-		diskTableNum:            diskTableNum,
+		keySizeLimit:            MaxKeySize,
+		valueSizeLimit:          MaxValueSize,
 		diskTableNumThreshold:   defaultDiskTableNumThreshold,
-		immutableMemtableMaxNum: 4,
+		immutableMemtableMaxNum: defaultImmutableMemtableMaxNum,
+		warmUpTimeout:           defaultWarmUpTimeout,
+		createIfMissing:         true,
+		verifyChecksums:         true,
+		searchParallelism:       defaultSearchParallelism,
+		readAhead:               defaultReadAhead,
+		sparseIndexCacheSize:    defaultSparseIndexCacheSize,
+		compactionStrategy:      AdjacentPairStrategy{MaxSize: defaultSSTableSize},
+		pinnedMemoryLimit:       defaultPinnedMemoryLimit,
+		pinned:                  newMemTable(),
+		logger:                  StdLogger(),
 	}
 	for _, option := range options {
 		option(t)
 	}
 
+	if t.immutableMemtableMaxNum < 1 {
+		return nil, fmt.Errorf("immutable memtable max num must be at least 1, got %d", t.immutableMemtableMaxNum)
+	}
+	if t.keySizeLimit < 1 || int64(t.keySizeLimit) > MaxKeySizeLimit {
+		return nil, fmt.Errorf("key size limit must be between 1 and %d, got %d", int64(MaxKeySizeLimit), t.keySizeLimit)
+	}
+	if t.valueSizeLimit < 1 || int64(t.valueSizeLimit) > MaxValueSizeLimit {
+		return nil, fmt.Errorf("value size limit must be between 1 and %d, got %d", int64(MaxValueSizeLimit), t.valueSizeLimit)
+	}
+	if t.disableWAL && t.walRetention > 0 {
+		return nil, errors.New("WAL archiving requires the WAL to be enabled")
+	}
+	if t.readAmpThreshold < 0 {
+		return nil, fmt.Errorf("read amplification threshold must not be negative, got %g", t.readAmpThreshold)
+	}
+	if t.coalesceMinSize > 0 && t.coalesceIdle <= 0 {
+		return nil, fmt.Errorf("coalesce idle time must be positive, got %s", t.coalesceIdle)
+	}
+	if t.flushIdle < 0 {
+		return nil, fmt.Errorf("idle flush interval must not be negative, got %s", t.flushIdle)
+	}
+	if t.compactIdle < 0 || t.compactIdle > 0 && t.compactMaxDeferral <= 0 {
+		return nil, fmt.Errorf("idle compaction requires a non-negative idle time and a positive max deferral, got %s and %s", t.compactIdle, t.compactMaxDeferral)
+	}
+	if t.readAhead < 0 {
+		return nil, fmt.Errorf("read ahead must not be negative, got %d", t.readAhead)
+	}
+	if t.sparseIndexCacheSize < 0 {
+		return nil, fmt.Errorf("sparse index cache size must not be negative, got %d", t.sparseIndexCacheSize)
+	}
+	t.sparseIndexes = newSparseIndexCache(t.sparseIndexCacheSize)
+	if t.negativeCacheSize < 0 || t.negativeCacheTTL < 0 {
+		return nil, fmt.Errorf("negative cache size and ttl must not be negative, got %d and %s", t.negativeCacheSize, t.negativeCacheTTL)
+	}
+	t.negatives = newNegativeCache(t.negativeCacheSize, t.negativeCacheTTL)
+	if t.adaptiveMaxThreshold > 0 {
+		if t.adaptiveMinThreshold < 1 || t.adaptiveMinThreshold > t.adaptiveMaxThreshold {
+			return nil, fmt.Errorf("adaptive memtable threshold range must satisfy 1 <= min <= max, got [%d, %d]", t.adaptiveMinThreshold, t.adaptiveMaxThreshold)
+		}
+		t.memTableThreshold = min(max(t.memTableThreshold, t.adaptiveMinThreshold), t.adaptiveMaxThreshold)
+	}
+	if t.pinnedMemoryLimit < 0 {
+		return nil, fmt.Errorf("pinned memory limit must not be negative, got %d", t.pinnedMemoryLimit)
+	}
+	if !t.compression.valid() {
+		return nil, fmt.Errorf("unknown codec %d", byte(t.compression))
+	}
+	if !t.recordFormat.valid() {
+		return nil, fmt.Errorf("unknown record format %d", byte(t.recordFormat))
+	}
+
+	if err := prepareDBDir(dbDir, t.createIfMissing && !t.readOnly); err != nil {
+		return nil, err
+	}
+	if t.walDir == "" {
+		t.walDir = dbDir
+	} else if !t.readOnly {
+		if err := prepareDBDir(t.walDir, t.createIfMissing); err != nil {
+			return nil, err
+		}
+	}
+
+	version, diskTableNum, maxDiskTableIndex, err := readDiskTableMeta(dbDir, t.walDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read disk table meta: %w", err)
+	}
+	t.maxDiskTableIndex = maxDiskTableIndex
+	t.diskTableNum = diskTableNum
+
+	// 旧格式的数据库在加载 WAL 和提供服务之前先升级
+	if err := t.upgrade(version); err != nil {
+		return nil, err
+	}
+
+	// 重放清单得到现存的磁盘表
+	if err := t.loadManifest(); err != nil {
+		return nil, fmt.Errorf("failed to load the manifest: %w", err)
+	}
+	t.readers = newTableReaders(dbDir, t.logger)
+
+	// 清理崩溃留下的合并输出和写了一半的磁盘表，必须在加载 WAL 之前完成
+	if !t.readOnly {
+		if err := t.recoverDiskTables(); err != nil {
+			return nil, fmt.Errorf("failed to recover disk tables: %w", err)
+		}
+	}
+
+	t.epoch, err = loadEpoch(dbDir, t.readOnly)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		wal      *os.File
+		memTable *memTable
+	)
+	walPath := path.Join(t.walDir, walFileName)
+	if t.readOnly {
+		memTable, err = loadReadOnlyMemTable(walPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load entries from %s: %w", walPath, err)
+		}
+	} else {
+		wal, err = os.OpenFile(walPath, os.O_RDWR|os.O_CREATE, 0600)
+		if err != nil {
+			return nil, fmt.Errorf("failed to open file %s: %w", walPath, err)
+		}
+
+		var dropped int64
+		memTable, dropped, err = loadMemTable(wal)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load entries from %s: %w", walPath, err)
+		}
+		if dropped > 0 {
+			t.logger.Warn("truncated an incomplete record of %d bytes at the end of %s", dropped, walPath)
+		}
+	}
+
+	if err := t.loadPinned(); err != nil {
+		return nil, fmt.Errorf("failed to load pinned keys: %w", err)
+	}
+
+	if t.walRetention > 0 {
+		if err := t.loadWALSequence(); err != nil {
+			return nil, fmt.Errorf("failed to load WAL sequence: %w", err)
+		}
+	}
+
+	t.ctx, t.cancel = context.WithCancel(context.Background())
+	t.wal = wal
+	t.memTable = memTable
+	t.memTableCreated = time.Now()
+
+	if t.compactOnOpen && !t.readOnly {
+		if err := t.compactAfterOpen(); err != nil {
+			return nil, err
+		}
+	}
+
+	if t.coalesceMinSize > 0 && !t.readOnly {
+		t.startCoalescing()
+	}
+	if t.readAmpThreshold > 0 && !t.readOnly {
+		t.startReadAmpCompaction()
+	}
+	if t.flushIdle > 0 && !t.readOnly {
+		t.startIdleFlush()
+	}
+	if t.compactIdle > 0 && !t.readOnly {
+		t.startIdleCompaction()
+	}
+
 	return t, nil
 }
+
+// prepareDBDir 检查数据库目录，createIfMissing 为 true 时在目录不存在时创建它。
+func prepareDBDir(dbDir string, createIfMissing bool) error {
+	info, err := os.Stat(dbDir)
+	if os.IsNotExist(err) {
+		if !createIfMissing {
+			return fmt.Errorf("directory %s does not exist", dbDir)
+		}
+		if err := os.MkdirAll(dbDir, 0700); err != nil {
+			return fmt.Errorf("failed to create directory %s: %w", dbDir, err)
+		}
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to stat %s: %w", dbDir, err)
+	}
+	if !info.IsDir() {
+		return fmt.Errorf("%s: %w", dbDir, ErrNotDirectory)
+	}
+
+	return nil
+}
+
 func (t *LSMTree) refreshMemTable() {
 	t.memTable = newMemTable()
+	t.memTableCreated = time.Now()
 }
 
-// Close 关闭所有分配的资源。
+// Close 关闭所有分配的资源。正在进行的刷新或合并会被取消并回滚。
 func (t *LSMTree) Close() error {
+	t.cancel()
+	t.background.Wait()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	// ctx 已经取消，正在进行的完全合并会很快放弃
+	if done := t.majorDone; done != nil {
+		t.mu.Unlock()
+		<-done
+		t.mu.Lock()
+	}
+
+	if t.pinnedLog != nil {
+		if err := t.pinnedLog.Close(); err != nil {
+			return fmt.Errorf("failed to close file %s: %w", t.pinnedLog.Name(), err)
+		}
+		t.pinnedLog = nil
+	}
+
+	if err := t.manifest.close(); err != nil {
+		return fmt.Errorf("failed to close the manifest: %w", err)
+	}
+
+	if t.wal == nil {
+		return nil
+	}
+
 	if err := t.wal.Close(); err != nil {
 		return fmt.Errorf("failed to close file %s: %w", t.wal.Name(), err)
 	}
@@ -157,107 +617,293 @@ func (t *LSMTree) Close() error {
 
 // Put 将键放入数据库中。
 func (t *LSMTree) Put(key []byte, value []byte) error {
-	if len(key) == 0 {
-		return ErrKeyRequired
-	} else if len(key) > MaxKeySize {
-		return ErrKeyTooLarge
-	} else if len(value) == 0 {
-		return ErrValueRequired
-	} else if uint64(len(value)) > MaxValueSize {
-		return ErrValueTooLarge
+	if t.groupCommit {
+		return t.commit(&commitRequest{key: key, value: value})
 	}
 
-	if err := appendToWAL(t.wal, key, value); err != nil {
-		return fmt.Errorf("failed to append to file %s: %w", t.wal.Name(), err)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.put(key, value, 0)
+}
+
+// PutWithFlags 将键和键的标志放入数据库中。标志与值一起写入 WAL 和磁盘表，合并时保留，
+// 之后没有标志的写入（Put、Append 除外）把标志重置为 0。
+func (t *LSMTree) PutWithFlags(key []byte, value []byte, flags uint32) error {
+	if t.groupCommit {
+		return t.commit(&commitRequest{key: key, value: value, flags: flags})
 	}
 
-	t.memTable.put(key, value)
+	t.mu.Lock()
+	defer t.mu.Unlock()
 
-	if t.memTable.bytes() >= t.memTableThreshold {
-		// 当前 Memtable 已经达到了设定的大小阈值
+	return t.put(key, value, flags)
+}
+
+// Append 把 suffix 追加到键当前的值之后并写回，返回追加后的完整值。
+// 键不存在时视为追加到空值。读取和写回在同一把写锁内完成。
+func (t *LSMTree) Append(key, suffix []byte) ([]byte, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	current, flags, _, err := t.get(key)
+	if err != nil {
+		return nil, err
+	}
+
+	// 追加只修改值，保留键原有的标志
+	value := make([]byte, 0, len(current)+len(suffix))
+	value = append(value, current...)
+	value = append(value, suffix...)
+	if err := t.put(key, value, flags); err != nil {
+		return nil, err
+	}
+
+	return value, nil
+}
+
+// GetOrSet 在键存在时返回它当前的值，loaded 为 true；键不存在（或已删除）时写入 value 并返回它，loaded 为 false。
+// 读取和写入在同一把写锁内完成，多个调用方同时对同一个键调用时只有一个会写入，其余的都读到它写入的值。
+func (t *LSMTree) GetOrSet(key, value []byte) (stored []byte, loaded bool, err error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	current, _, exists, err := t.get(key)
+	if err != nil {
+		return nil, false, err
+	}
+	if exists {
+		return current, true, nil
+	}
+
+	if err := t.put(key, value, 0); err != nil {
+		return nil, false, err
+	}
+
+	return value, false, nil
+}
+
+// put 将键放入数据库中，调用方必须持有写锁。
+func (t *LSMTree) put(key []byte, value []byte, flags uint32) error {
+	if t.readOnly {
+		return ErrReadOnly
+	} else if err := t.checkEntry(key, value); err != nil {
+		return err
+	}
+	if _, pinned := t.pinned.get(key); pinned {
+		return t.putPinned(key, value, flags)
+	}
+
+	if err := t.appendWAL(key, value, flags); err != nil {
+		return err
+	}
+	t.stats.UserBytesWritten += int64(len(key) + len(value))
+	atomic.StoreInt64(&t.lastWrite, time.Now().UnixNano())
+
+	t.memTable.putWithFlags(key, value, flags)
+	t.negatives.remove(key)
+
+	return t.flushIfNeeded()
+}
+
+// appendWAL 把一次写入追加到 WAL 并同步，开启 DisableWAL 时什么也不做，调用方必须持有写锁。
+// 组提交期间只写入不同步，由 commitGroup 在最后统一同步并唤醒 WAL 的订阅。
+func (t *LSMTree) appendWAL(key, value []byte, flags uint32) error {
+	if t.disableWAL {
+		return nil
+	}
+	if t.committingGroup {
+		n, err := writeToWAL(t.wal, key, value, flags)
+		t.stats.WALBytesWritten += int64(n)
+		if err != nil {
+			return fmt.Errorf("failed to append to file %s: %w", t.wal.Name(), err)
+		}
+		t.walSeq++
+		t.walUnsynced = true
+		return nil
+	}
+
+	n, err := appendToWAL(t.wal, key, value, flags)
+	t.stats.WALBytesWritten += int64(n)
+	if err != nil {
+		return fmt.Errorf("failed to append to file %s: %w", t.wal.Name(), err)
+	}
+	t.stats.WALSyncs++
+	t.walSeq++
+	t.notifyWALAppended()
+	return nil
+}
+
+// flushIfNeeded 在写入内存表之后检查阈值，按需转换内存表、刷新和合并磁盘表，调用方必须持有写锁。
+func (t *LSMTree) flushIfNeeded() error {
+	if t.memTableFull() {
+		// 当前 Memtable 已经达到了设定的大小或数量阈值
 		// 将当前的 Memtable 转为只读并添加到 immutableMemtables
 		t.immutableMemtables = append(t.immutableMemtables, t.memTable)
+		t.adaptMemTableThreshold()
 		// 创建一个新的 Memtable 来继续接收写入
 		t.refreshMemTable()
 	}
 	//不可变内存表数量超过限制的时候进行合并，写入磁盘
+	// 刷新之后会清除整个 WAL，因此当前内存表也要一起刷新：之前的刷新失败时不可变内存表留在队列中，
+	// 之后的写入进入了非空的当前内存表，只刷新不可变内存表会丢掉这些写入在 WAL 中的记录
+	flushed := false
 	if len(t.immutableMemtables) >= t.immutableMemtableMaxNum {
-		err := t.compactImmutableMemtable()
+		err := t.flushAll()
 		if err != nil {
 			return err
 		}
+		flushed = true
 	}
-	if t.diskTableNum >= t.diskTableNumThreshold {
-		oldest := t.maxDiskTableIndex - t.diskTableNum + 1
-		merged := false
-		updateIndexMap := make(map[string]string)
-		// 遍历所有可能的相邻表对
-		for i := oldest; i < t.maxDiskTableIndex; i++ {
-			a := i
-			b := i + 1
-
-			aPath := path.Join(t.dbDir, fmt.Sprintf("%d-%s", a, diskTableDataFileName))
-			bPath := path.Join(t.dbDir, fmt.Sprintf("%d-%s", b, diskTableDataFileName))
-
-			aSize, err := GetFileSize(aPath)
-			if err != nil {
-				continue // 文件不存在，跳过
-			}
-
-			bSize, err := GetFileSize(bPath)
-			if err != nil {
-				continue
-			}
-
-			if aSize+bSize > defaultSSTableSize {
-				aPrefix := strconv.Itoa(a) + "-"
-				bPrefix := strconv.Itoa(b) + "-"
-				updateIndexMap[aPrefix] = bPrefix
-				continue
-			}
-
-			// 合并表对
-			if err := mergeDiskTables(t.dbDir, a, b, t.sparseKeyDistance); err != nil {
-				return fmt.Errorf("failed to merge disk tables %d and %d: %w", a, b, err)
-			}
-
-			// 更新元数据
-			newDiskTableNum := t.diskTableNum - 1
-			if err := updateDiskTableMeta(t.dbDir, newDiskTableNum, t.maxDiskTableIndex); err != nil {
-				return fmt.Errorf("failed to update disk table meta: %w", err)
-			}
-			for aPrefix, bPrefix := range updateIndexMap {
-				err := renameDiskTable(t.dbDir, aPrefix, bPrefix)
-				if err != nil {
-					return err
-				}
-			}
-			t.diskTableNum = newDiskTableNum
-			merged = true
-			break
+	if !flushed {
+		var err error
+		if flushed, err = t.enforceMemoryLimit(); err != nil {
+			return err
 		}
+	}
+	// 策略找不到可以合并的磁盘表时（例如相邻的磁盘表合并后都会超过大小上限）保留它们，写入已经成功
+	if !t.compactionNeeded(flushed) || t.deferCompaction() {
+		return nil
+	}
+	if !t.compactionDeferred.IsZero() {
+		// 推迟的时间已经用完
+		return t.compactDeferred()
+	}
+	return t.compactDiskTables(t.ctx)
+}
 
-		if !merged {
-			return fmt.Errorf("all adjacent disk table pairs exceed 64MB and cannot be merged")
-		}
+// memoryUsage 返回内存表和不可变内存表占用的内存估计。
+func (t *LSMTree) memoryUsage() int {
+	usage := t.memTable.memory()
+	for _, table := range t.immutableMemtables {
+		usage += table.memory()
 	}
+	return usage
+}
 
-	return nil
+// enforceMemoryLimit 在内存占用达到 memoryLimit 时同步刷新所有内存表，返回是否刷新，调用方必须持有写锁。
+func (t *LSMTree) enforceMemoryLimit() (bool, error) {
+	if t.memoryLimit <= 0 || t.memoryUsage() < t.memoryLimit {
+		return false, nil
+	}
+	if err := t.flushAll(); err != nil {
+		return false, err
+	}
+	return true, nil
 }
 
-func (t *LSMTree) compactImmutableMemtable() error {
+// Flush 把内存表和所有不可变内存表刷新为一个磁盘表，没有尚未刷新的写入时什么也不做。
+// 开启 DisableWAL 时，只有刷新之后的写入才能在重新打开后读到。
+func (t *LSMTree) Flush() error {
 	t.mu.Lock()
 	defer t.mu.Unlock()
-	merged := NewSkipList(16)
+
+	if t.readOnly {
+		return ErrReadOnly
+	}
+	if t.memTable.size() == 0 && len(t.immutableMemtables) == 0 {
+		return nil
+	}
+	return t.flushAll()
+}
+
+// flushAll 把内存表和所有不可变内存表刷新为一个磁盘表，调用方必须持有写锁。
+func (t *LSMTree) flushAll() error {
+	if t.memTable.size() > 0 {
+		t.immutableMemtables = append(t.immutableMemtables, t.memTable)
+		t.refreshMemTable()
+	}
+	return t.compactImmutableMemtable()
+}
+
+// memTableFull 判断当前 MemTable 是否达到字节阈值或键数量上限。
+func (t *LSMTree) memTableFull() bool {
+	if t.memTable.bytes() >= t.memTableThreshold {
+		return true
+	}
+	return t.memTableMaxEntries > 0 && t.memTable.size() >= t.memTableMaxEntries
+}
+
+// compactDiskTables 执行合并策略给出的第一个计划以减少磁盘表数量，调用方必须持有写锁。
+// 完全合并进行期间什么也不做，详见 MajorCompact。
+// ctx 被取消时，正在进行的合并会被回滚，数据库保持合并前的状态。
+func (t *LSMTree) compactDiskTables(ctx context.Context) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	// 完全合并正在读取所有磁盘表，结束后磁盘表只剩一个
+	if t.majorDone != nil {
+		return nil
+	}
+
+	plans := t.compactionStrategy.Plan(t.tableInfos())
+	if len(plans) == 0 {
+		return fmt.Errorf("compaction strategy found no disk tables to merge")
+	}
+	plan := plans[0]
+	if len(plan.Inputs) != 2 || plan.Inputs[1] != plan.Inputs[0]+1 {
+		return fmt.Errorf("unsupported compaction plan %v: inputs must be two adjacent disk tables", plan.Inputs)
+	}
+	return t.compactPair(ctx, plan.Inputs[0], plan.Inputs[1])
+}
+
+// compactPair 把编号相邻的磁盘表 a 和 b（b = a+1）合并为编号为 b 的磁盘表，调用方必须持有写锁。
+func (t *LSMTree) compactPair(ctx context.Context, a, b int) error {
+	// 合并会替换和移动磁盘表，无论成功与否都丢弃缓存的稀疏索引
+	defer t.sparseIndexes.clear()
+
+	if err := t.checkFreeSpace(diskTableSize(t.dbDir, a) + diskTableSize(t.dbDir, b)); err != nil {
+		return fmt.Errorf("failed to merge disk tables %d and %d: %w", a, b, err)
+	}
+
+	if err := t.checkPendingCompaction(); err != nil {
+		return err
+	}
+
+	// 合并表对。只有 a 是最旧的磁盘表时，没有更旧的表可能包含被删除的键，墓碑才可以丢弃
+	oldest := t.maxDiskTableIndex - t.diskTableNum + 1
+	processed, finish := t.startCompactionProgress(OpCompaction, []int{a, b}, b, nil)
+	written, err := writeMergedDiskTable(ctx, t.dbDir, []int{b, a}, t.sparseKeyDistance, t.tableFormat(), a == oldest, processed)
+	finish(err == nil)
+	if err != nil {
+		return fmt.Errorf("failed to merge disk tables %d and %d: %w", a, b, err)
+	}
+	t.stats.CompactionBytesWritten += int64(written)
+	t.resetReadAmplification()
+
+	// 提交后合并输出替换 b，比 a 更旧的磁盘表依次后移一位，填补 a 留下的空缺
+	if err := t.commitCompaction(compactionEdit{inputs: []int{b, a}, output: b}); err != nil {
+		return fmt.Errorf("failed to merge disk tables %d and %d: %w", a, b, err)
+	}
+	t.notify(func(l EventListener) {
+		l.OnCompaction([]int{a, b}, b)
+	})
+
+	return nil
+}
+
+// compactionTriggered 判断合并策略是否要求在磁盘表总数未达到阈值时合并，详见 CompactionTrigger。
+func (t *LSMTree) compactionTriggered() bool {
+	trigger, ok := t.compactionStrategy.(CompactionTrigger)
+	return ok && trigger.ShouldCompact(t.tableInfos())
+}
+
+// compactImmutableMemtable 将所有不可变内存表合并刷新到一个磁盘表，调用方必须持有写锁。
+// 开启 FlushMemtablesSeparately 时每个不可变内存表刷新为各自的磁盘表。
+func (t *LSMTree) compactImmutableMemtable() error {
+	if t.flushSeparately {
+		return t.flushImmutableMemtablesSeparately()
+	}
+	merged := newMemTable()
 	for _, list := range t.immutableMemtables {
 		l := list.data
 		current := l.head.next[0]
 		for current != nil {
-			merged.Insert(current.key, current.value)
+			merged.putWithFlags(current.key, current.value, list.getFlags(current.key))
 			current = current.next[0]
 		}
 	}
-	err := t.flushMemTable(&memTable{data: merged})
+	err := t.flushMemTable(t.ctx, merged)
 	if err != nil {
 		return err
 	}
@@ -267,39 +913,166 @@ func (t *LSMTree) compactImmutableMemtable() error {
 
 // Get 从数据库中获取键的值。
 func (t *LSMTree) Get(key []byte) ([]byte, bool, error) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	value, _, exists, err := t.get(key)
+	return value, exists, err
+}
+
+// GetWithFlags 从数据库中获取键的值和键的标志，没有设置过标志的键返回 0。
+func (t *LSMTree) GetWithFlags(key []byte) ([]byte, uint32, bool, error) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	return t.get(key)
+}
+
+// get 从数据库中获取键的值和标志，调用方必须持有读锁或写锁。
+// 已删除的键（值为 nil 的墓碑）视为不存在。
+func (t *LSMTree) get(key []byte) ([]byte, uint32, bool, error) {
+	value, flags, _, exists, err := t.getWithSource(key)
+	return value, flags, exists, err
+}
+
+// getWithSource 与 get 相同，同时返回给出结果的层级，调用方必须持有读锁或写锁。
+func (t *LSMTree) getWithSource(key []byte) ([]byte, uint32, ReadSource, bool, error) {
+	if value, pinned := t.pinned.get(key); pinned {
+		t.recordRead(ReadFromPinned, 0)
+		return value, t.pinned.getFlags(key), ReadSource{Tier: ReadFromPinned}, true, nil
+	}
 	value, exists := t.memTable.get(key)
 	if exists {
-		return value, value != nil, nil
+		t.recordRead(ReadFromMemTable, 0)
+		return value, t.memTable.getFlags(key), ReadSource{Tier: ReadFromMemTable}, value != nil, nil
+	}
+	for i := len(t.immutableMemtables) - 1; i >= 0; i-- {
+		table := t.immutableMemtables[i]
+		if value, exists := table.get(key); exists {
+			t.recordRead(ReadFromImmutableMemTable, 0)
+			return value, table.getFlags(key), ReadSource{Tier: ReadFromImmutableMemTable}, value != nil, nil
+		}
 	}
-	value, exists, err := t.SearchInImmutableMemtable(key)
+	if t.negatives.absent(key) {
+		t.recordRead(ReadFromNegativeCache, 0)
+		return nil, 0, ReadSource{Tier: ReadFromNegativeCache}, false, nil
+	}
+	value, flags, exists, index, tables, err := t.searchDiskTables(key)
+	source := ReadSource{Tier: ReadNotFound}
 	if exists {
-		return value, value != nil, nil
+		source = ReadSource{Tier: ReadFromDiskTable, DiskTable: index}
 	}
-	value, exists, err = searchInDiskTables(t.dbDir, t.maxDiskTableIndex, key)
+	t.recordRead(source.Tier, tables)
 	if err != nil {
-		return nil, false, fmt.Errorf("failed to search in DiskTables: %w", err)
+		return nil, 0, ReadSource{}, false, fmt.Errorf("failed to search in DiskTables: %w", err)
+	}
+	if !exists || value == nil {
+		t.negatives.add(key)
 	}
 
-	return value, exists, nil
+	return value, flags, source, exists && value != nil, nil
 }
+
+// searchDiskTables 在当前的磁盘表中查找键，调用方必须持有读锁或写锁。
+// 合并在写锁下替换磁盘表，本实例的查找不会遇到被替换的表；但以只读方式打开、由另一个进程写入的目录中，
+// 磁盘表可能在查找期间被那个进程的合并移走。此时稍等后重新读取清单，在新的磁盘表集合中重新查找，
+// 而不是跳过消失的表：它的记录已经合并到其他磁盘表中，跳过它可能返回更旧的值。
+// 合并在追加编辑之后才移动文件，移动完成之前清单与目录不一致，因此清单没有变化时同样重试；
+// 重试用完后文件仍然缺失时返回错误。
+// 返回找到键的磁盘表编号和查找的数量，后者包括所有重试。
+func (t *LSMTree) searchDiskTables(key []byte) ([]byte, uint32, bool, int, int, error) {
+	oldest, maxIndex := t.maxDiskTableIndex-t.diskTableNum+1, t.maxDiskTableIndex
+	total := 0
+	for attempt := 0; ; attempt++ {
+		value, flags, exists, index, tables, err := searchInDiskTables(t.dbDir, t.sparseIndexes, oldest, maxIndex, key, t.verifyChecksums, t.searchParallelism)
+		total += tables
+		if err == nil || !errors.Is(err, fs.ErrNotExist) || attempt == diskTableSearchRetries {
+			return value, flags, exists, index, total, err
+		}
+
+		time.Sleep(diskTableSearchBackoff << attempt)
+		state, manifestErr := readManifest(t.dbDir)
+		if manifestErr != nil {
+			return nil, 0, false, 0, total, err
+		}
+		num, max := state.num, state.max
+		t.logger.Debug("disk table missing during the search, retrying with tables [%d, %d]: %s", max-num+1, max, err)
+		// 合并的输出会重命名为已有的编号，缓存的稀疏索引可能属于被替换的磁盘表
+		t.sparseIndexes.clear()
+		oldest, maxIndex = max-num+1, max
+	}
+}
+
+// SearchInImmutableMemtable 从新到旧在不可变内存表中查找键。
+// 找到墓碑时返回 nil 值和 true，表示键已被删除。
 func (t *LSMTree) SearchInImmutableMemtable(key []byte) ([]byte, bool, error) {
 	tables := t.immutableMemtables
-	for _, table := range tables {
-		value, exists := table.get(key)
+	for i := len(tables) - 1; i >= 0; i-- {
+		value, exists := tables[i].get(key)
 		if exists {
-			return value, value != nil, nil
+			return value, true, nil
 		}
 	}
 	return nil, false, nil
 }
 
-// Delete 根据键从数据库中删除值。
+// checkKey 检查键的长度，写入和删除在写 WAL 之前都要检查。
+func (t *LSMTree) checkKey(key []byte) error {
+	if len(key) == 0 {
+		return ErrKeyRequired
+	} else if len(key) > t.keySizeLimit {
+		return ErrKeyTooLarge
+	}
+	return nil
+}
+
+// checkEntry 检查写入的键和值的大小。
+func (t *LSMTree) checkEntry(key, value []byte) error {
+	if err := t.checkKey(key); err != nil {
+		return err
+	} else if len(value) == 0 {
+		return ErrValueRequired
+	} else if len(value) > t.valueSizeLimit {
+		return ErrValueTooLarge
+	}
+	return nil
+}
+
+// Delete 根据键从数据库中删除值。键的长度与 Put 一样检查。
 func (t *LSMTree) Delete(key []byte) error {
-	if err := appendToWAL(t.wal, key, nil); err != nil {
-		return fmt.Errorf("failed to append to file %s: %w", t.wal.Name(), err)
+	if t.groupCommit {
+		return t.commit(&commitRequest{key: key, delete: true})
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return t.delete(key)
+}
+
+// delete 删除键，调用方必须持有写锁。
+func (t *LSMTree) delete(key []byte) error {
+	if t.readOnly {
+		return ErrReadOnly
+	}
+	if err := t.checkKey(key); err != nil {
+		return err
 	}
 
+	if err := t.appendWAL(key, nil, 0); err != nil {
+		return err
+	}
+	t.stats.UserBytesWritten += int64(len(key))
+	atomic.StoreInt64(&t.lastWrite, time.Now().UnixNano())
+
 	t.memTable.delete(key)
+	if err := t.unpinForDelete(key); err != nil {
+		return err
+	}
+
+	if _, err := t.enforceMemoryLimit(); err != nil {
+		return err
+	}
 
 	return nil
 }
@@ -307,33 +1080,75 @@ func (t *LSMTree) Delete(key []byte) error {
 // flushMemTable 将当前的 MemTable 刷新到磁盘并清除它。
 // 该函数期望在同步块中运行，
 // 因此它不使用任何同步机制。
-func (t *LSMTree) flushMemTable(table *memTable) error {
-	newDiskTableNum := t.diskTableNum + 1
-	newDiskTableIndex := t.maxDiskTableIndex + 1
+// 刷新后 WAL 被清除（或归档），调用方必须保证 WAL 中的记录都在 table 中或已经刷新。
+func (t *LSMTree) flushMemTable(ctx context.Context, table *memTable) error {
+	newDiskTableIndex, info, err := t.writeMemTable(ctx, table)
+	if err != nil {
+		return err
+	}
 
-	if err := createDiskTable(table, t.dbDir, newDiskTableIndex, t.sparseKeyDistance); err != nil {
-		return fmt.Errorf("failed to create disk table %d: %w", newDiskTableIndex, err)
+	// 失败时 newWAL 为 nil，t.wal 仍然是打开的旧文件
+	var newWAL *os.File
+	if t.walRetention > 0 {
+		newWAL, err = archiveWAL(t.walDir, t.wal, t.walStart, t.walRetention)
+		if newWAL != nil {
+			t.wal = newWAL
+			t.walStart = t.walSeq
+		}
+		if err != nil {
+			return fmt.Errorf("failed to archive the WAL file: %w", err)
+		}
+	} else {
+		newWAL, err = clearWAL(t.walDir, t.wal)
+		if newWAL != nil {
+			t.wal = newWAL
+		}
+		if err != nil {
+			return fmt.Errorf("failed to clear the WAL file: %w", err)
+		}
 	}
 
-	if err := updateDiskTableMeta(t.dbDir, newDiskTableNum, newDiskTableIndex); err != nil {
-		return fmt.Errorf("failed to update max disk table index %d: %w", newDiskTableIndex, err)
+	t.notify(func(l EventListener) {
+		l.OnFlush(newDiskTableIndex, info)
+	})
+
+	return nil
+}
+
+// writeMemTable 把内存表写为一个新的磁盘表并记录到清单中，返回磁盘表的编号和信息，不清除 WAL。
+// 调用方必须持有写锁。
+func (t *LSMTree) writeMemTable(ctx context.Context, table *memTable) (int, TableInfo, error) {
+	newDiskTableNum := t.diskTableNum + 1
+	newDiskTableIndex := t.maxDiskTableIndex + 1
+
+	if err := t.checkFreeSpace(table.bytes()); err != nil {
+		return 0, TableInfo{}, fmt.Errorf("failed to create disk table %d: %w", newDiskTableIndex, err)
 	}
 
-	newWAL, err := clearWAL(t.dbDir, t.wal)
+	op := t.startOp(OpFlush, 0, nil)
+	info, err := createDiskTable(ctx, table, t.dbDir, newDiskTableIndex, t.sparseKeyDistance, t.tableFormat())
+	t.finishOp(op)
 	if err != nil {
-		return fmt.Errorf("failed to clear the WAL file: %w", err)
+		return 0, TableInfo{}, fmt.Errorf("failed to create disk table %d: %w", newDiskTableIndex, err)
 	}
 
-	t.wal = newWAL
+	t.stats.FlushBytesWritten += int64(info.FileSize)
+
+	// 磁盘表的文件同步到目录之后才能记录到清单中，否则崩溃后清单中的磁盘表可能不存在
+	if err := syncDir(t.dbDir); err != nil {
+		return 0, TableInfo{}, fmt.Errorf("failed to create disk table %d: %w", newDiskTableIndex, err)
+	}
+	if _, err := t.manifest.append(editFlush, newDiskTableIndex); err != nil {
+		return 0, TableInfo{}, fmt.Errorf("failed to add disk table %d to the manifest: %w", newDiskTableIndex, err)
+	}
 	t.diskTableNum = newDiskTableNum
 	t.maxDiskTableIndex = newDiskTableIndex
-
-	return nil
+	return newDiskTableIndex, info, nil
 }
 
-// PrintStatus 打印当前树的状态，包括 memTable 和 immutableMemtables 的信息。
+// PrintStatus 通过日志接口输出当前树的状态，包括 memTable 和 immutableMemtables 的信息。
 func (t *LSMTree) PrintStatus() {
-	fmt.Printf("MemTable: n:%d, b:%d kb:\n", t.memTable.data.num, t.memTable.bytes()/1024)
+	t.logger.Info("MemTable: n:%d, b:%d kb:", t.memTable.data.num, t.memTable.bytes()/1024)
 	// 打印不可读内存表的状态
 	totalImmutableSize := 0
 	totalImmutableCount := 0
@@ -342,7 +1157,7 @@ func (t *LSMTree) PrintStatus() {
 		immutableCount := immutableTable.size() // 获取不可读内存表的 KV 数量
 		totalImmutableSize += immutableSize
 		totalImmutableCount += immutableCount
-		fmt.Printf("immutableTable %d n:%d, b:%d kb:\n", i, immutableCount, immutableSize/1024)
+		t.logger.Info("immutableTable %d n:%d, b:%d kb:", i, immutableCount, immutableSize/1024)
 
 	}
 }
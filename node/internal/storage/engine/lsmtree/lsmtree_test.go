@@ -1,11 +1,16 @@
 package lsmtree
 
 import (
+	"bytes"
+	"context"
 	"errors"
 	"fmt"
 	"io/ioutil"
 	"os"
+	"path"
 	"strconv"
+	"strings"
+	"sync"
 	"testing"
 )
 
@@ -126,6 +131,141 @@ func TestPutForErrors(t *testing.T) {
 	}
 }
 
+// 长度不合法的写入和删除在写 WAL 之前被拒绝
+func TestInvalidWritesSkipWAL(t *testing.T) {
+	dbDir := t.TempDir()
+	tree, err := Open(dbDir, KeySizeLimit(16), ValueSizeLimit(16))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tree.Close()
+
+	large := bytes.Repeat([]byte{'x'}, 17)
+	for _, c := range []struct {
+		write func() error
+		want  error
+	}{
+		{func() error { return tree.Put(nil, []byte("value")) }, ErrKeyRequired},
+		{func() error { return tree.Put(large, []byte("value")) }, ErrKeyTooLarge},
+		{func() error { return tree.Put([]byte("key"), nil) }, ErrValueRequired},
+		{func() error { return tree.Put([]byte("key"), large) }, ErrValueTooLarge},
+		{func() error { return tree.Delete(nil) }, ErrKeyRequired},
+		{func() error { return tree.Delete(large) }, ErrKeyTooLarge},
+		{func() error { _, err := tree.Append(large, []byte("suffix")); return err }, ErrKeyTooLarge},
+		{func() error { _, err := tree.Append([]byte("key"), large); return err }, ErrValueTooLarge},
+		{func() error { _, _, err := tree.GetOrSet(large, []byte("value")); return err }, ErrKeyTooLarge},
+	} {
+		if err := c.write(); !errors.Is(err, c.want) {
+			t.Fatalf("expected %v, but got %v", c.want, err)
+		}
+	}
+
+	info, err := os.Stat(path.Join(dbDir, walFileName))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() != 0 || tree.Stats().WALBytesWritten != 0 {
+		t.Fatalf("expected the WAL to be untouched, but it has %d bytes", info.Size())
+	}
+}
+
+func TestLargeKeys(t *testing.T) {
+	dbDir := t.TempDir()
+	options := []func(*LSMTree){KeySizeLimit(4 << 20), MemTableMaxEntries(4), ImmutableMemtableMaxNum(1), DiskTableNumThreshold(3)}
+
+	// 键远大于默认的 MaxKeySize，并且只在末尾几个字节上不同
+	largeKey := func(size, i int) []byte {
+		key := bytes.Repeat([]byte{'k'}, size)
+		copy(key[size-8:], fmt.Sprintf("%08d", i))
+		return key
+	}
+	var keys [][]byte
+	for i, size := range []int{MaxKeySize + 1, 200 << 10, 1 << 20, 4 << 20} {
+		for j := 0; j < 3; j++ {
+			keys = append(keys, largeKey(size, i*10+j))
+		}
+	}
+
+	tree, err := Open(dbDir, options...)
+	if err != nil {
+		t.Fatalf("failed to open LSM tree %s: %s", dbDir, err)
+	}
+	for i, key := range keys {
+		if err := tree.Put(key, []byte(strconv.Itoa(i))); err != nil {
+			t.Fatalf("failed to put a %d byte key: %s", len(key), err)
+		}
+	}
+	if err := tree.Put(largeKey(4<<20+1, 0), []byte("value")); !errors.Is(err, ErrKeyTooLarge) {
+		t.Fatalf("expected %v, but got %v", ErrKeyTooLarge, err)
+	}
+	if err := tree.Close(); err != nil {
+		t.Fatalf("failed to close: %s", err)
+	}
+
+	// 重新打开后键分布在 WAL、刷新和合并得到的磁盘表中
+	tree, err = Open(dbDir, options...)
+	if err != nil {
+		t.Fatalf("failed to open LSM tree %s: %s", dbDir, err)
+	}
+	defer tree.Close()
+	if tree.diskTableNum == 0 {
+		t.Fatalf("expected some keys to be flushed to disk tables")
+	}
+	for i, key := range keys {
+		value, ok, err := tree.Get(key)
+		if err != nil || !ok || string(value) != strconv.Itoa(i) {
+			t.Fatalf("expected a %d byte key to have value %d, but got %q %v %v", len(key), i, value, ok, err)
+		}
+	}
+
+	// 从一个大键开始的范围扫描需要借助索引中完整的键定位
+	it, err := tree.Scan(keys[4], nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer it.Close()
+	count := 0
+	for it.Next() {
+		if bytes.Compare(it.Key(), keys[4]) < 0 {
+			t.Fatalf("unexpected key of %d bytes before the start", len(it.Key()))
+		}
+		count++
+	}
+	if err := it.Err(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if count == 0 {
+		t.Fatalf("expected keys after the start")
+	}
+	if err := tree.Verify(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	exportPath := path.Join(t.TempDir(), "export")
+	if err := tree.Export(exportPath); err != nil {
+		t.Fatalf("failed to export: %s", err)
+	}
+	imported, err := Open(t.TempDir(), KeySizeLimit(4<<20))
+	if err != nil {
+		t.Fatalf("failed to open: %s", err)
+	}
+	defer imported.Close()
+	if err := imported.Import(exportPath); err != nil {
+		t.Fatalf("failed to import: %s", err)
+	}
+	for i, key := range keys {
+		if value, ok, err := imported.Get(key); err != nil || !ok || string(value) != strconv.Itoa(i) {
+			t.Fatalf("expected an imported %d byte key to have value %d, but got %q %v %v", len(key), i, value, ok, err)
+		}
+	}
+
+	for _, limit := range []int{0, -1, MaxKeySizeLimit + 1} {
+		if _, err := Open(t.TempDir(), KeySizeLimit(limit)); err == nil {
+			t.Fatalf("expected an error for key size limit %d", limit)
+		}
+	}
+}
+
 func TestPut100(t *testing.T) {
 	dbDir, err := ioutil.TempDir(os.TempDir(), "example")
 	if err != nil {
@@ -208,3 +348,943 @@ func TestPut100(t *testing.T) {
 		panic(fmt.Errorf("failed to close: %w", err))
 	}
 }
+
+func TestWarmUp(t *testing.T) {
+	dbDir, err := ioutil.TempDir(os.TempDir(), "example")
+	if err != nil {
+		panic(fmt.Errorf("failed to create %s: %w", dbDir, err))
+	}
+	defer func() {
+		if err := os.RemoveAll(dbDir); err != nil {
+			panic(fmt.Errorf("failed to remove %s: %w", dbDir, err))
+		}
+	}()
+
+	tree, err := Open(dbDir, MemTableThreshold(100))
+	if err != nil {
+		panic(fmt.Errorf("failed to open LSM tree %s: %w", dbDir, err))
+	}
+
+	for i := 1; i <= 1000; i++ {
+		key := strconv.Itoa(i)
+		if err := tree.Put([]byte(key), []byte(key)); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+	if tree.diskTableNum == 0 {
+		t.Fatalf("expected disk tables to be flushed")
+	}
+
+	if err := tree.WarmUp(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	WarmUpTimeout(0)(tree)
+	if err := tree.WarmUp(); !errors.Is(err, ErrWarmUpTimeout) {
+		t.Fatalf("expected %v, but got %v", ErrWarmUpTimeout, err)
+	}
+
+	if err := tree.Close(); err != nil {
+		panic(fmt.Errorf("failed to close: %w", err))
+	}
+}
+
+func TestOpenCreateIfMissing(t *testing.T) {
+	parentDir, err := ioutil.TempDir(os.TempDir(), "example")
+	if err != nil {
+		panic(fmt.Errorf("failed to create %s: %w", parentDir, err))
+	}
+	defer func() {
+		if err := os.RemoveAll(parentDir); err != nil {
+			panic(fmt.Errorf("failed to remove %s: %w", parentDir, err))
+		}
+	}()
+
+	// 目录不存在且不允许创建
+	missingDir := path.Join(parentDir, "missing")
+	if _, err := Open(missingDir, CreateIfMissing(false)); err == nil {
+		t.Fatalf("expected error for missing directory %s", missingDir)
+	}
+
+	// 目录不存在，默认自动创建
+	tree, err := Open(missingDir)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := tree.Close(); err != nil {
+		panic(fmt.Errorf("failed to close: %w", err))
+	}
+	if info, err := os.Stat(missingDir); err != nil || !info.IsDir() {
+		t.Fatalf("expected directory %s to be created", missingDir)
+	}
+
+	// 目录已存在
+	tree, err = Open(missingDir, CreateIfMissing(false))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := tree.Close(); err != nil {
+		panic(fmt.Errorf("failed to close: %w", err))
+	}
+
+	// 路径是文件而不是目录
+	filePath := path.Join(parentDir, "file")
+	if err := os.WriteFile(filePath, []byte("not a directory"), 0600); err != nil {
+		panic(fmt.Errorf("failed to write %s: %w", filePath, err))
+	}
+	if _, err := Open(filePath); !errors.Is(err, ErrNotDirectory) {
+		t.Fatalf("expected %v, but got %v", ErrNotDirectory, err)
+	}
+}
+
+func TestMemTableMaxEntries(t *testing.T) {
+	dbDir, err := ioutil.TempDir(os.TempDir(), "example")
+	if err != nil {
+		panic(fmt.Errorf("failed to create %s: %w", dbDir, err))
+	}
+	defer func() {
+		if err := os.RemoveAll(dbDir); err != nil {
+			panic(fmt.Errorf("failed to remove %s: %w", dbDir, err))
+		}
+	}()
+
+	tree, err := Open(dbDir, MemTableThreshold(1000000), MemTableMaxEntries(10))
+	if err != nil {
+		panic(fmt.Errorf("failed to open LSM tree %s: %w", dbDir, err))
+	}
+
+	for i := 0; i < 25; i++ {
+		if err := tree.Put([]byte{byte(i)}, []byte{byte(i)}); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+
+	if len(tree.immutableMemtables) != 2 {
+		t.Fatalf("expected 2 immutable memtables, but got %d", len(tree.immutableMemtables))
+	}
+	if tree.memTable.size() != 5 {
+		t.Fatalf("expected 5 entries in memtable, but got %d", tree.memTable.size())
+	}
+
+	if err := tree.Close(); err != nil {
+		panic(fmt.Errorf("failed to close: %w", err))
+	}
+}
+
+func TestImmutableMemtableMaxNum(t *testing.T) {
+	dbDir, err := ioutil.TempDir(os.TempDir(), "example")
+	if err != nil {
+		panic(fmt.Errorf("failed to create %s: %w", dbDir, err))
+	}
+	defer func() {
+		if err := os.RemoveAll(dbDir); err != nil {
+			panic(fmt.Errorf("failed to remove %s: %w", dbDir, err))
+		}
+	}()
+
+	if _, err := Open(dbDir, ImmutableMemtableMaxNum(0)); err == nil {
+		t.Fatalf("expected error for immutable memtable max num 0")
+	}
+
+	tree, err := Open(dbDir, MemTableMaxEntries(1), ImmutableMemtableMaxNum(2))
+	if err != nil {
+		panic(fmt.Errorf("failed to open LSM tree %s: %w", dbDir, err))
+	}
+
+	if err := tree.Put([]byte("1"), []byte("1")); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(tree.immutableMemtables) != 1 || tree.diskTableNum != 0 {
+		t.Fatalf("expected 1 immutable memtable and no disk table")
+	}
+
+	if err := tree.Put([]byte("2"), []byte("2")); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(tree.immutableMemtables) != 0 || tree.diskTableNum != 1 {
+		t.Fatalf("expected immutable memtables to be flushed into 1 disk table")
+	}
+
+	if err := tree.Close(); err != nil {
+		panic(fmt.Errorf("failed to close: %w", err))
+	}
+}
+
+func TestImmutableMemtablesNewestFirst(t *testing.T) {
+	dbDir, err := ioutil.TempDir(os.TempDir(), "example")
+	if err != nil {
+		panic(fmt.Errorf("failed to create %s: %w", dbDir, err))
+	}
+	defer func() {
+		if err := os.RemoveAll(dbDir); err != nil {
+			panic(fmt.Errorf("failed to remove %s: %w", dbDir, err))
+		}
+	}()
+
+	tree, err := Open(dbDir, MemTableMaxEntries(1), ImmutableMemtableMaxNum(3))
+	if err != nil {
+		panic(fmt.Errorf("failed to open LSM tree %s: %w", dbDir, err))
+	}
+
+	// 同一个键的两个版本分别进入两个不可变内存表，后写入的版本生效
+	for _, value := range []string{"old", "new"} {
+		if err := tree.Put([]byte("key"), []byte(value)); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+	if len(tree.immutableMemtables) != 2 {
+		t.Fatalf("expected 2 immutable memtables, but got %d", len(tree.immutableMemtables))
+	}
+	if value, ok, err := tree.Get([]byte("key")); err != nil || !ok || string(value) != "new" {
+		t.Fatalf("expected new, but got %s (%v, %v)", value, ok, err)
+	}
+
+	if err := tree.Close(); err != nil {
+		panic(fmt.Errorf("failed to close: %w", err))
+	}
+}
+
+func TestDeleteShadowsOlderValues(t *testing.T) {
+	dbDir, err := ioutil.TempDir(os.TempDir(), "example")
+	if err != nil {
+		panic(fmt.Errorf("failed to create %s: %w", dbDir, err))
+	}
+	defer func() {
+		if err := os.RemoveAll(dbDir); err != nil {
+			panic(fmt.Errorf("failed to remove %s: %w", dbDir, err))
+		}
+	}()
+
+	tree, err := Open(dbDir, ImmutableMemtableMaxNum(2))
+	if err != nil {
+		panic(fmt.Errorf("failed to open LSM tree %s: %w", dbDir, err))
+	}
+
+	// a 和 b 都在磁盘表中
+	for _, key := range []string{"a", "b"} {
+		if err := tree.Put([]byte(key), []byte("v"+key)); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+	if err := tree.flushMemTable(context.Background(), tree.memTable); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	tree.refreshMemTable()
+
+	// 删除 a 之后，墓碑分别在 MemTable 和不可变内存表中时都要覆盖磁盘表中的值
+	if err := tree.Delete([]byte("a")); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if _, ok, err := tree.Get([]byte("a")); err != nil || ok {
+		t.Fatalf("expected a to be deleted in the memtable, but got %v, %v", ok, err)
+	}
+	tree.immutableMemtables = append(tree.immutableMemtables, tree.memTable)
+	tree.refreshMemTable()
+	if _, ok, err := tree.Get([]byte("a")); err != nil || ok {
+		t.Fatalf("expected a to be deleted in the immutable memtable, but got %v, %v", ok, err)
+	}
+
+	// 墓碑刷新到磁盘表后仍然生效
+	if err := tree.compactImmutableMemtable(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if _, ok, err := tree.Get([]byte("a")); err != nil || ok {
+		t.Fatalf("expected a to be deleted in the disk table, but got %v, %v", ok, err)
+	}
+	if value, ok, err := tree.Get([]byte("b")); err != nil || !ok || string(value) != "vb" {
+		t.Fatalf("expected vb, but got %s (%v, %v)", value, ok, err)
+	}
+
+	if err := tree.Close(); err != nil {
+		panic(fmt.Errorf("failed to close: %w", err))
+	}
+}
+
+// TestConcurrentAccess 并发读写，刷新和合并在写入中进行；用 go test -race 运行时检查数据竞争。
+func TestConcurrentAccess(t *testing.T) {
+	dbDir, err := ioutil.TempDir(os.TempDir(), "example")
+	if err != nil {
+		panic(fmt.Errorf("failed to create %s: %w", dbDir, err))
+	}
+	defer func() {
+		if err := os.RemoveAll(dbDir); err != nil {
+			panic(fmt.Errorf("failed to remove %s: %w", dbDir, err))
+		}
+	}()
+
+	tree, err := Open(dbDir, MemTableMaxEntries(16), ImmutableMemtableMaxNum(2))
+	if err != nil {
+		panic(fmt.Errorf("failed to open LSM tree %s: %w", dbDir, err))
+	}
+
+	var wg sync.WaitGroup
+	for w := 0; w < 4; w++ {
+		wg.Add(2)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				key := []byte(fmt.Sprintf("%d-%d", w, i))
+				if err := tree.Put(key, key); err != nil {
+					t.Errorf("unexpected error: %s", err)
+					return
+				}
+				if i%3 == 0 {
+					if err := tree.Delete(key); err != nil {
+						t.Errorf("unexpected error: %s", err)
+						return
+					}
+				}
+			}
+		}(w)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				if _, _, err := tree.Get([]byte(fmt.Sprintf("%d-%d", w, i))); err != nil {
+					t.Errorf("unexpected error: %s", err)
+					return
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	for w := 0; w < 4; w++ {
+		for i := 0; i < 200; i++ {
+			key := []byte(fmt.Sprintf("%d-%d", w, i))
+			value, ok, err := tree.Get(key)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if ok != (i%3 != 0) || (ok && !bytes.Equal(value, key)) {
+				t.Fatalf("unexpected value for %s: %s (%v)", key, value, ok)
+			}
+		}
+	}
+
+	if err := tree.Close(); err != nil {
+		panic(fmt.Errorf("failed to close: %w", err))
+	}
+}
+
+func TestVerifyChecksums(t *testing.T) {
+	dbDir, err := ioutil.TempDir(os.TempDir(), "example")
+	if err != nil {
+		panic(fmt.Errorf("failed to create %s: %w", dbDir, err))
+	}
+	defer func() {
+		if err := os.RemoveAll(dbDir); err != nil {
+			panic(fmt.Errorf("failed to remove %s: %w", dbDir, err))
+		}
+	}()
+
+	tree, err := Open(dbDir, MemTableMaxEntries(1), ImmutableMemtableMaxNum(1))
+	if err != nil {
+		panic(fmt.Errorf("failed to open LSM tree %s: %w", dbDir, err))
+	}
+	if err := tree.Put([]byte("key"), []byte("value")); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := tree.Verify(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	// 篡改磁盘表数据文件中值的最后一个字节
+	dataPath := path.Join(dbDir, "0-"+diskTableDataFileName)
+	data, err := os.ReadFile(dataPath)
+	if err != nil {
+		panic(fmt.Errorf("failed to read %s: %w", dataPath, err))
+	}
+	data[len(data)-1] ^= 0xff
+	if err := os.WriteFile(dataPath, data, 0600); err != nil {
+		panic(fmt.Errorf("failed to write %s: %w", dataPath, err))
+	}
+
+	if _, _, err := tree.Get([]byte("key")); !errors.Is(err, ErrChecksumMismatch) {
+		t.Fatalf("expected %v, but got %v", ErrChecksumMismatch, err)
+	}
+	if err := tree.Verify(); !errors.Is(err, ErrChecksumMismatch) {
+		t.Fatalf("expected %v, but got %v", ErrChecksumMismatch, err)
+	}
+
+	VerifyChecksums(false)(tree)
+	if _, ok, err := tree.Get([]byte("key")); err != nil || !ok {
+		t.Fatalf("expected unverified read to succeed, but got %v", err)
+	}
+	if err := tree.Verify(); !errors.Is(err, ErrChecksumMismatch) {
+		t.Fatalf("expected %v, but got %v", ErrChecksumMismatch, err)
+	}
+
+	if err := tree.Close(); err != nil {
+		panic(fmt.Errorf("failed to close: %w", err))
+	}
+}
+
+func BenchmarkGetVerifyChecksums(b *testing.B) {
+	for _, verify := range []bool{true, false} {
+		b.Run(fmt.Sprintf("verify=%t", verify), func(b *testing.B) {
+			dbDir := b.TempDir()
+			tree, err := Open(dbDir, VerifyChecksums(verify), MemTableThreshold(64*1024))
+			if err != nil {
+				b.Fatalf("failed to open LSM tree %s: %s", dbDir, err)
+			}
+			defer tree.Close()
+
+			value := bytes.Repeat([]byte("v"), 1024)
+			count := 1000
+			for i := 0; i < count; i++ {
+				if err := tree.Put([]byte(fmt.Sprintf("key%06d", i)), value); err != nil {
+					b.Fatalf("unexpected error: %s", err)
+				}
+			}
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, _, err := tree.Get([]byte(fmt.Sprintf("key%06d", i%count))); err != nil {
+					b.Fatalf("unexpected error: %s", err)
+				}
+			}
+		})
+	}
+}
+
+func TestDisableWAL(t *testing.T) {
+	dbDir := t.TempDir()
+	tree, err := Open(dbDir, DisableWAL(true))
+	if err != nil {
+		t.Fatalf("failed to open LSM tree %s: %s", dbDir, err)
+	}
+
+	if err := tree.Put([]byte("flushed"), []byte("value")); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := tree.Flush(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := tree.Put([]byte("unflushed"), []byte("value")); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := tree.Delete([]byte("flushed")); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if _, ok, _ := tree.Get([]byte("unflushed")); !ok {
+		t.Fatalf("expected unflushed to be readable before reopening")
+	}
+	if size, err := GetFileSize(path.Join(dbDir, walFileName)); err != nil || size != 0 {
+		t.Fatalf("expected an empty WAL, but got %d bytes: %v", size, err)
+	}
+
+	// 模拟崩溃：不刷新直接关闭，内存表中的写入和删除都丢失
+	if err := tree.Close(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	tree, err = Open(dbDir)
+	if err != nil {
+		t.Fatalf("failed to reopen LSM tree %s: %s", dbDir, err)
+	}
+	defer tree.Close()
+
+	if value, ok, err := tree.Get([]byte("flushed")); err != nil || !ok || string(value) != "value" {
+		t.Fatalf("expected the flushed write to survive reopening, but got %q %v %v", value, ok, err)
+	}
+	if _, ok, err := tree.Get([]byte("unflushed")); err != nil || ok {
+		t.Fatalf("expected the unflushed write to be lost, but got %v %v", ok, err)
+	}
+
+	if _, err := Open(t.TempDir(), DisableWAL(true), ArchiveWAL(1)); err == nil {
+		t.Fatalf("expected an error when archiving a disabled WAL")
+	}
+}
+
+func BenchmarkPutDisableWAL(b *testing.B) {
+	for _, disable := range []bool{false, true} {
+		b.Run(fmt.Sprintf("disable=%t", disable), func(b *testing.B) {
+			dbDir := b.TempDir()
+			tree, err := Open(dbDir, DisableWAL(disable), MemTableThreshold(1<<20))
+			if err != nil {
+				b.Fatalf("failed to open LSM tree %s: %s", dbDir, err)
+			}
+			defer tree.Close()
+
+			value := bytes.Repeat([]byte("v"), 128)
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if err := tree.Put([]byte(fmt.Sprintf("key%09d", i)), value); err != nil {
+					b.Fatalf("unexpected error: %s", err)
+				}
+			}
+		})
+	}
+}
+
+func TestAppend(t *testing.T) {
+	dbDir, err := ioutil.TempDir(os.TempDir(), "example")
+	if err != nil {
+		panic(fmt.Errorf("failed to create %s: %w", dbDir, err))
+	}
+	defer func() {
+		if err := os.RemoveAll(dbDir); err != nil {
+			panic(fmt.Errorf("failed to remove %s: %w", dbDir, err))
+		}
+	}()
+
+	tree, err := Open(dbDir)
+	if err != nil {
+		panic(fmt.Errorf("failed to open LSM tree %s: %w", dbDir, err))
+	}
+
+	// 键不存在时追加到空值
+	value, err := tree.Append([]byte("log"), []byte("a"))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if string(value) != "a" {
+		t.Fatalf("expected a, but got %s", value)
+	}
+
+	// 值在 MemTable 中
+	value, err = tree.Append([]byte("log"), []byte("b"))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if string(value) != "ab" {
+		t.Fatalf("expected ab, but got %s", value)
+	}
+
+	// 值在磁盘表中
+	if err := tree.flushMemTable(context.Background(), tree.memTable); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	tree.refreshMemTable()
+	value, err = tree.Append([]byte("log"), []byte("c"))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if string(value) != "abc" {
+		t.Fatalf("expected abc, but got %s", value)
+	}
+	if stored, ok, err := tree.Get([]byte("log")); err != nil || !ok || string(stored) != "abc" {
+		t.Fatalf("expected abc to be stored, but got %s (%v, %v)", stored, ok, err)
+	}
+
+	// 删除后重新从空值开始
+	if err := tree.Delete([]byte("log")); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	value, err = tree.Append([]byte("log"), []byte("d"))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if string(value) != "d" {
+		t.Fatalf("expected d, but got %s", value)
+	}
+
+	if err := tree.Close(); err != nil {
+		panic(fmt.Errorf("failed to close: %w", err))
+	}
+}
+
+func TestGetOrSet(t *testing.T) {
+	dbDir := t.TempDir()
+	tree, err := Open(dbDir, MemTableMaxEntries(10), ImmutableMemtableMaxNum(1))
+	if err != nil {
+		t.Fatalf("failed to open LSM tree %s: %s", dbDir, err)
+	}
+	defer tree.Close()
+
+	stored, loaded, err := tree.GetOrSet([]byte("key"), []byte("a"))
+	if err != nil || loaded || string(stored) != "a" {
+		t.Fatalf("expected a to be set, but got %s (%v, %v)", stored, loaded, err)
+	}
+	stored, loaded, err = tree.GetOrSet([]byte("key"), []byte("b"))
+	if err != nil || !loaded || string(stored) != "a" {
+		t.Fatalf("expected a to be loaded, but got %s (%v, %v)", stored, loaded, err)
+	}
+
+	// 删除后的键视为不存在
+	if err := tree.Delete([]byte("key")); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	stored, loaded, err = tree.GetOrSet([]byte("key"), []byte("c"))
+	if err != nil || loaded || string(stored) != "c" {
+		t.Fatalf("expected c to be set, but got %s (%v, %v)", stored, loaded, err)
+	}
+
+	// 并发调用时每个键只有一个调用方写入，其余的都读到它写入的值
+	const workers = 16
+	for k := 0; k < 20; k++ {
+		key := []byte(fmt.Sprintf("concurrent%d", k))
+		type result struct {
+			stored []byte
+			loaded bool
+		}
+		results := make(chan result, workers)
+		var wg sync.WaitGroup
+		for i := 0; i < workers; i++ {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				stored, loaded, err := tree.GetOrSet(key, []byte(fmt.Sprintf("value%d", i)))
+				if err != nil {
+					t.Errorf("unexpected error: %s", err)
+				}
+				results <- result{stored, loaded}
+			}(i)
+		}
+		wg.Wait()
+		close(results)
+
+		var all []result
+		var winner []byte
+		sets := 0
+		for r := range results {
+			all = append(all, r)
+			if !r.loaded {
+				sets++
+				winner = r.stored
+			}
+		}
+		if sets != 1 {
+			t.Fatalf("expected exactly one GetOrSet to set %s, but got %d", key, sets)
+		}
+		for _, r := range all {
+			if string(r.stored) != string(winner) {
+				t.Fatalf("expected every caller to get %s, but got %s", winner, r.stored)
+			}
+		}
+		value, ok, err := tree.Get(key)
+		if err != nil || !ok || string(value) != string(winner) {
+			t.Fatalf("expected %s to be stored, but got %s (%v, %v)", winner, value, ok, err)
+		}
+	}
+}
+
+func TestWALDir(t *testing.T) {
+	dbDir := t.TempDir()
+	walDir := path.Join(t.TempDir(), "wal")
+
+	tree, err := Open(dbDir, WALDir(walDir), MemTableMaxEntries(10), ImmutableMemtableMaxNum(1))
+	if err != nil {
+		panic(fmt.Errorf("failed to open LSM tree %s: %w", dbDir, err))
+	}
+
+	for i := 0; i < 25; i++ {
+		key := strconv.Itoa(i)
+		if err := tree.Put([]byte(key), []byte(key)); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+	if err := tree.Close(); err != nil {
+		panic(fmt.Errorf("failed to close: %w", err))
+	}
+
+	if _, err := os.Stat(path.Join(walDir, walFileName)); err != nil {
+		t.Fatalf("expected WAL in %s: %s", walDir, err)
+	}
+	if _, err := os.Stat(path.Join(dbDir, walFileName)); !os.IsNotExist(err) {
+		t.Fatalf("expected no WAL in %s", dbDir)
+	}
+
+	// 重新打开后，已刷新的数据和 WAL 中未刷新的数据都能读到
+	tree, err = Open(dbDir, WALDir(walDir))
+	if err != nil {
+		panic(fmt.Errorf("failed to open LSM tree %s: %w", dbDir, err))
+	}
+	if tree.memTable.size() != 5 {
+		t.Fatalf("expected 5 entries recovered from WAL, but got %d", tree.memTable.size())
+	}
+	for i := 0; i < 25; i++ {
+		key := strconv.Itoa(i)
+		value, ok, err := tree.Get([]byte(key))
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if !ok || string(value) != key {
+			t.Fatalf("value is wrong for key %s: %s", key, value)
+		}
+	}
+	if err := tree.Close(); err != nil {
+		panic(fmt.Errorf("failed to close: %w", err))
+	}
+}
+
+// cancelAfterContext 在 Err 被调用 n 次之后返回 context.Canceled，用于在合并中途取消。
+type cancelAfterContext struct {
+	context.Context
+	n int
+}
+
+func (c *cancelAfterContext) Err() error {
+	if c.n <= 0 {
+		return context.Canceled
+	}
+	c.n--
+	return nil
+}
+
+func TestMergeDiskTablesCancel(t *testing.T) {
+	dbDir := t.TempDir()
+
+	tree, err := Open(dbDir, MemTableMaxEntries(10), ImmutableMemtableMaxNum(1), DiskTableNumThreshold(100))
+	if err != nil {
+		panic(fmt.Errorf("failed to open LSM tree %s: %w", dbDir, err))
+	}
+	for i := 0; i < 40; i++ {
+		key := strconv.Itoa(i)
+		if err := tree.Put([]byte(key), []byte(key)); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+	diskTableNum, maxDiskTableIndex := tree.diskTableNum, tree.maxDiskTableIndex
+	if diskTableNum < 2 {
+		t.Fatalf("expected at least 2 disk tables, but got %d", diskTableNum)
+	}
+	if err := tree.Close(); err != nil {
+		panic(fmt.Errorf("failed to close: %w", err))
+	}
+
+	// 写入若干条记录后取消合并
+	ctx := &cancelAfterContext{Context: context.Background(), n: 5}
+	oldest := maxDiskTableIndex - diskTableNum + 1
+	_, err = writeMergedDiskTable(ctx, dbDir, []int{oldest + 1, oldest}, defaultSparseKeyDistance, tableFormat{}, true, nil)
+	if !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected %v, but got %v", context.Canceled, err)
+	}
+
+	entries, err := os.ReadDir(dbDir)
+	if err != nil {
+		panic(fmt.Errorf("failed to read %s: %w", dbDir, err))
+	}
+	for _, entry := range entries {
+		if strings.HasPrefix(entry.Name(), "merge") {
+			t.Fatalf("expected partial merge file %s to be removed", entry.Name())
+		}
+	}
+
+	// 重新打开后，合并前的磁盘表完好无损
+	tree, err = Open(dbDir)
+	if err != nil {
+		panic(fmt.Errorf("failed to open LSM tree %s: %w", dbDir, err))
+	}
+	if tree.diskTableNum != diskTableNum || tree.maxDiskTableIndex != maxDiskTableIndex {
+		t.Fatalf("expected %d disk tables up to %d, but got %d up to %d",
+			diskTableNum, maxDiskTableIndex, tree.diskTableNum, tree.maxDiskTableIndex)
+	}
+	for i := 0; i < 40; i++ {
+		key := strconv.Itoa(i)
+		value, ok, err := tree.Get([]byte(key))
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if !ok || string(value) != key {
+			t.Fatalf("value is wrong for key %s: %s", key, value)
+		}
+	}
+	if err := tree.Close(); err != nil {
+		panic(fmt.Errorf("failed to close: %w", err))
+	}
+}
+
+func TestSearchParallelism(t *testing.T) {
+	dbDir := t.TempDir()
+	tree, err := Open(dbDir, MemTableMaxEntries(10), ImmutableMemtableMaxNum(1), DiskTableNumThreshold(100), SearchParallelism(4))
+	if err != nil {
+		t.Fatalf("failed to open LSM tree %s: %s", dbDir, err)
+	}
+	defer tree.Close()
+
+	// 每一轮覆盖上一轮的值，并删除一部分键，结果分布在多个磁盘表中
+	count := 50
+	rounds := 5
+	for round := 0; round < rounds; round++ {
+		for i := 0; i < count; i++ {
+			key := []byte(fmt.Sprintf("key%03d", i))
+			if i%7 == round {
+				if err := tree.Delete(key); err != nil {
+					t.Fatalf("unexpected error: %s", err)
+				}
+				continue
+			}
+			if err := tree.Put(key, []byte(fmt.Sprintf("%d-%d", round, i))); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+		}
+	}
+	if tree.diskTableNum < 10 {
+		t.Fatalf("expected many disk tables, got %d", tree.diskTableNum)
+	}
+
+	for i := 0; i < count; i++ {
+		key := []byte(fmt.Sprintf("key%03d", i))
+		value, ok, err := tree.Get(key)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if i%7 == rounds-1 {
+			if ok {
+				t.Fatalf("expected %s to be deleted, got %s", key, value)
+			}
+			continue
+		}
+		if expected := fmt.Sprintf("%d-%d", rounds-1, i); !ok || string(value) != expected {
+			t.Fatalf("value is wrong for key %s: %s != %s", key, expected, value)
+		}
+	}
+	if _, ok, err := tree.Get([]byte("missing")); err != nil || ok {
+		t.Fatalf("expected missing key, ok=%v err=%v", ok, err)
+	}
+}
+
+func BenchmarkGetManyDiskTables(b *testing.B) {
+	for _, parallelism := range []int{1, 4, 16} {
+		b.Run(fmt.Sprintf("parallelism=%d", parallelism), func(b *testing.B) {
+			dbDir := b.TempDir()
+			tree, err := Open(dbDir, MemTableMaxEntries(100), ImmutableMemtableMaxNum(1), DiskTableNumThreshold(1000), SearchParallelism(parallelism))
+			if err != nil {
+				b.Fatalf("failed to open LSM tree %s: %s", dbDir, err)
+			}
+			defer tree.Close()
+
+			// 32 个磁盘表，查找最旧表中的键和不存在的键都要遍历所有表
+			count := 3200
+			for i := 0; i < count; i++ {
+				if err := tree.Put([]byte(fmt.Sprintf("key%06d", i)), []byte("value")); err != nil {
+					b.Fatalf("unexpected error: %s", err)
+				}
+			}
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if _, _, err := tree.Get([]byte(fmt.Sprintf("key%06d", i%100))); err != nil {
+					b.Fatalf("unexpected error: %s", err)
+				}
+			}
+		})
+	}
+}
+
+func TestMergeDiskTablesByRecency(t *testing.T) {
+	dbDir := t.TempDir()
+
+	// 编号与新旧无关：2 最新，9 其次，5 最旧
+	tables := []struct {
+		index   int
+		entries map[string]string
+	}{
+		{2, map[string]string{"a": "new", "c": ""}},
+		{9, map[string]string{"a": "mid", "b": "mid", "c": "mid"}},
+		{5, map[string]string{"a": "old", "b": "old", "d": "old"}},
+	}
+	for _, table := range tables {
+		mt := newMemTable()
+		for key, value := range table.entries {
+			if value == "" {
+				mt.delete([]byte(key))
+			} else {
+				mt.put([]byte(key), []byte(value))
+			}
+		}
+		if _, err := createDiskTable(context.Background(), mt, dbDir, table.index, defaultSparseKeyDistance, tableFormat{}); err != nil {
+			t.Fatalf("failed to create disk table %d: %s", table.index, err)
+		}
+	}
+
+	if _, err := writeMergedDiskTable(context.Background(), dbDir, []int{2, 9, 5}, defaultSparseKeyDistance, tableFormat{}, false, nil); err != nil {
+		t.Fatalf("failed to merge: %s", err)
+	}
+	if err := applyCompactionEdit(dbDir, compactionEdit{inputs: []int{2, 9, 5}, output: 9}); err != nil {
+		t.Fatalf("failed to replace the inputs: %s", err)
+	}
+	for _, index := range []int{2, 5} {
+		if _, err := os.Stat(path.Join(dbDir, strconv.Itoa(index)+"-"+diskTableDataFileName)); !os.IsNotExist(err) {
+			t.Fatalf("expected disk table %d to be removed, got %v", index, err)
+		}
+	}
+
+	expected := map[string]string{"a": "new", "b": "mid", "c": "", "d": "old"}
+	for key, want := range expected {
+		value, _, ok, err := searchInDiskTable(dbDir, nil, 9, []byte(key), true)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		// 墓碑保留为 nil 值
+		if !ok || string(value) != want || (want == "") != (value == nil) {
+			t.Fatalf("value is wrong for key %s: %q != %q (ok=%v)", key, want, value, ok)
+		}
+	}
+}
+
+func TestGetWhileAnotherProcessCompacts(t *testing.T) {
+	dbDir := t.TempDir()
+	writer, err := Open(dbDir, MemTableThreshold(100), DiskTableNumThreshold(1000))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer writer.Close()
+
+	const count = 300
+	for i := 0; i < count; i++ {
+		key := strconv.Itoa(i)
+		if err := writer.Put([]byte(key), []byte("v"+key)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := writer.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	// 只读实例模拟另一个进程，它的磁盘表集合在打开时读取，之后不会随写入方的合并更新
+	reader, err := Open(dbDir, ReadOnly())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+	check := func(key string) error {
+		value, exists, err := reader.Get([]byte(key))
+		if err != nil {
+			return err
+		}
+		if !exists || string(value) != "v"+key {
+			return fmt.Errorf("expected %s=v%s, but got %q (%t)", key, key, value, exists)
+		}
+		return nil
+	}
+
+	// 写入方合并期间读取方持续查找，合并删除的磁盘表不应让查找失败
+	done := make(chan error, 1)
+	go func() {
+		done <- writer.MajorCompact()
+	}()
+	for compacting := true; compacting; {
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Fatal(err)
+			}
+			compacting = false
+		default:
+		}
+		for i := 0; i < count; i += 7 {
+			if err := check(strconv.Itoa(i)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	// 合并之后读取方打开时的磁盘表大多已经不存在
+	for i := 0; i < count; i++ {
+		if err := check(strconv.Itoa(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 磁盘表集合没有变化而文件缺失时仍然返回错误
+	prefix := strconv.Itoa(writer.maxDiskTableIndex) + "-"
+	if err := os.Remove(path.Join(dbDir, prefix+diskTableSparseIndexFileName)); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(path.Join(dbDir, prefix+diskTableIndexFileName)); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := writer.Get([]byte("0")); err == nil {
+		t.Fatal("expected an error for the missing disk table")
+	}
+}
@@ -0,0 +1,128 @@
+package lsmtree
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// ErrMajorCompactionRunning 在已经有一次完全合并正在进行时由 MajorCompact 返回。
+var ErrMajorCompactionRunning = errors.New("major compaction already in progress")
+
+// MajorCompact 等同于 MajorCompactContext(context.Background())。
+func (t *LSMTree) MajorCompact() error {
+	return t.MajorCompactContext(context.Background())
+}
+
+// MajorCompactContext 把内存表刷新到磁盘后，将所有磁盘表合并为一个并丢弃所有墓碑，用于在维护窗口内回收空间、减少读取查找的磁盘表。
+//
+// 合并期间不持有锁：读取照常进行，写入和刷新照常进行，合并开始之后刷新的磁盘表比合并的输出更新，不参与这次合并。
+// 其间由策略触发的合并和后台合并暂停，磁盘表数量可能暂时超过阈值。合并结束时在写锁下替换输入的磁盘表。
+//
+// ctx 被取消、通过 CancelOp 取消或 Close 时放弃合并，数据库保持合并前的状态并返回 ctx 的错误；
+// 合并期间调用 DropAll 时同样放弃合并。同一时刻只能进行一次完全合并，否则返回 ErrMajorCompactionRunning。
+func (t *LSMTree) MajorCompactContext(ctx context.Context) error {
+	t.mu.Lock()
+	if t.readOnly {
+		t.mu.Unlock()
+		return ErrReadOnly
+	}
+	if t.majorDone != nil {
+		t.mu.Unlock()
+		return ErrMajorCompactionRunning
+	}
+	if err := t.checkPendingCompaction(); err != nil {
+		t.mu.Unlock()
+		return err
+	}
+	// Close 先取消 ctx 再获取写锁，之后不再修改磁盘表
+	for _, c := range []context.Context{t.ctx, ctx} {
+		if err := c.Err(); err != nil {
+			t.mu.Unlock()
+			return err
+		}
+	}
+	if t.memTable.size() > 0 || len(t.immutableMemtables) > 0 {
+		if err := t.flushAll(); err != nil {
+			t.mu.Unlock()
+			return fmt.Errorf("failed to flush before the major compaction: %w", err)
+		}
+	}
+
+	oldest, newest := t.maxDiskTableIndex-t.diskTableNum+1, t.maxDiskTableIndex
+	if t.diskTableNum == 0 {
+		t.mu.Unlock()
+		return nil
+	}
+	// 从新到旧排列的输入，以及按编号升序排列的输入，后者用于报告进度和通知
+	tables := make([]int, 0, t.diskTableNum)
+	inputs := make([]int, 0, t.diskTableNum)
+	required := 0
+	for index := newest; index >= oldest; index-- {
+		tables = append(tables, index)
+		inputs = append([]int{index}, inputs...)
+		required += diskTableSize(t.dbDir, index)
+	}
+	if err := t.checkFreeSpace(required); err != nil {
+		t.mu.Unlock()
+		return fmt.Errorf("failed to major compact: %w", err)
+	}
+
+	epoch := t.epoch
+	done := make(chan struct{})
+	t.majorDone = done
+	mergeCtx, cancel := context.WithCancel(ctx)
+	processed, finish := t.startCompactionProgress(OpMajorCompaction, inputs, newest, cancel)
+	t.mu.Unlock()
+
+	stop := context.AfterFunc(t.ctx, cancel)
+	written, err := writeMergedDiskTable(mergeCtx, t.dbDir, tables, t.sparseKeyDistance, t.tableFormat(), true, processed)
+	stop()
+	cancel()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	defer func() {
+		t.majorDone = nil
+		close(done)
+	}()
+
+	if err == nil {
+		switch {
+		case t.ctx.Err() != nil:
+			err = t.ctx.Err()
+		case t.epoch != epoch:
+			err = errors.New("the database was dropped during the major compaction")
+		}
+		if err != nil {
+			_ = deleteDiskTables(t.dbDir, mergeDiskTablePrefix)
+		}
+	}
+	if err == nil {
+		err = t.replaceWithMajorCompaction(oldest, newest)
+	}
+	finish(err == nil)
+	if err != nil {
+		return fmt.Errorf("failed to major compact disk tables %d to %d: %w", oldest, newest, err)
+	}
+
+	t.stats.CompactionBytesWritten += int64(written)
+	t.resetReadAmplification()
+	t.notify(func(l EventListener) {
+		l.OnCompaction(inputs, newest)
+	})
+	return nil
+}
+
+// replaceWithMajorCompaction 用合并输出替换编号在 [oldest, newest] 内的磁盘表，调用方必须持有写锁。
+// 替换通过清单提交，中途崩溃时 Open 按清单补完，详见 manifestFileName。
+func (t *LSMTree) replaceWithMajorCompaction(oldest, newest int) error {
+	defer t.sparseIndexes.clear()
+
+	// 合并期间刷新的磁盘表编号都大于 newest，不需要移动
+	edit := compactionEdit{output: newest}
+	for index := newest; index >= oldest; index-- {
+		edit.inputs = append(edit.inputs, index)
+	}
+	return t.commitCompaction(edit)
+}
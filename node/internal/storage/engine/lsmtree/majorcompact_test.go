@@ -0,0 +1,215 @@
+package lsmtree
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// writeMajorCompactionTables 写入多个磁盘表，其中有覆盖和删除的键，返回最终存在的键值
+func writeMajorCompactionTables(t *testing.T, tree *LSMTree) map[string]string {
+	t.Helper()
+	expected := make(map[string]string)
+	for table := 0; table < 5; table++ {
+		for i := 0; i < 100; i++ {
+			key, value := fmt.Sprintf("key%03d", (table*37+i)%200), fmt.Sprintf("value%d-%d", table, i)
+			if err := tree.Put([]byte(key), []byte(value)); err != nil {
+				t.Fatal(err)
+			}
+			expected[key] = value
+		}
+		for i := table; i < 200; i += 9 {
+			key := fmt.Sprintf("key%03d", i)
+			if err := tree.Delete([]byte(key)); err != nil {
+				t.Fatal(err)
+			}
+			delete(expected, key)
+		}
+		if err := tree.Flush(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return expected
+}
+
+func expectContents(t *testing.T, tree *LSMTree, expected map[string]string) {
+	t.Helper()
+	for i := 0; i < 200; i++ {
+		key := fmt.Sprintf("key%03d", i)
+		value, exists, err := tree.Get([]byte(key))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if want, ok := expected[key]; exists != ok || string(value) != want {
+			t.Fatalf("expected %s=%q (exists %t), but got %q (exists %t)", key, want, ok, value, exists)
+		}
+	}
+}
+
+func TestMajorCompact(t *testing.T) {
+	tree, err := Open(t.TempDir(), DiskTableNumThreshold(100))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tree.Close()
+
+	expected := writeMajorCompactionTables(t, tree)
+	// 内存表中未刷新的写入也被合并
+	if err := tree.Put([]byte("key000"), []byte("unflushed")); err != nil {
+		t.Fatal(err)
+	}
+	expected["key000"] = "unflushed"
+	if tables := tree.tableInfos(); len(tables) != 5 {
+		t.Fatalf("expected 5 disk tables, but got %d", len(tables))
+	}
+
+	// 合并期间的读取和写入照常进行
+	var wg sync.WaitGroup
+	stop := make(chan struct{})
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; ; i++ {
+			select {
+			case <-stop:
+				return
+			default:
+			}
+			key := fmt.Sprintf("key%03d", i%200)
+			value, exists, err := tree.Get([]byte(key))
+			if want, ok := expected[key]; err != nil || exists != ok || string(value) != want {
+				t.Errorf("expected %s=%q during the compaction, but got %q (%v)", key, want, value, err)
+				return
+			}
+		}
+	}()
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 100; i++ {
+			if err := tree.Put([]byte("concurrent"+strconv.Itoa(i)), []byte("v")); err != nil {
+				t.Error(err)
+				return
+			}
+		}
+	}()
+
+	if err := tree.MajorCompact(); err != nil {
+		t.Fatal(err)
+	}
+	close(stop)
+	wg.Wait()
+
+	tables := tree.tableInfos()
+	if len(tables) != 1 {
+		t.Fatalf("expected 1 disk table after the major compaction, but got %d", len(tables))
+	}
+	// 墓碑已经丢弃，磁盘表中只有存在的键
+	prefix := strconv.Itoa(tables[0].Index) + "-"
+	format, err := readTableFormat(tree.dbDir, prefix)
+	if err != nil {
+		t.Fatal(err)
+	}
+	it, err := newDataFileIterator(path.Join(tree.dbDir, prefix+diskTableDataFileName), format, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer it.close()
+	// 合并期间的写入可能在合并之前刷新，只统计写入磁盘表时的键
+	records := 0
+	for it.hasNext() {
+		key, value, err := it.next()
+		if err != nil || value == nil {
+			t.Fatalf("expected no tombstones, but got %q=%q (%v)", key, value, err)
+		}
+		if !strings.HasPrefix(string(key), "concurrent") {
+			records++
+		}
+	}
+	if records != len(expected) {
+		t.Fatalf("expected %d keys in the disk table, but got %d", len(expected), records)
+	}
+	expectContents(t, tree, expected)
+	for i := 0; i < 100; i++ {
+		if _, exists, err := tree.Get([]byte("concurrent" + strconv.Itoa(i))); err != nil || !exists {
+			t.Fatalf("expected the write during the compaction to survive, but got %t, %v", exists, err)
+		}
+	}
+}
+
+func TestMajorCompactCancelled(t *testing.T) {
+	dbDir := t.TempDir()
+	tree, err := Open(dbDir, DiskTableNumThreshold(100))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := writeMajorCompactionTables(t, tree)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if err := tree.MajorCompactContext(ctx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected %v, but got %v", context.Canceled, err)
+	}
+	if tables := tree.tableInfos(); len(tables) != 5 {
+		t.Fatalf("expected the 5 disk tables to be kept, but got %d", len(tables))
+	}
+	expectContents(t, tree, expected)
+
+	// 之后的合并和重新打开不受影响
+	if err := tree.MajorCompact(); err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.MajorCompact(); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected %v after Close, but got %v", context.Canceled, err)
+	}
+	tree, err = Open(dbDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tree.Close()
+	if tables := tree.tableInfos(); len(tables) != 1 {
+		t.Fatalf("expected 1 disk table after reopening, but got %d", len(tables))
+	}
+	expectContents(t, tree, expected)
+}
+
+func TestMajorCompactRecoversFromCrash(t *testing.T) {
+	dbDir := t.TempDir()
+	tree, err := Open(dbDir, DiskTableNumThreshold(100))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := writeMajorCompactionTables(t, tree)
+	oldest, newest := tree.maxDiskTableIndex-tree.diskTableNum+1, tree.maxDiskTableIndex
+	if err := tree.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// 模拟替换输入时崩溃：合并已经追加到清单，只移走了最旧的两个输入
+	var tables []int
+	for index := newest; index >= oldest; index-- {
+		tables = append(tables, index)
+	}
+	if _, err := writeMergedDiskTable(context.Background(), dbDir, tables, defaultSparseKeyDistance, tableFormat{}, true, nil); err != nil {
+		t.Fatal(err)
+	}
+	appendTestEdit(t, dbDir, editCompaction, append([]int{newest}, tables...)...)
+	if err := deleteDiskTables(dbDir, strconv.Itoa(oldest)+"-", strconv.Itoa(oldest+1)+"-"); err != nil {
+		t.Fatal(err)
+	}
+
+	tree, err = Open(dbDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tree.Close()
+	expectContents(t, tree, expected)
+}
@@ -0,0 +1,566 @@
+package lsmtree
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// manifestFileName 是清单的文件名。清单是磁盘表集合的编辑日志，是 Open 确定现存磁盘表的唯一依据。
+//
+// 每条编辑带有连续递增的版本号：刷新增加一个磁盘表，合并用输出替换输入，DropAll 删除所有磁盘表。
+// 编辑追加到清单末尾并同步后才生效，这就是刷新和合并的提交点。Open 从头重放清单得到磁盘表的数量和最大编号，
+// 目录中不属于这些磁盘表的文件都是崩溃留下的，由 recoverDiskTables 清理。
+//
+// 合并的文件操作（移走输入、把合并输出重命名到目标编号、后移更旧的磁盘表）在合并的编辑之后进行，
+// 全部完成并同步目录后再追加一条完成的编辑；Open 发现没有完成的合并时按编辑中的输入和输出补完文件操作。
+// 被替换的输入不会立即删除，而是改名为 obsoleteDiskTablePrefix 开头的前缀，
+// 等到提交之前打开的迭代器和 ValueReader 都关闭后才删除，详见 tableReaders。
+//
+// 清单在 Open 时重写为一条快照，编辑超过 manifestMaxEdits 条时同样重写。
+const manifestFileName = "manifest.db"
+
+// manifestMaxEdits 是清单在重写为快照之前最多包含的编辑数量。
+const manifestMaxEdits = 1000
+
+// obsoleteDiskTablePrefix 是被替换、等待删除的磁盘表的前缀，完整的前缀为 obsolete-<版本>-<编号>-。
+const obsoleteDiskTablePrefix = "obsolete-"
+
+// editKind 是清单中编辑的类型。
+type editKind byte
+
+const (
+	// editSnapshot 记录完整的磁盘表集合：[数量][最大编号]，总是清单的第一条编辑。
+	editSnapshot editKind = iota + 1
+	// editFlush 增加一个编号为最大编号加一的磁盘表：[编号]。
+	editFlush
+	// editCompaction 用合并输出替换输入的磁盘表：[输出的编号][输入的编号]...，详见 compactionEdit。
+	editCompaction
+	// editCompactionDone 表示之前的合并的文件操作已经全部完成。
+	editCompactionDone
+	// editDropAll 删除所有磁盘表。
+	editDropAll
+)
+
+// compactionEdit 是一次合并：inputs 中的磁盘表被编号为 output 的合并输出替换，output 是 inputs 中的一个编号。
+// 比被替换的磁盘表更旧的磁盘表在提交后后移，填补空出的编号。
+type compactionEdit struct {
+	inputs []int
+	output int
+}
+
+// manifestState 是重放清单得到的磁盘表集合。
+type manifestState struct {
+	// 最后一条编辑的版本
+	version uint64
+	// 清单中的编辑数量
+	edits int
+	// 磁盘表的数量和最大编号，与 LSMTree 的 diskTableNum 和 maxDiskTableIndex 相同
+	num, max int
+	// 已经提交但文件操作还没有完成的合并，没有时为 nil
+	pending *compactionEdit
+}
+
+// encodeEdit 编码一条编辑：[类型（1 字节）][参数]...，每个参数 8 字节。
+func encodeEdit(kind editKind, args ...int) []byte {
+	data := []byte{byte(kind)}
+	for _, arg := range args {
+		data = append(data, encodeInt(arg)...)
+	}
+	return data
+}
+
+// apply 把版本为 version 的编辑应用到状态上，编辑与状态不符时返回错误。
+func (s *manifestState) apply(version uint64, data []byte) error {
+	if len(data) < 1 || (len(data)-1)%8 != 0 {
+		return fmt.Errorf("invalid edit of %d bytes", len(data))
+	}
+	var args []int
+	for offset := 1; offset < len(data); offset += 8 {
+		args = append(args, decodeInt(data[offset:]))
+	}
+
+	kind := editKind(data[0])
+	if kind == editSnapshot {
+		if s.edits != 0 || len(args) != 2 {
+			return fmt.Errorf("unexpected snapshot %v at version %d", args, version)
+		}
+		s.num, s.max = args[0], args[1]
+	} else {
+		if s.edits == 0 {
+			return fmt.Errorf("the manifest does not start with a snapshot")
+		}
+		if version != s.version+1 {
+			return fmt.Errorf("edit version %d does not follow %d", version, s.version)
+		}
+		switch kind {
+		case editFlush:
+			if len(args) != 1 || args[0] != s.max+1 {
+				return fmt.Errorf("invalid flush of disk table %v after %d", args, s.max)
+			}
+			s.num, s.max = s.num+1, args[0]
+		case editCompaction:
+			if s.pending != nil {
+				return fmt.Errorf("the compaction into disk table %d has not been applied, reopen the database to finish it", s.pending.output)
+			}
+			if len(args) < 2 || len(args)-1 > s.num {
+				return fmt.Errorf("invalid compaction %v of %d disk tables", args, s.num)
+			}
+			s.num -= len(args) - 2
+			s.pending = &compactionEdit{output: args[0], inputs: args[1:]}
+		case editCompactionDone:
+			if len(args) != 0 || s.pending == nil {
+				return fmt.Errorf("no compaction to finish at version %d", version)
+			}
+			s.pending = nil
+		case editDropAll:
+			if len(args) != 0 {
+				return fmt.Errorf("invalid drop %v", args)
+			}
+			s.num, s.max = 0, -1
+		default:
+			return fmt.Errorf("unknown edit %d", kind)
+		}
+	}
+	s.version = version
+	s.edits++
+	return nil
+}
+
+// replayManifest 从头重放清单，返回状态和完整的编辑占用的字节数。
+// 与 WAL 相同，文件末尾写了一半的编辑被忽略，其他位置的损坏返回错误。
+func replayManifest(r io.Reader) (manifestState, int64, error) {
+	var read atomic.Int64
+	cr := &countingReader{r: r, n: &read}
+	var state manifestState
+	var valid int64
+	for {
+		key, value, err := decodeRecord(cr, true)
+		if err == io.EOF || errors.Is(err, io.ErrUnexpectedEOF) {
+			break
+		}
+		if err != nil {
+			return manifestState{}, 0, fmt.Errorf("failed to read edit: %w", err)
+		}
+		if len(key) != 8 {
+			return manifestState{}, 0, fmt.Errorf("the file is corrupted, invalid edit version of %d bytes", len(key))
+		}
+		if err := state.apply(uint64(decodeInt(key)), value); err != nil {
+			return manifestState{}, 0, fmt.Errorf("the file is corrupted: %w", err)
+		}
+		valid = read.Load()
+	}
+	if state.edits == 0 {
+		return manifestState{}, 0, fmt.Errorf("the file is corrupted, no snapshot")
+	}
+	return state, valid, nil
+}
+
+// readManifest 以只读方式重放 dbDir 中的清单，清单不存在时返回的错误满足 os.IsNotExist。
+func readManifest(dbDir string) (manifestState, error) {
+	filePath := path.Join(dbDir, manifestFileName)
+	file, err := os.Open(filePath)
+	if err != nil {
+		return manifestState{}, err
+	}
+	defer file.Close()
+
+	state, _, err := replayManifest(file)
+	if err != nil {
+		return manifestState{}, fmt.Errorf("failed to replay %s: %w", filePath, err)
+	}
+	return state, nil
+}
+
+// writeManifest 原子地把 state 写为只有一条快照的清单：先写入临时文件并同步，再重命名为清单并同步目录，
+// 崩溃后清单要么是旧的要么是新的。快照沿用 state 的版本，state 不能有没有完成的合并。
+func writeManifest(dbDir string, state manifestState) error {
+	filePath := path.Join(dbDir, manifestFileName)
+	tmpPath := filePath + ".tmp"
+	var buf bytes.Buffer
+	if _, err := encode(encodeInt(int(state.version)), encodeEdit(editSnapshot, state.num, state.max), &buf); err != nil {
+		return fmt.Errorf("failed to encode the snapshot: %w", err)
+	}
+
+	tmp, err := os.OpenFile(tmpPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open the file %s: %w", tmpPath, err)
+	}
+	if _, err := tmp.Write(buf.Bytes()); err != nil {
+		_ = tmp.Close()
+		return fmt.Errorf("failed to write %s: %w", tmpPath, err)
+	}
+	if err := tmp.Sync(); err != nil {
+		_ = tmp.Close()
+		return fmt.Errorf("failed to sync %s: %w", tmpPath, err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("failed to close %s: %w", tmpPath, err)
+	}
+	if err := os.Rename(tmpPath, filePath); err != nil {
+		return fmt.Errorf("failed to rename %s: %w", tmpPath, err)
+	}
+	return syncDir(dbDir)
+}
+
+// manifest 是打开的清单，在写锁下使用。以只读方式打开时 file 为 nil，state 是 Open 时重放的结果。
+type manifest struct {
+	dbDir string
+	file  *os.File
+	state manifestState
+	// 完整的编辑占用的字节数，追加失败时截断到这里
+	size int64
+}
+
+// openManifest 打开 dbDir 中的清单并重放，截掉末尾写了一半的编辑，返回截掉的字节数。
+func openManifest(dbDir string) (*manifest, int64, error) {
+	filePath := path.Join(dbDir, manifestFileName)
+	file, err := os.OpenFile(filePath, os.O_RDWR, 0600)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to open the file %s: %w", filePath, err)
+	}
+	state, valid, err := replayManifest(file)
+	if err != nil {
+		_ = file.Close()
+		return nil, 0, fmt.Errorf("failed to replay %s: %w", filePath, err)
+	}
+	end, err := file.Seek(0, io.SeekEnd)
+	if err != nil {
+		_ = file.Close()
+		return nil, 0, fmt.Errorf("failed to seek to the end: %w", err)
+	}
+	if end > valid {
+		if err := file.Truncate(valid); err != nil {
+			_ = file.Close()
+			return nil, 0, fmt.Errorf("failed to truncate the incomplete edit: %w", err)
+		}
+		if err := file.Sync(); err != nil {
+			_ = file.Close()
+			return nil, 0, fmt.Errorf("failed to sync the file: %w", err)
+		}
+	}
+	return &manifest{dbDir: dbDir, file: file, state: state, size: valid}, end - valid, nil
+}
+
+// append 追加一条编辑并同步，返回编辑的版本。失败时清单截断到追加之前，状态不变。
+// 编辑超过 manifestMaxEdits 条并且没有未完成的合并时把清单重写为快照。
+func (m *manifest) append(kind editKind, args ...int) (uint64, error) {
+	if m.file == nil {
+		return 0, ErrReadOnly
+	}
+	version := m.state.version + 1
+	data := encodeEdit(kind, args...)
+	next := m.state
+	if err := next.apply(version, data); err != nil {
+		return 0, err
+	}
+
+	var buf bytes.Buffer
+	if _, err := encode(encodeInt(int(version)), data, &buf); err != nil {
+		return 0, fmt.Errorf("failed to encode the edit: %w", err)
+	}
+	_, err := m.file.WriteAt(buf.Bytes(), m.size)
+	if err == nil {
+		err = m.file.Sync()
+	}
+	if err != nil {
+		// 截掉写了一半的编辑，否则之后追加的编辑会跟在它后面，重放时被一起忽略
+		_ = m.file.Truncate(m.size)
+		return 0, fmt.Errorf("failed to append to %s: %w", m.file.Name(), err)
+	}
+	m.size += int64(buf.Len())
+	m.state = next
+
+	if m.state.edits > manifestMaxEdits && m.state.pending == nil {
+		if err := m.rewrite(); err != nil {
+			return version, err
+		}
+	}
+	return version, nil
+}
+
+// rewrite 把清单重写为一条快照，之后的编辑追加到新的文件上。有未完成的合并时什么也不做。
+func (m *manifest) rewrite() error {
+	if m.state.pending != nil {
+		return nil
+	}
+	state := m.state
+	state.edits = 1
+	if err := writeManifest(m.dbDir, state); err != nil {
+		return err
+	}
+
+	next, _, err := openManifest(m.dbDir)
+	if err != nil {
+		return err
+	}
+	_ = m.file.Close()
+	*m = *next
+	return nil
+}
+
+// close 关闭清单文件。
+func (m *manifest) close() error {
+	if m.file == nil {
+		return nil
+	}
+	err := m.file.Close()
+	m.file = nil
+	return err
+}
+
+// loadManifest 在 Open 时重放清单，得到现存的磁盘表。新数据库还没有清单，创建一个空的清单；
+// 清单不存在但目录中有磁盘表时返回错误，而不是把数据库当成空的。
+func (t *LSMTree) loadManifest() error {
+	if _, err := os.Stat(path.Join(t.dbDir, manifestFileName)); os.IsNotExist(err) {
+		tables, _, err := listDiskTableFiles(t.dbDir)
+		if err != nil {
+			return err
+		}
+		if len(tables) > 0 {
+			return fmt.Errorf("found %d disk tables in %s, but no manifest", len(tables), t.dbDir)
+		}
+		if t.readOnly {
+			t.manifest = &manifest{dbDir: t.dbDir, state: manifestState{max: -1, edits: 1}}
+			t.diskTableNum, t.maxDiskTableIndex = 0, -1
+			return nil
+		}
+		// 先写入格式版本：清单存在而元数据不存在时，非空的 WAL 会被当成版本 1 的数据库
+		if err := updateDiskTableMeta(t.dbDir); err != nil {
+			return err
+		}
+		if err := writeManifest(t.dbDir, manifestState{max: -1, edits: 1}); err != nil {
+			return err
+		}
+	} else if err != nil {
+		return fmt.Errorf("failed to stat the manifest: %w", err)
+	}
+
+	if t.readOnly {
+		state, err := readManifest(t.dbDir)
+		if err != nil {
+			return err
+		}
+		t.manifest = &manifest{dbDir: t.dbDir, state: state}
+	} else {
+		m, dropped, err := openManifest(t.dbDir)
+		if err != nil {
+			return err
+		}
+		if dropped > 0 {
+			t.logger.Warn("truncated an incomplete edit of %d bytes at the end of %s", dropped, m.file.Name())
+		}
+		t.manifest = m
+	}
+	t.diskTableNum, t.maxDiskTableIndex = t.manifest.state.num, t.manifest.state.max
+	return nil
+}
+
+// obsoletePrefix 返回被版本为 version 的编辑替换的编号为 index 的磁盘表等待删除时的前缀。
+func obsoletePrefix(version uint64, index int) string {
+	return obsoleteDiskTablePrefix + strconv.FormatUint(version, 10) + "-" + strconv.Itoa(index) + "-"
+}
+
+// removeObsoleteDiskTables 删除所有等待删除的磁盘表的文件，返回删除的文件数量。只在没有读取者时（Open）调用。
+func removeObsoleteDiskTables(dbDir string) (int, error) {
+	entries, err := os.ReadDir(dbDir)
+	if err != nil {
+		return 0, fmt.Errorf("failed to read directory %s: %w", dbDir, err)
+	}
+	removed := 0
+	for _, entry := range entries {
+		if !strings.HasPrefix(entry.Name(), obsoleteDiskTablePrefix) {
+			continue
+		}
+		filePath := path.Join(dbDir, entry.Name())
+		if err := os.Remove(filePath); err != nil && !os.IsNotExist(err) {
+			return removed, fmt.Errorf("failed to remove %s: %w", filePath, err)
+		}
+		removed++
+	}
+	return removed, nil
+}
+
+// commitCompaction 提交写完的合并输出（前缀为 mergeDiskTablePrefix），调用方必须持有写锁。
+// 先同步目录并追加合并的编辑，追加失败时合并没有提交，删除合并输出。之后把输入改名为等待删除的磁盘表，
+// 把合并输出重命名为 edit.output，后移更旧的磁盘表填补空缺，同步目录后追加完成的编辑。
+// 提交之后的步骤失败时返回错误，Open 会按清单补完它们。
+func (t *LSMTree) commitCompaction(edit compactionEdit) error {
+	oldest := t.maxDiskTableIndex - t.diskTableNum + 1
+	err := syncDir(t.dbDir)
+	var version uint64
+	if err == nil {
+		version, err = t.manifest.append(editCompaction, append([]int{edit.output}, edit.inputs...)...)
+	}
+	if err != nil {
+		_ = deleteDiskTables(t.dbDir, mergeDiskTablePrefix)
+		return fmt.Errorf("failed to commit the compaction: %w", err)
+	}
+	t.diskTableNum = t.manifest.state.num
+
+	replaced := make(map[int]bool)
+	var retired []string
+	for _, index := range edit.inputs {
+		prefix := obsoletePrefix(version, index)
+		if err := renameDiskTable(t.dbDir, strconv.Itoa(index)+"-", prefix); err != nil {
+			t.readers.retire(version, retired)
+			return fmt.Errorf("failed to retire disk table %d: %w", index, err)
+		}
+		retired = append(retired, prefix)
+		replaced[index] = true
+	}
+	t.readers.retire(version, retired)
+
+	if err := renameDiskTable(t.dbDir, mergeDiskTablePrefix, strconv.Itoa(edit.output)+"-"); err != nil {
+		return fmt.Errorf("failed to rename the merged disk table: %w", err)
+	}
+	var live []int
+	for index := t.maxDiskTableIndex; index >= oldest; index-- {
+		if index == edit.output || !replaced[index] {
+			live = append(live, index)
+		}
+	}
+	if _, err := moveDiskTables(t.dbDir, live, t.maxDiskTableIndex); err != nil {
+		return err
+	}
+	if err := syncDir(t.dbDir); err != nil {
+		return err
+	}
+	if _, err := t.manifest.append(editCompactionDone); err != nil {
+		return fmt.Errorf("failed to finish the compaction: %w", err)
+	}
+	return nil
+}
+
+// checkPendingCompaction 在开始合并之前检查上一次合并是否完成：提交之后的文件操作失败时合并输出还在，
+// 新的合并输出会覆盖它，必须重新打开数据库补完。调用方必须持有锁。
+func (t *LSMTree) checkPendingCompaction() error {
+	if pending := t.manifest.state.pending; pending != nil {
+		return fmt.Errorf("the compaction into disk table %d has not been applied, reopen the database to finish it", pending.output)
+	}
+	return nil
+}
+
+// applyCompactionEdit 在 Open 时补完已提交的合并的文件操作，可以重复执行：合并输出的数据文件还在时删除剩余的输入，
+// 再把合并输出移到 edit.output；数据文件已经移走时只把合并输出剩余的文件移过去。
+// 更旧的磁盘表的后移由 recoverDiskTables 补完。
+func applyCompactionEdit(dbDir string, edit compactionEdit) error {
+	tables, merge, err := listDiskTableFiles(dbDir)
+	if err != nil {
+		return err
+	}
+	output := strconv.Itoa(edit.output) + "-"
+
+	if !merge[diskTableDataFileName] {
+		if len(merge) == 0 {
+			return nil
+		}
+		return finishRename(dbDir, mergeDiskTablePrefix, output, merge)
+	}
+
+	// 合并输出还在时更旧的磁盘表还没有后移，输入的编号上只可能是输入本身剩余的文件
+	for _, index := range edit.inputs {
+		if err := removeTableFiles(dbDir, strconv.Itoa(index)+"-", tables[index]); err != nil {
+			return err
+		}
+	}
+	if err := renameDiskTable(dbDir, mergeDiskTablePrefix, output); err != nil {
+		return fmt.Errorf("failed to rename the merged disk table: %w", err)
+	}
+	return nil
+}
+
+// tableReaders 记录正在读取磁盘表的迭代器和 ValueReader，以及被替换后等待它们关闭的磁盘表。
+// 读取者在打开时按当时的清单版本登记，关闭时注销；被版本为 v 的编辑替换的磁盘表只可能被版本小于 v 的读取者使用，
+// 没有这样的读取者时删除。迭代器和 ValueReader 在打开时就打开了需要的文件，等待只是为了不在读取者仍然持有文件时删除它们。
+type tableReaders struct {
+	dbDir  string
+	logger Logger
+
+	mu sync.Mutex
+	// 按登记时的清单版本计数的读取者
+	versions map[uint64]int
+	obsolete []obsoleteTables
+}
+
+// obsoleteTables 是被版本为 version 的编辑替换、等待删除的磁盘表的前缀。
+type obsoleteTables struct {
+	version  uint64
+	prefixes []string
+}
+
+func newTableReaders(dbDir string, logger Logger) *tableReaders {
+	return &tableReaders{dbDir: dbDir, logger: logger, versions: make(map[uint64]int)}
+}
+
+// acquire 登记一个读取清单版本 version 上的磁盘表的读取者，返回注销它的函数，重复调用只注销一次。
+func (r *tableReaders) acquire(version uint64) func() {
+	r.mu.Lock()
+	r.versions[version]++
+	r.mu.Unlock()
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			r.mu.Lock()
+			defer r.mu.Unlock()
+			if r.versions[version]--; r.versions[version] == 0 {
+				delete(r.versions, version)
+			}
+			r.collect()
+		})
+	}
+}
+
+// retire 登记被版本为 version 的编辑替换的磁盘表，没有更早的读取者时立即删除。
+func (r *tableReaders) retire(version uint64, prefixes []string) {
+	if len(prefixes) == 0 {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.obsolete = append(r.obsolete, obsoleteTables{version: version, prefixes: prefixes})
+	r.collect()
+}
+
+// collect 删除不再有读取者的磁盘表，调用方必须持有 r.mu。删除失败的文件留给下一次 Open 清理。
+func (r *tableReaders) collect() {
+	kept := r.obsolete[:0]
+	for _, o := range r.obsolete {
+		inUse := false
+		for version := range r.versions {
+			if version < o.version {
+				inUse = true
+				break
+			}
+		}
+		if inUse {
+			kept = append(kept, o)
+			continue
+		}
+		for _, prefix := range o.prefixes {
+			if err := deleteDiskTables(r.dbDir, prefix); err != nil {
+				r.logger.Warn("failed to remove replaced disk table %s: %s", prefix, err)
+			}
+		}
+	}
+	r.obsolete = kept
+}
+
+// pending 返回等待删除的磁盘表的数量。
+func (r *tableReaders) pending() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	n := 0
+	for _, o := range r.obsolete {
+		n += len(o.prefixes)
+	}
+	return n
+}
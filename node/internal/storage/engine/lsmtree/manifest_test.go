@@ -0,0 +1,387 @@
+package lsmtree
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"testing"
+)
+
+// appendTestEdit 像另一个进程那样打开 dbDir 的清单并追加一条编辑，返回编辑的版本。
+func appendTestEdit(t *testing.T, dbDir string, kind editKind, args ...int) uint64 {
+	t.Helper()
+	m, _, err := openManifest(dbDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.close()
+	version, err := m.append(kind, args...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return version
+}
+
+// obsoleteFiles 返回 dbDir 中等待删除的磁盘表的文件。
+func obsoleteFiles(t *testing.T, dbDir string) []string {
+	t.Helper()
+	files, err := filepath.Glob(path.Join(dbDir, obsoleteDiskTablePrefix+"*"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return files
+}
+
+func TestOpenFollowsManifest(t *testing.T) {
+	options := []func(*LSMTree){MemTableMaxEntries(10), ImmutableMemtableMaxNum(1), DiskTableNumThreshold(100)}
+	prefix := func(index int) string {
+		return strconv.Itoa(index) + "-"
+	}
+	commit := func(t *testing.T, dbDir string, edit compactionEdit) uint64 {
+		return appendTestEdit(t, dbDir, editCompaction, append([]int{edit.output}, edit.inputs...)...)
+	}
+	retire := func(t *testing.T, dbDir string, version uint64, edit compactionEdit) {
+		for _, index := range edit.inputs {
+			if err := renameDiskTable(dbDir, prefix(index), obsoletePrefix(version, index)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := renameDiskTable(dbDir, mergeDiskTablePrefix, prefix(edit.output)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name string
+		// crash 模拟提交 inputs 到 output 的合并时在某一步被杀死，oldest 是最旧的磁盘表
+		crash func(t *testing.T, dbDir string, edit compactionEdit, oldest int)
+		// 重新打开后合并是否生效
+		committed bool
+	}{
+		{
+			name:  "killed before appending the edit",
+			crash: func(t *testing.T, dbDir string, edit compactionEdit, oldest int) {},
+		},
+		{
+			name: "killed between the manifest edit and moving the inputs",
+			crash: func(t *testing.T, dbDir string, edit compactionEdit, oldest int) {
+				commit(t, dbDir, edit)
+			},
+			committed: true,
+		},
+		{
+			name: "killed while retiring the inputs",
+			crash: func(t *testing.T, dbDir string, edit compactionEdit, oldest int) {
+				version := commit(t, dbDir, edit)
+				index := edit.inputs[0]
+				if err := os.Rename(path.Join(dbDir, prefix(index)+diskTableDataFileName), path.Join(dbDir, obsoletePrefix(version, index)+diskTableDataFileName)); err != nil {
+					t.Fatal(err)
+				}
+			},
+			committed: true,
+		},
+		{
+			name: "killed before shifting the older tables",
+			crash: func(t *testing.T, dbDir string, edit compactionEdit, oldest int) {
+				retire(t, dbDir, commit(t, dbDir, edit), edit)
+			},
+			committed: true,
+		},
+		{
+			name: "killed before deleting the replaced tables",
+			crash: func(t *testing.T, dbDir string, edit compactionEdit, oldest int) {
+				retire(t, dbDir, commit(t, dbDir, edit), edit)
+				if err := moveDiskTable(dbDir, prefix(oldest), prefix(oldest+1)); err != nil {
+					t.Fatal(err)
+				}
+				appendTestEdit(t, dbDir, editCompactionDone)
+			},
+			committed: true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dbDir := t.TempDir()
+			tree, err := Open(dbDir, options...)
+			if err != nil {
+				t.Fatal(err)
+			}
+			expected := make(map[string]string)
+			for i := 0; i < 80; i++ {
+				key, value := fmt.Sprintf("key%02d", i%25), fmt.Sprintf("value%d", i)
+				if err := tree.Put([]byte(key), []byte(value)); err != nil {
+					t.Fatal(err)
+				}
+				expected[key] = value
+			}
+			num, max := tree.diskTableNum, tree.maxDiskTableIndex
+			if err := tree.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			// 合并第二和第三旧的磁盘表，合并输出写完后被杀死
+			oldest := max - num + 1
+			edit := compactionEdit{inputs: []int{oldest + 2, oldest + 1}, output: oldest + 2}
+			if _, err := writeMergedDiskTable(context.Background(), dbDir, edit.inputs, defaultSparseKeyDistance, tableFormat{}, false, nil); err != nil {
+				t.Fatal(err)
+			}
+			tt.crash(t, dbDir, edit, oldest)
+
+			tree, err = Open(dbDir, options...)
+			if err != nil {
+				t.Fatalf("failed to open after crash: %s", err)
+			}
+			defer tree.Close()
+
+			want := num
+			if tt.committed {
+				want--
+			}
+			if tree.diskTableNum != want || tree.maxDiskTableIndex != max {
+				t.Fatalf("expected %d disk tables up to %d, but got %d up to %d", want, max, tree.diskTableNum, tree.maxDiskTableIndex)
+			}
+			state, err := readManifest(dbDir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if state.pending != nil || state.edits != 1 || state.num != want || state.max != max {
+				t.Fatalf("expected the manifest to be rewritten with %d tables up to %d, but got %+v", want, max, state)
+			}
+			tables, merge, err := listDiskTableFiles(dbDir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(merge) != 0 || len(tables) != want {
+				t.Fatalf("expected %d disk tables and no merge output on disk, but got %d and %v", want, len(tables), merge)
+			}
+			if files := obsoleteFiles(t, dbDir); len(files) != 0 {
+				t.Fatalf("expected the replaced tables to be deleted, but got %v", files)
+			}
+			if err := tree.Verify(); err != nil {
+				t.Fatal(err)
+			}
+			for key, value := range expected {
+				got, ok, err := tree.Get([]byte(key))
+				if err != nil || !ok || string(got) != value {
+					t.Fatalf("expected %s for %s, but got %s (%v, %v)", value, key, got, ok, err)
+				}
+			}
+		})
+	}
+}
+
+func TestOpenRequiresManifest(t *testing.T) {
+	dbDir := t.TempDir()
+	tree, err := Open(dbDir, MemTableMaxEntries(10), ImmutableMemtableMaxNum(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 30; i++ {
+		if err := tree.Put([]byte(fmt.Sprintf("key%02d", i)), []byte("value")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tree.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// 没有清单时不能把有磁盘表的数据库当成空的
+	if err := os.Remove(path.Join(dbDir, manifestFileName)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Open(dbDir); err == nil {
+		t.Fatal("expected an error without the manifest")
+	}
+}
+
+func TestReplacedTablesOutliveReaders(t *testing.T) {
+	dbDir := t.TempDir()
+	tree, err := Open(dbDir, MemTableMaxEntries(10), ImmutableMemtableMaxNum(1), DiskTableNumThreshold(100))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tree.Close()
+	for i := 0; i < 40; i++ {
+		if err := tree.Put([]byte(fmt.Sprintf("key%02d", i)), []byte(fmt.Sprintf("value%d", i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tree.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	it, err := tree.Scan(nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r, ok, err := tree.OpenValue([]byte("key00"))
+	if err != nil || !ok {
+		t.Fatalf("expected key00 to exist (%v)", err)
+	}
+	if err := tree.MajorCompact(); err != nil {
+		t.Fatal(err)
+	}
+	if tree.diskTableNum != 1 {
+		t.Fatalf("expected 1 disk table after the major compaction, but got %d", tree.diskTableNum)
+	}
+
+	// 合并之后打开的迭代器只读取合并输出，不会推迟删除
+	after, err := tree.Scan(nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer after.Close()
+
+	if files := obsoleteFiles(t, dbDir); len(files) == 0 {
+		t.Fatal("expected the replaced tables to be kept while readers are open")
+	}
+	n := 0
+	for it.Next() {
+		if want := fmt.Sprintf("value%d", n); string(it.Value()) != want {
+			t.Fatalf("expected %s for %s, but got %s", want, it.Key(), it.Value())
+		}
+		n++
+	}
+	if err := it.Err(); err != nil || n != 40 {
+		t.Fatalf("expected 40 keys, but got %d (%v)", n, err)
+	}
+	if err := it.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if files := obsoleteFiles(t, dbDir); len(files) == 0 {
+		t.Fatal("expected the replaced tables to be kept while the value reader is open")
+	}
+
+	value, err := io.ReadAll(r)
+	if err != nil || string(value) != "value0" {
+		t.Fatalf("expected value0, but got %s (%v)", value, err)
+	}
+	if err := r.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if files := obsoleteFiles(t, dbDir); len(files) != 0 {
+		t.Fatalf("expected the replaced tables to be deleted after the readers closed, but got %v", files)
+	}
+	if n := tree.readers.pending(); n != 0 {
+		t.Fatalf("expected no pending deletions, but got %d", n)
+	}
+}
+
+func TestManifestReplay(t *testing.T) {
+	t.Run("incomplete edit at the end", func(t *testing.T) {
+		dbDir := t.TempDir()
+		if err := writeManifest(dbDir, manifestState{max: -1, edits: 1}); err != nil {
+			t.Fatal(err)
+		}
+		appendTestEdit(t, dbDir, editFlush, 0)
+		appendTestEdit(t, dbDir, editFlush, 1)
+
+		var buf bytes.Buffer
+		if _, err := encode(encodeInt(3), encodeEdit(editFlush, 2), &buf); err != nil {
+			t.Fatal(err)
+		}
+		filePath := path.Join(dbDir, manifestFileName)
+		file, err := os.OpenFile(filePath, os.O_WRONLY|os.O_APPEND, 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := file.Write(buf.Bytes()[:buf.Len()-3]); err != nil {
+			t.Fatal(err)
+		}
+		file.Close()
+
+		m, dropped, err := openManifest(dbDir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer m.close()
+		if dropped != int64(buf.Len()-3) {
+			t.Fatalf("expected %d bytes to be dropped, but got %d", buf.Len()-3, dropped)
+		}
+		if m.state.version != 2 || m.state.num != 2 || m.state.max != 1 {
+			t.Fatalf("unexpected state %+v", m.state)
+		}
+		// 截断之后追加的编辑在重放时可见
+		if _, err := m.append(editFlush, 2); err != nil {
+			t.Fatal(err)
+		}
+		if state, err := readManifest(dbDir); err != nil || state.version != 3 || state.num != 3 {
+			t.Fatalf("unexpected state %+v (%v)", state, err)
+		}
+	})
+
+	t.Run("version gap", func(t *testing.T) {
+		var buf bytes.Buffer
+		if _, err := encode(encodeInt(0), encodeEdit(editSnapshot, 0, -1), &buf); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := encode(encodeInt(2), encodeEdit(editFlush, 0), &buf); err != nil {
+			t.Fatal(err)
+		}
+		if _, _, err := replayManifest(&buf); err == nil {
+			t.Fatal("expected an error for a missing version")
+		}
+	})
+
+	t.Run("compaction edits", func(t *testing.T) {
+		state := manifestState{}
+		edits := [][]byte{
+			encodeEdit(editSnapshot, 3, 4),
+			encodeEdit(editCompaction, 4, 4, 3),
+			encodeEdit(editCompactionDone),
+			encodeEdit(editFlush, 5),
+			encodeEdit(editCompaction, 5, 5, 4, 3),
+		}
+		for version, data := range edits {
+			if err := state.apply(uint64(version), data); err != nil {
+				t.Fatalf("failed to apply edit %d: %s", version, err)
+			}
+		}
+		if state.num != 1 || state.max != 5 || state.pending == nil || state.pending.output != 5 {
+			t.Fatalf("unexpected state %+v", state)
+		}
+		// 上一次合并没有完成时不能开始新的合并
+		if err := state.apply(5, encodeEdit(editCompaction, 5, 5)); err == nil {
+			t.Fatal("expected an error for a compaction while another is pending")
+		}
+		if err := state.apply(5, encodeEdit(editDropAll)); err != nil || state.num != 0 || state.max != -1 {
+			t.Fatalf("unexpected state %+v (%v)", state, err)
+		}
+	})
+
+	t.Run("rewrite", func(t *testing.T) {
+		dbDir := t.TempDir()
+		if err := writeManifest(dbDir, manifestState{max: -1, edits: 1}); err != nil {
+			t.Fatal(err)
+		}
+		m, _, err := openManifest(dbDir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer m.close()
+		for i := 0; i < manifestMaxEdits; i++ {
+			if _, err := m.append(editFlush, i); err != nil {
+				t.Fatal(err)
+			}
+		}
+		state, err := readManifest(dbDir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if state.edits != 1 || state.version != manifestMaxEdits || state.num != manifestMaxEdits {
+			t.Fatalf("expected the manifest to be rewritten, but got %+v", state)
+		}
+	})
+
+	t.Run("missing", func(t *testing.T) {
+		if _, err := readManifest(t.TempDir()); !os.IsNotExist(err) {
+			t.Fatalf("expected a missing manifest, but got %v", err)
+		}
+	})
+}
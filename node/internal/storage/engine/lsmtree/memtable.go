@@ -7,35 +7,69 @@ type memTable struct {
 	// 插入到MemTable中的所有键和值的总大小，单位为字节（b在这里应该就是指字节）。
 	b int
 	n int //键值对数量
+	// 非零的键标志，没有记录的键标志为 0
+	flags map[string]uint32
 }
 
 // newMemTable函数用于返回一个MemTable的新实例。
 func newMemTable() *memTable {
-	return &memTable{data: NewSkipList(16), n: 0, b: 0}
+	return &memTable{data: NewSkipList(16), n: 0, b: 0, flags: make(map[string]uint32)}
 }
 
 // put函数用于将键和值插入到表中。
 func (mt *memTable) put(key, value []byte) error {
+	return mt.putWithFlags(key, value, 0)
+}
+
+// putWithFlags函数用于将键、值和键的标志插入到表中，覆盖键之前的标志。
+func (mt *memTable) putWithFlags(key, value []byte, flags uint32) error {
 	mt.data.Insert(key, value)
+	mt.setFlags(key, flags)
 	return nil
 }
 
+// getFlags函数用于返回键的标志，没有标志的键返回0。
+func (mt *memTable) getFlags(key []byte) uint32 {
+	return mt.flags[string(key)]
+}
+
+// setFlags函数用于记录键的标志，只保存非零的标志。
+func (mt *memTable) setFlags(key []byte, flags uint32) {
+	if flags == 0 {
+		delete(mt.flags, string(key))
+	} else {
+		mt.flags[string(key)] = flags
+	}
+}
+
 // get函数用于通过键来获取对应的值。
 func (mt *memTable) get(key []byte) ([]byte, bool) {
 	return mt.data.Search(key)
 }
 
-// delete
+// delete函数用于删除键：插入一个值为nil的墓碑，使其覆盖磁盘表中更旧的值。
 func (mt *memTable) delete(key []byte) error {
-	mt.data.Delete(key)
+	mt.data.Insert(key, nil)
+	mt.setFlags(key, 0)
 	return nil
 }
 
+// remove函数用于从表中移除键，不留下墓碑，返回键是否存在。
+func (mt *memTable) remove(key []byte) bool {
+	mt.setFlags(key, 0)
+	return mt.data.Delete(key)
+}
+
 // bytes函数用于返回插入到MemTable中的所有键和值的总大小，单位为字节。
 func (mt *memTable) bytes() int {
 	return mt.data.size
 }
 
+// memory函数用于返回MemTable占用的内存估计，包括跳表节点的开销，单位为字节。
+func (mt *memTable) memory() int {
+	return mt.data.mem
+}
+
 func (mt *memTable) size() int {
 	return mt.data.num
 }
@@ -44,16 +78,18 @@ func (mt *memTable) size() int {
 func (mt *memTable) clear() {
 	mt.data = NewSkipList(16)
 	mt.b = 0
+	mt.flags = make(map[string]uint32)
 }
 
 // iterator函数用于返回MemTable的迭代器。该迭代器也会遍历已被标记删除的键，不过这些已删除键对应的值为nil。
 func (mt *memTable) iterator() *memTableIterator {
-	return &memTableIterator{mt.data.Iterator()}
+	return &memTableIterator{it: mt.data.Iterator(), flags: mt.flags}
 }
 
 // MemTable迭代器相关结构体定义。
 type memTableIterator struct {
-	it *SkipListIterator
+	it    *SkipListIterator
+	flags map[string]uint32
 }
 
 // hasNext方法用于判断是否还有下一个元素，有则返回true。
@@ -65,3 +101,9 @@ func (it *memTableIterator) hasNext() bool {
 func (it *memTableIterator) next() ([]byte, []byte) {
 	return it.it.Next()
 }
+
+// nextWithFlags方法与next相同，同时返回键的标志。
+func (it *memTableIterator) nextWithFlags() ([]byte, []byte, uint32) {
+	key, value := it.it.Next()
+	return key, value, it.flags[string(key)]
+}
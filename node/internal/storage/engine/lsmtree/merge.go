@@ -1,181 +1,216 @@
 package lsmtree
 
 import (
+	"bufio"
 	"bytes"
+	"context"
 	"fmt"
 	"io"
 	"os"
 	"path"
 	"strconv"
+	"strings"
+	"sync/atomic"
 )
 
-// mergeDiskTables 函数用于合并磁盘表（索引为a和b的磁盘表），
-// 并创建一个新的合并表（索引为b）。
-// 索引a必须小于b，且代表更旧的表。
-func mergeDiskTables(dbDir string, a, b int, sparseKeyDistance int) error {
-	mergePrefix := "merge"
-	aPrefix := strconv.Itoa(a) + "-"
-	bPrefix := strconv.Itoa(b) + "-"
-
-	// 获取索引为a的磁盘表数据文件的完整路径
-	aPath := path.Join(dbDir, aPrefix+diskTableDataFileName)
-	// 为索引为a的磁盘表数据文件实例化一个迭代器，如果失败则返回错误
-	aIt, err := newDataFileIterator(aPath)
-	if err != nil {
-		return fmt.Errorf("为 %s 实例化迭代器失败: %w", aPath, err)
-	}
-	// 确保迭代器最终被关闭，释放相关资源
-	defer aIt.close()
+// mergeDiskTablePrefix 是合并输出在重命名为最终编号之前使用的前缀。
+const mergeDiskTablePrefix = "merge"
 
-	// 获取索引为b的磁盘表数据文件的完整路径
-	bPath := path.Join(dbDir, bPrefix+diskTableDataFileName)
-	// 为索引为b的磁盘表数据文件实例化一个迭代器，如果失败则返回错误
-	bIt, err := newDataFileIterator(bPath)
-	if err != nil {
-		return fmt.Errorf("为 %s 实例化迭代器失败: %w", bPath, err)
-	}
+// writeMergedDiskTable 把 tables 中的磁盘表合并写入前缀为 mergeDiskTablePrefix 的磁盘表，不修改输入的磁盘表。
+// tables 按从新到旧的顺序排列，同一个键出现在多个表中时取排在最前（最新）的值，
+// 新旧只由 tables 中的位置决定，与编号的大小无关。
+// 返回合并写入的字节数，失败或 ctx 被取消时删除写入了一部分的合并文件。合并输出由 commitCompaction 提交。
+// dropTombstones 为 true 时丢弃墓碑，只有输入包含最旧的磁盘表时才可以这样做，
+// 否则更旧的表中被删除的键会重新出现。
+// 输入的磁盘表按各自记录的格式读取，合并后的磁盘表使用 format 的压缩算法和记录编码。
+// processed 不为 nil 时累加从输入的数据文件中读取的字节数，用于报告合并进度。
+func writeMergedDiskTable(ctx context.Context, dbDir string, tables []int, sparseKeyDistance int, format tableFormat, dropTombstones bool, processed *atomic.Int64) (int, error) {
+	mergePrefix := mergeDiskTablePrefix
+
+	// 为每个输入的磁盘表数据文件实例化一个迭代器，如果失败则返回错误
+	its := make([]*dataFileIterator, 0, len(tables))
 	// 确保迭代器最终被关闭，释放相关资源
-	defer bIt.close()
+	defer func() {
+		for _, it := range its {
+			it.close()
+		}
+	}()
+	for _, index := range tables {
+		prefix := strconv.Itoa(index) + "-"
+		tableFormat, err := readTableFormat(dbDir, prefix)
+		if err != nil {
+			return 0, err
+		}
+		dataPath := path.Join(dbDir, prefix+diskTableDataFileName)
+		it, err := newDataFileIterator(dataPath, tableFormat, processed)
+		if err != nil {
+			return 0, fmt.Errorf("为 %s 实例化迭代器失败: %w", dataPath, err)
+		}
+		its = append(its, it)
+	}
 
 	// 创建一个新的磁盘表写入器，用于将合并后的数据写入磁盘，如果失败则返回错误
-	w, err := newDiskTableWriter(dbDir, mergePrefix, sparseKeyDistance)
+	w, err := newDiskTableWriter(dbDir, mergePrefix, sparseKeyDistance, format)
 	if err != nil {
-		return fmt.Errorf("实例化磁盘表写入器失败: %w", err)
-	}
-
-	// 使用迭代器合并磁盘表数据，如果失败则返回错误
-	if err := merge(aIt, bIt, w); err != nil {
-		return fmt.Errorf("合并磁盘表失败: %w", err)
+		return 0, fmt.Errorf("实例化磁盘表写入器失败: %w", err)
 	}
 
-	// 关闭索引为a的磁盘表数据文件对应的迭代器，如果失败则返回错误
-	if err := aIt.close(); err != nil {
-		return fmt.Errorf("关闭 %s 的迭代器失败: %w", aPath, err)
+	// 使用迭代器合并磁盘表数据，如果失败则回滚合并文件并返回错误
+	if err := merge(ctx, its, w, dropTombstones); err != nil {
+		abortDiskTable(w, dbDir, mergePrefix)
+		return 0, fmt.Errorf("合并磁盘表失败: %w", err)
 	}
 
-	// 关闭索引为b的磁盘表数据文件对应的迭代器，如果失败则返回错误
-	if err := bIt.close(); err != nil {
-		return fmt.Errorf("关闭 %s 的迭代器失败: %w", bPath, err)
+	// 将合并后的数据同步到磁盘并关闭写入器，如果失败则回滚合并文件并返回错误
+	if err := w.sync(); err != nil {
+		abortDiskTable(w, dbDir, mergePrefix)
+		return 0, fmt.Errorf("同步合并后的磁盘表失败: %w", err)
 	}
-
-	// 删除索引为a和b的磁盘表，如果失败则返回错误
-	if err := deleteDiskTables(dbDir, aPrefix, bPrefix); err != nil {
-		return fmt.Errorf("删除磁盘表失败: %w", err)
+	if err := w.close(); err != nil {
+		_ = deleteDiskTables(dbDir, mergePrefix)
+		return 0, fmt.Errorf("关闭合并后的磁盘表失败: %w", err)
 	}
 
-	// 将合并后的磁盘表重命名为索引为b的磁盘表的名称，如果失败则返回错误
-	if err := renameDiskTable(dbDir, mergePrefix, bPrefix); err != nil {
-		return fmt.Errorf("重命名合并后的磁盘表失败: %w", err)
+	// 关闭所有迭代器，如果失败则删除合并文件并返回错误
+	for i, it := range its {
+		if err := it.close(); err != nil {
+			_ = deleteDiskTables(dbDir, mergePrefix)
+			return 0, fmt.Errorf("关闭磁盘表 %d 的迭代器失败: %w", tables[i], err)
+		}
 	}
 
-	return nil
+	return w.size(), nil
 }
 
-// merge 函数用于合并来自a和b迭代器的键和值，并使用磁盘表写入器将它们写入磁盘表中。
-func merge(aIt, bIt *dataFileIterator, w *diskTableWriter) error {
-	var aKey, aValue, bKey, bValue []byte
+// merge 函数按键的顺序合并 its 中各迭代器的键和值，并使用磁盘表写入器将它们写入磁盘表中。
+// its 按从新到旧的顺序排列，键相同时写入排在最前的迭代器的值，丢弃其余的。
+// ctx 被取消时停止合并并返回 ctx 的错误。dropTombstones 为 true 时不写入墓碑。
+func merge(ctx context.Context, its []*dataFileIterator, w *diskTableWriter, dropTombstones bool) error {
+	// 每个迭代器当前的键值对和键的标志，键为 nil 表示需要读取下一个
+	keys := make([][]byte, len(its))
+	values := make([][]byte, len(its))
+	flags := make([]uint32, len(its))
 	for {
-		// 如果a的键为空且a迭代器还有下一个元素
-		if aKey == nil && aIt.hasNext() {
-			// 获取a迭代器的下一个键值对，如果失败则返回错误
-			if k, v, err := aIt.next(); err != nil {
-				return fmt.Errorf("获取a的下一个元素失败: %w", err)
-			} else {
-				aKey, aValue = k, v
-			}
+		// 如果合并已被取消，返回错误
+		if err := ctx.Err(); err != nil {
+			return err
 		}
 
-		// 如果b的键为空且b迭代器还有下一个元素
-		if bKey == nil && bIt.hasNext() {
-			// 获取b迭代器的下一个键值对，如果失败则返回错误
-			if k, v, err := bIt.next(); err != nil {
-				return fmt.Errorf("获取b的下一个元素失败: %w", err)
-			} else {
-				bKey, bValue = k, v
+		// 为已消耗的迭代器读取下一个键值对，并找出最小的键，键相同时保留最新的
+		newest := -1
+		for i, it := range its {
+			if keys[i] == nil && it.hasNext() {
+				k, v, f, err := it.nextWithFlags()
+				if err != nil {
+					return fmt.Errorf("获取磁盘表的下一个元素失败: %w", err)
+				}
+				keys[i], values[i], flags[i] = k, v, f
+			}
+			if keys[i] != nil && (newest == -1 || bytes.Compare(keys[i], keys[newest]) < 0) {
+				newest = i
 			}
 		}
 
-		// 如果a和b的键都为空且a和b迭代器都没有下一个元素了，说明已经遍历完，返回nil表示结束
-		if aKey == nil && bKey == nil && !aIt.hasNext() && !bIt.hasNext() {
+		// 所有迭代器都已遍历完，返回nil表示结束
+		if newest == -1 {
 			return nil
 		}
 
-		// 如果a和b的键都不为空
-		if aKey != nil && bKey != nil {
-			// 比较a和b的键
-			cmp := bytes.Compare(aKey, bKey)
-
-			// 如果键相等，由于b是更新的，可以丢弃a
-			if cmp == 0 {
-				// 将b的键值对写入磁盘表，如果写入失败则返回错误
-				if err := w.write(bKey, bValue); err != nil {
-					return fmt.Errorf("写入失败: %w", err)
-				}
-				// 将a和b的键值对都置为空，准备读取下一组
-				aKey, aValue, bKey, bValue = nil, nil, nil, nil
-			} else if cmp > 0 {
-				// 如果a的键大于b的键
-				// 将b的键值对写入磁盘表，并读取b的下一个键
-				if err := w.write(bKey, bValue); err != nil {
-					return fmt.Errorf("写入失败: %w", err)
-				}
-				bKey, bValue = nil, nil
-			} else if cmp < 0 {
-				// 如果a的键小于b的键
-				// 将a的键值对写入磁盘表
-				if err := w.write(aKey, aValue); err != nil {
-					return fmt.Errorf("写入失败: %w", err)
-				}
-				aKey, aValue = nil, nil
-			}
-		} else if aKey != nil {
-			// 如果只有a的键不为空，将a的键值对写入磁盘表，如果写入失败则返回错误
-			if err := w.write(aKey, aValue); err != nil {
+		key, value := keys[newest], values[newest]
+		if !dropTombstones || value != nil {
+			if err := w.write(key, value, flags[newest]); err != nil {
 				return fmt.Errorf("写入失败: %w", err)
 			}
-			aKey, aValue = nil, nil
-		} else {
-			// 如果只有b的键不为空，将b的键值对写入磁盘表，如果写入失败则返回错误
-			if err := w.write(bKey, bValue); err != nil {
-				return fmt.Errorf("写入失败: %w", err)
+		}
+
+		// 丢弃更旧的表中相同的键
+		for i := range its {
+			if keys[i] != nil && bytes.Equal(keys[i], key) {
+				keys[i], values[i] = nil, nil
 			}
-			bKey, bValue = nil, nil
 		}
 	}
 }
 
-// dataFileIterator 结构体允许对数据文件进行简单的迭代操作。
+// dataFileIterator 结构体允许对数据文件进行简单的迭代操作，返回的值已经解压。
 type dataFileIterator struct {
 	dataFile *os.File
-	key      []byte
-	value    []byte
-	end      bool
-	closed   bool
+	// 读取记录的来源，开启预读时是 dataFile 上的 bufio.Reader
+	reader io.Reader
+	// 分开存放值时同一磁盘表的值文件，否则为 nil
+	values *valuesFileReader
+	format tableFormat
+	key    []byte
+	value  []byte
+	flags  uint32
+	end    bool
+	closed bool
+}
+
+// newDataFileIterator 函数用于实例化一个新的数据文件迭代器，format 是数据文件所属磁盘表的格式。
+// 合并总是顺序读取整个数据文件，使用默认大小的预读。processed 不为 nil 时累加从文件读取的字节数。
+func newDataFileIterator(path string, format tableFormat, processed *atomic.Int64) (*dataFileIterator, error) {
+	return newDataFileIteratorAt(path, 0, format, defaultReadAhead, processed)
 }
 
-// newDataFileIterator 函数用于实例化一个新的数据文件迭代器。
-func newDataFileIterator(path string) (*dataFileIterator, error) {
+// newDataFileIteratorAt 函数用于实例化一个从指定偏移量开始的数据文件迭代器。
+// 偏移量必须指向记录的开头。readAhead 是每次从文件预读的字节数，0 表示每条记录直接从文件读取。
+// processed 不为 nil 时累加从文件读取的字节数。分开存放值的磁盘表同时打开旁边的值文件。
+func newDataFileIteratorAt(path string, offset int64, format tableFormat, readAhead int, processed *atomic.Int64) (*dataFileIterator, error) {
 	// 以只读模式打开指定路径的数据文件，如果失败则返回错误
 	dataFile, err := os.OpenFile(path, os.O_RDONLY, 0600)
 	if err != nil {
 		return nil, fmt.Errorf("打开数据文件 %s 失败: %w", path, err)
 	}
 
+	// 定位到起始偏移量，如果失败则返回错误
+	if _, err := dataFile.Seek(offset, io.SeekStart); err != nil {
+		dataFile.Close()
+		return nil, fmt.Errorf("定位失败: %w", err)
+	}
+
 	// 从数据文件中解码出键和值，如果读取失败且不是文件末尾错误，则返回错误
-	key, value, err := decode(dataFile)
+	it := &dataFileIterator{dataFile: dataFile, reader: dataFile, format: format}
+	if processed != nil {
+		it.reader = &countingReader{r: dataFile, n: processed}
+	}
+	if readAhead > 0 {
+		it.reader = bufio.NewReaderSize(it.reader, readAhead)
+	}
+	if format.separateValues {
+		valuesPath := strings.TrimSuffix(path, diskTableDataFileName) + diskTableValuesFileName
+		if it.values, err = openValuesFile(valuesPath, readAhead, processed); err != nil {
+			dataFile.Close()
+			return nil, err
+		}
+	}
+	key, value, flags, err := it.read()
 	if err != nil && err != io.EOF {
+		it.close()
 		return nil, fmt.Errorf("读取失败: %w", err)
 	}
 	// 如果错误是文件末尾（io.EOF），则表示已经到文件末尾了
-	end := err == io.EOF
-
-	return &dataFileIterator{
-		dataFile,
-		key,
-		value,
-		end,
-		false,
-	}, nil
+	it.key, it.value, it.flags, it.end = key, value, flags, err == io.EOF
+
+	return it, nil
+}
+
+// read 方法用于从数据文件中解码下一条记录并解压其中的值，同时返回键的标志。
+func (it *dataFileIterator) read() ([]byte, []byte, uint32, error) {
+	key, value, flags, err := it.format.records.decode(it.reader, true)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	if it.values != nil {
+		if value, err = it.values.read(value); err != nil {
+			return nil, nil, 0, err
+		}
+	}
+	value, err = it.format.codec.decompress(value)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	return key, value, flags, nil
 }
 
 // hasNext 方法用于判断是否还有下一个元素。
@@ -185,12 +220,18 @@ func (it *dataFileIterator) hasNext() bool {
 
 // next 方法用于返回当前的键和值，并将迭代器位置前进到下一个元素。
 func (it *dataFileIterator) next() ([]byte, []byte, error) {
-	key, value := it.key, it.value
+	key, value, _, err := it.nextWithFlags()
+	return key, value, err
+}
+
+// nextWithFlags 方法与 next 相同，同时返回键的标志。
+func (it *dataFileIterator) nextWithFlags() ([]byte, []byte, uint32, error) {
+	key, value, flags := it.key, it.value, it.flags
 
 	// 从数据文件中读取下一个键值对，如果读取失败且不是文件末尾错误，则返回错误
-	nextKey, nextValue, err := decode(it.dataFile)
+	nextKey, nextValue, nextFlags, err := it.read()
 	if err != nil && err != io.EOF {
-		return nil, nil, fmt.Errorf("读取失败: %w", err)
+		return nil, nil, 0, fmt.Errorf("读取失败: %w", err)
 	}
 	// 如果错误是文件末尾（io.EOF），则标记迭代器已到末尾
 	if err == io.EOF {
@@ -200,8 +241,9 @@ func (it *dataFileIterator) next() ([]byte, []byte, error) {
 	// 更新迭代器的当前键和值为刚读取的下一组键值对
 	it.key = nextKey
 	it.value = nextValue
+	it.flags = nextFlags
 
-	return key, value, nil
+	return key, value, flags, nil
 }
 
 // close 方法用于关闭相关联的数据文件。
@@ -210,10 +252,15 @@ func (it *dataFileIterator) close() error {
 		return nil
 	}
 
-	// 关闭数据文件，如果关闭失败则返回错误
+	// 关闭数据文件和值文件，如果关闭失败则返回错误
 	if err := it.dataFile.Close(); err != nil {
 		return fmt.Errorf("关闭失败: %w", err)
 	}
+	if it.values != nil {
+		if err := it.values.close(); err != nil {
+			return fmt.Errorf("关闭失败: %w", err)
+		}
+	}
 
 	it.closed = true
 
@@ -0,0 +1,325 @@
+package lsmtree
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strconv"
+)
+
+// formatVersion 是当前的磁盘格式版本，记录在元数据文件的第一个字节中。
+//
+//	1：记录没有校验和，元数据文件只有磁盘表数量和最大编号。
+//	2：记录带有 CRC32 校验和。
+//	3：磁盘表的集合记录在清单中，元数据文件只有格式版本。
+const formatVersion = 3
+
+// ErrFormatVersion 当数据库的格式版本无法被当前程序打开时返回，
+// 例如数据库由更新的版本写入（不支持降级），或需要升级但以只读方式打开。
+var ErrFormatVersion = errors.New("unsupported format version")
+
+// migrations 按起始版本登记升级步骤，每一步把数据库从版本 v 升级到 v+1。
+// 格式变化时在这里增加一步，Open 会依次执行直到 formatVersion。
+var migrations = map[int]func(t *LSMTree) error{
+	1: migrateV1,
+	2: migrateV2,
+}
+
+// upgrade 在 Open 时把版本为 version 的数据库升级到 formatVersion 并更新元数据。
+func (t *LSMTree) upgrade(version int) error {
+	if version > formatVersion {
+		return fmt.Errorf("database format version %d is newer than supported version %d: %w", version, formatVersion, ErrFormatVersion)
+	}
+	if version == formatVersion {
+		return nil
+	}
+	if t.readOnly {
+		return fmt.Errorf("database format version %d must be upgraded to %d before opening read-only: %w", version, formatVersion, ErrFormatVersion)
+	}
+
+	for v := version; v < formatVersion; v++ {
+		migrate, ok := migrations[v]
+		if !ok {
+			return fmt.Errorf("no migration from format version %d: %w", v, ErrFormatVersion)
+		}
+		if err := migrate(t); err != nil {
+			return fmt.Errorf("failed to migrate from format version %d to %d: %w", v, v+1, err)
+		}
+	}
+
+	return updateDiskTableMeta(t.dbDir)
+}
+
+// migrateV1 用带校验和的记录重写所有磁盘表和 WAL。
+// 已经是新格式的文件会被跳过，因此升级中途崩溃后可以重新执行。
+func migrateV1(t *LSMTree) error {
+	oldest := t.maxDiskTableIndex - t.diskTableNum + 1
+	for index := oldest; index <= t.maxDiskTableIndex; index++ {
+		if err := migrateV1DiskTable(t.dbDir, strconv.Itoa(index)+"-", t.sparseKeyDistance); err != nil {
+			return fmt.Errorf("failed to migrate disk table %d: %w", index, err)
+		}
+	}
+
+	if err := migrateV1WAL(path.Join(t.walDir, walFileName)); err != nil {
+		return fmt.Errorf("failed to migrate WAL: %w", err)
+	}
+
+	return nil
+}
+
+// legacyCompactionMarkerFileName 是版本 2 在合并提交期间写入的合并标记，格式为 [输出的编号][输入的编号]...，
+// 每个编号 8 字节。合并标记存在时合并已经提交，但文件操作可能没有完成。
+const legacyCompactionMarkerFileName = "manifest"
+
+// migrateV2 按版本 2 的规则补完被崩溃打断的刷新和合并，再把元数据中的磁盘表集合写为清单，最后删除合并标记。
+// 版本 2 的元数据在元数据更新之后才写入清单，升级中途崩溃后可以重新执行。
+func migrateV2(t *LSMTree) error {
+	if err := t.recoverV2DiskTables(); err != nil {
+		return err
+	}
+	if err := writeManifest(t.dbDir, manifestState{num: t.diskTableNum, max: t.maxDiskTableIndex, edits: 1}); err != nil {
+		return err
+	}
+
+	filePath := path.Join(t.dbDir, legacyCompactionMarkerFileName)
+	if err := os.Remove(filePath); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("failed to remove %s: %w", filePath, err)
+	}
+	return nil
+}
+
+// readLegacyCompactionMarker 读取版本 2 的合并标记中的编辑，合并标记不存在时返回 false。
+func readLegacyCompactionMarker(dbDir string) (compactionEdit, bool, error) {
+	filePath := path.Join(dbDir, legacyCompactionMarkerFileName)
+	data, err := os.ReadFile(filePath)
+	if os.IsNotExist(err) {
+		return compactionEdit{}, false, nil
+	}
+	if err != nil {
+		return compactionEdit{}, false, fmt.Errorf("failed to read file %s: %w", filePath, err)
+	}
+	if len(data) < 16 || len(data)%8 != 0 {
+		return compactionEdit{}, false, fmt.Errorf("the file %s is corrupted, invalid length %d", filePath, len(data))
+	}
+
+	edit := compactionEdit{output: decodeInt(data)}
+	for offset := 8; offset < len(data); offset += 8 {
+		edit.inputs = append(edit.inputs, decodeInt(data[offset:]))
+	}
+	return edit, true, nil
+}
+
+// recoverV2DiskTables 清理版本 2 的数据库中崩溃留下的文件，使磁盘表与元数据一致，并按实际的磁盘表更新 diskTableNum。
+// 版本 2 先删除合并的输入再更新元数据，刷新先写出磁盘表再更新元数据：
+//   - 合并标记存在：合并已经提交，按其中的编辑补完；
+//   - 没有合并标记但合并输出还在：输入的删除从较新的表开始，编号最大的缺少数据文件的表就是合并的目标，
+//     没有这样的表时合并输出没有提交，删除它；
+//   - 之后与版本 3 相同，补完后移、删除 maxDiskTableIndex 以外的磁盘表并填补空缺。
+func (t *LSMTree) recoverV2DiskTables() error {
+	edit, committed, err := readLegacyCompactionMarker(t.dbDir)
+	if err != nil {
+		return err
+	}
+	if committed {
+		if err := applyCompactionEdit(t.dbDir, edit); err != nil {
+			return err
+		}
+		t.logger.Warn("finished committed compaction of disk tables %v into %d in %s", edit.inputs, edit.output, t.dbDir)
+	}
+
+	tables, merge, err := listDiskTableFiles(t.dbDir)
+	if err != nil {
+		return err
+	}
+	oldest := t.maxDiskTableIndex - t.diskTableNum + 1
+	if len(merge) > 0 {
+		target := -1
+		for index := t.maxDiskTableIndex; index >= oldest; index-- {
+			if files := tables[index]; files == nil || !files[diskTableDataFileName] {
+				target = index
+				break
+			}
+		}
+
+		switch {
+		case merge[diskTableDataFileName] && target == -1:
+			if err := removeTableFiles(t.dbDir, mergeDiskTablePrefix, merge); err != nil {
+				return err
+			}
+			t.logger.Warn("removed incomplete merge output in %s", t.dbDir)
+		case merge[diskTableDataFileName]:
+			if err := applyCompactionEdit(t.dbDir, compactionEdit{inputs: []int{target}, output: target}); err != nil {
+				return err
+			}
+			t.logger.Warn("finished interrupted compaction into disk table %d in %s", target, t.dbDir)
+		default:
+			// 数据文件已经移走，目标是有数据文件但还缺少合并输出剩余文件的表
+			moved := false
+			for index := t.maxDiskTableIndex; index >= oldest && !moved; index-- {
+				if files := tables[index]; files != nil && files[diskTableDataFileName] && !files.complete() {
+					if err := finishRename(t.dbDir, mergeDiskTablePrefix, strconv.Itoa(index)+"-", merge); err != nil {
+						return err
+					}
+					t.logger.Warn("finished interrupted rename of merge output to disk table %d in %s", index, t.dbDir)
+					moved = true
+				}
+			}
+			if !moved {
+				return fmt.Errorf("found merge output without data file in %s, but no disk table to move it to", t.dbDir)
+			}
+		}
+
+		if tables, _, err = listDiskTableFiles(t.dbDir); err != nil {
+			return err
+		}
+	}
+
+	live, err := t.finishDiskTableRenames(tables)
+	if err != nil {
+		return err
+	}
+	if _, err := moveDiskTables(t.dbDir, live, t.maxDiskTableIndex); err != nil {
+		return err
+	}
+	t.diskTableNum = len(live)
+	return syncDir(t.dbDir)
+}
+
+// migrateV1DiskTable 读取旧格式的数据文件，重新生成数据、索引和稀疏索引文件。
+func migrateV1DiskTable(dbDir, prefix string, sparseKeyDistance int) error {
+	dataPath := path.Join(dbDir, prefix+diskTableDataFileName)
+	current, err := isCurrentFormat(dataPath)
+	if err != nil || current {
+		return err
+	}
+
+	dataFile, err := os.OpenFile(dataPath, os.O_RDONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open data file: %w", err)
+	}
+	defer dataFile.Close()
+
+	migratePrefix := "migrate"
+	w, err := newDiskTableWriter(dbDir, migratePrefix, sparseKeyDistance, tableFormat{})
+	if err != nil {
+		return fmt.Errorf("failed to create disk table writer: %w", err)
+	}
+
+	for {
+		key, value, err := decodeV1(dataFile)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			abortDiskTable(w, dbDir, migratePrefix)
+			return fmt.Errorf("failed to read %s: %w", dataPath, err)
+		}
+		if err := w.write(key, value, 0); err != nil {
+			abortDiskTable(w, dbDir, migratePrefix)
+			return fmt.Errorf("failed to write: %w", err)
+		}
+	}
+
+	if err := w.sync(); err != nil {
+		abortDiskTable(w, dbDir, migratePrefix)
+		return fmt.Errorf("failed to sync: %w", err)
+	}
+	if err := w.close(); err != nil {
+		_ = deleteDiskTables(dbDir, migratePrefix)
+		return fmt.Errorf("failed to close: %w", err)
+	}
+
+	return renameDiskTable(dbDir, migratePrefix, prefix)
+}
+
+// migrateV1WAL 用带校验和的记录重写旧格式的 WAL，WAL 不存在时什么也不做。
+func migrateV1WAL(walPath string) error {
+	if _, err := os.Stat(walPath); os.IsNotExist(err) {
+		return nil
+	}
+	current, err := isCurrentFormat(walPath)
+	if err != nil || current {
+		return err
+	}
+
+	wal, err := os.OpenFile(walPath, os.O_RDONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open the file %s: %w", walPath, err)
+	}
+	defer wal.Close()
+
+	tmpPath := walPath + ".migrate"
+	tmp, err := os.OpenFile(tmpPath, newDiskTableFlag, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open the file %s: %w", tmpPath, err)
+	}
+	defer os.Remove(tmpPath)
+	defer tmp.Close()
+
+	for {
+		key, value, err := decodeV1(wal)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read %s: %w", walPath, err)
+		}
+		if _, err := encode(key, value, tmp); err != nil {
+			return fmt.Errorf("failed to write %s: %w", tmpPath, err)
+		}
+	}
+
+	if err := tmp.Sync(); err != nil {
+		return fmt.Errorf("failed to sync %s: %w", tmpPath, err)
+	}
+	if err := os.Rename(tmpPath, walPath); err != nil {
+		return fmt.Errorf("failed to rename %s: %w", tmpPath, err)
+	}
+
+	return nil
+}
+
+// isCurrentFormat 判断文件的第一条记录能否按当前格式解码并通过校验，空文件视为当前格式。
+func isCurrentFormat(filePath string) (bool, error) {
+	file, err := os.OpenFile(filePath, os.O_RDONLY, 0600)
+	if err != nil {
+		return false, fmt.Errorf("failed to open %s: %w", filePath, err)
+	}
+	defer file.Close()
+
+	_, _, err = decode(file)
+	return err == nil || err == io.EOF, nil
+}
+
+// decodeV1 解码版本 1 中没有校验和的记录：
+// [编码的总长度（字节）][编码的键长度（字节）][键][值]
+func decodeV1(r io.Reader) ([]byte, []byte, error) {
+	var encodedEntryLen [8]byte
+	if _, err := io.ReadFull(r, encodedEntryLen[:]); err != nil {
+		return nil, nil, err
+	}
+
+	entryLen := decodeInt(encodedEntryLen[:])
+	if entryLen < 8 {
+		return nil, nil, fmt.Errorf("the file is corrupted, invalid entry length %d", entryLen)
+	}
+	encodedEntry := make([]byte, entryLen)
+	if _, err := io.ReadFull(r, encodedEntry); err != nil {
+		return nil, nil, fmt.Errorf("the file is corrupted, failed to read entry: %w", err)
+	}
+
+	keyLen := decodeInt(encodedEntry[0:8])
+	keyPartLen := 8 + keyLen
+	if keyLen < 0 || keyPartLen > len(encodedEntry) {
+		return nil, nil, fmt.Errorf("the file is corrupted, invalid key length %d", keyLen)
+	}
+	key := encodedEntry[8:keyPartLen]
+
+	if keyPartLen == len(encodedEntry) {
+		return key, nil, nil
+	}
+
+	return key, encodedEntry[keyPartLen:], nil
+}
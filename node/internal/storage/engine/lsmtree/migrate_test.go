@@ -0,0 +1,174 @@
+package lsmtree
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"strconv"
+	"testing"
+)
+
+// encodeV1 按版本 1 的格式编码记录：[编码的总长度][编码的键长度][键][值]
+func encodeV1(key, value []byte) []byte {
+	keyLen := encodeInt(len(key))
+	record := encodeInt(len(keyLen) + len(key) + len(value))
+	record = append(record, keyLen...)
+	record = append(record, key...)
+	return append(record, value...)
+}
+
+// writeV1DB 在 dbDir 中创建一个版本 1 的数据库：一张磁盘表和一个包含删除的 WAL。
+func writeV1DB(dbDir string) {
+	var data, index bytes.Buffer
+	for _, key := range []string{"a", "b", "c", "d"} {
+		index.Write(encodeV1([]byte(key), encodeInt(data.Len())))
+		data.Write(encodeV1([]byte(key), []byte("v"+key)))
+	}
+	files := map[string][]byte{
+		"0-" + diskTableDataFileName:        data.Bytes(),
+		"0-" + diskTableIndexFileName:       index.Bytes(),
+		"0-" + diskTableSparseIndexFileName: encodeV1([]byte("a"), encodeInt(0)),
+		diskTableNumFileName:                encodeIntPair(1, 0),
+		walFileName:                         append(encodeV1([]byte("e"), []byte("ve")), encodeV1([]byte("b"), nil)...),
+	}
+	for name, content := range files {
+		if err := os.WriteFile(path.Join(dbDir, name), content, 0600); err != nil {
+			panic(fmt.Errorf("failed to write %s: %w", name, err))
+		}
+	}
+}
+
+func TestOpenMigratesV1(t *testing.T) {
+	dbDir := t.TempDir()
+	writeV1DB(dbDir)
+
+	// 只读方式不能升级
+	if _, err := Open(dbDir, ReadOnly()); !errors.Is(err, ErrFormatVersion) {
+		t.Fatalf("expected %v, but got %v", ErrFormatVersion, err)
+	}
+
+	for i := 0; i < 2; i++ {
+		tree, err := Open(dbDir)
+		if err != nil {
+			t.Fatalf("failed to open v1 database: %s", err)
+		}
+
+		expected := map[string]string{"a": "va", "c": "vc", "d": "vd", "e": "ve"}
+		for key, want := range expected {
+			value, ok, err := tree.Get([]byte(key))
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if !ok || string(value) != want {
+				t.Fatalf("expected %s for key %s, but got %s", want, key, value)
+			}
+		}
+		if _, ok, err := tree.Get([]byte("b")); err != nil || ok {
+			t.Fatalf("expected deleted key b to be missing, but got %v, %v", ok, err)
+		}
+		if err := tree.Verify(); err != nil {
+			t.Fatalf("expected migrated tables to pass verification: %s", err)
+		}
+		if err := tree.Close(); err != nil {
+			panic(fmt.Errorf("failed to close: %w", err))
+		}
+
+		version, _, _, err := readDiskTableMeta(dbDir, dbDir)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		state, err := readManifest(dbDir)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if version != formatVersion || state.num != 1 || state.max != 0 {
+			t.Fatalf("expected version %d with 1 disk table up to 0, but got %d with %+v", formatVersion, version, state)
+		}
+	}
+}
+
+func TestOpenMigratesV2(t *testing.T) {
+	options := []func(*LSMTree){MemTableMaxEntries(10), ImmutableMemtableMaxNum(1), DiskTableNumThreshold(100)}
+	dbDir := t.TempDir()
+	tree, err := Open(dbDir, options...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := make(map[string]string)
+	for i := 0; i < 80; i++ {
+		key, value := fmt.Sprintf("key%02d", i%25), fmt.Sprintf("value%d", i)
+		if err := tree.Put([]byte(key), []byte(value)); err != nil {
+			t.Fatal(err)
+		}
+		expected[key] = value
+	}
+	num, max := tree.diskTableNum, tree.maxDiskTableIndex
+	if err := tree.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// 改写为版本 2 的数据库：元数据记录磁盘表集合，没有清单，合并在写入合并标记并删除一个输入之后崩溃
+	meta := append([]byte{2}, encodeIntPair(num, max)...)
+	if err := os.WriteFile(path.Join(dbDir, diskTableNumFileName), meta, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(path.Join(dbDir, manifestFileName)); err != nil {
+		t.Fatal(err)
+	}
+	oldest := max - num + 1
+	inputs := []int{oldest + 2, oldest + 1}
+	if _, err := writeMergedDiskTable(context.Background(), dbDir, inputs, defaultSparseKeyDistance, tableFormat{}, false, nil); err != nil {
+		t.Fatal(err)
+	}
+	marker := append(encodeInt(oldest+2), append(encodeInt(oldest+2), encodeInt(oldest+1)...)...)
+	if err := os.WriteFile(path.Join(dbDir, legacyCompactionMarkerFileName), marker, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := deleteDiskTables(dbDir, strconv.Itoa(oldest+1)+"-"); err != nil {
+		t.Fatal(err)
+	}
+
+	// 只读方式不能升级
+	if _, err := Open(dbDir, ReadOnly()); !errors.Is(err, ErrFormatVersion) {
+		t.Fatalf("expected %v, but got %v", ErrFormatVersion, err)
+	}
+	tree, err = Open(dbDir, options...)
+	if err != nil {
+		t.Fatalf("failed to open v2 database: %s", err)
+	}
+	defer tree.Close()
+	if tree.diskTableNum != num-1 || tree.maxDiskTableIndex != max {
+		t.Fatalf("expected %d disk tables up to %d, but got %d up to %d", num-1, max, tree.diskTableNum, tree.maxDiskTableIndex)
+	}
+	if _, err := os.Stat(path.Join(dbDir, legacyCompactionMarkerFileName)); !os.IsNotExist(err) {
+		t.Fatalf("expected the compaction marker to be removed, but got %v", err)
+	}
+	if version, _, _, err := readDiskTableMeta(dbDir, dbDir); err != nil || version != formatVersion {
+		t.Fatalf("expected version %d, but got %d (%v)", formatVersion, version, err)
+	}
+	if err := tree.Verify(); err != nil {
+		t.Fatal(err)
+	}
+	for key, want := range expected {
+		value, ok, err := tree.Get([]byte(key))
+		if err != nil || !ok || string(value) != want {
+			t.Fatalf("expected %s for %s, but got %s (%v, %v)", want, key, value, ok, err)
+		}
+	}
+}
+
+func TestOpenRejectsNewerFormat(t *testing.T) {
+	dbDir := t.TempDir()
+
+	meta := append([]byte{formatVersion + 1}, encodeIntPair(0, -1)...)
+	if err := os.WriteFile(path.Join(dbDir, diskTableNumFileName), meta, 0600); err != nil {
+		panic(fmt.Errorf("failed to write meta: %w", err))
+	}
+
+	if _, err := Open(dbDir); !errors.Is(err, ErrFormatVersion) {
+		t.Fatalf("expected %v, but got %v", ErrFormatVersion, err)
+	}
+}
@@ -0,0 +1,111 @@
+package lsmtree
+
+import (
+	"container/list"
+	"sync"
+	"time"
+)
+
+// NegativeCache 开启不存在的键的缓存（默认关闭）：Get 在所有磁盘表中都没有找到的键被记录 ttl，
+// 期间对它的 Get 直接返回不存在，不再查找每个磁盘表的稀疏索引和索引。
+// 写入该键时删除它的条目，因此缓存不会返回过时的结果。
+// 最多记录 size 个键，超过时淘汰最久没有查询的键：大量查询随机的不存在的键只会不断淘汰条目，
+// 占用的内存不超过 size 个键。size 或 ttl 为 0 时不缓存。
+func NegativeCache(size int, ttl time.Duration) func(*LSMTree) {
+	return func(t *LSMTree) {
+		t.negativeCacheSize = size
+		t.negativeCacheTTL = ttl
+	}
+}
+
+// negativeCache 是最近确认不存在的键的 LRU 缓存，可以被多个读取同时调用。
+// 写入键的操作持有写锁，读取持有读锁，因此记录不存在的读取不会与写入同一个键的操作交错。
+type negativeCache struct {
+	mu       sync.Mutex
+	capacity int
+	ttl      time.Duration
+	ll       *list.List
+	items    map[string]*list.Element
+}
+
+type negativeEntry struct {
+	key     string
+	expires time.Time
+}
+
+// newNegativeCache 返回最多记录 capacity 个键的缓存，capacity 或 ttl 不大于 0 时返回 nil（不缓存）。
+func newNegativeCache(capacity int, ttl time.Duration) *negativeCache {
+	if capacity <= 0 || ttl <= 0 {
+		return nil
+	}
+	return &negativeCache{
+		capacity: capacity,
+		ttl:      ttl,
+		ll:       list.New(),
+		items:    make(map[string]*list.Element),
+	}
+}
+
+// absent 返回键是否在 ttl 内被确认不存在，nil 的缓存总是返回 false。
+func (c *negativeCache) absent(key []byte) bool {
+	if c == nil {
+		return false
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.items[string(key)]
+	if !ok {
+		return false
+	}
+	if time.Now().After(e.Value.(*negativeEntry).expires) {
+		c.ll.Remove(e)
+		delete(c.items, string(key))
+		return false
+	}
+	c.ll.MoveToFront(e)
+	return true
+}
+
+// add 记录键不存在，超过容量时淘汰最久没有查询的键。
+func (c *negativeCache) add(key []byte) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	expires := time.Now().Add(c.ttl)
+	if e, ok := c.items[string(key)]; ok {
+		e.Value.(*negativeEntry).expires = expires
+		c.ll.MoveToFront(e)
+		return
+	}
+	c.items[string(key)] = c.ll.PushFront(&negativeEntry{key: string(key), expires: expires})
+	if c.ll.Len() > c.capacity {
+		oldest := c.ll.Back()
+		c.ll.Remove(oldest)
+		delete(c.items, oldest.Value.(*negativeEntry).key)
+	}
+}
+
+// remove 删除键的条目，在写入键时调用，nil 的缓存什么也不做。
+func (c *negativeCache) remove(key []byte) {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if e, ok := c.items[string(key)]; ok {
+		c.ll.Remove(e)
+		delete(c.items, string(key))
+	}
+}
+
+// len 返回记录的键数量。
+func (c *negativeCache) len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.ll.Len()
+}
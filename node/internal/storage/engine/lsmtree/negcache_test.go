@@ -0,0 +1,108 @@
+package lsmtree
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestNegativeCache(t *testing.T) {
+	tree, err := Open(t.TempDir(), NegativeCache(2, time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tree.Close()
+
+	if err := tree.Put([]byte("a"), []byte("1")); err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.Flush(); err != nil {
+		t.Fatal(err)
+	}
+
+	// 磁盘表中没有找到的键被记录，之后的查询不再查找磁盘表
+	for i := 0; i < 2; i++ {
+		if _, exists, err := tree.Get([]byte("b")); err != nil || exists {
+			t.Fatalf("expected b to be missing, but got %t, %v", exists, err)
+		}
+	}
+	if n := tree.negatives.len(); n != 1 {
+		t.Fatalf("expected 1 cached miss, but got %d", n)
+	}
+	if _, exists, _ := tree.Get([]byte("a")); !exists {
+		t.Fatal("expected a to exist")
+	}
+
+	// 写入使条目失效
+	if err := tree.Put([]byte("b"), []byte("2")); err != nil {
+		t.Fatal(err)
+	}
+	if value, exists, err := tree.Get([]byte("b")); err != nil || !exists || string(value) != "2" {
+		t.Fatalf("expected b=2 after the put, but got %q (%t, %v)", value, exists, err)
+	}
+	if err := tree.Delete([]byte("b")); err != nil {
+		t.Fatal(err)
+	}
+	if _, exists, _ := tree.Get([]byte("b")); exists {
+		t.Fatal("expected b to be deleted")
+	}
+
+	// 记录的键数量有上限
+	for i := 0; i < 10; i++ {
+		tree.Get([]byte(fmt.Sprintf("missing%d", i)))
+	}
+	if n := tree.negatives.len(); n != 2 {
+		t.Fatalf("expected the cache to hold at most 2 keys, but got %d", n)
+	}
+}
+
+func TestNegativeCacheExpires(t *testing.T) {
+	c := newNegativeCache(10, 20*time.Millisecond)
+	c.add([]byte("key"))
+	if !c.absent([]byte("key")) {
+		t.Fatal("expected the key to be cached as absent")
+	}
+	time.Sleep(30 * time.Millisecond)
+	if c.absent([]byte("key")) {
+		t.Fatal("expected the entry to expire")
+	}
+	if newNegativeCache(0, time.Second) != nil || newNegativeCache(10, 0) != nil {
+		t.Fatal("expected no cache when the size or ttl is 0")
+	}
+}
+
+func BenchmarkGetMissNegativeCache(b *testing.B) {
+	dbDir := b.TempDir()
+	tree, err := Open(dbDir, MemTableMaxEntries(1000), DiskTableNumThreshold(100))
+	if err != nil {
+		b.Fatal(err)
+	}
+	for i := 0; i < 10000; i++ {
+		// 键交错地分布在 10 个磁盘表中
+		if err := tree.Put([]byte(fmt.Sprintf("key%06d", i*7919%10000)), []byte("value")); err != nil {
+			b.Fatal(err)
+		}
+	}
+	if err := tree.Close(); err != nil {
+		b.Fatal(err)
+	}
+
+	for _, size := range []int{0, 1000} {
+		b.Run(fmt.Sprintf("cache=%d", size), func(b *testing.B) {
+			tree, err := Open(dbDir, NegativeCache(size, time.Minute))
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer tree.Close()
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				// 反复查询 100 个落在磁盘表键范围内的不存在的键
+				key := []byte(fmt.Sprintf("key%06dx", i%100*97))
+				if _, ok, err := tree.Get(key); err != nil || ok {
+					b.Fatalf("expected %s to be missing (%v)", key, err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,223 @@
+package lsmtree
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+)
+
+// 固定的键的日志文件名，与 WAL 放在同一个目录。
+const pinnedFileName = "pinned.db"
+
+var (
+	// ErrPinnedMemoryFull 当固定的键和值的总大小将超过 PinnedMemoryLimit 时返回。
+	ErrPinnedMemoryFull = errors.New("pinned memory limit reached")
+	// ErrKeyPinned 当 PutBatch 或 ApplyBatch 写入固定的键时在该键的位置返回，固定的键不在 WAL 中，无法与批量一起原子地写入。
+	ErrKeyPinned = errors.New("key is pinned")
+)
+
+// PinnedMemoryLimit 设置固定的键和值的总字节数上限（默认 4 MB），0 表示不允许固定。详见 PutPinned。
+func PinnedMemoryLimit(limit int) func(*LSMTree) {
+	return func(t *LSMTree) {
+		t.pinnedMemoryLimit = limit
+	}
+}
+
+// PutPinned 写入键值对并把键固定在内存中：固定的键保存在单独的内存表中，Get 最先查找它，
+// 刷新时不写入磁盘表，适合很热的键。之后对它的 Put、Append 等写入更新固定的值，键保持固定；
+// Delete 删除键并解除固定，Unpin 只解除固定。
+// 固定的键记录在 WAL 目录中单独的日志里，重新打开后仍然固定；它们不占用 WAL 序号，不会被 WALSubscribe 返回。
+// 开启 DisableWAL 时不记录，重新打开后丢失。总大小将超过 PinnedMemoryLimit 时返回 ErrPinnedMemoryFull。
+func (t *LSMTree) PutPinned(key, value []byte) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.readOnly {
+		return ErrReadOnly
+	} else if err := t.checkEntry(key, value); err != nil {
+		return err
+	}
+	return t.putPinned(key, value, 0)
+}
+
+// Unpin 解除键的固定，把它当前的值写回普通的内存表，之后随内存表刷新到磁盘表。键没有固定时什么也不做。
+func (t *LSMTree) Unpin(key []byte) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if t.readOnly {
+		return ErrReadOnly
+	}
+	value, pinned := t.pinned.get(key)
+	if !pinned {
+		return nil
+	}
+	flags := t.pinned.getFlags(key)
+
+	// 先把值写入 WAL，再记录解除固定：中途崩溃时重新打开后键仍然固定，值相同
+	t.pinned.remove(key)
+	if err := t.put(key, value, flags); err != nil {
+		t.pinned.putWithFlags(key, value, flags)
+		return err
+	}
+	return t.appendPinned(key, nil, 0)
+}
+
+// Pinned 返回键是否被固定。
+func (t *LSMTree) Pinned(key []byte) bool {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	_, pinned := t.pinned.get(key)
+	return pinned
+}
+
+// putPinned 写入固定的键，调用方必须持有写锁并已检查键和值。
+func (t *LSMTree) putPinned(key, value []byte, flags uint32) error {
+	size := t.pinned.bytes() + len(key) + len(value)
+	if old, pinned := t.pinned.get(key); pinned {
+		size -= len(key) + len(old)
+	}
+	if size > t.pinnedMemoryLimit {
+		return ErrPinnedMemoryFull
+	}
+
+	if err := t.appendPinned(key, value, flags); err != nil {
+		return err
+	}
+	t.stats.UserBytesWritten += int64(len(key) + len(value))
+	t.pinned.putWithFlags(key, value, flags)
+	t.negatives.remove(key)
+	return nil
+}
+
+// unpinForDelete 在删除固定的键时解除固定，调用方必须持有写锁，并且已经把墓碑写入 WAL：
+// 中途崩溃时重新打开后键仍然固定，保留删除之前的值，而不是露出固定之前更旧的值。
+func (t *LSMTree) unpinForDelete(key []byte) error {
+	if !t.pinned.remove(key) {
+		return nil
+	}
+	return t.appendPinned(key, nil, 0)
+}
+
+// appendPinned 把固定（value 不为 nil）或解除固定（value 为 nil）追加到固定的键的日志，
+// 日志中过时的记录过多时重写日志。开启 DisableWAL 时什么也不做，调用方必须持有写锁。
+func (t *LSMTree) appendPinned(key, value []byte, flags uint32) error {
+	if t.disableWAL {
+		return nil
+	}
+	if t.pinnedLog == nil {
+		logPath := path.Join(t.walDir, pinnedFileName)
+		f, err := os.OpenFile(logPath, os.O_RDWR|os.O_CREATE, 0600)
+		if err != nil {
+			return fmt.Errorf("failed to open the file %s: %w", logPath, err)
+		}
+		t.pinnedLog = f
+	}
+
+	n, err := appendToWAL(t.pinnedLog, key, value, flags)
+	t.stats.WALBytesWritten += int64(n)
+	t.pinnedLogSize += int64(n)
+	if err != nil {
+		return fmt.Errorf("failed to append to file %s: %w", t.pinnedLog.Name(), err)
+	}
+
+	// 每条记录的开销不超过 32 字节，过时的记录超过一半时重写
+	if live := int64(t.pinned.bytes() + 32*t.pinned.size()); t.pinnedLogSize > 2*live+minPinnedLogRewriteSize {
+		return t.rewritePinnedLog()
+	}
+	return nil
+}
+
+// rewritePinnedLog 只用当前固定的键重写日志，先写入临时文件再替换，调用方必须持有写锁。
+func (t *LSMTree) rewritePinnedLog() error {
+	logPath := path.Join(t.walDir, pinnedFileName)
+	tmpPath := logPath + ".tmp"
+	tmp, err := os.OpenFile(tmpPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open the file %s: %w", tmpPath, err)
+	}
+
+	var size int64
+	it := t.pinned.iterator()
+	for it.hasNext() {
+		key, value, flags := it.nextWithFlags()
+		n, err := encodeWithFlags(key, value, flags, tmp)
+		if err != nil {
+			_ = tmp.Close()
+			return fmt.Errorf("failed to write %s: %w", tmpPath, err)
+		}
+		size += int64(n)
+	}
+	if err := tmp.Sync(); err != nil {
+		_ = tmp.Close()
+		return fmt.Errorf("failed to sync %s: %w", tmpPath, err)
+	}
+	if err := os.Rename(tmpPath, logPath); err != nil {
+		_ = tmp.Close()
+		return fmt.Errorf("failed to rename %s: %w", tmpPath, err)
+	}
+
+	_ = t.pinnedLog.Close()
+	t.pinnedLog, t.pinnedLogSize = tmp, size
+	return nil
+}
+
+// loadPinned 在 Open 时从日志中恢复固定的键，日志不存在时没有固定的键。
+// 可写时截掉日志末尾不完整的记录，并保持日志打开以追加之后的记录。
+func (t *LSMTree) loadPinned() error {
+	logPath := path.Join(t.walDir, pinnedFileName)
+	var table *memTable
+	if t.readOnly {
+		var err error
+		if table, err = loadReadOnlyMemTable(logPath); err != nil {
+			return err
+		}
+	} else {
+		f, err := os.OpenFile(logPath, os.O_RDWR, 0600)
+		if os.IsNotExist(err) {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to open the file %s: %w", logPath, err)
+		}
+		var dropped int64
+		if table, dropped, err = loadMemTable(f); err != nil {
+			_ = f.Close()
+			return err
+		}
+		if dropped > 0 {
+			t.logger.Warn("truncated an incomplete record of %d bytes at the end of %s", dropped, logPath)
+		}
+		if t.pinnedLogSize, err = f.Seek(0, io.SeekEnd); err != nil {
+			_ = f.Close()
+			return err
+		}
+		t.pinnedLog = f
+	}
+
+	// 解除固定的记录在内存表中是墓碑
+	it := table.iterator()
+	for it.hasNext() {
+		key, value, flags := it.nextWithFlags()
+		if value != nil {
+			t.pinned.putWithFlags(key, value, flags)
+		}
+	}
+	return nil
+}
+
+// clearPinned 解除所有固定并清空日志，用于 DropAll，调用方必须持有写锁。
+func (t *LSMTree) clearPinned() error {
+	t.pinned = newMemTable()
+	if t.pinnedLog == nil {
+		return nil
+	}
+	if err := t.pinnedLog.Truncate(0); err != nil {
+		return fmt.Errorf("failed to truncate %s: %w", t.pinnedLog.Name(), err)
+	}
+	t.pinnedLogSize = 0
+	return nil
+}
@@ -0,0 +1,172 @@
+package lsmtree
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+// inDiskTables 返回键是否出现在任何磁盘表中。
+func inDiskTables(t *testing.T, tree *LSMTree, key []byte) bool {
+	t.Helper()
+	oldest := tree.maxDiskTableIndex - tree.diskTableNum + 1
+	_, _, exists, _, _, err := searchInDiskTables(tree.dbDir, nil, oldest, tree.maxDiskTableIndex, key, true, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return exists
+}
+
+func TestPinnedKeysSurviveFlushes(t *testing.T) {
+	dbDir := t.TempDir()
+	tree, err := Open(dbDir, MemTableThreshold(200), ImmutableMemtableMaxNum(1), DiskTableNumThreshold(1000))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	hot := []byte("hot")
+	if err := tree.PutPinned(hot, []byte("v1")); err != nil {
+		t.Fatal(err)
+	}
+	// 之后的写入更新固定的值，键保持固定
+	if _, err := tree.Append(hot, []byte("+")); err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100; i++ {
+		key := []byte(fmt.Sprintf("key%03d", i))
+		if err := tree.Put(key, bytes.Repeat([]byte("v"), 20)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tree.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if tree.diskTableNum < 2 {
+		t.Fatalf("expected several flushes, but got %d disk tables", tree.diskTableNum)
+	}
+	if inDiskTables(t, tree, hot) {
+		t.Fatal("the pinned key should not be flushed to disk tables")
+	}
+	if value, exists, err := tree.Get(hot); err != nil || !exists || string(value) != "v1+" {
+		t.Fatalf("expected hot=v1+, but got %q (%t, %v)", value, exists, err)
+	}
+	if stats := tree.Stats(); stats.PinnedBytes != int64(len("hot")+len("v1+")) {
+		t.Fatalf("unexpected pinned bytes %d", stats.PinnedBytes)
+	}
+
+	// 固定的键出现在 Scan 中
+	it, err := tree.ScanPrefix([]byte("ho"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !it.Next() || string(it.Key()) != "hot" || string(it.Value()) != "v1+" || it.Next() {
+		t.Fatal("expected the scan to return the pinned key")
+	}
+	it.Close()
+
+	// 重新打开后仍然固定
+	if err := tree.Close(); err != nil {
+		t.Fatal(err)
+	}
+	tree, err = Open(dbDir, MemTableThreshold(200), ImmutableMemtableMaxNum(1), DiskTableNumThreshold(1000))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tree.Close()
+	if !tree.Pinned(hot) {
+		t.Fatal("expected the key to stay pinned after reopening")
+	}
+	if value, exists, err := tree.Get(hot); err != nil || !exists || string(value) != "v1+" {
+		t.Fatalf("expected hot=v1+ after reopening, but got %q (%t, %v)", value, exists, err)
+	}
+	if errs, err := tree.PutBatch([][]byte{hot}, [][]byte{[]byte("v2")}); err != nil || !errors.Is(errs[0], ErrKeyPinned) {
+		t.Fatalf("expected ErrKeyPinned from the batch, but got %v (%v)", errs, err)
+	}
+
+	// 解除固定后随内存表刷新到磁盘表
+	if err := tree.Unpin(hot); err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	if tree.Pinned(hot) || !inDiskTables(t, tree, hot) {
+		t.Fatal("expected the unpinned key to be flushed")
+	}
+	if value, exists, err := tree.Get(hot); err != nil || !exists || string(value) != "v1+" {
+		t.Fatalf("expected hot=v1+ after unpinning, but got %q (%t, %v)", value, exists, err)
+	}
+}
+
+func TestPinnedDeleteAndLimit(t *testing.T) {
+	dbDir := t.TempDir()
+	tree, err := Open(dbDir, PinnedMemoryLimit(10))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 固定之前写入的旧值在删除后不应重新出现
+	if err := tree.Put([]byte("a"), []byte("old")); err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.PutPinned([]byte("a"), []byte("new")); err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.PutPinned([]byte("b"), []byte("1234567")); !errors.Is(err, ErrPinnedMemoryFull) {
+		t.Fatalf("expected ErrPinnedMemoryFull, but got %v", err)
+	}
+	if err := tree.Delete([]byte("a")); err != nil {
+		t.Fatal(err)
+	}
+	if _, exists, _ := tree.Get([]byte("a")); exists || tree.Pinned([]byte("a")) {
+		t.Fatal("expected the pinned key to be deleted and unpinned")
+	}
+	if err := tree.PutPinned([]byte("b"), []byte("1234567")); err != nil {
+		t.Fatalf("expected the memory to be freed by the delete, but got %v", err)
+	}
+	if err := tree.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	tree, err = Open(dbDir, PinnedMemoryLimit(10))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tree.Close()
+	if _, exists, _ := tree.Get([]byte("a")); exists {
+		t.Fatal("expected the deleted key to stay deleted after reopening")
+	}
+	if value, _, _ := tree.Get([]byte("b")); string(value) != "1234567" || !tree.Pinned([]byte("b")) {
+		t.Fatalf("expected b to stay pinned, but got %q", value)
+	}
+}
+
+func TestPinnedLogRewrite(t *testing.T) {
+	dbDir := t.TempDir()
+	tree, err := Open(dbDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	value := bytes.Repeat([]byte("v"), 1000)
+	for i := 0; i < 200; i++ {
+		if err := tree.PutPinned([]byte("hot"), append(value, byte(i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if size := tree.pinnedLogSize; size > 2*minPinnedLogRewriteSize {
+		t.Fatalf("expected the pinned log to be rewritten, but it has %d bytes", size)
+	}
+	if err := tree.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	tree, err = Open(dbDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tree.Close()
+	if got, _, _ := tree.Get([]byte("hot")); !bytes.Equal(got, append(value, 199)) {
+		t.Fatal("expected the last pinned value after rewriting the log")
+	}
+}
@@ -0,0 +1,140 @@
+package lsmtree
+
+import (
+	"context"
+	"io"
+	"strconv"
+	"sync/atomic"
+	"time"
+)
+
+// compactionProgressInterval 是合并期间向 CompactionProgressListener 报告进度的间隔。
+var compactionProgressInterval = time.Second
+
+// CompactionProgress 是正在进行的合并的进度。
+type CompactionProgress struct {
+	// 输入的磁盘表编号和合并后的编号
+	Inputs []int
+	Output int
+	// 已经从输入的数据文件中读取的字节数和输入的数据文件总大小
+	BytesProcessed int64
+	BytesTotal     int64
+}
+
+// Fraction 返回合并完成的比例，在 0 到 1 之间。
+func (p CompactionProgress) Fraction() float64 {
+	if p.BytesTotal <= 0 {
+		return 1
+	}
+	return float64(p.BytesProcessed) / float64(p.BytesTotal)
+}
+
+// CompactionProgressListener 是可选的 EventListener 扩展，实现它的监听器在合并期间定期收到进度，
+// 合并的数据写完时最后收到一次 BytesProcessed 等于 BytesTotal 的进度，失败的合并没有这一次。
+// 同一次合并的进度在同一个 goroutine 中按顺序回调，不会阻塞合并，与 OnCompaction 之间没有先后保证。
+type CompactionProgressListener interface {
+	OnCompactionProgress(progress CompactionProgress)
+}
+
+// compactionState 记录正在进行的合并，合并持有写锁，读取进度时不能获取写锁。
+// 合并同时登记为后台操作，读取的字节数记录在 op 中。
+type compactionState struct {
+	inputs []int
+	output int
+	op     *backgroundOp
+}
+
+func (s *compactionState) progress() CompactionProgress {
+	status := s.op.status()
+	return CompactionProgress{
+		Inputs:         append([]int(nil), s.inputs...),
+		Output:         s.output,
+		BytesProcessed: status.BytesProcessed,
+		BytesTotal:     status.BytesTotal,
+	}
+}
+
+// CompactionProgress 返回正在进行的合并的进度，没有合并时返回 false。
+func (t *LSMTree) CompactionProgress() (CompactionProgress, bool) {
+	s := t.compaction.Load()
+	if s == nil {
+		return CompactionProgress{}, false
+	}
+	return s.progress(), true
+}
+
+// startCompactionProgress 记录合并 inputs 的开始并把它登记为 kind 类型的后台操作，cancel 不为 nil 时操作可以取消。
+// 返回累加读取字节数的计数器和合并结束时调用的函数。
+// 有 CompactionProgressListener 时在后台定期报告进度，合并成功时最后报告一次完成。
+func (t *LSMTree) startCompactionProgress(kind string, inputs []int, output int, cancel context.CancelFunc) (*atomic.Int64, func(ok bool)) {
+	var total int64
+	for _, index := range inputs {
+		size, err := diskTableDataSize(t.dbDir, strconv.Itoa(index)+"-")
+		if err == nil {
+			total += size
+		}
+	}
+	s := &compactionState{inputs: inputs, output: output, op: t.startOp(kind, total, cancel)}
+	t.compaction.Store(s)
+
+	var listeners []CompactionProgressListener
+	for _, l := range t.listeners {
+		if pl, ok := l.(CompactionProgressListener); ok {
+			listeners = append(listeners, pl)
+		}
+	}
+	done := make(chan bool, 1)
+	if len(listeners) > 0 {
+		go t.reportCompactionProgress(s, listeners, done)
+	}
+
+	return &s.op.processed, func(ok bool) {
+		t.compaction.Store(nil)
+		t.finishOp(s.op)
+		done <- ok
+	}
+}
+
+// reportCompactionProgress 每隔 compactionProgressInterval 向 listeners 报告一次进度，直到合并结束。
+func (t *LSMTree) reportCompactionProgress(s *compactionState, listeners []CompactionProgressListener, done <-chan bool) {
+	report := func(progress CompactionProgress) {
+		for _, l := range listeners {
+			func() {
+				defer func() {
+					if r := recover(); r != nil {
+						t.logger.Error("event listener panicked: %v", r)
+					}
+				}()
+				l.OnCompactionProgress(progress)
+			}()
+		}
+	}
+
+	ticker := time.NewTicker(compactionProgressInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case ok := <-done:
+			if ok {
+				progress := s.progress()
+				progress.BytesProcessed = progress.BytesTotal
+				report(progress)
+			}
+			return
+		case <-ticker.C:
+			report(s.progress())
+		}
+	}
+}
+
+// countingReader 把从 r 读取的字节数累加到 n。
+type countingReader struct {
+	r io.Reader
+	n *atomic.Int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n.Add(int64(n))
+	return n, err
+}
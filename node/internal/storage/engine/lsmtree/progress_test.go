@@ -0,0 +1,100 @@
+package lsmtree
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"testing"
+	"time"
+)
+
+type progressListener struct {
+	progress chan CompactionProgress
+}
+
+func (l *progressListener) OnFlush(int, TableInfo) {}
+
+func (l *progressListener) OnCompaction([]int, int) {}
+
+func (l *progressListener) OnCompactionProgress(progress CompactionProgress) {
+	l.progress <- progress
+}
+
+func TestCompactionProgress(t *testing.T) {
+	compactionProgressInterval = time.Millisecond
+	defer func() { compactionProgressInterval = time.Second }()
+
+	listener := &progressListener{progress: make(chan CompactionProgress, 100000)}
+	tree, err := Open(t.TempDir(), MemTableThreshold(64<<20), DiskTableNumThreshold(100), DisableWAL(true), Listeners(listener))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tree.Close()
+
+	// 两个各约 10 MB 的磁盘表
+	value := bytes.Repeat([]byte("v"), 500)
+	for table := 0; table < 2; table++ {
+		for i := 0; i < 20000; i++ {
+			if err := tree.Put([]byte(fmt.Sprintf("key%06d", i)), value); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := tree.Flush(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, ok := tree.CompactionProgress(); ok {
+		t.Fatal("expected no compaction in progress")
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		tree.mu.Lock()
+		defer tree.mu.Unlock()
+		done <- tree.compactPair(context.Background(), 0, 1)
+	}()
+
+	// 合并期间轮询到的进度不会后退
+	var last int64
+	for polling := true; polling; {
+		select {
+		case err := <-done:
+			if err != nil {
+				t.Fatal(err)
+			}
+			polling = false
+		default:
+			if progress, ok := tree.CompactionProgress(); ok {
+				if progress.BytesProcessed < last || progress.BytesProcessed > progress.BytesTotal {
+					t.Fatalf("progress went from %d to %d of %d", last, progress.BytesProcessed, progress.BytesTotal)
+				}
+				last = progress.BytesProcessed
+			}
+			time.Sleep(100 * time.Microsecond)
+		}
+	}
+	if _, ok := tree.CompactionProgress(); ok {
+		t.Fatal("expected no compaction in progress after it finished")
+	}
+
+	// 监听器按顺序收到进度，最后一次是 100%
+	var events []CompactionProgress
+	deadline := time.After(5 * time.Second)
+	for len(events) == 0 || events[len(events)-1].Fraction() < 1 {
+		select {
+		case progress := <-listener.progress:
+			events = append(events, progress)
+		case <-deadline:
+			t.Fatalf("expected progress to reach 100%%, but got %d events", len(events))
+		}
+	}
+	for i, progress := range events {
+		if fmt.Sprint(progress.Inputs) != "[0 1]" || progress.Output != 1 || progress.BytesTotal < 20000*500 {
+			t.Fatalf("unexpected progress %+v", progress)
+		}
+		if i > 0 && progress.BytesProcessed < events[i-1].BytesProcessed {
+			t.Fatalf("progress went from %d to %d", events[i-1].BytesProcessed, progress.BytesProcessed)
+		}
+	}
+	t.Logf("observed %d progress events, last polled %d bytes", len(events), last)
+}
@@ -0,0 +1,153 @@
+package lsmtree
+
+import (
+	"bytes"
+	"fmt"
+)
+
+// RawEntry 是 RawScan 返回的一个版本。
+type RawEntry struct {
+	Key []byte
+	// 墓碑的值为 nil。
+	Value []byte
+	// Tombstone 表示这个版本是一次删除。
+	Tombstone bool
+	// Generation 是版本所在的表的代数，同一个键的多个版本中越大越新：
+	// 磁盘表的代数是它的编号，不可变内存表和当前内存表排在所有磁盘表之后，当前内存表最大。
+	// 合并会重新编号磁盘表，代数只在同一次 RawScan 中可以比较。
+	Generation int
+}
+
+// RawScan 返回按键升序遍历 [start, end) 范围内所有版本的迭代器，边界语义与 Scan 相同。
+// 与 Scan 不同，它不把同一个键的多个版本合并为最新的值，而是依次返回每个表中的版本（从新到旧），
+// 包括墓碑。它用于变更捕获和增量复制等需要观察删除的场景。
+//
+// RawScan 暴露的是引擎的内部状态，不用于普通读取：
+//   - 每个表中一个键只保留最后一次写入，同一张表内被覆盖的写入不可见；
+//   - 返回哪些旧版本和墓碑取决于刷新和合并的时机，合并到最旧的磁盘表时墓碑会被丢弃；
+//   - 引擎不为每条写入持久化序号，版本之间只能通过 Generation 比较新旧。
+//
+// 需要精确到每条写入的变更时，使用 ArchiveWAL 和 ReplayTo。
+// 迭代器的 Sequence 返回创建时的 WAL 序号，可以作为之后从 WAL 续读的起点。
+func (t *LSMTree) RawScan(start, end []byte) (*RawIterator, error) {
+	if len(start) == 0 {
+		start = nil
+	}
+	if len(end) == 0 {
+		end = nil
+	}
+
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	it := &RawIterator{end: end, sequence: t.walSeq}
+	if start != nil && end != nil && bytes.Compare(start, end) >= 0 {
+		return it, nil
+	}
+
+	// 输入按从新到旧排列，与 Scan 相同
+	// 固定的键比所有内存表都新
+	generation := t.maxDiskTableIndex + len(t.immutableMemtables) + 2
+	it.sources = append(it.sources, newMemTableSource(t.pinned, start, end))
+	it.generations = append(it.generations, generation)
+	generation--
+	it.sources = append(it.sources, newMemTableSource(t.memTable, start, end))
+	it.generations = append(it.generations, generation)
+	for i := len(t.immutableMemtables) - 1; i >= 0; i-- {
+		generation--
+		it.sources = append(it.sources, newMemTableSource(t.immutableMemtables[i], start, end))
+		it.generations = append(it.generations, generation)
+	}
+
+	oldest := t.maxDiskTableIndex - t.diskTableNum + 1
+	for index := t.maxDiskTableIndex; index >= oldest; index-- {
+		source, err := newDiskTableSource(t.dbDir, index, start, t.readAhead)
+		if err != nil {
+			closeScanSources(it.sources)
+			return nil, fmt.Errorf("failed to open disk table %d: %w", index, err)
+		}
+		if source != nil {
+			it.sources = append(it.sources, source)
+			it.generations = append(it.generations, index)
+		}
+	}
+	it.release = t.readers.acquire(t.manifest.state.version)
+
+	return it, nil
+}
+
+// RawIterator 是 RawScan 返回的迭代器，用法与 Iterator 相同。
+type RawIterator struct {
+	sources []scanSource
+	// 与 sources 一一对应的代数
+	generations []int
+	end         []byte
+	sequence    uint64
+	// 注销迭代器对磁盘表的读取，没有打开磁盘表时为 nil，详见 tableReaders
+	release func()
+
+	entry RawEntry
+	done  bool
+	err   error
+}
+
+// Next 前进到下一个版本，没有更多版本或发生错误时返回 false。
+// 键按升序返回，同一个键的版本从新到旧返回。
+func (it *RawIterator) Next() bool {
+	if it.done || it.err != nil {
+		return false
+	}
+
+	// 键最小的输入中排在最前的最新，sources 已经按从新到旧排列
+	next := -1
+	for i, source := range it.sources {
+		if source.valid() && (next == -1 || bytes.Compare(source.key(), it.sources[next].key()) < 0) {
+			next = i
+		}
+	}
+	if next == -1 {
+		it.done = true
+		return false
+	}
+
+	source := it.sources[next]
+	key, value := source.key(), source.value()
+	if it.end != nil && bytes.Compare(key, it.end) >= 0 {
+		it.done = true
+		return false
+	}
+	if err := source.advance(); err != nil {
+		it.err = err
+		it.done = true
+		return false
+	}
+
+	it.entry = RawEntry{Key: key, Value: value, Tombstone: value == nil, Generation: it.generations[next]}
+	return true
+}
+
+// Entry 返回当前的版本。
+func (it *RawIterator) Entry() RawEntry {
+	return it.entry
+}
+
+// Sequence 返回创建迭代器时的 WAL 序号，序号小于它的写入都已反映在迭代器的输入中，详见 LSMTree.Sequence。
+func (it *RawIterator) Sequence() uint64 {
+	return it.sequence
+}
+
+// Err 返回迭代过程中发生的错误。
+func (it *RawIterator) Err() error {
+	return it.err
+}
+
+// Close 关闭迭代器打开的所有文件。
+func (it *RawIterator) Close() error {
+	err := closeScanSources(it.sources)
+	it.sources = nil
+	it.done = true
+	if it.release != nil {
+		it.release()
+	}
+	return err
+}
@@ -0,0 +1,110 @@
+package lsmtree
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestRawScan(t *testing.T) {
+	dbDir := t.TempDir()
+	tree, err := Open(dbDir)
+	if err != nil {
+		t.Fatalf("failed to open LSM tree %s: %s", dbDir, err)
+	}
+	defer tree.Close()
+
+	flush := func() {
+		t.Helper()
+		if err := tree.flushMemTable(context.Background(), tree.memTable); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		tree.refreshMemTable()
+	}
+	put := func(key, value string) {
+		t.Helper()
+		if err := tree.Put([]byte(key), []byte(value)); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+	del := func(key string) {
+		t.Helper()
+		if err := tree.Delete([]byte(key)); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+
+	// 磁盘表 0
+	put("a", "1")
+	put("b", "1")
+	put("c", "1")
+	flush()
+	// 磁盘表 1：覆盖 a，删除 b
+	put("a", "2")
+	del("b")
+	flush()
+	// 内存表：再次覆盖 a，删除 c
+	put("a", "3")
+	del("c")
+
+	rawScan := func(start, end string) []string {
+		t.Helper()
+		it, err := tree.RawScan([]byte(start), []byte(end))
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		defer it.Close()
+		if it.Sequence() != tree.Sequence() {
+			t.Fatalf("expected sequence %d, but got %d", tree.Sequence(), it.Sequence())
+		}
+
+		var entries []string
+		for it.Next() {
+			e := it.Entry()
+			if e.Tombstone {
+				entries = append(entries, fmt.Sprintf("%s@%d:deleted", e.Key, e.Generation))
+			} else {
+				entries = append(entries, fmt.Sprintf("%s@%d:%s", e.Key, e.Generation, e.Value))
+			}
+		}
+		if err := it.Err(); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		return entries
+	}
+
+	got := strings.Join(rawScan("", ""), " ")
+	want := "a@2:3 a@1:2 a@0:1 b@1:deleted b@0:1 c@2:deleted c@0:1"
+	if got != want {
+		t.Fatalf("expected %s, but got %s", want, got)
+	}
+
+	got = strings.Join(rawScan("b", "c"), " ")
+	if want := "b@1:deleted b@0:1"; got != want {
+		t.Fatalf("expected %s, but got %s", want, got)
+	}
+
+	// Scan 只返回最新的存活版本
+	it, err := tree.Scan(nil, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer it.Close()
+	var live []string
+	for it.Next() {
+		live = append(live, fmt.Sprintf("%s:%s", it.Key(), it.Value()))
+	}
+	if fmt.Sprint(live) != "[a:3]" {
+		t.Fatalf("expected [a:3], but got %v", live)
+	}
+
+	// 合并到最旧的磁盘表后，磁盘表中的旧版本和墓碑消失，只剩合并后的结果
+	if err := tree.compactDiskTables(context.Background()); err != nil {
+		t.Fatalf("failed to compact: %s", err)
+	}
+	got = strings.Join(rawScan("", ""), " ")
+	if want := "a@2:3 a@1:2 c@2:deleted c@1:1"; got != want {
+		t.Fatalf("expected %s after compaction, but got %s", want, got)
+	}
+}
@@ -0,0 +1,85 @@
+package lsmtree
+
+import "sync/atomic"
+
+// readAmpMinGets 是判断读放大之前至少需要的读取次数，避免少量读取的偶然结果触发合并。
+const readAmpMinGets = 100
+
+// CompactOnReadAmplification 开启按读放大触发合并（默认关闭）：自上次合并以来平均每次 Get 查找的磁盘表数量
+// 超过 threshold 时，后台任务按合并策略执行一次合并，与磁盘表的数量和大小无关。
+// 读多写少时很少刷新内存表，按数量触发的合并迟迟不会发生，而每次未命中的读取都要逐个查找所有磁盘表；
+// 该触发让合并跟随读取的代价。每次合并后重新统计，读放大仍然超过阈值时继续合并。
+func CompactOnReadAmplification(threshold float64) func(*LSMTree) {
+	return func(t *LSMTree) {
+		t.readAmpThreshold = threshold
+	}
+}
+
+// recordRead 记录一次 Get 给出结果的层级和查找的磁盘表数量，读放大超过阈值时通知后台任务合并。
+// 调用方持有读锁，多个读取可能同时调用。
+func (t *LSMTree) recordRead(tier ReadTier, tables int) {
+	atomic.AddInt64(&t.readSources[tier], 1)
+	t.recordAccess()
+	gets := atomic.AddInt64(&t.gets, 1)
+	read := atomic.AddInt64(&t.getTablesRead, int64(tables))
+	if t.readAmpCompaction == nil || gets < readAmpMinGets || float64(read)/float64(gets) <= t.readAmpThreshold {
+		return
+	}
+	select {
+	case t.readAmpCompaction <- struct{}{}:
+	default:
+		// 已经有待执行的合并
+	}
+}
+
+// resetReadAmplification 在磁盘表变化后重新统计读放大，调用方必须持有写锁。
+func (t *LSMTree) resetReadAmplification() {
+	atomic.StoreInt64(&t.gets, 0)
+	atomic.StoreInt64(&t.getTablesRead, 0)
+}
+
+// startReadAmpCompaction 启动按读放大合并的后台任务，Close 时退出。
+func (t *LSMTree) startReadAmpCompaction() {
+	t.readAmpCompaction = make(chan struct{}, 1)
+
+	t.background.Add(1)
+	go func() {
+		defer t.background.Done()
+
+		for {
+			select {
+			case <-t.ctx.Done():
+				return
+			case <-t.readAmpCompaction:
+			}
+			if err := t.compactForReads(); err != nil {
+				t.logger.Error("failed to compact for read amplification: %v", err)
+			}
+		}
+	}()
+}
+
+// compactForReads 在读放大仍然超过阈值时执行合并策略给出的第一个计划，策略没有计划时什么也不做。
+func (t *LSMTree) compactForReads() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	// Close 先取消 ctx 再获取写锁，之后不再修改磁盘表
+	if t.ctx.Err() != nil {
+		return nil
+	}
+	// 等待写锁期间可能已经发生过合并
+	stats := t.readStats()
+	if stats.Gets < readAmpMinGets || stats.ReadAmplification() <= t.readAmpThreshold {
+		return nil
+	}
+	if len(t.compactionStrategy.Plan(t.tableInfos())) == 0 {
+		return nil
+	}
+	return t.compactDiskTables(t.ctx)
+}
+
+// readStats 返回自上次合并以来的读取统计，只填充 Gets 和 GetTablesRead。
+func (t *LSMTree) readStats() Stats {
+	return Stats{Gets: atomic.LoadInt64(&t.gets), GetTablesRead: atomic.LoadInt64(&t.getTablesRead)}
+}
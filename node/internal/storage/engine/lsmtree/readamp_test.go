@@ -0,0 +1,100 @@
+package lsmtree
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+// openReadAmpTree 打开一个有 tables 个磁盘表的数据库，磁盘表数量不会触发合并
+func openReadAmpTree(t *testing.T, tables int, options ...func(*LSMTree)) *LSMTree {
+	options = append([]func(*LSMTree){DiskTableNumThreshold(100)}, options...)
+	tree, err := Open(t.TempDir(), options...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { tree.Close() })
+
+	for i := 0; i < tables; i++ {
+		key := []byte(fmt.Sprintf("key%03d", i))
+		if err := tree.Put(key, key); err != nil {
+			t.Fatal(err)
+		}
+		if err := tree.Flush(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return tree
+}
+
+func TestCompactOnReadAmplification(t *testing.T) {
+	listener := &testListener{
+		flushes:     make(chan TableInfo, 100),
+		compactions: make(chan compactionEvent, 100),
+	}
+	tree := openReadAmpTree(t, 8, CompactOnReadAmplification(4), Listeners(listener))
+
+	// 最新的磁盘表中的键只查找一张表，不触发合并
+	for i := 0; i < 2*readAmpMinGets; i++ {
+		if _, _, err := tree.Get([]byte("key007")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if amp := tree.Stats().ReadAmplification(); amp != 1 {
+		t.Fatalf("expected read amplification 1, but got %g", amp)
+	}
+	select {
+	case event := <-listener.compactions:
+		t.Fatalf("expected no compaction below the threshold, but got %v", event)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	// 不存在的键要查找所有 8 张磁盘表，读放大超过阈值后在后台合并
+	for i := 0; i < 3*readAmpMinGets; i++ {
+		if _, _, err := tree.Get([]byte("missing")); err != nil {
+			t.Fatal(err)
+		}
+	}
+	select {
+	case <-listener.compactions:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("expected a compaction, read amplification is %g", tree.Stats().ReadAmplification())
+	}
+
+	// 合并后重新统计读放大，合并之前的读取不再计入
+	if stats := tree.Stats(); stats.Gets >= 5*readAmpMinGets {
+		t.Fatalf("expected read statistics to be reset after compaction, but got %d gets", stats.Gets)
+	}
+}
+
+func TestReadAmplificationWithoutTrigger(t *testing.T) {
+	tree := openReadAmpTree(t, 5)
+
+	// 内存表中的键不查找磁盘表
+	if err := tree.Put([]byte("memtable"), []byte("value")); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"memtable", "missing"} {
+		for i := 0; i < readAmpMinGets; i++ {
+			if _, _, err := tree.Get([]byte(key)); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	stats := tree.Stats()
+	if stats.Gets != 2*readAmpMinGets || stats.GetTablesRead != 5*readAmpMinGets {
+		t.Fatalf("expected %d gets reading %d tables, but got %+v", 2*readAmpMinGets, 5*readAmpMinGets, stats)
+	}
+	if amp := stats.ReadAmplification(); amp != 2.5 {
+		t.Fatalf("expected read amplification 2.5, but got %g", amp)
+	}
+	// 没有开启按读放大合并时不合并
+	if tree.diskTableNum != 5 {
+		t.Fatalf("expected 5 disk tables to remain, but got %d", tree.diskTableNum)
+	}
+
+	if _, err := Open(t.TempDir(), CompactOnReadAmplification(-1)); err == nil {
+		t.Fatal("expected an error for a negative threshold")
+	}
+}
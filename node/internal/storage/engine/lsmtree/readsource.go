@@ -0,0 +1,68 @@
+package lsmtree
+
+import "strconv"
+
+// ReadTier 是给出 Get 结果的层级，详见 GetWithSource。
+type ReadTier int
+
+const (
+	// 查找了所有磁盘表都没有找到键。
+	ReadNotFound ReadTier = iota
+	// 固定的键，详见 PutPinned。
+	ReadFromPinned
+	// 当前的内存表。
+	ReadFromMemTable
+	// 等待刷新的不可变内存表。
+	ReadFromImmutableMemTable
+	// 负缓存判定键不存在，没有查找磁盘表，详见 NegativeCache。
+	ReadFromNegativeCache
+	// 磁盘表，编号见 ReadSource.DiskTable。
+	ReadFromDiskTable
+
+	numReadTiers = iota
+)
+
+func (tier ReadTier) String() string {
+	switch tier {
+	case ReadNotFound:
+		return "not-found"
+	case ReadFromPinned:
+		return "pinned"
+	case ReadFromMemTable:
+		return "memtable"
+	case ReadFromImmutableMemTable:
+		return "immutable-memtable"
+	case ReadFromNegativeCache:
+		return "negative-cache"
+	case ReadFromDiskTable:
+		return "disk-table"
+	}
+	return "ReadTier(" + strconv.Itoa(int(tier)) + ")"
+}
+
+// ReadSource 描述一次 Get 的结果来自哪里。
+type ReadSource struct {
+	Tier ReadTier
+	// 包含键的磁盘表编号，只在 Tier 为 ReadFromDiskTable 时有意义。
+	// 之后的合并和刷新会改变编号，编号只反映读取时的磁盘表。
+	DiskTable int
+}
+
+func (s ReadSource) String() string {
+	if s.Tier == ReadFromDiskTable {
+		return s.Tier.String() + " " + strconv.Itoa(s.DiskTable)
+	}
+	return s.Tier.String()
+}
+
+// GetWithSource 与 Get 相同，同时返回给出结果的层级，用于调整参数和排查读取路径。
+// 层级按 Get 的查找顺序依次为固定的键、内存表、不可变内存表、负缓存和磁盘表，结果来自第一个包含键的层级；
+// 键在某一层被删除（墓碑）时 found 为 false，source 是包含墓碑的层级。
+// 树中没有缓存值的块缓存，磁盘表中的值总是从数据文件读取。每个层级给出结果的次数汇总在 Stats 中。
+func (t *LSMTree) GetWithSource(key []byte) (value []byte, source ReadSource, found bool, err error) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	value, _, source, found, err = t.getWithSource(key)
+	return value, source, found, err
+}
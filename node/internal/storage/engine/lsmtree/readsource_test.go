@@ -0,0 +1,71 @@
+package lsmtree
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestGetWithSource(t *testing.T) {
+	tree, err := Open(t.TempDir(), MemTableThreshold(10), ImmutableMemtableMaxNum(10), DiskTableNumThreshold(100), NegativeCache(10, time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tree.Close()
+
+	// 超过阈值的写入使内存表变为不可变内存表
+	large := bytes.Repeat([]byte("v"), 10)
+	var diskTables []int
+	for _, key := range []string{"disk-old", "disk-new"} {
+		if err := tree.Put([]byte(key), large); err != nil {
+			t.Fatal(err)
+		}
+		if err := tree.Flush(); err != nil {
+			t.Fatal(err)
+		}
+		diskTables = append(diskTables, tree.maxDiskTableIndex)
+	}
+	if err := tree.Put([]byte("immutable"), large); err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.Put([]byte("mem"), []byte("1")); err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.PutPinned([]byte("pinned"), []byte("1")); err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.Delete([]byte("deleted")); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		key    string
+		source ReadSource
+		found  bool
+	}{
+		{"pinned", ReadSource{Tier: ReadFromPinned}, true},
+		{"mem", ReadSource{Tier: ReadFromMemTable}, true},
+		{"deleted", ReadSource{Tier: ReadFromMemTable}, false},
+		{"immutable", ReadSource{Tier: ReadFromImmutableMemTable}, true},
+		{"disk-old", ReadSource{Tier: ReadFromDiskTable, DiskTable: diskTables[0]}, true},
+		{"disk-new", ReadSource{Tier: ReadFromDiskTable, DiskTable: diskTables[1]}, true},
+		{"missing", ReadSource{Tier: ReadNotFound}, false},
+		// 第一次没有找到的键被负缓存记录
+		{"missing", ReadSource{Tier: ReadFromNegativeCache}, false},
+	}
+	for _, test := range tests {
+		_, source, found, err := tree.GetWithSource([]byte(test.key))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if source != test.source || found != test.found {
+			t.Errorf("%s: expected %v (%t), but got %v (%t)", test.key, test.source, test.found, source, found)
+		}
+	}
+
+	stats := tree.Stats()
+	if stats.GetsFromPinned != 1 || stats.GetsFromMemTable != 2 || stats.GetsFromImmutableMemTables != 1 ||
+		stats.GetsFromDiskTables != 2 || stats.GetsNotFound != 1 || stats.GetsFromNegativeCache != 1 {
+		t.Fatalf("unexpected read sources %+v", stats)
+	}
+}
@@ -0,0 +1,220 @@
+package lsmtree
+
+import (
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"math"
+)
+
+// RecordFormat 是磁盘表数据文件中记录的编码，与 Codec 一起记录在每个磁盘表中。
+// 修改 RecordEncoding 只影响之后刷新和合并写入的磁盘表，旧的磁盘表仍按写入时的编码读取。
+// 索引、稀疏索引和 WAL 总是使用 FixedRecords。
+type RecordFormat byte
+
+const (
+	// FixedRecords 用 8 字节记录总长度和键长度，是默认值：
+	// [编码的总长度（8 字节）][校验和][编码的键长度（8 字节）][键][值]
+	FixedRecords RecordFormat = iota
+	// VarintRecords 用变长整数记录两个长度，键和值都很小时每条记录可以节省十几个字节：
+	// [总长度（uvarint）][校验和][键长度（uvarint）][键][值]
+	VarintRecords
+)
+
+// RecordEncoding 设置新写入的磁盘表使用的记录编码（默认 FixedRecords）。
+func RecordEncoding(format RecordFormat) func(*LSMTree) {
+	return func(t *LSMTree) {
+		t.recordFormat = format
+	}
+}
+
+// tableFormat 返回新写入的磁盘表使用的格式。
+func (t *LSMTree) tableFormat() tableFormat {
+	return tableFormat{codec: t.compression, records: t.recordFormat, separateValues: t.separateValues}
+}
+
+func (f RecordFormat) String() string {
+	switch f {
+	case FixedRecords:
+		return "fixed"
+	case VarintRecords:
+		return "varint"
+	default:
+		return fmt.Sprintf("format(%d)", byte(f))
+	}
+}
+
+// valid 返回 f 是否是已知的记录编码。
+func (f RecordFormat) valid() bool {
+	return f <= VarintRecords
+}
+
+// encode 按 f 编码键、标志和值并写入 w，返回写入的字节数。flags 为 0 时不写入标志。
+func (f RecordFormat) encode(key, value []byte, flags uint32, w io.Writer) (int, error) {
+	if f == FixedRecords {
+		return encodeWithFlags(key, value, flags, w)
+	}
+
+	keyLen := uint64(len(key))
+	var encodedFlags []byte
+	if flags != 0 {
+		keyLen |= flaggedKeyLen
+		encodedFlags = binary.BigEndian.AppendUint32(nil, flags)
+	}
+	encodedKeyLen := binary.AppendUvarint(nil, keyLen)
+	var header [binary.MaxVarintLen64 + checksumLen]byte
+	n := binary.PutUvarint(header[:], uint64(checksumLen+len(encodedKeyLen)+len(key)+len(encodedFlags)+len(value)))
+
+	h := crc32.NewIEEE()
+	h.Write(encodedKeyLen)
+	h.Write(key)
+	h.Write(encodedFlags)
+	h.Write(value)
+	binary.BigEndian.PutUint32(header[n:], h.Sum32())
+
+	bytes := 0
+	for _, part := range [][]byte{header[:n+checksumLen], encodedKeyLen, key, encodedFlags, value} {
+		written, err := w.Write(part)
+		bytes += written
+		if err != nil {
+			return bytes, err
+		}
+	}
+	return bytes, nil
+}
+
+// decode 从 r 中解码一条按 f 编码的记录，返回键、值和标志，verify 为 false 时跳过校验和检查。
+// 与 decodeRecord 一样，没有值的记录返回 nil 的值，读到文件末尾时返回 io.EOF。
+func (f RecordFormat) decode(r io.Reader, verify bool) ([]byte, []byte, uint32, error) {
+	if f == FixedRecords {
+		return decodeRecordWithFlags(r, verify)
+	}
+
+	header, err := f.readHeader(r)
+	if err != nil {
+		return nil, nil, 0, err
+	}
+	rest := make([]byte, header.keyLen+header.flagsLen()+header.valueLen)
+	if _, err := io.ReadFull(r, rest); err != nil {
+		return nil, nil, 0, fmt.Errorf("the file is corrupted, failed to read entry: %w", err)
+	}
+	if verify {
+		h := crc32.NewIEEE()
+		h.Write(header.encodedKeyLen)
+		h.Write(rest)
+		if h.Sum32() != header.checksum {
+			return nil, nil, 0, ErrChecksumMismatch
+		}
+	}
+
+	key := rest[:header.keyLen]
+	var flags uint32
+	if header.flagged {
+		flags = binary.BigEndian.Uint32(rest[header.keyLen:])
+	}
+	if header.valueLen == 0 {
+		return key, nil, flags, nil
+	}
+	return key, rest[header.keyLen+header.flagsLen():], flags, nil
+}
+
+// tombstoneLen 返回按 f 编码的、键长度为 keyLen 的墓碑（没有值和标志）在数据文件中占用的字节数。
+func (f RecordFormat) tombstoneLen(keyLen int) int {
+	if f == FixedRecords {
+		return 8 + checksumLen + 8 + keyLen
+	}
+	var buf [binary.MaxVarintLen64]byte
+	entryLen := checksumLen + binary.PutUvarint(buf[:], uint64(keyLen)) + keyLen
+	return binary.PutUvarint(buf[:], uint64(entryLen)) + entryLen
+}
+
+// recordHeader 是记录中键之前的部分。
+type recordHeader struct {
+	checksum uint32
+	// 校验和覆盖编码的键长度、键、标志和值
+	encodedKeyLen []byte
+	keyLen        int
+	// 键之后是否有标志
+	flagged  bool
+	valueLen int
+}
+
+// flagsLen 返回键之后的标志占用的字节数。
+func (h recordHeader) flagsLen() int {
+	if h.flagged {
+		return flagsLen
+	}
+	return 0
+}
+
+// readHeader 从 r 中读取一条按 f 编码的记录的头部，r 停在键的开头。
+// r 上没有更多记录时返回 io.EOF。为了不越过记录读取，变长整数逐字节读取。
+func (f RecordFormat) readHeader(r io.Reader) (recordHeader, error) {
+	var entryLen int
+	var header recordHeader
+	if f == FixedRecords {
+		var fixed [8 + checksumLen + 8]byte
+		if _, err := io.ReadFull(r, fixed[:]); err != nil {
+			if err == io.ErrUnexpectedEOF {
+				return header, fmt.Errorf("the file is corrupted, failed to read entry header: %w", err)
+			}
+			return header, err
+		}
+		entryLen = decodeInt(fixed[0:8])
+		header.checksum = binary.BigEndian.Uint32(fixed[8 : 8+checksumLen])
+		header.encodedKeyLen = fixed[8+checksumLen:]
+		header.keyLen, header.flagged = splitKeyLen(decodeInt(header.encodedKeyLen))
+	} else {
+		br := byteReader{r}
+		n, err := binary.ReadUvarint(br)
+		if err == io.EOF {
+			return header, err
+		}
+		if err != nil {
+			return header, fmt.Errorf("the file is corrupted, failed to read entry length: %w", err)
+		}
+		if n > math.MaxInt32 {
+			return header, fmt.Errorf("the file is corrupted, invalid entry length %d", n)
+		}
+		entryLen = int(n)
+
+		var checksum [checksumLen]byte
+		if _, err := io.ReadFull(r, checksum[:]); err != nil {
+			return header, fmt.Errorf("the file is corrupted, failed to read entry header: %w", err)
+		}
+		header.checksum = binary.BigEndian.Uint32(checksum[:])
+
+		keyLen, err := binary.ReadUvarint(br)
+		if err != nil {
+			return header, fmt.Errorf("the file is corrupted, failed to read key length: %w", err)
+		}
+		// 按规范的编码重新得到键长度的字节，被篡改的非规范编码会在校验和上暴露
+		header.encodedKeyLen = binary.AppendUvarint(nil, keyLen)
+		header.flagged = keyLen&flaggedKeyLen != 0
+		keyLen &^= flaggedKeyLen
+		if keyLen > n {
+			return header, fmt.Errorf("the file is corrupted, invalid key length %d", keyLen)
+		}
+		header.keyLen = int(keyLen)
+	}
+
+	header.valueLen = entryLen - checksumLen - len(header.encodedKeyLen) - header.keyLen - header.flagsLen()
+	if header.keyLen < 0 || header.valueLen < 0 {
+		return header, fmt.Errorf("the file is corrupted, invalid entry length %d", entryLen)
+	}
+	return header, nil
+}
+
+// byteReader 逐字节地从 r 中读取，不会多读。
+type byteReader struct {
+	r io.Reader
+}
+
+func (b byteReader) ReadByte() (byte, error) {
+	var p [1]byte
+	if _, err := io.ReadFull(b.r, p[:]); err != nil {
+		return 0, err
+	}
+	return p[0], nil
+}
@@ -0,0 +1,262 @@
+package lsmtree
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestRecordFormatRoundTrip(t *testing.T) {
+	records := []struct {
+		key, value []byte
+		flags      uint32
+	}{
+		{[]byte("k"), []byte("v"), 0},
+		{[]byte("tombstone"), nil, 0},
+		// 超过 127 字节的长度需要多个字节的变长整数
+		{bytes.Repeat([]byte("k"), 300), bytes.Repeat([]byte("v"), 70000), 0},
+		{[]byte("flagged"), []byte("v"), 0xdeadbeef},
+	}
+	for _, format := range []RecordFormat{FixedRecords, VarintRecords} {
+		buf := new(bytes.Buffer)
+		for _, record := range records {
+			n, err := format.encode(record.key, record.value, record.flags, buf)
+			if err != nil {
+				t.Fatalf("%s: unexpected error: %s", format, err)
+			}
+			size := 8 + checksumLen + 8 + len(record.key) + len(record.value)
+			if record.flags != 0 {
+				size += flagsLen
+			}
+			if format == FixedRecords && n != size {
+				t.Fatalf("%s: unexpected record size %d", format, n)
+			}
+		}
+		r := bytes.NewReader(buf.Bytes())
+		for _, record := range records {
+			key, value, flags, err := format.decode(r, true)
+			if err != nil {
+				t.Fatalf("%s: unexpected error: %s", format, err)
+			}
+			if !bytes.Equal(key, record.key) || (value == nil) != (record.value == nil) || !bytes.Equal(value, record.value) {
+				t.Fatalf("%s: expected %q, but got %q", format, record.key, key)
+			}
+			if flags != record.flags {
+				t.Fatalf("%s: expected flags %x for %q, but got %x", format, record.flags, record.key, flags)
+			}
+		}
+		if _, _, _, err := format.decode(r, true); err != io.EOF {
+			t.Fatalf("%s: expected io.EOF at the end, but got %v", format, err)
+		}
+
+		// 截断和损坏的记录返回错误
+		data := buf.Bytes()
+		if _, _, _, err := format.decode(bytes.NewReader(data[:3]), true); err == nil || err == io.EOF {
+			t.Fatalf("%s: expected an error for a truncated record, but got %v", format, err)
+		}
+		corrupted := append([]byte(nil), data...)
+		corrupted[len(corrupted)-1] ^= 0xff
+		r = bytes.NewReader(corrupted)
+		var err error
+		for range records {
+			_, _, _, err = format.decode(r, true)
+		}
+		if !errors.Is(err, ErrChecksumMismatch) {
+			t.Fatalf("%s: expected a checksum mismatch, but got %v", format, err)
+		}
+	}
+
+	// 3 字节的键和值只需要 2 字节而不是 16 字节的长度
+	fixed, _ := FixedRecords.encode([]byte("key"), []byte("val"), 0, io.Discard)
+	varint, _ := VarintRecords.encode([]byte("key"), []byte("val"), 0, io.Discard)
+	if fixed-varint != 14 {
+		t.Fatalf("expected varint records to save 14 bytes, but got %d and %d", fixed, varint)
+	}
+
+	if _, err := Open(t.TempDir(), RecordEncoding(RecordFormat(100))); err == nil {
+		t.Fatal("expected an error for an unknown record format")
+	}
+}
+
+func TestMixedRecordFormats(t *testing.T) {
+	dbDir := t.TempDir()
+	options := []func(*LSMTree){MemTableMaxEntries(10), ImmutableMemtableMaxNum(1), DiskTableNumThreshold(100)}
+
+	// 先用 FixedRecords 写入一批磁盘表
+	tree, err := Open(dbDir, options...)
+	if err != nil {
+		t.Fatalf("failed to open LSM tree %s: %s", dbDir, err)
+	}
+	expected := make(map[string]string)
+	for i := 0; i < 40; i++ {
+		key := fmt.Sprintf("key%03d", i)
+		if err := tree.Put([]byte(key), []byte("v1")); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		expected[key] = "v1"
+	}
+	if err := tree.Close(); err != nil {
+		t.Fatalf("failed to close: %s", err)
+	}
+
+	// 之后用 VarintRecords 和压缩写入，一半的键被覆盖，部分键被删除
+	tree, err = Open(dbDir, append(options, RecordEncoding(VarintRecords), Compression(Snappy))...)
+	if err != nil {
+		t.Fatalf("failed to open LSM tree %s: %s", dbDir, err)
+	}
+	defer tree.Close()
+	for i := 20; i < 60; i++ {
+		key := fmt.Sprintf("key%03d", i)
+		value := strings.Repeat("v2", i)
+		if err := tree.Put([]byte(key), []byte(value)); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		expected[key] = value
+	}
+	for i := 0; i < 60; i += 7 {
+		key := fmt.Sprintf("key%03d", i)
+		if err := tree.Delete([]byte(key)); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		delete(expected, key)
+	}
+	if err := tree.Flush(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	formats := make(map[tableFormat]int)
+	oldest := tree.maxDiskTableIndex - tree.diskTableNum + 1
+	for index := oldest; index <= tree.maxDiskTableIndex; index++ {
+		format, err := readTableFormat(dbDir, strconv.Itoa(index)+"-")
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		formats[format]++
+	}
+	if formats[tableFormat{}] == 0 || formats[tableFormat{codec: Snappy, records: VarintRecords}] == 0 || len(formats) != 2 {
+		t.Fatalf("expected both fixed and varint tables, but got %v", formats)
+	}
+
+	check := func() {
+		t.Helper()
+		for i := 0; i < 60; i++ {
+			key := fmt.Sprintf("key%03d", i)
+			got, ok, err := tree.Get([]byte(key))
+			want, exists := expected[key]
+			if err != nil || ok != exists || string(got) != want {
+				t.Fatalf("value is wrong for key %s: %q (%v) != %q (%v), err: %v", key, got, ok, want, exists, err)
+			}
+
+			r, ok, err := tree.OpenValue([]byte(key))
+			if err != nil || ok != exists {
+				t.Fatalf("expected %s to exist: %v, but got %v (%v)", key, exists, ok, err)
+			}
+			if ok {
+				got, err := io.ReadAll(r)
+				r.Close()
+				if err != nil || string(got) != want {
+					t.Fatalf("streamed value is wrong for key %s: %q != %q (%v)", key, got, want, err)
+				}
+			}
+		}
+
+		keys := collectKeys(t, func() (*Iterator, error) { return tree.Scan(nil, nil) })
+		if len(keys) != len(expected) {
+			t.Fatalf("expected %d keys in scan, but got %d", len(expected), len(keys))
+		}
+		if err := tree.Verify(); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+	check()
+
+	// 合并后只剩一个以当前格式重写的磁盘表
+	for tree.diskTableNum > 1 {
+		if err := tree.compactDiskTables(context.Background()); err != nil {
+			t.Fatalf("failed to compact: %s", err)
+		}
+	}
+	format, err := readTableFormat(dbDir, strconv.Itoa(tree.maxDiskTableIndex)+"-")
+	if err != nil || format.records != VarintRecords {
+		t.Fatalf("expected the merged table to use varint records, but got %s (%v)", format.records, err)
+	}
+	check()
+}
+
+func TestReadTableFormat(t *testing.T) {
+	dbDir := t.TempDir()
+	for _, tc := range []struct {
+		data []byte
+		ok   bool
+	}{
+		{[]byte{byte(Snappy)}, true},
+		{[]byte{byte(NoCompression), byte(VarintRecords)}, true},
+		{[]byte{byte(Zstd), byte(VarintRecords)}, true},
+		// FixedRecords 不写入第二个字节
+		{[]byte{byte(Snappy), byte(FixedRecords)}, false},
+		{[]byte{byte(Snappy), 100}, false},
+		{[]byte{byte(Snappy), byte(VarintRecords), 0}, false},
+		{nil, false},
+	} {
+		if err := os.WriteFile(path.Join(dbDir, "0-"+diskTableCodecFileName), tc.data, 0600); err != nil {
+			t.Fatal(err)
+		}
+		format, err := readTableFormat(dbDir, "0-")
+		if (err == nil) != tc.ok {
+			t.Fatalf("%v: expected ok %v, but got %v (%v)", tc.data, tc.ok, format, err)
+		}
+		if err == nil {
+			if err := writeTableFormat(dbDir, "1-", format); err != nil {
+				t.Fatal(err)
+			}
+			if written, err := readTableFormat(dbDir, "1-"); err != nil || written != format {
+				t.Fatalf("%v: expected %v to round trip, but got %v (%v)", tc.data, format, written, err)
+			}
+		}
+	}
+}
+
+func BenchmarkRecordFormatSize(b *testing.B) {
+	count := 10000
+	value := []byte("val")
+	for _, format := range []RecordFormat{FixedRecords, VarintRecords} {
+		b.Run(format.String(), func(b *testing.B) {
+			var dataSize int64
+			var tableSize int
+			for i := 0; i < b.N; i++ {
+				dbDir := b.TempDir()
+				tree, err := Open(dbDir, MemTableThreshold(64<<20), DisableWAL(true), RecordEncoding(format))
+				if err != nil {
+					b.Fatalf("failed to open LSM tree %s: %s", dbDir, err)
+				}
+				// 3 字节的键和值
+				for j := 0; j < count; j++ {
+					if err := tree.Put([]byte{byte(j >> 16), byte(j >> 8), byte(j)}, value); err != nil {
+						b.Fatal(err)
+					}
+				}
+				if err := tree.Flush(); err != nil {
+					b.Fatal(err)
+				}
+				info := tree.tableInfos()[0]
+				dataSize, err = GetFileSize(path.Join(dbDir, strconv.Itoa(info.Index)+"-"+diskTableDataFileName))
+				if err != nil {
+					b.Fatal(err)
+				}
+				tableSize = diskTableSize(dbDir, info.Index)
+				if err := tree.Close(); err != nil {
+					b.Fatal(err)
+				}
+			}
+			b.ReportMetric(float64(dataSize)/float64(count), "data-B/record")
+			b.ReportMetric(float64(tableSize)/float64(count), "table-B/record")
+		})
+	}
+}
@@ -0,0 +1,208 @@
+package lsmtree
+
+import (
+	"fmt"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// diskTableFileNames 是一个磁盘表可能包含的文件，按 renameDiskTable 重命名的顺序排列。
+// values 只在分开存放值时存在，codec 只在压缩或不使用默认格式时存在。
+var diskTableFileNames = []string{diskTableDataFileName, diskTableValuesFileName, diskTableCodecFileName, diskTableIndexFileName, diskTableSparseIndexFileName}
+
+// CompactOnOpen 让 Open 在清理完崩溃留下的文件后，按合并策略合并磁盘表，直到磁盘表数量低于阈值
+// 且策略不再要求合并（默认关闭）。崩溃可能打断合并，使磁盘表数量超过阈值，开启后 Open 会先补上这些合并。
+func CompactOnOpen() func(*LSMTree) {
+	return func(t *LSMTree) {
+		t.compactOnOpen = true
+	}
+}
+
+// tableFiles 记录一个前缀下存在的磁盘表文件。
+type tableFiles map[string]bool
+
+// complete 判断磁盘表的数据、索引和稀疏索引文件是否都存在。
+func (f tableFiles) complete() bool {
+	return f[diskTableDataFileName] && f[diskTableIndexFileName] && f[diskTableSparseIndexFileName]
+}
+
+// listDiskTableFiles 返回 dbDir 中编号磁盘表的文件和合并输出的文件。
+func listDiskTableFiles(dbDir string) (map[int]tableFiles, tableFiles, error) {
+	entries, err := os.ReadDir(dbDir)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to read directory %s: %w", dbDir, err)
+	}
+
+	tables := make(map[int]tableFiles)
+	merge := make(tableFiles)
+	for _, entry := range entries {
+		for _, kind := range diskTableFileNames {
+			name := entry.Name()
+			if !strings.HasSuffix(name, kind) {
+				continue
+			}
+			prefix := strings.TrimSuffix(name, kind)
+			if prefix == mergeDiskTablePrefix {
+				merge[kind] = true
+				continue
+			}
+			index, err := strconv.Atoi(strings.TrimSuffix(prefix, "-"))
+			if err != nil || !strings.HasSuffix(prefix, "-") || index < 0 {
+				continue
+			}
+			if tables[index] == nil {
+				tables[index] = make(tableFiles)
+			}
+			tables[index][kind] = true
+		}
+	}
+
+	return tables, merge, nil
+}
+
+// removeTableFiles 删除前缀下存在的文件。
+func removeTableFiles(dbDir, prefix string, files tableFiles) error {
+	for kind := range files {
+		filePath := path.Join(dbDir, prefix+kind)
+		if err := os.Remove(filePath); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove %s: %w", filePath, err)
+		}
+	}
+	return nil
+}
+
+// finishRename 补完被打断的 renameDiskTable：数据文件已经移到 newPrefix，把 oldPrefix 下剩余的文件也移过去。
+func finishRename(dbDir, oldPrefix, newPrefix string, files tableFiles) error {
+	for _, kind := range diskTableFileNames[1:] {
+		if !files[kind] {
+			continue
+		}
+		if err := os.Rename(path.Join(dbDir, oldPrefix+kind), path.Join(dbDir, newPrefix+kind)); err != nil {
+			return fmt.Errorf("failed to rename %s%s: %w", oldPrefix, kind, err)
+		}
+	}
+	return nil
+}
+
+// recoverDiskTables 在 Open 时按清单清理崩溃留下的文件，使目录中的磁盘表与清单一致，必须在加载 WAL 之前调用。
+// 可以处理的情况：
+//   - 清单中有没有完成的合并：按其中的编辑删除剩余的输入、把合并输出移到目标编号，再补完更旧的磁盘表的后移；
+//   - 没有提交的合并输出：删除它，输入的磁盘表保持不变；
+//   - 重命名磁盘表时只移动了一部分文件：把剩余的文件移过去；
+//   - 刷新写出了磁盘表但没有追加到清单：删除它，其中的数据仍在 WAL 中；
+//   - 被替换、等待删除的磁盘表：读取它们的迭代器随进程结束，直接删除。
+//
+// 只剩索引而没有数据文件的磁盘表是被打断的删除，直接清理；有数据文件但缺少索引的磁盘表，
+// 以及清理后磁盘表的数量与清单不符时无法解释，返回错误。最后把清单重写为快照。
+func (t *LSMTree) recoverDiskTables() error {
+	pending := t.manifest.state.pending
+	if pending != nil {
+		if err := applyCompactionEdit(t.dbDir, *pending); err != nil {
+			return err
+		}
+		t.logger.Warn("finished committed compaction of disk tables %v into %d in %s", pending.inputs, pending.output, t.dbDir)
+	}
+
+	tables, merge, err := listDiskTableFiles(t.dbDir)
+	if err != nil {
+		return err
+	}
+	if len(merge) > 0 {
+		if err := removeTableFiles(t.dbDir, mergeDiskTablePrefix, merge); err != nil {
+			return err
+		}
+		t.logger.Warn("removed uncommitted merge output in %s", t.dbDir)
+	}
+
+	live, err := t.finishDiskTableRenames(tables)
+	if err != nil {
+		return err
+	}
+	if removed, err := removeObsoleteDiskTables(t.dbDir); err != nil {
+		return err
+	} else if removed > 0 {
+		t.logger.Warn("removed %d files of replaced disk tables in %s", removed, t.dbDir)
+	}
+	if len(live) != t.diskTableNum {
+		return fmt.Errorf("found %d disk tables in %s, but the manifest has %d", len(live), t.dbDir, t.diskTableNum)
+	}
+
+	// 合并后更旧的磁盘表没有全部后移时，按从新到旧的顺序移到以 maxDiskTableIndex 结尾的连续编号上
+	moved, err := moveDiskTables(t.dbDir, live, t.maxDiskTableIndex)
+	if err != nil {
+		return err
+	}
+	if moved > 0 {
+		t.logger.Warn("moved %d disk tables in %s to close gaps", moved, t.dbDir)
+	}
+	if pending != nil || moved > 0 {
+		if err := syncDir(t.dbDir); err != nil {
+			return err
+		}
+	}
+	if pending != nil {
+		if _, err := t.manifest.append(editCompactionDone); err != nil {
+			return err
+		}
+	}
+
+	return t.manifest.rewrite()
+}
+
+// finishDiskTableRenames 补完被打断的后移，删除不属于 maxDiskTableIndex 以内的磁盘表和被打断的删除留下的文件，
+// 返回按从新到旧排列的完整的磁盘表。
+func (t *LSMTree) finishDiskTableRenames(tables map[int]tableFiles) ([]int, error) {
+	// 合并后的后移只会把磁盘表移到下一个编号上
+	for index, files := range tables {
+		next := tables[index+1]
+		if !files[diskTableDataFileName] && next != nil && next[diskTableDataFileName] && !next.complete() {
+			if err := finishRename(t.dbDir, strconv.Itoa(index)+"-", strconv.Itoa(index+1)+"-", files); err != nil {
+				return nil, err
+			}
+			t.logger.Warn("finished interrupted rename of disk table %d to %d in %s", index, index+1, t.dbDir)
+		}
+	}
+	tables, _, err := listDiskTableFiles(t.dbDir)
+	if err != nil {
+		return nil, err
+	}
+
+	var live []int
+	for index, files := range tables {
+		prefix := strconv.Itoa(index) + "-"
+		switch {
+		case index > t.maxDiskTableIndex:
+			if err := removeTableFiles(t.dbDir, prefix, files); err != nil {
+				return nil, err
+			}
+			t.logger.Warn("removed disk table %d in %s that is not in the manifest", index, t.dbDir)
+		case !files[diskTableDataFileName]:
+			if err := removeTableFiles(t.dbDir, prefix, files); err != nil {
+				return nil, err
+			}
+			t.logger.Warn("removed leftover files of deleted disk table %d in %s", index, t.dbDir)
+		case !files.complete():
+			return nil, fmt.Errorf("disk table %d in %s is missing files", index, t.dbDir)
+		default:
+			live = append(live, index)
+		}
+	}
+	sort.Sort(sort.Reverse(sort.IntSlice(live)))
+	return live, nil
+}
+
+// compactAfterOpen 在开启 CompactOnOpen 时按合并策略合并磁盘表。
+func (t *LSMTree) compactAfterOpen() error {
+	for t.diskTableNum >= t.diskTableNumThreshold || t.compactionTriggered() {
+		if len(t.compactionStrategy.Plan(t.tableInfos())) == 0 {
+			return nil
+		}
+		if err := t.compactDiskTables(t.ctx); err != nil {
+			return fmt.Errorf("failed to compact on open: %w", err)
+		}
+	}
+	return nil
+}
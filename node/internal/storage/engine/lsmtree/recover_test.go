@@ -0,0 +1,188 @@
+package lsmtree
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"path"
+	"strconv"
+	"testing"
+)
+
+func TestOpenRecoversFromCrash(t *testing.T) {
+	options := []func(*LSMTree){MemTableMaxEntries(10), ImmutableMemtableMaxNum(1), DiskTableNumThreshold(100)}
+
+	// setup 写入多个磁盘表，同一个键在不同的表中有不同的版本，返回最终的键值和最旧、最新的编号
+	setup := func(t *testing.T, dbDir string) (map[string]string, int, int) {
+		tree, err := Open(dbDir, options...)
+		if err != nil {
+			t.Fatalf("failed to open LSM tree %s: %s", dbDir, err)
+		}
+		expected := make(map[string]string)
+		for i := 0; i < 80; i++ {
+			key, value := fmt.Sprintf("key%02d", i%25), fmt.Sprintf("value%d", i)
+			if err := tree.Put([]byte(key), []byte(value)); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			expected[key] = value
+		}
+		oldest, max := tree.maxDiskTableIndex-tree.diskTableNum+1, tree.maxDiskTableIndex
+		if max-oldest < 3 {
+			t.Fatalf("expected at least 4 disk tables, but got %d", max-oldest+1)
+		}
+		if err := tree.Close(); err != nil {
+			t.Fatalf("failed to close: %s", err)
+		}
+		return expected, oldest, max
+	}
+	prefix := func(index int) string {
+		return strconv.Itoa(index) + "-"
+	}
+	// merge 合并 oldest+1 和 oldest+2 并提交，模拟 compactDiskTables 在后移更旧的磁盘表之前崩溃
+	merge := func(t *testing.T, dbDir string, oldest int) {
+		edit := compactionEdit{inputs: []int{oldest + 2, oldest + 1}, output: oldest + 2}
+		if _, err := writeMergedDiskTable(context.Background(), dbDir, edit.inputs, defaultSparseKeyDistance, tableFormat{}, false, nil); err != nil {
+			t.Fatalf("failed to merge: %s", err)
+		}
+		appendTestEdit(t, dbDir, editCompaction, edit.output, edit.inputs[0], edit.inputs[1])
+		if err := applyCompactionEdit(dbDir, edit); err != nil {
+			t.Fatal(err)
+		}
+	}
+	rename := func(t *testing.T, dbDir, from, to string) {
+		if err := os.Rename(path.Join(dbDir, from), path.Join(dbDir, to)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	tests := []struct {
+		name  string
+		crash func(t *testing.T, dbDir string, oldest, max int)
+	}{
+		{
+			name: "half-written merge output",
+			crash: func(t *testing.T, dbDir string, oldest, max int) {
+				if err := os.WriteFile(path.Join(dbDir, mergeDiskTablePrefix+diskTableDataFileName), []byte("partial"), 0600); err != nil {
+					t.Fatal(err)
+				}
+			},
+		},
+		{
+			name: "inputs deleted before the merge output was moved",
+			crash: func(t *testing.T, dbDir string, oldest, max int) {
+				merge(t, dbDir, oldest)
+				if err := renameDiskTable(dbDir, prefix(oldest+2), mergeDiskTablePrefix); err != nil {
+					t.Fatal(err)
+				}
+			},
+		},
+		{
+			name: "merge output partially renamed",
+			crash: func(t *testing.T, dbDir string, oldest, max int) {
+				merge(t, dbDir, oldest)
+				rename(t, dbDir, prefix(oldest+2)+diskTableIndexFileName, mergeDiskTablePrefix+diskTableIndexFileName)
+				rename(t, dbDir, prefix(oldest+2)+diskTableSparseIndexFileName, mergeDiskTablePrefix+diskTableSparseIndexFileName)
+			},
+		},
+		{
+			name: "merged before shifting the older tables",
+			crash: func(t *testing.T, dbDir string, oldest, max int) {
+				merge(t, dbDir, oldest)
+			},
+		},
+		{
+			name: "older tables partially shifted",
+			crash: func(t *testing.T, dbDir string, oldest, max int) {
+				merge(t, dbDir, oldest)
+				rename(t, dbDir, prefix(oldest)+diskTableDataFileName, prefix(oldest+1)+diskTableDataFileName)
+			},
+		},
+		{
+			name: "flushed before adding the table to the manifest",
+			crash: func(t *testing.T, dbDir string, oldest, max int) {
+				for _, kind := range []string{diskTableDataFileName, diskTableIndexFileName} {
+					if err := copyFile(path.Join(dbDir, prefix(oldest)+kind), path.Join(dbDir, prefix(max+1)+kind)); err != nil {
+						t.Fatal(err)
+					}
+				}
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dbDir := t.TempDir()
+			expected, oldest, max := setup(t, dbDir)
+			tt.crash(t, dbDir, oldest, max)
+
+			logger := &testLogger{}
+			tree, err := Open(dbDir, append(options, WithLogger(logger))...)
+			if err != nil {
+				t.Fatalf("failed to open after crash: %s", err)
+			}
+			defer tree.Close()
+			if _, ok := logger.find("WARN "); !ok {
+				t.Fatalf("expected the recovery to be logged")
+			}
+
+			tables, mergeFiles, err := listDiskTableFiles(dbDir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(mergeFiles) != 0 {
+				t.Fatalf("expected merge output to be gone, but got %v", mergeFiles)
+			}
+			if len(tables) != tree.diskTableNum {
+				t.Fatalf("expected %d disk tables on disk, but got %d", tree.diskTableNum, len(tables))
+			}
+			for index := tree.maxDiskTableIndex - tree.diskTableNum + 1; index <= tree.maxDiskTableIndex; index++ {
+				if !tables[index].complete() {
+					t.Fatalf("expected disk table %d to be complete, but got %v", index, tables[index])
+				}
+			}
+			if err := tree.Verify(); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			for key, want := range expected {
+				value, ok, err := tree.Get([]byte(key))
+				if err != nil || !ok || string(value) != want {
+					t.Fatalf("expected %s for %s, but got %s (%v, %v)", want, key, value, ok, err)
+				}
+			}
+		})
+	}
+}
+
+func TestCompactOnOpen(t *testing.T) {
+	dbDir := t.TempDir()
+	tree, err := Open(dbDir, MemTableMaxEntries(10), ImmutableMemtableMaxNum(1), DiskTableNumThreshold(100))
+	if err != nil {
+		t.Fatalf("failed to open LSM tree %s: %s", dbDir, err)
+	}
+	for i := 0; i < 80; i++ {
+		if err := tree.Put([]byte(fmt.Sprintf("key%02d", i)), []byte("value")); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+	if tree.diskTableNum < 4 {
+		t.Fatalf("expected at least 4 disk tables, but got %d", tree.diskTableNum)
+	}
+	if err := tree.Close(); err != nil {
+		t.Fatalf("failed to close: %s", err)
+	}
+
+	tree, err = Open(dbDir, DiskTableNumThreshold(3), CompactOnOpen())
+	if err != nil {
+		t.Fatalf("failed to open LSM tree %s: %s", dbDir, err)
+	}
+	defer tree.Close()
+	if tree.diskTableNum >= 3 {
+		t.Fatalf("expected fewer than 3 disk tables after open, but got %d", tree.diskTableNum)
+	}
+	for i := 0; i < 80; i++ {
+		key := fmt.Sprintf("key%02d", i)
+		if value, ok, err := tree.Get([]byte(key)); err != nil || !ok || string(value) != "value" {
+			t.Fatalf("expected value for %s, but got %s (%v, %v)", key, value, ok, err)
+		}
+	}
+}
@@ -0,0 +1,404 @@
+package lsmtree
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strconv"
+)
+
+// Scan 返回按键升序遍历 [start, end) 范围内键值对的迭代器，已删除的键会被跳过。
+// 边界的语义如下，对内存表和磁盘表一致：
+//   - 范围是半开区间，包含等于 start 的键，不包含等于 end 的键；
+//   - start 为空（nil 或长度为 0）表示从第一个键开始，end 为空表示遍历到最后一个键，
+//     因此 Scan(nil, nil) 遍历所有键，Scan(start, nil) 遍历到最后一个键；
+//   - start 不小于 end 时范围为空，例如 Scan(k, k) 不返回任何键。
+//
+// 迭代器基于调用时的快照：内存表中的条目被复制出来，磁盘表的数据文件在调用时打开，
+// 之后的写入和合并不会影响它。使用完毕后必须调用 Close 释放打开的文件；
+// 以 -tags lsmdebug 构建时，没有关闭就被垃圾回收的迭代器会输出带创建位置的警告。
+func (t *LSMTree) Scan(start, end []byte) (*Iterator, error) {
+	if len(start) == 0 {
+		start = nil
+	}
+	if len(end) == 0 {
+		end = nil
+	}
+	if start != nil && end != nil && bytes.Compare(start, end) >= 0 {
+		return &Iterator{done: true}, nil
+	}
+
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	// 输入按从新到旧排列，键相同时取最靠前（最新）的值
+	sources := []scanSource{newMemTableSource(t.pinned, start, end), newMemTableSource(t.memTable, start, end)}
+	for i := len(t.immutableMemtables) - 1; i >= 0; i-- {
+		sources = append(sources, newMemTableSource(t.immutableMemtables[i], start, end))
+	}
+
+	oldest := t.maxDiskTableIndex - t.diskTableNum + 1
+	for index := t.maxDiskTableIndex; index >= oldest; index-- {
+		source, err := newDiskTableSource(t.dbDir, index, start, t.readAhead)
+		if err != nil {
+			closeScanSources(sources)
+			return nil, fmt.Errorf("failed to open disk table %d: %w", index, err)
+		}
+		if source != nil {
+			sources = append(sources, source)
+		}
+	}
+
+	it := &Iterator{sources: sources, end: end, release: t.readers.acquire(t.manifest.state.version)}
+	trackIterator(it, t.logger)
+	t.recordAccess()
+	return it, nil
+}
+
+// ReadAhead 为 LSMTree 设置 readAhead（默认 64 KB），即 Scan 遍历磁盘表时每次从数据文件预读的字节数。
+// 顺序遍历时较大的预读把许多小的读取合并为少量大块读取；每个迭代器为每个磁盘表分配一块预读缓冲区，
+// 磁盘表较多或同时打开很多迭代器时会相应地占用内存。0 表示不预读，每条记录直接从文件读取。
+func ReadAhead(readAhead int) func(*LSMTree) {
+	return func(t *LSMTree) {
+		t.readAhead = readAhead
+	}
+}
+
+// ScanPrefix 返回按键升序遍历所有以 prefix 开头的键的迭代器，空的 prefix 遍历所有键，
+// 其余语义与 Scan 相同。
+func (t *LSMTree) ScanPrefix(prefix []byte) (*Iterator, error) {
+	return t.Scan(prefix, prefixEnd(prefix))
+}
+
+// prefixEnd 返回大于所有以 prefix 开头的键的最小键，prefix 为空或全部由 0xff 组成时返回 nil（不限制）。
+func prefixEnd(prefix []byte) []byte {
+	for i := len(prefix) - 1; i >= 0; i-- {
+		if prefix[i] != 0xff {
+			end := make([]byte, i+1)
+			copy(end, prefix)
+			end[i]++
+			return end
+		}
+	}
+	return nil
+}
+
+// Iterator 是 Scan 返回的范围迭代器，合并所有内存表和磁盘表的有序输入。
+//
+//	it, err := tree.Scan(start, end)
+//	...
+//	defer it.Close()
+//	for it.Next() {
+//		key, value := it.Key(), it.Value()
+//	}
+//	if err := it.Err(); err != nil { ... }
+type Iterator struct {
+	sources []scanSource
+	end     []byte
+	// 注销迭代器对磁盘表的读取，没有打开磁盘表时为 nil，详见 tableReaders
+	release func()
+
+	// 最多返回的键数量，0 表示不限制
+	limit int
+	count int
+	// 是否只返回键，详见 ScanKeys
+	keysOnly bool
+
+	key   []byte
+	value []byte
+	token []byte
+	done  bool
+	err   error
+}
+
+// Limit 限制迭代器最多返回 n 个键（n <= 0 表示不限制）。
+// 达到上限且范围内还有剩余的键时，Token 返回续读令牌。
+func (it *Iterator) Limit(n int) *Iterator {
+	it.limit = n
+	return it
+}
+
+// Next 前进到下一个键值对，没有更多键值对或发生错误时返回 false。
+func (it *Iterator) Next() bool {
+	if it.done || it.err != nil {
+		return false
+	}
+
+	key, value, ok := it.nextLive()
+	if !ok {
+		it.done = true
+		return false
+	}
+
+	if it.limit > 0 && it.count >= it.limit {
+		// 达到上限，而范围内仍有剩余的键
+		it.token = successor(it.key)
+		it.done = true
+		return false
+	}
+
+	it.key, it.value = key, value
+	if it.keysOnly {
+		it.value = nil
+	}
+	it.count++
+
+	return true
+}
+
+// Key 返回当前的键。
+func (it *Iterator) Key() []byte {
+	return it.key
+}
+
+// Value 返回当前的值，ScanKeys 返回的迭代器总是返回 nil。
+func (it *Iterator) Value() []byte {
+	return it.value
+}
+
+// Err 返回迭代过程中发生的错误。
+func (it *Iterator) Err() error {
+	return it.err
+}
+
+// Token 返回续读令牌：迭代因 Limit 停止且还有剩余的键时，
+// 令牌是紧跟在本页最后一个键之后的键，把它作为下一次 Scan 的 start 即可继续。
+// 令牌是键而不是文件偏移量，因此两页之间发生合并也不影响续读。
+// 范围已经遍历完时返回 nil。
+func (it *Iterator) Token() []byte {
+	return it.token
+}
+
+// Close 关闭迭代器打开的所有文件，可以重复调用。
+func (it *Iterator) Close() error {
+	err := closeScanSources(it.sources)
+	it.sources = nil
+	it.done = true
+	if it.release != nil {
+		it.release()
+	}
+	return err
+}
+
+// nextLive 合并所有输入，返回下一个未被删除且小于 end 的键值对。
+func (it *Iterator) nextLive() ([]byte, []byte, bool) {
+	for {
+		var min scanSource
+		for _, source := range it.sources {
+			if source.valid() && (min == nil || bytes.Compare(source.key(), min.key()) < 0) {
+				min = source
+			}
+		}
+		if min == nil {
+			return nil, nil, false
+		}
+
+		key, value := min.key(), min.value()
+		if it.end != nil && bytes.Compare(key, it.end) >= 0 {
+			return nil, nil, false
+		}
+
+		// 跳过所有输入中相同的键，更旧的版本被最新的版本覆盖
+		for _, source := range it.sources {
+			if source.valid() && bytes.Equal(source.key(), key) {
+				if err := source.advance(); err != nil {
+					it.err = err
+					return nil, nil, false
+				}
+			}
+		}
+
+		if value != nil {
+			return key, value, true
+		}
+	}
+}
+
+// successor 返回按字节序紧跟在 key 之后的键。
+func successor(key []byte) []byte {
+	next := make([]byte, len(key)+1)
+	copy(next, key)
+	return next
+}
+
+// scanSource 是合并迭代器的一个有序输入。
+type scanSource interface {
+	// valid 判断当前是否有键值对。
+	valid() bool
+	key() []byte
+	value() []byte
+	// advance 前进到下一个键值对。
+	advance() error
+	close() error
+}
+
+// closeScanSources 关闭所有输入，返回遇到的第一个错误。
+func closeScanSources(sources []scanSource) error {
+	var firstErr error
+	for _, source := range sources {
+		if err := source.close(); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
+}
+
+// memTableSource 遍历 Scan 时从内存表复制出的条目。
+type memTableSource struct {
+	keys   [][]byte
+	values [][]byte
+	pos    int
+}
+
+// newMemTableSource 复制内存表中 [start, end) 范围内的条目（包括墓碑）。
+func newMemTableSource(table *memTable, start, end []byte) *memTableSource {
+	source := &memTableSource{}
+	for it := table.iterator(); it.hasNext(); {
+		key, value := it.next()
+		if start != nil && bytes.Compare(key, start) < 0 {
+			continue
+		}
+		if end != nil && bytes.Compare(key, end) >= 0 {
+			break
+		}
+		source.keys = append(source.keys, key)
+		source.values = append(source.values, value)
+	}
+	return source
+}
+
+func (s *memTableSource) valid() bool {
+	return s.pos < len(s.keys)
+}
+
+func (s *memTableSource) key() []byte {
+	return s.keys[s.pos]
+}
+
+func (s *memTableSource) value() []byte {
+	return s.values[s.pos]
+}
+
+func (s *memTableSource) advance() error {
+	s.pos++
+	return nil
+}
+
+func (s *memTableSource) close() error {
+	return nil
+}
+
+// diskTableSource 从第一个不小于 start 的键开始遍历磁盘表的数据文件。
+type diskTableSource struct {
+	it *dataFileIterator
+}
+
+// newDiskTableSource 打开磁盘表的数据文件并定位到 start，磁盘表中所有键都小于 start 时返回 nil。
+func newDiskTableSource(dbDir string, index int, start []byte, readAhead int) (*diskTableSource, error) {
+	prefix := strconv.Itoa(index) + "-"
+
+	offset, ok, err := seekInDiskTable(dbDir, prefix, start)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, nil
+	}
+
+	format, err := readTableFormat(dbDir, prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	it, err := newDataFileIteratorAt(path.Join(dbDir, prefix+diskTableDataFileName), int64(offset), format, readAhead, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	return &diskTableSource{it: it}, nil
+}
+
+func (s *diskTableSource) valid() bool {
+	return s.it.hasNext()
+}
+
+func (s *diskTableSource) key() []byte {
+	return s.it.key
+}
+
+func (s *diskTableSource) value() []byte {
+	return s.it.value
+}
+
+func (s *diskTableSource) advance() error {
+	_, _, err := s.it.next()
+	return err
+}
+
+func (s *diskTableSource) close() error {
+	return s.it.close()
+}
+
+// seekInDiskTable 借助稀疏索引和索引，返回数据文件中第一个不小于 start 的键的偏移量。
+// 磁盘表中所有键都小于 start 时返回 false。
+func seekInDiskTable(dbDir, prefix string, start []byte) (int, bool, error) {
+	if start == nil {
+		return 0, true, nil
+	}
+
+	from, err := sparseIndexFrom(dbDir, prefix, start)
+	if err != nil {
+		return 0, false, err
+	}
+
+	indexPath := path.Join(dbDir, prefix+diskTableIndexFileName)
+	indexFile, err := os.OpenFile(indexPath, os.O_RDONLY, 0600)
+	if err != nil {
+		return 0, false, fmt.Errorf("failed to open index file: %w", err)
+	}
+	defer indexFile.Close()
+
+	if _, err := indexFile.Seek(int64(from), io.SeekStart); err != nil {
+		return 0, false, fmt.Errorf("failed to seek: %w", err)
+	}
+
+	for {
+		key, value, err := decode(indexFile)
+		if err == io.EOF {
+			return 0, false, nil
+		}
+		if err != nil {
+			return 0, false, fmt.Errorf("failed to read index file %s: %w", indexPath, err)
+		}
+		if bytes.Compare(key, start) >= 0 {
+			return decodeInt(value), true, nil
+		}
+	}
+}
+
+// sparseIndexFrom 返回索引文件中最后一个不大于 start 的稀疏索引条目的位置，没有时返回 0（索引文件开头）。
+func sparseIndexFrom(dbDir, prefix string, start []byte) (int, error) {
+	sparseIndexPath := path.Join(dbDir, prefix+diskTableSparseIndexFileName)
+	sparseIndexFile, err := os.OpenFile(sparseIndexPath, os.O_RDONLY, 0600)
+	if err != nil {
+		return 0, fmt.Errorf("failed to open sparse index file: %w", err)
+	}
+	defer sparseIndexFile.Close()
+
+	from := 0
+	for {
+		key, value, err := decode(sparseIndexFile)
+		if err == io.EOF {
+			return from, nil
+		}
+		if err != nil {
+			return 0, fmt.Errorf("failed to read sparse index file %s: %w", sparseIndexPath, err)
+		}
+		if bytes.Compare(key, start) > 0 {
+			return from, nil
+		}
+		from = decodeInt(value)
+	}
+}
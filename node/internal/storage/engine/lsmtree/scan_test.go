@@ -0,0 +1,284 @@
+package lsmtree
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"testing"
+)
+
+func TestScanPagination(t *testing.T) {
+	dbDir, err := os.MkdirTemp(os.TempDir(), "example")
+	if err != nil {
+		panic(fmt.Errorf("failed to create %s: %w", dbDir, err))
+	}
+	defer func() {
+		if err := os.RemoveAll(dbDir); err != nil {
+			panic(fmt.Errorf("failed to remove %s: %w", dbDir, err))
+		}
+	}()
+
+	tree, err := Open(dbDir, MemTableThreshold(256), DiskTableNumThreshold(3))
+	if err != nil {
+		panic(fmt.Errorf("failed to open LSM tree %s: %w", dbDir, err))
+	}
+
+	var expected []string
+	for i := 0; i < 1000; i++ {
+		key := fmt.Sprintf("key%04d", i)
+		if err := tree.Put([]byte(key), []byte("v"+key)); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+	for i := 0; i < 1000; i++ {
+		key := fmt.Sprintf("key%04d", i)
+		if i%10 == 0 {
+			if err := tree.Delete([]byte(key)); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			continue
+		}
+		expected = append(expected, key)
+	}
+	if tree.diskTableNum < 2 {
+		t.Fatalf("expected data to span multiple disk tables, but got %d", tree.diskTableNum)
+	}
+
+	var got []string
+	start := []byte("key")
+	end := []byte("kez")
+	for page := 0; start != nil; page++ {
+		it, err := tree.Scan(start, end)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		it.Limit(64)
+		n := 0
+		for it.Next() {
+			if !bytes.Equal(it.Value(), append([]byte("v"), it.Key()...)) {
+				t.Fatalf("value is wrong for key %s: %s", it.Key(), it.Value())
+			}
+			got = append(got, string(it.Key()))
+			n++
+		}
+		if err := it.Err(); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		if n > 64 {
+			t.Fatalf("page %d returned %d keys, more than the limit", page, n)
+		}
+		start = it.Token()
+		if err := it.Close(); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+
+		// 两页之间写入范围外的键，触发刷新和合并
+		for i := 0; i < 50; i++ {
+			key := fmt.Sprintf("a%03d-%04d", page, i)
+			if err := tree.Put([]byte(key), []byte(key)); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+		}
+	}
+
+	if len(got) != len(expected) {
+		t.Fatalf("expected %d keys, but got %d", len(expected), len(got))
+	}
+	for i := range expected {
+		if got[i] != expected[i] {
+			t.Fatalf("expected key %s at %d, but got %s", expected[i], i, got[i])
+		}
+	}
+
+	if err := tree.Close(); err != nil {
+		panic(fmt.Errorf("failed to close: %w", err))
+	}
+}
+
+func TestScanBounds(t *testing.T) {
+	dbDir := t.TempDir()
+
+	// 键分布在多个磁盘表和内存表中，稀疏索引很密，边界会落在稀疏索引条目上
+	tree, err := Open(dbDir, SparseKeyDistance(4), MemTableMaxEntries(10), ImmutableMemtableMaxNum(1), DiskTableNumThreshold(100))
+	if err != nil {
+		panic(fmt.Errorf("failed to open LSM tree %s: %w", dbDir, err))
+	}
+	defer tree.Close()
+
+	// 只写入偶数键，使边界可以落在两个键之间
+	for i := 0; i < 50; i += 2 {
+		key := fmt.Sprintf("k%02d", i)
+		if err := tree.Put([]byte(key), []byte(key)); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+	if tree.diskTableNum < 2 || tree.memTable.size() == 0 {
+		t.Fatalf("expected keys in both disk tables and the memtable")
+	}
+
+	// keys 返回 [from, to) 范围内的偶数键
+	keys := func(from, to int) []string {
+		var keys []string
+		for i := from; i < to; i++ {
+			if i%2 == 0 {
+				keys = append(keys, fmt.Sprintf("k%02d", i))
+			}
+		}
+		return keys
+	}
+
+	tests := []struct {
+		name       string
+		start, end []byte
+		want       []string
+	}{
+		{"all", nil, nil, keys(0, 50)},
+		{"all with empty bounds", []byte{}, []byte{}, keys(0, 50)},
+		{"start to end", []byte("k20"), nil, keys(20, 50)},
+		{"begin to end key", nil, []byte("k20"), keys(0, 20)},
+		{"single key", []byte("k20"), []byte("k20\x00"), []string{"k20"}},
+		{"equal bounds", []byte("k20"), []byte("k20"), nil},
+		{"inverted bounds", []byte("k30"), []byte("k20"), nil},
+		{"bounds between keys", []byte("k21"), []byte("k25"), keys(21, 25)},
+		{"empty range between keys", []byte("k21"), []byte("k22"), nil},
+		{"before first key", []byte("a"), []byte("k04"), keys(0, 4)},
+		{"entirely before first key", []byte("a"), []byte("b"), nil},
+		{"after last key", []byte("k48"), []byte("z"), []string{"k48"}},
+		{"entirely after last key", []byte("k49"), nil, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			it, err := tree.Scan(tt.start, tt.end)
+			if err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			defer it.Close()
+
+			var got []string
+			for it.Next() {
+				got = append(got, string(it.Key()))
+			}
+			if err := it.Err(); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			if fmt.Sprint(got) != fmt.Sprint(tt.want) {
+				t.Fatalf("expected %v, but got %v", tt.want, got)
+			}
+			if it.Token() != nil {
+				t.Fatalf("expected no continuation token, but got %q", it.Token())
+			}
+		})
+	}
+}
+
+func TestScanPrefix(t *testing.T) {
+	dbDir := t.TempDir()
+	tree, err := Open(dbDir, MemTableMaxEntries(10), ImmutableMemtableMaxNum(1), DiskTableNumThreshold(100))
+	if err != nil {
+		panic(fmt.Errorf("failed to open LSM tree %s: %w", dbDir, err))
+	}
+	defer tree.Close()
+
+	keys := []string{"a", "ab", "abc", "abd", "ac", "b", "\xff", "\xff\xff", "\xff\xffa"}
+	for _, key := range keys {
+		if err := tree.Put([]byte(key), []byte(key)); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+
+	tests := []struct {
+		prefix string
+		want   []string
+	}{
+		{"ab", []string{"ab", "abc", "abd"}},
+		{"a", []string{"a", "ab", "abc", "abd", "ac"}},
+		{"abe", nil},
+		// 全部由 0xff 组成的前缀没有上界
+		{"\xff\xff", []string{"\xff\xff", "\xff\xffa"}},
+		{"", keys},
+	}
+	for _, test := range tests {
+		it, err := tree.ScanPrefix([]byte(test.prefix))
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		var got []string
+		for it.Next() {
+			got = append(got, string(it.Key()))
+		}
+		if err := it.Close(); err != nil {
+			t.Fatalf("failed to close iterator: %s", err)
+		}
+		if fmt.Sprintf("%q", got) != fmt.Sprintf("%q", test.want) {
+			t.Fatalf("prefix %q: expected %q, but got %q", test.prefix, test.want, got)
+		}
+	}
+}
+
+// writeScanTables 写入 count 个键并刷新为磁盘表，返回数据库目录
+func writeScanTables(tb testing.TB, count, valueSize int) string {
+	dbDir := tb.TempDir()
+	tree, err := Open(dbDir, MemTableThreshold(64<<20))
+	if err != nil {
+		tb.Fatalf("failed to open LSM tree %s: %s", dbDir, err)
+	}
+	value := bytes.Repeat([]byte("v"), valueSize)
+	for i := 0; i < count; i++ {
+		if err := tree.Put([]byte(fmt.Sprintf("key%06d", i)), value); err != nil {
+			tb.Fatalf("unexpected error: %s", err)
+		}
+	}
+	if err := tree.Flush(); err != nil {
+		tb.Fatalf("unexpected error: %s", err)
+	}
+	if err := tree.Close(); err != nil {
+		tb.Fatalf("unexpected error: %s", err)
+	}
+	return dbDir
+}
+
+func TestScanReadAhead(t *testing.T) {
+	dbDir := writeScanTables(t, 1000, 100)
+
+	// 预读缓冲区比单条记录还小时结果也相同
+	for _, readAhead := range []int{0, 16, defaultReadAhead} {
+		tree, err := Open(dbDir, ReadAhead(readAhead))
+		if err != nil {
+			t.Fatalf("failed to open LSM tree %s: %s", dbDir, err)
+		}
+		keys := collectKeys(t, func() (*Iterator, error) { return tree.Scan([]byte("key000500"), nil) })
+		if len(keys) != 500 || keys[0] != "key000500" || keys[499] != "key000999" {
+			t.Fatalf("read ahead %d: expected key000500..key000999, but got %d keys", readAhead, len(keys))
+		}
+		if err := tree.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := Open(dbDir, ReadAhead(-1)); err == nil {
+		t.Fatal("expected an error for a negative read ahead")
+	}
+}
+
+func BenchmarkScanReadAhead(b *testing.B) {
+	count := 50000
+	dbDir := writeScanTables(b, count, 100)
+
+	for _, readAhead := range []int{0, defaultReadAhead} {
+		b.Run(fmt.Sprintf("readahead=%d", readAhead), func(b *testing.B) {
+			tree, err := Open(dbDir, ReadAhead(readAhead))
+			if err != nil {
+				b.Fatalf("failed to open LSM tree %s: %s", dbDir, err)
+			}
+			defer tree.Close()
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				if keys := collectKeys(b, func() (*Iterator, error) { return tree.Scan(nil, nil) }); len(keys) != count {
+					b.Fatalf("expected %d keys, but got %d", count, len(keys))
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,35 @@
+package lsmtree
+
+// FlushMemtablesSeparately 为 LSMTree 设置刷新不可变内存表的方式（默认合并）。
+// 默认把所有不可变内存表合并到一个新的跳表后刷新为一个磁盘表，合并期间内存中同时存在两份数据；
+// 为 true 时从旧到新把每个不可变内存表刷新为各自的磁盘表，不需要额外的内存，但产生更多的磁盘表，
+// 合并磁盘表的次数随之增加。Flush 和 MemoryLimit 触发的刷新同样按该方式进行。
+func FlushMemtablesSeparately(separately bool) func(*LSMTree) {
+	return func(t *LSMTree) {
+		t.flushSeparately = separately
+	}
+}
+
+// flushImmutableMemtablesSeparately 从旧到新把每个不可变内存表刷新为一个磁盘表，调用方必须持有写锁。
+// WAL 在最后一个刷新之后才清除：中途崩溃时重放 WAL 会再次写入已经刷新的记录，它们与磁盘表中的相同。
+// 中途失败时已经刷新的不可变内存表被移除，其余的保留到下一次刷新。
+func (t *LSMTree) flushImmutableMemtablesSeparately() error {
+	for len(t.immutableMemtables) > 1 {
+		index, info, err := t.writeMemTable(t.ctx, t.immutableMemtables[0])
+		if err != nil {
+			return err
+		}
+		t.immutableMemtables = t.immutableMemtables[1:]
+		t.notify(func(l EventListener) {
+			l.OnFlush(index, info)
+		})
+	}
+	if len(t.immutableMemtables) == 0 {
+		return nil
+	}
+	if err := t.flushMemTable(t.ctx, t.immutableMemtables[0]); err != nil {
+		return err
+	}
+	t.immutableMemtables = []*memTable{}
+	return nil
+}
@@ -0,0 +1,74 @@
+package lsmtree
+
+import (
+	"fmt"
+	"runtime"
+	"testing"
+)
+
+// flushImmutableMemtables 写入 4 个不可变内存表后刷新，返回刷新期间分配的字节数
+func flushImmutableMemtables(t *testing.T, tree *LSMTree) uint64 {
+	t.Helper()
+	for i := 0; i < 4000; i++ {
+		key, value := fmt.Sprintf("key%05d", i%3000), fmt.Sprintf("value%0100d", i)
+		if err := tree.Put([]byte(key), []byte(value)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := len(tree.immutableMemtables); n != 4 {
+		t.Fatalf("expected 4 immutable memtables, but got %d", n)
+	}
+
+	var before, after runtime.MemStats
+	runtime.GC()
+	runtime.ReadMemStats(&before)
+	if err := tree.Flush(); err != nil {
+		t.Fatal(err)
+	}
+	runtime.ReadMemStats(&after)
+	return after.TotalAlloc - before.TotalAlloc
+}
+
+func TestFlushMemtablesSeparately(t *testing.T) {
+	allocated := make(map[bool]uint64)
+	for _, separately := range []bool{false, true} {
+		tree, err := Open(t.TempDir(),
+			MemTableThreshold(1<<30),
+			MemTableMaxEntries(1000),
+			ImmutableMemtableMaxNum(10),
+			DiskTableNumThreshold(100),
+			FlushMemtablesSeparately(separately))
+		if err != nil {
+			t.Fatal(err)
+		}
+		allocated[separately] = flushImmutableMemtables(t, tree)
+
+		expected := 1
+		if separately {
+			expected = 4
+		}
+		if tables := tree.tableInfos(); len(tables) != expected {
+			t.Fatalf("separately=%t: expected %d disk tables, but got %d", separately, expected, len(tables))
+		}
+		if len(tree.immutableMemtables) != 0 {
+			t.Fatalf("separately=%t: expected the immutable memtables to be flushed", separately)
+		}
+		// 后写入的值覆盖先写入的
+		for _, i := range []int{0, 999, 1000, 2999} {
+			key, want := fmt.Sprintf("key%05d", i), fmt.Sprintf("value%0100d", i)
+			if i < 1000 {
+				want = fmt.Sprintf("value%0100d", i+3000)
+			}
+			if value, exists, err := tree.Get([]byte(key)); err != nil || !exists || string(value) != want {
+				t.Fatalf("separately=%t: expected %s=%s, but got %q (%t, %v)", separately, key, want, value, exists, err)
+			}
+		}
+		tree.Close()
+	}
+
+	// 合并刷新需要构建一个包含所有记录的跳表，分别刷新不需要
+	t.Logf("allocated while flushing: merged %d bytes, separately %d bytes", allocated[false], allocated[true])
+	if allocated[true] >= allocated[false] {
+		t.Fatalf("expected flushing separately to allocate less than merging, but got %d and %d", allocated[true], allocated[false])
+	}
+}
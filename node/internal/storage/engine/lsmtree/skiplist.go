@@ -3,6 +3,7 @@ package lsmtree
 import (
 	"bytes"
 	"math/rand"
+	"unsafe"
 )
 
 // 跳表节点
@@ -17,26 +18,51 @@ type SkipList struct {
 	head     *skipListNode
 	level    int
 	maxLevel int
-	num      int // 跳表的节点数量
-	size     int // 跳表中所有值的总字节数
+	num      int        // 跳表的节点数量
+	size     int        // 跳表中所有值的总字节数
+	mem      int        // 跳表节点占用的内存估计，包括节点结构和指针数组
+	rand     *rand.Rand // 生成层级的随机数源，为 nil 时使用全局的随机数源
 }
 
-// 创建新的跳表
+// 节点结构体和 next 中每个指针的大小
+const (
+	skipListNodeSize    = int(unsafe.Sizeof(skipListNode{}))
+	skipListPointerSize = int(unsafe.Sizeof(&skipListNode{}))
+)
+
+// nodeMemory 估计一个有 level 层的节点占用的内存
+func nodeMemory(key, value []byte, level int) int {
+	return skipListNodeSize + level*skipListPointerSize + len(key) + len(value)
+}
+
+// 创建新的跳表，层级使用全局的随机数源随机生成，详见 NewSkipListWithRand
 func NewSkipList(maxLevel int) *SkipList {
 	head := &skipListNode{next: make([]*skipListNode, maxLevel)}
 	return &SkipList{head: head, level: 0, maxLevel: maxLevel, num: 0, size: 0}
 }
 
+// NewSkipListWithRand 创建使用 rnd 生成层级的跳表，相同种子的 rnd 和相同的插入顺序得到相同的结构，
+// 用于在测试中复现与结构有关的问题。rnd 不是并发安全的，跳表本身也不是，由调用方同步。
+func NewSkipListWithRand(maxLevel int, rnd *rand.Rand) *SkipList {
+	s := NewSkipList(maxLevel)
+	s.rand = rnd
+	return s
+}
+
 // 随机生成层级
-func randomLevel(maxLevel int) int {
+func (s *SkipList) randomLevel() int {
+	next := rand.Float32
+	if s.rand != nil {
+		next = s.rand.Float32
+	}
 	level := 1
-	for rand.Float32() < 0.5 && level < maxLevel {
+	for next() < 0.5 && level < s.maxLevel {
 		level++
 	}
 	return level
 }
 
-// 插入节点
+// 插入节点，键已存在时覆盖其值
 func (s *SkipList) Insert(key []byte, value []byte) {
 	update := make([]*skipListNode, s.maxLevel)
 	current := s.head
@@ -49,8 +75,16 @@ func (s *SkipList) Insert(key []byte, value []byte) {
 		update[i] = current
 	}
 
+	// 键已存在，直接覆盖值
+	if existing := current.next[0]; existing != nil && bytes.Equal(existing.key, key) {
+		s.size += len(value) - len(existing.value)
+		s.mem += len(value) - len(existing.value)
+		existing.value = value
+		return
+	}
+
 	// 生成随机层级
-	newLevel := randomLevel(s.maxLevel)
+	newLevel := s.randomLevel()
 	if newLevel > s.level {
 		for i := s.level; i < newLevel; i++ {
 			update[i] = s.head
@@ -68,6 +102,7 @@ func (s *SkipList) Insert(key []byte, value []byte) {
 	// 更新跳表的节点数量和大小
 	s.num++
 	s.size += len(key) + len(value) // 更新大小为 key 和 value 的字节数
+	s.mem += nodeMemory(key, value, newLevel)
 }
 
 // 查找节点
@@ -116,6 +151,7 @@ func (s *SkipList) Delete(key []byte) bool {
 		// 更新跳表的节点数量和大小
 		s.num--
 		s.size -= len(current.key) + len(current.value) // 更新大小为被删除节点的字节数
+		s.mem -= nodeMemory(current.key, current.value, len(current.next))
 		return true
 	}
 	return false
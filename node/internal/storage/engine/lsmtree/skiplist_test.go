@@ -1,6 +1,9 @@
 package lsmtree
 
 import (
+	"math/rand"
+	"reflect"
+	"strconv"
 	"testing"
 )
 
@@ -39,4 +42,48 @@ func TestSkipList(t *testing.T) {
 		t.Errorf("Expected num to be 2, got %d", skipList.num)
 	}
 
+	// 测试覆盖已有的键
+	skipList.Insert([]byte("1"), []byte("uno"))
+	if value, found := skipList.Search([]byte("1")); !found || string(value) != "uno" {
+		t.Errorf("Expected to find key '1' with value 'uno', got %v", value)
+	}
+	if skipList.num != 2 {
+		t.Errorf("Expected num to stay 2 after overwrite, got %d", skipList.num)
+	}
+	if skipList.size != len("1uno")+len("3three") {
+		t.Errorf("Expected size to be %d, got %d", len("1uno")+len("3three"), skipList.size)
+	}
+
+}
+
+// skipListLevels 按键的顺序返回每个节点的层数
+func skipListLevels(s *SkipList) []int {
+	var levels []int
+	for node := s.head.next[0]; node != nil; node = node.next[0] {
+		levels = append(levels, len(node.next))
+	}
+	return levels
+}
+
+func TestSkipListWithSeed(t *testing.T) {
+	build := func() *SkipList {
+		s := NewSkipListWithRand(4, rand.New(rand.NewSource(1)))
+		for i := 0; i < 8; i++ {
+			s.Insert([]byte(strconv.Itoa(i)), nil)
+		}
+		return s
+	}
+
+	// 种子 1 得到的结构是固定的
+	want := []int{1, 1, 1, 3, 4, 1, 1, 4}
+	s := build()
+	if got := skipListLevels(s); !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected levels %v, but got %v", want, got)
+	}
+	if s.level != 4 {
+		t.Fatalf("expected level 4, but got %d", s.level)
+	}
+	if got := skipListLevels(build()); !reflect.DeepEqual(got, want) {
+		t.Fatalf("expected the same levels with the same seed, but got %v", got)
+	}
 }
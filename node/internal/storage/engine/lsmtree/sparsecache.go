@@ -0,0 +1,141 @@
+package lsmtree
+
+import (
+	"bytes"
+	"container/list"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// SparseIndexCacheSize 为 LSMTree 设置最多缓存稀疏索引的磁盘表数量（默认 256），0 表示不缓存。
+// 稀疏索引每 sparseKeyDistance 个键才有一个条目，通常很小；缓存之后 Get 在内存中二分查找，
+// 不必每次都读取并解析稀疏索引文件。超过上限时淘汰最久没有使用的磁盘表。
+func SparseIndexCacheSize(tables int) func(*LSMTree) {
+	return func(t *LSMTree) {
+		t.sparseIndexCacheSize = tables
+	}
+}
+
+// sparseIndex 是加载到内存中的稀疏索引，键按升序排列。
+type sparseIndex struct {
+	keys    [][]byte
+	offsets []int
+}
+
+// loadSparseIndex 读取编号为 index 的磁盘表的整个稀疏索引文件。
+func loadSparseIndex(dbDir string, index int, verify bool) (*sparseIndex, error) {
+	sparseIndexPath := path.Join(dbDir, strconv.Itoa(index)+"-"+diskTableSparseIndexFileName)
+	data, err := os.ReadFile(sparseIndexPath)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read sparse index file: %w", err)
+	}
+
+	s := &sparseIndex{}
+	r := bytes.NewReader(data)
+	for {
+		key, value, err := decodeRecord(r, verify)
+		if err == io.EOF {
+			return s, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed to read sparse index file %s: %w", sparseIndexPath, err)
+		}
+		s.keys = append(s.keys, key)
+		s.offsets = append(s.offsets, decodeInt(value))
+	}
+}
+
+// window 返回键在索引文件中所在的范围，语义与 searchInSparseIndex 相同。
+func (s *sparseIndex) window(key []byte) (int, int, bool) {
+	i := sort.Search(len(s.keys), func(i int) bool {
+		return bytes.Compare(s.keys[i], key) > 0
+	})
+	if i == 0 {
+		// 稀疏索引中的第一个键大于查找的键（或者稀疏索引为空），意味着不存在该键
+		return 0, 0, false
+	}
+	if i == len(s.keys) {
+		return s.offsets[i-1], 0, true
+	}
+	return s.offsets[i-1], s.offsets[i], true
+}
+
+// sparseIndexCache 按磁盘表编号缓存稀疏索引，淘汰最久没有使用的磁盘表，可以被多个读取同时调用。
+// 合并会移动磁盘表的编号，因此合并和删除磁盘表之后必须调用 clear。
+type sparseIndexCache struct {
+	mu       sync.Mutex
+	capacity int
+	ll       *list.List
+	items    map[int]*list.Element
+}
+
+type sparseIndexEntry struct {
+	index  int
+	sparse *sparseIndex
+}
+
+// newSparseIndexCache 返回最多缓存 capacity 个磁盘表的缓存，capacity 不大于 0 时返回 nil（不缓存）。
+func newSparseIndexCache(capacity int) *sparseIndexCache {
+	if capacity <= 0 {
+		return nil
+	}
+	return &sparseIndexCache{
+		capacity: capacity,
+		ll:       list.New(),
+		items:    make(map[int]*list.Element),
+	}
+}
+
+// get 返回编号为 index 的磁盘表的稀疏索引，没有缓存时从文件加载。
+func (c *sparseIndexCache) get(dbDir string, index int, verify bool) (*sparseIndex, error) {
+	c.mu.Lock()
+	if e, ok := c.items[index]; ok {
+		c.ll.MoveToFront(e)
+		c.mu.Unlock()
+		return e.Value.(*sparseIndexEntry).sparse, nil
+	}
+	c.mu.Unlock()
+
+	// 加载时不持有锁，多个读取可能同时加载同一个磁盘表，结果相同
+	sparse, err := loadSparseIndex(dbDir, index, verify)
+	if err != nil {
+		return nil, err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if e, ok := c.items[index]; ok {
+		c.ll.MoveToFront(e)
+		return e.Value.(*sparseIndexEntry).sparse, nil
+	}
+	c.items[index] = c.ll.PushFront(&sparseIndexEntry{index: index, sparse: sparse})
+	if c.ll.Len() > c.capacity {
+		oldest := c.ll.Back()
+		c.ll.Remove(oldest)
+		delete(c.items, oldest.Value.(*sparseIndexEntry).index)
+	}
+	return sparse, nil
+}
+
+// clear 清空缓存，nil 的缓存什么也不做。
+func (c *sparseIndexCache) clear() {
+	if c == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.ll.Init()
+	c.items = make(map[int]*list.Element)
+}
+
+// len 返回缓存的磁盘表数量。
+func (c *sparseIndexCache) len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.ll.Len()
+}
@@ -0,0 +1,194 @@
+package lsmtree
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path"
+	"testing"
+)
+
+func TestSparseIndexWindow(t *testing.T) {
+	dbDir := writeScanTables(t, 1000, 10)
+	sparseIndexPath := path.Join(dbDir, "0-"+diskTableSparseIndexFileName)
+	data, err := os.ReadFile(sparseIndexPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sparse, err := loadSparseIndex(dbDir, 0, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sparse.keys) < 2 {
+		t.Fatalf("expected several sparse index entries, but got %d", len(sparse.keys))
+	}
+
+	// 内存中的查找与从文件中查找的结果相同
+	for _, key := range []string{"", "a", "key000000", "key000001", "key000128", "key000500", "key000999", "z"} {
+		from, to, ok, err := searchInSparseIndex(bytes.NewReader(data), []byte(key), true)
+		if err != nil {
+			t.Fatal(err)
+		}
+		gotFrom, gotTo, gotOK := sparse.window([]byte(key))
+		if gotFrom != from || gotTo != to || gotOK != ok {
+			t.Fatalf("%q: expected [%d, %d) %v, but got [%d, %d) %v", key, from, to, ok, gotFrom, gotTo, gotOK)
+		}
+	}
+}
+
+func TestSparseIndexCacheEviction(t *testing.T) {
+	tree, err := Open(t.TempDir(), DiskTableNumThreshold(100), SparseIndexCacheSize(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tree.Close()
+
+	for i := 0; i < 3; i++ {
+		key := []byte(fmt.Sprintf("key%03d", i))
+		if err := tree.Put(key, key); err != nil {
+			t.Fatal(err)
+		}
+		if err := tree.Flush(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 最旧的磁盘表中的键要查找全部 3 张表，缓存只保留最近使用的 2 张
+	if value, ok, err := tree.Get([]byte("key000")); err != nil || !ok || string(value) != "key000" {
+		t.Fatalf("expected key000, but got %s %v (%v)", value, ok, err)
+	}
+	if n := tree.sparseIndexes.len(); n != 2 {
+		t.Fatalf("expected 2 cached sparse indexes, but got %d", n)
+	}
+	if _, ok := tree.sparseIndexes.items[0]; !ok {
+		t.Fatal("expected the most recently used disk table to stay cached")
+	}
+
+	if _, err := Open(t.TempDir(), SparseIndexCacheSize(-1)); err == nil {
+		t.Fatal("expected an error for a negative sparse index cache size")
+	}
+}
+
+func TestWarmUpSparseIndexCache(t *testing.T) {
+	dbDir := t.TempDir()
+	tree, err := Open(dbDir, DiskTableNumThreshold(100))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3; i++ {
+		key := []byte(fmt.Sprintf("key%03d", i))
+		if err := tree.Put(key, key); err != nil {
+			t.Fatal(err)
+		}
+		if err := tree.Flush(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tree.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// 缓存只能容纳 2 张磁盘表，预热加载最新的 2 张
+	tree, err = Open(dbDir, DiskTableNumThreshold(100), SparseIndexCacheSize(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tree.Close()
+	if n := tree.sparseIndexes.len(); n != 0 {
+		t.Fatalf("expected no cached sparse indexes before warming up, but got %d", n)
+	}
+	if err := tree.WarmUp(); err != nil {
+		t.Fatal(err)
+	}
+	if n := tree.sparseIndexes.len(); n != 2 {
+		t.Fatalf("expected 2 cached sparse indexes, but got %d", n)
+	}
+	for index := tree.maxDiskTableIndex; index > tree.maxDiskTableIndex-2; index-- {
+		if _, ok := tree.sparseIndexes.items[index]; !ok {
+			t.Fatalf("expected the sparse index of disk table %d to be cached", index)
+		}
+	}
+}
+
+func TestSparseIndexCacheCompaction(t *testing.T) {
+	tree, err := Open(t.TempDir(), DiskTableNumThreshold(100), SparseKeyDistance(4))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tree.Close()
+
+	// 旧的磁盘表有 50 个键，新的磁盘表覆盖其中的 10 个
+	for _, table := range []struct {
+		count int
+		value string
+	}{{50, "old"}, {10, "new"}} {
+		for i := 0; i < table.count; i++ {
+			if err := tree.Put([]byte(fmt.Sprintf("key%03d", i)), []byte(table.value)); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := tree.Flush(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	check := func() {
+		t.Helper()
+		for i := 0; i < 50; i++ {
+			expected := "old"
+			if i < 10 {
+				expected = "new"
+			}
+			key := []byte(fmt.Sprintf("key%03d", i))
+			if value, ok, err := tree.Get(key); err != nil || !ok || string(value) != expected {
+				t.Fatalf("%s: expected %s, but got %s %v (%v)", key, expected, value, ok, err)
+			}
+		}
+	}
+	check()
+	if n := tree.sparseIndexes.len(); n != 2 {
+		t.Fatalf("expected 2 cached sparse indexes, but got %d", n)
+	}
+
+	// 合并后编号 1 是新的磁盘表，缓存的稀疏索引必须丢弃
+	tree.mu.Lock()
+	err = tree.compactPair(tree.ctx, 0, 1)
+	tree.mu.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := tree.sparseIndexes.len(); n != 0 {
+		t.Fatalf("expected the cache to be cleared by compaction, but got %d entries", n)
+	}
+	check()
+
+	if err := tree.DropAll(); err != nil {
+		t.Fatal(err)
+	}
+	if n := tree.sparseIndexes.len(); n != 0 {
+		t.Fatalf("expected the cache to be cleared by DropAll, but got %d entries", n)
+	}
+}
+
+func BenchmarkGetSparseIndexCache(b *testing.B) {
+	count := 50000
+	dbDir := writeScanTables(b, count, 100)
+
+	for _, size := range []int{0, defaultSparseIndexCacheSize} {
+		b.Run(fmt.Sprintf("cache=%d", size), func(b *testing.B) {
+			tree, err := Open(dbDir, SparseIndexCacheSize(size))
+			if err != nil {
+				b.Fatalf("failed to open LSM tree %s: %s", dbDir, err)
+			}
+			defer tree.Close()
+
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				key := []byte(fmt.Sprintf("key%06d", i*7919%count))
+				if _, ok, err := tree.Get(key); err != nil || !ok {
+					b.Fatalf("expected %s to exist (%v)", key, err)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,79 @@
+package lsmtree
+
+import "sync/atomic"
+
+// Stats 是自 Open 以来写入字节数和读取的统计，用于评估写放大、读放大和调整合并策略。
+// 计数不会持久化，重新打开数据库后从零开始。
+type Stats struct {
+	// 数据库的纪元，详见 LSMTree.Epoch。
+	Epoch string
+	// 用户通过 Put、Append 和 Delete 写入的键和值的字节数。
+	UserBytesWritten int64
+	// 写入 WAL 的字节数。
+	WALBytesWritten int64
+	// WAL 的同步次数，开启 GroupCommit 时一次同步覆盖同时提交的多个写入。
+	WALSyncs int64
+	// 刷新内存表时写入磁盘表的字节数，包括数据、索引和稀疏索引文件。
+	FlushBytesWritten int64
+	// 合并磁盘表时写入的字节数，包括数据、索引和稀疏索引文件。
+	CompactionBytesWritten int64
+	// 开启 CompactWhenIdle 时因为繁忙而推迟合并的次数，每次推迟直到合并为止算一次。
+	CompactionsDeferred int64
+	// 当前内存表和不可变内存表占用的内存估计，包括跳表节点的开销。
+	MemoryUsage int64
+	// 固定的键和值的总字节数，详见 PutPinned。
+	PinnedBytes int64
+	// 当前有效的内存表阈值（字节），开启 AdaptiveMemTableThreshold 时随写入速率变化。
+	MemTableThreshold int64
+	// 自上次合并以来 Get 的次数，合并改变了磁盘表，之前的读取不再反映当前的读放大。
+	Gets int64
+	// 自上次合并以来所有 Get 查找的磁盘表总数，在内存表中命中的读取不查找磁盘表。
+	GetTablesRead int64
+	// 自 Open 以来 Get 的结果分别来自固定的键、内存表、不可变内存表、负缓存和磁盘表的次数，
+	// 以及查找了所有磁盘表都没有找到键的次数，详见 GetWithSource。
+	GetsFromPinned             int64
+	GetsFromMemTable           int64
+	GetsFromImmutableMemTables int64
+	GetsFromNegativeCache      int64
+	GetsFromDiskTables         int64
+	GetsNotFound               int64
+}
+
+// WriteAmplification 返回写放大：实际写入磁盘的字节数与用户写入字节数之比，
+// 还没有用户写入时返回 0。
+func (s Stats) WriteAmplification() float64 {
+	if s.UserBytesWritten == 0 {
+		return 0
+	}
+	written := s.WALBytesWritten + s.FlushBytesWritten + s.CompactionBytesWritten
+	return float64(written) / float64(s.UserBytesWritten)
+}
+
+// ReadAmplification 返回读放大：自上次合并以来平均每次 Get 查找的磁盘表数量，还没有读取时返回 0。
+func (s Stats) ReadAmplification() float64 {
+	if s.Gets == 0 {
+		return 0
+	}
+	return float64(s.GetTablesRead) / float64(s.Gets)
+}
+
+// Stats 返回当前的统计数据。
+func (t *LSMTree) Stats() Stats {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	stats := t.stats
+	stats.Epoch = t.epoch
+	stats.MemoryUsage = int64(t.memoryUsage())
+	stats.MemTableThreshold = int64(t.memTableThreshold)
+	stats.PinnedBytes = int64(t.pinned.bytes())
+	reads := t.readStats()
+	stats.Gets, stats.GetTablesRead = reads.Gets, reads.GetTablesRead
+	stats.GetsFromPinned = atomic.LoadInt64(&t.readSources[ReadFromPinned])
+	stats.GetsFromMemTable = atomic.LoadInt64(&t.readSources[ReadFromMemTable])
+	stats.GetsFromImmutableMemTables = atomic.LoadInt64(&t.readSources[ReadFromImmutableMemTable])
+	stats.GetsFromNegativeCache = atomic.LoadInt64(&t.readSources[ReadFromNegativeCache])
+	stats.GetsFromDiskTables = atomic.LoadInt64(&t.readSources[ReadFromDiskTable])
+	stats.GetsNotFound = atomic.LoadInt64(&t.readSources[ReadNotFound])
+	return stats
+}
@@ -0,0 +1,140 @@
+package lsmtree
+
+import (
+	"bytes"
+	"fmt"
+	"path"
+	"sync"
+	"testing"
+)
+
+func TestStatsWriteAmplification(t *testing.T) {
+	dbDir := t.TempDir()
+
+	tree, err := Open(dbDir, MemTableMaxEntries(10), ImmutableMemtableMaxNum(1), DiskTableNumThreshold(100))
+	if err != nil {
+		panic(fmt.Errorf("failed to open LSM tree %s: %w", dbDir, err))
+	}
+	defer tree.Close()
+
+	if stats := tree.Stats(); stats != (Stats{Epoch: tree.Epoch(), MemTableThreshold: defaultMemTableThreshold}) || stats.WriteAmplification() != 0 {
+		t.Fatalf("expected empty stats, but got %+v", stats)
+	}
+
+	// 每条记录在 WAL 中占 [总长度 8][校验和 4][键长度 8][键][值]
+	var userBytes, walBytes int64
+	for i := 0; i < 25; i++ {
+		key, value := fmt.Sprintf("key%02d", i), fmt.Sprintf("value%02d", i)
+		if err := tree.Put([]byte(key), []byte(value)); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		userBytes += int64(len(key) + len(value))
+		walBytes += int64(8 + checksumLen + 8 + len(key) + len(value))
+	}
+	if err := tree.Delete([]byte("key00")); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	userBytes += int64(len("key00"))
+	walBytes += int64(8 + checksumLen + 8 + len("key00"))
+
+	stats := tree.Stats()
+	if stats.UserBytesWritten != userBytes || stats.WALBytesWritten != walBytes {
+		t.Fatalf("expected %d user bytes and %d WAL bytes, but got %+v", userBytes, walBytes, stats)
+	}
+
+	// 刷新写入的字节数等于磁盘表文件的总大小
+	var tableBytes int64
+	for index := 0; index <= tree.maxDiskTableIndex; index++ {
+		for _, name := range []string{diskTableDataFileName, diskTableIndexFileName, diskTableSparseIndexFileName} {
+			size, err := GetFileSize(path.Join(dbDir, fmt.Sprintf("%d-%s", index, name)))
+			if err != nil {
+				panic(fmt.Errorf("failed to stat disk table %d: %w", index, err))
+			}
+			tableBytes += size
+		}
+	}
+	if tree.diskTableNum < 2 || stats.FlushBytesWritten != tableBytes {
+		t.Fatalf("expected %d flush bytes over at least 2 disk tables, but got %+v", tableBytes, stats)
+	}
+	if stats.CompactionBytesWritten != 0 {
+		t.Fatalf("expected no compaction bytes, but got %d", stats.CompactionBytesWritten)
+	}
+
+	// 降低阈值后下一次写入触发合并
+	DiskTableNumThreshold(2)(tree)
+	for i := 0; i < 10; i++ {
+		if err := tree.Put([]byte(fmt.Sprintf("more%02d", i)), []byte("value")); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+	stats = tree.Stats()
+	if stats.CompactionBytesWritten == 0 {
+		t.Fatalf("expected compaction bytes after compaction, but got %+v", stats)
+	}
+	if stats.WriteAmplification() <= 1 {
+		t.Fatalf("expected write amplification above 1, but got %f", stats.WriteAmplification())
+	}
+}
+
+func TestMemoryLimit(t *testing.T) {
+	dbDir := t.TempDir()
+	limit := 64 * 1024
+	// 内存表阈值和不可变内存表数量都不会触发刷新，只有内存上限会
+	tree, err := Open(dbDir, MemoryLimit(limit), MemTableThreshold(1<<30), ImmutableMemtableMaxNum(1000), DiskTableNumThreshold(1000))
+	if err != nil {
+		t.Fatalf("failed to open LSM tree %s: %s", dbDir, err)
+	}
+	defer tree.Close()
+
+	value := bytes.Repeat([]byte("v"), 1024)
+	// 一次写入最多让内存超过上限一个节点
+	maxAllowed := int64(limit + nodeMemory([]byte("writer-0-key-0000"), value, 16))
+
+	writers, count := 8, 200
+	var wg sync.WaitGroup
+	done := make(chan struct{})
+	peak := make(chan int64)
+	go func() {
+		var max int64
+		for {
+			select {
+			case <-done:
+				peak <- max
+				return
+			default:
+			}
+			if usage := tree.Stats().MemoryUsage; usage > max {
+				max = usage
+			}
+		}
+	}()
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < count; i++ {
+				if err := tree.Put([]byte(fmt.Sprintf("writer-%d-key-%04d", w, i)), value); err != nil {
+					t.Errorf("unexpected error: %s", err)
+					return
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+	close(done)
+
+	if max := <-peak; max > maxAllowed {
+		t.Fatalf("memory usage %d exceeded the limit %d", max, maxAllowed)
+	}
+	if tree.diskTableNum == 0 {
+		t.Fatal("expected the memory limit to force flushes")
+	}
+	for w := 0; w < writers; w++ {
+		for i := 0; i < count; i++ {
+			key := fmt.Sprintf("writer-%d-key-%04d", w, i)
+			if got, ok, err := tree.Get([]byte(key)); err != nil || !ok || !bytes.Equal(got, value) {
+				t.Fatalf("failed to read %s: ok=%v err=%v", key, ok, err)
+			}
+		}
+	}
+}
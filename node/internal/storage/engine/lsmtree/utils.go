@@ -1,6 +1,7 @@
 package lsmtree
 
 import (
+	"io"
 	"os"
 )
 
@@ -19,3 +20,24 @@ func GetDatabaseSourcePath() string {
 	}
 	return home + databaseSourcePath
 }
+
+// copyFile 将 src 文件的内容复制到 dst，并同步到磁盘。
+func copyFile(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+
+	out, err := os.OpenFile(dst, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	if _, err := io.Copy(out, in); err != nil {
+		return err
+	}
+
+	return out.Sync()
+}
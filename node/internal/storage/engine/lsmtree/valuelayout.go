@@ -0,0 +1,230 @@
+package lsmtree
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path"
+	"sync/atomic"
+)
+
+// diskTableValuesFileName 是分开存放值的磁盘表的值文件名，详见 SeparateValues。
+const diskTableValuesFileName = "values"
+
+// valuePointerLen 是值指针编码后的长度：[值文件中的偏移量（8 字节）][长度（8 字节）][值的校验和（4 字节）]。
+const valuePointerLen = 8 + 8 + checksumLen
+
+// SeparateValues 设置新写入的磁盘表是否把键和值分开存放（默认 false）。
+// 开启后数据文件中只有键、标志和指向值文件的 20 字节指针，值按键的顺序依次写入单独的值文件（<编号>-values），
+// 按键遍历数据文件时读取的是紧凑的键文件，不会把值一起从磁盘读出来。
+// 值文件随磁盘表一起合并和删除，合并时值按新的顺序重写，不需要单独回收空间。
+//
+// 代价是点查询：经由索引找到数据文件中的记录后，还要再打开值文件并定位一次（一次额外的寻道）才能读到值，
+// 值很小时这次额外的读取比省下的字节更贵。Scan 和合并顺序读取两个文件，值文件同样是顺序读取的。
+// 与 Compression 和 RecordEncoding 一样只影响之后刷新和合并写入的磁盘表，旧的磁盘表在合并时按当前的设置重写。
+func SeparateValues(separate bool) func(*LSMTree) {
+	return func(t *LSMTree) {
+		t.separateValues = separate
+	}
+}
+
+// valuePointer 是分开存放时数据文件中代替值的指针。
+type valuePointer struct {
+	offset   int64
+	length   int
+	checksum uint32
+}
+
+// encodeValuePointer 编码指向值文件中 offset 处的 value 的指针。
+func encodeValuePointer(offset int64, value []byte) []byte {
+	buf := make([]byte, valuePointerLen)
+	binary.BigEndian.PutUint64(buf, uint64(offset))
+	binary.BigEndian.PutUint64(buf[8:], uint64(len(value)))
+	binary.BigEndian.PutUint32(buf[16:], crc32.ChecksumIEEE(value))
+	return buf
+}
+
+// decodeValuePointer 解码数据文件中的值指针。
+func decodeValuePointer(data []byte) (valuePointer, error) {
+	if len(data) != valuePointerLen {
+		return valuePointer{}, fmt.Errorf("the file is corrupted, invalid value pointer of %d bytes", len(data))
+	}
+	return valuePointer{
+		offset:   int64(binary.BigEndian.Uint64(data)),
+		length:   int(binary.BigEndian.Uint64(data[8:])),
+		checksum: binary.BigEndian.Uint32(data[16:]),
+	}, nil
+}
+
+// check 在 verify 为 true 时比较读出的值与指针中的校验和。
+func (p valuePointer) check(value []byte, verify bool) error {
+	if verify && crc32.ChecksumIEEE(value) != p.checksum {
+		return ErrChecksumMismatch
+	}
+	return nil
+}
+
+// readSeparateValue 按指针从磁盘表的值文件中读取（压缩后的）值，墓碑（nil）保持为 nil。
+func readSeparateValue(dbDir, prefix string, pointer []byte, verify bool) ([]byte, error) {
+	if pointer == nil {
+		return nil, nil
+	}
+	p, err := decodeValuePointer(pointer)
+	if err != nil {
+		return nil, err
+	}
+
+	valuesPath := path.Join(dbDir, prefix+diskTableValuesFileName)
+	file, err := os.OpenFile(valuesPath, os.O_RDONLY, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open values file: %w", err)
+	}
+	defer file.Close()
+
+	return readValueAt(file, p, verify)
+}
+
+// readValueAt 读取值文件中指针指向的值。
+func readValueAt(file *os.File, p valuePointer, verify bool) ([]byte, error) {
+	value := make([]byte, p.length)
+	if _, err := file.ReadAt(value, p.offset); err != nil {
+		if err == io.EOF {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, fmt.Errorf("the file %s is corrupted, failed to read value: %w", file.Name(), err)
+	}
+	if err := p.check(value, verify); err != nil {
+		return nil, err
+	}
+	return value, nil
+}
+
+// openSeparateValue 返回读取值文件中 pointer 指向的值的 ValueReader。
+// 未压缩的值与数据文件中的值一样按需读取，压缩的值整体读入内存后解压。
+func openSeparateValue(valuesPath string, pointer []byte, format tableFormat, verify bool) (*ValueReader, error) {
+	p, err := decodeValuePointer(pointer)
+	if err != nil {
+		return nil, err
+	}
+	file, err := os.OpenFile(valuesPath, os.O_RDONLY, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open values file: %w", err)
+	}
+
+	if format.codec != NoCompression {
+		defer file.Close()
+		compressed, err := readValueAt(file, p, verify)
+		if err != nil {
+			return nil, err
+		}
+		value, err := format.codec.decompress(compressed)
+		if err != nil {
+			return nil, err
+		}
+		r, _, err := newMemValueReader(value)
+		return r, err
+	}
+
+	if _, err := file.Seek(p.offset, io.SeekStart); err != nil {
+		file.Close()
+		return nil, fmt.Errorf("failed to seek: %w", err)
+	}
+	r := &ValueReader{
+		r:        io.LimitReader(file, int64(p.length)),
+		size:     int64(p.length),
+		file:     file,
+		checksum: p.checksum,
+	}
+	if verify {
+		r.hash = crc32.NewIEEE()
+	}
+	return r, nil
+}
+
+// valuesFileReader 按指针顺序读取值文件，供 dataFileIterator 使用。
+// 指针按键的顺序递增，顺序遍历时不需要定位；从中间开始时定位一次。
+type valuesFileReader struct {
+	file      *os.File
+	r         *bufio.Reader
+	readAhead int
+	processed *atomic.Int64
+	// r 下一个读取的字节在文件中的偏移量
+	pos int64
+}
+
+// openValuesFile 打开磁盘表的值文件，readAhead 和 processed 的含义与 newDataFileIteratorAt 相同。
+func openValuesFile(valuesPath string, readAhead int, processed *atomic.Int64) (*valuesFileReader, error) {
+	file, err := os.OpenFile(valuesPath, os.O_RDONLY, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open values file %s: %w", valuesPath, err)
+	}
+	v := &valuesFileReader{file: file, readAhead: max(readAhead, 16), processed: processed}
+	v.reset()
+	return v, nil
+}
+
+// reset 在文件的当前位置上重新建立缓冲。
+func (v *valuesFileReader) reset() {
+	var r io.Reader = v.file
+	if v.processed != nil {
+		r = &countingReader{r: v.file, n: v.processed}
+	}
+	v.r = bufio.NewReaderSize(r, v.readAhead)
+}
+
+// read 读取指针指向的（压缩后的）值，墓碑（nil）保持为 nil。
+func (v *valuesFileReader) read(pointer []byte) ([]byte, error) {
+	if pointer == nil {
+		return nil, nil
+	}
+	p, err := decodeValuePointer(pointer)
+	if err != nil {
+		return nil, err
+	}
+	if p.offset != v.pos {
+		if _, err := v.file.Seek(p.offset, io.SeekStart); err != nil {
+			return nil, fmt.Errorf("failed to seek values file: %w", err)
+		}
+		v.reset()
+		v.pos = p.offset
+	}
+
+	value := make([]byte, p.length)
+	n, err := io.ReadFull(v.r, value)
+	v.pos += int64(n)
+	if err != nil {
+		if errors.Is(err, io.EOF) {
+			err = io.ErrUnexpectedEOF
+		}
+		return nil, fmt.Errorf("the values file is corrupted, failed to read value: %w", err)
+	}
+	// 合并和扫描总是校验，与数据文件中的记录一致
+	if err := p.check(value, true); err != nil {
+		return nil, err
+	}
+	return value, nil
+}
+
+func (v *valuesFileReader) close() error {
+	return v.file.Close()
+}
+
+// diskTableDataSize 返回磁盘表中记录占用的字节数：数据文件的大小，分开存放值时加上值文件的大小。
+func diskTableDataSize(dbDir, prefix string) (int64, error) {
+	size, err := GetFileSize(path.Join(dbDir, prefix+diskTableDataFileName))
+	if err != nil {
+		return 0, err
+	}
+	valuesSize, err := GetFileSize(path.Join(dbDir, prefix+diskTableValuesFileName))
+	if os.IsNotExist(err) {
+		return size, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	return size + valuesSize, nil
+}
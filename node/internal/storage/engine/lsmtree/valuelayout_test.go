@@ -0,0 +1,282 @@
+package lsmtree
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestSeparateValues(t *testing.T) {
+	dbDir := t.TempDir()
+	options := []func(*LSMTree){MemTableMaxEntries(10), ImmutableMemtableMaxNum(1), DiskTableNumThreshold(100), SparseKeyDistance(4)}
+
+	// 先写入键和值放在一起的磁盘表，再分别写入压缩和不压缩的分开存放的磁盘表
+	expected := make(map[string]string)
+	phases := []struct {
+		options []func(*LSMTree)
+		from    int
+		value   string
+	}{
+		{nil, 0, "v1"},
+		{[]func(*LSMTree){SeparateValues(true), Compression(Snappy)}, 20, "v2"},
+		{[]func(*LSMTree){SeparateValues(true), RecordEncoding(VarintRecords)}, 40, "v3"},
+	}
+	var tree *LSMTree
+	for _, phase := range phases {
+		if tree != nil {
+			if err := tree.Close(); err != nil {
+				t.Fatalf("failed to close: %s", err)
+			}
+		}
+		var err error
+		tree, err = Open(dbDir, append(options, phase.options...)...)
+		if err != nil {
+			t.Fatalf("failed to open LSM tree %s: %s", dbDir, err)
+		}
+		for i := phase.from; i < phase.from+40; i++ {
+			key := fmt.Sprintf("key%03d", i)
+			value := strings.Repeat(phase.value, i+1)
+			if err := tree.Put([]byte(key), []byte(value)); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			expected[key] = value
+		}
+		for i := phase.from; i < phase.from+40; i += 9 {
+			key := fmt.Sprintf("key%03d", i)
+			if err := tree.Delete([]byte(key)); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			delete(expected, key)
+		}
+		if err := tree.Flush(); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+	defer func() { tree.Close() }()
+
+	separate := 0
+	oldest := tree.maxDiskTableIndex - tree.diskTableNum + 1
+	for index := oldest; index <= tree.maxDiskTableIndex; index++ {
+		prefix := strconv.Itoa(index) + "-"
+		format, err := readTableFormat(dbDir, prefix)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		_, err = os.Stat(path.Join(dbDir, prefix+diskTableValuesFileName))
+		if format.separateValues != (err == nil) {
+			t.Fatalf("table %d: separate values is %v, but the values file: %v", index, format.separateValues, err)
+		}
+		if format.separateValues {
+			separate++
+		}
+	}
+	if separate == 0 || separate == tree.diskTableNum {
+		t.Fatalf("expected both layouts, but got %d of %d separate tables", separate, tree.diskTableNum)
+	}
+
+	check := func() {
+		t.Helper()
+		for i := 0; i < 80; i++ {
+			key := fmt.Sprintf("key%03d", i)
+			got, ok, err := tree.Get([]byte(key))
+			want, exists := expected[key]
+			if err != nil || ok != exists || string(got) != want {
+				t.Fatalf("value is wrong for key %s: %q (%v) != %q (%v), err: %v", key, got, ok, want, exists, err)
+			}
+
+			r, ok, err := tree.OpenValue([]byte(key))
+			if err != nil || ok != exists {
+				t.Fatalf("expected %s to exist: %v, but got %v (%v)", key, exists, ok, err)
+			}
+			if ok {
+				got, err := io.ReadAll(r)
+				r.Close()
+				if err != nil || string(got) != want {
+					t.Fatalf("streamed value is wrong for key %s: %q != %q (%v)", key, got, want, err)
+				}
+			}
+		}
+
+		it, err := tree.Scan(nil, nil)
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		n := 0
+		for it.Next() {
+			if want := expected[string(it.Key())]; string(it.Value()) != want {
+				t.Fatalf("scanned value is wrong for key %s: %q != %q", it.Key(), it.Value(), want)
+			}
+			n++
+		}
+		if err := it.Err(); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		it.Close()
+		if n != len(expected) {
+			t.Fatalf("expected %d keys in scan, but got %d", len(expected), n)
+		}
+		if keys := collectKeys(t, func() (*Iterator, error) { return tree.ScanKeys(nil, nil) }); len(keys) != len(expected) {
+			t.Fatalf("expected %d keys in key scan, but got %d", len(expected), len(keys))
+		}
+		if err := tree.Verify(); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+	check()
+
+	// 合并后只剩一个分开存放的磁盘表，数据文件中没有值
+	for tree.diskTableNum > 1 {
+		if err := tree.compactDiskTables(context.Background()); err != nil {
+			t.Fatalf("failed to compact: %s", err)
+		}
+	}
+	prefix := strconv.Itoa(tree.maxDiskTableIndex) + "-"
+	format, err := readTableFormat(dbDir, prefix)
+	if err != nil || !format.separateValues {
+		t.Fatalf("expected the merged table to separate values, but got %v (%v)", format, err)
+	}
+	dataSize, err := GetFileSize(path.Join(dbDir, prefix+diskTableDataFileName))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	valuesSize, err := GetFileSize(path.Join(dbDir, prefix+diskTableValuesFileName))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if info := tree.tableInfos()[0]; int64(info.DataSize) != dataSize+valuesSize {
+		t.Fatalf("expected data size %d, but got %d", dataSize+valuesSize, info.DataSize)
+	}
+	check()
+
+	if err := tree.Close(); err != nil {
+		t.Fatalf("failed to close: %s", err)
+	}
+	tree, err = Open(dbDir, options...)
+	if err != nil {
+		t.Fatalf("failed to open LSM tree %s: %s", dbDir, err)
+	}
+	check()
+
+	// 值文件损坏时 Verify 和读取都能发现
+	valuesPath := path.Join(dbDir, prefix+diskTableValuesFileName)
+	data, err := os.ReadFile(valuesPath)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	data[len(data)-1] ^= 0xff
+	if err := os.WriteFile(valuesPath, data, 0600); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := tree.Verify(); !errors.Is(err, ErrChecksumMismatch) {
+		t.Fatalf("expected a checksum mismatch, but got %v", err)
+	}
+	it, err := tree.Scan(nil, nil)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	for it.Next() {
+	}
+	if err := it.Err(); !errors.Is(err, ErrChecksumMismatch) {
+		t.Fatalf("expected a checksum mismatch in scan, but got %v", err)
+	}
+	it.Close()
+}
+
+func TestSeparateValuesTableFormat(t *testing.T) {
+	dbDir := t.TempDir()
+	for _, format := range []tableFormat{
+		{separateValues: true},
+		{codec: Zstd, separateValues: true},
+		{records: VarintRecords, separateValues: true},
+	} {
+		if err := writeTableFormat(dbDir, "0-", format); err != nil {
+			t.Fatal(err)
+		}
+		if read, err := readTableFormat(dbDir, "0-"); err != nil || read != format {
+			t.Fatalf("expected %v to round trip, but got %v (%v)", format, read, err)
+		}
+	}
+
+	pointer := encodeValuePointer(1<<40, []byte("value"))
+	p, err := decodeValuePointer(pointer)
+	if err != nil || p.offset != 1<<40 || p.length != 5 {
+		t.Fatalf("unexpected pointer %+v (%v)", p, err)
+	}
+	if err := p.check([]byte("value"), true); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := p.check([]byte("other"), true); !errors.Is(err, ErrChecksumMismatch) {
+		t.Fatalf("expected a checksum mismatch, but got %v", err)
+	}
+	if _, err := decodeValuePointer(pointer[1:]); err == nil {
+		t.Fatal("expected an error for a truncated pointer")
+	}
+}
+
+// BenchmarkValueLayout 比较键和值放在一起与分开存放时遍历键、遍历键值对和点查询的开销。
+func BenchmarkValueLayout(b *testing.B) {
+	value := bytes.Repeat([]byte("v"), 1024)
+	count := 5000
+	for _, layout := range []struct {
+		name     string
+		separate bool
+	}{
+		{"combined", false},
+		{"separate", true},
+	} {
+		dbDir := b.TempDir()
+		tree, err := Open(dbDir, MemTableThreshold(64<<20), DisableWAL(true), SeparateValues(layout.separate))
+		if err != nil {
+			b.Fatalf("failed to open LSM tree %s: %s", dbDir, err)
+		}
+		for i := 0; i < count; i++ {
+			if err := tree.Put([]byte(fmt.Sprintf("key%06d", i)), value); err != nil {
+				b.Fatalf("unexpected error: %s", err)
+			}
+		}
+		if err := tree.Flush(); err != nil {
+			b.Fatalf("unexpected error: %s", err)
+		}
+
+		b.Run(layout.name+"/keys", func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if keys := collectKeys(b, func() (*Iterator, error) { return tree.ScanKeys(nil, nil) }); len(keys) != count {
+					b.Fatalf("expected %d keys, but got %d", count, len(keys))
+				}
+			}
+		})
+		b.Run(layout.name+"/scan", func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if keys := collectKeys(b, func() (*Iterator, error) { return tree.Scan(nil, nil) }); len(keys) != count {
+					b.Fatalf("expected %d keys, but got %d", count, len(keys))
+				}
+			}
+		})
+		b.Run(layout.name+"/get", func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				key := []byte(fmt.Sprintf("key%06d", i%count))
+				if _, ok, err := tree.Get(key); err != nil || !ok {
+					b.Fatalf("failed to get %s: %v", key, err)
+				}
+			}
+		})
+		b.Run(layout.name+"/compact", func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if err := tree.MajorCompact(); err != nil {
+					b.Fatalf("failed to compact: %s", err)
+				}
+			}
+		})
+
+		if err := tree.Close(); err != nil {
+			b.Fatalf("failed to close: %s", err)
+		}
+	}
+}
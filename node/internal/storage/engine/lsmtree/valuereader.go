@@ -0,0 +1,216 @@
+package lsmtree
+
+import (
+	"bytes"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"os"
+	"path"
+	"strconv"
+)
+
+// OpenValue 返回流式读取 key 当前值的 ValueReader，键不存在或已被删除时返回 false。
+// 值位于未压缩的磁盘表中时，ValueReader 直接从数据文件中按需读取，不会把整个值读入内存；
+// 位于内存表或压缩的磁盘表中时从内存中读取。
+//
+// ValueReader 基于调用时的快照：数据文件在调用时打开，之后的写入、合并和删除不影响已经打开的读取。
+// 使用完毕后必须调用 Close 释放打开的文件。
+func (t *LSMTree) OpenValue(key []byte) (*ValueReader, bool, error) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	if value, exists := t.pinned.get(key); exists {
+		return newMemValueReader(value)
+	}
+	if value, exists := t.memTable.get(key); exists {
+		return newMemValueReader(value)
+	}
+	if value, exists, _ := t.SearchInImmutableMemtable(key); exists {
+		return newMemValueReader(value)
+	}
+
+	oldest := t.maxDiskTableIndex - t.diskTableNum + 1
+	for index := t.maxDiskTableIndex; index >= oldest; index-- {
+		r, exists, err := openValueInDiskTable(t.dbDir, t.sparseIndexes, index, key, t.verifyChecksums)
+		if err != nil {
+			return nil, false, fmt.Errorf("failed to open value in disk table with index %d: %w", index, err)
+		}
+		if exists {
+			// 流式读取时 Close 之前一直持有磁盘表的文件，被合并替换的磁盘表要等它关闭后才能删除
+			if r != nil && r.file != nil {
+				r.release = t.readers.acquire(t.manifest.state.version)
+			}
+			// 更新的表中的墓碑遮蔽更旧的值
+			return r, r != nil, nil
+		}
+	}
+
+	return nil, false, nil
+}
+
+// ValueReader 流式读取 OpenValue 打开的值。
+type ValueReader struct {
+	r    io.Reader
+	size int64
+	read int64
+	// 值位于未压缩的磁盘表中时打开的数据文件，分开存放时是值文件
+	file *os.File
+	// 注销对磁盘表的读取，file 为 nil 时也为 nil，详见 tableReaders
+	release func()
+
+	// 开启校验时，读完整个值后与记录的校验和比较
+	hash     hash.Hash32
+	checksum uint32
+}
+
+// newMemValueReader 返回读取内存中的值的 ValueReader，值为 nil（墓碑）时返回 false。
+func newMemValueReader(value []byte) (*ValueReader, bool, error) {
+	if value == nil {
+		return nil, false, nil
+	}
+	return &ValueReader{r: bytes.NewReader(value), size: int64(len(value))}, true, nil
+}
+
+// openValueInDiskTable 打开磁盘表中 key 的记录，返回定位到值开头的 ValueReader。
+// 磁盘表中是墓碑时返回 nil 和 true，没有该键时返回 false。
+func openValueInDiskTable(dbDir string, sparse *sparseIndexCache, index int, key []byte, verify bool) (*ValueReader, bool, error) {
+	prefix := strconv.Itoa(index) + "-"
+	offset, ok, err := locateInDiskTable(dbDir, sparse, index, key, verify)
+	if err != nil || !ok {
+		return nil, false, err
+	}
+
+	format, err := readTableFormat(dbDir, prefix)
+	if err != nil {
+		return nil, false, err
+	}
+
+	dataPath := path.Join(dbDir, prefix+diskTableDataFileName)
+	file, err := os.OpenFile(dataPath, os.O_RDONLY, 0600)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to open data file: %w", err)
+	}
+	valuesPath := path.Join(dbDir, prefix+diskTableValuesFileName)
+	r, err := openValueAt(file, int64(offset), key, format, valuesPath, verify)
+	if err != nil {
+		file.Close()
+		return nil, false, fmt.Errorf("failed to read %s: %w", dataPath, err)
+	}
+	// 只有未压缩、未分开存放的值直接从数据文件中读取
+	if r == nil || r.file != file {
+		file.Close()
+	}
+	return r, true, nil
+}
+
+// openValueAt 读取数据文件中 offset 处记录的头部和键，记录的格式见 RecordFormat。
+// 压缩的值只能整体解压，因此被完整地读入内存；分开存放时记录中是指针，值从 valuesPath 中读取。
+func openValueAt(file *os.File, offset int64, key []byte, format tableFormat, valuesPath string, verify bool) (*ValueReader, error) {
+	if _, err := file.Seek(offset, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("failed to seek: %w", err)
+	}
+
+	header, err := format.records.readHeader(file)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	if err != nil {
+		return nil, fmt.Errorf("the file is corrupted, failed to read entry header: %w", err)
+	}
+	checksum, encodedKeyLen, keyLen, valueLen := header.checksum, header.encodedKeyLen, header.keyLen, header.valueLen
+	if keyLen != len(key) {
+		return nil, fmt.Errorf("the file is corrupted, invalid entry at offset %d", offset)
+	}
+	storedKey := make([]byte, keyLen)
+	if _, err := io.ReadFull(file, storedKey); err != nil {
+		return nil, fmt.Errorf("the file is corrupted, failed to read key: %w", err)
+	}
+	if !bytes.Equal(storedKey, key) {
+		return nil, fmt.Errorf("the file is corrupted, unexpected key at offset %d", offset)
+	}
+	// 键的标志位于键和值之间
+	flags := make([]byte, header.flagsLen())
+	if _, err := io.ReadFull(file, flags); err != nil {
+		return nil, fmt.Errorf("the file is corrupted, failed to read flags: %w", err)
+	}
+	if valueLen == 0 {
+		return nil, nil
+	}
+
+	var h hash.Hash32
+	if verify {
+		h = crc32.NewIEEE()
+		h.Write(encodedKeyLen)
+		h.Write(storedKey)
+		h.Write(flags)
+	}
+
+	if format.codec != NoCompression || format.separateValues {
+		compressed := make([]byte, valueLen)
+		if _, err := io.ReadFull(file, compressed); err != nil {
+			return nil, fmt.Errorf("the file is corrupted, failed to read value: %w", err)
+		}
+		if h != nil {
+			h.Write(compressed)
+			if h.Sum32() != checksum {
+				return nil, ErrChecksumMismatch
+			}
+		}
+		if format.separateValues {
+			return openSeparateValue(valuesPath, compressed, format, verify)
+		}
+		value, err := format.codec.decompress(compressed)
+		if err != nil {
+			return nil, err
+		}
+		r, _, err := newMemValueReader(value)
+		return r, err
+	}
+
+	return &ValueReader{
+		r:        io.LimitReader(file, int64(valueLen)),
+		size:     int64(valueLen),
+		file:     file,
+		hash:     h,
+		checksum: checksum,
+	}, nil
+}
+
+// Size 返回值的总长度。
+func (r *ValueReader) Size() int64 {
+	return r.size
+}
+
+// Read 读取值的下一部分，读完后返回 io.EOF。
+// 开启校验时，值的内容与记录的校验和不一致会在读到结尾时返回 ErrChecksumMismatch。
+func (r *ValueReader) Read(p []byte) (int, error) {
+	n, err := r.r.Read(p)
+	r.read += int64(n)
+	if r.hash != nil {
+		r.hash.Write(p[:n])
+	}
+	if err == io.EOF {
+		if r.read < r.size {
+			return n, fmt.Errorf("the file is corrupted, value ends after %d of %d bytes: %w", r.read, r.size, io.ErrUnexpectedEOF)
+		}
+		if r.hash != nil && r.hash.Sum32() != r.checksum {
+			return n, ErrChecksumMismatch
+		}
+	}
+	return n, err
+}
+
+// Close 关闭打开的数据文件或值文件。
+func (r *ValueReader) Close() error {
+	if r.file == nil {
+		return nil
+	}
+	err := r.file.Close()
+	r.file = nil
+	if r.release != nil {
+		r.release()
+	}
+	return err
+}
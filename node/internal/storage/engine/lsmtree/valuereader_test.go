@@ -0,0 +1,158 @@
+package lsmtree
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"os"
+	"path"
+	"strconv"
+	"testing"
+)
+
+func TestOpenValue(t *testing.T) {
+	dbDir := t.TempDir()
+	tree, err := Open(dbDir, ValueSizeLimit(4<<20), MemTableThreshold(64<<20))
+	if err != nil {
+		t.Fatalf("failed to open LSM tree %s: %s", dbDir, err)
+	}
+	defer tree.Close()
+
+	flush := func() {
+		t.Helper()
+		if err := tree.flushMemTable(context.Background(), tree.memTable); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		tree.refreshMemTable()
+	}
+	large := make([]byte, 3<<20)
+	rand.New(rand.NewSource(1)).Read(large)
+
+	// readValue 以较小的块读取整个值，返回值和 ValueReader 是否直接读取数据文件
+	readValue := func(key string) ([]byte, bool) {
+		t.Helper()
+		r, ok, err := tree.OpenValue([]byte(key))
+		if err != nil || !ok {
+			t.Fatalf("expected %s to exist, but got %v %v", key, ok, err)
+		}
+		defer r.Close()
+		var buf bytes.Buffer
+		if _, err := io.CopyBuffer(&buf, r, make([]byte, 4096)); err != nil {
+			t.Fatalf("failed to read %s: %s", key, err)
+		}
+		if int64(buf.Len()) != r.Size() {
+			t.Fatalf("expected %d bytes, but read %d", r.Size(), buf.Len())
+		}
+		return buf.Bytes(), r.file != nil
+	}
+
+	// 内存表中的值
+	if err := tree.Put([]byte("large"), large); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if value, fromFile := readValue("large"); !bytes.Equal(value, large) || fromFile {
+		t.Fatalf("expected the value to be read from the memtable, fromFile=%v", fromFile)
+	}
+
+	// 刷新后从数据文件中读取
+	flush()
+	if value, fromFile := readValue("large"); !bytes.Equal(value, large) || !fromFile {
+		t.Fatalf("expected the value to be streamed from the data file, fromFile=%v", fromFile)
+	}
+
+	// 打开的读取不受之后的覆盖、删除和合并影响
+	r, _, err := tree.OpenValue([]byte("large"))
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	defer r.Close()
+	if err := tree.Put([]byte("large"), []byte("small")); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	flush()
+	if err := tree.compactDiskTables(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if value, err := io.ReadAll(r); err != nil || !bytes.Equal(value, large) {
+		t.Fatalf("expected the snapshot value, but got %d bytes: %v", len(value), err)
+	}
+	if value, _ := readValue("large"); string(value) != "small" {
+		t.Fatalf("expected small, but got %d bytes", len(value))
+	}
+
+	// 更新的磁盘表中的墓碑遮蔽旧值
+	if err := tree.Delete([]byte("large")); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	flush()
+	if _, ok, err := tree.OpenValue([]byte("large")); ok || err != nil {
+		t.Fatalf("expected a deleted key to be missing, but got %v %v", ok, err)
+	}
+	if _, ok, err := tree.OpenValue([]byte("missing")); ok || err != nil {
+		t.Fatalf("expected a missing key, but got %v %v", ok, err)
+	}
+
+	for _, limit := range []int{0, MaxValueSizeLimit + 1} {
+		if _, err := Open(t.TempDir(), ValueSizeLimit(limit)); err == nil {
+			t.Fatalf("expected an error for value size limit %d", limit)
+		}
+	}
+}
+
+func TestOpenValueCompressedAndCorrupted(t *testing.T) {
+	dbDir := t.TempDir()
+	tree, err := Open(dbDir, ValueSizeLimit(1<<20), MemTableThreshold(64<<20), Compression(Snappy))
+	if err != nil {
+		t.Fatalf("failed to open LSM tree %s: %s", dbDir, err)
+	}
+	defer tree.Close()
+
+	value := bytes.Repeat([]byte("compressible "), 50000)
+	if err := tree.Put([]byte("key"), value); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := tree.flushMemTable(context.Background(), tree.memTable); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	tree.refreshMemTable()
+
+	// 压缩的值被整体解压后读取
+	r, ok, err := tree.OpenValue([]byte("key"))
+	if err != nil || !ok {
+		t.Fatalf("expected key to exist, but got %v %v", ok, err)
+	}
+	if got, err := io.ReadAll(r); err != nil || !bytes.Equal(got, value) || r.Size() != int64(len(value)) {
+		t.Fatalf("unexpected value of %d bytes: %v", len(got), err)
+	}
+	r.Close()
+
+	// 未压缩的值被破坏时，读到结尾才能发现
+	tree.compression = NoCompression
+	if err := tree.Put([]byte("key"), value); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := tree.flushMemTable(context.Background(), tree.memTable); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	tree.refreshMemTable()
+	dataPath := path.Join(dbDir, strconv.Itoa(tree.maxDiskTableIndex)+"-"+diskTableDataFileName)
+	data, err := os.ReadFile(dataPath)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	data[len(data)/2] ^= 0xff
+	if err := os.WriteFile(dataPath, data, 0600); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	r, ok, err = tree.OpenValue([]byte("key"))
+	if err != nil || !ok {
+		t.Fatalf("expected key to exist, but got %v %v", ok, err)
+	}
+	defer r.Close()
+	if _, err := io.ReadAll(r); !errors.Is(err, ErrChecksumMismatch) {
+		t.Fatalf("expected %v, but got %v", ErrChecksumMismatch, err)
+	}
+}
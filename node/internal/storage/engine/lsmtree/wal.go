@@ -1,76 +1,173 @@
 package lsmtree
 
 import (
+	"errors"
 	"fmt"
 	"io"
 	"os"
 	"path"
+	"sync/atomic"
 )
 
-// clearWAL关闭当前文件，并以截断模式打开新文件。
-func clearWAL(dbDir string, wal *os.File) (*os.File, error) {
+// clearWAL以截断模式打开新文件，再关闭当前文件。
+// 打开失败时当前文件保持打开，之后的写入照常追加到它上面。
+func clearWAL(walDir string, wal *os.File) (*os.File, error) {
 	// 拼接预写日志（WAL）文件的路径。
-	walPath := path.Join(dbDir, walFileName)
+	walPath := path.Join(walDir, walFileName)
 
-	// 关闭当前的WAL文件，如果关闭失败则返回相应错误。
+	// 以读写、创建、截断模式打开WAL文件，如果打开失败则返回相应错误。
+	newWAL, err := os.OpenFile(walPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open the file %s: %w", walPath, err)
+	}
+
+	// 关闭旧的WAL文件，关闭失败时新文件已经可用，一并返回。
 	if err := wal.Close(); err != nil {
-		return nil, fmt.Errorf("failed to close the WAL file %s: %w", walPath, err)
+		return newWAL, fmt.Errorf("failed to close the WAL file %s: %w", walPath, err)
 	}
 
-	// 以读写、创建、截断模式打开WAL文件，如果打开失败则返回相应错误。
-	wal, err := os.OpenFile(walPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
+	return newWAL, nil
+}
+
+// appendToWAL将条目追加到WAL文件中，返回写入的字节数。
+// flags 为 0 时记录与没有标志的旧版本相同。
+func appendToWAL(wal *os.File, key []byte, value []byte, flags uint32) (int, error) {
+	n, err := writeToWAL(wal, key, value, flags)
 	if err != nil {
-		return nil, fmt.Errorf("failed to open the file %s: %w", walPath, err)
+		return n, err
 	}
 
-	return wal, nil
+	// 同步文件（将缓存中的数据刷写到磁盘等持久化存储），如果同步失败则返回相应错误。
+	if err := wal.Sync(); err != nil {
+		return n, fmt.Errorf("failed to sync the file: %w", err)
+	}
+
+	return n, nil
 }
 
-// appendToWAL将条目追加到WAL文件中。
-func appendToWAL(wal *os.File, key []byte, value []byte) error {
+// writeToWAL 与 appendToWAL 相同，但不同步文件，用于组提交（详见 GroupCommit）。
+func writeToWAL(wal *os.File, key []byte, value []byte, flags uint32) (int, error) {
 	// 出于安全考虑，因为文件是以读写模式打开的，将文件指针定位到文件末尾，如果定位失败则返回相应错误。
 	if _, err := wal.Seek(0, io.SeekEnd); err != nil {
-		return fmt.Errorf("failed to seek to the end: %w", err)
+		return 0, fmt.Errorf("failed to seek to the end: %w", err)
 	}
 
 	// 将键值对进行编码并写入文件，如果编码或写入失败则返回相应错误。
-	if _, err := encode(key, value, wal); err != nil {
-		return fmt.Errorf("failed to encode and write to the file: %w", err)
+	n, err := encodeWithFlags(key, value, flags, wal)
+	if err != nil {
+		return n, fmt.Errorf("failed to encode and write to the file: %w", err)
 	}
 
-	// 同步文件（将缓存中的数据刷写到磁盘等持久化存储），如果同步失败则返回相应错误。
-	if err := wal.Sync(); err != nil {
-		return fmt.Errorf("failed to sync the file: %w", err)
-	}
+	return n, nil
+}
 
+// Sync 把当前的 WAL 和固定的键的日志同步到磁盘，返回后之前成功的写入在崩溃后仍然存在。
+// 目前每次写入在返回之前都已经同步了 WAL，Sync 只是一个显式的持久化屏障，几乎没有开销；
+// 开启 DisableWAL 或以只读方式打开时什么也不做。
+func (t *LSMTree) Sync() error {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	for _, f := range []*os.File{t.wal, t.pinnedLog} {
+		if f == nil {
+			continue
+		}
+		if err := f.Sync(); err != nil {
+			return fmt.Errorf("failed to sync file %s: %w", f.Name(), err)
+		}
+	}
 	return nil
 }
 
 // loadMemTable从WAL文件中加载内存表（MemTable）。
-func loadMemTable(wal *os.File) (*memTable, error) {
+// 追加记录时崩溃会在文件末尾留下不完整的记录，加载时把它截掉，返回截掉的字节数。
+func loadMemTable(wal *os.File) (*memTable, int64, error) {
+	memTable, valid, err := readWAL(wal)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	end, err := wal.Seek(0, io.SeekEnd)
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to seek to the end: %w", err)
+	}
+	if valid == end {
+		return memTable, 0, nil
+	}
+	// 截掉不完整的记录，之后追加的记录紧跟在最后一条完整的记录之后
+	if err := wal.Truncate(valid); err != nil {
+		return nil, 0, fmt.Errorf("failed to truncate the incomplete record: %w", err)
+	}
+	if err := wal.Sync(); err != nil {
+		return nil, 0, fmt.Errorf("failed to sync the file: %w", err)
+	}
+	return memTable, end - valid, nil
+}
+
+// readWAL从WAL文件中读取所有完整的记录，返回内存表和完整的记录占用的字节数。
+// 文件末尾不完整的记录被忽略，其他位置的损坏仍然返回错误。
+func readWAL(wal *os.File) (*memTable, int64, error) {
 	// 出于安全考虑，因为文件是以读写模式打开的，将文件指针定位到文件开头，如果定位失败则返回相应错误。
 	if _, err := wal.Seek(0, io.SeekStart); err != nil {
-		return nil, fmt.Errorf("failed to seek to the beginning: %w", err)
+		return nil, 0, fmt.Errorf("failed to seek to the beginning: %w", err)
 	}
 
 	// 创建一个新的内存表实例。
 	memTable := newMemTable()
+	var read atomic.Int64
+	r := &countingReader{r: wal, n: &read}
+	var valid int64
 	for {
 		// 从WAL文件中解码出键、值，如果读取或解码出现错误（非文件末尾错误）则返回相应错误，
 		// 如果遇到文件末尾则返回已加载好的内存表实例。
-		key, value, err := decode(wal)
-		if err != nil && err != io.EOF {
-			return nil, fmt.Errorf("failed to read: %w", err)
-		}
+		key, value, flags, err := decodeRecordWithFlags(r, true)
 		if err == io.EOF {
-			return memTable, nil
+			return memTable, valid, nil
 		}
+		// 记录读到一半遇到文件末尾，只可能是最后一条记录没有写完
+		if errors.Is(err, io.ErrUnexpectedEOF) {
+			return memTable, valid, nil
+		}
+		if err != nil {
+			return nil, 0, fmt.Errorf("failed to read: %w", err)
+		}
+
+		records := []walRecord{{key: key, value: value, flags: flags}}
+		if n, ok := batchMarker(key, value); ok {
+			// 批量没有写完时整个批量都被丢弃
+			records, err = readBatch(r, n)
+			if errors.Is(err, io.ErrUnexpectedEOF) {
+				return memTable, valid, nil
+			}
+			if err != nil {
+				return nil, 0, fmt.Errorf("failed to read: %w", err)
+			}
+		}
+		valid = read.Load()
 
 		// 如果值不为空，则将键值对插入内存表；如果值为空，则在内存表中根据键执行删除操作。
-		if value != nil {
-			memTable.put(key, value)
-		} else {
-			memTable.delete(key)
+		for _, record := range records {
+			if record.value != nil {
+				memTable.putWithFlags(record.key, record.value, record.flags)
+			} else {
+				memTable.delete(record.key)
+			}
 		}
 	}
 }
+
+// loadReadOnlyMemTable以只读方式从WAL文件中加载内存表，WAL文件不存在时返回空的内存表。
+func loadReadOnlyMemTable(walPath string) (*memTable, error) {
+	wal, err := os.OpenFile(walPath, os.O_RDONLY, 0600)
+	if os.IsNotExist(err) {
+		return newMemTable(), nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to open the file %s: %w", walPath, err)
+	}
+	defer wal.Close()
+
+	// 只读模式下不修改文件，忽略末尾不完整的记录
+	memTable, _, err := readWAL(wal)
+	return memTable, err
+}
@@ -0,0 +1,261 @@
+package lsmtree
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// 归档的 WAL 段的文件名格式，编号是段中第一条记录的序号。
+const (
+	archivedWALPrefix = "wal-"
+	archivedWALSuffix = ".db"
+)
+
+// ErrOutsideWALWindow 当 ReplayTo 的序号不在归档的 WAL 覆盖的范围内时返回。
+var ErrOutsideWALWindow = errors.New("sequence is outside the archived WAL window")
+
+// ArchiveWAL 开启 WAL 归档，retention 是保留的归档段数量上限（默认 0，即不归档）。
+// 开启后刷新不再截断 WAL，而是把它改名为一个归档段，超过 retention 的最旧的段被删除，
+// 配合 Backup 和 ReplayTo 可以把数据库恢复到窗口内任意一条写入之后的状态。
+//
+// 每个段包含两次刷新之间的全部写入，大约是 MemTableThreshold × ImmutableMemtableMaxNum 字节
+// 加上每条记录 20 字节的编码开销，因此归档额外占用的磁盘空间约为 retention 倍的该大小。
+func ArchiveWAL(retention int) func(*LSMTree) {
+	return func(t *LSMTree) {
+		t.walRetention = retention
+	}
+}
+
+// Sequence 返回已写入 WAL 的记录数量，也就是下一条写入的序号。
+// 每次 Put、Append 和 Delete 都占用一个序号，PutBatch 中写入的每个键值对各占用一个序号。只有开启 ArchiveWAL 时序号才跨越刷新连续。
+func (t *LSMTree) Sequence() uint64 {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	return t.walSeq
+}
+
+// WALWindow 返回可以传给 ReplayTo 的序号范围 [first, last]。
+func (t *LSMTree) WALWindow() (uint64, uint64, error) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	segments, err := listArchivedWALs(t.walDir)
+	if err != nil {
+		return 0, 0, err
+	}
+	if len(segments) > 0 {
+		return segments[0], t.walSeq, nil
+	}
+	return t.walStart, t.walSeq, nil
+}
+
+// ReplayTo 把归档窗口内序号小于 seq 的写入按顺序应用到 dst，使 dst 恢复到第 seq 条写入之前的状态。
+// dst 应该是从窗口开始之后、seq 之前的某个时刻的 Backup 打开的数据库；
+// 窗口覆盖了数据库的全部历史时也可以是空数据库。dst 不能是 t 本身。
+// seq 不在 WALWindow 返回的范围内时返回 ErrOutsideWALWindow。
+func (t *LSMTree) ReplayTo(dst *LSMTree, seq uint64) error {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	if t.walRetention <= 0 {
+		return errors.New("WAL archiving is not enabled")
+	}
+
+	segments, err := listArchivedWALs(t.walDir)
+	if err != nil {
+		return err
+	}
+	first := t.walStart
+	if len(segments) > 0 {
+		first = segments[0]
+	}
+	if seq < first || seq > t.walSeq {
+		return fmt.Errorf("sequence %d, window [%d, %d]: %w", seq, first, t.walSeq, ErrOutsideWALWindow)
+	}
+
+	paths := make([]string, 0, len(segments)+1)
+	starts := make([]uint64, 0, len(segments)+1)
+	for _, start := range segments {
+		paths = append(paths, path.Join(t.walDir, archivedWALName(start)))
+		starts = append(starts, start)
+	}
+	paths = append(paths, path.Join(t.walDir, walFileName))
+	starts = append(starts, t.walStart)
+
+	for i, walPath := range paths {
+		if starts[i] >= seq {
+			break
+		}
+		if err := replayWALSegment(walPath, starts[i], seq, dst); err != nil {
+			return fmt.Errorf("failed to replay %s: %w", walPath, err)
+		}
+	}
+
+	return nil
+}
+
+// replayWALSegment 把第一条记录序号为 start 的 WAL 段中序号小于 seq 的记录应用到 dst。
+func replayWALSegment(walPath string, start, seq uint64, dst *LSMTree) error {
+	f, err := os.Open(walPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	for n := start; n < seq; n++ {
+		key, value, flags, err := decodeWALRecord(f)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return fmt.Errorf("failed to read: %w", err)
+		}
+
+		if value == nil {
+			err = dst.Delete(key)
+		} else {
+			err = dst.PutWithFlags(key, value, flags)
+		}
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// archivedWALName 返回第一条记录序号为 start 的归档段的文件名。
+func archivedWALName(start uint64) string {
+	return fmt.Sprintf("%s%020d%s", archivedWALPrefix, start, archivedWALSuffix)
+}
+
+// listArchivedWALs 按从旧到新的顺序返回 walDir 中归档段的起始序号。
+func listArchivedWALs(walDir string) ([]uint64, error) {
+	entries, err := os.ReadDir(walDir)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read directory %s: %w", walDir, err)
+	}
+
+	var starts []uint64
+	for _, entry := range entries {
+		name := entry.Name()
+		if !strings.HasPrefix(name, archivedWALPrefix) || !strings.HasSuffix(name, archivedWALSuffix) {
+			continue
+		}
+		start, err := strconv.ParseUint(strings.TrimSuffix(strings.TrimPrefix(name, archivedWALPrefix), archivedWALSuffix), 10, 64)
+		if err != nil {
+			continue
+		}
+		starts = append(starts, start)
+	}
+	sort.Slice(starts, func(i, j int) bool { return starts[i] < starts[j] })
+
+	return starts, nil
+}
+
+// archiveWAL 把当前的 WAL 改名为起始序号为 start 的归档段，打开一个新的空 WAL 之后再关闭旧的文件。
+// 归档段超过 retention 个时删除最旧的段。
+// 改名或打开新的 WAL 失败时返回 nil，当前的 WAL 仍然打开并留在原来的路径上，之后的写入照常追加到它上面。
+func archiveWAL(walDir string, wal *os.File, start uint64, retention int) (*os.File, error) {
+	walPath := path.Join(walDir, walFileName)
+	archivePath := path.Join(walDir, archivedWALName(start))
+	if err := os.Rename(walPath, archivePath); err != nil {
+		return nil, fmt.Errorf("failed to archive the WAL file %s: %w", walPath, err)
+	}
+
+	newWAL, err := os.OpenFile(walPath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		// 把归档段改回原名，继续使用仍然打开的旧文件
+		if renameErr := os.Rename(archivePath, walPath); renameErr != nil {
+			return nil, fmt.Errorf("failed to open the file %s: %w (and failed to restore it: %v)", walPath, err, renameErr)
+		}
+		return nil, fmt.Errorf("failed to open the file %s: %w", walPath, err)
+	}
+	if err := wal.Close(); err != nil {
+		return newWAL, fmt.Errorf("failed to close the archived WAL file %s: %w", archivePath, err)
+	}
+	wal = newWAL
+
+	segments, err := listArchivedWALs(walDir)
+	if err != nil {
+		return wal, err
+	}
+	for len(segments) > retention {
+		if err := os.Remove(path.Join(walDir, archivedWALName(segments[0]))); err != nil {
+			return wal, fmt.Errorf("failed to remove archived WAL: %w", err)
+		}
+		segments = segments[1:]
+	}
+
+	return wal, nil
+}
+
+// removeArchivedWALs 删除 walDir 中所有的归档段。
+func removeArchivedWALs(walDir string) error {
+	segments, err := listArchivedWALs(walDir)
+	if err != nil {
+		return err
+	}
+	for _, start := range segments {
+		if err := os.Remove(path.Join(walDir, archivedWALName(start))); err != nil {
+			return fmt.Errorf("failed to remove archived WAL: %w", err)
+		}
+	}
+	return nil
+}
+
+// countWALRecords 返回 WAL 文件中的记录数量，文件不存在时返回 0。
+func countWALRecords(walPath string) (uint64, error) {
+	f, err := os.Open(walPath)
+	if os.IsNotExist(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	var n uint64
+	for {
+		_, _, _, err := decodeWALRecord(f)
+		if err == io.EOF {
+			return n, nil
+		}
+		if err != nil {
+			return 0, fmt.Errorf("failed to read %s: %w", walPath, err)
+		}
+		n++
+	}
+}
+
+// loadWALSequence 在 Open 时恢复序号：当前 WAL 的起始序号是最新的归档段的起始序号加上它的记录数量。
+func (t *LSMTree) loadWALSequence() error {
+	segments, err := listArchivedWALs(t.walDir)
+	if err != nil {
+		return err
+	}
+	t.walStart = 0
+	if len(segments) > 0 {
+		last := segments[len(segments)-1]
+		n, err := countWALRecords(path.Join(t.walDir, archivedWALName(last)))
+		if err != nil {
+			return err
+		}
+		t.walStart = last + n
+	}
+
+	n, err := countWALRecords(path.Join(t.walDir, walFileName))
+	if err != nil {
+		return err
+	}
+	t.walSeq = t.walStart + n
+
+	return nil
+}
@@ -0,0 +1,174 @@
+package lsmtree
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"testing"
+)
+
+func TestReplayToMidWindow(t *testing.T) {
+	dbDir := t.TempDir()
+	tree, err := Open(dbDir, MemTableMaxEntries(10), ImmutableMemtableMaxNum(1), DiskTableNumThreshold(100), ArchiveWAL(100))
+	if err != nil {
+		t.Fatalf("failed to open LSM tree %s: %s", dbDir, err)
+	}
+
+	// 每一轮覆盖 20 个键并删除其中一个，跨越多次刷新
+	state := make(map[string]string)
+	var midSeq uint64
+	var midState map[string]string
+	for round := 0; round < 10; round++ {
+		for i := 0; i < 20; i++ {
+			key := fmt.Sprintf("key%02d", i)
+			value := fmt.Sprintf("%d-%d", round, i)
+			if err := tree.Put([]byte(key), []byte(value)); err != nil {
+				t.Fatalf("unexpected error: %s", err)
+			}
+			state[key] = value
+		}
+		key := fmt.Sprintf("key%02d", round)
+		if err := tree.Delete([]byte(key)); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		delete(state, key)
+
+		if round == 4 {
+			midSeq = tree.Sequence()
+			midState = make(map[string]string, len(state))
+			for k, v := range state {
+				midState[k] = v
+			}
+		}
+	}
+	if tree.diskTableNum < 5 {
+		t.Fatalf("expected several flushes, got %d disk tables", tree.diskTableNum)
+	}
+	if err := tree.Close(); err != nil {
+		t.Fatalf("failed to close: %s", err)
+	}
+
+	// 重新打开后序号保持连续
+	tree, err = Open(dbDir, MemTableMaxEntries(10), ImmutableMemtableMaxNum(1), DiskTableNumThreshold(100), ArchiveWAL(100))
+	if err != nil {
+		t.Fatalf("failed to reopen LSM tree %s: %s", dbDir, err)
+	}
+	defer tree.Close()
+	if seq := tree.Sequence(); seq != 10*21 {
+		t.Fatalf("expected sequence %d, got %d", 10*21, seq)
+	}
+	first, last, err := tree.WALWindow()
+	if err != nil || first != 0 || last != 10*21 {
+		t.Fatalf("unexpected window [%d, %d]: %v", first, last, err)
+	}
+
+	// 窗口覆盖了全部历史，从空数据库恢复到中间的时刻
+	dst, err := Open(path.Join(t.TempDir(), "restore"))
+	if err != nil {
+		t.Fatalf("failed to open restore target: %s", err)
+	}
+	defer dst.Close()
+	if err := tree.ReplayTo(dst, midSeq); err != nil {
+		t.Fatalf("failed to replay: %s", err)
+	}
+	for i := 0; i < 20; i++ {
+		key := fmt.Sprintf("key%02d", i)
+		value, ok, err := dst.Get([]byte(key))
+		if err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+		want, exists := midState[key]
+		if ok != exists || string(value) != want {
+			t.Fatalf("value is wrong for key %s: %q (%v) != %q (%v)", key, value, ok, want, exists)
+		}
+	}
+
+	if err := tree.ReplayTo(dst, last+1); !errors.Is(err, ErrOutsideWALWindow) {
+		t.Fatalf("expected %v, got %v", ErrOutsideWALWindow, err)
+	}
+}
+
+func TestArchiveWALRetention(t *testing.T) {
+	dbDir := t.TempDir()
+	tree, err := Open(dbDir, MemTableMaxEntries(10), ImmutableMemtableMaxNum(1), DiskTableNumThreshold(100), ArchiveWAL(2))
+	if err != nil {
+		t.Fatalf("failed to open LSM tree %s: %s", dbDir, err)
+	}
+	defer tree.Close()
+
+	for i := 0; i < 100; i++ {
+		key := []byte(fmt.Sprintf("key%03d", i))
+		if err := tree.Put(key, key); err != nil {
+			t.Fatalf("unexpected error: %s", err)
+		}
+	}
+
+	segments, err := listArchivedWALs(dbDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(segments) != 2 {
+		t.Fatalf("expected 2 archived segments, got %d", len(segments))
+	}
+	first, _, err := tree.WALWindow()
+	if err != nil || first == 0 {
+		t.Fatalf("expected the oldest segments to be pruned, window starts at %d: %v", first, err)
+	}
+	dst, err := Open(path.Join(t.TempDir(), "restore"))
+	if err != nil {
+		t.Fatalf("failed to open restore target: %s", err)
+	}
+	defer dst.Close()
+	if err := tree.ReplayTo(dst, first-1); !errors.Is(err, ErrOutsideWALWindow) {
+		t.Fatalf("expected %v, got %v", ErrOutsideWALWindow, err)
+	}
+}
+
+func TestArchiveWALFailureKeepsWAL(t *testing.T) {
+	dbDir := t.TempDir()
+	tree, err := Open(dbDir, DiskTableNumThreshold(100), ArchiveWAL(2))
+	if err != nil {
+		t.Fatalf("failed to open LSM tree %s: %s", dbDir, err)
+	}
+
+	if err := tree.Put([]byte("key0"), []byte("value")); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	// 归档段的路径上有一个非空目录，改名失败
+	blocker := path.Join(dbDir, archivedWALName(tree.walStart))
+	if err := os.MkdirAll(path.Join(blocker, "file"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.Flush(); err == nil {
+		t.Fatal("expected archiving the WAL to fail")
+	}
+	if err := os.RemoveAll(blocker); err != nil {
+		t.Fatal(err)
+	}
+
+	// 当前的 WAL 仍然可用，之后的写入和刷新照常进行
+	if err := tree.Put([]byte("key1"), []byte("value")); err != nil {
+		t.Fatalf("expected writes to continue after a failed archive, but got %s", err)
+	}
+	if err := tree.Flush(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := tree.Put([]byte("key2"), []byte("value")); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if err := tree.Close(); err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+
+	tree, err = Open(dbDir, DiskTableNumThreshold(100), ArchiveWAL(2))
+	if err != nil {
+		t.Fatalf("failed to reopen LSM tree %s: %s", dbDir, err)
+	}
+	defer tree.Close()
+	for i := 0; i < 3; i++ {
+		if _, ok, err := tree.Get([]byte(fmt.Sprintf("key%d", i))); err != nil || !ok {
+			t.Fatalf("expected key%d to exist, but got %v %v", i, ok, err)
+		}
+	}
+}
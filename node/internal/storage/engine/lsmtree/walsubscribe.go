@@ -0,0 +1,189 @@
+package lsmtree
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+)
+
+// ErrWALDropped 当订阅期间调用了 DropAll 时由 WALSubscription.Next 返回，之前的序号不再有效。
+var ErrWALDropped = errors.New("the database was dropped during the WAL subscription")
+
+// WALEntry 是 WAL 中的一条写入。
+type WALEntry struct {
+	// 写入的序号，详见 Sequence
+	Seq uint64
+	Key []byte
+	// 删除时为 nil
+	Value []byte
+	Flags uint32
+}
+
+// WALSubscription 按顺序返回写入 WAL 的记录，由 WALSubscribe 创建，不能被多个 goroutine 同时使用。
+type WALSubscription struct {
+	t *LSMTree
+	// 下一条要返回的记录的序号
+	next uint64
+	// 创建时数据库的纪元
+	epoch string
+
+	// 正在读取的 WAL 段，下一次读取的是序号为 next 的记录；没有打开的段时为 nil
+	file *os.File
+}
+
+// WALSubscribe 返回从序号 from 开始的 WAL 订阅，用于把写入异步地复制到其他节点。
+// 订阅先返回归档段和当前 WAL 中已有的记录，然后等待新的写入；刷新时 WAL 被归档、打开新的 WAL，订阅会接着读取新的 WAL。
+// 需要开启 ArchiveWAL，序号才跨越刷新连续；from 不在 WALWindow 返回的范围内时返回 ErrOutsideWALWindow。
+// 订阅读得太慢、需要的段已经超出 retention 被删除时，Next 同样返回 ErrOutsideWALWindow，
+// 此时应从备份重新开始复制。可以用 Position 记录进度，之后用它创建新的订阅继续读取。
+func (t *LSMTree) WALSubscribe(from uint64) (*WALSubscription, error) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	if t.walRetention <= 0 {
+		return nil, errors.New("WAL archiving is not enabled")
+	}
+	if err := t.checkWALWindow(from); err != nil {
+		return nil, err
+	}
+	return &WALSubscription{t: t, next: from, epoch: t.epoch}, nil
+}
+
+// checkWALWindow 检查序号 seq 的记录仍然在 WAL 中或者是下一条写入，调用方必须持有读锁或写锁。
+func (t *LSMTree) checkWALWindow(seq uint64) error {
+	segments, err := listArchivedWALs(t.walDir)
+	if err != nil {
+		return err
+	}
+	first := t.walStart
+	if len(segments) > 0 {
+		first = segments[0]
+	}
+	if seq < first || seq > t.walSeq {
+		return fmt.Errorf("sequence %d, window [%d, %d]: %w", seq, first, t.walSeq, ErrOutsideWALWindow)
+	}
+	return nil
+}
+
+// Position 返回下一条要返回的记录的序号。
+func (s *WALSubscription) Position() uint64 {
+	return s.next
+}
+
+// Next 返回下一条记录，还没有新的写入时等待。ctx 被取消时返回 ctx 的错误，数据库关闭时返回 context.Canceled。
+func (s *WALSubscription) Next(ctx context.Context) (WALEntry, error) {
+	for {
+		entry, ok, wait, err := s.read()
+		if err != nil || ok {
+			return entry, err
+		}
+		select {
+		case <-wait:
+		case <-ctx.Done():
+			return WALEntry{}, ctx.Err()
+		case <-s.t.ctx.Done():
+			return WALEntry{}, s.t.ctx.Err()
+		}
+	}
+}
+
+// read 在读锁下读取序号为 next 的记录。没有新的写入时返回下一次写入时关闭的通道。
+func (s *WALSubscription) read() (WALEntry, bool, <-chan struct{}, error) {
+	t := s.t
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	if t.epoch != s.epoch {
+		return WALEntry{}, false, nil, ErrWALDropped
+	}
+	if s.next >= t.walSeq {
+		// 写入持有写锁，在读锁下注册的通道不会错过下一次写入
+		return WALEntry{}, false, t.walAppended(), nil
+	}
+
+	for attempt := 0; attempt < 2; attempt++ {
+		if s.file == nil {
+			if err := s.open(); err != nil {
+				return WALEntry{}, false, nil, err
+			}
+		}
+		key, value, flags, err := decodeWALRecord(s.file)
+		if err == io.EOF {
+			// 段已经读完，记录在刷新后归档的段之后的段中
+			_ = s.file.Close()
+			s.file = nil
+			continue
+		}
+		if err != nil {
+			return WALEntry{}, false, nil, fmt.Errorf("failed to read the WAL: %w", err)
+		}
+		entry := WALEntry{Seq: s.next, Key: key, Value: value, Flags: flags}
+		s.next++
+		return entry, true, nil, nil
+	}
+	return WALEntry{}, false, nil, fmt.Errorf("record %d is missing from the WAL", s.next)
+}
+
+// open 打开包含序号为 next 的记录的段，并跳过段中之前的记录，调用方必须持有读锁。
+// 打开的文件在之后归档或删除时仍然可以读取。
+func (s *WALSubscription) open() error {
+	t := s.t
+	if err := t.checkWALWindow(s.next); err != nil {
+		return err
+	}
+	segments, err := listArchivedWALs(t.walDir)
+	if err != nil {
+		return err
+	}
+	walPath, start := path.Join(t.walDir, walFileName), t.walStart
+	for i := len(segments) - 1; i >= 0 && s.next < start; i-- {
+		walPath, start = path.Join(t.walDir, archivedWALName(segments[i])), segments[i]
+	}
+
+	f, err := os.Open(walPath)
+	if err != nil {
+		return err
+	}
+	for n := start; n < s.next; n++ {
+		if _, _, _, err := decodeWALRecord(f); err != nil {
+			_ = f.Close()
+			return fmt.Errorf("failed to skip to record %d in %s: %w", s.next, walPath, err)
+		}
+	}
+	s.file = f
+	return nil
+}
+
+// Close 关闭订阅打开的文件。
+func (s *WALSubscription) Close() error {
+	if s.file == nil {
+		return nil
+	}
+	err := s.file.Close()
+	s.file = nil
+	return err
+}
+
+// walAppended 返回下一次写入 WAL 时关闭的通道，调用方必须持有读锁。
+// 多个订阅共用同一个通道；只有持有写锁的写入才会取走它，因此读锁下 Load 和 CompareAndSwap 之间不会被取走。
+func (t *LSMTree) walAppended() <-chan struct{} {
+	for {
+		if current := t.walWaiters.Load(); current != nil {
+			return *current
+		}
+		ch := make(chan struct{})
+		if t.walWaiters.CompareAndSwap(nil, &ch) {
+			return ch
+		}
+	}
+}
+
+// notifyWALAppended 唤醒等待写入的订阅，调用方必须持有写锁。
+func (t *LSMTree) notifyWALAppended() {
+	if ch := t.walWaiters.Swap(nil); ch != nil {
+		close(*ch)
+	}
+}
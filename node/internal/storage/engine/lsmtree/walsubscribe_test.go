@@ -0,0 +1,120 @@
+package lsmtree
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestWALSubscribe(t *testing.T) {
+	tree, err := Open(t.TempDir(), MemTableMaxEntries(10), ImmutableMemtableMaxNum(1), DiskTableNumThreshold(100), ArchiveWAL(100))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tree.Close()
+
+	sub, err := tree.WALSubscribe(0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sub.Close()
+
+	// 订阅先于写入开始，写入期间 WAL 每 10 条记录归档一次
+	const count = 100
+	done := make(chan error, 1)
+	go func() {
+		for i := 0; i < count; i++ {
+			key := []byte(fmt.Sprintf("key%03d", i%30))
+			var err error
+			if i%7 == 6 {
+				err = tree.Delete(key)
+			} else {
+				err = tree.PutWithFlags(key, []byte(fmt.Sprintf("value%d", i)), uint32(i))
+			}
+			if err != nil {
+				done <- err
+				return
+			}
+		}
+		done <- nil
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	for i := 0; i < count; i++ {
+		entry, err := sub.Next(ctx)
+		if err != nil {
+			t.Fatalf("entry %d: %v", i, err)
+		}
+		key := fmt.Sprintf("key%03d", i%30)
+		if entry.Seq != uint64(i) || string(entry.Key) != key {
+			t.Fatalf("expected entry %d for %s, but got %d for %s", i, key, entry.Seq, entry.Key)
+		}
+		if i%7 == 6 {
+			if entry.Value != nil {
+				t.Fatalf("expected entry %d to be a delete, but got %q", i, entry.Value)
+			}
+		} else if string(entry.Value) != fmt.Sprintf("value%d", i) || entry.Flags != uint32(i) {
+			t.Fatalf("unexpected entry %d: %q flags %d", i, entry.Value, entry.Flags)
+		}
+	}
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if segments, err := listArchivedWALs(tree.walDir); err != nil || len(segments) < 5 {
+		t.Fatalf("expected the WAL to rotate during the subscription, but got %d segments (%v)", len(segments), err)
+	}
+
+	// 没有新的写入时等待
+	short, cancelShort := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancelShort()
+	if _, err := sub.Next(short); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected %v, but got %v", context.DeadlineExceeded, err)
+	}
+
+	// 从记录的位置恢复的订阅从归档段中读到相同的记录
+	resumed, err := tree.WALSubscribe(42)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resumed.Close()
+	for i := 42; i < count; i++ {
+		entry, err := resumed.Next(ctx)
+		if err != nil || entry.Seq != uint64(i) || string(entry.Key) != fmt.Sprintf("key%03d", i%30) {
+			t.Fatalf("expected entry %d after resuming, but got %d %s (%v)", i, entry.Seq, entry.Key, err)
+		}
+	}
+	if resumed.Position() != count {
+		t.Fatalf("expected position %d, but got %d", count, resumed.Position())
+	}
+	if _, err := tree.WALSubscribe(count + 1); !errors.Is(err, ErrOutsideWALWindow) {
+		t.Fatalf("expected %v, but got %v", ErrOutsideWALWindow, err)
+	}
+
+	// DropAll 之后之前的序号不再有效
+	waiting := make(chan error, 1)
+	go func() {
+		_, err := sub.Next(ctx)
+		waiting <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	if err := tree.DropAll(); err != nil {
+		t.Fatal(err)
+	}
+	if err := <-waiting; !errors.Is(err, ErrWALDropped) {
+		t.Fatalf("expected %v, but got %v", ErrWALDropped, err)
+	}
+}
+
+func TestWALSubscribeRequiresArchive(t *testing.T) {
+	tree, err := Open(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tree.Close()
+	if _, err := tree.WALSubscribe(0); err == nil {
+		t.Fatal("expected an error without WAL archiving")
+	}
+}
@@ -0,0 +1,59 @@
+package lsmtree
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"strconv"
+	"time"
+)
+
+// WarmUp 预热数据库：从最新到最旧依次读取所有磁盘表的稀疏索引和索引文件，
+// 让它们进入操作系统的页缓存，使 Open 之后的首批查询不必等待冷盘读取。
+// 启用了稀疏索引缓存（SparseIndexCacheSize）时，最新的若干张磁盘表的稀疏索引同时被加载到缓存中，
+// 数量不超过缓存的容量，避免预热较旧的磁盘表时把较新的淘汰出去。
+// 预热是可选的，耗时受 WarmUpTimeout 限制，超时返回 ErrWarmUpTimeout，
+// 此时已预热的磁盘表仍然有效。
+func (t *LSMTree) WarmUp() error {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	deadline := time.Now().Add(t.warmUpTimeout)
+	oldest := t.maxDiskTableIndex - t.diskTableNum + 1
+	for index := t.maxDiskTableIndex; index >= oldest; index-- {
+		if time.Now().After(deadline) {
+			return ErrWarmUpTimeout
+		}
+
+		prefix := strconv.Itoa(index) + "-"
+		for _, name := range []string{diskTableSparseIndexFileName, diskTableIndexFileName} {
+			filePath := path.Join(t.dbDir, prefix+name)
+			if err := warmUpFile(filePath); err != nil {
+				return fmt.Errorf("failed to warm up %s: %w", filePath, err)
+			}
+		}
+		if t.sparseIndexes != nil && t.maxDiskTableIndex-index < t.sparseIndexes.capacity {
+			if _, err := t.sparseIndexes.get(t.dbDir, index, t.verifyChecksums); err != nil {
+				return fmt.Errorf("failed to load the sparse index of disk table %d: %w", index, err)
+			}
+		}
+	}
+
+	return nil
+}
+
+// warmUpFile 完整读取一遍文件，使其内容进入页缓存。
+func warmUpFile(filePath string) error {
+	file, err := os.OpenFile(filePath, os.O_RDONLY, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to open file: %w", err)
+	}
+	defer file.Close()
+
+	if _, err := io.Copy(io.Discard, file); err != nil {
+		return fmt.Errorf("failed to read file: %w", err)
+	}
+
+	return nil
+}
@@ -1,6 +1,7 @@
 package lsmtree
 
 import (
+	"bytes"
 	"fmt"
 	"os"
 	"path"
@@ -61,7 +62,7 @@ func TestAppendToWAL(t *testing.T) {
 	defer walFile.Close()
 
 	// 正常的写入操作
-	if err := appendToWAL(walFile, []byte("key1"), []byte("value1")); err != nil {
+	if _, err := appendToWAL(walFile, []byte("key1"), []byte("value1"), 0); err != nil {
 		t.Fatalf("追加条目失败: %v", err)
 	}
 
@@ -73,7 +74,7 @@ func TestAppendToWAL(t *testing.T) {
 	}
 
 	// 尝试在只读文件中追加条目，期望会失败
-	err = appendToWAL(walFile, []byte("key2"), []byte("value2"))
+	_, err = appendToWAL(walFile, []byte("key2"), []byte("value2"), 0)
 	if err == nil {
 		t.Fatal("预期应返回错误，但没有错误")
 	}
@@ -90,15 +91,15 @@ func TestLoadMemTable(t *testing.T) {
 	}
 	defer walFile.Close()
 
-	if err := appendToWAL(walFile, []byte("key1"), []byte("value1")); err != nil {
+	if _, err := appendToWAL(walFile, []byte("key1"), []byte("value1"), 0); err != nil {
 		t.Fatalf("追加条目失败: %v", err)
 	}
-	if err := appendToWAL(walFile, []byte("key2"), []byte("value2")); err != nil {
+	if _, err := appendToWAL(walFile, []byte("key2"), []byte("value2"), 0); err != nil {
 		t.Fatalf("追加条目失败: %v", err)
 	}
 
 	// 测试加载内存表
-	memTable, err := loadMemTable(walFile)
+	memTable, _, err := loadMemTable(walFile)
 	if err != nil {
 		t.Fatalf("加载内存表失败: %v", err)
 	}
@@ -113,7 +114,7 @@ func TestLoadMemTable(t *testing.T) {
 	}
 	defer emptyFile.Close()
 
-	memTable, err = loadMemTable(emptyFile)
+	memTable, _, err = loadMemTable(emptyFile)
 	if err != nil {
 		t.Fatalf("加载空内存表失败: %v", err)
 	}
@@ -131,15 +132,15 @@ func TestCreateAndRead(t *testing.T) {
 		t.Fatalf("创建WAL文件失败: %v", err)
 	}
 	defer walFile.Close()
-	if err := appendToWAL(walFile, []byte("key1"), []byte("value1")); err != nil {
+	if _, err := appendToWAL(walFile, []byte("key1"), []byte("value1"), 0); err != nil {
 		t.Fatalf("追加条目失败: %v", err)
 	}
-	if err := appendToWAL(walFile, []byte("key2"), []byte("value2")); err != nil {
+	if _, err := appendToWAL(walFile, []byte("key2"), []byte("value2"), 0); err != nil {
 		t.Fatalf("追加条目失败: %v", err)
 	}
 
 	// 测试加载内存表
-	memTable, err := loadMemTable(walFile)
+	memTable, _, err := loadMemTable(walFile)
 	if err != nil {
 		t.Fatalf("加载内存表失败: %v", err)
 	}
@@ -149,3 +150,106 @@ func TestCreateAndRead(t *testing.T) {
 	value, _ := memTable.get([]byte("key1"))
 	fmt.Println(string(value))
 }
+
+// 测试WAL末尾有不完整的记录时，Open截掉它并恢复之前完整的记录
+func TestOpenTruncatesIncompleteWALRecord(t *testing.T) {
+	var partial bytes.Buffer
+	if _, err := encodeWithFlags([]byte("key2"), []byte("value2"), 0, &partial); err != nil {
+		t.Fatal(err)
+	}
+	// 分别在长度前缀中间和记录主体中间截断
+	for _, cut := range []int{3, partial.Len() - 1} {
+		dbDir := t.TempDir()
+		walFile, err := os.OpenFile(path.Join(dbDir, walFileName), os.O_RDWR|os.O_CREATE, 0600)
+		if err != nil {
+			t.Fatal(err)
+		}
+		valid, err := appendToWAL(walFile, []byte("key1"), []byte("value1"), 0)
+		if err != nil {
+			t.Fatalf("追加条目失败: %v", err)
+		}
+		if _, err := walFile.Write(partial.Bytes()[:cut]); err != nil {
+			t.Fatal(err)
+		}
+		walFile.Close()
+
+		tree, err := Open(dbDir)
+		if err != nil {
+			t.Fatalf("截断 %d 字节时打开失败: %v", cut, err)
+		}
+		if value, exists, err := tree.Get([]byte("key1")); err != nil || !exists || string(value) != "value1" {
+			t.Fatalf("期望恢复 key1=value1，实际为 %q (%t, %v)", value, exists, err)
+		}
+		if _, exists, _ := tree.Get([]byte("key2")); exists {
+			t.Fatal("不完整的记录不应该被恢复")
+		}
+		if info, err := os.Stat(path.Join(dbDir, walFileName)); err != nil || info.Size() != int64(valid) {
+			t.Fatalf("期望WAL被截断到 %d 字节，实际为 %v (%v)", valid, info.Size(), err)
+		}
+
+		// 之后追加的记录在重新打开后可以读取
+		if err := tree.Put([]byte("key3"), []byte("value3")); err != nil {
+			t.Fatal(err)
+		}
+		walFile, err = os.Open(path.Join(dbDir, walFileName))
+		if err != nil {
+			t.Fatal(err)
+		}
+		memTable, _, err := readWAL(walFile)
+		walFile.Close()
+		if err != nil {
+			t.Fatalf("加载内存表失败: %v", err)
+		}
+		if value, _ := memTable.get([]byte("key3")); string(value) != "value3" {
+			t.Fatalf("期望 key3=value3，实际为 %q", value)
+		}
+		tree.Close()
+	}
+}
+
+func TestSyncBeforeCrash(t *testing.T) {
+	dbDir := t.TempDir()
+	tree, err := Open(dbDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer tree.Close()
+
+	if err := tree.Put([]byte("key1"), []byte("value1")); err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.PutPinned([]byte("hot"), []byte("pinned")); err != nil {
+		t.Fatal(err)
+	}
+	if err := tree.Sync(); err != nil {
+		t.Fatalf("同步失败: %v", err)
+	}
+
+	// 模拟崩溃：不关闭数据库，直接用此刻的文件打开副本
+	crashDir := t.TempDir()
+	for _, name := range []string{walFileName, pinnedFileName} {
+		if err := copyFile(path.Join(dbDir, name), path.Join(crashDir, name)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	recovered, err := Open(crashDir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer recovered.Close()
+	for key, expected := range map[string]string{"key1": "value1", "hot": "pinned"} {
+		if value, exists, err := recovered.Get([]byte(key)); err != nil || !exists || string(value) != expected {
+			t.Fatalf("期望 %s=%s，实际为 %q (%t, %v)", key, expected, value, exists, err)
+		}
+	}
+
+	// 只读时什么也不做
+	readOnly, err := Open(crashDir, ReadOnly())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer readOnly.Close()
+	if err := readOnly.Sync(); err != nil {
+		t.Fatalf("只读时同步失败: %v", err)
+	}
+}
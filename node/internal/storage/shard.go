@@ -0,0 +1,92 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"hash/fnv"
+	"os"
+	"path"
+	"strconv"
+	"strings"
+
+	"github.com/huahuoao/lsm-core/internal/storage/engine/lsmtree"
+)
+
+// shardCountFileName 记录多分片数据库的分片数量，位于各分片目录的上级目录中。
+const shardCountFileName = "shards"
+
+// shardCount 是 NewHbaseClient 打开的分片数量，详见 SetShardCount。
+var shardCount = 1
+
+// ShardFunc 返回键所属分片的下标，取值范围为 [0, n)。
+type ShardFunc func(key []byte, n int) int
+
+// HashShard 按键的 FNV-1a 哈希值选择分片。
+func HashShard(key []byte, n int) int {
+	f := fnv.New32a()
+	_, _ = f.Write(key)
+	return int(f.Sum32() % uint32(n))
+}
+
+// shard 返回键所属的分片树。
+func (h *Hbase) shard(key []byte) *lsmtree.LSMTree {
+	return h.shards[h.shardFunc(key, len(h.shards))]
+}
+
+// SetShardCount 设置节点存储的分片数量（默认 1），必须在第一次 OpenClient 之前调用。
+// 每个分片是一棵独立的树，有自己的目录、内存表和 WAL，不同分片上的读写不争用同一把锁，可以在多个 CPU 核上并行。
+// 分片数量在数据库创建时确定，之后不能更改，否则键会被路由到错误的分片，详见 ShardDirs。
+func SetShardCount(n int) error {
+	if n < 1 {
+		return fmt.Errorf("shard count must be positive, got %d", n)
+	}
+	shardCount = n
+	return nil
+}
+
+// ShardDirs 返回在 base 下打开 n 个分片时各分片的目录。n 为 1 时就是 base 本身，与不分片的数据库相同；
+// 大于 1 时是 base 下的 shard-0 到 shard-<n-1>，第一次打开时在 base 中记录分片数量。
+// base 中已有的数据库的分片数量与 n 不同时返回错误：HashShard 按分片数量取模，改变数量会让已有的键找不到。
+func ShardDirs(base string, n int) ([]string, error) {
+	if n < 1 {
+		return nil, fmt.Errorf("shard count must be positive, got %d", n)
+	}
+
+	countPath := path.Join(base, shardCountFileName)
+	data, err := os.ReadFile(countPath)
+	switch {
+	case err == nil:
+		recorded, err := strconv.Atoi(strings.TrimSpace(string(data)))
+		if err != nil {
+			return nil, fmt.Errorf("the file %s is corrupted: %w", countPath, err)
+		}
+		if recorded != n {
+			return nil, fmt.Errorf("the database in %s has %d shards, but %d were requested", base, recorded, n)
+		}
+	case !errors.Is(err, os.ErrNotExist):
+		return nil, fmt.Errorf("failed to read file %s: %w", countPath, err)
+	case n == 1:
+		return []string{base}, nil
+	default:
+		// 没有记录分片数量的非空目录是一个不分片的数据库
+		entries, err := os.ReadDir(base)
+		if err != nil && !errors.Is(err, os.ErrNotExist) {
+			return nil, fmt.Errorf("failed to read directory %s: %w", base, err)
+		}
+		if len(entries) > 0 {
+			return nil, fmt.Errorf("%s contains a database with 1 shard, but %d were requested", base, n)
+		}
+		if err := os.MkdirAll(base, 0700); err != nil {
+			return nil, fmt.Errorf("failed to create directory %s: %w", base, err)
+		}
+		if err := os.WriteFile(countPath, []byte(strconv.Itoa(n)), 0600); err != nil {
+			return nil, fmt.Errorf("failed to write %s: %w", countPath, err)
+		}
+	}
+
+	dirs := make([]string, n)
+	for i := range dirs {
+		dirs[i] = path.Join(base, "shard-"+strconv.Itoa(i))
+	}
+	return dirs, nil
+}
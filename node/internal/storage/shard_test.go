@@ -0,0 +1,282 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"strconv"
+	"sync/atomic"
+	"testing"
+
+	"github.com/huahuoao/lsm-core/internal/storage/engine/lsmtree"
+	"github.com/huahuoao/lsm-core/internal/testutil"
+)
+
+func TestShardDirs(t *testing.T) {
+	dirs := []string{path.Join(t.TempDir(), "disk0"), path.Join(t.TempDir(), "disk1")}
+	// 按键最后一个字节的奇偶性分片
+	parity := func(key []byte, n int) int {
+		return int(key[len(key)-1]) % n
+	}
+	h, err := NewShardedHbaseClient(dirs, parity)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	count := 100
+	for i := 0; i < count; i++ {
+		if err := h.Put(testutil.Key(i), testutil.Key(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := h.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// 分别直接打开两个目录，检查键落在预期的分片中
+	for shard, dir := range dirs {
+		tree, err := lsmtree.Open(dir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for i := 0; i < count; i++ {
+			key := testutil.Key(i)
+			_, exists, err := tree.Get(key)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if expected := parity(key, len(dirs)) == shard; exists != expected {
+				t.Errorf("键 %s 在分片 %d 中存在: %v，期望 %v", key, shard, exists, expected)
+			}
+		}
+		if err := tree.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestShardedScan(t *testing.T) {
+	dirs := []string{path.Join(t.TempDir(), "disk0"), path.Join(t.TempDir(), "disk1"), path.Join(t.TempDir(), "disk2")}
+	h, err := NewShardedHbaseClient(dirs, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+
+	for i := 0; i < 100; i++ {
+		if err := h.Put(testutil.Key(i), testutil.Key(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	kvs, err := h.Scan(testutil.Key(10), testutil.Key(90), 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(kvs) != 80 {
+		t.Fatalf("expected 80 keys, but got %d", len(kvs))
+	}
+	for i, kv := range kvs {
+		if string(kv.Key) != string(testutil.Key(10+i)) || string(kv.Value) != string(kv.Key) {
+			t.Fatalf("expected key %s at %d, but got %s", testutil.Key(10+i), i, kv.Key)
+		}
+	}
+
+	// 上限在所有分片合并后生效
+	kvs, err = h.Scan(testutil.Key(10), testutil.Key(90), 5)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(kvs) != 5 || string(kvs[4].Key) != string(testutil.Key(14)) {
+		t.Fatalf("expected the first 5 keys, but got %d", len(kvs))
+	}
+}
+
+func TestShardedScanPrefix(t *testing.T) {
+	dirs := []string{path.Join(t.TempDir(), "disk0"), path.Join(t.TempDir(), "disk1"), path.Join(t.TempDir(), "disk2")}
+	h, err := NewShardedHbaseClient(dirs, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+
+	for i := 0; i < 50; i++ {
+		for _, prefix := range []string{"user:", "order:"} {
+			key := []byte(fmt.Sprintf("%s%03d", prefix, i))
+			if err := h.Put(key, key); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+
+	kvs, err := h.ScanPrefix([]byte("user:"), 20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(kvs) != 20 {
+		t.Fatalf("expected 20 keys, but got %d", len(kvs))
+	}
+	for i, kv := range kvs {
+		if want := fmt.Sprintf("user:%03d", i); string(kv.Key) != want {
+			t.Fatalf("expected key %s at %d, but got %s", want, i, kv.Key)
+		}
+	}
+}
+
+func TestShardedGetAll(t *testing.T) {
+	dirs := []string{path.Join(t.TempDir(), "disk0"), path.Join(t.TempDir(), "disk1"), path.Join(t.TempDir(), "disk2")}
+	h, err := NewShardedHbaseClient(dirs, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+
+	size := 0
+	for i := 0; i < 100; i++ {
+		if err := h.Put(testutil.Key(i), testutil.Key(i)); err != nil {
+			t.Fatal(err)
+		}
+		size += 2 * len(testutil.Key(i))
+	}
+
+	kvs, err := h.GetAll(nil, size)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(kvs) != 100 {
+		t.Fatalf("expected 100 keys, but got %d", len(kvs))
+	}
+	for i, kv := range kvs {
+		if string(kv.Key) != string(testutil.Key(i)) || string(kv.Value) != string(kv.Key) {
+			t.Fatalf("expected key %s at %d, but got %s", testutil.Key(i), i, kv.Key)
+		}
+	}
+
+	if _, err := h.GetAll(nil, size-1); !errors.Is(err, ErrTooLarge) {
+		t.Fatalf("expected %v, but got %v", ErrTooLarge, err)
+	}
+}
+
+func TestShardedPutBatch(t *testing.T) {
+	dirs := []string{path.Join(t.TempDir(), "disk0"), path.Join(t.TempDir(), "disk1")}
+	parity := func(key []byte, n int) int {
+		return int(key[len(key)-1]) % n
+	}
+	h, err := NewShardedHbaseClient(dirs, parity)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+
+	keys := [][]byte{[]byte("a"), []byte("b"), []byte("c"), []byte("d")}
+	values := [][]byte{[]byte("1"), []byte("2"), nil, []byte("4")}
+	errs, err := h.PutBatch(keys, values)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// 错误按原来的顺序返回
+	for i, err := range errs {
+		if expected := i == 2; (err != nil) != expected {
+			t.Fatalf("键 %s 的错误: %v", keys[i], err)
+		}
+	}
+	if !errors.Is(errs[2], lsmtree.ErrValueRequired) {
+		t.Fatalf("期望 ErrValueRequired，实际为 %v", errs[2])
+	}
+	for i, key := range keys {
+		value, exists, err := h.Get(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if exists != (values[i] != nil) || string(value) != string(values[i]) {
+			t.Errorf("键 %s: %q (%v)", key, value, exists)
+		}
+	}
+}
+
+func TestShardDirsCount(t *testing.T) {
+	base := path.Join(t.TempDir(), "db")
+	dirs, err := ShardDirs(base, 4)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(dirs) != 4 || dirs[3] != path.Join(base, "shard-3") {
+		t.Fatalf("unexpected shard directories %v", dirs)
+	}
+	h, err := NewShardedHbaseClient(dirs, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100; i++ {
+		if err := h.Put(testutil.Key(i), testutil.Key(i)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := h.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	// 分片数量不能改变
+	for _, n := range []int{1, 2} {
+		if _, err := ShardDirs(base, n); err == nil {
+			t.Fatalf("expected opening 4 shards as %d to fail", n)
+		}
+	}
+	if dirs, err = ShardDirs(base, 4); err != nil {
+		t.Fatal(err)
+	}
+	h, err = NewShardedHbaseClient(dirs, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+	for i := 0; i < 100; i++ {
+		if value, exists, err := h.Get(testutil.Key(i)); err != nil || !exists || string(value) != string(testutil.Key(i)) {
+			t.Fatalf("unexpected value for %s: %q (%v, %v)", testutil.Key(i), value, exists, err)
+		}
+	}
+
+	// 不分片的数据库就在 base 中，不能按多个分片打开
+	single := t.TempDir()
+	if dirs, err := ShardDirs(single, 1); err != nil || len(dirs) != 1 || dirs[0] != single {
+		t.Fatalf("expected the base directory for 1 shard, but got %v (%v)", dirs, err)
+	}
+	if err := os.WriteFile(path.Join(single, "wal"), []byte("x"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ShardDirs(single, 2); err == nil {
+		t.Fatal("expected opening a single-shard database with 2 shards to fail")
+	}
+}
+
+// BenchmarkShardedPut 比较不同分片数量下并发写入的吞吐量：每个分片有自己的写锁和 WAL，
+// 写入分散到多个分片后可以并行进行。可以用 go test -race -bench ShardedPut 在竞争检测下运行。
+func BenchmarkShardedPut(b *testing.B) {
+	for _, n := range []int{1, 2, 4, 8} {
+		b.Run(strconv.Itoa(n)+"-shards", func(b *testing.B) {
+			dirs, err := ShardDirs(path.Join(b.TempDir(), "db"), n)
+			if err != nil {
+				b.Fatal(err)
+			}
+			h, err := NewShardedHbaseClient(dirs, nil)
+			if err != nil {
+				b.Fatal(err)
+			}
+			defer h.Close()
+
+			value := make([]byte, 100)
+			var next atomic.Int64
+			b.SetParallelism(8)
+			b.ResetTimer()
+			b.RunParallel(func(pb *testing.PB) {
+				for pb.Next() {
+					if err := h.Put(testutil.Key(int(next.Add(1))), value); err != nil {
+						b.Error(err)
+						return
+					}
+				}
+			})
+		})
+	}
+}
@@ -0,0 +1,94 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/bytedance/sonic"
+)
+
+// snapshotManifestFileName 是快照目录中描述快照的清单文件名。
+const snapshotManifestFileName = "MANIFEST.json"
+
+// snapshotRoot 是节点存放快照的目录，为空时节点不接受快照命令，详见 SetSnapshotRoot。
+var snapshotRoot = ""
+
+// SetSnapshotRoot 设置节点存放快照的目录（默认为空，不接受快照命令），必须在节点开始处理请求之前调用。
+// 快照命令只能在这个目录下创建以快照名命名的子目录，详见 SnapshotDir。
+func SetSnapshotRoot(dir string) {
+	snapshotRoot = dir
+}
+
+// SnapshotDir 返回快照根目录下名为 name 的快照目录。name 来自客户端，必须是单个目录名：
+// 不能为空、不能是 "." 或 ".."、不能是绝对路径，也不能包含路径分隔符，否则客户端可以让节点写入任意位置。
+// 没有设置快照根目录时返回错误。
+func SnapshotDir(name string) (string, error) {
+	if snapshotRoot == "" {
+		return "", errors.New("snapshots are disabled on this node")
+	}
+	if name == "" || name == "." || name == ".." || filepath.IsAbs(name) || strings.ContainsAny(name, `/\`) {
+		return "", fmt.Errorf("invalid snapshot name %q", name)
+	}
+	return path.Join(snapshotRoot, name), nil
+}
+
+// SnapshotManifest 描述节点的一次快照，以 JSON 写入快照目录并返回给协调快照的客户端。
+type SnapshotManifest struct {
+	// 协调者为一次集群快照指定的 ID，集群中所有节点相同
+	ID string
+	// 快照开始和完成的时间
+	Started  time.Time
+	Finished time.Time
+	// 每个分片的快照，下标与分片一一对应
+	Shards []ShardSnapshot
+}
+
+// ShardSnapshot 描述一个分片的快照。
+type ShardSnapshot struct {
+	// 快照目录中分片备份的子目录，可以用 lsmtree.Open 直接打开
+	Dir string
+	// 快照时分片的纪元，详见 lsmtree.LSMTree.Epoch
+	Epoch string
+}
+
+// Snapshot 把每个分片备份到 dir 下以分片下标命名的子目录，并在 dir 中写入清单，返回清单。
+// 每个分片的备份在分片的写锁下完成，是该分片某一时刻的一致状态；
+// 多个分片依次备份，分片之间不是同一时刻的状态。dir 已经包含清单时返回错误，不覆盖之前的快照。
+func (h *Hbase) Snapshot(id, dir string) (*SnapshotManifest, error) {
+	if err := h.checkOpen(); err != nil {
+		return nil, err
+	}
+	if id == "" || dir == "" {
+		return nil, errors.New("snapshot id and directory are required")
+	}
+	manifestPath := path.Join(dir, snapshotManifestFileName)
+	if _, err := os.Stat(manifestPath); err == nil {
+		return nil, fmt.Errorf("snapshot %s already exists", dir)
+	}
+
+	manifest := &SnapshotManifest{ID: id, Started: time.Now()}
+	for i, shard := range h.shards {
+		shardDir := strconv.Itoa(i)
+		if err := shard.Backup(path.Join(dir, shardDir)); err != nil {
+			return nil, fmt.Errorf("failed to back up shard %d: %w", i, err)
+		}
+		manifest.Shards = append(manifest.Shards, ShardSnapshot{Dir: shardDir, Epoch: shard.Epoch()})
+	}
+	manifest.Finished = time.Now()
+
+	// 清单最后写入，没有清单的快照目录是没有完成的快照
+	data, err := sonic.Marshal(manifest)
+	if err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(manifestPath, data, 0600); err != nil {
+		return nil, fmt.Errorf("failed to write the snapshot manifest: %w", err)
+	}
+	return manifest, nil
+}
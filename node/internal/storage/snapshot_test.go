@@ -0,0 +1,70 @@
+package storage
+
+import (
+	"path"
+	"testing"
+
+	"github.com/huahuoao/lsm-core/internal/testutil"
+)
+
+func TestSnapshot(t *testing.T) {
+	dirs := []string{t.TempDir(), t.TempDir()}
+	h, err := NewShardedHbaseClient(dirs, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+	for i := 0; i < 100; i++ {
+		if err := h.Put(testutil.Key(i), []byte("value")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	dir := t.TempDir()
+	manifest, err := h.Snapshot("snap-1", dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if manifest.ID != "snap-1" || len(manifest.Shards) != len(dirs) {
+		t.Fatalf("unexpected manifest %+v", manifest)
+	}
+	if _, err := h.Snapshot("snap-1", dir); err == nil {
+		t.Fatal("expected an existing snapshot not to be overwritten")
+	}
+
+	// 每个分片的备份可以作为分片重新打开，合起来包含全部的键
+	var backups []string
+	for _, shard := range manifest.Shards {
+		backups = append(backups, path.Join(dir, shard.Dir))
+	}
+	restored, err := NewShardedHbaseClient(backups, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer restored.Close()
+	for i := 0; i < 100; i++ {
+		if _, exists, err := restored.Get(testutil.Key(i)); err != nil || !exists {
+			t.Fatalf("expected key %d in the snapshot, but got %t, %v", i, exists, err)
+		}
+	}
+}
+
+func TestSnapshotDir(t *testing.T) {
+	defer SetSnapshotRoot("")
+
+	if _, err := SnapshotDir("snap-1"); err == nil {
+		t.Fatal("expected snapshots to be disabled without a snapshot root")
+	}
+
+	SetSnapshotRoot("/backups")
+	dir, err := SnapshotDir("snap-1")
+	if err != nil || dir != "/backups/snap-1" {
+		t.Fatalf("expected /backups/snap-1, but got %s (%v)", dir, err)
+	}
+	// 只接受单个目录名，不能借此写到快照根目录之外
+	for _, name := range []string{"", ".", "..", "/tmp/snap", "../snap", "a/b", `a\b`} {
+		if dir, err := SnapshotDir(name); err == nil {
+			t.Fatalf("expected %q to be rejected, but got %s", name, dir)
+		}
+	}
+}
@@ -1,70 +1,479 @@
 package storage
 
 import (
+	"bytes"
+	"errors"
+	"fmt"
 	"github.com/huahuoao/lsm-core/internal/storage/engine/lsmtree"
 	"os"
+	"sort"
+	"sync"
 )
 
-var h *Hbase
+var (
+	// 节点的存储，由 OpenClient 打开
+	h     *Hbase
+	hOnce sync.Once
+)
+
+// ErrNotOpen 在存储没有成功打开或已经关闭时返回。
+var ErrNotOpen = errors.New("storage is not open")
+
+// ErrTooLarge 在 GetAll 的数据量超过上限时返回。
+var ErrTooLarge = errors.New("dataset is too large")
+
+// MaxValueSize 是节点接受的最大值大小，大于引擎默认的 lsmtree.MaxValueSize。
+// 单条消息的大小有上限，更大的值通过流式命令分块写入和读取
+const MaxValueSize = 1 << 30
 
 type Hbase struct {
-	tree *lsmtree.LSMTree
+	// 每个分片树的目录，可以分布在不同的磁盘上
+	dirs []string
+	// 决定键属于哪个分片
+	shardFunc ShardFunc
+	shards    []*lsmtree.LSMTree
+	// 打开失败的原因，之后的每个请求都返回它
+	openErr error
 }
 
+// GetClient 返回节点的存储，第一次调用时打开它。
+// 打开失败时返回的存储拒绝所有请求并返回打开失败的原因，不会在之后的请求中重试打开。
+// 可以被多个连接并发调用，存储只打开一次。
 func GetClient() *Hbase {
-	if h == nil {
-		InitClient()
-	}
-	return h
+	client, _ := OpenClient()
+	return client
 }
-func InitClient() {
-	h, _ = NewHbaseClient()
+
+// InitClient 打开节点的存储并返回打开失败的原因，已经打开过时返回第一次打开的结果。
+func InitClient() error {
+	_, err := OpenClient()
+	return err
 }
+
+// OpenClient 返回节点的存储和打开失败的原因，只有第一次调用真正打开存储。
+// 并发的第一次调用等待同一次打开完成，不会在同一个目录上打开多个 LSMTree。
+func OpenClient() (*Hbase, error) {
+	hOnce.Do(func() {
+		client, err := NewHbaseClient()
+		if err != nil {
+			client = &Hbase{openErr: err}
+		}
+		h = client
+	})
+	return h, h.openErr
+}
+
+// NewHbaseClient 在默认的数据库目录下打开 SetShardCount 设置数量的分片树，键按 HashShard 分配到分片。
 func NewHbaseClient() (*Hbase, error) {
-	h := &Hbase{}
-	err := h.initTree()
+	dirs, err := ShardDirs(lsmtree.GetDatabaseSourcePath(), shardCount)
 	if err != nil {
 		return nil, err
 	}
-	return h, err
+	return NewShardedHbaseClient(dirs, nil)
+}
+
+// NewShardedHbaseClient 在 dirs 的每个目录上各打开一个分片树，
+// 把目录放在不同的物理磁盘上可以让操作系统把 I/O 分散到多个设备。
+// shardFunc 决定键所属的分片，为 nil 时使用 HashShard。
+// 返回之前检查存储可以对外服务（见 Ready），任何分片打开失败或不可写入时返回具体的错误。
+func NewShardedHbaseClient(dirs []string, shardFunc ShardFunc) (*Hbase, error) {
+	if len(dirs) == 0 {
+		return nil, errors.New("at least one shard directory is required")
+	}
+	if shardFunc == nil {
+		shardFunc = HashShard
+	}
+	h := &Hbase{dirs: dirs, shardFunc: shardFunc}
+	if err := h.initTree(); err != nil {
+		return nil, err
+	}
+	if err := h.Ready(); err != nil {
+		_ = h.Close()
+		return nil, err
+	}
+	return h, nil
 }
 
 func (h *Hbase) initTree() error {
-	walPath := lsmtree.GetDatabaseSourcePath()
-	_ = os.MkdirAll(walPath, 0700)
-	tree, err := lsmtree.Open(walPath)
-	if err != nil {
-		return err
+	shards := make([]*lsmtree.LSMTree, 0, len(h.dirs))
+	for _, dir := range h.dirs {
+		tree, err := lsmtree.Open(dir, lsmtree.ValueSizeLimit(MaxValueSize))
+		if err != nil {
+			for _, shard := range shards {
+				_ = shard.Close()
+			}
+			return fmt.Errorf("failed to open shard %s: %w", dir, err)
+		}
+		shards = append(shards, tree)
 	}
-	h.tree = tree
+	h.shards = shards
 	return nil
 }
 
-func (h *Hbase) Get(key []byte) ([]byte, bool) {
-	if h.tree == nil {
-		err := h.initTree()
+// checkOpen 在存储没有成功打开或已经关闭时返回 ErrNotOpen，打开失败时带上失败的原因。
+func (h *Hbase) checkOpen() error {
+	if h.shards != nil {
+		return nil
+	}
+	if h.openErr != nil {
+		return fmt.Errorf("%w: %v", ErrNotOpen, h.openErr)
+	}
+	return ErrNotOpen
+}
+
+// Get 返回键当前的值，键不存在时返回 false。
+func (h *Hbase) Get(key []byte) ([]byte, bool, error) {
+	if err := h.checkOpen(); err != nil {
+		return nil, false, err
+	}
+	return h.shard(key).Get(key)
+}
+
+// Put 写入键值对。
+func (h *Hbase) Put(key []byte, value []byte) error {
+	if err := h.checkOpen(); err != nil {
+		return err
+	}
+	return h.shard(key).Put(key, value)
+}
+
+// Delete 删除键，键不存在时同样返回成功。
+func (h *Hbase) Delete(key []byte) error {
+	if err := h.checkOpen(); err != nil {
+		return err
+	}
+	return h.shard(key).Delete(key)
+}
+
+// GetWithFlags 返回键当前的值和键的标志，详见 lsmtree.LSMTree.GetWithFlags。
+func (h *Hbase) GetWithFlags(key []byte) ([]byte, uint32, bool, error) {
+	if err := h.checkOpen(); err != nil {
+		return nil, 0, false, err
+	}
+	return h.shard(key).GetWithFlags(key)
+}
+
+// PutWithFlags 写入键值对和键的标志，详见 lsmtree.LSMTree.PutWithFlags。
+func (h *Hbase) PutWithFlags(key []byte, value []byte, flags uint32) error {
+	if err := h.checkOpen(); err != nil {
+		return err
+	}
+	return h.shard(key).PutWithFlags(key, value, flags)
+}
+
+// PutBatch 写入多个键值对，返回每个键值对的错误，详见 lsmtree.LSMTree.PutBatch。
+// 键值对按分片分组，每个分片的一组原子地写入；只有一个分片时整个批量是原子的，
+// 多个分片时各分片依次写入，崩溃后可能只有部分分片的写入存在。
+func (h *Hbase) PutBatch(keys, values [][]byte) ([]error, error) {
+	return h.batch(keys, values, (*lsmtree.LSMTree).PutBatch)
+}
+
+// ApplyBatch 写入或删除（值为 nil 时）多个键，详见 lsmtree.LSMTree.ApplyBatch。
+// 与 PutBatch 一样只在每个分片内原子：有不合法的写入的分片整个被拒绝，错误记录在对应的位置，
+// 但其他分片可能已经写入，第二个返回值包装了 lsmtree.ErrBatchRejected。
+func (h *Hbase) ApplyBatch(keys, values [][]byte) ([]error, error) {
+	return h.batch(keys, values, (*lsmtree.LSMTree).ApplyBatch)
+}
+
+// batch 把批量按分片分组，对每个分片的一组调用 apply。某个分片失败时立即返回，
+// apply 返回了每个键值对的错误时一并填入结果。
+func (h *Hbase) batch(keys, values [][]byte, apply func(*lsmtree.LSMTree, [][]byte, [][]byte) ([]error, error)) ([]error, error) {
+	if err := h.checkOpen(); err != nil {
+		return nil, err
+	}
+	if len(keys) != len(values) {
+		return nil, fmt.Errorf("%d keys but %d values", len(keys), len(values))
+	}
+
+	// 每个分片中键值对在批量中的下标
+	groups := make(map[int][]int)
+	for i, key := range keys {
+		shard := h.shardFunc(key, len(h.shards))
+		groups[shard] = append(groups[shard], i)
+	}
+
+	errs := make([]error, len(keys))
+	for shard, indexes := range groups {
+		shardKeys := make([][]byte, len(indexes))
+		shardValues := make([][]byte, len(indexes))
+		for j, i := range indexes {
+			shardKeys[j], shardValues[j] = keys[i], values[i]
+		}
+		shardErrs, err := apply(h.shards[shard], shardKeys, shardValues)
+		if shardErrs == nil && err != nil {
+			return nil, fmt.Errorf("shard %d: %w", shard, err)
+		}
+		for j, i := range indexes {
+			errs[i] = shardErrs[j]
+		}
 		if err != nil {
-			return nil, false
+			return errs, fmt.Errorf("shard %d: %w", shard, err)
 		}
 	}
+	return errs, nil
+}
 
-	value, exists, err := h.tree.Get(key)
-	if err != nil {
-		return nil, false
+// Append 把 suffix 追加到键当前的值之后，返回追加后的完整值。
+func (h *Hbase) Append(key []byte, suffix []byte) ([]byte, error) {
+	if err := h.checkOpen(); err != nil {
+		return nil, err
 	}
-	return value, exists
+	return h.shard(key).Append(key, suffix)
 }
 
-func (h *Hbase) Put(key []byte, value []byte) error {
-	if h.tree == nil {
-		err := h.initTree()
+// GetOrSet 在键存在时返回它当前的值，否则写入 value，详见 lsmtree.LSMTree.GetOrSet。
+func (h *Hbase) GetOrSet(key []byte, value []byte) ([]byte, bool, error) {
+	if err := h.checkOpen(); err != nil {
+		return nil, false, err
+	}
+	return h.shard(key).GetOrSet(key, value)
+}
+
+// OpenValue 返回流式读取键当前值的读取器，详见 lsmtree.LSMTree.OpenValue。
+func (h *Hbase) OpenValue(key []byte) (*lsmtree.ValueReader, bool, error) {
+	if err := h.checkOpen(); err != nil {
+		return nil, false, err
+	}
+	return h.shard(key).OpenValue(key)
+}
+
+// KV 是一个键值对。
+type KV struct {
+	Key   []byte
+	Value []byte
+}
+
+// Scan 返回所有分片中 [start, end) 范围内按键升序排列的键值对，边界语义与 lsmtree.LSMTree.Scan 相同，
+// 最多 limit 个（limit <= 0 表示不限制）。
+func (h *Hbase) Scan(start, end []byte, limit int) ([]KV, error) {
+	if err := h.checkOpen(); err != nil {
+		return nil, err
+	}
+
+	var kvs []KV
+	for _, shard := range h.shards {
+		it, err := shard.Scan(start, end)
+		if err != nil {
+			return nil, err
+		}
+		// 每个分片最多贡献 limit 个，合并后再截断
+		it.Limit(limit)
+		for it.Next() {
+			kvs = append(kvs, KV{Key: it.Key(), Value: it.Value()})
+		}
+		err = it.Err()
+		_ = it.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// 各分片的键互不相交，合并后只需排序
+	if len(h.shards) > 1 {
+		sort.Slice(kvs, func(i, j int) bool {
+			return bytes.Compare(kvs[i].Key, kvs[j].Key) < 0
+		})
+	}
+	if limit > 0 && len(kvs) > limit {
+		kvs = kvs[:limit]
+	}
+	return kvs, nil
+}
+
+// GetAll 返回所有分片中以 prefix 开头的全部键值对（prefix 为空时返回全部），按键升序排列，只适用于小数据集。
+// 键和值的总字节数超过 maxBytes 时停止遍历并返回 ErrTooLarge，不会把整个数据集读入内存。
+func (h *Hbase) GetAll(prefix []byte, maxBytes int) ([]KV, error) {
+	if err := h.checkOpen(); err != nil {
+		return nil, err
+	}
+
+	var kvs []KV
+	size := 0
+	for _, shard := range h.shards {
+		it, err := shard.ScanPrefix(prefix)
 		if err != nil {
-			return nil
+			return nil, err
+		}
+		for it.Next() {
+			size += len(it.Key()) + len(it.Value())
+			if size > maxBytes {
+				_ = it.Close()
+				return nil, fmt.Errorf("%w: more than %d bytes", ErrTooLarge, maxBytes)
+			}
+			kvs = append(kvs, KV{Key: it.Key(), Value: it.Value()})
+		}
+		err = it.Err()
+		_ = it.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if len(h.shards) > 1 {
+		sort.Slice(kvs, func(i, j int) bool {
+			return bytes.Compare(kvs[i].Key, kvs[j].Key) < 0
+		})
+	}
+	return kvs, nil
+}
+
+// ScanPrefix 返回所有分片中以 prefix 开头的键值对，按键升序排列，最多 limit 个（limit <= 0 表示不限制）。
+func (h *Hbase) ScanPrefix(prefix []byte, limit int) ([]KV, error) {
+	if err := h.checkOpen(); err != nil {
+		return nil, err
+	}
+
+	var kvs []KV
+	for _, shard := range h.shards {
+		it, err := shard.ScanPrefix(prefix)
+		if err != nil {
+			return nil, err
+		}
+		// 每个分片最多贡献 limit 个，合并后再截断
+		it.Limit(limit)
+		for it.Next() {
+			kvs = append(kvs, KV{Key: it.Key(), Value: it.Value()})
+		}
+		err = it.Err()
+		_ = it.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if len(h.shards) > 1 {
+		sort.Slice(kvs, func(i, j int) bool {
+			return bytes.Compare(kvs[i].Key, kvs[j].Key) < 0
+		})
+	}
+	if limit > 0 && len(kvs) > limit {
+		kvs = kvs[:limit]
+	}
+	return kvs, nil
+}
+
+// WarmUp 预热所有分片树，详见 lsmtree.LSMTree.WarmUp。
+func (h *Hbase) WarmUp() error {
+	if err := h.checkOpen(); err != nil {
+		return err
+	}
+	for _, shard := range h.shards {
+		if err := shard.WarmUp(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// PlanCompaction 返回每个分片下一次合并的计划，下标与分片一一对应，详见 lsmtree.LSMTree.PlanCompaction。
+func (h *Hbase) PlanCompaction() ([][]lsmtree.CompactionPlan, error) {
+	if err := h.checkOpen(); err != nil {
+		return nil, err
+	}
+	plans := make([][]lsmtree.CompactionPlan, len(h.shards))
+	for i, shard := range h.shards {
+		plans[i] = shard.PlanCompaction()
+	}
+	return plans, nil
+}
+
+// MajorCompact 依次对每个分片做一次完全合并，详见 lsmtree.LSMTree.MajorCompact。
+func (h *Hbase) MajorCompact() error {
+	if err := h.checkOpen(); err != nil {
+		return err
+	}
+	for i, shard := range h.shards {
+		if err := shard.MajorCompact(); err != nil {
+			return fmt.Errorf("shard %d: %w", i, err)
 		}
 	}
-	err := h.tree.Put(key, value)
+	return nil
+}
+
+// Sync 把每个分片的 WAL 同步到磁盘，详见 lsmtree.LSMTree.Sync。
+func (h *Hbase) Sync() error {
+	if err := h.checkOpen(); err != nil {
+		return err
+	}
+	for i, shard := range h.shards {
+		if err := shard.Sync(); err != nil {
+			return fmt.Errorf("shard %d: %w", i, err)
+		}
+	}
+	return nil
+}
+
+// BackgroundOps 返回每个分片正在进行的后台操作，下标与分片一一对应，详见 lsmtree.LSMTree.BackgroundOps。
+func (h *Hbase) BackgroundOps() ([][]lsmtree.OpStatus, error) {
+	if err := h.checkOpen(); err != nil {
+		return nil, err
+	}
+	ops := make([][]lsmtree.OpStatus, len(h.shards))
+	for i, shard := range h.shards {
+		ops[i] = shard.BackgroundOps()
+	}
+	return ops, nil
+}
+
+// CancelOp 取消分片 shard 上编号为 id 的后台操作，详见 lsmtree.LSMTree.CancelOp。
+func (h *Hbase) CancelOp(shard int, id uint64) error {
+	if err := h.checkOpen(); err != nil {
+		return err
+	}
+	if shard < 0 || shard >= len(h.shards) {
+		return fmt.Errorf("shard %d out of range [0, %d)", shard, len(h.shards))
+	}
+	return h.shards[shard].CancelOp(id)
+}
+
+// Epochs 返回每个分片的纪元，下标与分片一一对应，详见 lsmtree.LSMTree.Epoch。
+func (h *Hbase) Epochs() ([]string, error) {
+	if err := h.checkOpen(); err != nil {
+		return nil, err
+	}
+	epochs := make([]string, len(h.shards))
+	for i, shard := range h.shards {
+		epochs[i] = shard.Epoch()
+	}
+	return epochs, nil
+}
+
+// Ready 检查存储是否可以对外服务：所有分片树都已打开（WAL 已重放），
+// 并且每个分片目录都可以写入和同步（例如磁盘未满）。
+func (h *Hbase) Ready() error {
+	if err := h.checkOpen(); err != nil {
+		return err
+	}
+	for _, dir := range h.dirs {
+		if err := checkWritable(dir); err != nil {
+			return fmt.Errorf("shard directory %s is not writable: %w", dir, err)
+		}
+	}
+	return nil
+}
+
+// checkWritable 在目录中创建、写入并同步一个临时文件，然后删除它。
+func checkWritable(dir string) error {
+	f, err := os.CreateTemp(dir, ".ready-*")
 	if err != nil {
 		return err
 	}
+	defer os.Remove(f.Name())
+	defer f.Close()
+
+	if _, err := f.Write([]byte{0}); err != nil {
+		return err
+	}
+	return f.Sync()
+}
+
+// Close 关闭所有分片树。
+func (h *Hbase) Close() error {
+	for _, shard := range h.shards {
+		if err := shard.Close(); err != nil {
+			return err
+		}
+	}
+	h.shards = nil
 	return nil
 }
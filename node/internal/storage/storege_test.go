@@ -2,21 +2,16 @@ package storage
 
 import (
 	"bytes"
-	"math/rand"
+	"errors"
+	"os"
+	"path"
+	"sync"
 	"testing"
 	"time"
-)
 
-const charset = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+	"github.com/huahuoao/lsm-core/internal/testutil"
+)
 
-// RandStringBytesGenerate 生成指定长度的随机字符串
-func RandStringBytesGenerate(n int) string {
-	var result string
-	for i := 0; i < n; i++ {
-		result += string(charset[rand.Intn(len(charset))])
-	}
-	return result
-}
 func TestStorage(t *testing.T) {
 	count := 1000
 	keys := make([][]byte, count)
@@ -25,25 +20,106 @@ func TestStorage(t *testing.T) {
 	if err != nil {
 		t.Fatal(err)
 	}
+	r := testutil.NewRand(1)
 	start := time.Now() // 记录开始时间
 	for i := 0; i < count; i++ {
-		v := RandStringBytesGenerate(1024)
-		h.Put([]byte("key"+string(rune(i))), []byte(v))
-		keys[i] = []byte("key" + string(rune(i)))
-		values[i] = []byte(v)
+		keys[i] = testutil.Key(i)
+		values[i] = testutil.RandBytes(r, 1024)
+		if err := h.Put(keys[i], values[i]); err != nil {
+			t.Fatal(err)
+		}
 	}
 	elapsed := time.Since(start) // 计算执行时间
 	t.Logf("存储 %d 个键值对耗时: %s", count, elapsed)
 	// 验证存储的键值对
 	for i := 0; i < count; i++ {
-		val, exist := h.Get(keys[i])
+		val, exist, err := h.Get(keys[i])
+		if err != nil {
+			t.Fatal(err)
+		}
 		if !exist {
-			t.Errorf("获取键值对时出错: %v", err)
+			t.Errorf("键 %s 不存在", keys[i])
 			continue
 		}
 		if !bytes.Equal(val, values[i]) {
 			t.Errorf("键 %v 对应的值不匹配，期望 %v，实际 %v", keys[i], values[i], val)
 		}
 	}
-	h.tree.PrintStatus()
+	for _, shard := range h.shards {
+		shard.PrintStatus()
+	}
+}
+
+// resetClient 丢弃 OpenClient 打开的存储，之后的调用重新打开
+func resetClient() {
+	if h != nil {
+		_ = h.Close()
+	}
+	h, hOnce = nil, sync.Once{}
+}
+
+func TestGetClientConcurrent(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	resetClient()
+	defer resetClient()
+
+	// 并发的第一次调用得到同一个存储，目录只被打开一次
+	clients := make([]*Hbase, 32)
+	var wg sync.WaitGroup
+	for i := range clients {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			clients[i] = GetClient()
+		}(i)
+	}
+	wg.Wait()
+	for _, client := range clients {
+		if client != clients[0] {
+			t.Fatal("expected a single storage instance")
+		}
+	}
+	if err := clients[0].Put([]byte("key"), []byte("value")); err != nil {
+		t.Fatal(err)
+	}
+	if client, err := OpenClient(); err != nil || client != clients[0] {
+		t.Fatalf("expected the same storage from OpenClient, but got %v", err)
+	}
+}
+
+func TestStorageFailedOpen(t *testing.T) {
+	// 分片目录是一个文件，打开失败
+	file := path.Join(t.TempDir(), "file")
+	if err := os.WriteFile(file, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewShardedHbaseClient([]string{t.TempDir(), file}, nil); err == nil {
+		t.Fatal("expected open to fail")
+	}
+
+	// 默认存储打开失败后，写入返回打开失败的原因，不会假装成功
+	t.Setenv("HOME", file)
+	defer resetClient()
+	if err := InitClient(); err == nil {
+		t.Fatal("expected open to fail")
+	}
+	client := GetClient()
+	if err := client.Put([]byte("key"), []byte("value")); !errors.Is(err, ErrNotOpen) {
+		t.Fatalf("expected %v, but got %v", ErrNotOpen, err)
+	}
+	if _, _, err := client.Get([]byte("key")); !errors.Is(err, ErrNotOpen) {
+		t.Fatalf("expected %v, but got %v", ErrNotOpen, err)
+	}
+
+	// 关闭后的存储同样拒绝写入，不会重新打开
+	closed, err := NewShardedHbaseClient([]string{t.TempDir()}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := closed.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := closed.Put([]byte("key"), []byte("value")); !errors.Is(err, ErrNotOpen) {
+		t.Fatalf("expected %v, but got %v", ErrNotOpen, err)
+	}
 }
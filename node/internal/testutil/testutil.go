@@ -0,0 +1,28 @@
+// Package testutil 提供测试和基准测试共用的数据生成工具。
+package testutil
+
+import (
+	"fmt"
+	"math/rand"
+)
+
+const charset = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+
+// Key 返回第 i 个测试键。不同的 i 生成不同的键，且键的字典序与 i 的数值顺序一致。
+func Key(i int) []byte {
+	return []byte(fmt.Sprintf("key%010d", i))
+}
+
+// NewRand 返回使用固定种子的随机数生成器，保证每次运行生成相同的数据。
+func NewRand(seed int64) *rand.Rand {
+	return rand.New(rand.NewSource(seed))
+}
+
+// RandBytes 使用 r 生成长度为 n 的随机字母数字字节切片。
+func RandBytes(r *rand.Rand, n int) []byte {
+	b := make([]byte, n)
+	for i := range b {
+		b[i] = charset[r.Intn(len(charset))]
+	}
+	return b
+}
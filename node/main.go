@@ -1,42 +1,176 @@
 package main
 
 import (
+	"context"
+	"flag"
+	"fmt"
 	"github.com/huahuoao/lsm-core/internal/etcd"
 	"github.com/huahuoao/lsm-core/internal/protocol"
 	"github.com/huahuoao/lsm-core/internal/storage"
 	"github.com/panjf2000/gnet/v2"
 	"github.com/panjf2000/gnet/v2/pkg/logging"
 	"log"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
 	"time"
 )
 
 var Hbase *storage.Hbase
 
-func NewTCPPool() {
-	ss := protocol.NewBluebellServer("tcp", "0.0.0.0:9000", true)
-	options := []gnet.Option{
-		gnet.WithMulticore(true),               // 启用多核模式
-		gnet.WithReusePort(true),               // 启用端口重用
-		gnet.WithTCPKeepAlive(time.Minute * 5), // 启用 TCP keep-alive
-		gnet.WithReadBufferCap(2048 * 1024),
-		gnet.WithWriteBufferCap(2048 * 1024),
-	}
+var warmUp = flag.Bool("warmup", false, "warm up disk table indexes before taking full traffic, registering in etcd with a reduced weight meanwhile")
+
+var (
+	numEventLoop   = flag.Int("event-loops", 0, "number of gnet event loops, 0 means one per CPU core")
+	readBufferCap  = flag.Int("read-buffer", 2*protocol.MB, "read buffer size of each connection in bytes")
+	writeBufferCap = flag.Int("write-buffer", 2*protocol.MB, "write buffer size of each connection in bytes")
+	keepAlive      = flag.Duration("keepalive", 5*time.Minute, "TCP keep-alive interval, 0 disables it")
+	shards         = flag.Int("shards", 1, "number of independent shard trees, each with its own directory and WAL; must not change for an existing database")
+	snapshotRoot   = flag.String("snapshot-dir", "", "directory under which the snapshot command creates snapshots by name, empty disables snapshots")
+)
+
+// 关闭时等待已处理请求的响应写完的最长时间
+const shutdownTimeout = 10 * time.Second
+
+// 预热期间注册的权重，相对于 etcd.FullWeight
+const warmingWeight = 10
+
+// 注册失败后重试的初始间隔和最大间隔，每次失败间隔翻倍
+var (
+	registerRetryInterval    = time.Second
+	registerRetryMaxInterval = 30 * time.Second
+)
+
+func NewTCPPool(ss *protocol.BluebellServer) {
+	options := append(ss.GnetOptions(), gnet.WithReusePort(true)) // 启用端口重用
 	err := gnet.Run(ss, ss.Network+"://"+ss.Addr, options...)
 	logging.Infof("node exits with error: %v", err)
 }
 
 func main() {
-	go NewTCPPool()
+	flag.Parse()
+	if err := storage.SetShardCount(*shards); err != nil {
+		log.Fatal(err)
+	}
+	storage.SetSnapshotRoot(*snapshotRoot)
+	ss := protocol.NewBluebellServer("tcp", "0.0.0.0:9000", true,
+		protocol.WithNumEventLoop(*numEventLoop),
+		protocol.WithBufferCap(*readBufferCap, *writeBufferCap),
+		protocol.WithTCPKeepAlive(*keepAlive))
+	go NewTCPPool(ss)
 	var err error
-	Hbase, err = storage.NewHbaseClient()
+	var rc *etcd.RegistryClient
+	// 与处理请求的连接共用同一个存储，不能在同一个目录上再打开一次
+	Hbase, err = startNode(storage.OpenClient, *warmUp, func(weight int) error {
+		// 第一次成功注册之后只更新权重
+		if rc != nil {
+			return rc.SetWeight(weight)
+		}
+		endpoints := []string{"192.168.93.128:2379"}
+		client, err := etcd.NewRegistryClient(endpoints)
+		if err != nil {
+			return fmt.Errorf("failed to create registry client: %w", err)
+		}
+		if err := client.Register("192.168.93.128:9000", weight); err != nil {
+			_ = client.Close()
+			return err
+		}
+		rc = client
+		return nil
+	})
 	if err != nil {
-		panic(err)
+		log.Fatalf("node is not ready: %v", err)
+	}
+
+	// 收到退出信号后先把已处理请求的响应写完，再关闭存储
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGINT, syscall.SIGTERM)
+	<-sig
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	if err := ss.Shutdown(ctx); err != nil {
+		log.Printf("shutdown: %v", err)
 	}
-	endpoints := []string{"192.168.93.128:2379"}
-	rc, err := etcd.NewRegistryClient(endpoints)
+	if err := Hbase.Close(); err != nil {
+		log.Printf("failed to close storage: %v", err)
+	}
+}
+
+// startNode 打开存储并通过就绪检查后才调用 register 注册到 etcd，
+// 保证客户端不会路由到仍在恢复或无法写入的节点。就绪检查失败时不注册并返回错误。
+// 开启预热时先以 warmingWeight 注册，客户端只把少量请求路由到还没有预热的节点，
+// 预热完成后再把权重提高到 etcd.FullWeight；不预热时直接以 etcd.FullWeight 注册。
+// register 以给定的权重注册节点，已经注册时只更新权重。
+// 注册是尽力而为的：etcd 不可用时节点照常启动并在本地提供服务，
+// 后台以指数退避重试，etcd 恢复后再以最新的权重完成注册。
+func startNode(open func() (*storage.Hbase, error), warmUp bool, register func(weight int) error) (*storage.Hbase, error) {
+	// 打开存储时会重放 WAL
+	h, err := open()
 	if err != nil {
-		log.Fatalf("Failed to create registry client: %v", err)
+		return nil, fmt.Errorf("failed to open storage: %w", err)
+	}
+	if err := h.Ready(); err != nil {
+		_ = h.Close()
+		return nil, err
+	}
+
+	r := &registration{register: register}
+	if warmUp {
+		r.set(warmingWeight)
+		if err := h.WarmUp(); err != nil {
+			log.Printf("warm up failed: %v", err)
+		}
+	}
+	r.set(etcd.FullWeight)
+	return h, nil
+}
+
+// registration 以最新设置的权重把节点注册到 etcd，失败时在后台重试
+type registration struct {
+	register func(weight int) error
+
+	mu sync.Mutex
+	// 最新设置的权重
+	weight int
+	// 后台是否正在重试，重试总是使用最新的权重
+	retrying bool
+}
+
+// set 设置节点的权重并立即注册，失败时启动后台重试
+func (r *registration) set(weight int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.weight = weight
+	if r.retrying {
+		return
+	}
+	if err := r.register(weight); err != nil {
+		log.Printf("failed to register node, retrying in background: %v", err)
+		r.retrying = true
+		go r.retry()
+	}
+}
+
+// retry 以指数退避反复注册直到成功。
+func (r *registration) retry() {
+	interval := registerRetryInterval
+	for {
+		time.Sleep(interval)
+		r.mu.Lock()
+		err := r.register(r.weight)
+		if err == nil {
+			r.retrying = false
+		}
+		r.mu.Unlock()
+		if err == nil {
+			log.Printf("node registered after etcd recovered")
+			return
+		}
+		log.Printf("failed to register node: %v", err)
+		if interval *= 2; interval > registerRetryMaxInterval {
+			interval = registerRetryMaxInterval
+		}
 	}
-	_ = rc.Register("192.168.93.128:9000")
-	select {}
 }
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/huahuoao/lsm-core/internal/etcd"
+	"github.com/huahuoao/lsm-core/internal/storage"
+)
+
+func TestStartNodeFailedOpen(t *testing.T) {
+	registered := false
+	register := func(weight int) error {
+		registered = true
+		return nil
+	}
+
+	// 存储目录是一个文件，打开失败
+	file := path.Join(t.TempDir(), "file")
+	if err := os.WriteFile(file, nil, 0600); err != nil {
+		t.Fatal(err)
+	}
+	open := func() (*storage.Hbase, error) {
+		return storage.NewShardedHbaseClient([]string{file}, nil)
+	}
+	if _, err := startNode(open, false, register); err == nil {
+		t.Fatal("expected start to fail")
+	}
+	if registered {
+		t.Fatal("node must not register when it is not ready")
+	}
+
+	// 打开成功
+	dir := t.TempDir()
+	open = func() (*storage.Hbase, error) {
+		return storage.NewShardedHbaseClient([]string{dir}, nil)
+	}
+	h, err := startNode(open, true, register)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !registered {
+		t.Fatal("node must register once it is ready")
+	}
+	_ = h.Close()
+}
+
+func TestStartNodeNotReady(t *testing.T) {
+	open := func() (*storage.Hbase, error) {
+		h, err := storage.NewShardedHbaseClient([]string{t.TempDir()}, nil)
+		if err != nil {
+			return nil, err
+		}
+		// 模拟打开后存储不可用
+		_ = h.Close()
+		return h, nil
+	}
+	_, err := startNode(open, false, func(weight int) error {
+		return errors.New("must not register")
+	})
+	if err == nil {
+		t.Fatal("expected readiness check to fail")
+	}
+}
+
+func TestStartNodeRegistersAfterEtcdRecovers(t *testing.T) {
+	registerRetryInterval = time.Millisecond
+	registerRetryMaxInterval = 5 * time.Millisecond
+	defer func() {
+		registerRetryInterval = time.Second
+		registerRetryMaxInterval = 30 * time.Second
+	}()
+
+	// 前几次注册模拟 etcd 不可用
+	var attempts int32
+	registered := make(chan struct{})
+	register := func(weight int) error {
+		if atomic.AddInt32(&attempts, 1) <= 3 {
+			return errors.New("etcd is unavailable")
+		}
+		close(registered)
+		return nil
+	}
+
+	dir := t.TempDir()
+	open := func() (*storage.Hbase, error) {
+		return storage.NewShardedHbaseClient([]string{dir}, nil)
+	}
+	h, err := startNode(open, false, register)
+	if err != nil {
+		t.Fatalf("node must start while etcd is down: %s", err)
+	}
+	defer h.Close()
+
+	// 注册完成之前节点已经可以在本地读写
+	if err := h.Put([]byte("key"), []byte("value")); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-registered:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("node did not register after etcd recovered, attempts: %d", atomic.LoadInt32(&attempts))
+	}
+}
+
+func TestStartNodeWarmUpWeight(t *testing.T) {
+	var weights []int
+	register := func(weight int) error {
+		weights = append(weights, weight)
+		return nil
+	}
+
+	dir := t.TempDir()
+	open := func() (*storage.Hbase, error) {
+		return storage.NewShardedHbaseClient([]string{dir}, nil)
+	}
+	h, err := startNode(open, true, register)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = h.Close()
+	// 预热期间以较低的权重注册，预热完成后提高到全部权重
+	if fmt.Sprint(weights) != fmt.Sprint([]int{warmingWeight, etcd.FullWeight}) {
+		t.Fatalf("expected weights %d then %d, but got %v", warmingWeight, etcd.FullWeight, weights)
+	}
+
+	weights = nil
+	h, err = startNode(open, false, register)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_ = h.Close()
+	if fmt.Sprint(weights) != fmt.Sprint([]int{etcd.FullWeight}) {
+		t.Fatalf("expected weight %d without warm up, but got %v", etcd.FullWeight, weights)
+	}
+}
+
+func TestStartNodeRetriesWithLatestWeight(t *testing.T) {
+	// 空存储的预热远快于第一次重试
+	registerRetryInterval = 50 * time.Millisecond
+	registerRetryMaxInterval = 50 * time.Millisecond
+	defer func() {
+		registerRetryInterval = time.Second
+		registerRetryMaxInterval = 30 * time.Second
+	}()
+
+	// etcd 在预热期间不可用，恢复后直接以全部权重注册
+	var attempts int32
+	registered := make(chan int, 2)
+	register := func(weight int) error {
+		if atomic.AddInt32(&attempts, 1) <= 3 {
+			return errors.New("etcd is unavailable")
+		}
+		registered <- weight
+		return nil
+	}
+
+	dir := t.TempDir()
+	open := func() (*storage.Hbase, error) {
+		return storage.NewShardedHbaseClient([]string{dir}, nil)
+	}
+	h, err := startNode(open, true, register)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer h.Close()
+
+	select {
+	case weight := <-registered:
+		if weight != etcd.FullWeight {
+			t.Fatalf("expected to register with weight %d after warm up, but got %d", etcd.FullWeight, weight)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("node did not register after etcd recovered, attempts: %d", atomic.LoadInt32(&attempts))
+	}
+}